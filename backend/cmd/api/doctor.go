@@ -0,0 +1,657 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/config"
+	"greact-bones/backend/internal/data"
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/malware"
+	"greact-bones/backend/internal/webpush"
+)
+
+// checkStatus is the outcome of a doctor check.
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkipped
+)
+
+func (s checkStatus) symbol() string {
+	return [...]string{"✓", "!", "✗", "-"}[s]
+}
+
+// checkResult is what a check found and, unless it passed, how to fix it.
+type checkResult struct {
+	Name   string
+	Status checkStatus
+	Detail string
+	Fix    string
+}
+
+// doctor runs the checks of the doctor command. Later checks use what
+// earlier ones found, such as whether a server is already listening.
+type doctor struct {
+	cfg     *config.Config
+	timeURL string
+	timeout time.Duration
+	results []checkResult
+	// serverURL is set when a server answers on the configured port.
+	serverURL string
+}
+
+func (d *doctor) report(name string, status checkStatus, detail, fix string) {
+	d.results = append(d.results, checkResult{Name: name, Status: status, Detail: detail, Fix: fix})
+}
+
+// runDoctor diagnoses the local or production setup and prints a fix for
+// every problem found. It fails when a check failed.
+func runDoctor(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	timeURL := fs.String("time-url", "https://www.cloudflare.com", "HTTPS server whose Date header is used to measure clock skew")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each network check")
+	fs.Parse(args)
+
+	d := &doctor{cfg: cfg, timeURL: *timeURL, timeout: *timeout}
+	d.checkGo()
+	d.checkConfig()
+	d.checkPort()
+	d.checkDatabase()
+	d.checkTenants()
+	d.checkProjections()
+	d.checkFrontend()
+	d.checkStorage()
+	d.checkScanner()
+	d.checkPush()
+	d.checkBackplane()
+	d.checkTLS()
+	d.checkClock()
+	d.checkCORS()
+
+	failed, warned := 0, 0
+	for _, r := range d.results {
+		fmt.Printf("%s %-14s %s\n", r.Status.symbol(), r.Name, r.Detail)
+		if r.Fix != "" && (r.Status == checkWarn || r.Status == checkFail) {
+			fmt.Printf("  %-14s fix: %s\n", "", r.Fix)
+		}
+		switch r.Status {
+		case checkFail:
+			failed++
+		case checkWarn:
+			warned++
+		}
+	}
+	fmt.Printf("\n%d checks, %d failed, %d warnings\n", len(d.results), failed, warned)
+	if failed > 0 {
+		return fmt.Errorf("doctor: %d checks failed", failed)
+	}
+	return nil
+}
+
+var goVersionPattern = regexp.MustCompile(`go(\d+)\.(\d+)`)
+
+// checkGo compares the go tool on PATH with the version go.mod requires.
+// The running binary may have been built elsewhere, so PATH is what matters
+// for `go run`.
+func (d *doctor) checkGo() {
+	required := requiredGoVersion()
+	path, err := exec.LookPath("go")
+	if err != nil {
+		d.report("go", checkWarn, "go is not on PATH (this binary was built with "+runtime.Version()+")",
+			"install Go "+required+" or newer from https://go.dev/dl and add its bin directory to PATH; on Windows see Troubleshooting in the README")
+		return
+	}
+	out, err := exec.Command(path, "env", "GOVERSION").Output()
+	if err != nil {
+		d.report("go", checkWarn, "could not run "+path+": "+err.Error(), "check that the Go installation is complete")
+		return
+	}
+	version := strings.TrimSpace(string(out))
+	if required != "" && compareGoVersions(version, required) < 0 {
+		d.report("go", checkFail, fmt.Sprintf("%s at %s, go.mod requires %s", version, path, required),
+			"install Go "+required+" or newer from https://go.dev/dl")
+		return
+	}
+	d.report("go", checkOK, version+" at "+path, "")
+}
+
+// requiredGoVersion reads the go directive of go.mod in the working
+// directory, if there is one.
+func requiredGoVersion() string {
+	body, err := os.ReadFile("go.mod")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "go "); ok {
+			return "go" + strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func compareGoVersions(a, b string) int {
+	pa, pb := goVersionPattern.FindStringSubmatch(a), goVersionPattern.FindStringSubmatch(b)
+	if pa == nil || pb == nil {
+		return 0
+	}
+	for i := 1; i <= 2; i++ {
+		x, _ := strconv.Atoi(pa[i])
+		y, _ := strconv.Atoi(pb[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+func (d *doctor) checkConfig() {
+	cfg := d.cfg
+	var problems, warnings, fixes []string
+	if n, err := strconv.Atoi(cfg.Port); err != nil || n < 1 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT %q is not a port number", cfg.Port))
+	}
+	switch cfg.DatabaseDriver {
+	case "sqlite", "postgres":
+	default:
+		problems = append(problems, fmt.Sprintf("DATABASE_DRIVER %q is not sqlite or postgres", cfg.DatabaseDriver))
+	}
+	if u, err := url.Parse(cfg.AppURL); err != nil || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("APP_URL %q is not an absolute URL", cfg.AppURL))
+	}
+	if cfg.FrontendOrigin != "*" {
+		if u, err := url.Parse(cfg.FrontendOrigin); err != nil || u.Host == "" || u.Path != "" {
+			problems = append(problems, fmt.Sprintf("FRONTEND_ORIGIN %q is not an origin like http://localhost:5173", cfg.FrontendOrigin))
+		}
+	}
+	if cfg.JobWorkers < 1 {
+		warnings = append(warnings, "JOB_WORKERS is below 1, one worker is used")
+		fixes = append(fixes, "set JOB_WORKERS to 1 or more")
+	}
+	if cfg.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.SMTPAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("SMTP_ADDR %q is not host:port", cfg.SMTPAddr))
+		}
+	}
+	switch cfg.SchemaCheck {
+	case "off", "warn", "block":
+	default:
+		problems = append(problems, fmt.Sprintf("SCHEMA_CHECK %q is not off, warn or block", cfg.SchemaCheck))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		problems = append(problems, "set both TLS_CERT_FILE and TLS_KEY_FILE, or neither")
+	}
+	if cfg.AuthSecret == "dev-secret-change-me" {
+		msg := "AUTH_SECRET is the development default; anyone can mint tokens"
+		if cfg.IsProduction() {
+			problems = append(problems, msg)
+		} else {
+			warnings = append(warnings, msg)
+		}
+		fixes = append(fixes, "set AUTH_SECRET to a long random value, e.g. from openssl rand -hex 32")
+	}
+	if cfg.IsProduction() && cfg.FrontendOrigin == "*" {
+		warnings = append(warnings, "FRONTEND_ORIGIN allows every origin in production")
+		fixes = append(fixes, "set FRONTEND_ORIGIN to the frontend's origin")
+	}
+
+	switch {
+	case len(problems) > 0:
+		d.report("config", checkFail, strings.Join(append(problems, warnings...), "; "),
+			"correct the environment variables; every setting and its default is listed in internal/config/config.go")
+	case len(warnings) > 0:
+		d.report("config", checkWarn, strings.Join(warnings, "; "), strings.Join(fixes, "; "))
+	default:
+		d.report("config", checkOK, fmt.Sprintf("%s environment, %s database", cfg.Environment, cfg.DatabaseDriver), "")
+	}
+}
+
+// checkPort tries to bind the port. A busy port is fine when it is this
+// API answering; the CORS check then runs against it.
+func (d *doctor) checkPort() {
+	if _, err := strconv.Atoi(d.cfg.Port); err != nil {
+		d.report("port", checkSkipped, "PORT is invalid, see config", "")
+		return
+	}
+	ln, err := net.Listen("tcp", ":"+d.cfg.Port)
+	if err == nil {
+		ln.Close()
+		d.report("port", checkOK, "port "+d.cfg.Port+" is free", "")
+		return
+	}
+	base := "http://localhost:" + d.cfg.Port
+	client := &http.Client{Timeout: d.timeout}
+	if resp, err := client.Get(base + "/health"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			d.serverURL = base
+			d.report("port", checkOK, "port "+d.cfg.Port+" is in use by a running API server", "")
+			return
+		}
+	}
+	fix := "stop the process using it (lsof -i :" + d.cfg.Port + " or netstat -ano | findstr :" + d.cfg.Port + " on Windows) or start the server with another PORT"
+	d.report("port", checkFail, "port "+d.cfg.Port+" is used by another program", fix)
+}
+
+func (d *doctor) checkDatabase() {
+	db, err := data.Open(d.cfg.DatabaseDriver, d.cfg.DatabaseURL)
+	if err != nil {
+		fix := "check DATABASE_URL and that the database server is running"
+		if d.cfg.DatabaseDriver == "sqlite" {
+			fix = "check that the directory in DATABASE_URL exists and is writable"
+		}
+		d.report("database", checkFail, err.Error(), fix)
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*d.timeout)
+	defer cancel()
+	drift, err := data.VerifySchema(ctx, db, d.cfg.DatabaseDriver)
+	if err != nil {
+		d.report("database", checkFail, "connected, but reading the schema failed: "+err.Error(), "check the database user's permissions")
+		return
+	}
+	pending := 0
+	for _, dr := range drift {
+		if strings.HasSuffix(dr.Problem, "is not applied") {
+			pending++
+		}
+	}
+	switch {
+	case pending > 0:
+		d.report("database", checkWarn, fmt.Sprintf("connected, %d migrations pending", pending),
+			"run go run ./cmd/api migrate, or start the server, which migrates")
+	case len(drift) > 0:
+		d.report("database", checkWarn, fmt.Sprintf("connected, the schema differs from the migrations in %d places (first: %s)", len(drift), drift[0]),
+			"run go run ./cmd/api migrate verify for the full list and undo manual schema changes")
+	default:
+		d.report("database", checkOK, "connected, all migrations applied, no schema drift", "")
+	}
+}
+
+// checkTenants reports tenants with a database of their own that were not
+// migrated to the latest version, as recorded in the main database.
+// Lagging tenants are migrated when first used, which delays that request.
+func (d *doctor) checkTenants() {
+	db, err := data.Open(d.cfg.DatabaseDriver, d.cfg.DatabaseURL)
+	if err != nil {
+		return // reported by checkDatabase
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*d.timeout)
+	defer cancel()
+	tenants, err := data.NewTenantRouter(ctx, db, tenantOptions(d.cfg))
+	if err != nil {
+		d.report("tenants", checkWarn, "cannot read tenant databases: "+err.Error(), "run go run ./cmd/api migrate")
+		return
+	}
+	defer tenants.Close()
+	list, err := tenants.Tenants(ctx)
+	if err != nil {
+		d.report("tenants", checkWarn, "cannot read tenant databases: "+err.Error(), "run go run ./cmd/api migrate")
+		return
+	}
+	if len(list) == 0 {
+		d.report("tenants", checkOK, "all tenants share the main database", "")
+		return
+	}
+	migrations, err := data.Migrations()
+	if err != nil {
+		d.report("tenants", checkFail, err.Error(), "")
+		return
+	}
+	latest := migrations[len(migrations)-1].Version
+	var behind []string
+	for _, t := range list {
+		if t.Version != latest {
+			behind = append(behind, t.TenantID)
+		}
+	}
+	if len(behind) > 0 {
+		d.report("tenants", checkWarn, fmt.Sprintf("%d of %d tenant databases have pending migrations (first: %s)", len(behind), len(list), behind[0]),
+			"run go run ./cmd/api tenants migrate -all")
+		return
+	}
+	d.report("tenants", checkOK, fmt.Sprintf("%d tenant databases, all migrated", len(list)), "")
+}
+
+// checkProjections reports read models of the main database that stopped
+// at an event they fail to apply; lists built from them go stale.
+func (d *doctor) checkProjections() {
+	db, err := data.Open(d.cfg.DatabaseDriver, d.cfg.DatabaseURL)
+	if err != nil {
+		return // reported by checkDatabase
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	statuses, err := newProjections(db).Statuses(ctx)
+	if err != nil {
+		d.report("projections", checkWarn, "cannot read projection checkpoints: "+err.Error(), "run go run ./cmd/api migrate")
+		return
+	}
+	for _, st := range statuses {
+		if st.State == domain.ProjectionFailing {
+			d.report("projections", checkWarn, fmt.Sprintf("%s is stuck %d events behind: %s", st.Name, st.Lag, st.LastError),
+				"fix the cause, then run go run ./cmd/api projections rebuild "+st.Name)
+			return
+		}
+	}
+	d.report("projections", checkOK, fmt.Sprintf("%d projections applying events", len(statuses)), "")
+}
+
+func (d *doctor) checkFrontend() {
+	index := filepath.Join(d.cfg.FrontendDist, "index.html")
+	info, err := os.Stat(index)
+	if err != nil {
+		status := checkWarn
+		if d.cfg.IsProduction() {
+			status = checkFail
+		}
+		d.report("frontend", status, "no build in "+d.cfg.FrontendDist+"; only the API is served",
+			"run npm install && npm run build in frontend/, or set FRONTEND_DIST; during development use npm run dev instead")
+		return
+	}
+	d.report("frontend", checkOK, fmt.Sprintf("build from %s in %s", info.ModTime().Format(time.DateTime), d.cfg.FrontendDist), "")
+}
+
+// checkStorage checks that uploaded files and uploads in progress can be
+// written to FILE_STORAGE_DIR and UPLOAD_DIR, creating them like the
+// server would.
+func (d *doctor) checkStorage() {
+	for _, dir := range []struct{ env, path string }{
+		{"FILE_STORAGE_DIR", d.cfg.FileStorageDir},
+		{"UPLOAD_DIR", d.cfg.UploadDir},
+	} {
+		if err := os.MkdirAll(dir.path, 0o750); err != nil {
+			d.report("storage", checkFail, err.Error(), "create "+dir.path+" or point "+dir.env+" at a writable directory")
+			return
+		}
+		probe, err := os.CreateTemp(dir.path, ".doctor-*")
+		if err != nil {
+			d.report("storage", checkFail, dir.path+" is not writable: "+err.Error(),
+				"give the server user write access to "+dir.path+" or set "+dir.env)
+			return
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	d.report("storage", checkOK, "files stored in "+d.cfg.FileStorageDir+", uploads in progress in "+d.cfg.UploadDir, "")
+}
+
+// checkScanner checks that the malware scanner uploads wait for works.
+func (d *doctor) checkScanner() {
+	scanner, err := newScanner(d.cfg)
+	if err != nil {
+		d.report("scanner", checkFail, err.Error(), "set SCANNER to clamd, signatures or off and fix SCAN_SIGNATURES or CLAMD_ADDR")
+		return
+	}
+	if scanner == nil {
+		status := checkWarn
+		if d.cfg.IsProduction() {
+			status = checkFail
+		}
+		d.report("scanner", status, "uploads are served without a malware scan", "set SCANNER=clamd, or SCANNER=signatures for the built-in rules")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if clamd, ok := scanner.(*malware.Clamd); ok {
+		if err := clamd.Ping(ctx); err != nil {
+			d.report("scanner", checkFail, err.Error(),
+				"start clamd (or go run ./cmd/api scan clamd during development) and check CLAMD_ADDR; uploads stay quarantined until it answers")
+			return
+		}
+	}
+	version, err := scanner.Version(ctx)
+	if err != nil {
+		d.report("scanner", checkFail, err.Error(), "check SCAN_SIGNATURES or the clamd installation")
+		return
+	}
+	d.report("scanner", checkOK, scanner.Name()+" "+version, "")
+}
+
+// checkPush checks the VAPID key Web Push requests are signed with and
+// the contact push services see.
+func (d *doctor) checkPush() {
+	var key *webpush.VAPIDKey
+	var err error
+	source := "VAPID_PRIVATE_KEY"
+	if d.cfg.VAPIDPrivateKey != "" {
+		key, err = webpush.ParseVAPIDKey(d.cfg.VAPIDPrivateKey)
+	} else {
+		source = d.cfg.VAPIDKeyFile
+		var raw []byte
+		raw, err = os.ReadFile(d.cfg.VAPIDKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			d.report("push", checkWarn, "no VAPID key yet; the server generates one in "+d.cfg.VAPIDKeyFile+" on first start",
+				"keep that file across deployments, or set VAPID_PRIVATE_KEY (go run ./cmd/api push keys)")
+			return
+		}
+		if err == nil {
+			key, err = webpush.ParseVAPIDKey(strings.TrimSpace(string(raw)))
+		}
+	}
+	if err != nil {
+		d.report("push", checkFail, source+": "+err.Error(), "generate a key with go run ./cmd/api push keys and set VAPID_PRIVATE_KEY")
+		return
+	}
+	subject := vapidSubject(d.cfg)
+	u, err := url.Parse(subject)
+	if err != nil || (u.Scheme != "mailto" && u.Scheme != "https") || strings.Contains(subject, "localhost") {
+		status := checkWarn
+		if d.cfg.IsProduction() {
+			status = checkFail
+		}
+		d.report("push", status, fmt.Sprintf("VAPID subject %q is not a reachable mailto: or https: contact", subject),
+			"set VAPID_SUBJECT to e.g. mailto:ops@example.com; some push services reject other subjects")
+		return
+	}
+	d.report("push", checkOK, fmt.Sprintf("VAPID key %s… from %s, subject %s", key.PublicKey()[:12], source, subject), "")
+}
+
+// checkBackplane checks that the realtime backplane can be reached.
+func (d *doctor) checkBackplane() {
+	switch d.cfg.Backplane {
+	case "memory":
+		d.report("backplane", checkOK, "memory; realtime messages reach the clients of this replica only",
+			"set BACKPLANE=postgres or BACKPLANE=nats when running several replicas")
+	case "postgres":
+		if d.cfg.DatabaseDriver != "postgres" {
+			d.report("backplane", checkFail, "BACKPLANE=postgres with DATABASE_DRIVER="+d.cfg.DatabaseDriver,
+				"use BACKPLANE=nats, or memory for a single replica")
+			return
+		}
+		d.report("backplane", checkOK, "postgres LISTEN/NOTIFY on the application database", "")
+	case "nats":
+		if d.cfg.NATSURL == "" {
+			if err := os.MkdirAll(d.cfg.NATSStoreDir, 0o755); err != nil {
+				d.report("backplane", checkFail, "NATS_STORE_DIR: "+err.Error(), "point NATS_STORE_DIR at a writable directory")
+				return
+			}
+			d.report("backplane", checkOK, fmt.Sprintf("embedded NATS server on %s storing in %s", d.cfg.NATSListen, d.cfg.NATSStoreDir), "")
+			return
+		}
+		u, err := url.Parse(d.cfg.NATSURL)
+		if err != nil || u.Host == "" {
+			d.report("backplane", checkFail, fmt.Sprintf("NATS_URL %q is not a URL", d.cfg.NATSURL), "set NATS_URL to e.g. nats://nats:4222")
+			return
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host += ":4222"
+		}
+		conn, err := net.DialTimeout("tcp", host, d.timeout)
+		if err != nil {
+			d.report("backplane", checkFail, "cannot reach NATS at "+host+": "+err.Error(),
+				"start the NATS server (with JetStream enabled) or correct NATS_URL")
+			return
+		}
+		conn.Close()
+		d.report("backplane", checkOK, "NATS at "+host, "")
+	default:
+		d.report("backplane", checkFail, fmt.Sprintf("unknown BACKPLANE %q", d.cfg.Backplane), "set BACKPLANE to memory, postgres or nats")
+	}
+}
+
+// checkTLS checks the configured certificate and, for an https APP_URL,
+// the certificate that host serves.
+func (d *doctor) checkTLS() {
+	checked := false
+	if d.cfg.TLSCertFile != "" && d.cfg.TLSKeyFile != "" {
+		checked = true
+		if _, err := tls.LoadX509KeyPair(d.cfg.TLSCertFile, d.cfg.TLSKeyFile); err != nil {
+			d.report("tls", checkFail, "cannot load TLS_CERT_FILE/TLS_KEY_FILE: "+err.Error(),
+				"point both variables at a PEM certificate chain and its private key")
+		} else if cert, err := readCertificate(d.cfg.TLSCertFile); err != nil {
+			d.report("tls", checkFail, err.Error(), "point TLS_CERT_FILE at a PEM certificate")
+		} else {
+			d.reportExpiry("certificate "+d.cfg.TLSCertFile, cert)
+		}
+	}
+
+	if u, err := url.Parse(d.cfg.AppURL); err == nil && u.Scheme == "https" {
+		checked = true
+		host := u.Host
+		if u.Port() == "" {
+			host += ":443"
+		}
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: d.timeout}, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			d.report("tls", checkWarn, "could not verify the certificate of "+u.Host+": "+err.Error(),
+				"check that APP_URL is reachable and serves a certificate trusted for "+u.Hostname())
+		} else {
+			d.reportExpiry("certificate of "+u.Host, conn.ConnectionState().PeerCertificates[0])
+			conn.Close()
+		}
+	}
+	if !checked {
+		d.report("tls", checkSkipped, "no TLS_CERT_FILE and APP_URL is not https", "")
+	}
+}
+
+func (d *doctor) reportExpiry(what string, cert *x509.Certificate) {
+	left := time.Until(cert.NotAfter)
+	detail := fmt.Sprintf("%s expires %s", what, cert.NotAfter.Format(time.DateOnly))
+	switch {
+	case left <= 0:
+		d.report("tls", checkFail, what+" expired on "+cert.NotAfter.Format(time.DateOnly), "renew the certificate")
+	case left < 14*24*time.Hour:
+		d.report("tls", checkWarn, fmt.Sprintf("%s, in %d days", detail, int(left.Hours()/24)), "renew the certificate now; check that automatic renewal works")
+	default:
+		d.report("tls", checkOK, detail, "")
+	}
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// checkClock compares the local clock with the Date header of a public
+// server. Tokens and scheduled jobs rely on the clocks of all replicas
+// agreeing.
+func (d *doctor) checkClock() {
+	client := &http.Client{Timeout: d.timeout}
+	start := time.Now()
+	resp, err := client.Head(d.timeURL)
+	if err != nil {
+		d.report("clock", checkSkipped, "could not reach "+d.timeURL+" to compare clocks", "")
+		return
+	}
+	resp.Body.Close()
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		d.report("clock", checkSkipped, d.timeURL+" sent no usable Date header", "")
+		return
+	}
+	// The Date header has second precision and describes the middle of
+	// the round trip at best
+	local := start.Add(time.Since(start) / 2)
+	skew := local.Sub(remote).Round(time.Second)
+	detail := fmt.Sprintf("local clock differs from %s by %s", d.timeURL, skew)
+	switch abs := max(skew, -skew); {
+	case abs > time.Minute:
+		d.report("clock", checkFail, detail, "enable time synchronization (timedatectl set-ntp true, or w32tm /resync on Windows)")
+	case abs > 5*time.Second:
+		d.report("clock", checkWarn, detail, "enable time synchronization (timedatectl set-ntp true, or w32tm /resync on Windows)")
+	default:
+		d.report("clock", checkOK, detail, "")
+	}
+}
+
+// checkCORS sends the preflight a browser at FRONTEND_ORIGIN would send
+// before an authenticated API call, to the server found by checkPort.
+func (d *doctor) checkCORS() {
+	if d.serverURL == "" {
+		d.report("cors", checkSkipped, "no server running on port "+d.cfg.Port+"; start it and run doctor again to check CORS", "")
+		return
+	}
+	origin := d.cfg.FrontendOrigin
+	if origin == "*" {
+		origin = "http://localhost:5173"
+	}
+	req, err := http.NewRequest(http.MethodOptions, d.serverURL+"/api/hello", nil)
+	if err != nil {
+		d.report("cors", checkFail, err.Error(), "")
+		return
+	}
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	resp, err := (&http.Client{Timeout: d.timeout}).Do(req)
+	if err != nil {
+		d.report("cors", checkFail, "preflight failed: "+err.Error(), "check that the server on port "+d.cfg.Port+" is this API")
+		return
+	}
+	resp.Body.Close()
+
+	allowOrigin := resp.Header.Get("Access-Control-Allow-Origin")
+	allowHeaders := strings.ToLower(resp.Header.Get("Access-Control-Allow-Headers"))
+	allowMethods := resp.Header.Get("Access-Control-Allow-Methods")
+	switch {
+	case resp.StatusCode >= 300:
+		d.report("cors", checkFail, fmt.Sprintf("preflight from %s got status %d", origin, resp.StatusCode),
+			"make sure corsMiddleware runs before routing (router.Use in internal/api/router.go)")
+	case allowOrigin != "*" && allowOrigin != origin:
+		d.report("cors", checkFail, fmt.Sprintf("the server allows origin %q, the frontend runs on %s", allowOrigin, origin),
+			"set FRONTEND_ORIGIN="+origin+" on the server and restart it")
+	case !strings.Contains(allowHeaders, "authorization") || !strings.Contains(allowHeaders, "content-type"):
+		d.report("cors", checkFail, "preflight does not allow the Authorization and Content-Type headers",
+			"add them to Access-Control-Allow-Headers in corsMiddleware")
+	case !strings.Contains(allowMethods, http.MethodPost):
+		d.report("cors", checkFail, "preflight does not allow POST", "add it to Access-Control-Allow-Methods in corsMiddleware")
+	default:
+		d.report("cors", checkOK, "preflight from "+origin+" is allowed", "")
+	}
+}
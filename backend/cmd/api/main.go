@@ -1,48 +1,1054 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gin-gonic/gin"
+	"greact-bones/backend/internal/api"
+	"greact-bones/backend/internal/backplane"
+	"greact-bones/backend/internal/config"
+	"greact-bones/backend/internal/data"
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/mail"
+	"greact-bones/backend/internal/malware"
+	"greact-bones/backend/internal/pdf"
+	"greact-bones/backend/internal/realtime"
+	"greact-bones/backend/internal/storage"
+	"greact-bones/backend/internal/webpush"
 )
 
 func main() {
-	// Create a Gin router with default middleware
-	router := gin.Default()
+	cfg := config.Load()
 
-	// Add CORS middleware for frontend communication
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	// Without arguments the binary starts the API server
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = runServer(cfg)
+	case "token":
+		err = runToken(cfg, args)
+	case "snapshot":
+		err = runSnapshot(cfg, args)
+	case "migrate":
+		err = runMigrate(cfg, args)
+	case "doctor":
+		err = runDoctor(cfg, args)
+	case "files":
+		err = runFiles(cfg, args)
+	case "scan":
+		err = runScan(cfg, args)
+	case "push":
+		err = runPush(cfg, args)
+	case "documents":
+		err = runDocuments(cfg, args)
+	case "inbound":
+		err = runInbound(cfg, args)
+	case "projections":
+		err = runProjections(cfg, args)
+	case "tenants":
+		err = runTenants(cfg, args)
+	default:
+		err = fmt.Errorf("unknown command %q (available: serve, token, snapshot, migrate, doctor, files, scan, push, tenants, projections, inbound, documents)", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runServer(cfg *config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := data.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := data.Migrate(ctx, db); err != nil {
+		return err
+	}
+	if err := checkSchema(ctx, cfg, db); err != nil {
+		return err
+	}
+
+	// Tenants provisioned with a database of their own get their queries,
+	// files and scheduled tasks routed to it
+	tenants, err := data.NewTenantRouter(ctx, db, tenantOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer tenants.Close()
+
+	// Wire repositories into the domain services
+	events := domain.NewEventBus()
+
+	// Every event and realtime message has a versioned schema
+	schemas := domain.NewMessageSchemaRegistry()
+	schemas.Register(domain.CommentEventSchemas...)
+	schemas.Register(domain.PreferenceEventSchemas...)
+	schemas.Register(realtime.MessageSchemas...)
+	schemas.Register(realtime.CollabMessageSchemas...)
+	events.UseSchemas(schemas)
+
+	// Every event is also logged, and read models are projected from the
+	// log
+	events.UseLog(data.NewEventLogRepo(tenants))
+	projections := newProjections(tenants)
+	projections.UseTenants(tenants.IsolatedTenants)
+	projections.Watch(events)
+
+	// Realtime messages reach the clients of every replica
+	hub := realtime.NewHub()
+	bp, err := newBackplane(cfg, db)
+	if err != nil {
+		return err
+	}
+	defer bp.Close()
+	hub.UseBackplane(bp)
+	go hub.Run(ctx)
+	users := domain.NewUserService(data.NewUserRepo(tenants))
+	notifications := domain.NewNotificationService(data.NewNotificationRepo(tenants), realtime.NewNotificationChannel(hub))
+	activity := domain.NewActivityService(data.NewActivityRepo(tenants))
+	comments := domain.NewCommentService(data.NewCommentRepo(tenants), users, notifications, events)
+
+	// Modules declare their preference keys with defaults and validation
+	preferenceRegistry := domain.NewPreferenceRegistry()
+	preferenceRegistry.Register(domain.CorePreferenceKeys...)
+	preferenceRegistry.Register(domain.CommentPreferenceKeys...)
+	preferenceRegistry.Register(domain.EmailPreferenceKeys...)
+	preferences := domain.NewPreferenceService(preferenceRegistry, data.NewPreferenceRepo(tenants), events)
+	notifications.UsePreferences(preferences)
+
+	// Background jobs and periodic tasks
+	jobRepo := data.NewJobRepo(db)
+	jobs := domain.NewJobQueue(jobRepo, cfg.JobWorkers)
+	scheduler := domain.NewScheduler(jobs)
+	scheduler.UseTenants(tenants.IsolatedTenants)
+
+	var mailer domain.Mailer = mail.LogMailer{}
+	if cfg.SMTPAddr != "" {
+		mailer = mail.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	}
+
+	listing := domain.NewListingService(
+		data.NewCommentListSource(tenants),
+		data.NewActivityListSource(tenants),
+		data.NewNotificationListSource(tenants),
+		data.NewDiscussionListSource(tenants),
+		data.NewEngagementListSource(tenants),
+	)
+	savedViews := domain.NewSavedViewService(data.NewSavedViewRepo(tenants), listing, users, jobs, mailer, cfg.AppURL)
+	scheduler.Add(domain.ScheduledTask{Name: "deliver-saved-views", Kind: domain.JobDeliverDueViews, Interval: time.Minute})
+
+	// Collaborative documents edited live over WebSockets
+	collab := domain.NewCollabService(data.NewCollabRepo(tenants), jobs)
+	collabServer := realtime.NewCollabServer(collab)
+	collabServer.UseHub(hub)
+	scheduler.Add(domain.ScheduledTask{Name: "compact-documents", Kind: domain.JobCompactDocuments, Interval: 10 * time.Minute})
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
+	// Calendar entries, published to calendar apps as iCalendar feeds
+	calendar := domain.NewCalendarService(data.NewCalendarRepo(tenants), cfg.AppURL)
+
+	// Uploaded files, stored once per distinct content
+	blobs, err := storage.NewFSBlobStore(cfg.FileStorageDir)
+	if err != nil {
+		return err
+	}
+	files := domain.NewFileService(data.NewFileRepo(tenants), storage.NewTenantBlobStore(blobs, tenants.Dir), jobs, int64(cfg.MaxUploadBytes))
+	scheduler.Add(domain.ScheduledTask{Name: "collect-blobs", Kind: domain.JobCollectBlobs, Interval: time.Hour})
+	scanner, err := newScanner(cfg)
+	if err != nil {
+		return err
+	}
+	if scanner != nil {
+		// Uploads stay quarantined until the scanner finds them clean
+		files.UseScanner(scanner, notifications)
+		scheduler.Add(domain.ScheduledTask{Name: "rescan-blobs", Kind: domain.JobRescanBlobs, Interval: time.Hour})
+	}
+	parts, err := storage.NewFSUploadStore(cfg.UploadDir)
+	if err != nil {
+		return err
+	}
+	uploads := domain.NewUploadService(data.NewUploadRepo(tenants), storage.NewTenantUploadStore(parts, tenants.Dir), files, jobs, time.Duration(cfg.UploadTTLHours)*time.Hour)
+	scheduler.Add(domain.ScheduledTask{Name: "expire-uploads", Kind: domain.JobExpireUploads, Interval: time.Hour})
+
+	// PDF documents rendered from templates, stored as files of their
+	// owners and downloaded through signed links
+	renderer, err := newRenderer(cfg)
+	if err != nil {
+		return err
+	}
+	documents := domain.NewDocumentService(data.NewDocumentRepo(tenants), renderer, files, listing, jobs, cfg.AuthSecret, cfg.AppURL,
+		time.Duration(cfg.DocLinkSeconds)*time.Second, time.Duration(cfg.DocRetentionDays)*24*time.Hour)
+	documents.UseNotifications(notifications)
+	scheduler.Add(domain.ScheduledTask{Name: "expire-documents", Kind: domain.JobExpireDocuments, Interval: time.Hour})
+
+	// Notifications are also pushed to users' browsers with Web Push
+	vapidKey, err := loadVAPIDKey(cfg)
+	if err != nil {
+		return err
+	}
+	pushClient := webpush.NewClient(vapidKey, vapidSubject(cfg))
+	push := domain.NewPushService(data.NewPushRepo(tenants), pushClient, jobs,
+		vapidKey.PublicKey(), time.Duration(cfg.PushTTLSeconds)*time.Second)
+	push.SetUrgency(domain.NotificationFileQuarantined, domain.PushUrgencyHigh)
+	if !cfg.IsProduction() {
+		push.AllowLoopback()
+		pushClient.AllowLoopback()
+	}
+	notifications.AddChannel(push)
+
+	// Users who opt in get their notifications by e-mail, too
+	emailNotifications := domain.NewEmailNotifications(users, preferences, mailer, jobs, cfg.AppURL)
+	notifications.AddChannel(emailNotifications)
+
+	// Mail to the inbound domain: replies to notification e-mails become
+	// comments, and attachments become files
+	var inbound *domain.InboundMailService
+	if cfg.InboundDomain != "" {
+		addresses := domain.NewInboundAddresses(cfg.AuthSecret, cfg.InboundDomain)
+		emailNotifications.UseReplyAddresses(addresses)
+		inbound = domain.NewInboundMailService(data.NewInboundMailRepo(tenants), addresses, users, comments, files)
+		if cfg.InboundSMTPAddr != "" {
+			receiver := mail.NewServer(cfg.InboundDomain, int64(cfg.InboundMaxBytes), inbound)
+			if cfg.TLSCertFile != "" {
+				cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+				if err != nil {
+					return err
+				}
+				receiver.UseTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+			}
+			go func() {
+				if err := receiver.ListenAndServe(ctx, cfg.InboundSMTPAddr); err != nil {
+					log.Printf("inbound SMTP listener: %v", err)
+				}
+			}()
+			log.Printf("Receiving mail for %s on %s", cfg.InboundDomain, cfg.InboundSMTPAddr)
 		}
+	}
+
+	// Abuse reports and the moderation queue; auto-flag rules check
+	// comments as they are posted and edited, before they are announced
+	audit := domain.NewAuditService(data.NewAuditRepo(tenants))
+	moderation := domain.NewModerationService(data.NewModerationRepo(tenants), audit, notifications)
+	moderation.SetStrikePolicy(cfg.BanAfterStrikes, time.Duration(cfg.StrikeExpiryDays)*24*time.Hour)
+	moderation.RegisterTarget("comment", comments)
+	moderation.RegisterTarget("user", users)
+	comments.UseModeration(moderation)
+
+	// Versioned terms and policies; callers are held up until they accept
+	// the current ones
+	policies := domain.NewPolicyService(data.NewPolicyRepo(tenants), audit)
 
-		c.Next()
+	// Resources managed through the generic admin API
+	admin := domain.NewAdminService(audit,
+		data.NewUserAdminResource(tenants),
+		data.NewCommentAdminResource(tenants, comments),
+		data.NewSavedViewAdminResource(tenants),
+	)
+	jobAdmin := domain.NewJobAdminService(jobRepo, jobs, scheduler, audit)
+
+	events.Subscribe("*", activity.HandleEvent)
+	realtime.ForwardEvents(events, hub)
+
+	router := api.NewRouter(api.Services{
+		Config:        cfg,
+		Tokens:        domain.NewTokenSigner(cfg.AuthSecret),
+		Users:         users,
+		Comments:      comments,
+		Activity:      activity,
+		Notifications: notifications,
+		Preferences:   preferences,
+		Listing:       listing,
+		SavedViews:    savedViews,
+		Admin:         admin,
+		Audit:         audit,
+		Jobs:          jobAdmin,
+		Schemas:       schemas,
+		Collab:        collab,
+		Calendar:      calendar,
+		Files:         files,
+		Uploads:       uploads,
+		Push:          push,
+		Moderation:    moderation,
+		Projections:   projections,
+		Inbound:       inbound,
+		Documents:     documents,
+		Policies:      policies,
+		Hub:           hub,
+		CollabServer:  collabServer,
 	})
 
-	// Basic health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
-			"message": "Greact-Bones API is running!",
+	go jobs.Run(ctx)
+	go scheduler.Run(ctx)
+	go projections.Run(ctx)
+
+	// Start the server on the configured port
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+	go func() {
+		<-ctx.Done()
+		collabServer.Shutdown()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("Listening on :%s", cfg.Port)
+	if cfg.TLSCertFile != "" {
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// runToken mints a bearer token for local development and testing.
+func runToken(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	user := fs.String("user", "", "user ID (required)")
+	username := fs.String("username", "", "username used for @mentions (defaults to the user ID)")
+	email := fs.String("email", "", "e-mail address")
+	tenant := fs.String("tenant", domain.DefaultTenant, "tenant ID")
+	roles := fs.String("roles", "", "comma-separated roles")
+	teams := fs.String("teams", "", "comma-separated team IDs")
+	ttl := fs.Duration("ttl", 24*time.Hour, "token lifetime")
+	fs.Parse(args)
+
+	if *user == "" {
+		return fmt.Errorf("token: -user is required")
+	}
+	token, err := domain.NewTokenSigner(cfg.AuthSecret).Issue(domain.Identity{
+		UserID:   *user,
+		TenantID: *tenant,
+		Username: *username,
+		Email:    *email,
+		Roles:    splitList(*roles),
+		Teams:    splitList(*teams),
+	}, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// checkSchema compares the live schema with the migrations at startup,
+// as configured by SCHEMA_CHECK.
+func checkSchema(ctx context.Context, cfg *config.Config, db *sql.DB) error {
+	if cfg.SchemaCheck == "off" {
+		return nil
+	}
+	drift, err := data.VerifySchema(ctx, db, cfg.DatabaseDriver)
+	if err != nil {
+		log.Printf("Schema check failed: %v", err)
+		return nil
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+	for _, d := range drift {
+		log.Printf("Schema drift: %s", d)
+	}
+	if cfg.SchemaCheck == "block" {
+		return fmt.Errorf("the database schema differs from the migrations in %d places (SCHEMA_CHECK=block)", len(drift))
+	}
+	return nil
+}
+
+// runMigrate applies the migrations (up), compares the live schema with
+// them (verify) or checks them for dangerous operations (lint).
+func runMigrate(cfg *config.Config, args []string) error {
+	sub := "up"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	ctx := context.Background()
+
+	if sub == "lint" {
+		findings, err := data.LintMigrations()
+		if err != nil {
+			return err
+		}
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		if len(findings) > 0 {
+			return fmt.Errorf("migrate lint: %d findings; fix them or add a -- lint:ignore <rule> comment", len(findings))
+		}
+		fmt.Println("No dangerous migration operations found")
+		return nil
+	}
+
+	db, err := data.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch sub {
+	case "up":
+		if err := data.Migrate(ctx, db); err != nil {
+			return err
+		}
+		fmt.Println("Migrations applied")
+		return nil
+	case "verify":
+		drift, err := data.VerifySchema(ctx, db, cfg.DatabaseDriver)
+		if err != nil {
+			return err
+		}
+		for _, d := range drift {
+			fmt.Println(d)
+		}
+		if len(drift) > 0 {
+			return fmt.Errorf("migrate verify: the schema differs from the migrations in %d places", len(drift))
+		}
+		fmt.Println("The schema matches the migrations")
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q (available: up, verify, lint)", sub)
+}
+
+// runSnapshot copies the configured database into a SQLite file with
+// personal data masked, or with -check verifies that every column has a
+// masking rule.
+func runSnapshot(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := fs.String("out", "", "SQLite file to create (required unless -check)")
+	check := fs.Bool("check", false, "only verify that every column has a masking rule")
+	shiftDays := fs.Int("shift-days", 0, "days to move dates by (default: random, up to a year back)")
+	fs.Parse(args)
+	ctx := context.Background()
+
+	if *check {
+		problems, err := data.CheckMasking(ctx)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("snapshot: masking rules are incomplete:\n  %s", strings.Join(problems, "\n  "))
+		}
+		fmt.Println("Every column has a masking rule")
+		return nil
+	}
+	if *out == "" {
+		return fmt.Errorf("snapshot: -out is required")
+	}
+
+	db, err := data.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tenants, err := data.NewTenantRouter(ctx, db, tenantOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer tenants.Close()
+	tables, err := data.Snapshot(ctx, db, data.SnapshotOptions{Out: *out, ShiftDays: *shiftDays, Tenants: tenants})
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	for _, t := range tables {
+		name := t.Name
+		if t.Tenant != "" {
+			name = t.Tenant + "/" + t.Name
+		}
+		if t.Skipped {
+			fmt.Printf("%-28s skipped\n", name)
+		} else {
+			fmt.Printf("%-28s %d rows\n", name, t.Rows)
+		}
+	}
+	fmt.Printf("Wrote %s\n", *out)
+	return nil
+}
+
+// runFiles checks blob storage against the file metadata (check) or
+// collects unused blobs (gc) without starting the server.
+func runFiles(cfg *config.Config, args []string) error {
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("files "+sub, flag.ExitOnError)
+	deep := fs.Bool("deep", false, "rehash all stored content (check only)")
+	fs.Parse(args)
+	ctx := context.Background()
+
+	db, err := data.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	blobs, err := storage.NewFSBlobStore(cfg.FileStorageDir)
+	if err != nil {
+		return err
+	}
+	tenants, err := data.NewTenantRouter(ctx, db, tenantOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer tenants.Close()
+	files := domain.NewFileService(data.NewFileRepo(tenants), storage.NewTenantBlobStore(blobs, tenants.Dir), nil, int64(cfg.MaxUploadBytes))
+
+	// The main database and every isolated tenant keep their own blobs
+	isolated, err := tenants.IsolatedTenants(ctx)
+	if err != nil {
+		return err
+	}
+	scopes := append([]string{""}, isolated...)
+	scope := func(tenantID string) (context.Context, string) {
+		if tenantID == "" {
+			return ctx, "main database"
+		}
+		return domain.WithTenant(ctx, tenantID), "tenant " + tenantID
+	}
+
+	switch sub {
+	case "check":
+		problems := 0
+		for _, tenantID := range scopes {
+			sctx, label := scope(tenantID)
+			report, err := files.Check(sctx, *deep)
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+			fmt.Printf("%s: %d blobs, %d stored objects\n", label, report.Blobs, report.Objects)
+			for _, hash := range report.Missing {
+				fmt.Printf("missing        %s\n", hash)
+			}
+			for _, hash := range report.SizeMismatches {
+				fmt.Printf("size mismatch  %s\n", hash)
+			}
+			for _, hash := range report.Corrupt {
+				fmt.Printf("corrupt        %s\n", hash)
+			}
+			for _, hash := range report.Orphans {
+				fmt.Printf("orphan         %s\n", hash)
+			}
+			for _, m := range report.RefMismatches {
+				fmt.Printf("ref count      %s stored %d, used by %d files\n", m.Hash, m.Stored, m.Actual)
+			}
+			problems += report.ProblemsFound
+		}
+		if problems > 0 {
+			return fmt.Errorf("files check: %d problems found; orphans and reference counts are fixed by files gc", problems)
+		}
+		fmt.Println("Metadata and storage are consistent")
+		return nil
+	case "gc":
+		for _, tenantID := range scopes {
+			sctx, label := scope(tenantID)
+			report, err := files.CollectGarbage(sctx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+			fmt.Printf("%s: fixed %d reference counts, deleted %d blobs and %d orphans, freed %d bytes\n",
+				label, report.Recounted, report.BlobsDeleted, report.OrphansDeleted, report.BytesFreed)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown files command %q (available: check, gc)", sub)
+}
+
+// newScanner returns the malware scanner selected by SCANNER, or nil if
+// scanning is off.
+func newScanner(cfg *config.Config) (domain.Scanner, error) {
+	switch cfg.Scanner {
+	case "off":
+		return nil, nil
+	case "clamd":
+		clamd, err := malware.NewClamd(cfg.ClamdAddr)
+		if err != nil {
+			return nil, err
+		}
+		return clamd, nil
+	case "signatures":
+		signatures, err := malware.NewSignatures(cfg.ScanSignatures)
+		if err != nil {
+			return nil, err
+		}
+		return signatures, nil
+	}
+	return nil, fmt.Errorf("unknown SCANNER %q (available: clamd, signatures, off)", cfg.Scanner)
+}
+
+// runScan scans local files with the configured scanner (file), or
+// serves the clamd protocol backed by the signature scanner (clamd) so the
+// clamd integration can be used without installing ClamAV.
+func runScan(cfg *config.Config, args []string) error {
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	ctx := context.Background()
+	switch sub {
+	case "file":
+		fs := flag.NewFlagSet("scan file", flag.ExitOnError)
+		fs.Parse(args)
+		scanner, err := newScanner(cfg)
+		if err != nil {
+			return err
+		}
+		if scanner == nil {
+			return errors.New("scan file: SCANNER is off")
+		}
+		version, err := scanner.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Scanning with %s %s\n", scanner.Name(), version)
+		infected := 0
+		for _, path := range fs.Args() {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			result, err := scanner.Scan(ctx, f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if result.Infected {
+				infected++
+				fmt.Printf("%s: %s FOUND\n", path, result.Signature)
+			} else {
+				fmt.Printf("%s: OK\n", path)
+			}
+		}
+		if infected > 0 {
+			return fmt.Errorf("scan file: %d infected files", infected)
+		}
+		return nil
+	case "clamd":
+		fs := flag.NewFlagSet("scan clamd", flag.ExitOnError)
+		listen := fs.String("listen", "127.0.0.1:3310", "TCP address, or unix:///path for a socket")
+		maxStream := fs.Int64("max-stream", int64(cfg.MaxUploadBytes), "largest accepted stream in bytes")
+		fs.Parse(args)
+		signatures, err := malware.NewSignatures(cfg.ScanSignatures)
+		if err != nil {
+			return err
+		}
+		network, address := "tcp", *listen
+		if strings.HasPrefix(address, "unix://") {
+			network, address = "unix", strings.TrimPrefix(address, "unix://")
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return err
+		}
+		version, _ := signatures.Version(ctx)
+		log.Printf("Serving the clamd protocol on %s with %s", *listen, version)
+		return (&malware.ClamdServer{Scanner: signatures, MaxStream: *maxStream}).Serve(l)
+	}
+	return fmt.Errorf("unknown scan command %q (available: file, clamd)", sub)
+}
+
+// backplaneRetention is how long the shared backplanes keep messages for
+// replicas that reconnect or restart.
+const backplaneRetention = 10 * time.Minute
+
+// newBackplane returns the realtime backplane BACKPLANE selects.
+func newBackplane(cfg *config.Config, db *sql.DB) (realtime.Backplane, error) {
+	switch cfg.Backplane {
+	case "memory":
+		return backplane.NewMemory(), nil
+	case "postgres":
+		if cfg.DatabaseDriver != "postgres" {
+			return nil, fmt.Errorf("BACKPLANE=postgres needs DATABASE_DRIVER=postgres, not %q", cfg.DatabaseDriver)
+		}
+		return backplane.NewPostgres(data.NewRealtimeLogRepo(db), cfg.DatabaseURL, backplaneRetention), nil
+	case "nats":
+		return backplane.NewNATS(backplane.NATSOptions{
+			URL:       cfg.NATSURL,
+			Listen:    cfg.NATSListen,
+			StoreDir:  cfg.NATSStoreDir,
+			Retention: backplaneRetention,
 		})
-	})
+	}
+	return nil, fmt.Errorf("unknown BACKPLANE %q (available: memory, postgres, nats)", cfg.Backplane)
+}
+
+// loadVAPIDKey returns the key of VAPID_PRIVATE_KEY, or the one in
+// VAPID_KEY_FILE, which is generated on first use.
+func loadVAPIDKey(cfg *config.Config) (*webpush.VAPIDKey, error) {
+	if cfg.VAPIDPrivateKey != "" {
+		return webpush.ParseVAPIDKey(cfg.VAPIDPrivateKey)
+	}
+	key, created, err := webpush.LoadOrCreateVAPIDKey(cfg.VAPIDKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("VAPID key: %w", err)
+	}
+	if created {
+		log.Printf("Generated a VAPID key for Web Push in %s", cfg.VAPIDKeyFile)
+	}
+	return key, nil
+}
+
+func vapidSubject(cfg *config.Config) string {
+	if cfg.VAPIDSubject != "" {
+		return cfg.VAPIDSubject
+	}
+	return cfg.AppURL
+}
+
+// runPush generates VAPID keys (keys), or runs a push service stand-in
+// (receive) that decrypts and logs what the server pushes to the
+// subscriptions it hands out.
+func runPush(cfg *config.Config, args []string) error {
+	sub := ""
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "keys":
+		key, err := webpush.GenerateVAPIDKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("VAPID_PRIVATE_KEY=%s\n", key.PrivateKey())
+		fmt.Printf("# public key: %s\n", key.PublicKey())
+		return nil
+	case "receive":
+		fs := flag.NewFlagSet("push receive", flag.ExitOnError)
+		listen := fs.String("listen", "127.0.0.1:8090", "address to listen on")
+		fs.Parse(args)
+		receiver := webpush.NewReceiver()
+		receiver.Received = webpush.LogReceived
+		log.Printf("Push service stand-in on http://%s; GET /subscribe for a subscription, DELETE its endpoint to unsubscribe", *listen)
+		return http.ListenAndServe(*listen, receiver)
+	}
+	return fmt.Errorf("unknown push command %q (available: keys, receive)", sub)
+}
+
+func tenantOptions(cfg *config.Config) data.TenantOptions {
+	return data.TenantOptions{
+		Driver:   cfg.DatabaseDriver,
+		DSN:      cfg.DatabaseURL,
+		Dir:      cfg.TenantDir,
+		MaxPools: cfg.TenantMaxPools,
+	}
+}
+
+// runTenants lists the tenants with a database of their own (list), gives
+// tenants one (provision) or applies pending migrations to them (migrate).
+// The main database is migrated first.
+func runTenants(cfg *config.Config, args []string) error {
+	sub := "list"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("tenants "+sub, flag.ExitOnError)
+	all := fs.Bool("all", false, "migrate every tenant (migrate only)")
+	concurrency := fs.Int("concurrency", 4, "tenants migrated at once with -all")
+	fs.Parse(args)
+	ctx := context.Background()
+
+	db, err := data.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := data.Migrate(ctx, db); err != nil {
+		return err
+	}
+	tenants, err := data.NewTenantRouter(ctx, db, tenantOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer tenants.Close()
+
+	switch sub {
+	case "list":
+		list, err := tenants.Tenants(ctx)
+		if err != nil {
+			return err
+		}
+		migrations, err := data.Migrations()
+		if err != nil {
+			return err
+		}
+		latest := migrations[len(migrations)-1].Version
+		for _, t := range list {
+			state := "up to date"
+			if t.Version != latest {
+				state = "pending migrations"
+			}
+			fmt.Printf("%-24s %-40s %-28s %s\n", t.TenantID, t.Location, t.Version, state)
+		}
+		fmt.Printf("%d tenants with a database of their own\n", len(list))
+		return nil
+	case "provision":
+		if fs.NArg() == 0 {
+			return fmt.Errorf("tenants provision: name the tenants to provision")
+		}
+		for _, id := range fs.Args() {
+			t, err := tenants.Provision(ctx, id)
+			if err != nil {
+				return fmt.Errorf("tenants provision: %w", err)
+			}
+			fmt.Printf("Provisioned %s in %s (migrated to %s)\n", t.TenantID, t.Location, t.Version)
+		}
+		return nil
+	case "migrate":
+		if *all {
+			failed, err := tenants.MigrateAll(ctx, *concurrency, func(tenantID, version string, err error) {
+				if err != nil {
+					fmt.Printf("%-24s failed: %v\n", tenantID, err)
+				} else {
+					fmt.Printf("%-24s migrated to %s\n", tenantID, version)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("tenants migrate: %d tenants failed", failed)
+			}
+			return nil
+		}
+		if fs.NArg() == 0 {
+			return fmt.Errorf("tenants migrate: name the tenants to migrate, or pass -all")
+		}
+		for _, id := range fs.Args() {
+			version, err := tenants.Migrate(ctx, id)
+			if err != nil {
+				return fmt.Errorf("tenants migrate: %w", err)
+			}
+			fmt.Printf("%-24s migrated to %s\n", id, version)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown tenants command %q (available: list, provision, migrate)", sub)
+}
+
+// newRenderer creates the PDF renderer with the configured templates and
+// fonts.
+func newRenderer(cfg *config.Config) (*pdf.Renderer, error) {
+	renderer, err := pdf.NewRenderer()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DocTemplateDir != "" {
+		if err := renderer.LoadTemplates(cfg.DocTemplateDir); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.DocFontDir != "" {
+		if err := renderer.LoadFonts(cfg.DocFontDir); err != nil {
+			return nil, err
+		}
+	}
+	return renderer, nil
+}
+
+// runDocuments lists the document templates and renders one with data
+// from a JSON file, for working on templates without the server.
+func runDocuments(cfg *config.Config, args []string) error {
+	sub := "templates"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("documents "+sub, flag.ExitOnError)
+	name := fs.String("template", "", "template name")
+	dataFile := fs.String("data", "", "JSON file with the template data")
+	out := fs.String("o", "", "output file (default <template>.pdf)")
+	fs.Parse(args)
+
+	renderer, err := newRenderer(cfg)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "templates":
+		for _, t := range renderer.Templates() {
+			origin := "custom"
+			if t.Builtin {
+				origin = "built-in"
+			}
+			fmt.Printf("%-16s %-8s %s\n", t.Name, origin, t.Description)
+		}
+		fmt.Printf("fonts: %s\n", strings.Join(renderer.Fonts(), ", "))
+		return nil
+	case "render":
+		if *name == "" {
+			return fmt.Errorf("documents render: -template is required")
+		}
+		dc := &domain.DocumentContext{Name: *name, Author: "cli", GeneratedAt: time.Now().UTC()}
+		if *dataFile != "" {
+			content, err := os.ReadFile(*dataFile)
+			if err != nil {
+				return err
+			}
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.UseNumber()
+			if err := decoder.Decode(&dc.Data); err != nil {
+				return fmt.Errorf("documents render: %s: %w", *dataFile, err)
+			}
+		}
+		if *out == "" {
+			*out = *name + ".pdf"
+		}
+		var buf bytes.Buffer
+		pages, err := renderer.Render(context.Background(), *name, dc, nil, &buf)
+		if err != nil {
+			return fmt.Errorf("documents render: %w", err)
+		}
+		if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d pages, %d bytes\n", *out, pages, buf.Len())
+		return nil
+	}
+	return fmt.Errorf("unknown documents command %q (available: templates, render)", sub)
+}
+
+// runInbound prints the inbound addresses of a user: where they can send
+// files and, with -resource type/id, where their replies become comments
+// on the resource.
+func runInbound(cfg *config.Config, args []string) error {
+	sub := "address"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("inbound "+sub, flag.ExitOnError)
+	tenant := fs.String("tenant", "default", "tenant ID")
+	user := fs.String("user", "", "user ID")
+	resource := fs.String("resource", "", "resource to reply to, as type/id")
+	fs.Parse(args)
+
+	switch sub {
+	case "address":
+		if cfg.InboundDomain == "" {
+			return fmt.Errorf("inbound address: set INBOUND_DOMAIN")
+		}
+		if *user == "" {
+			return fmt.Errorf("inbound address: -user is required")
+		}
+		addresses := domain.NewInboundAddresses(cfg.AuthSecret, cfg.InboundDomain)
+		fmt.Printf("files: %s\n", addresses.Files(*tenant, *user))
+		if *resource != "" {
+			resourceType, resourceID, _ := strings.Cut(*resource, "/")
+			if err := domain.ValidateResourceRef(resourceType, resourceID); err != nil {
+				return fmt.Errorf("inbound address: %w", err)
+			}
+			fmt.Printf("reply: %s\n", addresses.Reply(*tenant, *user, resourceType, resourceID))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown inbound command %q (available: address)", sub)
+}
+
+// newProjections creates the projection runner with every read model.
+func newProjections(db data.DB) *domain.ProjectionService {
+	return domain.NewProjectionService(data.NewEventLogRepo(db), data.NewProjectionCheckpointRepo(db),
+		data.NewDiscussionProjection(db),
+		data.NewEngagementProjection(db),
+	)
+}
 
-	// API route group
-	api := router.Group("/api")
-	{
-		api.GET("/hello", func(c *gin.Context) {
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Hello from Greact-Bones backend!",
-				"version": "1.0.0",
+// runProjections reports how far the read models trail the event log
+// (status) or empties and replays them (rebuild), in the main database or
+// the database of the tenant given with -tenant. A rebuild waits for a
+// running server to finish its current pass over the projection.
+func runProjections(cfg *config.Config, args []string) error {
+	sub := "status"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("projections "+sub, flag.ExitOnError)
+	tenant := fs.String("tenant", "", "tenant whose database to use")
+	all := fs.Bool("all", false, "rebuild every projection (rebuild only)")
+	fs.Parse(args)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := data.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := data.Migrate(ctx, db); err != nil {
+		return err
+	}
+	tenants, err := data.NewTenantRouter(ctx, db, tenantOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer tenants.Close()
+	ctx = domain.WithTenant(ctx, *tenant)
+	projections := newProjections(tenants)
+
+	switch sub {
+	case "status":
+		statuses, err := projections.Statuses(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			fmt.Printf("%-16s %-11s position %d of %d, %d behind (%.0fs)\n",
+				st.Name, st.State, st.Position, st.Head, st.Lag, st.LagSeconds)
+			if st.LastError != "" {
+				fmt.Printf("%-16s last error: %s\n", "", st.LastError)
+			}
+		}
+		return nil
+	case "rebuild":
+		names := fs.Args()
+		if *all {
+			names = nil
+			for _, p := range projections.Projections() {
+				names = append(names, p.Name())
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("projections rebuild: name the projections to rebuild, or pass -all")
+		}
+		for _, name := range names {
+			start := time.Now()
+			st, err := projections.Rebuild(ctx, name, func(position int64) {
+				fmt.Printf("%-16s replayed %d events\r", name, position)
 			})
-		})
+			if err != nil {
+				return fmt.Errorf("projections rebuild %s: %w", name, err)
+			}
+			fmt.Printf("%-16s rebuilt from %d events in %s\n", name, st.Position, time.Since(start).Round(time.Millisecond))
+		}
+		return nil
 	}
+	return fmt.Errorf("unknown projections command %q (available: status, rebuild)", sub)
+}
 
-	// Start the server on port 8080
-	router.Run(":8080")
-} 
\ No newline at end of file
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
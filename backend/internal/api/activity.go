@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// ActivityHandlers serves resource timelines and the user feed.
+type ActivityHandlers struct {
+	activity *domain.ActivityService
+}
+
+func (h *ActivityHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "resource.activity", "/resources/:type/:id/activity", h.ForResource)
+	rg.PUT("/resources/:type/:id/follow", h.Follow)
+	rg.DELETE("/resources/:type/:id/follow", h.Unfollow)
+	getNamed(rg, "feed", "/me/feed", h.Feed)
+}
+
+func (h *ActivityHandlers) ForResource(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	items, meta, err := h.activity.ForResource(c.Request.Context(), identity(c), c.Param("type"), c.Param("id"), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, items, meta)
+}
+
+func (h *ActivityHandlers) Feed(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	items, meta, err := h.activity.Feed(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, items, meta)
+}
+
+func (h *ActivityHandlers) Follow(c *gin.Context) {
+	if err := h.activity.Follow(c.Request.Context(), identity(c), c.Param("type"), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ActivityHandlers) Unfollow(c *gin.Context) {
+	if err := h.activity.Unfollow(c.Request.Context(), identity(c), c.Param("type"), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
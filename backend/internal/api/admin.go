@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// AdminHandlers serves the generic admin API. Every registered admin
+// resource gets the same schema, list, detail and write endpoints, so an
+// admin UI can be generated from the schema alone.
+type AdminHandlers struct {
+	admin *domain.AdminService
+	audit *domain.AuditService
+}
+
+func (h *AdminHandlers) register(rg *gin.RouterGroup) {
+	rg.GET("/schema", h.Schemas)
+	rg.GET("/schema/:resource", h.Schema)
+	rg.GET("/resources/:resource", h.List)
+	rg.POST("/resources/:resource", h.Create)
+	rg.GET("/resources/:resource/:id", h.Get)
+	rg.PATCH("/resources/:resource/:id", h.Update)
+	rg.DELETE("/resources/:resource/:id", h.Delete)
+	rg.GET("/audit", h.Audit)
+}
+
+func (h *AdminHandlers) Schemas(c *gin.Context) {
+	respondOK(c, http.StatusOK, h.admin.Schemas(identity(c)))
+}
+
+func (h *AdminHandlers) Schema(c *gin.Context) {
+	schema, err := h.admin.Schema(identity(c), c.Param("resource"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, schema)
+}
+
+// List accepts the listing toolkit's filter, sort and columns parameters.
+func (h *AdminHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	q := domain.ParseListQuery(c.Request.URL.Query())
+	records, meta, err := h.admin.List(c.Request.Context(), identity(c), c.Param("resource"), q, params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, records, meta)
+}
+
+func (h *AdminHandlers) Get(c *gin.Context) {
+	record, err := h.admin.Get(c.Request.Context(), identity(c), c.Param("resource"), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, record)
+}
+
+func (h *AdminHandlers) Create(c *gin.Context) {
+	var values map[string]interface{}
+	if err := c.ShouldBindJSON(&values); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	record, err := h.admin.Create(c.Request.Context(), identity(c), c.Param("resource"), values)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, record)
+}
+
+func (h *AdminHandlers) Update(c *gin.Context) {
+	var values map[string]interface{}
+	if err := c.ShouldBindJSON(&values); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	record, err := h.admin.Update(c.Request.Context(), identity(c), c.Param("resource"), c.Param("id"), values)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, record)
+}
+
+func (h *AdminHandlers) Delete(c *gin.Context) {
+	if err := h.admin.Delete(c.Request.Context(), identity(c), c.Param("resource"), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Audit lists the audit log, filtered by actor_id, action, resource_type
+// and resource_id.
+func (h *AdminHandlers) Audit(c *gin.Context) {
+	var query struct {
+		domain.ListParams
+		domain.AuditFilter
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	entries, meta, err := h.audit.List(c.Request.Context(), identity(c), query.AuditFilter, query.ListParams)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, entries, meta)
+}
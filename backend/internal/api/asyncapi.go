@@ -0,0 +1,202 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const asyncAPIVersion = "2.6.0"
+
+// SchemaHandlers documents the events and realtime messages: the schema
+// registry and the AsyncAPI document generated from it.
+type SchemaHandlers struct {
+	schemas *domain.MessageSchemaRegistry
+	appURL  string
+}
+
+func (h *SchemaHandlers) register(rg *gin.RouterGroup) {
+	rg.GET("/asyncapi.json", h.AsyncAPI)
+	rg.GET("/message-schemas", h.List)
+	rg.GET("/message-schemas/:name", h.Get)
+}
+
+func (h *SchemaHandlers) List(c *gin.Context) {
+	respondOK(c, http.StatusOK, h.schemas.All())
+}
+
+// Get returns a message schema with every version.
+func (h *SchemaHandlers) Get(c *gin.Context) {
+	info, err := h.schemas.Get(c.Param("name"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, info)
+}
+
+// AsyncAPI serves the AsyncAPI document. It is a document in its own
+// right, so it is not wrapped in the response envelope.
+func (h *SchemaHandlers) AsyncAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, asyncAPIDocument(h.schemas.All(), h.appURL))
+}
+
+// asyncAPIDocument describes the Server-Sent Events stream at /api/stream,
+// the WebSocket of collaborative documents, and the in-process event bus
+// and backplane. Each topic a client can pass is a channel; the SSE event
+// name is the message name.
+func asyncAPIDocument(schemas []domain.MessageSchema, appURL string) gin.H {
+	host, protocol := "localhost:8080", "http"
+	if u, err := url.Parse(appURL); err == nil && u.Host != "" {
+		host, protocol = u.Host, u.Scheme
+	}
+	wsProtocol := "ws"
+	if protocol == "https" {
+		wsProtocol = "wss"
+	}
+
+	componentSchemas := gin.H{}
+	messages := gin.H{}
+	byChannel := map[domain.MessageChannel][]gin.H{}
+	event := domain.SchemaOf(domain.Event{})
+
+	for _, s := range schemas {
+		ref := gin.H{"$ref": "#/components/messages/" + s.Name}
+		byChannel[s.Channel] = append(byChannel[s.Channel], ref)
+
+		// Domain events carry their payload in Event.data; other messages
+		// are the payload itself
+		schema := s.Payload.Clone()
+		if s.Channel == domain.ChannelResource || s.Channel == domain.ChannelEvents {
+			schema = event.Clone()
+			schema.Properties["type"].Enum = []string{s.Name}
+			schema.Properties["data"] = s.Payload.Clone()
+			schema.Required = append(schema.Required, "version", "data")
+		}
+		// Frames keep the description of their layout
+		if s.Channel != domain.ChannelCollab {
+			schema.Description = s.Description
+		}
+		componentSchemas[s.Name] = schema
+
+		payload := gin.H{"$ref": "#/components/schemas/" + s.Name}
+		if s.Channel == domain.ChannelUser || s.Channel == domain.ChannelResource {
+			payload = streamEnvelope(s.Name)
+		}
+		message := gin.H{
+			"messageId":        s.Name,
+			"name":             s.Name,
+			"title":            s.Name,
+			"summary":          s.Summary,
+			"payload":          payload,
+			"x-schema-version": s.Version,
+		}
+		if s.Channel == domain.ChannelCollab {
+			message["contentType"] = "application/octet-stream"
+		}
+		if s.Description != "" {
+			message["description"] = s.Description
+		}
+		if s.Deprecated {
+			message["x-deprecated"] = true
+		}
+		messages[s.Name] = message
+	}
+
+	channels := gin.H{}
+	if refs := byChannel[domain.ChannelUser]; len(refs) > 0 {
+		channels["me"] = gin.H{
+			"description": "Messages addressed to the caller. Subscribe with GET /api/stream?topic=me.",
+			"servers":     []string{"api"},
+			"subscribe":   gin.H{"operationId": "streamUserMessages", "message": gin.H{"oneOf": refs}},
+		}
+	}
+	if refs := byChannel[domain.ChannelResource]; len(refs) > 0 {
+		channels["resource:{resourceType}:{resourceId}"] = gin.H{
+			"description": "Domain events about a resource, such as comments on it. " +
+				"Subscribe with GET /api/stream?topic=resource:<type>:<id>. " +
+				"These events are also published on the internal event bus.",
+			"servers": []string{"api"},
+			"parameters": gin.H{
+				"resourceType": gin.H{"description": "Resource type, e.g. project", "schema": gin.H{"type": "string"}},
+				"resourceId":   gin.H{"description": "Resource ID", "schema": gin.H{"type": "string"}},
+			},
+			"subscribe": gin.H{"operationId": "streamResourceEvents", "message": gin.H{"oneOf": refs}},
+		}
+	}
+	if refs := byChannel[domain.ChannelEvents]; len(refs) > 0 {
+		channels["events"] = gin.H{
+			"description": "Domain events on the in-process event bus. They are not streamed to clients.",
+			"x-internal":  true,
+			"subscribe":   gin.H{"operationId": "handleEvents", "message": gin.H{"oneOf": refs}},
+		}
+	}
+	if refs := byChannel[domain.ChannelCollab]; len(refs) > 0 {
+		channels["{documentId}"] = gin.H{
+			"description": "The y-websocket session of a collaborative document, opened with GET /api/collab/sync/<id>. " +
+				"Both sides send the same binary frames.",
+			"servers": []string{"collab"},
+			"parameters": gin.H{
+				"documentId": gin.H{"description": "Collaborative document ID", "schema": gin.H{"type": "string"}},
+			},
+			"publish":   gin.H{"operationId": "sendCollabMessages", "message": gin.H{"oneOf": refs}},
+			"subscribe": gin.H{"operationId": "receiveCollabMessages", "message": gin.H{"oneOf": refs}},
+		}
+	}
+	if refs := byChannel[domain.ChannelBackplane]; len(refs) > 0 {
+		channels["backplane"] = gin.H{
+			"description": "Realtime messages relayed between replicas. They are not sent to clients.",
+			"x-internal":  true,
+			"subscribe":   gin.H{"operationId": "relayMessages", "message": gin.H{"oneOf": refs}},
+		}
+	}
+
+	return gin.H{
+		"asyncapi": asyncAPIVersion,
+		"info": gin.H{
+			"title":       "Greact-Bones realtime API",
+			"version":     "1.0.0",
+			"description": "Events, realtime messages and collaborative editing. The REST API is documented separately.",
+		},
+		"servers": gin.H{
+			"api": gin.H{
+				"url":         host + "/api/stream",
+				"protocol":    protocol,
+				"description": "Server-Sent Events; pass one or more topic query parameters",
+				"security":    []gin.H{{"bearerAuth": []string{}}},
+			},
+			"collab": gin.H{
+				"url":         host + "/api/collab/sync",
+				"protocol":    wsProtocol,
+				"description": "WebSocket speaking the y-websocket protocol; browsers pass the token as access_token",
+				"security":    []gin.H{{"bearerAuth": []string{}}},
+			},
+		},
+		"defaultContentType": "application/json",
+		"channels":           channels,
+		"components": gin.H{
+			"messages": messages,
+			"schemas":  componentSchemas,
+			"securitySchemes": gin.H{
+				"bearerAuth": gin.H{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// streamEnvelope is the realtime.Message a stream sends as SSE data.
+func streamEnvelope(name string) gin.H {
+	return gin.H{
+		"type":     "object",
+		"required": []string{"topic", "type", "data"},
+		"properties": gin.H{
+			"id":    gin.H{"type": "integer", "description": "Position in the stream; also the SSE event ID"},
+			"topic": gin.H{"type": "string"},
+			"type":  gin.H{"type": "string", "enum": []string{name}},
+			"data":  gin.H{"$ref": "#/components/schemas/" + name},
+		},
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const maxCalendarImportBytes = 5 << 20
+
+// CalendarHandlers serves calendar entries, .ics import and the feeds
+// calendar apps subscribe to.
+type CalendarHandlers struct {
+	calendar *domain.CalendarService
+}
+
+type occurrencesQuery struct {
+	From time.Time `form:"from" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+	To   time.Time `form:"to" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+type importQuery struct {
+	HorizonDays int `form:"horizon_days" binding:"min=0"`
+}
+
+type feedRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *CalendarHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "calendar.entries", "/calendar/entries", h.List)
+	rg.POST("/calendar/entries", h.Create)
+	getNamed(rg, "calendar.entry", "/calendar/entries/:id", h.Get)
+	rg.PATCH("/calendar/entries/:id", h.Update)
+	rg.DELETE("/calendar/entries/:id", h.Delete)
+	rg.GET("/calendar/occurrences", h.Occurrences)
+	rg.POST("/calendar/import", h.Import)
+	rg.GET("/calendar/feeds", h.ListFeeds)
+	rg.POST("/calendar/feeds", h.CreateFeed)
+	rg.DELETE("/calendar/feeds/:id", h.RevokeFeed)
+}
+
+// registerPublic adds the feed download, which calendar apps call without
+// credentials; the secret token in the URL identifies the user.
+func (h *CalendarHandlers) registerPublic(rg *gin.RouterGroup) {
+	rg.GET("/calendar/ics/:file", h.Feed)
+}
+
+func (h *CalendarHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	entries, meta, err := h.calendar.List(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, entries, meta)
+}
+
+func (h *CalendarHandlers) Create(c *gin.Context) {
+	var in domain.CalendarEntryInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	entry, err := h.calendar.Create(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, entry)
+}
+
+func (h *CalendarHandlers) Get(c *gin.Context) {
+	entry, err := h.calendar.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, entry)
+}
+
+func (h *CalendarHandlers) Update(c *gin.Context) {
+	var in domain.CalendarEntryInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	entry, err := h.calendar.Update(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, entry)
+}
+
+func (h *CalendarHandlers) Delete(c *gin.Context) {
+	if err := h.calendar.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Occurrences lists the instances of the caller's entries between from
+// and to, with recurring entries expanded.
+func (h *CalendarHandlers) Occurrences(c *gin.Context) {
+	var q occurrencesQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	occurrences, err := h.calendar.Occurrences(c.Request.Context(), identity(c), q.From, q.To)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, occurrences)
+}
+
+// Import reads an .ics file, uploaded as the "file" field of a multipart
+// form or sent as the text/calendar request body.
+func (h *CalendarHandlers) Import(c *gin.Context) {
+	var q importQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCalendarImportBytes)
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			respondError(c, domain.InvalidInput("upload the calendar as the \"file\" field"))
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	result, err := h.calendar.Import(c.Request.Context(), identity(c), body, time.Duration(q.HorizonDays)*24*time.Hour)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, result)
+}
+
+func (h *CalendarHandlers) ListFeeds(c *gin.Context) {
+	feeds, err := h.calendar.ListFeeds(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, feeds)
+}
+
+// CreateFeed returns the new feed with its secret URL, which is not shown
+// again.
+func (h *CalendarHandlers) CreateFeed(c *gin.Context) {
+	var req feedRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondInvalidInput(c, err)
+			return
+		}
+	}
+	feed, err := h.calendar.CreateFeed(c.Request.Context(), identity(c), req.Name)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, feed)
+}
+
+func (h *CalendarHandlers) RevokeFeed(c *gin.Context) {
+	if err := h.calendar.RevokeFeed(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Feed serves a calendar as text/calendar for subscription URLs of the
+// form /api/calendar/ics/<token>.ics.
+func (h *CalendarHandlers) Feed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("file"), ".ics")
+	feed, body, err := h.calendar.Feed(c.Request.Context(), token)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("Content-Disposition", `inline; filename="`+safeFilename(feed.Name)+`.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", body)
+}
+
+// safeFilename keeps the characters of name that need no quoting in a
+// Content-Disposition header.
+func safeFilename(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x80 && (r == '-' || r == '_' || r == ' ' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "calendar"
+	}
+	return b.String()
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/realtime"
+)
+
+// CollabHandlers serves collaborative documents and their live editing
+// sessions.
+type CollabHandlers struct {
+	docs     *domain.CollabService
+	server   *realtime.CollabServer
+	upgrader websocket.Upgrader
+}
+
+func newCollabHandlers(docs *domain.CollabService, server *realtime.CollabServer, frontendOrigin string) *CollabHandlers {
+	return &CollabHandlers{
+		docs:   docs,
+		server: server,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			CheckOrigin:     func(r *http.Request) bool { return allowedOrigin(r, frontendOrigin) },
+		},
+	}
+}
+
+func (h *CollabHandlers) register(rg *gin.RouterGroup) {
+	rg.GET("/collab/documents", h.List)
+	rg.POST("/collab/documents", h.Create)
+	rg.GET("/collab/documents/:id", h.Get)
+	rg.PATCH("/collab/documents/:id", h.Update)
+	rg.DELETE("/collab/documents/:id", h.Delete)
+	rg.GET("/collab/documents/:id/content", h.Content)
+	rg.GET("/collab/sync/:id", h.Sync)
+}
+
+func (h *CollabHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	docs, meta, err := h.docs.List(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, docs, meta)
+}
+
+func (h *CollabHandlers) Create(c *gin.Context) {
+	var in domain.CollabDocumentInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	doc, err := h.docs.Create(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, doc)
+}
+
+func (h *CollabHandlers) Get(c *gin.Context) {
+	doc, err := h.docs.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, doc)
+}
+
+func (h *CollabHandlers) Update(c *gin.Context) {
+	var in domain.CollabDocumentInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	doc, err := h.docs.Update(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, doc)
+}
+
+func (h *CollabHandlers) Delete(c *gin.Context) {
+	if err := h.docs.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Content returns the whole document as one Yjs update, to apply with
+// Y.applyUpdate.
+func (h *CollabHandlers) Content(c *gin.Context) {
+	content, err := h.docs.Content(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/octet-stream", content)
+}
+
+// Sync upgrades to a WebSocket speaking the y-websocket protocol. Access
+// is checked before the upgrade, so unauthorized clients get a normal
+// error response. WebSockets cannot send headers, so browsers pass the
+// token as access_token.
+func (h *CollabHandlers) Sync(c *gin.Context) {
+	doc, canEdit, err := h.docs.Join(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	ws, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already wrote an error response
+		return
+	}
+	h.server.Serve(ws, doc, canEdit)
+}
+
+// allowedOrigin accepts WebSocket handshakes from the frontend, from the
+// API's own origin and from non-browser clients, which send no Origin.
+func allowedOrigin(r *http.Request, frontendOrigin string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || frontendOrigin == "*" || origin == frontendOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// CommentHandlers serves the comment endpoints.
+type CommentHandlers struct {
+	comments *domain.CommentService
+}
+
+type createCommentRequest struct {
+	Body     string `json:"body" binding:"required"`
+	ParentID string `json:"parent_id"`
+}
+
+type editCommentRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+func (h *CommentHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "resource.comments", "/resources/:type/:id/comments", h.ListThreads)
+	rg.POST("/resources/:type/:id/comments", h.Create)
+	getNamed(rg, "comment", "/comments/:id", h.Get)
+	rg.PATCH("/comments/:id", h.Edit)
+	rg.DELETE("/comments/:id", h.Delete)
+	getNamed(rg, "comment.replies", "/comments/:id/replies", h.ListReplies)
+	getNamed(rg, "comment.history", "/comments/:id/history", h.History)
+	rg.PUT("/comments/:id/reactions/:emoji", h.React)
+	rg.DELETE("/comments/:id/reactions/:emoji", h.Unreact)
+}
+
+func (h *CommentHandlers) ListThreads(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	comments, meta, err := h.comments.ListThreads(c.Request.Context(), identity(c), c.Param("type"), c.Param("id"), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, comments, meta)
+}
+
+func (h *CommentHandlers) Create(c *gin.Context) {
+	var req createCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	comment, err := h.comments.Create(c.Request.Context(), identity(c), domain.CreateCommentInput{
+		ResourceType: c.Param("type"),
+		ResourceID:   c.Param("id"),
+		ParentID:     req.ParentID,
+		Body:         req.Body,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, comment)
+}
+
+func (h *CommentHandlers) Get(c *gin.Context) {
+	comment, err := h.comments.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, comment)
+}
+
+func (h *CommentHandlers) Edit(c *gin.Context) {
+	var req editCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	comment, err := h.comments.Edit(c.Request.Context(), identity(c), c.Param("id"), req.Body)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, comment)
+}
+
+func (h *CommentHandlers) Delete(c *gin.Context) {
+	if err := h.comments.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *CommentHandlers) ListReplies(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	replies, meta, err := h.comments.ListReplies(c.Request.Context(), identity(c), c.Param("id"), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, replies, meta)
+}
+
+func (h *CommentHandlers) History(c *gin.Context) {
+	revisions, err := h.comments.History(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, revisions)
+}
+
+func (h *CommentHandlers) React(c *gin.Context) {
+	comment, err := h.comments.React(c.Request.Context(), identity(c), c.Param("id"), c.Param("emoji"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, comment)
+}
+
+func (h *CommentHandlers) Unreact(c *gin.Context) {
+	comment, err := h.comments.Unreact(c.Request.Context(), identity(c), c.Param("id"), c.Param("emoji"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, comment)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// DocumentHandlers generates PDF documents and serves their signed
+// download links.
+type DocumentHandlers struct {
+	documents *domain.DocumentService
+}
+
+type documentDownloadQuery struct {
+	Tenant    string `form:"tenant" binding:"required"`
+	Expires   int64  `form:"expires" binding:"required"`
+	Signature string `form:"signature" binding:"required"`
+	Inline    bool   `form:"inline"`
+}
+
+func (h *DocumentHandlers) register(public, authed *gin.RouterGroup) {
+	public.GET("/documents/:id/download", h.Download)
+
+	authed.GET("/documents/templates", h.Templates)
+	getNamed(authed, "documents", "/documents", h.List)
+	authed.POST("/documents", h.Create)
+	getNamed(authed, "document", "/documents/:id", h.Get)
+	authed.DELETE("/documents/:id", h.Delete)
+}
+
+func (h *DocumentHandlers) Templates(c *gin.Context) {
+	respondOK(c, http.StatusOK, h.documents.Templates())
+}
+
+func (h *DocumentHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	docs, meta, err := h.documents.List(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, docs, meta)
+}
+
+// Create renders a document. Documents rendered right away are returned
+// with 201; those left to the background with 202, to be polled.
+func (h *DocumentHandlers) Create(c *gin.Context) {
+	var req domain.DocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	doc, err := h.documents.Create(c.Request.Context(), identity(c), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	status := http.StatusCreated
+	if doc.Status == domain.DocumentPending {
+		status = http.StatusAccepted
+	}
+	respondOK(c, status, doc)
+}
+
+func (h *DocumentHandlers) Get(c *gin.Context) {
+	doc, err := h.documents.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, doc)
+}
+
+func (h *DocumentHandlers) Delete(c *gin.Context) {
+	if err := h.documents.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Download serves the PDF behind a signed link, without a token, as an
+// attachment or, with ?inline=true, for viewing in the browser.
+func (h *DocumentHandlers) Download(c *gin.Context) {
+	var q documentDownloadQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	doc, content, err := h.documents.Download(c.Request.Context(), q.Tenant, c.Param("id"), q.Expires, q.Signature)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer content.Close()
+	disposition := "attachment"
+	if q.Inline {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": doc.Name}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, no-store")
+	http.ServeContent(c.Writer, c.Request, "", *doc.CompletedAt, content)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// FileHandlers serves file uploads and downloads, and the blob storage
+// maintenance endpoints for admins.
+type FileHandlers struct {
+	files *domain.FileService
+}
+
+type fileUploadQuery struct {
+	Name string `form:"name"`
+}
+
+type blobCheckQuery struct {
+	Deep bool `form:"deep"`
+}
+
+func (h *FileHandlers) register(rg, admin *gin.RouterGroup) {
+	getNamed(rg, "files", "/files", h.List)
+	rg.POST("/files", h.Upload)
+	getNamed(rg, "file", "/files/:id", h.Get)
+	getNamed(rg, "file.content", "/files/:id/content", h.Content)
+	rg.DELETE("/files/:id", h.Delete)
+
+	admin.GET("/files/check", h.Check)
+	admin.POST("/files/gc", h.CollectGarbage)
+	admin.GET("/files/quarantine", h.Quarantine)
+	admin.POST("/files/rescan", h.Rescan)
+}
+
+func (h *FileHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	files, meta, err := h.files.List(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, files, meta)
+}
+
+// Upload stores the "file" field of a multipart form, or the raw request
+// body named by the name query parameter.
+func (h *FileHandlers) Upload(c *gin.Context) {
+	var q fileUploadQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	// Allow for multipart framing around the largest accepted file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.files.MaxSize()+1<<20)
+
+	name, contentType := q.Name, c.ContentType()
+	var body io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			respondError(c, domain.InvalidInput("malformed multipart body"))
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				respondError(c, domain.InvalidInput("upload the content as the \"file\" field"))
+				return
+			}
+			if part.FormName() == "file" {
+				if name == "" {
+					name = part.FileName()
+				}
+				contentType = part.Header.Get("Content-Type")
+				body = part
+				break
+			}
+		}
+	}
+	file, err := h.files.Upload(c.Request.Context(), identity(c), name, contentType, body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = domain.InvalidInput("files can be at most %d bytes", h.files.MaxSize())
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, file)
+}
+
+func (h *FileHandlers) Get(c *gin.Context) {
+	file, err := h.files.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, file)
+}
+
+// Content downloads a file. Content never changes, so its hash is a
+// strong ETag, and ranges are supported for resumed downloads.
+func (h *FileHandlers) Content(c *gin.Context) {
+	file, content, err := h.files.Open(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer content.Close()
+	c.Header("Content-Type", file.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("ETag", `"`+file.SHA256+`"`)
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(c.Writer, c.Request, "", file.CreatedAt, content)
+}
+
+func (h *FileHandlers) Delete(c *gin.Context) {
+	if err := h.files.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Check compares blob metadata with storage; ?deep=true rehashes all
+// content.
+func (h *FileHandlers) Check(c *gin.Context) {
+	var q blobCheckQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	report, err := h.files.Check(c.Request.Context(), q.Deep)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, report)
+}
+
+// Quarantine lists the tenant's files that are infected or not scanned
+// yet.
+func (h *FileHandlers) Quarantine(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	files, meta, err := h.files.Unscanned(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, files, meta)
+}
+
+// Rescan scans content not yet checked with the current signatures now
+// instead of waiting for the scheduled run.
+func (h *FileHandlers) Rescan(c *gin.Context) {
+	report, err := h.files.Rescan(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, report)
+}
+
+// CollectGarbage runs the blob garbage collector now instead of waiting
+// for the scheduled run.
+func (h *FileHandlers) CollectGarbage(c *gin.Context) {
+	report, err := h.files.CollectGarbage(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, report)
+}
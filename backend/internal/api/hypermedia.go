@@ -0,0 +1,626 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// Media types of the hypermedia response profiles. Clients opt in through
+// the Accept header; everything else gets the default envelope.
+const (
+	mediaTypeJSONAPI  = "application/vnd.api+json"
+	mediaTypeHAL      = "application/hal+json"
+	mediaTypeVndError = "application/vnd.error+json"
+)
+
+type responseProfile int
+
+const (
+	profileEnvelope responseProfile = iota
+	profileJSONAPI
+	profileHAL
+)
+
+// negotiateProfile picks the response profile with the highest quality
+// value in the Accept header.
+func negotiateProfile(c *gin.Context) responseProfile {
+	best, bestQ := profileEnvelope, 0.0
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		var profile responseProfile
+		switch mediaType {
+		case mediaTypeJSONAPI:
+			profile = profileJSONAPI
+		case mediaTypeHAL:
+			profile = profileHAL
+		default:
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ {
+			best, bestQ = profile, q
+		}
+	}
+	return best
+}
+
+// Named routes let the profiles generate links without hard-coding URLs.
+var namedRoutes = struct {
+	sync.RWMutex
+	paths map[string]string
+}{paths: make(map[string]string)}
+
+// getNamed registers a GET route and names it for link generation.
+func getNamed(rg *gin.RouterGroup, name, path string, handlers ...gin.HandlerFunc) {
+	namedRoutes.Lock()
+	namedRoutes.paths[name] = rg.BasePath() + path
+	namedRoutes.Unlock()
+	rg.GET(path, handlers...)
+}
+
+// linkTo expands a named route with name/value pairs, for example
+// linkTo("comment", "id", "42"). Unknown routes yield an empty link.
+func linkTo(name string, params ...string) string {
+	namedRoutes.RLock()
+	path, ok := namedRoutes.paths[name]
+	namedRoutes.RUnlock()
+	if !ok {
+		return ""
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i+1 < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if name, ok := strings.CutPrefix(seg, ":"); ok {
+			segments[i] = url.PathEscape(values[name])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// resourceDescriptor tells the hypermedia profiles how to present a domain
+// type: its resource type, the named route of a single resource (taking an
+// "id" parameter) and its relationships.
+type resourceDescriptor struct {
+	typ           string
+	route         string
+	id            func(v interface{}) string
+	relationships func(v interface{}) []relationship
+}
+
+func (d resourceDescriptor) self(v interface{}) string {
+	if d.route == "" {
+		return ""
+	}
+	return linkTo(d.route, "id", d.id(v))
+}
+
+// relationship links a resource to others of type typ. Key names the
+// attribute holding the foreign key; JSON:API moves it out of the
+// attributes. Relationships without IDs are only exposed as a link.
+type relationship struct {
+	name    string
+	typ     string
+	key     string
+	ids     []string
+	toMany  bool
+	related string
+}
+
+// toOne relates a resource to the one identified by the foreign key.
+func toOne(name, typ, key, id string) relationship {
+	r := relationship{name: name, typ: typ, key: key}
+	if id != "" {
+		r.ids = []string{id}
+	}
+	return r
+}
+
+// toMany relates a resource to a collection found at the related link.
+func toMany(name, typ, related string) relationship {
+	return relationship{name: name, typ: typ, toMany: true, related: related}
+}
+
+// resourceLoader fetches a resource of one type for inclusion.
+type resourceLoader func(c *gin.Context, id string) (interface{}, error)
+
+var resourceRegistry = struct {
+	sync.RWMutex
+	byGoType map[reflect.Type]resourceDescriptor
+	byName   map[string]resourceDescriptor
+	loaders  map[string]resourceLoader
+}{
+	byGoType: make(map[reflect.Type]resourceDescriptor),
+	byName:   make(map[string]resourceDescriptor),
+	loaders:  make(map[string]resourceLoader),
+}
+
+// describe registers how values of type T are presented as resources.
+func describe[T any](typ, route string, id func(T) string, relationships func(T) []relationship) {
+	d := resourceDescriptor{
+		typ:   typ,
+		route: route,
+		id:    func(v interface{}) string { return id(v.(T)) },
+	}
+	if relationships != nil {
+		d.relationships = func(v interface{}) []relationship { return relationships(v.(T)) }
+	}
+	resourceRegistry.Lock()
+	defer resourceRegistry.Unlock()
+	resourceRegistry.byGoType[reflect.TypeOf((*T)(nil)).Elem()] = d
+	resourceRegistry.byName[typ] = d
+}
+
+// loadResources makes resources of a type available to ?include=.
+func loadResources(typ string, loader resourceLoader) {
+	resourceRegistry.Lock()
+	defer resourceRegistry.Unlock()
+	resourceRegistry.loaders[typ] = loader
+}
+
+func descriptorNamed(typ string) (resourceDescriptor, bool) {
+	resourceRegistry.RLock()
+	defer resourceRegistry.RUnlock()
+	d, ok := resourceRegistry.byName[typ]
+	return d, ok
+}
+
+func descriptorFor(t reflect.Type) (resourceDescriptor, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	resourceRegistry.RLock()
+	defer resourceRegistry.RUnlock()
+	d, ok := resourceRegistry.byGoType[t]
+	return d, ok
+}
+
+// resourcesOf splits data into described resources. It reports whether
+// data is a described type or a slice of one, and whether it is a slice.
+func resourcesOf(data interface{}) (d resourceDescriptor, items []interface{}, many, ok bool) {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return d, nil, false, false
+	}
+	if v.Kind() == reflect.Slice {
+		if d, ok = descriptorFor(v.Type().Elem()); !ok {
+			return d, nil, true, false
+		}
+		items = make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, reflect.Indirect(v.Index(i)).Interface())
+		}
+		return d, items, true, true
+	}
+	if d, ok = descriptorFor(v.Type()); !ok {
+		return d, nil, false, false
+	}
+	return d, []interface{}{v.Interface()}, false, true
+}
+
+// attributesOf returns the JSON object a value encodes to, or nil if it
+// does not encode to an object.
+func attributesOf(v interface{}) map[string]interface{} {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var attrs map[string]interface{}
+	if dec.Decode(&attrs) != nil {
+		return nil
+	}
+	return attrs
+}
+
+// includer resolves the relationships requested with ?include=a,b for one
+// response, loading every related resource at most once.
+type includer struct {
+	c         *gin.Context
+	requested map[string]bool
+	matched   map[string]bool
+	cache     map[string]interface{}
+}
+
+func newIncluder(c *gin.Context) (*includer, error) {
+	inc := &includer{c: c, requested: map[string]bool{}, matched: map[string]bool{}, cache: map[string]interface{}{}}
+	for _, name := range strings.Split(c.Query("include"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if strings.Contains(name, ".") {
+			return nil, domain.InvalidInput("nested includes are not supported (%s)", name)
+		}
+		inc.requested[name] = true
+	}
+	return inc, nil
+}
+
+// load returns the related resources of r if the client asked for them.
+func (inc *includer) load(r relationship) ([]interface{}, bool, error) {
+	if !inc.requested[r.name] {
+		return nil, false, nil
+	}
+	inc.matched[r.name] = true
+	resourceRegistry.RLock()
+	loader := resourceRegistry.loaders[r.typ]
+	resourceRegistry.RUnlock()
+	if loader == nil || (r.ids == nil && r.toMany) {
+		return nil, false, domain.InvalidInput("cannot include %q", r.name)
+	}
+
+	var items []interface{}
+	for _, id := range r.ids {
+		key := r.typ + "/" + id
+		item, ok := inc.cache[key]
+		if !ok {
+			loaded, err := loader(inc.c, id)
+			var appErr domain.AppError
+			if errors.As(err, &appErr) && appErr.Status == http.StatusNotFound {
+				loaded, err = nil, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			item = loaded
+			inc.cache[key] = item
+		}
+		if item != nil {
+			items = append(items, reflect.Indirect(reflect.ValueOf(item)).Interface())
+		}
+	}
+	return items, true, nil
+}
+
+// check rejects requested includes that no primary resource offers.
+func (inc *includer) check(primaries int) error {
+	if primaries == 0 {
+		return nil
+	}
+	for name := range inc.requested {
+		if !inc.matched[name] {
+			return domain.InvalidInput("cannot include %q", name)
+		}
+	}
+	return nil
+}
+
+// pageLinks returns links to the first, previous, next and last page of
+// the current request; absent pages are nil.
+func pageLinks(c *gin.Context, meta domain.PaginationMeta) map[string]interface{} {
+	page := func(n int) interface{} {
+		q := c.Request.URL.Query()
+		q.Set("page", strconv.Itoa(n))
+		q.Set("limit", strconv.Itoa(meta.Limit))
+		return c.Request.URL.Path + "?" + q.Encode()
+	}
+	links := map[string]interface{}{"first": page(1), "prev": nil, "next": nil, "last": nil}
+	if meta.HasPrev {
+		links["prev"] = page(meta.Page - 1)
+	}
+	if meta.HasNext {
+		links["next"] = page(meta.Page + 1)
+	}
+	if meta.TotalPages > 0 {
+		links["last"] = page(meta.TotalPages)
+	}
+	return links
+}
+
+// renderJSONAPI writes data as a JSON:API document. Values that are not
+// described resources travel in the top-level meta, the only other member
+// a document may carry in place of data.
+func renderJSONAPI(c *gin.Context, status int, data interface{}, page *domain.PaginationMeta, extra gin.H) {
+	inc, err := newIncluder(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	doc := gin.H{"jsonapi": gin.H{"version": "1.1"}}
+	links := gin.H{"self": c.Request.URL.RequestURI()}
+	meta := gin.H{}
+	if page != nil {
+		for k, v := range pageLinks(c, *page) {
+			links[k] = v
+		}
+		for k, v := range attributesOf(*page) {
+			meta[k] = v
+		}
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+
+	d, items, many, ok := resourcesOf(data)
+	if ok {
+		seen := make(map[string]bool)
+		for _, item := range items {
+			seen[d.typ+"/"+d.id(item)] = true
+		}
+		objects := make([]gin.H, 0, len(items))
+		included := []gin.H{}
+		for _, item := range items {
+			obj, related, err := jsonAPIResource(d, item, inc)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			objects = append(objects, obj)
+			for _, rel := range related {
+				key := rel["type"].(string) + "/" + rel["id"].(string)
+				if !seen[key] {
+					seen[key] = true
+					included = append(included, rel)
+				}
+			}
+		}
+		if err := inc.check(len(items)); err != nil {
+			respondError(c, err)
+			return
+		}
+		if many {
+			doc["data"] = objects
+		} else {
+			doc["data"] = objects[0]
+		}
+		if len(inc.requested) > 0 {
+			doc["included"] = included
+		}
+	} else {
+		meta["data"] = data
+	}
+
+	doc["links"] = links
+	if len(meta) > 0 {
+		doc["meta"] = meta
+	}
+	c.Header("Content-Type", mediaTypeJSONAPI)
+	c.JSON(status, doc)
+}
+
+// jsonAPIResource renders one resource object and the resources it pulls
+// into the compound document.
+func jsonAPIResource(d resourceDescriptor, v interface{}, inc *includer) (gin.H, []gin.H, error) {
+	attrs := attributesOf(v)
+	delete(attrs, "id")
+	obj := gin.H{"type": d.typ, "id": d.id(v)}
+	if self := d.self(v); self != "" {
+		obj["links"] = gin.H{"self": self}
+	}
+
+	var included []gin.H
+	if d.relationships != nil {
+		rels := gin.H{}
+		for _, r := range d.relationships(v) {
+			if r.key != "" {
+				delete(attrs, r.key)
+			}
+			rel := gin.H{}
+			related := r.related
+			if !r.toMany && len(r.ids) == 1 {
+				rel["data"] = gin.H{"type": r.typ, "id": r.ids[0]}
+				if related == "" {
+					if target, ok := descriptorNamed(r.typ); ok && target.route != "" {
+						related = linkTo(target.route, "id", r.ids[0])
+					}
+				}
+			} else if !r.toMany {
+				rel["data"] = nil
+			} else if r.ids != nil {
+				data := make([]gin.H, len(r.ids))
+				for i, id := range r.ids {
+					data[i] = gin.H{"type": r.typ, "id": id}
+				}
+				rel["data"] = data
+			}
+			if related != "" {
+				rel["links"] = gin.H{"related": related}
+			}
+			rels[r.name] = rel
+
+			loaded, ok, err := inc.load(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+			for _, item := range loaded {
+				target, found := descriptorFor(reflect.TypeOf(item))
+				if !found {
+					continue
+				}
+				// Included resources do not pull in further resources.
+				relatedObj, _, err := jsonAPIResource(target, item, &includer{})
+				if err != nil {
+					return nil, nil, err
+				}
+				included = append(included, relatedObj)
+			}
+		}
+		if len(rels) > 0 {
+			obj["relationships"] = rels
+		}
+	}
+	obj["attributes"] = attrs
+	return obj, included, nil
+}
+
+// renderHAL writes data as a HAL document: resources keep their fields and
+// gain _links, included relationships and list items go to _embedded.
+func renderHAL(c *gin.Context, status int, data interface{}, page *domain.PaginationMeta, extra gin.H) {
+	inc, err := newIncluder(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var doc gin.H
+	d, items, many, ok := resourcesOf(data)
+	switch {
+	case ok && !many && page == nil:
+		doc, err = halResource(d, items[0], inc)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if links := doc["_links"].(gin.H); links["self"] == nil {
+			links["self"] = gin.H{"href": c.Request.URL.RequestURI()}
+		}
+	default:
+		embedded := make([]interface{}, 0, len(items))
+		key := "items"
+		if ok {
+			key = d.typ
+			for _, item := range items {
+				obj, err := halResource(d, item, inc)
+				if err != nil {
+					respondError(c, err)
+					return
+				}
+				embedded = append(embedded, obj)
+			}
+		} else if attrs := attributesOf(data); attrs != nil && !many {
+			doc = gin.H(attrs)
+		}
+		if doc == nil {
+			doc = gin.H{}
+			if ok {
+				doc["_embedded"] = gin.H{key: embedded}
+			} else {
+				doc["_embedded"] = gin.H{key: data}
+			}
+		}
+		links := gin.H{"self": gin.H{"href": c.Request.URL.RequestURI()}}
+		if page != nil {
+			for k, v := range pageLinks(c, *page) {
+				if v != nil {
+					links[k] = gin.H{"href": v}
+				}
+			}
+			for k, v := range attributesOf(*page) {
+				doc[k] = v
+			}
+		}
+		doc["_links"] = links
+	}
+	if err := inc.check(len(items)); err != nil {
+		respondError(c, err)
+		return
+	}
+	for k, v := range extra {
+		doc[k] = v
+	}
+	c.Header("Content-Type", mediaTypeHAL)
+	c.JSON(status, doc)
+}
+
+// halResource renders one resource with links to its relationships.
+func halResource(d resourceDescriptor, v interface{}, inc *includer) (gin.H, error) {
+	doc := gin.H(attributesOf(v))
+	links := gin.H{}
+	if self := d.self(v); self != "" {
+		links["self"] = gin.H{"href": self}
+	}
+	embedded := gin.H{}
+	if d.relationships != nil {
+		for _, r := range d.relationships(v) {
+			if r.related != "" {
+				links[r.name] = gin.H{"href": r.related}
+			} else if target, ok := descriptorNamed(r.typ); ok && target.route != "" && len(r.ids) > 0 {
+				hrefs := make([]gin.H, len(r.ids))
+				for i, id := range r.ids {
+					hrefs[i] = gin.H{"href": linkTo(target.route, "id", id)}
+				}
+				if r.toMany {
+					links[r.name] = hrefs
+				} else {
+					links[r.name] = hrefs[0]
+				}
+			}
+
+			loaded, ok, err := inc.load(r)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			objs := make([]gin.H, 0, len(loaded))
+			for _, item := range loaded {
+				if target, found := descriptorFor(reflect.TypeOf(item)); found {
+					obj, err := halResource(target, item, &includer{})
+					if err != nil {
+						return nil, err
+					}
+					objs = append(objs, obj)
+				}
+			}
+			if r.toMany {
+				embedded[r.name] = objs
+			} else if len(objs) == 1 {
+				embedded[r.name] = objs[0]
+			}
+		}
+	}
+	doc["_links"] = links
+	if len(embedded) > 0 {
+		doc["_embedded"] = embedded
+	}
+	return doc, nil
+}
+
+// jsonAPIError maps an application error onto a JSON:API error document.
+func jsonAPIError(appErr domain.AppError) gin.H {
+	obj := gin.H{
+		"status": strconv.Itoa(appErr.Status),
+		"code":   appErr.Code,
+		"title":  appErr.Message,
+	}
+	if len(appErr.Details) > 0 {
+		obj["meta"] = appErr.Details
+	}
+	return gin.H{"jsonapi": gin.H{"version": "1.1"}, "errors": []gin.H{obj}}
+}
+
+// halError maps an application error onto a vnd.error document, the HAL
+// flavoured error format.
+func halError(c *gin.Context, appErr domain.AppError) gin.H {
+	body := gin.H{
+		"message": appErr.Message,
+		"logref":  appErr.Code,
+		"path":    c.Request.URL.Path,
+		"_links":  gin.H{"about": gin.H{"href": c.Request.URL.RequestURI()}},
+	}
+	if len(appErr.Details) > 0 {
+		body["details"] = appErr.Details
+	}
+	return body
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/mail"
+)
+
+// InboundHandlers receives e-mail posted by a relay and tells users their
+// inbound addresses.
+type InboundHandlers struct {
+	inbound  *domain.InboundMailService
+	secret   string
+	maxBytes int64
+}
+
+func (h *InboundHandlers) register(public, authed, admin *gin.RouterGroup) {
+	if h.secret != "" {
+		public.POST("/inbound/mime", h.Receive)
+	}
+	authed.GET("/me/inbound", h.Addresses)
+	admin.GET("/inbound/messages", h.List)
+}
+
+// Receive takes a MIME message as the raw request body, or as the
+// "email" (SendGrid) or "body-mime" (Mailgun) field of a multipart form. The
+// relay authenticates with the webhook secret as the password of basic
+// authentication or in the X-Inbound-Secret header. Envelope recipients
+// may be given in a "recipient" field; otherwise they are read from the
+// message headers.
+func (h *InboundHandlers) Receive(c *gin.Context) {
+	secret := c.GetHeader("X-Inbound-Secret")
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		secret = password
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(h.secret)) != 1 {
+		respondError(c, domain.ErrUnauthorized)
+		return
+	}
+	// Allow for form framing around the largest accepted message
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxBytes+1<<20)
+
+	var raw io.Reader = c.Request.Body
+	var recipients []string
+	if c.ContentType() == "multipart/form-data" {
+		if err := c.Request.ParseMultipartForm(h.maxBytes); err != nil {
+			respondError(c, domain.InvalidInput("malformed form body"))
+			return
+		}
+		body := c.Request.FormValue("email")
+		if body == "" {
+			body = c.Request.FormValue("body-mime")
+		}
+		if body == "" {
+			respondError(c, domain.InvalidInput("the form has no email or body-mime field"))
+			return
+		}
+		raw = strings.NewReader(body)
+		for _, r := range strings.Split(c.Request.FormValue("recipient"), ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				recipients = append(recipients, r)
+			}
+		}
+	}
+
+	content, err := io.ReadAll(raw)
+	if err != nil {
+		respondError(c, domain.InvalidInput("the message is larger than %d bytes", h.maxBytes))
+		return
+	}
+	msg, err := mail.Parse(bytes.NewReader(content))
+	if err != nil {
+		respondError(c, domain.InvalidInput("malformed message: %v", err))
+		return
+	}
+	if len(recipients) > 0 {
+		msg.Recipients = recipients
+	}
+	results, err := h.inbound.Receive(c.Request.Context(), msg)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, results)
+}
+
+// Addresses returns the caller's address for sending in files and, with
+// resource_type and resource_id, the address for replying to the
+// resource's discussion.
+func (h *InboundHandlers) Addresses(c *gin.Context) {
+	view, err := h.inbound.Addresses(identity(c), c.Query("resource_type"), c.Query("resource_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, view)
+}
+
+// List returns what became of the e-mail received for the tenant.
+func (h *InboundHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	messages, meta, err := h.inbound.List(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, messages, meta)
+}
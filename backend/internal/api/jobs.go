@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// JobAdminHandlers lets operators watch and steer the background job
+// queue.
+type JobAdminHandlers struct {
+	jobs *domain.JobAdminService
+}
+
+func (h *JobAdminHandlers) register(rg *gin.RouterGroup) {
+	rg.GET("/queues", h.Queues)
+	rg.POST("/queues/:queue/pause", h.Pause)
+	rg.POST("/queues/:queue/resume", h.Resume)
+	rg.POST("/queues/:queue/retry", h.RetryQueue)
+	rg.GET("/jobs", h.List)
+	rg.GET("/jobs/:id", h.Get)
+	rg.POST("/jobs/:id/retry", h.Retry)
+	rg.DELETE("/jobs/:id", h.Delete)
+	rg.GET("/scheduled", h.Scheduled)
+	rg.POST("/scheduled/:name/trigger", h.Trigger)
+}
+
+// Queues reports depth, latency and throughput per queue.
+func (h *JobAdminHandlers) Queues(c *gin.Context) {
+	stats, err := h.jobs.Queues(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, stats)
+}
+
+func (h *JobAdminHandlers) Pause(c *gin.Context) {
+	if err := h.jobs.Pause(c.Request.Context(), identity(c), c.Param("queue")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *JobAdminHandlers) Resume(c *gin.Context) {
+	if err := h.jobs.Resume(c.Request.Context(), identity(c), c.Param("queue")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RetryQueue revives every dead job of the queue.
+func (h *JobAdminHandlers) RetryQueue(c *gin.Context) {
+	n, err := h.jobs.RetryQueue(c.Request.Context(), identity(c), c.Param("queue"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, gin.H{"retried": n})
+}
+
+// List accepts queue, kind and status filters.
+func (h *JobAdminHandlers) List(c *gin.Context) {
+	var query struct {
+		domain.ListParams
+		domain.JobFilter
+	}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	jobs, meta, err := h.jobs.Jobs(c.Request.Context(), identity(c), query.JobFilter, query.ListParams)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, jobs, meta)
+}
+
+func (h *JobAdminHandlers) Get(c *gin.Context) {
+	job, err := h.jobs.Job(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, job)
+}
+
+func (h *JobAdminHandlers) Retry(c *gin.Context) {
+	job, err := h.jobs.Retry(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, job)
+}
+
+func (h *JobAdminHandlers) Delete(c *gin.Context) {
+	if err := h.jobs.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *JobAdminHandlers) Scheduled(c *gin.Context) {
+	tasks, err := h.jobs.ScheduledTasks(identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, tasks)
+}
+
+// Trigger enqueues a scheduled task now.
+func (h *JobAdminHandlers) Trigger(c *gin.Context) {
+	job, err := h.jobs.Trigger(c.Request.Context(), identity(c), c.Param("name"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusAccepted, job)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// ListingHandlers exposes the listing toolkit for ad-hoc queries.
+type ListingHandlers struct {
+	listing *domain.ListingService
+}
+
+func (h *ListingHandlers) register(rg *gin.RouterGroup) {
+	rg.GET("/lists", h.Schemas)
+	rg.GET("/lists/:source", h.Query)
+}
+
+func (h *ListingHandlers) Schemas(c *gin.Context) {
+	respondOK(c, http.StatusOK, h.listing.Schemas())
+}
+
+// Query runs a list query given as URL parameters, for example
+// /api/lists/activities?filter[verb]=comment.created&sort=-created_at.
+func (h *ListingHandlers) Query(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	q := domain.ParseListQuery(c.Request.URL.Query())
+	rows, meta, err := h.listing.Execute(c.Request.Context(), identity(c), c.Param("source"), q, params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, rows, meta)
+}
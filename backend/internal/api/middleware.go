@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const identityKey = "identity"
+
+// corsMiddleware allows the frontend to call the API from another origin.
+// Preflight requests are answered here unless a route handles OPTIONS
+// itself, like tus discovery.
+func corsMiddleware(origin string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Canary, "+tusRequestHeader)
+		c.Header("Access-Control-Expose-Headers", tusExposeHeaders)
+		if origin != "*" {
+			c.Header("Vary", "Origin")
+		}
+
+		if c.Request.Method == "OPTIONS" && c.FullPath() == "" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// authMiddleware resolves the caller from the bearer token, if any, and
+// keeps the user directory in sync. Requests without a token continue
+// anonymously; requireAuth rejects them where needed.
+//
+// EventSource cannot set headers, so the token may also be passed in the
+// access_token query parameter.
+func authMiddleware(signer *domain.TokenSigner, users *domain.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		// Basic credentials, such as a mail relay's, are for the handler
+		// that expects them
+		if len(token) > 6 && strings.EqualFold(token[:6], "basic ") {
+			token = ""
+		}
+		if token == "" {
+			token = c.Query("access_token")
+		}
+		if token == "" {
+			// Anonymous requests, such as calendar feeds, name their tenant
+			if tenantID := c.Query("tenant"); tenantID != "" {
+				c.Request = c.Request.WithContext(domain.WithTenant(c.Request.Context(), tenantID))
+			}
+			c.Next()
+			return
+		}
+
+		id, err := signer.Verify(token)
+		if err != nil {
+			respondError(c, domain.ErrUnauthorized.WithDetails(map[string]interface{}{"reason": "invalid or expired token"}))
+			return
+		}
+		// Queries for the request go to the tenant's database
+		c.Request = c.Request.WithContext(domain.WithTenant(c.Request.Context(), id.TenantID))
+		if err := users.Sync(c.Request.Context(), id); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Set(identityKey, id)
+		c.Next()
+	}
+}
+
+// requireAuth rejects anonymous requests.
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := currentIdentity(c); !ok {
+			respondError(c, domain.ErrUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireRole rejects callers that lack the given role.
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := currentIdentity(c)
+		if !ok {
+			respondError(c, domain.ErrUnauthorized)
+			return
+		}
+		if !id.HasRole(role) {
+			respondError(c, domain.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requirePolicies rejects callers who must accept the current version of
+// a policy before continuing, with POLICY_ACCEPTANCE_REQUIRED.
+func requirePolicies(policies *domain.PolicyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := policies.CheckAccepted(c.Request.Context(), identity(c)); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentIdentity returns the authenticated caller.
+func currentIdentity(c *gin.Context) (domain.Identity, bool) {
+	v, ok := c.Get(identityKey)
+	if !ok {
+		return domain.Identity{}, false
+	}
+	id, ok := v.(domain.Identity)
+	return id, ok
+}
+
+// identity returns the authenticated caller of a route guarded by
+// requireAuth.
+func identity(c *gin.Context) domain.Identity {
+	id, _ := currentIdentity(c)
+	return id
+}
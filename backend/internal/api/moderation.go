@@ -0,0 +1,264 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// ModerationHandlers exposes abuse reports, the moderation queue, auto-flag
+// rules and appeals.
+type ModerationHandlers struct {
+	moderation *domain.ModerationService
+}
+
+func (h *ModerationHandlers) register(rg *gin.RouterGroup) {
+	rg.POST("/resources/:type/:id/reports", h.Report)
+
+	getNamed(rg, "moderation.cases", "/moderation/cases", h.Cases)
+	getNamed(rg, "moderation.case", "/moderation/cases/:id", h.Case)
+	rg.POST("/moderation/cases/:id/assign", h.Assign)
+	rg.POST("/moderation/cases/:id/decision", h.Decide)
+
+	getNamed(rg, "moderation.rules", "/moderation/rules", h.Rules)
+	rg.POST("/moderation/rules", h.CreateRule)
+	rg.POST("/moderation/rules/test", h.TestRules)
+	rg.PATCH("/moderation/rules/:id", h.UpdateRule)
+	rg.DELETE("/moderation/rules/:id", h.DeleteRule)
+
+	getNamed(rg, "moderation.standing", "/moderation/users/:id", h.Standing)
+	rg.DELETE("/moderation/users/:id/ban", h.LiftBan)
+
+	getNamed(rg, "moderation.appeals", "/moderation/appeals", h.Appeals)
+	rg.POST("/moderation/appeals/:id/decision", h.DecideAppeal)
+
+	getNamed(rg, "moderation.me", "/me/moderation", h.MyStanding)
+	getNamed(rg, "moderation.my_appeals", "/me/moderation/appeals", h.MyAppeals)
+	rg.POST("/me/moderation/appeals", h.Appeal)
+}
+
+// Report flags a resource for moderators; reporting it again is a
+// conflict.
+func (h *ModerationHandlers) Report(c *gin.Context) {
+	var in domain.ModerationReportInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	report, err := h.moderation.Report(c.Request.Context(), identity(c), c.Param("type"), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, report)
+}
+
+func (h *ModerationHandlers) Cases(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	var f domain.ModerationCaseFilter
+	if err := c.ShouldBindQuery(&f); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	cases, meta, err := h.moderation.Cases(c.Request.Context(), identity(c), f, params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, cases, meta)
+}
+
+// Case returns a case with its reports, appeal and history.
+func (h *ModerationHandlers) Case(c *gin.Context) {
+	detail, err := h.moderation.Case(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, detail)
+}
+
+func (h *ModerationHandlers) Assign(c *gin.Context) {
+	var in domain.ModerationAssignInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			respondInvalidInput(c, err)
+			return
+		}
+	}
+	mc, err := h.moderation.Assign(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, mc)
+}
+
+func (h *ModerationHandlers) Decide(c *gin.Context) {
+	var in domain.ModerationDecisionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	mc, err := h.moderation.Decide(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, mc)
+}
+
+func (h *ModerationHandlers) Rules(c *gin.Context) {
+	rules, err := h.moderation.Rules(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, rules)
+}
+
+func (h *ModerationHandlers) CreateRule(c *gin.Context) {
+	var in domain.ModerationRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	rule, err := h.moderation.CreateRule(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, rule)
+}
+
+func (h *ModerationHandlers) UpdateRule(c *gin.Context) {
+	var in domain.ModerationRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	rule, err := h.moderation.UpdateRule(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, rule)
+}
+
+func (h *ModerationHandlers) DeleteRule(c *gin.Context) {
+	if err := h.moderation.DeleteRule(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TestRules returns the enabled rules a sample text would trip.
+func (h *ModerationHandlers) TestRules(c *gin.Context) {
+	var in domain.ModerationRuleTestInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	rules, err := h.moderation.TestRules(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, rules)
+}
+
+func (h *ModerationHandlers) Standing(c *gin.Context) {
+	standing, err := h.moderation.Standing(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, standing)
+}
+
+func (h *ModerationHandlers) LiftBan(c *gin.Context) {
+	if err := h.moderation.LiftBan(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ModerationHandlers) Appeals(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	var f domain.ModerationAppealFilter
+	if err := c.ShouldBindQuery(&f); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	appeals, meta, err := h.moderation.Appeals(c.Request.Context(), identity(c), f, params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, appeals, meta)
+}
+
+func (h *ModerationHandlers) DecideAppeal(c *gin.Context) {
+	var in domain.ModerationAppealDecisionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	appeal, err := h.moderation.DecideAppeal(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, appeal)
+}
+
+// MyStanding returns the caller's strikes and ban.
+func (h *ModerationHandlers) MyStanding(c *gin.Context) {
+	actor := identity(c)
+	standing, err := h.moderation.Standing(c.Request.Context(), actor, actor.UserID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, standing)
+}
+
+func (h *ModerationHandlers) MyAppeals(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	appeals, meta, err := h.moderation.MyAppeals(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, appeals, meta)
+}
+
+func (h *ModerationHandlers) Appeal(c *gin.Context) {
+	var in domain.ModerationAppealInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	appeal, err := h.moderation.Appeal(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, appeal)
+}
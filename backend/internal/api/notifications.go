@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// NotificationHandlers serves the caller's notification inbox.
+type NotificationHandlers struct {
+	notifications *domain.NotificationService
+}
+
+type listNotificationsQuery struct {
+	domain.ListParams
+	Unread bool `form:"unread"`
+}
+
+func (h *NotificationHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "notifications", "/me/notifications", h.List)
+	rg.POST("/me/notifications/:id/read", h.MarkRead)
+}
+
+func (h *NotificationHandlers) List(c *gin.Context) {
+	var q listNotificationsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	items, meta, err := h.notifications.List(c.Request.Context(), identity(c), q.Unread, q.ListParams)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, items, meta)
+}
+
+func (h *NotificationHandlers) MarkRead(c *gin.Context) {
+	if err := h.notifications.MarkRead(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// PolicyHandlers serves versioned policies, such as the terms of service,
+// their acceptance and their administration. The routes are exempt from
+// requirePolicies, so callers held up by it can accept.
+type PolicyHandlers struct {
+	policies *domain.PolicyService
+}
+
+func (h *PolicyHandlers) register(rg, admin *gin.RouterGroup) {
+	getNamed(rg, "policies", "/policies", h.Current)
+	getNamed(rg, "policy", "/policies/:id", h.Get)
+	rg.POST("/policies/:id/accept", h.Accept)
+	getNamed(rg, "policies.my_acceptances", "/me/policies", h.MyAcceptances)
+
+	getNamed(admin, "admin.policies", "/policies", h.List)
+	getNamed(admin, "admin.policies.report", "/policies/report", h.Report)
+	admin.POST("/policies", h.Create)
+	admin.PATCH("/policies/:id", h.Update)
+	admin.DELETE("/policies/:id", h.Delete)
+	admin.POST("/policies/:id/publish", h.Publish)
+	getNamed(admin, "admin.policy.acceptances", "/policies/:id/acceptances", h.Acceptances)
+}
+
+// Current lists each kind of policy with the version in effect and
+// whether the caller must accept it.
+func (h *PolicyHandlers) Current(c *gin.Context) {
+	statuses, err := h.policies.Current(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, statuses)
+}
+
+func (h *PolicyHandlers) Get(c *gin.Context) {
+	p, err := h.policies.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, p)
+}
+
+// Accept records the caller's acceptance with the client's address and
+// user agent.
+func (h *PolicyHandlers) Accept(c *gin.Context) {
+	a, err := h.policies.Accept(c.Request.Context(), identity(c), c.Param("id"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, a)
+}
+
+func (h *PolicyHandlers) MyAcceptances(c *gin.Context) {
+	items, err := h.policies.Acceptances(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, items)
+}
+
+func (h *PolicyHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	var f domain.PolicyFilter
+	if err := c.ShouldBindQuery(&f); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	items, meta, err := h.policies.List(c.Request.Context(), identity(c), f, params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, items, meta)
+}
+
+func (h *PolicyHandlers) Create(c *gin.Context) {
+	var in domain.PolicyVersionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	p, err := h.policies.Create(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, p)
+}
+
+func (h *PolicyHandlers) Update(c *gin.Context) {
+	var in domain.PolicyVersionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	p, err := h.policies.Update(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, p)
+}
+
+func (h *PolicyHandlers) Delete(c *gin.Context) {
+	if err := h.policies.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Publish makes a draft final, in effect now or from effective_at.
+func (h *PolicyHandlers) Publish(c *gin.Context) {
+	var in domain.PolicyPublishInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			respondInvalidInput(c, err)
+			return
+		}
+	}
+	p, err := h.policies.Publish(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, p)
+}
+
+func (h *PolicyHandlers) Acceptances(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	items, meta, err := h.policies.VersionAcceptances(c.Request.Context(), identity(c), c.Param("id"), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, items, meta)
+}
+
+// Report returns acceptance rates by kind and version.
+func (h *PolicyHandlers) Report(c *gin.Context) {
+	report, err := h.policies.Report(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, report)
+}
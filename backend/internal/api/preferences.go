@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// PreferenceHandlers serves user preferences and tenant defaults.
+type PreferenceHandlers struct {
+	preferences *domain.PreferenceService
+}
+
+func (h *PreferenceHandlers) register(rg, admin *gin.RouterGroup) {
+	rg.GET("/preferences/schema", h.Schema)
+	rg.GET("/me/preferences", h.Get)
+	rg.PATCH("/me/preferences", h.Update)
+	admin.GET("/preferences/defaults", h.GetDefaults)
+	admin.PATCH("/preferences/defaults", h.UpdateDefaults)
+}
+
+func (h *PreferenceHandlers) Schema(c *gin.Context) {
+	respondOK(c, http.StatusOK, h.preferences.Schema())
+}
+
+func (h *PreferenceHandlers) Get(c *gin.Context) {
+	values, err := h.preferences.ForUser(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, values)
+}
+
+// Update applies a partial update: only the keys present in the body
+// change, and a null value resets a key to its default.
+func (h *PreferenceHandlers) Update(c *gin.Context) {
+	var patch map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	values, err := h.preferences.UpdateForUser(c.Request.Context(), identity(c), patch)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, values)
+}
+
+func (h *PreferenceHandlers) GetDefaults(c *gin.Context) {
+	values, err := h.preferences.TenantDefaults(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, values)
+}
+
+func (h *PreferenceHandlers) UpdateDefaults(c *gin.Context) {
+	var patch map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	values, err := h.preferences.UpdateTenantDefaults(c.Request.Context(), identity(c), patch)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, values)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// ProjectionHandlers reports how far the read models trail the event log
+// of the caller's database. Rebuilds are started with the projections
+// command.
+type ProjectionHandlers struct {
+	projections *domain.ProjectionService
+}
+
+func (h *ProjectionHandlers) register(admin *gin.RouterGroup) {
+	admin.GET("/projections", h.List)
+	admin.GET("/projections/:name", h.Get)
+}
+
+func (h *ProjectionHandlers) List(c *gin.Context) {
+	statuses, err := h.projections.Statuses(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, statuses)
+}
+
+func (h *ProjectionHandlers) Get(c *gin.Context) {
+	status, err := h.projections.Status(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, status)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// PushHandlers manages the caller's Web Push subscriptions.
+type PushHandlers struct {
+	push *domain.PushService
+}
+
+func (h *PushHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "push.key", "/push/key", h.Key)
+	getNamed(rg, "push.subscriptions", "/me/push/subscriptions", h.List)
+	rg.POST("/me/push/subscriptions", h.Subscribe)
+	rg.DELETE("/me/push/subscriptions/:id", h.Unsubscribe)
+	rg.POST("/me/push/test", h.Test)
+}
+
+// Key returns the VAPID public key to pass to PushManager.subscribe as
+// applicationServerKey.
+func (h *PushHandlers) Key(c *gin.Context) {
+	respondOK(c, http.StatusOK, gin.H{"public_key": h.push.PublicKey()})
+}
+
+func (h *PushHandlers) List(c *gin.Context) {
+	subs, err := h.push.List(c.Request.Context(), identity(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, subs)
+}
+
+// Subscribe registers the JSON of a browser PushSubscription.
+func (h *PushHandlers) Subscribe(c *gin.Context) {
+	var in domain.PushSubscriptionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	sub, err := h.push.Subscribe(c.Request.Context(), identity(c), in, c.Request.UserAgent())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, sub)
+}
+
+func (h *PushHandlers) Unsubscribe(c *gin.Context) {
+	if err := h.push.Unsubscribe(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Test sends a test message to each of the caller's devices.
+func (h *PushHandlers) Test(c *gin.Context) {
+	var in domain.PushTestInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			respondInvalidInput(c, err)
+			return
+		}
+	}
+	queued, err := h.push.SendTest(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusAccepted, gin.H{"queued": queued})
+}
@@ -0,0 +1,53 @@
+package api
+
+import "greact-bones/backend/internal/domain"
+
+// Resource descriptors for the JSON:API and HAL profiles. Types without a
+// descriptor are rendered as plain values.
+func init() {
+	describe("users", "user",
+		func(u domain.User) string { return u.ID },
+		nil)
+
+	describe("comments", "comment",
+		func(c domain.Comment) string { return c.ID },
+		func(c domain.Comment) []relationship {
+			return []relationship{
+				toOne("author", "users", "author_id", c.AuthorID),
+				toOne("thread", "comments", "thread_id", c.ThreadID),
+				toOne("parent", "comments", "parent_id", c.ParentID),
+				toMany("replies", "comments", linkTo("comment.replies", "id", c.ID)),
+				toMany("history", "comment_revisions", linkTo("comment.history", "id", c.ID)),
+			}
+		})
+
+	describe("comment_revisions", "",
+		func(r domain.CommentRevision) string { return r.ID },
+		func(r domain.CommentRevision) []relationship {
+			return []relationship{
+				toOne("comment", "comments", "comment_id", r.CommentID),
+				toOne("editor", "users", "editor_id", r.EditorID),
+			}
+		})
+
+	describe("activities", "",
+		func(a domain.Activity) string { return a.ID },
+		func(a domain.Activity) []relationship {
+			return []relationship{toOne("actor", "users", "actor_id", a.ActorID)}
+		})
+
+	describe("notifications", "",
+		func(n domain.Notification) string { return n.ID },
+		func(n domain.Notification) []relationship {
+			return []relationship{toOne("actor", "users", "actor_id", n.ActorID)}
+		})
+
+	describe("views", "view",
+		func(v domain.SavedView) string { return v.ID },
+		func(v domain.SavedView) []relationship {
+			return []relationship{
+				toOne("owner", "users", "owner_id", v.OwnerID),
+				toMany("results", "rows", linkTo("view.results", "id", v.ID)),
+			}
+		})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// respondOK writes a success envelope, or the JSON:API or HAL document the
+// client asked for.
+func respondOK(c *gin.Context, status int, data interface{}) {
+	c.Writer.Header().Add("Vary", "Accept")
+	switch negotiateProfile(c) {
+	case profileJSONAPI:
+		renderJSONAPI(c, status, data, nil, nil)
+	case profileHAL:
+		renderHAL(c, status, data, nil, nil)
+	default:
+		c.JSON(status, gin.H{
+			"status": "success",
+			"data":   data,
+		})
+	}
+}
+
+// respondList writes a success envelope for a page of results.
+func respondList(c *gin.Context, data interface{}, meta domain.PaginationMeta) {
+	c.Writer.Header().Add("Vary", "Accept")
+	switch negotiateProfile(c) {
+	case profileJSONAPI:
+		renderJSONAPI(c, http.StatusOK, data, &meta, nil)
+	case profileHAL:
+		renderHAL(c, http.StatusOK, data, &meta, nil)
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   data,
+			"meta":   meta,
+		})
+	}
+}
+
+// respondListMeta writes a page of results with additional meta members.
+// The envelope nests the pagination under meta.pagination.
+func respondListMeta(c *gin.Context, data interface{}, meta domain.PaginationMeta, extra gin.H) {
+	c.Writer.Header().Add("Vary", "Accept")
+	switch negotiateProfile(c) {
+	case profileJSONAPI:
+		renderJSONAPI(c, http.StatusOK, data, &meta, extra)
+	case profileHAL:
+		renderHAL(c, http.StatusOK, data, &meta, extra)
+	default:
+		envelopeMeta := gin.H{"pagination": meta}
+		for k, v := range extra {
+			envelopeMeta[k] = v
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"data":   data,
+			"meta":   envelopeMeta,
+		})
+	}
+}
+
+// respondError writes the error envelope for err. Application errors keep
+// their code and status; anything else is logged and reported as a 500.
+func respondError(c *gin.Context, err error) {
+	var appErr domain.AppError
+	if !errors.As(err, &appErr) {
+		log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		appErr = domain.AppError{
+			Status:  http.StatusInternalServerError,
+			Code:    "INTERNAL_ERROR",
+			Message: "Internal server error",
+		}
+	}
+	c.Writer.Header().Add("Vary", "Accept")
+	switch negotiateProfile(c) {
+	case profileJSONAPI:
+		c.Header("Content-Type", mediaTypeJSONAPI)
+		c.AbortWithStatusJSON(appErr.Status, jsonAPIError(appErr))
+		return
+	case profileHAL:
+		c.Header("Content-Type", mediaTypeVndError)
+		c.AbortWithStatusJSON(appErr.Status, halError(c, appErr))
+		return
+	}
+	body := gin.H{
+		"status":  "error",
+		"code":    appErr.Code,
+		"message": appErr.Message,
+	}
+	if len(appErr.Details) > 0 {
+		body["details"] = appErr.Details
+	}
+	c.AbortWithStatusJSON(appErr.Status, body)
+}
+
+// respondInvalidInput reports a request that failed binding or validation.
+func respondInvalidInput(c *gin.Context, err error) {
+	respondError(c, domain.InvalidInput("%s", err.Error()))
+}
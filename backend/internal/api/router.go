@@ -0,0 +1,167 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/config"
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/realtime"
+)
+
+// Services bundles everything the HTTP layer depends on.
+type Services struct {
+	Config        *config.Config
+	Tokens        *domain.TokenSigner
+	Users         *domain.UserService
+	Comments      *domain.CommentService
+	Activity      *domain.ActivityService
+	Notifications *domain.NotificationService
+	Preferences   *domain.PreferenceService
+	Listing       *domain.ListingService
+	SavedViews    *domain.SavedViewService
+	Admin         *domain.AdminService
+	Audit         *domain.AuditService
+	Jobs          *domain.JobAdminService
+	Schemas       *domain.MessageSchemaRegistry
+	Collab        *domain.CollabService
+	Calendar      *domain.CalendarService
+	Files         *domain.FileService
+	Uploads       *domain.UploadService
+	Push          *domain.PushService
+	Moderation    *domain.ModerationService
+	Projections   *domain.ProjectionService
+	Inbound       *domain.InboundMailService
+	Documents     *domain.DocumentService
+	Policies      *domain.PolicyService
+	Hub           *realtime.Hub
+	CollabServer  *realtime.CollabServer
+	// Variants are alternate versions of routes, for canaries and shadows
+	Variants []RouteVariant
+}
+
+// NewRouter builds the Gin engine with all routes registered.
+func NewRouter(s Services) *gin.Engine {
+	// Create a Gin router with default middleware
+	router := gin.Default()
+	// Client addresses come from X-Forwarded-For only behind a trusted proxy
+	if err := router.SetTrustedProxies(trustedProxies(s.Config.TrustedProxies)); err != nil {
+		log.Printf("Ignoring TRUSTED_PROXIES: %v", err)
+		router.SetTrustedProxies(nil)
+	}
+
+	// Add CORS middleware for frontend communication
+	router.Use(corsMiddleware(s.Config.FrontendOrigin))
+
+	// Basic health check endpoint
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "ok",
+			"message": "Greact-Bones API is running!",
+		})
+	})
+
+	// API route group
+	api := router.Group("/api")
+	api.Use(authMiddleware(s.Tokens, s.Users))
+
+	// Mirror and split traffic of individual routes; see TrafficHandlers.
+	// Canary versions are dispatched after each group's guards.
+	traffic := newTrafficRouter(append(strings.Split(s.Config.ShadowTrustedUpstreams, ","), s.Config.ShadowUpstream))
+	traffic.engine = router
+	for _, v := range s.Variants {
+		traffic.version(v.Route, v.Version, v.Handler)
+	}
+	if s.Config.ShadowUpstream != "" {
+		for _, route := range shadowRoutes(s.Config.ShadowRoutes) {
+			traffic.setShadow(ShadowRule{Route: route, SampleRate: s.Config.ShadowSampleRate, Upstream: s.Config.ShadowUpstream})
+		}
+	}
+	api.Use(traffic.middleware())
+	public := api.Group("", traffic.dispatch())
+	{
+		public.GET("/hello", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "Hello from Greact-Bones backend!",
+				"version": "1.0.0",
+			})
+		})
+		(&SchemaHandlers{schemas: s.Schemas, appURL: s.Config.AppURL}).register(public)
+		(&CalendarHandlers{calendar: s.Calendar}).registerPublic(public)
+	}
+
+	// Routes below require an authenticated caller who accepted the
+	// current policies, except the policy routes, which let them accept
+	signedIn := api.Group("", requireAuth())
+	(&PolicyHandlers{policies: s.Policies}).register(signedIn.Group("", traffic.dispatch()),
+		signedIn.Group("/admin", requireRole("admin"), traffic.dispatch()))
+	accepted := signedIn.Group("", requirePolicies(s.Policies))
+	authed := accepted.Group("", traffic.dispatch())
+	admin := accepted.Group("/admin", requireRole("admin"), traffic.dispatch())
+	// Settings of the whole process, across tenants
+	operator := accepted.Group("/admin", requireRole("operator"), traffic.dispatch())
+	(&UserHandlers{users: s.Users}).register(authed)
+	(&CommentHandlers{comments: s.Comments}).register(authed)
+	(&ActivityHandlers{activity: s.Activity}).register(authed)
+	(&NotificationHandlers{notifications: s.Notifications}).register(authed)
+	(&StreamHandlers{hub: s.Hub}).register(authed)
+	(&PreferenceHandlers{preferences: s.Preferences}).register(authed, admin)
+	(&ListingHandlers{listing: s.Listing}).register(authed)
+	(&SavedViewHandlers{views: s.SavedViews}).register(authed)
+	newCollabHandlers(s.Collab, s.CollabServer, s.Config.FrontendOrigin).register(authed)
+	(&CalendarHandlers{calendar: s.Calendar}).register(authed)
+	(&FileHandlers{files: s.Files}).register(authed, admin)
+	(&UploadHandlers{uploads: s.Uploads}).register(public, authed)
+	(&PushHandlers{push: s.Push}).register(authed)
+	(&ModerationHandlers{moderation: s.Moderation}).register(authed)
+	(&TrafficHandlers{traffic: traffic, audit: s.Audit}).register(operator)
+	(&ProjectionHandlers{projections: s.Projections}).register(admin)
+	(&DocumentHandlers{documents: s.Documents}).register(public, authed)
+	if s.Inbound != nil {
+		inbound := &InboundHandlers{inbound: s.Inbound, secret: s.Config.InboundSecret, maxBytes: int64(s.Config.InboundMaxBytes)}
+		inbound.register(public, authed, admin)
+	}
+
+	// Generic admin API; each resource declares which roles may use it
+	adminAPI := router.Group("/admin/api", authMiddleware(s.Tokens, s.Users), requireAuth(), requirePolicies(s.Policies))
+	(&AdminHandlers{admin: s.Admin, audit: s.Audit}).register(adminAPI)
+
+	// Job queue administration, across tenants
+	jobsAdmin := router.Group("/admin/jobs", authMiddleware(s.Tokens, s.Users), requireAuth(), requirePolicies(s.Policies),
+		requireRole("operator"))
+	(&JobAdminHandlers{jobs: s.Jobs}).register(jobsAdmin)
+
+	// Serve the built frontend, if present, for every other path
+	if _, err := os.Stat(filepath.Join(s.Config.FrontendDist, "index.html")); err == nil {
+		router.NoRoute((&spaServer{dist: s.Config.FrontendDist, engine: router}).serve)
+	} else {
+		log.Printf("Frontend build not found in %s, serving the API only", s.Config.FrontendDist)
+	}
+
+	// Resources clients may pull into JSON:API and HAL documents with
+	// ?include=
+	loadResources("users", func(c *gin.Context, id string) (interface{}, error) {
+		return s.Users.Get(c.Request.Context(), identity(c).TenantID, id)
+	})
+	loadResources("comments", func(c *gin.Context, id string) (interface{}, error) {
+		return s.Comments.Get(c.Request.Context(), identity(c), id)
+	})
+
+	return router
+}
+
+// trustedProxies splits a comma-separated list of addresses and ranges.
+func trustedProxies(list string) []string {
+	var proxies []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
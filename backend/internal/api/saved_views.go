@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// SavedViewHandlers serves saved views and their scheduled delivery.
+type SavedViewHandlers struct {
+	views *domain.SavedViewService
+}
+
+type viewScheduleRequest struct {
+	Frequency string `json:"frequency" binding:"required,oneof=daily weekly"`
+	Hour      int    `json:"hour" binding:"min=0,max=23"`
+	Weekday   int    `json:"weekday" binding:"min=0,max=6"`
+}
+
+func (h *SavedViewHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "views", "/views", h.List)
+	rg.POST("/views", h.Create)
+	getNamed(rg, "view", "/views/:id", h.Get)
+	rg.PATCH("/views/:id", h.Update)
+	rg.DELETE("/views/:id", h.Delete)
+	getNamed(rg, "view.results", "/views/:id/results", h.Execute)
+	rg.PUT("/views/:id/schedule", h.SetSchedule)
+	rg.DELETE("/views/:id/schedule", h.ClearSchedule)
+}
+
+func (h *SavedViewHandlers) List(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	views, meta, err := h.views.List(c.Request.Context(), identity(c), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondList(c, views, meta)
+}
+
+func (h *SavedViewHandlers) Create(c *gin.Context) {
+	var in domain.SavedViewInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	view, err := h.views.Create(c.Request.Context(), identity(c), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusCreated, view)
+}
+
+func (h *SavedViewHandlers) Get(c *gin.Context) {
+	view, err := h.views.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, view)
+}
+
+func (h *SavedViewHandlers) Update(c *gin.Context) {
+	var in domain.SavedViewInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	view, err := h.views.Update(c.Request.Context(), identity(c), c.Param("id"), in)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, view)
+}
+
+func (h *SavedViewHandlers) Delete(c *gin.Context) {
+	if err := h.views.Delete(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Execute runs the view and returns a page of its results along with the
+// columns to display.
+func (h *SavedViewHandlers) Execute(c *gin.Context) {
+	var params domain.ListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	rows, meta, view, err := h.views.Execute(c.Request.Context(), identity(c), c.Param("id"), params)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondListMeta(c, rows, meta, gin.H{
+		"columns": view.Query.Columns,
+		"view":    view,
+	})
+}
+
+func (h *SavedViewHandlers) SetSchedule(c *gin.Context) {
+	var req viewScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	view, err := h.views.SetSchedule(c.Request.Context(), identity(c), c.Param("id"), domain.ViewSchedule{
+		Frequency: req.Frequency,
+		Hour:      req.Hour,
+		Weekday:   req.Weekday,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, view)
+}
+
+func (h *SavedViewHandlers) ClearSchedule(c *gin.Context) {
+	if err := h.views.ClearSchedule(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	// tokenCookie carries the bearer token for page loads, which cannot
+	// set an Authorization header. It is only honoured for preloading;
+	// API requests still need the header.
+	tokenCookie = "access_token"
+
+	preloadTimeout = 2 * time.Second
+	preloadStateID = "__REACT_QUERY_STATE__"
+)
+
+// preloader names the API queries a frontend route needs on first paint.
+// Pattern uses the router's :param syntax; Queries returns GET paths under
+// /api, which become the React Query keys ["api", path].
+type preloader struct {
+	Pattern string
+	Queries func(params map[string]string, query url.Values) []string
+}
+
+// preloaders are matched against the frontend path in order; every match
+// contributes its queries.
+var preloaders = []preloader{
+	{Pattern: "*", Queries: func(map[string]string, url.Values) []string {
+		return []string{"/api/me/preferences"}
+	}},
+	{Pattern: "/views", Queries: func(map[string]string, url.Values) []string {
+		return []string{"/api/views"}
+	}},
+	{Pattern: "/views/:id", Queries: func(p map[string]string, q url.Values) []string {
+		results := "/api/views/" + url.PathEscape(p["id"]) + "/results"
+		if page := q.Get("page"); page != "" {
+			results += "?" + url.Values{"page": {page}}.Encode()
+		}
+		return []string{"/api/views/" + url.PathEscape(p["id"]), results}
+	}},
+	{Pattern: "/notifications", Queries: func(map[string]string, url.Values) []string {
+		return []string{"/api/me/notifications"}
+	}},
+	{Pattern: "/feed", Queries: func(map[string]string, url.Values) []string {
+		return []string{"/api/me/feed"}
+	}},
+}
+
+// matchRoute matches a path against a pattern with :param segments.
+func matchRoute(pattern, path string) (map[string]string, bool) {
+	if pattern == "*" {
+		return map[string]string{}, true
+	}
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, seg := range want {
+		if name, ok := strings.CutPrefix(seg, ":"); ok {
+			value, err := url.PathUnescape(got[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[name] = value
+		} else if seg != got[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// spaServer serves the built frontend. Unknown paths get index.html with
+// the data of the route's preloaders embedded as dehydrated React Query
+// state, so the first paint does not wait for API round-trips.
+type spaServer struct {
+	dist   string
+	engine http.Handler
+}
+
+func (s *spaServer) serve(c *gin.Context) {
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/admin/api/") || strings.HasPrefix(path, "/admin/jobs/") {
+		respondError(c, domain.NotFound("Route"))
+		return
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	// Built assets are served as they are
+	file := filepath.Join(s.dist, filepath.FromSlash(filepath.Clean("/"+path)))
+	if info, err := os.Stat(file); err == nil && !info.IsDir() {
+		c.File(file)
+		return
+	}
+
+	index, err := os.ReadFile(filepath.Join(s.dist, "index.html"))
+	if err != nil {
+		log.Printf("spa: %v", err)
+		c.String(http.StatusNotFound, "frontend not built")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Vary", "Cookie")
+	if state := s.preload(c); state != nil {
+		index = injectState(index, state)
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+}
+
+// preload runs the queries of the matching preloaders as the caller and
+// returns the dehydrated state, or nil when there is nothing to embed.
+// Queries that fail or time out are left out; the client fetches them.
+func (s *spaServer) preload(c *gin.Context) []byte {
+	token, err := c.Cookie(tokenCookie)
+	if err != nil || token == "" {
+		return nil
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, p := range preloaders {
+		params, ok := matchRoute(p.Pattern, c.Request.URL.Path)
+		if !ok {
+			continue
+		}
+		for _, path := range p.Queries(params, c.Request.URL.Query()) {
+			if strings.HasPrefix(path, "/api/") && !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), preloadTimeout)
+	defer cancel()
+	results := make([]json.RawMessage, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			results[i] = s.query(ctx, token, path)
+		}(i, path)
+	}
+	wg.Wait()
+
+	now := time.Now().UnixMilli()
+	queries := []dehydratedQuery{}
+	for i, body := range results {
+		if body == nil {
+			continue
+		}
+		key := []string{"api", paths[i]}
+		queries = append(queries, dehydratedQuery{
+			QueryKey:  key,
+			QueryHash: queryHash(key),
+			State: dehydratedQueryState{
+				Data:            body,
+				DataUpdateCount: 1,
+				DataUpdatedAt:   now,
+				Status:          "success",
+				FetchStatus:     "idle",
+			},
+		})
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+	state, err := json.Marshal(dehydratedState{Mutations: []interface{}{}, Queries: queries})
+	if err != nil {
+		log.Printf("spa: encode preloaded state: %v", err)
+		return nil
+	}
+	return state
+}
+
+// query performs an in-process GET through the router so the preloaded
+// data is exactly what the client would fetch, with the same auth checks.
+func (s *spaServer) query(ctx context.Context, token, path string) json.RawMessage {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		return nil
+	}
+	return rec.Body.Bytes()
+}
+
+// dehydratedState mirrors the output of React Query's dehydrate().
+type dehydratedState struct {
+	Mutations []interface{}     `json:"mutations"`
+	Queries   []dehydratedQuery `json:"queries"`
+}
+
+type dehydratedQuery struct {
+	QueryKey  []string             `json:"queryKey"`
+	QueryHash string               `json:"queryHash"`
+	State     dehydratedQueryState `json:"state"`
+}
+
+type dehydratedQueryState struct {
+	Data               json.RawMessage `json:"data"`
+	DataUpdateCount    int             `json:"dataUpdateCount"`
+	DataUpdatedAt      int64           `json:"dataUpdatedAt"`
+	Error              interface{}     `json:"error"`
+	ErrorUpdateCount   int             `json:"errorUpdateCount"`
+	ErrorUpdatedAt     int64           `json:"errorUpdatedAt"`
+	FetchFailureCount  int             `json:"fetchFailureCount"`
+	FetchFailureReason interface{}     `json:"fetchFailureReason"`
+	FetchMeta          interface{}     `json:"fetchMeta"`
+	IsInvalidated      bool            `json:"isInvalidated"`
+	Status             string          `json:"status"`
+	FetchStatus        string          `json:"fetchStatus"`
+}
+
+// queryHash matches React Query's default key hash, JSON.stringify of the
+// key, which does not escape HTML characters.
+func queryHash(key []string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(key)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// injectState embeds the state in a JSON script element before </head>.
+// encoding/json escapes <, > and & (and U+2028/U+2029), so the payload can
+// neither close the element nor start markup, whatever the API returned.
+func injectState(index, state []byte) []byte {
+	tag := []byte(`<script type="application/json" id="` + preloadStateID + `">` + string(state) + `</script>`)
+	at := bytes.Index(index, []byte("</head>"))
+	if at < 0 {
+		at = bytes.Index(index, []byte("</body>"))
+	}
+	if at < 0 {
+		return append(tag, index...)
+	}
+	out := make([]byte, 0, len(index)+len(tag))
+	out = append(out, index[:at]...)
+	out = append(out, tag...)
+	return append(out, index[at:]...)
+}
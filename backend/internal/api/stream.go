@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/realtime"
+)
+
+const streamKeepAlive = 25 * time.Second
+
+// StreamHandlers serves realtime updates as Server-Sent Events.
+type StreamHandlers struct {
+	hub *realtime.Hub
+}
+
+func (h *StreamHandlers) register(rg *gin.RouterGroup) {
+	rg.GET("/stream", h.Stream)
+}
+
+// Stream subscribes the caller to the requested topics. Clients pass one
+// or more topic parameters: "me" for their own notifications and
+// "resource:<type>:<id>" for comments and activity on a resource.
+// Messages carry IDs, so a reconnecting EventSource sends Last-Event-ID
+// and first receives the retained messages it missed, whichever replica
+// it reconnects to.
+func (h *StreamHandlers) Stream(c *gin.Context) {
+	id := identity(c)
+	requested := c.QueryArray("topic")
+	if len(requested) == 0 {
+		requested = []string{"me"}
+	}
+
+	topics := make([]string, 0, len(requested))
+	for _, t := range requested {
+		topic, err := resolveTopic(id, t)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		topics = append(topics, topic)
+	}
+
+	lastID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	sub := h.hub.SubscribeAfter(lastID, topics...)
+	defer h.hub.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case msg := <-sub.C:
+			writeStreamMessage(w, msg)
+			return true
+		case <-ticker.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		}
+	})
+}
+
+func writeStreamMessage(w io.Writer, msg realtime.Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if msg.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", msg.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data)
+}
+
+// resolveTopic maps a client topic name to a hub topic scoped to the
+// caller's tenant.
+func resolveTopic(id domain.Identity, name string) (string, error) {
+	if name == "me" {
+		return realtime.UserTopic(id.TenantID, id.UserID), nil
+	}
+	if rest, ok := strings.CutPrefix(name, "resource:"); ok {
+		resourceType, resourceID, _ := strings.Cut(rest, ":")
+		if err := domain.ValidateResourceRef(resourceType, resourceID); err != nil {
+			return "", err
+		}
+		return realtime.ResourceTopic(id.TenantID, resourceType, resourceID), nil
+	}
+	return "", domain.InvalidInput("unknown topic %q", name)
+}
@@ -0,0 +1,659 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	canaryHeader        = "X-Canary"
+	canaryCookie        = "canary"
+	canaryVersionHeader = "X-Canary-Version"
+	// shadowHeader marks mirrored requests, which are never mirrored again
+	// even when an upstream points back at this deployment.
+	shadowHeader  = "X-Shadow-Request"
+	stableVersion = "stable"
+
+	maxShadowBody    = 1 << 20
+	shadowTimeout    = 10 * time.Second
+	maxShadowDiffs   = 10
+	keptShadowDiffs  = 20
+	maxShadowWorkers = 8
+)
+
+// credentialHeaders are removed from requests mirrored to an upstream,
+// unless their rule forwards credentials.
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// ShadowRule mirrors sampled requests of a route to an upstream or to a
+// version registered as a RouteVariant, and compares the responses.
+// Routes are keyed as "METHOD /full/path/:param".
+type ShadowRule struct {
+	Route        string   `json:"route"`
+	SampleRate   float64  `json:"sample_rate"`
+	Upstream     string   `json:"upstream,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	IgnoreFields []string `json:"ignore_fields,omitempty"`
+	// AllowUnsafe mirrors requests other than GET and HEAD. Only enable it
+	// for targets without side effects.
+	AllowUnsafe bool `json:"allow_unsafe,omitempty"`
+	// ForwardCredentials sends the caller's Authorization and Cookie
+	// headers to the upstream. Only trusted upstreams may receive them.
+	ForwardCredentials bool `json:"forward_credentials,omitempty"`
+}
+
+// RouteVariant is an alternate handler for a route, such as a rewrite
+// being tried out. It serves canary traffic and can be the target of a
+// shadow rule. Variants run behind the same guards as the route.
+type RouteVariant struct {
+	Route   string
+	Version string
+	Handler gin.HandlerFunc
+}
+
+// ShadowStats counts the outcome of mirrored requests.
+type ShadowStats struct {
+	Mirrored   int64        `json:"mirrored"`
+	Matched    int64        `json:"matched"`
+	Mismatched int64        `json:"mismatched"`
+	Failed     int64        `json:"failed"`
+	Recent     []ShadowDiff `json:"recent"`
+}
+
+// ShadowDiff describes a mirrored request whose responses differed.
+type ShadowDiff struct {
+	Path         string    `json:"path"`
+	Status       int       `json:"status"`
+	ShadowStatus int       `json:"shadow_status"`
+	Fields       []string  `json:"fields,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// CanaryRule routes part of a route's traffic to alternate handler
+// versions. Weights are percentages per version; the rest stays stable.
+type CanaryRule struct {
+	Route    string         `json:"route"`
+	Versions []string       `json:"versions"`
+	Weights  map[string]int `json:"weights"`
+
+	handlers map[string]gin.HandlerFunc
+}
+
+// trafficRouter applies shadow and canary rules to the routes behind its
+// middleware and dispatch.
+type trafficRouter struct {
+	// client only connects to public addresses; trustedClient serves the
+	// upstreams the configuration trusts, which may be internal.
+	client        *http.Client
+	trustedClient *http.Client
+	trusted       map[string]bool // origins
+	// engine replays requests mirrored to a version, through the route's
+	// guards like any other request.
+	engine http.Handler
+	slots  chan struct{}
+
+	mu       sync.RWMutex
+	shadows  map[string]*ShadowRule
+	stats    map[string]*ShadowStats
+	canaries map[string]*CanaryRule
+}
+
+// errPrivateUpstream is returned when an untrusted upstream resolves to
+// an address in the server's own network.
+var errPrivateUpstream = errors.New("upstream does not resolve to a public address")
+
+// newTrafficRouter creates a router that may mirror to the given trusted
+// upstream origins, and to public addresses otherwise.
+func newTrafficRouter(trusted []string) *trafficRouter {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: checkPublicAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would resolve host names itself, past checkPublicAddress.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	t := &trafficRouter{
+		client:        &http.Client{Timeout: shadowTimeout, Transport: transport},
+		trustedClient: &http.Client{Timeout: shadowTimeout},
+		trusted:       make(map[string]bool),
+		slots:         make(chan struct{}, maxShadowWorkers),
+		shadows:       make(map[string]*ShadowRule),
+		stats:         make(map[string]*ShadowStats),
+		canaries:      make(map[string]*CanaryRule),
+	}
+	for _, upstream := range trusted {
+		if origin, ok := upstreamOrigin(strings.TrimSpace(upstream)); ok {
+			t.trusted[origin] = true
+		}
+	}
+	return t
+}
+
+// checkPublicAddress runs before each connection to an untrusted
+// upstream with the resolved address, so host names pointing into the
+// server's network are refused too.
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !domain.PublicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateUpstream, address)
+	}
+	return nil
+}
+
+// upstreamOrigin returns the scheme://host:port an upstream URL points at.
+func upstreamOrigin(upstream string) (string, bool) {
+	u, err := url.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return u.Scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port), true
+}
+
+// isTrusted reports whether the configuration trusts an upstream.
+func (t *trafficRouter) isTrusted(upstream string) bool {
+	origin, ok := upstreamOrigin(upstream)
+	return ok && t.trusted[origin]
+}
+
+// routeKey identifies the matched route of a request.
+func routeKey(method, fullPath string) string {
+	return method + " " + fullPath
+}
+
+// setShadow adds or replaces a shadow rule, keeping its statistics.
+func (t *trafficRouter) setShadow(rule ShadowRule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shadows[rule.Route] = &rule
+	if t.stats[rule.Route] == nil {
+		t.stats[rule.Route] = &ShadowStats{Recent: []ShadowDiff{}}
+	}
+}
+
+func (t *trafficRouter) removeShadow(route string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.shadows[route]
+	delete(t.shadows, route)
+	return ok
+}
+
+// hasVersion reports whether a version of the route was registered.
+func (t *trafficRouter) hasVersion(route, name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rule, ok := t.canaries[route]
+	return ok && rule.handlers[name] != nil
+}
+
+// version registers an alternate handler version of a route. It receives
+// no traffic until it is given a weight or requested explicitly.
+func (t *trafficRouter) version(route, name string, handler gin.HandlerFunc) {
+	if name == "" || name == stableVersion || handler == nil {
+		panic(fmt.Sprintf("traffic: invalid version %q of %s", name, route))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rule, ok := t.canaries[route]
+	if !ok {
+		rule = &CanaryRule{Route: route, Weights: map[string]int{}, handlers: map[string]gin.HandlerFunc{}}
+		t.canaries[route] = rule
+	}
+	rule.handlers[name] = handler
+	rule.Versions = append(rule.Versions, name)
+	sort.Strings(rule.Versions)
+}
+
+// setWeights replaces the traffic split of a route's versions.
+func (t *trafficRouter) setWeights(route string, weights map[string]int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rule, ok := t.canaries[route]
+	if !ok {
+		return fmt.Errorf("route %q has no alternate versions", route)
+	}
+	total := 0
+	for name, w := range weights {
+		if _, ok := rule.handlers[name]; !ok {
+			return fmt.Errorf("route %q has no version %q", route, name)
+		}
+		if w < 0 {
+			return fmt.Errorf("weight of %q must not be negative", name)
+		}
+		total += w
+	}
+	if total > 100 {
+		return fmt.Errorf("weights add up to %d%%, more than 100%%", total)
+	}
+	rule.Weights = weights
+	return nil
+}
+
+// middleware mirrors sampled requests of shadowed routes, comparing the
+// response the caller got with the shadow target's.
+func (t *trafficRouter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t.mu.RLock()
+		shadow := t.shadows[routeKey(c.Request.Method, c.FullPath())]
+		t.mu.RUnlock()
+
+		var capture *captureWriter
+		var body []byte
+		if shadow != nil && t.sampled(c, shadow) {
+			var err error
+			if body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxShadowBody+1)); err != nil || len(body) > maxShadowBody {
+				body = nil
+			} else {
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				capture = &captureWriter{ResponseWriter: c.Writer}
+				c.Writer = capture
+			}
+		}
+
+		c.Next()
+
+		if capture != nil && !capture.truncated {
+			mirror := c.Request.Clone(context.Background())
+			go t.mirror(shadow, mirror, body, capture.Status(), capture.buf.Bytes())
+		}
+	}
+}
+
+// dispatch runs the canary version picked for the request in place of the
+// route's handler. It must be the last middleware of a group, so the
+// group's guards have passed by the time a version runs.
+func (t *trafficRouter) dispatch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t.mu.RLock()
+		canary := t.canaries[routeKey(c.Request.Method, c.FullPath())]
+		t.mu.RUnlock()
+		if canary == nil {
+			c.Next()
+			return
+		}
+		version := t.pickVersion(c, canary)
+		c.Header(canaryVersionHeader, version)
+		if version == stableVersion {
+			c.Next()
+			return
+		}
+		canary.handlers[version](c)
+		c.Abort()
+	}
+}
+
+func (t *trafficRouter) sampled(c *gin.Context, rule *ShadowRule) bool {
+	if c.GetHeader(shadowHeader) != "" {
+		return false
+	}
+	if !rule.AllowUnsafe && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+	return rand.Float64() < rule.SampleRate
+}
+
+// pickVersion chooses the handler version for a request: an explicit
+// X-Canary header or canary cookie wins, otherwise the caller falls into a
+// weighted bucket that stays the same across requests.
+func (t *trafficRouter) pickVersion(c *gin.Context, rule *CanaryRule) string {
+	if rule == nil {
+		return stableVersion
+	}
+	requested := c.GetHeader(canaryHeader)
+	if requested == "" {
+		requested, _ = c.Cookie(canaryCookie)
+	}
+	if _, ok := rule.handlers[requested]; ok || requested == stableVersion {
+		return requested
+	}
+
+	caller := c.ClientIP()
+	if id, ok := currentIdentity(c); ok {
+		caller = id.TenantID + "/" + id.UserID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(rule.Route + "|" + caller))
+	bucket := int(h.Sum32() % 100)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	cumulative := 0
+	for _, name := range rule.Versions {
+		cumulative += rule.Weights[name]
+		if bucket < cumulative {
+			return name
+		}
+	}
+	return stableVersion
+}
+
+// mirror replays the request against the shadow target and records how
+// its response compares to the one the client got.
+func (t *trafficRouter) mirror(rule *ShadowRule, req *http.Request, body []byte, status int, primary []byte) {
+	select {
+	case t.slots <- struct{}{}:
+		defer func() { <-t.slots }()
+	default:
+		// Shadow traffic must never queue up behind real traffic.
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+	shadowStatus, shadowBody, err := t.replay(ctx, rule, req, body)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats[rule.Route]
+	stats.Mirrored++
+	if err != nil {
+		stats.Failed++
+		log.Printf("shadow %s: %v", rule.Route, err)
+		return
+	}
+	fields := diffResponses(primary, shadowBody, rule.IgnoreFields)
+	if status == shadowStatus && len(fields) == 0 {
+		stats.Matched++
+		return
+	}
+	stats.Mismatched++
+	diff := ShadowDiff{Path: req.URL.RequestURI(), Status: status, ShadowStatus: shadowStatus, Fields: fields, At: time.Now().UTC()}
+	stats.Recent = append(stats.Recent, diff)
+	if len(stats.Recent) > keptShadowDiffs {
+		stats.Recent = stats.Recent[1:]
+	}
+	log.Printf("shadow %s %s: status %d vs %d, differing fields %v", rule.Route, diff.Path, status, shadowStatus, fields)
+}
+
+func (t *trafficRouter) replay(ctx context.Context, rule *ShadowRule, req *http.Request, body []byte) (int, []byte, error) {
+	req = req.WithContext(ctx)
+	req.Header.Set(shadowHeader, "1")
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	if rule.Upstream == "" {
+		req.Header.Set(canaryHeader, rule.Version)
+		rec := httptest.NewRecorder()
+		t.engine.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.Bytes(), nil
+	}
+
+	upstream, err := http.NewRequestWithContext(ctx, req.Method, strings.TrimRight(rule.Upstream, "/")+req.URL.RequestURI(), req.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	upstream.Header = req.Header.Clone()
+	trusted := t.isTrusted(rule.Upstream)
+	if !rule.ForwardCredentials || !trusted {
+		for _, name := range credentialHeaders {
+			upstream.Header.Del(name)
+		}
+	}
+	client := t.client
+	if trusted {
+		client = t.trustedClient
+	}
+	resp, err := client.Do(upstream)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxShadowBody))
+	return resp.StatusCode, respBody, err
+}
+
+// snapshot returns copies of the rules and statistics.
+func (t *trafficRouter) snapshot() (shadows []ShadowRule, stats map[string]ShadowStats, canaries []CanaryRule) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	shadows = []ShadowRule{}
+	stats = make(map[string]ShadowStats)
+	for route, rule := range t.shadows {
+		shadows = append(shadows, *rule)
+		s := *t.stats[route]
+		s.Recent = append([]ShadowDiff{}, s.Recent...)
+		stats[route] = s
+	}
+	canaries = []CanaryRule{}
+	for _, rule := range t.canaries {
+		r := *rule
+		r.Weights = make(map[string]int, len(rule.Weights))
+		for k, v := range rule.Weights {
+			r.Weights[k] = v
+		}
+		canaries = append(canaries, r)
+	}
+	sort.Slice(shadows, func(i, j int) bool { return shadows[i].Route < shadows[j].Route })
+	sort.Slice(canaries, func(i, j int) bool { return canaries[i].Route < canaries[j].Route })
+	return shadows, stats, canaries
+}
+
+// captureWriter keeps a copy of the response body for comparison.
+type captureWriter struct {
+	gin.ResponseWriter
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.buf.Len()+len(b) > maxShadowBody {
+		w.truncated = true
+	} else {
+		w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// diffResponses lists the paths at which two JSON bodies differ, ignoring
+// the named fields at any depth. Non-JSON bodies are compared as bytes.
+func diffResponses(a, b []byte, ignore []string) []string {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []string{"(body)"}
+	}
+	skip := make(map[string]bool, len(ignore))
+	for _, f := range ignore {
+		skip[f] = true
+	}
+	var diffs []string
+	diffValues("", va, vb, skip, &diffs)
+	return diffs
+}
+
+func diffValues(path string, a, b interface{}, skip map[string]bool, diffs *[]string) {
+	if len(*diffs) >= maxShadowDiffs {
+		return
+	}
+	label := path
+	if label == "" {
+		label = "(root)"
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			*diffs = append(*diffs, label)
+			return
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !skip[k] {
+				diffValues(strings.TrimPrefix(path+"."+k, "."), av[k], bv[k], skip, diffs)
+			}
+		}
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			*diffs = append(*diffs, label)
+			return
+		}
+		for i := range av {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), av[i], bv[i], skip, diffs)
+		}
+	default:
+		if !reflect.DeepEqual(a, b) {
+			*diffs = append(*diffs, label)
+		}
+	}
+}
+
+// TrafficHandlers lets operators inspect and adjust shadowing and canary
+// rules at runtime. Rules apply to the whole process, across tenants, and
+// are not persisted; startup rules come from the configuration and the
+// code.
+type TrafficHandlers struct {
+	traffic *trafficRouter
+	audit   *domain.AuditService
+}
+
+func (h *TrafficHandlers) register(operator *gin.RouterGroup) {
+	operator.GET("/traffic", h.Get)
+	operator.PUT("/traffic/shadows", h.PutShadow)
+	operator.DELETE("/traffic/shadows", h.DeleteShadow)
+	operator.PUT("/traffic/canaries", h.PutWeights)
+}
+
+func (h *TrafficHandlers) Get(c *gin.Context) {
+	shadows, stats, canaries := h.traffic.snapshot()
+	respondOK(c, http.StatusOK, gin.H{"shadows": shadows, "stats": stats, "canaries": canaries})
+}
+
+// PutShadow adds or updates a shadow rule, mirroring to an upstream or to
+// a registered version of the route.
+func (h *TrafficHandlers) PutShadow(c *gin.Context) {
+	var rule ShadowRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	if err := validateShadowRule(rule); err != nil {
+		respondError(c, err)
+		return
+	}
+	if rule.Version != "" && !h.traffic.hasVersion(rule.Route, rule.Version) {
+		respondError(c, domain.InvalidInput("route %q has no version %q", rule.Route, rule.Version))
+		return
+	}
+	if rule.Upstream != "" && !h.traffic.isTrusted(rule.Upstream) {
+		if rule.ForwardCredentials {
+			respondError(c, domain.InvalidInput("forward_credentials requires an upstream listed in SHADOW_TRUSTED_UPSTREAMS"))
+			return
+		}
+		u, _ := url.Parse(rule.Upstream)
+		if ip := net.ParseIP(u.Hostname()); (ip != nil && !domain.PublicIP(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+			respondError(c, domain.InvalidInput("upstream must be a public address or listed in SHADOW_TRUSTED_UPSTREAMS"))
+			return
+		}
+	}
+	h.traffic.setShadow(rule)
+	h.record(c, "traffic.shadow.set", rule.Route, map[string]interface{}{"rule": rule})
+	respondOK(c, http.StatusOK, rule)
+}
+
+func (h *TrafficHandlers) DeleteShadow(c *gin.Context) {
+	route := c.Query("route")
+	if !h.traffic.removeShadow(route) {
+		respondError(c, domain.NotFound("Shadow rule"))
+		return
+	}
+	h.record(c, "traffic.shadow.delete", route, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// PutWeights sets the traffic split of a route's canary versions.
+func (h *TrafficHandlers) PutWeights(c *gin.Context) {
+	var req struct {
+		Route   string         `json:"route" binding:"required"`
+		Weights map[string]int `json:"weights"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidInput(c, err)
+		return
+	}
+	if err := h.traffic.setWeights(req.Route, req.Weights); err != nil {
+		respondError(c, domain.InvalidInput("%s", err.Error()))
+		return
+	}
+	h.record(c, "traffic.canary.set", req.Route, map[string]interface{}{"weights": req.Weights})
+	respondOK(c, http.StatusOK, req)
+}
+
+func (h *TrafficHandlers) record(c *gin.Context, action, route string, changes map[string]interface{}) {
+	if err := h.audit.Record(c.Request.Context(), identity(c), action, "traffic_rule", route, changes); err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
+
+func validateShadowRule(rule ShadowRule) error {
+	method, path, ok := strings.Cut(rule.Route, " ")
+	if !ok || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/api/") {
+		return domain.InvalidInput("route must look like \"GET /api/path/:param\"")
+	}
+	if rule.SampleRate < 0 || rule.SampleRate > 1 {
+		return domain.InvalidInput("sample_rate must be between 0 and 1")
+	}
+	if (rule.Upstream == "") == (rule.Version == "") {
+		return domain.InvalidInput("a shadow rule needs either an upstream or a version")
+	}
+	if rule.ForwardCredentials && rule.Upstream == "" {
+		return domain.InvalidInput("forward_credentials only applies to upstreams")
+	}
+	if _, ok := upstreamOrigin(rule.Upstream); rule.Upstream != "" && !ok {
+		return domain.InvalidInput("upstream must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// shadowRoutes parses the SHADOW_ROUTES setting, a comma-separated list
+// of "METHOD /api/path" routes.
+func shadowRoutes(setting string) []string {
+	var routes []string
+	for _, r := range strings.Split(setting, ",") {
+		if r = strings.Join(strings.Fields(r), " "); r != "" {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
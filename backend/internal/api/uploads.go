@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// tus 1.0 protocol constants; see https://tus.io/protocols/resumable-upload
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,termination,checksum,expiration"
+	tusChunkType     = "application/offset+octet-stream"
+	tusRequestHeader = "Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Checksum"
+	tusExposeHeaders = "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, " +
+		"Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires"
+)
+
+var (
+	errTusVersion = domain.AppError{
+		Status:  http.StatusPreconditionFailed,
+		Code:    "TUS_VERSION_UNSUPPORTED",
+		Message: "Only tus " + tusVersion + " is supported",
+	}
+
+	errTusChunkType = domain.AppError{
+		Status:  http.StatusUnsupportedMediaType,
+		Code:    "UNSUPPORTED_MEDIA_TYPE",
+		Message: "Chunks are sent as " + tusChunkType,
+	}
+)
+
+// UploadHandlers is a tus 1.0 server for resumable uploads with the
+// creation, termination, checksum and expiration extensions. Finished
+// uploads become files; GET /uploads/:id tells the client which.
+type UploadHandlers struct {
+	uploads *domain.UploadService
+}
+
+func (h *UploadHandlers) register(public, rg *gin.RouterGroup) {
+	public.OPTIONS("/uploads", h.Options)
+	public.OPTIONS("/uploads/:id", h.Options)
+
+	tus := rg.Group("/uploads", tusResumable())
+	tus.POST("", h.Create)
+	tus.HEAD("/:id", h.Head)
+	tus.PATCH("/:id", h.Patch)
+	tus.DELETE("/:id", h.Terminate)
+	getNamed(rg, "upload", "/uploads/:id", h.Get)
+}
+
+// tusResumable rejects requests for another protocol version and marks
+// every response with the one spoken here.
+func tusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			respondError(c, errTusVersion)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Options answers tus discovery and CORS preflight requests.
+func (h *UploadHandlers) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.uploads.MaxSize(), 10))
+	c.Header("Tus-Checksum-Algorithm", strings.Join(domain.UploadChecksumAlgorithms, ","))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UploadHandlers) Create(c *gin.Context) {
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		respondError(c, domain.InvalidInput("Upload-Defer-Length is not supported; send Upload-Length"))
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		respondError(c, domain.InvalidInput("Upload-Length must be the size of the upload in bytes"))
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	upload, err := h.uploads.Create(c.Request.Context(), identity(c), length, metadata)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Location", c.Request.URL.Path+"/"+upload.ID)
+	h.writeState(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+func (h *UploadHandlers) Head(c *gin.Context) {
+	upload, err := h.uploads.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		c.Header("Upload-Metadata", encodeUploadMetadata(upload.Metadata))
+	}
+	h.writeState(c, upload)
+	c.Status(http.StatusOK)
+}
+
+func (h *UploadHandlers) Patch(c *gin.Context) {
+	if c.ContentType() != tusChunkType {
+		respondError(c, errTusChunkType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondError(c, domain.InvalidInput("Upload-Offset must be the number of bytes already received"))
+		return
+	}
+	var checksum *domain.UploadChecksum
+	if value := c.GetHeader("Upload-Checksum"); value != "" {
+		if checksum, err = domain.ParseUploadChecksum(value); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+	upload, err := h.uploads.Append(c.Request.Context(), identity(c), c.Param("id"), offset, c.Request.Body, checksum)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	h.writeState(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UploadHandlers) Terminate(c *gin.Context) {
+	if err := h.uploads.Terminate(c.Request.Context(), identity(c), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Get returns an upload as JSON, including the ID of the file it became.
+func (h *UploadHandlers) Get(c *gin.Context) {
+	upload, err := h.uploads.Get(c.Request.Context(), identity(c), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, upload)
+}
+
+func (h *UploadHandlers) writeState(c *gin.Context, upload *domain.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+}
+
+// parseUploadMetadata decodes Upload-Metadata: comma-separated keys,
+// each followed by a space and its base64 value unless it has none.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, domain.InvalidInput("malformed Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, domain.InvalidInput("Upload-Metadata value of %q is not base64", key)
+		}
+		if _, dup := metadata[key]; dup {
+			return nil, domain.InvalidInput("Upload-Metadata key %q is repeated", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func encodeUploadMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k
+		if v := metadata[k]; v != "" {
+			pairs[i] += " " + base64.StdEncoding.EncodeToString([]byte(v))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// UserHandlers serves the user directory of the caller's tenant.
+type UserHandlers struct {
+	users *domain.UserService
+}
+
+func (h *UserHandlers) register(rg *gin.RouterGroup) {
+	getNamed(rg, "user", "/users/:id", h.Get)
+}
+
+func (h *UserHandlers) Get(c *gin.Context) {
+	user, err := h.users.Get(c.Request.Context(), identity(c).TenantID, c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondOK(c, http.StatusOK, user)
+}
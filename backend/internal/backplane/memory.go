@@ -0,0 +1,75 @@
+// Package backplane implements realtime.Backplane: in memory for a
+// single replica, and with Postgres LISTEN/NOTIFY or NATS JetStream to
+// fan realtime messages out across replicas.
+package backplane
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryRetain is how many messages Memory keeps for receivers that
+// start late.
+const memoryRetain = 1024
+
+// Memory is the backplane of a single replica. Messages are numbered and
+// retained like on the shared backplanes, so clients resume the same
+// way, but nothing leaves the process.
+type Memory struct {
+	mu      sync.Mutex
+	entries []memoryEntry
+	next    uint64
+	// changed is closed and replaced whenever a message is published
+	changed chan struct{}
+}
+
+type memoryEntry struct {
+	seq  uint64
+	body []byte
+}
+
+// NewMemory creates an in-memory backplane.
+func NewMemory() *Memory {
+	return &Memory{next: 1, changed: make(chan struct{})}
+}
+
+func (m *Memory) Name() string { return "memory" }
+
+func (m *Memory) Publish(ctx context.Context, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.entries) == memoryRetain {
+		m.entries = append(m.entries[:0], m.entries[1:]...)
+	}
+	m.entries = append(m.entries, memoryEntry{seq: m.next, body: body})
+	m.next++
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return nil
+}
+
+func (m *Memory) Receive(ctx context.Context, after uint64, deliver func(seq uint64, body []byte)) error {
+	for {
+		m.mu.Lock()
+		var pending []memoryEntry
+		for _, e := range m.entries {
+			if e.seq > after {
+				pending = append(pending, e)
+			}
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		for _, e := range pending {
+			deliver(e.seq, e.body)
+			after = e.seq
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (m *Memory) Close() error { return nil }
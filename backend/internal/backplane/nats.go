@@ -0,0 +1,185 @@
+package backplane
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	natsStream  = "GREACT_REALTIME"
+	natsSubject = "greact.realtime"
+)
+
+// NATSOptions configures the NATS backplane. Without URL the backplane
+// runs an embedded server on Listen with its JetStream store in StoreDir;
+// other replicas connect to it, or all replicas use an external server.
+type NATSOptions struct {
+	URL       string
+	Listen    string
+	StoreDir  string
+	Retention time.Duration
+}
+
+// NATS relays messages through a JetStream stream. The stream numbers
+// and stores the messages, so replicas read them in one order and resume
+// after a reconnect or restart where they stopped.
+type NATS struct {
+	server *server.Server
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	closed chan struct{}
+	// reconnected is signalled when the connection is reestablished
+	reconnected chan struct{}
+}
+
+// NewNATS connects to the server at opts.URL, or starts an embedded one,
+// and creates the stream.
+func NewNATS(opts NATSOptions) (*NATS, error) {
+	n := &NATS{closed: make(chan struct{}), reconnected: make(chan struct{}, 1)}
+	url := opts.URL
+	if url == "" {
+		s, err := startNATSServer(opts.Listen, opts.StoreDir)
+		if err != nil {
+			return nil, err
+		}
+		n.server, url = s, s.ClientURL()
+	}
+	conn, err := nats.Connect(url,
+		nats.Name("greact-bones"),
+		nats.MaxReconnects(-1),
+		nats.ClosedHandler(func(*nats.Conn) { close(n.closed) }),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			select {
+			case n.reconnected <- struct{}{}:
+			default:
+			}
+		}),
+	)
+	if err != nil {
+		n.shutdownServer()
+		return nil, fmt.Errorf("nats backplane: %w", err)
+	}
+	n.conn = conn
+	if n.js, err = conn.JetStream(); err != nil {
+		n.Close()
+		return nil, fmt.Errorf("nats backplane: %w", err)
+	}
+	stream := &nats.StreamConfig{
+		Name:     natsStream,
+		Subjects: []string{natsSubject},
+		Storage:  nats.FileStorage,
+		MaxAge:   opts.Retention,
+		Discard:  nats.DiscardOld,
+	}
+	if _, err := n.js.StreamInfo(natsStream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = n.js.AddStream(stream)
+	} else if err == nil {
+		_, err = n.js.UpdateStream(stream)
+	}
+	if err != nil {
+		n.Close()
+		return nil, fmt.Errorf("nats backplane: stream %s: %w", natsStream, err)
+	}
+	return n, nil
+}
+
+func startNATSServer(listen, storeDir string) (*server.Server, error) {
+	host, portText, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("nats backplane: NATS_LISTEN: %w", err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, fmt.Errorf("nats backplane: NATS_LISTEN port %q", portText)
+	}
+	if err := os.MkdirAll(storeDir, 0o755); err != nil {
+		return nil, err
+	}
+	s, err := server.NewServer(&server.Options{
+		ServerName: "greact-" + strconv.Itoa(port),
+		Host:       host,
+		Port:       port,
+		JetStream:  true,
+		StoreDir:   storeDir,
+		NoSigs:     true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats backplane: %w", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		return nil, fmt.Errorf("nats backplane: embedded server did not start on %s", listen)
+	}
+	return s, nil
+}
+
+func (n *NATS) Name() string { return "nats" }
+
+// Publish sets a message ID derived from the body, so the stream drops a
+// retried publish that was appended before.
+func (n *NATS) Publish(ctx context.Context, body []byte) error {
+	sum := sha256.Sum256(body)
+	_, err := n.js.Publish(natsSubject, body, nats.Context(ctx), nats.MsgId(hex.EncodeToString(sum[:16])))
+	return err
+}
+
+// Receive reads the stream with an ordered consumer, which the client
+// recreates from the last delivered message when it detects a gap. After
+// a reconnect, when the server may have restarted and lost the consumer,
+// Receive returns so the caller starts over from its last message.
+func (n *NATS) Receive(ctx context.Context, after uint64, deliver func(seq uint64, body []byte)) error {
+	select {
+	case <-n.reconnected:
+	default:
+	}
+	start := nats.DeliverAll()
+	if after > 0 {
+		start = nats.StartSequence(after + 1)
+	}
+	sub, err := n.js.Subscribe(natsSubject, func(m *nats.Msg) {
+		meta, err := m.Metadata()
+		if err != nil {
+			return
+		}
+		deliver(meta.Sequence.Stream, m.Data)
+	}, nats.OrderedConsumer(), start)
+	if err != nil {
+		return fmt.Errorf("nats backplane: %w", err)
+	}
+	defer sub.Unsubscribe()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-n.closed:
+		return errors.New("nats backplane: connection closed")
+	case <-n.reconnected:
+		return errors.New("nats backplane: reconnected")
+	}
+}
+
+// Close disconnects and stops the embedded server, if any.
+func (n *NATS) Close() error {
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	n.shutdownServer()
+	return nil
+}
+
+func (n *NATS) shutdownServer() {
+	if n.server != nil {
+		n.server.Shutdown()
+		n.server.WaitForShutdown()
+	}
+}
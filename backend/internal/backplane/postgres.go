@@ -0,0 +1,104 @@
+package backplane
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	postgresChannel = "greact_realtime"
+	postgresPage    = 500
+	// postgresPoll catches up even if a notification was lost, e.g. while
+	// the listener reconnected.
+	postgresPoll = 30 * time.Second
+)
+
+// PostgresLog is the message table shared by the replicas.
+type PostgresLog interface {
+	Append(ctx context.Context, channel string, body []byte, at time.Time) (uint64, error)
+	After(ctx context.Context, seq uint64, limit int, fn func(seq uint64, body []byte)) (int, error)
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Postgres relays messages through the application database. Messages
+// are appended to a table, which orders them and lets replicas catch up
+// after a restart, and NOTIFY wakes the replicas LISTENing for them.
+type Postgres struct {
+	log       PostgresLog
+	dsn       string
+	retention time.Duration
+}
+
+// NewPostgres creates a backplane on the log, listening for its
+// notifications on a connection to dsn. Messages are kept for retention.
+func NewPostgres(log PostgresLog, dsn string, retention time.Duration) *Postgres {
+	return &Postgres{log: log, dsn: dsn, retention: retention}
+}
+
+func (p *Postgres) Name() string { return "postgres" }
+
+func (p *Postgres) Publish(ctx context.Context, body []byte) error {
+	_, err := p.log.Append(ctx, postgresChannel, body, time.Now().UTC())
+	return err
+}
+
+// Receive listens for notifications and reads the log past the last
+// message delivered whenever one arrives. pq reconnects the listener by
+// itself; reading the log afterwards picks up what was missed meanwhile.
+func (p *Postgres) Receive(ctx context.Context, after uint64, deliver func(seq uint64, body []byte)) error {
+	listener := pq.NewListener(p.dsn, time.Second, 30*time.Second, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("backplane: postgres listener: %v", err)
+		}
+	})
+	defer listener.Close()
+	// Listen before the first read, so a message appended in between is
+	// still noticed
+	if err := listener.Listen(postgresChannel); err != nil {
+		return fmt.Errorf("postgres backplane: %w", err)
+	}
+
+	poll := time.NewTicker(postgresPoll)
+	defer poll.Stop()
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+	for {
+		for {
+			n, err := p.log.After(ctx, after, postgresPage, func(seq uint64, body []byte) {
+				deliver(seq, body)
+				after = seq
+			})
+			if err != nil {
+				return fmt.Errorf("postgres backplane: %w", err)
+			}
+			if n < postgresPage {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-listener.Notify:
+			// One read covers every notification queued meanwhile
+			for drained := false; !drained; {
+				select {
+				case <-listener.Notify:
+				default:
+					drained = true
+				}
+			}
+		case <-poll.C:
+		case <-prune.C:
+			if _, err := p.log.Prune(ctx, time.Now().UTC().Add(-p.retention)); err != nil {
+				log.Printf("backplane: pruning the postgres log: %v", err)
+			}
+		}
+	}
+}
+
+func (p *Postgres) Close() error { return nil }
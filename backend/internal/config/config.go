@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// Config holds the runtime settings for the API server. Every value can be
+// overridden with an environment variable so the same binary runs locally,
+// in Docker and in production.
+type Config struct {
+	Port           string
+	Environment    string
+	DatabaseDriver string
+	DatabaseURL    string
+	AuthSecret     string
+	FrontendOrigin string
+	FrontendDist   string
+	AppURL         string
+	JobWorkers     int
+	SMTPAddr       string
+	SMTPFrom       string
+	SMTPUsername   string
+	SMTPPassword   string
+	// ShadowUpstream receives mirrored copies of the ShadowRoutes requests,
+	// sampled at ShadowSampleRate, for comparison with this server.
+	ShadowUpstream   string
+	ShadowRoutes     string
+	ShadowSampleRate float64
+	// ShadowTrustedUpstreams lists further origins (scheme://host:port)
+	// shadow rules may use although they resolve to private addresses,
+	// and that may receive callers' credentials. ShadowUpstream is
+	// trusted too.
+	ShadowTrustedUpstreams string
+	// SchemaCheck compares the live schema with the migrations at startup:
+	// "warn" logs differences, "block" refuses to start, "off" skips it.
+	SchemaCheck string
+	// TLSCertFile and TLSKeyFile make the server speak HTTPS directly
+	// instead of behind a terminating proxy.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TrustedProxies lists the addresses and CIDR ranges of the proxies
+	// whose X-Forwarded-For header gives the client's address. By default
+	// no proxy is trusted and the address is the connection's.
+	TrustedProxies string
+	// FileStorageDir holds uploaded file content, stored once per distinct
+	// content under its SHA-256 hash.
+	FileStorageDir string
+	MaxUploadBytes int
+	// UploadDir holds resumable uploads in progress; they expire after
+	// UploadTTLHours without a new chunk.
+	UploadDir      string
+	UploadTTLHours int
+	// Scanner checks uploads for malware before they are served: "clamd"
+	// at ClamdAddr, "signatures" for the built-in rules plus those in
+	// ScanSignatures, or "off".
+	Scanner        string
+	ClamdAddr      string
+	ScanSignatures string
+	// VAPIDPrivateKey signs Web Push requests; without it the key is read
+	// from, or generated into, VAPIDKeyFile. VAPIDSubject is the contact
+	// push services see, APP_URL by default. PushTTLSeconds is how long
+	// push services keep a notification for an offline device.
+	VAPIDPrivateKey string
+	VAPIDKeyFile    string
+	VAPIDSubject    string
+	PushTTLSeconds  int
+	// Backplane fans realtime messages out to the other replicas:
+	// "memory" for a single replica, "postgres" through the database, or
+	// "nats" through NATSURL, or an embedded server on NATSListen storing
+	// its stream in NATSStoreDir when NATSURL is empty.
+	Backplane    string
+	NATSURL      string
+	NATSListen   string
+	NATSStoreDir string
+	// A user reaching BanAfterStrikes active strikes is banned for a
+	// week; strikes stop counting after StrikeExpiryDays.
+	BanAfterStrikes  int
+	StrikeExpiryDays int
+	// Tenants provisioned with a database of their own keep it, and their
+	// files, under TenantDir/<tenant>. At most TenantMaxPools of their
+	// databases are open at once.
+	TenantDir      string
+	TenantMaxPools int
+	// Mail to InboundDomain is received by an SMTP listener on
+	// InboundSMTPAddr and by a webhook taking MIME from a relay, enabled
+	// by InboundSecret. Larger messages than InboundMaxBytes are refused.
+	InboundDomain   string
+	InboundSMTPAddr string
+	InboundSecret   string
+	InboundMaxBytes int
+	// Documents are rendered from the built-in templates and those in
+	// DocTemplateDir, with the fonts in DocFontDir. Their download links
+	// are valid for DocLinkSeconds; documents are deleted after
+	// DocRetentionDays.
+	DocTemplateDir   string
+	DocFontDir       string
+	DocLinkSeconds   int
+	DocRetentionDays int
+}
+
+// Load reads the configuration from the environment, falling back to
+// defaults that work for local development.
+func Load() *Config {
+	return &Config{
+		Port:                   getEnv("PORT", "8080"),
+		Environment:            getEnv("ENVIRONMENT", "development"),
+		DatabaseDriver:         getEnv("DATABASE_DRIVER", "sqlite"),
+		DatabaseURL:            getEnv("DATABASE_URL", "file:greact.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"),
+		AuthSecret:             getEnv("AUTH_SECRET", "dev-secret-change-me"),
+		FrontendOrigin:         getEnv("FRONTEND_ORIGIN", "*"),
+		FrontendDist:           getEnv("FRONTEND_DIST", "../frontend/dist"),
+		AppURL:                 getEnv("APP_URL", "http://localhost:5173"),
+		JobWorkers:             getEnvInt("JOB_WORKERS", 2),
+		SMTPAddr:               getEnv("SMTP_ADDR", ""),
+		SMTPFrom:               getEnv("SMTP_FROM", "Greact-Bones <no-reply@localhost>"),
+		SMTPUsername:           getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:           getEnv("SMTP_PASSWORD", ""),
+		ShadowUpstream:         getEnv("SHADOW_UPSTREAM", ""),
+		ShadowRoutes:           getEnv("SHADOW_ROUTES", ""),
+		ShadowSampleRate:       getEnvFloat("SHADOW_SAMPLE_RATE", 0.01),
+		ShadowTrustedUpstreams: getEnv("SHADOW_TRUSTED_UPSTREAMS", ""),
+		SchemaCheck:            getEnv("SCHEMA_CHECK", "warn"),
+		TLSCertFile:            getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:             getEnv("TLS_KEY_FILE", ""),
+		TrustedProxies:         getEnv("TRUSTED_PROXIES", ""),
+		FileStorageDir:         getEnv("FILE_STORAGE_DIR", "data/files"),
+		MaxUploadBytes:         getEnvInt("MAX_UPLOAD_BYTES", 100<<20),
+		UploadDir:              getEnv("UPLOAD_DIR", "data/uploads"),
+		UploadTTLHours:         getEnvInt("UPLOAD_EXPIRY_HOURS", 24),
+		Scanner:                getEnv("SCANNER", "signatures"),
+		ClamdAddr:              getEnv("CLAMD_ADDR", "tcp://127.0.0.1:3310"),
+		ScanSignatures:         getEnv("SCAN_SIGNATURES", ""),
+		VAPIDPrivateKey:        getEnv("VAPID_PRIVATE_KEY", ""),
+		VAPIDKeyFile:           getEnv("VAPID_KEY_FILE", "data/vapid.key"),
+		VAPIDSubject:           getEnv("VAPID_SUBJECT", ""),
+		PushTTLSeconds:         getEnvInt("PUSH_TTL_SECONDS", 24*60*60),
+		Backplane:              getEnv("BACKPLANE", "memory"),
+		NATSURL:                getEnv("NATS_URL", ""),
+		NATSListen:             getEnv("NATS_LISTEN", "127.0.0.1:4222"),
+		NATSStoreDir:           getEnv("NATS_STORE_DIR", "data/nats"),
+		BanAfterStrikes:        getEnvInt("MODERATION_BAN_STRIKES", 3),
+		StrikeExpiryDays:       getEnvInt("MODERATION_STRIKE_DAYS", 90),
+		TenantDir:              getEnv("TENANT_DIR", "data/tenants"),
+		TenantMaxPools:         getEnvInt("TENANT_MAX_POOLS", 50),
+		InboundDomain:          getEnv("INBOUND_DOMAIN", ""),
+		InboundSMTPAddr:        getEnv("INBOUND_SMTP_ADDR", ""),
+		InboundSecret:          getEnv("INBOUND_WEBHOOK_SECRET", ""),
+		InboundMaxBytes:        getEnvInt("INBOUND_MAX_BYTES", 25<<20),
+		DocTemplateDir:         getEnv("DOCUMENT_TEMPLATE_DIR", ""),
+		DocFontDir:             getEnv("DOCUMENT_FONT_DIR", ""),
+		DocLinkSeconds:         getEnvInt("DOCUMENT_LINK_SECONDS", 3600),
+		DocRetentionDays:       getEnvInt("DOCUMENT_RETENTION_DAYS", 30),
+	}
+}
+
+// IsProduction reports whether the server runs with production settings.
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
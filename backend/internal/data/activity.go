@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// ActivityRepo stores the activity stream and resource follows.
+type ActivityRepo struct {
+	db DB
+}
+
+// NewActivityRepo creates an activity repository.
+func NewActivityRepo(db DB) *ActivityRepo {
+	return &ActivityRepo{db: db}
+}
+
+func init() {
+	// Activity data holds excerpts of the changed resource
+	maskTable("activities",
+		keep("id"), keep("tenant_id"), userRef("actor_id"), keep("verb"),
+		keep("resource_type"), userRefWhen("resource_id", "resource_type"), blank("data"), shiftDate("created_at"),
+	)
+	maskTable("activity_follows",
+		keep("tenant_id"), userRef("user_id"), keep("resource_type"), userRefWhen("resource_id", "resource_type"), shiftDate("created_at"),
+	)
+}
+
+func (r *ActivityRepo) Create(ctx context.Context, a *domain.Activity) error {
+	data, err := encodeJSON(a.Data)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO activities (id, tenant_id, actor_id, verb, resource_type, resource_id, data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		a.ID, a.TenantID, a.ActorID, a.Verb, a.ResourceType, a.ResourceID, data, a.CreatedAt)
+	return err
+}
+
+func (r *ActivityRepo) ListForResource(ctx context.Context, tenantID, resourceType, resourceID string, params domain.ListParams) ([]domain.Activity, int, error) {
+	const where = `tenant_id = $1 AND resource_type = $2 AND resource_id = $3`
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM activities WHERE `+where,
+		tenantID, resourceType, resourceID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	items, err := r.query(ctx, `
+		SELECT id, tenant_id, actor_id, verb, resource_type, resource_id, data, created_at
+		FROM activities WHERE `+where+`
+		ORDER BY created_at DESC, id DESC LIMIT $4 OFFSET $5`,
+		tenantID, resourceType, resourceID, params.Limit, params.Offset())
+	return items, total, err
+}
+
+func (r *ActivityRepo) ListFeed(ctx context.Context, tenantID, userID string, params domain.ListParams) ([]domain.Activity, int, error) {
+	const where = `a.tenant_id = $1 AND (a.actor_id = $2 OR EXISTS (
+		SELECT 1 FROM activity_follows f
+		WHERE f.tenant_id = a.tenant_id AND f.user_id = $2
+			AND f.resource_type = a.resource_type AND f.resource_id = a.resource_id))`
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM activities a WHERE `+where,
+		tenantID, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	items, err := r.query(ctx, `
+		SELECT a.id, a.tenant_id, a.actor_id, a.verb, a.resource_type, a.resource_id, a.data, a.created_at
+		FROM activities a WHERE `+where+`
+		ORDER BY a.created_at DESC, a.id DESC LIMIT $3 OFFSET $4`,
+		tenantID, userID, params.Limit, params.Offset())
+	return items, total, err
+}
+
+func (r *ActivityRepo) Follow(ctx context.Context, tenantID, userID, resourceType, resourceID string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO activity_follows (tenant_id, user_id, resource_type, resource_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id, user_id, resource_type, resource_id) DO NOTHING`,
+		tenantID, userID, resourceType, resourceID, at)
+	return err
+}
+
+func (r *ActivityRepo) Unfollow(ctx context.Context, tenantID, userID, resourceType, resourceID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM activity_follows
+		WHERE tenant_id = $1 AND user_id = $2 AND resource_type = $3 AND resource_id = $4`,
+		tenantID, userID, resourceType, resourceID)
+	return err
+}
+
+func (r *ActivityRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.Activity, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []domain.Activity{}
+	for rows.Next() {
+		var a domain.Activity
+		var data string
+		if err := rows.Scan(&a.ID, &a.TenantID, &a.ActorID, &a.Verb, &a.ResourceType, &a.ResourceID, &data, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(data, &a.Data); err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// SQLAdminResource manages a table through the generic admin API. Rows
+// are scoped to the caller's tenant; created_at and updated_at columns are
+// maintained when the schema declares them.
+type SQLAdminResource struct {
+	db     DB
+	schema domain.AdminResourceSchema
+	table  string
+	// softDelete names a timestamp column set on delete instead of
+	// removing the row; such rows are hidden from the admin API.
+	softDelete string
+	list       *SQLListSource
+}
+
+func newSQLAdminResource(db DB, table, softDelete string, schema domain.AdminResourceSchema) *SQLAdminResource {
+	fields := make([]domain.ListField, len(schema.Fields))
+	for i, f := range schema.Fields {
+		fields[i] = f.ListField
+	}
+	list := &SQLListSource{
+		db:           db,
+		name:         schema.Name,
+		table:        table,
+		fields:       fields,
+		defaultOrder: []domain.SortField{{Field: "id"}},
+	}
+	if _, ok := schema.Field("created_at"); ok {
+		list.defaultOrder = []domain.SortField{{Field: "created_at", Desc: true}}
+	}
+	if softDelete != "" {
+		list.extraWhere = softDelete + " IS NULL"
+	}
+	return &SQLAdminResource{db: db, schema: schema, table: table, softDelete: softDelete, list: list}
+}
+
+func (r *SQLAdminResource) Schema() domain.AdminResourceSchema { return r.schema }
+
+func (r *SQLAdminResource) List(ctx context.Context, tenantID string, q domain.ListQuery, params domain.ListParams) ([]map[string]interface{}, int, error) {
+	return r.list.Query(ctx, domain.Identity{TenantID: tenantID}, q, params)
+}
+
+func (r *SQLAdminResource) Get(ctx context.Context, tenantID, id string) (map[string]interface{}, error) {
+	names := make([]string, len(r.schema.Fields))
+	columns := make([]string, len(r.schema.Fields))
+	for i, f := range r.schema.Fields {
+		names[i], columns[i] = f.Name, f.Column
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE tenant_id = $1 AND id = $2`, strings.Join(columns, ", "), r.table)
+	if r.softDelete != "" {
+		query += " AND " + r.softDelete + " IS NULL"
+	}
+	record, err := scanRecord(r.db.QueryRowContext(ctx, query, tenantID, id), names)
+	if err != nil {
+		return nil, notFound(err, r.schema.Label)
+	}
+	return record, nil
+}
+
+func (r *SQLAdminResource) Create(ctx context.Context, tenantID string, values map[string]interface{}) (map[string]interface{}, error) {
+	id, _ := values["id"].(string)
+	if id == "" {
+		id = domain.NewID()
+	}
+	now := time.Now().UTC()
+	columns := []string{"id", "tenant_id"}
+	args := []interface{}{id, tenantID}
+	for _, f := range r.schema.Fields {
+		switch {
+		case f.Name == "created_at" || f.Name == "updated_at":
+			columns = append(columns, f.Column)
+			args = append(args, now)
+		case f.Name == "id":
+		default:
+			if v, ok := values[f.Name]; ok {
+				columns = append(columns, f.Column)
+				args = append(args, v)
+			}
+		}
+	}
+	_, err := r.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+		r.table, strings.Join(columns, ", "), placeholders(1, len(args))), args...)
+	if err != nil {
+		return nil, conflict(err)
+	}
+	return r.Get(ctx, tenantID, id)
+}
+
+func (r *SQLAdminResource) Update(ctx context.Context, tenantID, id string, values map[string]interface{}) (map[string]interface{}, error) {
+	var sets []string
+	var args []interface{}
+	for _, f := range r.schema.Fields {
+		v, ok := values[f.Name]
+		if f.Name == "updated_at" {
+			v, ok = time.Now().UTC(), true
+		}
+		if ok {
+			args = append(args, v)
+			sets = append(sets, fmt.Sprintf("%s = $%d", f.Column, len(args)))
+		}
+	}
+	if len(sets) > 0 {
+		args = append(args, tenantID, id)
+		res, err := r.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s WHERE tenant_id = $%d AND id = $%d`,
+			r.table, strings.Join(sets, ", "), len(args)-1, len(args)), args...)
+		if err != nil {
+			return nil, conflict(err)
+		}
+		if err := requireRow(res, r.schema.Label); err != nil {
+			return nil, err
+		}
+	}
+	return r.Get(ctx, tenantID, id)
+}
+
+func (r *SQLAdminResource) Delete(ctx context.Context, actor domain.Identity, id string) error {
+	var res sql.Result
+	var err error
+	if r.softDelete != "" {
+		res, err = r.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE tenant_id = $2 AND id = $3 AND %s IS NULL`,
+			r.table, r.softDelete, r.softDelete), time.Now().UTC(), actor.TenantID, id)
+	} else {
+		res, err = r.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE tenant_id = $1 AND id = $2`, r.table), actor.TenantID, id)
+	}
+	if err != nil {
+		return err
+	}
+	return requireRow(res, r.schema.Label)
+}
+
+// conflict maps unique constraint violations to the domain conflict error.
+func conflict(err error) error {
+	msg := err.Error()
+	if strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "duplicate key value") {
+		return domain.ErrConflict
+	}
+	return err
+}
+
+// adminFieldOption adjusts the metadata of an admin field.
+type adminFieldOption func(*domain.AdminField)
+
+func adminField(name, label string, t domain.FieldType, opts ...adminFieldOption) domain.AdminField {
+	f := domain.AdminField{
+		ListField: domain.ListField{Name: name, Type: t, Column: name, Filterable: true, Sortable: true, Default: true},
+		Label:     label,
+	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f
+}
+
+func readOnly(f *domain.AdminField)  { f.ReadOnly = true }
+func required(f *domain.AdminField)  { f.Required = true }
+func immutable(f *domain.AdminField) { f.Immutable = true }
+func nullable(f *domain.AdminField)  { f.Nullable = true }
+func hidden(f *domain.AdminField)    { f.Default = false }
+func unsorted(f *domain.AdminField)  { f.Sortable = false }
+
+func maxLength(n int) adminFieldOption {
+	return func(f *domain.AdminField) { f.MaxLength = n }
+}
+
+func pattern(p string) adminFieldOption {
+	return func(f *domain.AdminField) { f.Pattern = p }
+}
+
+func relation(resource string) adminFieldOption {
+	return func(f *domain.AdminField) { f.Relation = resource }
+}
+
+// NewUserAdminResource manages the user directory. Users can be created
+// ahead of their first sign-in, for example so they can be mentioned.
+func NewUserAdminResource(db DB) *SQLAdminResource {
+	return newSQLAdminResource(db, "users", "", domain.AdminResourceSchema{
+		Name:  "users",
+		Label: "User",
+		Fields: []domain.AdminField{
+			adminField("id", "ID", domain.FieldString, required, immutable, maxLength(128), pattern(`^[A-Za-z0-9_.@-]+$`)),
+			adminField("username", "Username", domain.FieldString, required, maxLength(64), pattern(`^[A-Za-z0-9_.-]+$`)),
+			adminField("email", "E-mail", domain.FieldString, maxLength(254)),
+			adminField("display_name", "Display name", domain.FieldString, maxLength(100)),
+			adminField("created_at", "Created", domain.FieldTime, readOnly),
+			adminField("updated_at", "Updated", domain.FieldTime, readOnly, hidden),
+		},
+		Actions:   []domain.AdminAction{domain.AdminRead, domain.AdminCreate, domain.AdminUpdate},
+		ReadRoles: []string{"support"},
+	})
+}
+
+// CommentAdminResource lets moderators review and remove comments.
+// Removal goes through the comment service, like a moderation decision,
+// so it is announced like any other deletion.
+type CommentAdminResource struct {
+	*SQLAdminResource
+	comments *domain.CommentService
+}
+
+// NewCommentAdminResource creates the comment admin resource.
+func NewCommentAdminResource(db DB, comments *domain.CommentService) *CommentAdminResource {
+	return &CommentAdminResource{SQLAdminResource: newCommentAdminResource(db), comments: comments}
+}
+
+func (r *CommentAdminResource) Delete(ctx context.Context, actor domain.Identity, id string) error {
+	return r.comments.RemoveModerated(ctx, actor, id)
+}
+
+func newCommentAdminResource(db DB) *SQLAdminResource {
+	return newSQLAdminResource(db, "comments", "deleted_at", domain.AdminResourceSchema{
+		Name:  "comments",
+		Label: "Comment",
+		Fields: []domain.AdminField{
+			adminField("id", "ID", domain.FieldString, readOnly),
+			adminField("resource_type", "Resource type", domain.FieldString, readOnly),
+			adminField("resource_id", "Resource ID", domain.FieldString, readOnly),
+			adminField("thread_id", "Thread", domain.FieldString, readOnly, hidden, relation("comments")),
+			adminField("parent_id", "Parent", domain.FieldString, readOnly, hidden, nullable, relation("comments")),
+			adminField("author_id", "Author", domain.FieldString, readOnly, relation("users")),
+			adminField("body", "Body", domain.FieldString, readOnly, unsorted),
+			adminField("edited_at", "Edited", domain.FieldTime, readOnly, nullable),
+			adminField("hidden_at", "Hidden", domain.FieldTime, readOnly, nullable),
+			adminField("created_at", "Created", domain.FieldTime, readOnly),
+		},
+		Actions:    []domain.AdminAction{domain.AdminRead, domain.AdminDelete},
+		WriteRoles: []string{"moderator"},
+	})
+}
+
+// NewSavedViewAdminResource manages saved views across owners.
+func NewSavedViewAdminResource(db DB) *SQLAdminResource {
+	return newSQLAdminResource(db, "saved_views", "", domain.AdminResourceSchema{
+		Name:  "saved_views",
+		Label: "Saved view",
+		Fields: []domain.AdminField{
+			adminField("id", "ID", domain.FieldString, readOnly),
+			adminField("owner_id", "Owner", domain.FieldString, readOnly, relation("users")),
+			adminField("name", "Name", domain.FieldString, required, maxLength(100)),
+			adminField("source", "Source", domain.FieldString, readOnly),
+			adminField("created_at", "Created", domain.FieldTime, readOnly),
+			adminField("updated_at", "Updated", domain.FieldTime, readOnly),
+		},
+		Actions: []domain.AdminAction{domain.AdminRead, domain.AdminUpdate, domain.AdminDelete},
+	})
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// AuditRepo stores the audit log.
+type AuditRepo struct {
+	db DB
+}
+
+// NewAuditRepo creates an audit repository.
+func NewAuditRepo(db DB) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+func init() {
+	// Audit changes record the values before and after, personal data
+	// included
+	skipTable("audit_log")
+}
+
+func (r *AuditRepo) Create(ctx context.Context, e *domain.AuditEntry) error {
+	changes, err := encodeJSON(e.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO audit_log (id, tenant_id, actor_id, action, resource_type, resource_id, changes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		e.ID, e.TenantID, e.ActorID, e.Action, e.ResourceType, e.ResourceID, changes, e.CreatedAt)
+	return err
+}
+
+func (r *AuditRepo) List(ctx context.Context, tenantID string, f domain.AuditFilter, params domain.ListParams) ([]domain.AuditEntry, int, error) {
+	args := []interface{}{tenantID}
+	where := []string{"tenant_id = $1"}
+	for column, value := range map[string]string{
+		"actor_id":      f.ActorID,
+		"action":        f.Action,
+		"resource_type": f.ResourceType,
+		"resource_id":   f.ResourceID,
+	} {
+		if value != "" {
+			args = append(args, value)
+			where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, params.Limit, params.Offset())
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, tenant_id, actor_id, action, resource_type, resource_id, changes, created_at
+		FROM audit_log WHERE %s
+		ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`, whereSQL, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []domain.AuditEntry{}
+	for rows.Next() {
+		var e domain.AuditEntry
+		var changes string
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.ActorID, &e.Action, &e.ResourceType, &e.ResourceID, &changes, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		if err := decodeJSON(changes, &e.Changes); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, e)
+	}
+	return items, total, rows.Err()
+}
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const calendarEntryColumns = `id, tenant_id, owner_id, uid, kind, title, description, location, starts_at, ends_at,
+	all_day, time_zone, rrule, exdates, completed_at, created_at, updated_at`
+
+const calendarFeedColumns = `id, tenant_id, user_id, name, last_used_at, revoked_at, created_at`
+
+// CalendarRepo stores calendar entries and the feeds publishing them.
+type CalendarRepo struct {
+	db DB
+}
+
+// NewCalendarRepo creates a calendar repository.
+func NewCalendarRepo(db DB) *CalendarRepo {
+	return &CalendarRepo{db: db}
+}
+
+func init() {
+	maskTable("calendar_entries",
+		keep("id"), keep("tenant_id"), userRef("owner_id"), hashed("uid"), keep("kind"), fakeText("title"),
+		blank("description"), blank("location"), shiftDate("starts_at"), shiftDate("ends_at"), keep("all_day"),
+		keep("time_zone"), keep("rrule"), emptyList("exdates"), shiftDate("completed_at"),
+		shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("calendar_feeds",
+		keep("id"), keep("tenant_id"), userRef("user_id"), fakeText("name"), hashed("token_hash"),
+		shiftDate("last_used_at"), shiftDate("revoked_at"), shiftDate("created_at"),
+	)
+}
+
+// emptyList clears a JSON array column whose values would need masking
+// element by element, like excluded dates that would no longer match
+// their shifted series.
+func emptyList(column string) MaskRule {
+	return MaskRule{column, "empty_list", func(*masker, interface{}, map[string]interface{}) interface{} {
+		return "[]"
+	}}
+}
+
+func (r *CalendarRepo) Create(ctx context.Context, e *domain.CalendarEntry) error {
+	return r.insert(ctx, r.db, e)
+}
+
+func (r *CalendarRepo) Get(ctx context.Context, tenantID, id string) (*domain.CalendarEntry, error) {
+	entries, err := r.query(ctx, `SELECT `+calendarEntryColumns+` FROM calendar_entries WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, domain.NotFound("Calendar entry")
+	}
+	return &entries[0], nil
+}
+
+func (r *CalendarRepo) List(ctx context.Context, tenantID, ownerID string, params domain.ListParams) ([]domain.CalendarEntry, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM calendar_entries WHERE tenant_id = $1 AND owner_id = $2`,
+		tenantID, ownerID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	entries, err := r.query(ctx, `SELECT `+calendarEntryColumns+` FROM calendar_entries WHERE tenant_id = $1 AND owner_id = $2
+		ORDER BY starts_at, id LIMIT $3 OFFSET $4`, tenantID, ownerID, params.Limit, params.Offset())
+	return entries, total, err
+}
+
+func (r *CalendarRepo) Update(ctx context.Context, e *domain.CalendarEntry) error {
+	res, err := r.update(ctx, r.db, e)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Calendar entry")
+}
+
+func (r *CalendarRepo) Delete(ctx context.Context, tenantID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM calendar_entries WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Calendar entry")
+}
+
+func (r *CalendarRepo) InRange(ctx context.Context, tenantID, ownerID string, from, to time.Time) ([]domain.CalendarEntry, error) {
+	return r.query(ctx, `SELECT `+calendarEntryColumns+` FROM calendar_entries
+		WHERE tenant_id = $1 AND owner_id = $2 AND starts_at < $3
+		AND (rrule != '' OR COALESCE(ends_at, starts_at) >= $4)
+		ORDER BY starts_at, id`, tenantID, ownerID, to, from)
+}
+
+func (r *CalendarRepo) Upsert(ctx context.Context, e *domain.CalendarEntry) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `SELECT id, created_at FROM calendar_entries WHERE tenant_id = $1 AND owner_id = $2 AND uid = $3`,
+		e.TenantID, e.OwnerID, e.UID).Scan(&id, &createdAt)
+	created := errors.Is(err, sql.ErrNoRows)
+	switch {
+	case created:
+		err = r.insert(ctx, tx, e)
+	case err == nil:
+		e.ID, e.CreatedAt = id, createdAt
+		_, err = r.update(ctx, tx, e)
+	}
+	if err != nil {
+		return false, err
+	}
+	return created, tx.Commit()
+}
+
+func (r *CalendarRepo) CreateFeed(ctx context.Context, f *domain.CalendarFeed, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO calendar_feeds (id, tenant_id, user_id, name, token_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		f.ID, f.TenantID, f.UserID, f.Name, tokenHash, f.CreatedAt)
+	return err
+}
+
+func (r *CalendarRepo) ListFeeds(ctx context.Context, tenantID, userID string) ([]domain.CalendarFeed, error) {
+	return r.queryFeeds(ctx, `SELECT `+calendarFeedColumns+` FROM calendar_feeds WHERE tenant_id = $1 AND user_id = $2
+		ORDER BY created_at DESC, id`, tenantID, userID)
+}
+
+func (r *CalendarRepo) FeedByToken(ctx context.Context, tokenHash string) (*domain.CalendarFeed, error) {
+	feeds, err := r.queryFeeds(ctx, `SELECT `+calendarFeedColumns+` FROM calendar_feeds WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(feeds) == 0 {
+		return nil, domain.NotFound("Calendar feed")
+	}
+	return &feeds[0], nil
+}
+
+func (r *CalendarRepo) RevokeFeed(ctx context.Context, tenantID, userID, id string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE calendar_feeds SET revoked_at = COALESCE(revoked_at, $1)
+		WHERE tenant_id = $2 AND user_id = $3 AND id = $4`, at, tenantID, userID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Calendar feed")
+}
+
+func (r *CalendarRepo) TouchFeed(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE calendar_feeds SET last_used_at = $1 WHERE id = $2`, at, id)
+	return err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (r *CalendarRepo) insert(ctx context.Context, db execer, e *domain.CalendarEntry) error {
+	exdates, err := encodeJSON(e.ExDates)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO calendar_entries (`+calendarEntryColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		e.ID, e.TenantID, e.OwnerID, e.UID, e.Kind, e.Title, e.Description, e.Location, e.StartsAt, nullTime(e.EndsAt),
+		e.AllDay, e.TimeZone, e.RRule, exdates, nullTime(e.CompletedAt), e.CreatedAt, e.UpdatedAt)
+	return err
+}
+
+func (r *CalendarRepo) update(ctx context.Context, db execer, e *domain.CalendarEntry) (sql.Result, error) {
+	exdates, err := encodeJSON(e.ExDates)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, `
+		UPDATE calendar_entries SET kind = $1, title = $2, description = $3, location = $4, starts_at = $5, ends_at = $6,
+			all_day = $7, time_zone = $8, rrule = $9, exdates = $10, completed_at = $11, updated_at = $12
+		WHERE tenant_id = $13 AND id = $14`,
+		e.Kind, e.Title, e.Description, e.Location, e.StartsAt, nullTime(e.EndsAt),
+		e.AllDay, e.TimeZone, e.RRule, exdates, nullTime(e.CompletedAt), e.UpdatedAt, e.TenantID, e.ID)
+}
+
+func (r *CalendarRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.CalendarEntry, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []domain.CalendarEntry{}
+	for rows.Next() {
+		var e domain.CalendarEntry
+		var endsAt, completedAt sql.NullTime
+		var exdates string
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.OwnerID, &e.UID, &e.Kind, &e.Title, &e.Description, &e.Location,
+			&e.StartsAt, &endsAt, &e.AllDay, &e.TimeZone, &e.RRule, &exdates, &completedAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		e.EndsAt = timePtr(endsAt)
+		e.CompletedAt = timePtr(completedAt)
+		e.ExDates = []time.Time{}
+		if err := decodeJSON(exdates, &e.ExDates); err != nil {
+			return nil, fmt.Errorf("calendar entry %s: %w", e.ID, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (r *CalendarRepo) queryFeeds(ctx context.Context, query string, args ...interface{}) ([]domain.CalendarFeed, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []domain.CalendarFeed{}
+	for rows.Next() {
+		var f domain.CalendarFeed
+		var lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&f.ID, &f.TenantID, &f.UserID, &f.Name, &lastUsedAt, &revokedAt, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		f.LastUsedAt = timePtr(lastUsedAt)
+		f.RevokedAt = timePtr(revokedAt)
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/yjs"
+)
+
+const collabDocumentColumns = `d.id, d.tenant_id, d.owner_id, d.title, d.compacted_at, d.created_at, d.updated_at,
+	(SELECT COUNT(*) FROM collab_updates u WHERE u.document_id = d.id)`
+
+// CollabRepo stores collaborative documents, their team shares and the
+// Yjs updates received since the last compaction.
+type CollabRepo struct {
+	db DB
+}
+
+// NewCollabRepo creates a collaborative document repository.
+func NewCollabRepo(db DB) *CollabRepo {
+	return &CollabRepo{db: db}
+}
+
+func init() {
+	maskTable("collab_documents",
+		keep("id"), keep("tenant_id"), userRef("owner_id"), fakeText("title"), emptyDocument("state"),
+		keep("state_version"), shiftDate("compacted_at"), shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("collab_document_shares", keep("document_id"), hashed("team_id"), keep("access"))
+	skipTable("collab_updates")
+}
+
+// emptyDocument replaces document content, which cannot be masked
+// selectively, with an empty Yjs update.
+func emptyDocument(column string) MaskRule {
+	return MaskRule{column, "empty_document", func(*masker, interface{}, map[string]interface{}) interface{} {
+		return yjs.EmptyUpdate
+	}}
+}
+
+func (r *CollabRepo) Create(ctx context.Context, d *domain.CollabDocument, snapshot []byte) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO collab_documents (id, tenant_id, owner_id, title, state, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		d.ID, d.TenantID, d.OwnerID, d.Title, snapshot, d.CreatedAt, d.UpdatedAt); err != nil {
+		return err
+	}
+	if err := replaceCollabShares(ctx, tx, d.ID, d.Shares); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *CollabRepo) Get(ctx context.Context, tenantID, id string) (*domain.CollabDocument, error) {
+	docs, err := r.query(ctx, `SELECT `+collabDocumentColumns+` FROM collab_documents d WHERE d.tenant_id = $1 AND d.id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, domain.NotFound("Document")
+	}
+	return &docs[0], nil
+}
+
+func (r *CollabRepo) ListVisible(ctx context.Context, tenantID, userID string, teams []string, params domain.ListParams) ([]domain.CollabDocument, int, error) {
+	where := `d.tenant_id = $1 AND d.owner_id = $2`
+	args := []interface{}{tenantID, userID}
+	if len(teams) > 0 {
+		where = `d.tenant_id = $1 AND (d.owner_id = $2 OR d.id IN (
+			SELECT document_id FROM collab_document_shares WHERE team_id IN (` + placeholders(3, len(teams)) + `)))`
+		args = append(args, stringArgs(teams)...)
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM collab_documents d WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	n := len(args)
+	args = append(args, params.Limit, params.Offset())
+	docs, err := r.query(ctx, fmt.Sprintf(`SELECT `+collabDocumentColumns+` FROM collab_documents d WHERE `+where+`
+		ORDER BY d.updated_at DESC, d.id LIMIT $%d OFFSET $%d`, n+1, n+2), args...)
+	return docs, total, err
+}
+
+func (r *CollabRepo) Update(ctx context.Context, d *domain.CollabDocument) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE collab_documents SET title = $1, updated_at = $2 WHERE tenant_id = $3 AND id = $4`,
+		d.Title, d.UpdatedAt, d.TenantID, d.ID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Document"); err != nil {
+		return err
+	}
+	if err := replaceCollabShares(ctx, tx, d.ID, d.Shares); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *CollabRepo) Delete(ctx context.Context, tenantID, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"collab_document_shares", "collab_updates"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE document_id = $1`, id); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM collab_documents WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Document"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *CollabRepo) AppendUpdate(ctx context.Context, tenantID, documentID string, u domain.CollabUpdate, at time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE collab_documents SET updated_at = $1 WHERE tenant_id = $2 AND id = $3`, at, tenantID, documentID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Document"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO collab_updates (id, document_id, data, created_at) VALUES ($1, $2, $3, $4)`,
+		u.ID, documentID, u.Data, at); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *CollabRepo) State(ctx context.Context, tenantID, id string) (*domain.CollabState, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	st := &domain.CollabState{}
+	err = tx.QueryRowContext(ctx, `SELECT state, state_version FROM collab_documents WHERE tenant_id = $1 AND id = $2`,
+		tenantID, id).Scan(&st.Snapshot, &st.Version)
+	if err != nil {
+		return nil, notFound(err, "Document")
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, data FROM collab_updates WHERE document_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u domain.CollabUpdate
+		if err := rows.Scan(&u.ID, &u.Data); err != nil {
+			return nil, err
+		}
+		st.Updates = append(st.Updates, u)
+	}
+	return st, rows.Err()
+}
+
+func (r *CollabRepo) SaveSnapshot(ctx context.Context, tenantID, id string, version int, snapshot []byte, merged []string, at time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE collab_documents SET state = $1, state_version = state_version + 1, compacted_at = $2
+		WHERE tenant_id = $3 AND id = $4 AND state_version = $5`,
+		snapshot, at, tenantID, id, version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.ErrConflict
+	}
+	for start := 0; start < len(merged); start += 500 {
+		batch := merged[start:min(start+500, len(merged))]
+		if _, err := tx.ExecContext(ctx, `DELETE FROM collab_updates WHERE id IN (`+placeholders(1, len(batch))+`)`,
+			stringArgs(batch)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *CollabRepo) NeedingCompaction(ctx context.Context, minUpdates, limit int) ([]domain.CollabDocument, error) {
+	return r.query(ctx, `SELECT `+collabDocumentColumns+` FROM collab_documents d
+		WHERE (SELECT COUNT(*) FROM collab_updates u WHERE u.document_id = d.id) >= $1
+		ORDER BY d.updated_at LIMIT $2`, minUpdates, limit)
+}
+
+func (r *CollabRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.CollabDocument, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []domain.CollabDocument{}
+	for rows.Next() {
+		var d domain.CollabDocument
+		var compactedAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.TenantID, &d.OwnerID, &d.Title, &compactedAt, &d.CreatedAt, &d.UpdatedAt, &d.PendingUpdates); err != nil {
+			return nil, err
+		}
+		d.CompactedAt = timePtr(compactedAt)
+		d.Shares = []domain.CollabShare{}
+		docs = append(docs, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return docs, r.loadShares(ctx, docs)
+}
+
+func (r *CollabRepo) loadShares(ctx context.Context, docs []domain.CollabDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	index := make(map[string]*domain.CollabDocument, len(docs))
+	ids := make([]string, len(docs))
+	for i := range docs {
+		index[docs[i].ID] = &docs[i]
+		ids[i] = docs[i].ID
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT document_id, team_id, access FROM collab_document_shares
+		WHERE document_id IN (`+placeholders(1, len(ids))+`) ORDER BY team_id`, stringArgs(ids)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var documentID string
+		var share domain.CollabShare
+		if err := rows.Scan(&documentID, &share.TeamID, &share.Access); err != nil {
+			return err
+		}
+		index[documentID].Shares = append(index[documentID].Shares, share)
+	}
+	return rows.Err()
+}
+
+func replaceCollabShares(ctx context.Context, tx *sql.Tx, documentID string, shares []domain.CollabShare) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM collab_document_shares WHERE document_id = $1`, documentID); err != nil {
+		return err
+	}
+	for _, share := range shares {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO collab_document_shares (document_id, team_id, access) VALUES ($1, $2, $3)`,
+			documentID, share.TeamID, share.Access); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const commentColumns = `c.id, c.tenant_id, c.resource_type, c.resource_id, c.thread_id, c.parent_id,
+	c.author_id, c.body, c.edited_at, c.hidden_at, c.deleted_at, c.created_at, c.updated_at`
+
+// CommentRepo stores comments with their revisions, mentions and reactions.
+type CommentRepo struct {
+	db DB
+}
+
+// NewCommentRepo creates a comment repository.
+func NewCommentRepo(db DB) *CommentRepo {
+	return &CommentRepo{db: db}
+}
+
+func init() {
+	maskTable("comments",
+		keep("id"), keep("tenant_id"), keep("resource_type"), userRefWhen("resource_id", "resource_type"),
+		keep("thread_id"), keep("parent_id"), userRef("author_id"), fakeText("body"),
+		shiftDate("edited_at"), shiftDate("hidden_at"), shiftDate("deleted_at"),
+		shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("comment_revisions",
+		keep("id"), keep("comment_id"), fakeText("body"), userRef("editor_id"), shiftDate("created_at"),
+	)
+	maskTable("comment_mentions", keep("comment_id"), userRef("user_id"))
+	maskTable("comment_reactions", keep("comment_id"), userRef("user_id"), keep("emoji"), shiftDate("created_at"))
+}
+
+func (r *CommentRepo) Create(ctx context.Context, c *domain.Comment) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO comments (id, tenant_id, resource_type, resource_id, thread_id, parent_id, author_id, body, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		c.ID, c.TenantID, c.ResourceType, c.ResourceID, c.ThreadID, nullString(c.ParentID), c.AuthorID, c.Body, c.CreatedAt, c.UpdatedAt)
+	return err
+}
+
+func (r *CommentRepo) Get(ctx context.Context, tenantID, id string) (*domain.Comment, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+commentColumns+`,
+		(SELECT COUNT(*) FROM comments r WHERE r.thread_id = c.id AND r.id <> c.id)
+		FROM comments c WHERE c.tenant_id = $1 AND c.id = $2`, tenantID, id)
+	c, err := scanComment(row)
+	if err != nil {
+		return nil, notFound(err, "Comment")
+	}
+	comments := []domain.Comment{*c}
+	if err := r.loadDetails(ctx, comments); err != nil {
+		return nil, err
+	}
+	return &comments[0], nil
+}
+
+func (r *CommentRepo) ListThreads(ctx context.Context, tenantID, resourceType, resourceID string, params domain.ListParams) ([]domain.Comment, int, error) {
+	var total int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM comments
+		WHERE tenant_id = $1 AND resource_type = $2 AND resource_id = $3 AND parent_id IS NULL`,
+		tenantID, resourceType, resourceID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	comments, err := r.query(ctx, `SELECT `+commentColumns+`,
+		(SELECT COUNT(*) FROM comments r WHERE r.thread_id = c.id AND r.id <> c.id)
+		FROM comments c
+		WHERE c.tenant_id = $1 AND c.resource_type = $2 AND c.resource_id = $3 AND c.parent_id IS NULL
+		ORDER BY c.created_at, c.id LIMIT $4 OFFSET $5`,
+		tenantID, resourceType, resourceID, params.Limit, params.Offset())
+	return comments, total, err
+}
+
+func (r *CommentRepo) ListReplies(ctx context.Context, tenantID, threadID string, params domain.ListParams) ([]domain.Comment, int, error) {
+	var total int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM comments WHERE tenant_id = $1 AND thread_id = $2 AND id <> $2`,
+		tenantID, threadID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	comments, err := r.query(ctx, `SELECT `+commentColumns+`, 0
+		FROM comments c
+		WHERE c.tenant_id = $1 AND c.thread_id = $2 AND c.id <> $2
+		ORDER BY c.created_at, c.id LIMIT $3 OFFSET $4`,
+		tenantID, threadID, params.Limit, params.Offset())
+	return comments, total, err
+}
+
+func (r *CommentRepo) UpdateBody(ctx context.Context, c *domain.Comment, previous domain.CommentRevision) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO comment_revisions (id, comment_id, body, editor_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		previous.ID, previous.CommentID, previous.Body, previous.EditorID, previous.CreatedAt); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE comments SET body = $1, edited_at = $2, updated_at = $3 WHERE id = $4`,
+		c.Body, nullTime(c.EditedAt), c.UpdatedAt, c.ID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Comment"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *CommentRepo) SoftDelete(ctx context.Context, tenantID, id string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE comments SET body = '', deleted_at = $1, updated_at = $1
+		WHERE tenant_id = $2 AND id = $3`, at, tenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Comment"); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM comment_mentions WHERE comment_id = $1`, id); err != nil {
+		return err
+	}
+	// Earlier versions would give the deleted text away.
+	_, err = r.db.ExecContext(ctx, `DELETE FROM comment_revisions WHERE comment_id = $1`, id)
+	return err
+}
+
+func (r *CommentRepo) SetHidden(ctx context.Context, tenantID, id string, at *time.Time) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE comments SET hidden_at = $1 WHERE tenant_id = $2 AND id = $3`,
+		nullTime(at), tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Comment")
+}
+
+func (r *CommentRepo) Revisions(ctx context.Context, tenantID, commentID string) ([]domain.CommentRevision, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT v.id, v.comment_id, v.body, v.editor_id, v.created_at
+		FROM comment_revisions v JOIN comments c ON c.id = v.comment_id
+		WHERE c.tenant_id = $1 AND v.comment_id = $2
+		ORDER BY v.created_at DESC, v.id DESC`, tenantID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []domain.CommentRevision{}
+	for rows.Next() {
+		var v domain.CommentRevision
+		if err := rows.Scan(&v.ID, &v.CommentID, &v.Body, &v.EditorID, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, v)
+	}
+	return revisions, rows.Err()
+}
+
+func (r *CommentRepo) SetMentions(ctx context.Context, commentID string, userIDs []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comment_mentions WHERE comment_id = $1`, commentID); err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO comment_mentions (comment_id, user_id) VALUES ($1, $2)`, commentID, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *CommentRepo) AddReaction(ctx context.Context, commentID, userID, emoji string, at time.Time) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO comment_reactions (comment_id, user_id, emoji, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (comment_id, user_id, emoji) DO NOTHING`, commentID, userID, emoji, at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *CommentRepo) RemoveReaction(ctx context.Context, commentID, userID, emoji string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM comment_reactions WHERE comment_id = $1 AND user_id = $2 AND emoji = $3`, commentID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *CommentRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.Comment, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []domain.Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, r.loadDetails(ctx, comments)
+}
+
+// loadDetails fills in the mentions and reaction summaries of comments.
+func (r *CommentRepo) loadDetails(ctx context.Context, comments []domain.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	index := make(map[string]*domain.Comment, len(comments))
+	ids := make([]string, len(comments))
+	for i := range comments {
+		c := &comments[i]
+		c.Mentions = []string{}
+		c.Reactions = []domain.ReactionSummary{}
+		index[c.ID] = c
+		ids[i] = c.ID
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT comment_id, user_id FROM comment_mentions
+		WHERE comment_id IN (`+placeholders(1, len(ids))+`) ORDER BY user_id`, stringArgs(ids)...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var commentID, userID string
+		if err := rows.Scan(&commentID, &userID); err != nil {
+			rows.Close()
+			return err
+		}
+		index[commentID].Mentions = append(index[commentID].Mentions, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = r.db.QueryContext(ctx, `
+		SELECT comment_id, emoji, user_id FROM comment_reactions
+		WHERE comment_id IN (`+placeholders(1, len(ids))+`) ORDER BY created_at, user_id`, stringArgs(ids)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var commentID, emoji, userID string
+		if err := rows.Scan(&commentID, &emoji, &userID); err != nil {
+			return err
+		}
+		c := index[commentID]
+		found := false
+		for i := range c.Reactions {
+			if c.Reactions[i].Emoji == emoji {
+				c.Reactions[i].Count++
+				c.Reactions[i].UserIDs = append(c.Reactions[i].UserIDs, userID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.Reactions = append(c.Reactions, domain.ReactionSummary{Emoji: emoji, Count: 1, UserIDs: []string{userID}})
+		}
+	}
+	return rows.Err()
+}
+
+func scanComment(s scanner) (*domain.Comment, error) {
+	var c domain.Comment
+	var parentID sql.NullString
+	var editedAt, hiddenAt, deletedAt sql.NullTime
+	err := s.Scan(&c.ID, &c.TenantID, &c.ResourceType, &c.ResourceID, &c.ThreadID, &parentID,
+		&c.AuthorID, &c.Body, &editedAt, &hiddenAt, &deletedAt, &c.CreatedAt, &c.UpdatedAt, &c.ReplyCount)
+	if err != nil {
+		return nil, err
+	}
+	c.ParentID = parentID.String
+	c.EditedAt = timePtr(editedAt)
+	c.HiddenAt = timePtr(hiddenAt)
+	c.DeletedAt = timePtr(deletedAt)
+	return &c, nil
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Open connects to the database and verifies the connection. Both SQLite
+// (driver "sqlite") and PostgreSQL are supported; queries use $N
+// placeholders, which both understand.
+func Open(driver, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure connection pool
+	db.SetMaxIdleConns(10)
+	db.SetMaxOpenConns(100)
+	db.SetConnMaxLifetime(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to %s database: %w", driver, err)
+	}
+	return db, nil
+}
+
+// Migration is a schema change embedded in the binary.
+type Migration struct {
+	Version string
+	SQL     string
+	// NoTransaction is set by a "-- migrate:no-transaction" line. Such
+	// migrations run statement by statement outside a transaction, which
+	// CREATE INDEX CONCURRENTLY requires.
+	NoTransaction bool
+}
+
+const noTransactionDirective = "-- migrate:no-transaction"
+
+// Migrations returns the embedded migrations in the order they apply.
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	migrations := make([]Migration, 0, len(names))
+	for _, name := range names {
+		body, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		migrations = append(migrations, Migration{
+			Version:       version,
+			SQL:           string(body),
+			NoTransaction: strings.Contains(string(body), noTransactionDirective),
+		})
+	}
+	return migrations, nil
+}
+
+// Migrate applies every embedded migration that has not been applied yet.
+// Each migration runs in its own transaction.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied, err := AppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("apply migration %s: %w", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// AppliedMigrations returns the set of migration versions recorded in the
+// database.
+func AppliedMigrations(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m Migration) error {
+	// SQLite builds indexes under its database lock either way
+	if _, ok := db.Driver().(*sqlite.Driver); ok {
+		m.SQL = strings.ReplaceAll(m.SQL, " CONCURRENTLY", "")
+	}
+
+	if m.NoTransaction {
+		// A failure leaves the statements before it applied, so they
+		// should be idempotent (IF NOT EXISTS)
+		for _, stmt := range splitStatements(m.SQL) {
+			if _, err := db.ExecContext(ctx, stmt.sql); err != nil {
+				return fmt.Errorf("line %d: %w", stmt.line, err)
+			}
+		}
+		_, err := db.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, m.Version, time.Now().UTC())
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, m.Version, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const documentColumns = `id, tenant_id, owner_id, template, name, source, status, error, file_id, pages, size,
+	created_at, completed_at, expires_at`
+
+// DocumentRepo stores generated documents.
+type DocumentRepo struct {
+	db DB
+}
+
+// NewDocumentRepo creates a document repository.
+func NewDocumentRepo(db DB) *DocumentRepo {
+	return &DocumentRepo{db: db}
+}
+
+func init() {
+	maskTable("documents",
+		keep("id"), keep("tenant_id"), userRef("owner_id"), keep("template"), fakeText("name"), keep("source"),
+		keep("status"), blank("error"), keep("file_id"), keep("pages"), keep("size"), blank("request"),
+		shiftDate("created_at"), shiftDate("completed_at"), shiftDate("expires_at"),
+	)
+}
+
+func (r *DocumentRepo) Create(ctx context.Context, d *domain.Document, request []byte) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO documents (`+documentColumns+`, request)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		d.ID, d.TenantID, d.OwnerID, d.Template, d.Name, d.Source, d.Status, d.Error, d.FileID, d.Pages, d.Size,
+		d.CreatedAt, nullTime(d.CompletedAt), d.ExpiresAt, string(request))
+	return err
+}
+
+func (r *DocumentRepo) Get(ctx context.Context, tenantID, id string) (*domain.Document, error) {
+	docs, err := r.query(ctx, `SELECT `+documentColumns+` FROM documents WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, domain.NotFound("Document")
+	}
+	return &docs[0], nil
+}
+
+func (r *DocumentRepo) Request(ctx context.Context, tenantID, id string) ([]byte, error) {
+	var request string
+	err := r.db.QueryRowContext(ctx, `SELECT request FROM documents WHERE tenant_id = $1 AND id = $2`, tenantID, id).Scan(&request)
+	if err != nil {
+		return nil, notFound(err, "Document")
+	}
+	return []byte(request), nil
+}
+
+func (r *DocumentRepo) Finish(ctx context.Context, d *domain.Document) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE documents SET status = $1, error = $2, file_id = $3, pages = $4, size = $5,
+		completed_at = $6, request = '' WHERE tenant_id = $7 AND id = $8`,
+		d.Status, d.Error, d.FileID, d.Pages, d.Size, nullTime(d.CompletedAt), d.TenantID, d.ID)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Document")
+}
+
+func (r *DocumentRepo) List(ctx context.Context, tenantID, ownerID string, params domain.ListParams) ([]domain.Document, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM documents WHERE tenant_id = $1 AND owner_id = $2`,
+		tenantID, ownerID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	docs, err := r.query(ctx, `SELECT `+documentColumns+` FROM documents WHERE tenant_id = $1 AND owner_id = $2
+		ORDER BY created_at DESC, id LIMIT $3 OFFSET $4`, tenantID, ownerID, params.Limit, params.Offset())
+	return docs, total, err
+}
+
+func (r *DocumentRepo) Delete(ctx context.Context, tenantID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM documents WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Document")
+}
+
+func (r *DocumentRepo) Expired(ctx context.Context, now time.Time, limit int) ([]domain.Document, error) {
+	return r.query(ctx, `SELECT `+documentColumns+` FROM documents WHERE expires_at < $1 ORDER BY expires_at LIMIT $2`, now, limit)
+}
+
+func (r *DocumentRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.Document, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []domain.Document{}
+	for rows.Next() {
+		var d domain.Document
+		var fileID sql.NullString
+		var completedAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.TenantID, &d.OwnerID, &d.Template, &d.Name, &d.Source, &d.Status, &d.Error,
+			&fileID, &d.Pages, &d.Size, &d.CreatedAt, &completedAt, &d.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if fileID.Valid {
+			d.FileID = &fileID.String
+		}
+		d.CompletedAt = timePtr(completedAt)
+		docs = append(docs, d)
+	}
+	return docs, rows.Err()
+}
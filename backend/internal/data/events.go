@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const eventLogColumns = `position, id, type, version, tenant_id, actor_id, resource_type, resource_id, data, occurred_at`
+
+// eventLogAttempts bounds how often Append retries a position another
+// writer took first.
+const eventLogAttempts = 10
+
+// EventLogRepo stores published events in order. Positions are assigned
+// as one more than the newest; a writer that loses the race for a
+// position takes the next one, so positions are gapless and committed in
+// order.
+type EventLogRepo struct {
+	db DB
+}
+
+// NewEventLogRepo creates an event log repository.
+func NewEventLogRepo(db DB) *EventLogRepo {
+	return &EventLogRepo{db: db}
+}
+
+func init() {
+	maskTable("event_log",
+		keep("position"), keep("id"), keep("type"), keep("version"), keep("tenant_id"), userRef("actor_id"),
+		keep("resource_type"), userRefWhen("resource_id", "resource_type"), blank("data"), shiftDate("occurred_at"),
+	)
+}
+
+func (r *EventLogRepo) Append(ctx context.Context, e domain.Event) (int64, error) {
+	data, err := encodeJSON(e.Data)
+	if err != nil {
+		return 0, err
+	}
+	for attempt := 1; ; attempt++ {
+		var position int64
+		err := r.db.QueryRowContext(ctx, `
+			INSERT INTO event_log (`+eventLogColumns+`)
+			SELECT COALESCE(MAX(position), 0) + 1, $1, $2, $3, $4, $5, $6, $7, $8, $9 FROM event_log
+			RETURNING position`,
+			e.ID, e.Type, e.Version, e.TenantID, e.ActorID, e.ResourceType, e.ResourceID, data, e.OccurredAt.UTC()).Scan(&position)
+		if err == nil {
+			return position, nil
+		}
+		var appErr domain.AppError
+		if !errors.As(conflict(err), &appErr) || attempt == eventLogAttempts {
+			return 0, err
+		}
+	}
+}
+
+func (r *EventLogRepo) Read(ctx context.Context, after int64, limit int) ([]domain.LoggedEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+eventLogColumns+` FROM event_log WHERE position > $1 ORDER BY position LIMIT $2`,
+		after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []domain.LoggedEvent{}
+	for rows.Next() {
+		var e domain.LoggedEvent
+		var data string
+		var occurredAt time.Time
+		if err := rows.Scan(&e.Position, &e.ID, &e.Type, &e.Version, &e.TenantID, &e.ActorID,
+			&e.ResourceType, &e.ResourceID, &data, &occurredAt); err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(data, &e.Data); err != nil {
+			return nil, err
+		}
+		e.OccurredAt = occurredAt.UTC()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *EventLogRepo) Head(ctx context.Context) (int64, error) {
+	var head int64
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(position), 0) FROM event_log`).Scan(&head)
+	return head, err
+}
@@ -0,0 +1,251 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const fileColumns = `id, tenant_id, owner_id, name, content_type, size, blob_hash, created_at`
+
+// fileSelect reads files with the scan state of their content.
+const fileSelect = `SELECT f.id, f.tenant_id, f.owner_id, f.name, f.content_type, f.size, f.blob_hash,
+	b.scan_status, b.scan_signature, f.created_at FROM files f JOIN blobs b ON b.hash = f.blob_hash`
+
+const blobColumns = `hash, size, ref_count, scan_status, scan_signature, scanner_version, scanned_at, created_at, updated_at`
+
+// FileRepo stores file records and the reference counts of the blobs
+// holding their content.
+type FileRepo struct {
+	db DB
+}
+
+// NewFileRepo creates a file repository.
+func NewFileRepo(db DB) *FileRepo {
+	return &FileRepo{db: db}
+}
+
+func init() {
+	maskTable("files",
+		keep("id"), keep("tenant_id"), userRef("owner_id"), fakeText("name"), keep("content_type"), keep("size"),
+		keep("blob_hash"), shiftDate("created_at"),
+	)
+	maskTable("blobs",
+		keep("hash"), keep("size"), keep("ref_count"), keep("scan_status"), keep("scan_signature"), keep("scanner_version"),
+		shiftDate("scanned_at"), shiftDate("created_at"), shiftDate("updated_at"),
+	)
+}
+
+func (r *FileRepo) Create(ctx context.Context, f *domain.File) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO blobs (hash, size, ref_count, scan_status, created_at, updated_at) VALUES ($1, $2, 1, $3, $4, $4)
+		ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1, updated_at = $4
+		RETURNING scan_status, scan_signature`,
+		f.SHA256, f.Size, f.ScanStatus, f.CreatedAt).Scan(&f.ScanStatus, &f.ScanSignature); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO files (`+fileColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		f.ID, f.TenantID, f.OwnerID, f.Name, f.ContentType, f.Size, f.SHA256, f.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *FileRepo) Get(ctx context.Context, tenantID, id string) (*domain.File, error) {
+	files, err := r.query(ctx, fileSelect+` WHERE f.tenant_id = $1 AND f.id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, domain.NotFound("File")
+	}
+	return &files[0], nil
+}
+
+func (r *FileRepo) List(ctx context.Context, tenantID, ownerID string, params domain.ListParams) ([]domain.File, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM files WHERE tenant_id = $1 AND owner_id = $2`,
+		tenantID, ownerID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	files, err := r.query(ctx, fileSelect+` WHERE f.tenant_id = $1 AND f.owner_id = $2
+		ORDER BY f.created_at DESC, f.id LIMIT $3 OFFSET $4`, tenantID, ownerID, params.Limit, params.Offset())
+	return files, total, err
+}
+
+func (r *FileRepo) Delete(ctx context.Context, tenantID, id string, at time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hash string
+	err = tx.QueryRowContext(ctx, `DELETE FROM files WHERE tenant_id = $1 AND id = $2 RETURNING blob_hash`, tenantID, id).Scan(&hash)
+	if err != nil {
+		return notFound(err, "File")
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE blobs SET ref_count = ref_count - 1, updated_at = $1 WHERE hash = $2 AND ref_count > 0`,
+		at, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *FileRepo) Recount(ctx context.Context, at time.Time) (int, error) {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE blobs SET ref_count = (SELECT COUNT(*) FROM files WHERE files.blob_hash = blobs.hash), updated_at = $1
+		WHERE ref_count != (SELECT COUNT(*) FROM files WHERE files.blob_hash = blobs.hash)`, at)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *FileRepo) Unreferenced(ctx context.Context, before time.Time, limit int) ([]domain.Blob, error) {
+	return r.queryBlobs(ctx, `SELECT `+blobColumns+` FROM blobs
+		WHERE ref_count = 0 AND updated_at < $1 ORDER BY updated_at LIMIT $2`, before, limit)
+}
+
+func (r *FileRepo) DeleteBlob(ctx context.Context, hash string, before time.Time) (bool, error) {
+	// A count that is wrong must not delete content still in use, so
+	// referencing files are checked as well
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM blobs WHERE hash = $1 AND ref_count = 0 AND updated_at < $2
+		AND NOT EXISTS (SELECT 1 FROM files WHERE blob_hash = $1)`, hash, before)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (r *FileRepo) Blobs(ctx context.Context) (map[string]domain.Blob, error) {
+	blobs, err := r.queryBlobs(ctx, `SELECT `+blobColumns+` FROM blobs`)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]domain.Blob, len(blobs))
+	for _, b := range blobs {
+		index[b.Hash] = b
+	}
+	return index, nil
+}
+
+func (r *FileRepo) RefCountMismatches(ctx context.Context) ([]domain.BlobRefMismatch, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT b.hash, b.ref_count, COUNT(f.id) FROM blobs b LEFT JOIN files f ON f.blob_hash = b.hash
+		GROUP BY b.hash, b.ref_count HAVING b.ref_count != COUNT(f.id) ORDER BY b.hash`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mismatches := []domain.BlobRefMismatch{}
+	for rows.Next() {
+		var m domain.BlobRefMismatch
+		if err := rows.Scan(&m.Hash, &m.Stored, &m.Actual); err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches, rows.Err()
+}
+
+func (r *FileRepo) Blob(ctx context.Context, hash string) (*domain.Blob, error) {
+	blobs, err := r.queryBlobs(ctx, `SELECT `+blobColumns+` FROM blobs WHERE hash = $1`, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(blobs) == 0 {
+		return nil, domain.NotFound("Blob")
+	}
+	return &blobs[0], nil
+}
+
+func (r *FileRepo) SetScanResult(ctx context.Context, hash, status, signature, version string, at time.Time) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	if err := tx.QueryRowContext(ctx, `SELECT scan_status FROM blobs WHERE hash = $1`, hash).Scan(&previous); err != nil {
+		return "", notFound(err, "Blob")
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE blobs SET scan_status = $1, scan_signature = $2, scanner_version = $3, scanned_at = $4
+		WHERE hash = $5`, status, signature, version, at, hash); err != nil {
+		return "", err
+	}
+	return previous, tx.Commit()
+}
+
+func (r *FileRepo) StaleScans(ctx context.Context, version string, limit int) ([]domain.Blob, error) {
+	return r.queryBlobs(ctx, `SELECT `+blobColumns+` FROM blobs WHERE scanner_version != $1 AND ref_count > 0
+		ORDER BY COALESCE(scanned_at, created_at), hash LIMIT $2`, version, limit)
+}
+
+func (r *FileRepo) FilesByBlob(ctx context.Context, hash string) ([]domain.File, error) {
+	return r.query(ctx, fileSelect+` WHERE f.blob_hash = $1 ORDER BY f.created_at, f.id`, hash)
+}
+
+func (r *FileRepo) Unscanned(ctx context.Context, tenantID string, params domain.ListParams) ([]domain.File, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM files f JOIN blobs b ON b.hash = f.blob_hash
+		WHERE f.tenant_id = $1 AND b.scan_status != $2`, tenantID, domain.ScanClean).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	files, err := r.query(ctx, fileSelect+` WHERE f.tenant_id = $1 AND b.scan_status != $2
+		ORDER BY f.created_at DESC, f.id LIMIT $3 OFFSET $4`, tenantID, domain.ScanClean, params.Limit, params.Offset())
+	return files, total, err
+}
+
+func (r *FileRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.File, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []domain.File{}
+	for rows.Next() {
+		var f domain.File
+		if err := rows.Scan(&f.ID, &f.TenantID, &f.OwnerID, &f.Name, &f.ContentType, &f.Size, &f.SHA256,
+			&f.ScanStatus, &f.ScanSignature, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (r *FileRepo) queryBlobs(ctx context.Context, query string, args ...interface{}) ([]domain.Blob, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []domain.Blob{}
+	for rows.Next() {
+		var b domain.Blob
+		var scannedAt sql.NullTime
+		if err := rows.Scan(&b.Hash, &b.Size, &b.RefCount, &b.ScanStatus, &b.ScanSignature, &b.ScannerVersion, &scannedAt,
+			&b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		b.ScannedAt = timePtr(scannedAt)
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
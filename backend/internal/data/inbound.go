@@ -0,0 +1,104 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const inboundColumns = `id, tenant_id, user_id, message_id, address, kind, resource_type, resource_id, sender, subject,
+	status, reason, comment_id, file_ids, received_at`
+
+// InboundMailRepo records the e-mail received at inbound addresses.
+type InboundMailRepo struct {
+	db DB
+}
+
+// NewInboundMailRepo creates an inbound mail repository.
+func NewInboundMailRepo(db DB) *InboundMailRepo {
+	return &InboundMailRepo{db: db}
+}
+
+func init() {
+	maskTable("inbound_messages",
+		keep("id"), keep("tenant_id"), userRef("user_id"), hashed("message_id"), hashed("address"), keep("kind"),
+		keep("resource_type"), userRefWhen("resource_id", "resource_type"), hashed("sender"), fakeText("subject"),
+		keep("status"), keep("reason"), keep("comment_id"), keep("file_ids"), shiftDate("received_at"),
+	)
+}
+
+func (r *InboundMailRepo) Create(ctx context.Context, m *domain.InboundMessage) (*domain.InboundMessage, error) {
+	fileIDs, err := encodeJSON(m.FileIDs)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO inbound_messages (`+inboundColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		m.ID, m.TenantID, m.UserID, m.MessageID, m.Address, m.Kind, m.ResourceType, m.ResourceID, m.Sender, m.Subject,
+		m.Status, m.Reason, m.CommentID, fileIDs, m.ReceivedAt)
+	if err == nil {
+		return m, nil
+	}
+	var appErr domain.AppError
+	if err = conflict(err); !errors.As(err, &appErr) || appErr.Code != domain.ErrConflict.Code {
+		return nil, err
+	}
+	messages, err := r.query(ctx, `SELECT `+inboundColumns+` FROM inbound_messages
+		WHERE tenant_id = $1 AND message_id = $2 AND address = $3`, m.TenantID, m.MessageID, m.Address)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, domain.NotFound("Inbound message")
+	}
+	return &messages[0], nil
+}
+
+func (r *InboundMailRepo) Finish(ctx context.Context, m *domain.InboundMessage) error {
+	fileIDs, err := encodeJSON(m.FileIDs)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE inbound_messages SET status = $1, reason = $2, comment_id = $3, file_ids = $4 WHERE tenant_id = $5 AND id = $6`,
+		m.Status, m.Reason, m.CommentID, fileIDs, m.TenantID, m.ID)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Inbound message")
+}
+
+func (r *InboundMailRepo) List(ctx context.Context, tenantID string, params domain.ListParams) ([]domain.InboundMessage, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM inbound_messages WHERE tenant_id = $1`, tenantID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	messages, err := r.query(ctx, `SELECT `+inboundColumns+` FROM inbound_messages WHERE tenant_id = $1
+		ORDER BY received_at DESC, id LIMIT $2 OFFSET $3`, tenantID, params.Limit, params.Offset())
+	return messages, total, err
+}
+
+func (r *InboundMailRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.InboundMessage, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []domain.InboundMessage{}
+	for rows.Next() {
+		var m domain.InboundMessage
+		var fileIDs string
+		if err := rows.Scan(&m.ID, &m.TenantID, &m.UserID, &m.MessageID, &m.Address, &m.Kind, &m.ResourceType, &m.ResourceID,
+			&m.Sender, &m.Subject, &m.Status, &m.Reason, &m.CommentID, &fileIDs, &m.ReceivedAt); err != nil {
+			return nil, err
+		}
+		m.FileIDs = []string{}
+		if err := decodeJSON(fileIDs, &m.FileIDs); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,84 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"greact-bones/backend/internal/data"
+	"greact-bones/backend/internal/domain"
+)
+
+// flakyUsers fails the first lookup of a user, like a dropped connection.
+type flakyUsers struct {
+	*data.UserRepo
+	failed bool
+}
+
+func (r *flakyUsers) GetByID(ctx context.Context, tenantID, id string) (*domain.User, error) {
+	if !r.failed {
+		r.failed = true
+		return nil, errors.New("connection reset")
+	}
+	return r.UserRepo.GetByID(ctx, tenantID, id)
+}
+
+func TestInboundReceiveRetriesAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	db, err := data.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := data.Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	users := domain.NewUserService(&flakyUsers{UserRepo: data.NewUserRepo(db)})
+	alice := domain.Identity{UserID: "u1", TenantID: domain.DefaultTenant, Username: "alice", Email: "alice@example.com"}
+	if err := users.Sync(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	comments := domain.NewCommentService(data.NewCommentRepo(db), users, nil, domain.NewEventBus())
+	addresses := domain.NewInboundAddresses("secret", "in.example.com")
+	inbound := domain.NewInboundMailService(data.NewInboundMailRepo(db), addresses, users, comments, nil)
+
+	msg := &domain.InboundEmail{
+		MessageID:  "<m1@example.com>",
+		From:       alice.Email,
+		Recipients: []string{addresses.Reply(alice.TenantID, alice.UserID, "doc", "d1")},
+		Subject:    "Re: d1",
+		Reply:      "Looks good",
+	}
+	if _, err := inbound.Receive(ctx, msg); err == nil {
+		t.Fatal("first delivery: expected the lookup error")
+	}
+	results, err := inbound.Receive(ctx, msg)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != domain.InboundProcessed || results[0].CommentID == "" {
+		t.Fatalf("retry: got %+v, want one processed message with a comment", results)
+	}
+
+	threads, _, err := comments.ListThreads(ctx, alice, "doc", "d1", domain.ListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 || threads[0].Body != "Looks good" {
+		t.Fatalf("got comments %+v, want the reply once", threads)
+	}
+
+	results, err = inbound.Receive(ctx, msg)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("duplicate delivery: got %+v, %v, want it skipped", results, err)
+	}
+	stored, _, err := inbound.List(ctx, domain.Identity{TenantID: alice.TenantID, Roles: []string{"admin"}}, domain.ListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Status != domain.InboundProcessed {
+		t.Fatalf("got stored messages %+v, want one processed", stored)
+	}
+}
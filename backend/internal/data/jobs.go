@@ -0,0 +1,325 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const jobColumns = `id, tenant_id, queue, kind, payload, status, attempts, max_attempts, last_error,
+	run_at, started_at, finished_at, created_at, updated_at`
+
+// JobRepo stores the background job queue.
+type JobRepo struct {
+	db DB
+}
+
+// NewJobRepo creates a job repository.
+func NewJobRepo(db DB) *JobRepo {
+	return &JobRepo{db: db}
+}
+
+func init() {
+	// Job payloads and errors are free-form and short-lived
+	skipTable("jobs")
+	skipTable("job_attempts")
+	maskTable("job_queues", keep("name"), shiftDate("paused_at"), userRef("paused_by"))
+}
+
+func (r *JobRepo) Enqueue(ctx context.Context, j *domain.Job) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO jobs (id, tenant_id, queue, kind, payload, status, attempts, max_attempts, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		j.ID, j.TenantID, j.Queue, j.Kind, string(j.Payload), j.Status, j.Attempts, j.MaxAttempts, j.RunAt, j.CreatedAt, j.UpdatedAt)
+	return err
+}
+
+// ClaimNext marks the next runnable job as running in a single statement,
+// so concurrent workers never receive the same job.
+func (r *JobRepo) ClaimNext(ctx context.Context, queues []string, now time.Time, lease time.Duration) (*domain.Job, error) {
+	if len(queues) == 0 {
+		return nil, nil
+	}
+	const runnable = `((status = 'pending' AND run_at <= $1) OR (status = 'running' AND locked_until < $1))`
+	args := append([]interface{}{now, now.Add(lease)}, stringArgs(queues)...)
+	row := r.db.QueryRowContext(ctx, fmt.Sprintf(`
+		UPDATE jobs SET status = 'running', attempts = attempts + 1, started_at = $1, locked_until = $2, updated_at = $1
+		WHERE %[1]s AND id = (
+			SELECT id FROM jobs WHERE %[1]s AND queue IN (%[2]s)
+			AND queue NOT IN (SELECT name FROM job_queues WHERE paused_at IS NOT NULL)
+			ORDER BY run_at, id LIMIT 1
+		)
+		RETURNING `+jobColumns, runnable, placeholders(3, len(queues))), args...)
+	j, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return j, err
+}
+
+func (r *JobRepo) Complete(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE jobs SET status = 'succeeded', locked_until = NULL, finished_at = $1, updated_at = $1
+		WHERE id = $2`, at, id)
+	return err
+}
+
+func (r *JobRepo) Fail(ctx context.Context, id string, attempt int, message string, retryAt *time.Time, at time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO job_attempts (id, job_id, attempt, error, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		domain.NewID(), id, attempt, message, at); err != nil {
+		return err
+	}
+	if retryAt != nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE jobs SET status = 'pending', last_error = $1, run_at = $2, locked_until = NULL, updated_at = $3
+			WHERE id = $4`, message, *retryAt, at, id)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE jobs SET status = 'dead', last_error = $1, locked_until = NULL, finished_at = $2, updated_at = $2
+			WHERE id = $3`, message, at, id)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanJob(s scanner) (*domain.Job, error) {
+	var j domain.Job
+	var payload string
+	var startedAt, finishedAt sql.NullTime
+	err := s.Scan(&j.ID, &j.TenantID, &j.Queue, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.LastError,
+		&j.RunAt, &startedAt, &finishedAt, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Payload = []byte(payload)
+	j.StartedAt = timePtr(startedAt)
+	j.FinishedAt = timePtr(finishedAt)
+	return &j, nil
+}
+
+// QueueCounts gathers the per-queue numbers for the job admin API. Queues
+// only known from job_queues (paused before any job arrived) are included.
+func (r *JobRepo) QueueCounts(ctx context.Context, now, since time.Time) (map[string]domain.QueueCounts, error) {
+	counts := make(map[string]domain.QueueCounts)
+	update := func(queue string, fn func(*domain.QueueCounts)) {
+		c := counts[queue]
+		fn(&c)
+		counts[queue] = c
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT queue, status, CASE WHEN run_at <= $1 THEN 1 ELSE 0 END, COUNT(*)
+		FROM jobs WHERE status IN ('pending', 'running', 'dead')
+		GROUP BY queue, status, CASE WHEN run_at <= $1 THEN 1 ELSE 0 END`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var queue string
+		var status domain.JobStatus
+		var due, n int
+		if err := rows.Scan(&queue, &status, &due, &n); err != nil {
+			return nil, err
+		}
+		update(queue, func(c *domain.QueueCounts) {
+			switch status {
+			case domain.JobPending:
+				c.Pending += n
+				if due == 1 {
+					c.Runnable += n
+				}
+			case domain.JobRunning:
+				c.Running += n
+			case domain.JobDead:
+				c.Dead += n
+			}
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for query, set := range map[string]func(*domain.QueueCounts, int){
+		`SELECT queue, COUNT(*) FROM jobs WHERE status = 'succeeded' AND finished_at >= $1 GROUP BY queue`: func(c *domain.QueueCounts, n int) { c.Succeeded = n },
+		`SELECT j.queue, COUNT(*) FROM job_attempts a JOIN jobs j ON j.id = a.job_id
+			WHERE a.created_at >= $1 GROUP BY j.queue`: func(c *domain.QueueCounts, n int) { c.Failed = n },
+	} {
+		if err := r.eachCount(ctx, query, since, func(queue string, n int) {
+			update(queue, func(c *domain.QueueCounts) { set(c, n) })
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	paused, err := r.db.QueryContext(ctx, `SELECT name, paused_at, paused_by FROM job_queues WHERE paused_at IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer paused.Close()
+	for paused.Next() {
+		var name, by string
+		var at sql.NullTime
+		if err := paused.Scan(&name, &at, &by); err != nil {
+			return nil, err
+		}
+		update(name, func(c *domain.QueueCounts) { c.PausedAt, c.PausedBy = timePtr(at), by })
+	}
+	if err := paused.Err(); err != nil {
+		return nil, err
+	}
+
+	// Aggregates lose the column type, so the oldest due job is looked up
+	// per queue rather than with MIN(run_at).
+	for queue, c := range counts {
+		if c.Runnable == 0 {
+			continue
+		}
+		var oldest time.Time
+		err := r.db.QueryRowContext(ctx, `
+			SELECT run_at FROM jobs WHERE queue = $1 AND status = 'pending' AND run_at <= $2
+			ORDER BY run_at LIMIT 1`, queue, now).Scan(&oldest)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err == nil {
+			oldest = oldest.UTC()
+			c.OldestDueAt = &oldest
+			counts[queue] = c
+		}
+	}
+	return counts, nil
+}
+
+func (r *JobRepo) eachCount(ctx context.Context, query string, since time.Time, fn func(queue string, n int)) error {
+	rows, err := r.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var queue string
+		var n int
+		if err := rows.Scan(&queue, &n); err != nil {
+			return err
+		}
+		fn(queue, n)
+	}
+	return rows.Err()
+}
+
+func (r *JobRepo) ListJobs(ctx context.Context, f domain.JobFilter, params domain.ListParams) ([]domain.Job, int, error) {
+	var args []interface{}
+	where := []string{"1 = 1"}
+	for column, value := range map[string]string{
+		"queue":  f.Queue,
+		"kind":   f.Kind,
+		"status": string(f.Status),
+	} {
+		if value != "" {
+			args = append(args, value)
+			where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM jobs WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, params.Limit, params.Offset())
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s FROM jobs WHERE %s
+		ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`, jobColumns, whereSQL, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []domain.Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, *j)
+	}
+	return items, total, rows.Err()
+}
+
+func (r *JobRepo) GetJob(ctx context.Context, id string) (*domain.Job, error) {
+	j, err := scanJob(r.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if err != nil {
+		return nil, notFound(err, "Job")
+	}
+	return j, nil
+}
+
+func (r *JobRepo) JobAttempts(ctx context.Context, id string) ([]domain.JobAttempt, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT attempt, error, created_at FROM job_attempts WHERE job_id = $1 ORDER BY created_at, attempt`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []domain.JobAttempt{}
+	for rows.Next() {
+		var a domain.JobAttempt
+		if err := rows.Scan(&a.Attempt, &a.Error, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// RetryDead keeps the attempt history; attempts restarts at zero so the job
+// gets its full retry budget again.
+func (r *JobRepo) RetryDead(ctx context.Context, queue, id string, now time.Time) (int, error) {
+	query := `UPDATE jobs SET status = 'pending', attempts = 0, run_at = $1, finished_at = NULL, updated_at = $1
+		WHERE status = 'dead' AND queue = $2`
+	args := []interface{}{now, queue}
+	if id != "" {
+		query += ` AND id = $3`
+		args = append(args, id)
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *JobRepo) DeleteDead(ctx context.Context, id string) (int, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM jobs WHERE id = $1 AND status = 'dead'`, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *JobRepo) SetPaused(ctx context.Context, queue string, pausedAt *time.Time, by string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO job_queues (name, paused_at, paused_by) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET paused_at = excluded.paused_at, paused_by = excluded.paused_by`,
+		queue, nullTime(pausedAt), by)
+	return err
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LintFinding is a potentially dangerous operation in a migration.
+type LintFinding struct {
+	Migration string `json:"migration"`
+	Line      int    `json:"line"`
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+	Statement string `json:"statement"`
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s.sql:%d: %s: %s\n    %s", f.Migration, f.Line, f.Rule, f.Message, f.Statement)
+}
+
+// lintRule flags statements matching pattern, unless they also match
+// unless. Statements on a table created earlier in the same migration (the
+// pattern's "table" group) are exempt, since the table is still empty.
+type lintRule struct {
+	name    string
+	pattern *regexp.Regexp
+	unless  *regexp.Regexp
+	message string
+}
+
+var lintRules = []lintRule{
+	{
+		name:    "index-not-concurrent",
+		pattern: regexp.MustCompile(`(?is)^CREATE\s+(UNIQUE\s+)?INDEX\s+(IF\s+NOT\s+EXISTS\s+)?\S+\s+ON\s+(?P<table>[^\s(]+)`),
+		unless:  regexp.MustCompile(`(?i)\bCONCURRENTLY\b`),
+		message: "blocks writes to the table while the index builds; use CREATE INDEX CONCURRENTLY in a -- migrate:no-transaction migration",
+	},
+	{
+		name:    "add-column-not-null",
+		pattern: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)\s+ADD\s+(COLUMN\s+)?.*\bNOT\s+NULL\b`),
+		unless:  regexp.MustCompile(`(?i)\bDEFAULT\b`),
+		message: "fails on tables with rows; add the column nullable or with a default, backfill, then add the constraint",
+	},
+	{
+		name:    "volatile-default",
+		pattern: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)\s+ADD\s+(COLUMN\s+)?.*\bDEFAULT\s+(now|random|gen_random_uuid|uuid_generate_v4|clock_timestamp|CURRENT_TIMESTAMP)\b`),
+		message: "a volatile default rewrites the whole table; add the column without a default and backfill",
+	},
+	{
+		name:    "alter-column-type",
+		pattern: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)\s+ALTER\s+(COLUMN\s+)?\S+\s+(SET\s+DATA\s+)?TYPE\b`),
+		message: "changing a column type rewrites the table under an exclusive lock; add a new column and backfill instead",
+	},
+	{
+		name:    "set-not-null",
+		pattern: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)\s+ALTER\s+(COLUMN\s+)?\S+\s+SET\s+NOT\s+NULL\b`),
+		message: "scans the whole table under an exclusive lock; add a CHECK (... IS NOT NULL) NOT VALID constraint and validate it separately",
+	},
+	{
+		name:    "constraint-not-valid",
+		pattern: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)\s+ADD\s+(CONSTRAINT\s+\S+\s+)?(FOREIGN\s+KEY|CHECK)\b`),
+		unless:  regexp.MustCompile(`(?i)\bNOT\s+VALID\b`),
+		message: "validates every row while holding a lock; add the constraint NOT VALID, then VALIDATE CONSTRAINT in a later migration",
+	},
+	{
+		name:    "drop",
+		pattern: regexp.MustCompile(`(?is)^(DROP|ALTER)\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)(\s+DROP\b|\s*$)`),
+		message: "loses data and breaks replicas still running the previous release; stop using it in one release, drop it in the next",
+	},
+	{
+		name:    "rename",
+		pattern: regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(IF\s+EXISTS\s+)?(?P<table>[^\s(]+)\s+RENAME\b`),
+		message: "breaks replicas still running the previous release; add the new name, migrate readers and writers, then drop the old one",
+	},
+	{
+		name:    "table-rewrite",
+		pattern: regexp.MustCompile(`(?is)^(VACUUM\s+FULL|CLUSTER)\b`),
+		message: "rewrites the table under an exclusive lock",
+	},
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?([^\s(]+)`)
+	lintIgnorePattern  = regexp.MustCompile(`--\s*lint:ignore\s+([\w-]+)`)
+)
+
+// LintMigrations checks the embedded migrations for operations that lock
+// or rewrite tables with data, or break the previous release during a
+// rolling deploy. A "-- lint:ignore <rule>" comment in the statement, or
+// on the line before it, accepts a finding.
+func LintMigrations() ([]LintFinding, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var findings []LintFinding
+	for _, m := range migrations {
+		findings = append(findings, lintMigration(m)...)
+	}
+	return findings, nil
+}
+
+func lintMigration(m Migration) []LintFinding {
+	var findings []LintFinding
+	created := map[string]bool{}
+	for _, stmt := range splitStatements(m.SQL) {
+		if c := createTablePattern.FindStringSubmatch(stmt.sql); c != nil {
+			created[normalizeIdent(c[2])] = true
+			continue
+		}
+		for _, rule := range lintRules {
+			match := rule.pattern.FindStringSubmatch(stmt.sql)
+			if match == nil || (rule.unless != nil && rule.unless.MatchString(stmt.sql)) || stmt.ignores[rule.name] {
+				continue
+			}
+			if at := rule.pattern.SubexpIndex("table"); at >= 0 && created[normalizeIdent(match[at])] {
+				continue
+			}
+			findings = append(findings, LintFinding{
+				Migration: m.Version,
+				Line:      stmt.line,
+				Rule:      rule.name,
+				Message:   rule.message,
+				Statement: oneLine(stmt.sql),
+			})
+		}
+	}
+	return findings
+}
+
+type statement struct {
+	sql     string
+	line    int
+	ignores map[string]bool
+}
+
+// splitStatements splits a migration at semicolons, dropping comments
+// but remembering lint:ignore directives. Migrations do not put
+// semicolons in string literals.
+func splitStatements(src string) []statement {
+	var stmts []statement
+	var b strings.Builder
+	ignores := map[string]bool{}
+	start := 0
+	for i, line := range strings.Split(src, "\n") {
+		code := line
+		if at := strings.Index(line, "--"); at >= 0 {
+			for _, m := range lintIgnorePattern.FindAllStringSubmatch(line[at:], -1) {
+				ignores[m[1]] = true
+			}
+			code = line[:at]
+		}
+		for {
+			semi := strings.IndexByte(code, ';')
+			part := code
+			if semi >= 0 {
+				part = code[:semi]
+			}
+			if strings.TrimSpace(part) != "" && strings.TrimSpace(b.String()) == "" {
+				start = i + 1
+			}
+			b.WriteString(part + "\n")
+			if semi < 0 {
+				break
+			}
+			if sql := strings.TrimSpace(b.String()); sql != "" {
+				stmts = append(stmts, statement{sql: sql, line: start, ignores: ignores})
+			}
+			b.Reset()
+			ignores = map[string]bool{}
+			code = code[semi+1:]
+		}
+	}
+	if sql := strings.TrimSpace(b.String()); sql != "" {
+		stmts = append(stmts, statement{sql: sql, line: start, ignores: ignores})
+	}
+	return stmts
+}
+
+func normalizeIdent(s string) string {
+	s = strings.Trim(strings.TrimSuffix(s, "("), `"`)
+	if at := strings.LastIndexByte(s, '.'); at >= 0 {
+		s = s[at+1:]
+	}
+	return strings.ToLower(strings.Trim(s, `"`))
+}
+
+func oneLine(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > 120 {
+		s = s[:117] + "..."
+	}
+	return s
+}
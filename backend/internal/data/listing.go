@@ -0,0 +1,203 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// SQLListSource exposes a table to the listing toolkit. Rows are always
+// scoped to the caller's tenant and, when ownerColumn is set, to the
+// caller's own rows.
+type SQLListSource struct {
+	db           DB
+	name         string
+	table        string
+	ownerColumn  string
+	extraWhere   string
+	fields       []domain.ListField
+	defaultOrder []domain.SortField
+}
+
+func (s *SQLListSource) Name() string { return s.name }
+
+func (s *SQLListSource) Fields() []domain.ListField { return s.fields }
+
+func (s *SQLListSource) Query(ctx context.Context, actor domain.Identity, q domain.ListQuery, params domain.ListParams) ([]map[string]interface{}, int, error) {
+	columns := make(map[string]string, len(s.fields))
+	for _, f := range s.fields {
+		columns[f.Name] = f.Column
+	}
+
+	args := []interface{}{actor.TenantID}
+	where := []string{"tenant_id = $1"}
+	if s.ownerColumn != "" {
+		args = append(args, actor.UserID)
+		where = append(where, fmt.Sprintf("%s = $%d", s.ownerColumn, len(args)))
+	}
+	if s.extraWhere != "" {
+		where = append(where, s.extraWhere)
+	}
+	for _, f := range q.Filters {
+		clause, filterArgs := filterSQL(columns[f.Field], f, len(args)+1)
+		where = append(where, clause)
+		args = append(args, filterArgs...)
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+s.table+` WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	sortFields := q.Sort
+	if len(sortFields) == 0 {
+		sortFields = s.defaultOrder
+	}
+	order := make([]string, 0, len(sortFields)+1)
+	for _, sf := range sortFields {
+		dir := "ASC"
+		if sf.Desc {
+			dir = "DESC"
+		}
+		order = append(order, columns[sf.Field]+" "+dir)
+	}
+	order = append(order, "id")
+
+	selected := make([]string, len(q.Columns))
+	for i, name := range q.Columns {
+		selected[i] = columns[name]
+	}
+	args = append(args, params.Limit, params.Offset())
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+		strings.Join(selected, ", "), s.table, whereSQL, strings.Join(order, ", "), len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []map[string]interface{}{}
+	for rows.Next() {
+		item, err := scanRecord(rows, q.Columns)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
+
+// scanRecord scans a row into a map keyed by the given field names.
+func scanRecord(sc scanner, names []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(names))
+	ptrs := make([]interface{}, len(names))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := sc.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	record := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		record[name] = normalizeValue(values[i])
+	}
+	return record, nil
+}
+
+// filterSQL renders one filter with placeholders starting at $next.
+func filterSQL(column string, f domain.Filter, next int) (string, []interface{}) {
+	switch f.Op {
+	case domain.OpIn:
+		values := f.Value.([]interface{})
+		return fmt.Sprintf("%s IN (%s)", column, placeholders(next, len(values))), values
+	case domain.OpContains:
+		pattern := "%" + escapeLike(f.Value.(string)) + "%"
+		return fmt.Sprintf(`LOWER(%s) LIKE LOWER($%d) ESCAPE '\'`, column, next), []interface{}{pattern}
+	}
+	ops := map[domain.FilterOp]string{
+		domain.OpEq: "=", domain.OpNe: "<>",
+		domain.OpLt: "<", domain.OpLte: "<=",
+		domain.OpGt: ">", domain.OpGte: ">=",
+	}
+	return fmt.Sprintf("%s %s $%d", column, ops[f.Op], next), []interface{}{f.Value}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// normalizeValue converts driver values into JSON-friendly ones.
+func normalizeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.UTC()
+	}
+	return v
+}
+
+// NewCommentListSource lists the comments of the caller's tenant, except
+// deleted ones and those hidden by moderators.
+func NewCommentListSource(db DB) *SQLListSource {
+	return &SQLListSource{
+		db:         db,
+		name:       "comments",
+		table:      "comments",
+		extraWhere: "deleted_at IS NULL AND hidden_at IS NULL",
+		fields: []domain.ListField{
+			{Name: "id", Type: domain.FieldString, Column: "id", Filterable: true, Default: true},
+			{Name: "resource_type", Type: domain.FieldString, Column: "resource_type", Filterable: true, Sortable: true, Default: true},
+			{Name: "resource_id", Type: domain.FieldString, Column: "resource_id", Filterable: true, Sortable: true, Default: true},
+			{Name: "thread_id", Type: domain.FieldString, Column: "thread_id", Filterable: true},
+			{Name: "author_id", Type: domain.FieldString, Column: "author_id", Filterable: true, Sortable: true, Default: true},
+			{Name: "body", Type: domain.FieldString, Column: "body", Filterable: true, Default: true},
+			{Name: "edited_at", Type: domain.FieldTime, Column: "edited_at", Filterable: true, Sortable: true},
+			{Name: "created_at", Type: domain.FieldTime, Column: "created_at", Filterable: true, Sortable: true, Default: true},
+		},
+		defaultOrder: []domain.SortField{{Field: "created_at", Desc: true}},
+	}
+}
+
+// NewActivityListSource lists the activity stream of the caller's tenant.
+func NewActivityListSource(db DB) *SQLListSource {
+	return &SQLListSource{
+		db:    db,
+		name:  "activities",
+		table: "activities",
+		fields: []domain.ListField{
+			{Name: "id", Type: domain.FieldString, Column: "id", Filterable: true, Default: true},
+			{Name: "actor_id", Type: domain.FieldString, Column: "actor_id", Filterable: true, Sortable: true, Default: true},
+			{Name: "verb", Type: domain.FieldString, Column: "verb", Filterable: true, Sortable: true, Default: true},
+			{Name: "resource_type", Type: domain.FieldString, Column: "resource_type", Filterable: true, Sortable: true, Default: true},
+			{Name: "resource_id", Type: domain.FieldString, Column: "resource_id", Filterable: true, Sortable: true, Default: true},
+			{Name: "created_at", Type: domain.FieldTime, Column: "created_at", Filterable: true, Sortable: true, Default: true},
+		},
+		defaultOrder: []domain.SortField{{Field: "created_at", Desc: true}},
+	}
+}
+
+// NewNotificationListSource lists the caller's own notifications.
+func NewNotificationListSource(db DB) *SQLListSource {
+	return &SQLListSource{
+		db:          db,
+		name:        "notifications",
+		table:       "notifications",
+		ownerColumn: "user_id",
+		fields: []domain.ListField{
+			{Name: "id", Type: domain.FieldString, Column: "id", Filterable: true, Default: true},
+			{Name: "kind", Type: domain.FieldString, Column: "kind", Filterable: true, Sortable: true, Default: true},
+			{Name: "title", Type: domain.FieldString, Column: "title", Filterable: true, Sortable: true, Default: true},
+			{Name: "body", Type: domain.FieldString, Column: "body", Filterable: true},
+			{Name: "actor_id", Type: domain.FieldString, Column: "actor_id", Filterable: true, Sortable: true},
+			{Name: "resource_type", Type: domain.FieldString, Column: "resource_type", Filterable: true, Sortable: true},
+			{Name: "resource_id", Type: domain.FieldString, Column: "resource_id", Filterable: true},
+			{Name: "read_at", Type: domain.FieldTime, Column: "read_at", Filterable: true, Sortable: true, Default: true},
+			{Name: "created_at", Type: domain.FieldTime, Column: "created_at", Filterable: true, Sortable: true, Default: true},
+		},
+		defaultOrder: []domain.SortField{{Field: "created_at", Desc: true}},
+	}
+}
@@ -0,0 +1,12 @@
+CREATE TABLE users (
+    id TEXT NOT NULL,
+    tenant_id TEXT NOT NULL,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    display_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, id)
+);
+
+CREATE UNIQUE INDEX users_tenant_username_idx ON users (tenant_id, username);
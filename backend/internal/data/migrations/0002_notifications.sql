@@ -0,0 +1,15 @@
+CREATE TABLE notifications (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    actor_id TEXT NOT NULL DEFAULT '',
+    resource_type TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    read_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX notifications_user_idx ON notifications (tenant_id, user_id, created_at);
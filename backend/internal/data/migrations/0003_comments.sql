@@ -0,0 +1,41 @@
+CREATE TABLE comments (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    thread_id TEXT NOT NULL,
+    parent_id TEXT NULL REFERENCES comments (id),
+    author_id TEXT NOT NULL,
+    body TEXT NOT NULL,
+    edited_at TIMESTAMP NULL,
+    deleted_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX comments_resource_idx ON comments (tenant_id, resource_type, resource_id, created_at);
+CREATE INDEX comments_thread_idx ON comments (thread_id, created_at);
+
+CREATE TABLE comment_revisions (
+    id TEXT PRIMARY KEY,
+    comment_id TEXT NOT NULL REFERENCES comments (id),
+    body TEXT NOT NULL,
+    editor_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX comment_revisions_comment_idx ON comment_revisions (comment_id, created_at);
+
+CREATE TABLE comment_mentions (
+    comment_id TEXT NOT NULL REFERENCES comments (id),
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE TABLE comment_reactions (
+    comment_id TEXT NOT NULL REFERENCES comments (id),
+    user_id TEXT NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (comment_id, user_id, emoji)
+);
@@ -0,0 +1,22 @@
+CREATE TABLE activities (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    actor_id TEXT NOT NULL DEFAULT '',
+    verb TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    data TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX activities_resource_idx ON activities (tenant_id, resource_type, resource_id, created_at);
+CREATE INDEX activities_actor_idx ON activities (tenant_id, actor_id, created_at);
+
+CREATE TABLE activity_follows (
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, user_id, resource_type, resource_id)
+);
@@ -0,0 +1,24 @@
+CREATE TABLE saved_views (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    owner_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    source TEXT NOT NULL,
+    query TEXT NOT NULL,
+    schedule_frequency TEXT NULL,
+    schedule_hour INTEGER NULL,
+    schedule_weekday INTEGER NULL,
+    next_run_at TIMESTAMP NULL,
+    last_sent_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX saved_views_owner_idx ON saved_views (tenant_id, owner_id);
+CREATE INDEX saved_views_next_run_idx ON saved_views (next_run_at);
+
+CREATE TABLE saved_view_shares (
+    view_id TEXT NOT NULL REFERENCES saved_views (id) ON DELETE CASCADE,
+    team_id TEXT NOT NULL,
+    PRIMARY KEY (view_id, team_id)
+);
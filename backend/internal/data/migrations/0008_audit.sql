@@ -0,0 +1,13 @@
+CREATE TABLE audit_log (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    actor_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    changes TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_tenant_idx ON audit_log (tenant_id, created_at);
+CREATE INDEX audit_log_resource_idx ON audit_log (tenant_id, resource_type, resource_id);
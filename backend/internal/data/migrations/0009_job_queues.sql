@@ -0,0 +1,5 @@
+CREATE TABLE job_queues (
+    name TEXT PRIMARY KEY,
+    paused_at TIMESTAMP NULL,
+    paused_by TEXT NOT NULL DEFAULT ''
+);
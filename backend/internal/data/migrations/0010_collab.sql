@@ -0,0 +1,29 @@
+CREATE TABLE collab_documents (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    owner_id TEXT NOT NULL,
+    title TEXT NOT NULL,
+    state BYTEA NOT NULL,
+    state_version INTEGER NOT NULL DEFAULT 0,
+    compacted_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX collab_documents_owner_idx ON collab_documents (tenant_id, owner_id);
+
+CREATE TABLE collab_document_shares (
+    document_id TEXT NOT NULL REFERENCES collab_documents (id) ON DELETE CASCADE,
+    team_id TEXT NOT NULL,
+    access TEXT NOT NULL,
+    PRIMARY KEY (document_id, team_id)
+);
+
+CREATE TABLE collab_updates (
+    id TEXT PRIMARY KEY,
+    document_id TEXT NOT NULL REFERENCES collab_documents (id) ON DELETE CASCADE,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX collab_updates_document_idx ON collab_updates (document_id);
@@ -0,0 +1,35 @@
+CREATE TABLE calendar_entries (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    owner_id TEXT NOT NULL,
+    uid TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    location TEXT NOT NULL DEFAULT '',
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NULL,
+    all_day BOOLEAN NOT NULL DEFAULT FALSE,
+    time_zone TEXT NOT NULL,
+    rrule TEXT NOT NULL DEFAULT '',
+    exdates TEXT NOT NULL DEFAULT '[]',
+    completed_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX calendar_entries_uid_idx ON calendar_entries (tenant_id, owner_id, uid);
+CREATE INDEX calendar_entries_starts_idx ON calendar_entries (tenant_id, owner_id, starts_at);
+
+CREATE TABLE calendar_feeds (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX calendar_feeds_user_idx ON calendar_feeds (tenant_id, user_id);
@@ -0,0 +1,23 @@
+CREATE TABLE blobs (
+    hash TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX blobs_unreferenced_idx ON blobs (ref_count, updated_at);
+
+CREATE TABLE files (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    owner_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    blob_hash TEXT NOT NULL REFERENCES blobs (hash),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX files_owner_idx ON files (tenant_id, owner_id, created_at);
+CREATE INDEX files_blob_idx ON files (blob_hash);
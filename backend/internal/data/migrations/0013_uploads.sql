@@ -0,0 +1,15 @@
+CREATE TABLE uploads (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    owner_id TEXT NOT NULL,
+    length BIGINT NOT NULL,
+    received BIGINT NOT NULL DEFAULT 0,
+    metadata TEXT NOT NULL DEFAULT '{}',
+    file_id TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX uploads_owner_idx ON uploads (tenant_id, owner_id, file_id);
+CREATE INDEX uploads_expires_idx ON uploads (expires_at);
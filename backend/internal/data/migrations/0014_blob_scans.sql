@@ -0,0 +1,4 @@
+ALTER TABLE blobs ADD COLUMN scan_status TEXT NOT NULL DEFAULT 'clean';
+ALTER TABLE blobs ADD COLUMN scan_signature TEXT NOT NULL DEFAULT '';
+ALTER TABLE blobs ADD COLUMN scanner_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE blobs ADD COLUMN scanned_at TIMESTAMP NULL;
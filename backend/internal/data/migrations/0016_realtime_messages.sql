@@ -0,0 +1,7 @@
+CREATE TABLE realtime_messages (
+    seq BIGINT PRIMARY KEY,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX realtime_messages_created_idx ON realtime_messages (created_at);
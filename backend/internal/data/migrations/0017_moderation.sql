@@ -0,0 +1,97 @@
+ALTER TABLE comments ADD COLUMN hidden_at TIMESTAMP NULL;
+
+CREATE TABLE moderation_cases (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    subject_user_id TEXT NOT NULL DEFAULT '',
+    excerpt TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    flags TEXT NOT NULL DEFAULT '',
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    report_count INTEGER NOT NULL DEFAULT 0,
+    assignee_id TEXT NOT NULL DEFAULT '',
+    assigned_at TIMESTAMP NULL,
+    decision TEXT NOT NULL DEFAULT '',
+    decision_note TEXT NOT NULL DEFAULT '',
+    decided_by TEXT NOT NULL DEFAULT '',
+    decided_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX moderation_cases_open_idx ON moderation_cases (tenant_id, resource_type, resource_id) WHERE status = 'open';
+CREATE INDEX moderation_cases_queue_idx ON moderation_cases (tenant_id, status, report_count);
+CREATE INDEX moderation_cases_subject_idx ON moderation_cases (tenant_id, subject_user_id);
+
+CREATE TABLE moderation_reports (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    case_id TEXT NOT NULL REFERENCES moderation_cases (id),
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    reporter_id TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (case_id, reporter_id)
+);
+
+CREATE TABLE moderation_rules (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    action TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX moderation_rules_tenant_idx ON moderation_rules (tenant_id);
+
+CREATE TABLE moderation_strikes (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    case_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX moderation_strikes_user_idx ON moderation_strikes (tenant_id, user_id);
+CREATE INDEX moderation_strikes_case_idx ON moderation_strikes (case_id);
+
+CREATE TABLE moderation_bans (
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    case_id TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    until TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, user_id)
+);
+
+CREATE TABLE moderation_appeals (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    case_id TEXT NOT NULL UNIQUE REFERENCES moderation_cases (id),
+    user_id TEXT NOT NULL,
+    statement TEXT NOT NULL,
+    status TEXT NOT NULL,
+    decision_note TEXT NOT NULL DEFAULT '',
+    decided_by TEXT NOT NULL DEFAULT '',
+    decided_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX moderation_appeals_queue_idx ON moderation_appeals (tenant_id, status, created_at);
+CREATE INDEX moderation_appeals_user_idx ON moderation_appeals (tenant_id, user_id);
@@ -0,0 +1,9 @@
+ALTER TABLE jobs ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE tenant_databases (
+    tenant_id TEXT PRIMARY KEY,
+    location TEXT NOT NULL UNIQUE,
+    version TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    migrated_at TIMESTAMP NULL
+);
@@ -0,0 +1,64 @@
+CREATE TABLE event_log (
+    position BIGINT PRIMARY KEY,
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 0,
+    tenant_id TEXT NOT NULL,
+    actor_id TEXT NOT NULL DEFAULT '',
+    resource_type TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    data TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO event_log (position, id, type, tenant_id, actor_id, resource_type, resource_id, data, occurred_at)
+SELECT ROW_NUMBER() OVER (ORDER BY created_at, id), id, verb, tenant_id, actor_id, resource_type, resource_id, data, created_at
+FROM activities;
+
+CREATE TABLE projection_checkpoints (
+    name TEXT PRIMARY KEY,
+    position BIGINT NOT NULL DEFAULT 0,
+    rebuilding BOOLEAN NOT NULL DEFAULT FALSE,
+    last_error TEXT NOT NULL DEFAULT '',
+    failed_at TIMESTAMP NULL,
+    rebuilt_at TIMESTAMP NULL,
+    lease_owner TEXT NOT NULL DEFAULT '',
+    lease_until TIMESTAMP NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE discussion_stats (
+    id TEXT NOT NULL,
+    tenant_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    comment_count INTEGER NOT NULL DEFAULT 0,
+    thread_count INTEGER NOT NULL DEFAULT 0,
+    reaction_count INTEGER NOT NULL DEFAULT 0,
+    participant_count INTEGER NOT NULL DEFAULT 0,
+    last_comment_at TIMESTAMP NULL,
+    last_position BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, resource_type, resource_id)
+);
+
+CREATE TABLE discussion_participants (
+    tenant_id TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (tenant_id, resource_type, resource_id, user_id)
+);
+
+CREATE TABLE user_engagement (
+    id TEXT NOT NULL,
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    comment_count INTEGER NOT NULL DEFAULT 0,
+    reply_count INTEGER NOT NULL DEFAULT 0,
+    reaction_count INTEGER NOT NULL DEFAULT 0,
+    last_active_at TIMESTAMP NULL,
+    last_position BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, user_id)
+);
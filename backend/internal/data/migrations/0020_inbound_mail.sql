@@ -0,0 +1,20 @@
+CREATE TABLE inbound_messages (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    address TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    resource_type TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    sender TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    comment_id TEXT NOT NULL DEFAULT '',
+    file_ids TEXT NOT NULL DEFAULT '',
+    received_at TIMESTAMP NOT NULL,
+    UNIQUE (tenant_id, message_id, address)
+);
+
+CREATE INDEX inbound_messages_received_idx ON inbound_messages (tenant_id, received_at);
@@ -0,0 +1,35 @@
+CREATE TABLE policy_versions (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    version TEXT NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    summary TEXT NOT NULL DEFAULT '',
+    material BOOLEAN NOT NULL DEFAULT TRUE,
+    status TEXT NOT NULL,
+    effective_at TIMESTAMP NULL,
+    published_by TEXT NOT NULL DEFAULT '',
+    published_at TIMESTAMP NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (tenant_id, kind, version)
+);
+
+CREATE INDEX policy_versions_published_idx ON policy_versions (tenant_id, status, kind, effective_at);
+
+CREATE TABLE policy_acceptances (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    policy_id TEXT NOT NULL REFERENCES policy_versions (id),
+    kind TEXT NOT NULL,
+    version TEXT NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    accepted_at TIMESTAMP NOT NULL,
+    UNIQUE (tenant_id, user_id, policy_id)
+);
+
+CREATE INDEX policy_acceptances_policy_idx ON policy_acceptances (tenant_id, policy_id, accepted_at);
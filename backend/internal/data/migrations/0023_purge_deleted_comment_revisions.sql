@@ -0,0 +1 @@
+DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE deleted_at IS NOT NULL);
@@ -0,0 +1,440 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	moderationCaseColumns = `id, tenant_id, resource_type, resource_id, subject_user_id, excerpt, status, flags, hidden,
+	report_count, assignee_id, assigned_at, decision, decision_note, decided_by, decided_at, created_at, updated_at`
+	moderationReportColumns = `id, tenant_id, case_id, resource_type, resource_id, reporter_id, reason, details, created_at`
+	moderationRuleColumns   = `id, tenant_id, name, kind, pattern, action, enabled, created_by, created_at, updated_at`
+	moderationStrikeColumns = `id, tenant_id, user_id, case_id, kind, reason, created_by, expires_at, revoked_at, created_at`
+	moderationAppealColumns = `id, tenant_id, case_id, user_id, statement, status, decision_note, decided_by, decided_at, created_at`
+)
+
+// ModerationRepo stores the moderation queue with its reports, rules,
+// strikes, bans and appeals.
+type ModerationRepo struct {
+	db DB
+}
+
+// NewModerationRepo creates a moderation repository.
+func NewModerationRepo(db DB) *ModerationRepo {
+	return &ModerationRepo{db: db}
+}
+
+func init() {
+	maskTable("moderation_cases",
+		keep("id"), keep("tenant_id"), keep("resource_type"), userRefWhen("resource_id", "resource_type"),
+		userRef("subject_user_id"), fakeText("excerpt"), keep("status"), keep("flags"), keep("hidden"),
+		keep("report_count"), userRef("assignee_id"), shiftDate("assigned_at"), keep("decision"),
+		fakeText("decision_note"), userRef("decided_by"), shiftDate("decided_at"),
+		shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("moderation_reports",
+		keep("id"), keep("tenant_id"), keep("case_id"), keep("resource_type"), userRefWhen("resource_id", "resource_type"),
+		userRef("reporter_id"), keep("reason"), fakeText("details"), shiftDate("created_at"),
+	)
+	maskTable("moderation_rules",
+		keep("id"), keep("tenant_id"), keep("name"), keep("kind"), keep("pattern"), keep("action"), keep("enabled"),
+		userRef("created_by"), shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("moderation_strikes",
+		keep("id"), keep("tenant_id"), userRef("user_id"), keep("case_id"), keep("kind"), fakeText("reason"),
+		userRef("created_by"), shiftDate("expires_at"), shiftDate("revoked_at"), shiftDate("created_at"),
+	)
+	maskTable("moderation_bans",
+		keep("tenant_id"), userRef("user_id"), keep("case_id"), fakeText("reason"), userRef("created_by"),
+		shiftDate("until"), shiftDate("created_at"),
+	)
+	maskTable("moderation_appeals",
+		keep("id"), keep("tenant_id"), keep("case_id"), userRef("user_id"), fakeText("statement"), keep("status"),
+		fakeText("decision_note"), userRef("decided_by"), shiftDate("decided_at"), shiftDate("created_at"),
+	)
+}
+
+// OpenCase relies on the unique index over the open cases of a resource:
+// the insert does nothing when one exists.
+func (r *ModerationRepo) OpenCase(ctx context.Context, c *domain.ModerationCase) (*domain.ModerationCase, error) {
+	flags, err := encodeJSON(c.Flags)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO moderation_cases (`+moderationCaseColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, '', NULL, '', '', '', NULL, $10, $10)
+		ON CONFLICT DO NOTHING`,
+		c.ID, c.TenantID, c.ResourceType, c.ResourceID, c.SubjectUserID, c.Excerpt, c.Status, flags, c.Hidden,
+		c.CreatedAt); err != nil {
+		return nil, err
+	}
+	cases, err := r.queryCases(ctx, `SELECT `+moderationCaseColumns+` FROM moderation_cases
+		WHERE tenant_id = $1 AND resource_type = $2 AND resource_id = $3 AND status = $4`,
+		c.TenantID, c.ResourceType, c.ResourceID, domain.ModerationOpen)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, domain.NotFound("Moderation case")
+	}
+	return &cases[0], nil
+}
+
+func (r *ModerationRepo) GetCase(ctx context.Context, tenantID, id string) (*domain.ModerationCase, error) {
+	cases, err := r.queryCases(ctx, `SELECT `+moderationCaseColumns+` FROM moderation_cases
+		WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, domain.NotFound("Moderation case")
+	}
+	return &cases[0], nil
+}
+
+func (r *ModerationRepo) ListCases(ctx context.Context, tenantID string, f domain.ModerationCaseFilter, params domain.ListParams) ([]domain.ModerationCase, int, error) {
+	args := []interface{}{tenantID}
+	where := []string{"tenant_id = $1"}
+	add := func(column, value string) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if f.Status != "" {
+		add("status", f.Status)
+	}
+	switch f.Assignee {
+	case "":
+	case "none":
+		add("assignee_id", "")
+	default:
+		add("assignee_id", f.Assignee)
+	}
+	if f.ResourceType != "" {
+		add("resource_type", f.ResourceType)
+	}
+	if f.SubjectUserID != "" {
+		add("subject_user_id", f.SubjectUserID)
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM moderation_cases WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	// The open queue puts the most reported first; decided cases are
+	// listed newest first
+	order := "updated_at DESC, id DESC"
+	if f.Status == domain.ModerationOpen {
+		order = "report_count DESC, created_at, id"
+	}
+	args = append(args, params.Limit, params.Offset())
+	cases, err := r.queryCases(ctx, fmt.Sprintf(`SELECT `+moderationCaseColumns+` FROM moderation_cases
+		WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`, whereSQL, order, len(args)-1, len(args)), args...)
+	return cases, total, err
+}
+
+func (r *ModerationRepo) SaveCase(ctx context.Context, c *domain.ModerationCase) error {
+	flags, err := encodeJSON(c.Flags)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE moderation_cases SET excerpt = $1, status = $2, flags = $3, hidden = $4, assignee_id = $5,
+			assigned_at = $6, decision = $7, decision_note = $8, decided_by = $9, decided_at = $10, updated_at = $11
+		WHERE tenant_id = $12 AND id = $13`,
+		c.Excerpt, c.Status, flags, c.Hidden, c.AssigneeID, nullTime(c.AssignedAt), c.Decision, c.DecisionNote,
+		c.DecidedBy, nullTime(c.DecidedAt), c.UpdatedAt, c.TenantID, c.ID)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Moderation case")
+}
+
+func (r *ModerationRepo) AddReport(ctx context.Context, rep *domain.ModerationReport) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO moderation_reports (`+moderationReportColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (case_id, reporter_id) DO NOTHING`,
+		rep.ID, rep.TenantID, rep.CaseID, rep.ResourceType, rep.ResourceID, rep.ReporterID, rep.Reason, rep.Details,
+		rep.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE moderation_cases SET report_count = report_count + 1, updated_at = $1 WHERE id = $2`,
+		rep.CreatedAt, rep.CaseID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (r *ModerationRepo) Reports(ctx context.Context, tenantID, caseID string) ([]domain.ModerationReport, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+moderationReportColumns+` FROM moderation_reports
+		WHERE tenant_id = $1 AND case_id = $2 ORDER BY created_at, id`, tenantID, caseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []domain.ModerationReport{}
+	for rows.Next() {
+		var rep domain.ModerationReport
+		if err := rows.Scan(&rep.ID, &rep.TenantID, &rep.CaseID, &rep.ResourceType, &rep.ResourceID, &rep.ReporterID,
+			&rep.Reason, &rep.Details, &rep.CreatedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, rep)
+	}
+	return reports, rows.Err()
+}
+
+func (r *ModerationRepo) ListRules(ctx context.Context, tenantID string) ([]domain.ModerationRule, error) {
+	return r.queryRules(ctx, `SELECT `+moderationRuleColumns+` FROM moderation_rules
+		WHERE tenant_id = $1 ORDER BY created_at, id`, tenantID)
+}
+
+func (r *ModerationRepo) GetRule(ctx context.Context, tenantID, id string) (*domain.ModerationRule, error) {
+	rules, err := r.queryRules(ctx, `SELECT `+moderationRuleColumns+` FROM moderation_rules
+		WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, domain.NotFound("Moderation rule")
+	}
+	return &rules[0], nil
+}
+
+func (r *ModerationRepo) SaveRule(ctx context.Context, rule *domain.ModerationRule) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO moderation_rules (`+moderationRuleColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET name = $3, kind = $4, pattern = $5, action = $6, enabled = $7, updated_at = $10`,
+		rule.ID, rule.TenantID, rule.Name, rule.Kind, rule.Pattern, rule.Action, rule.Enabled, rule.CreatedBy,
+		rule.CreatedAt, rule.UpdatedAt)
+	return err
+}
+
+func (r *ModerationRepo) DeleteRule(ctx context.Context, tenantID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM moderation_rules WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Moderation rule")
+}
+
+func (r *ModerationRepo) AddStrike(ctx context.Context, s *domain.ModerationStrike) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO moderation_strikes (`+moderationStrikeColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULL, $9)`,
+		s.ID, s.TenantID, s.UserID, s.CaseID, s.Kind, s.Reason, s.CreatedBy, s.ExpiresAt, s.CreatedAt)
+	return err
+}
+
+func (r *ModerationRepo) Strikes(ctx context.Context, tenantID, userID string) ([]domain.ModerationStrike, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+moderationStrikeColumns+` FROM moderation_strikes
+		WHERE tenant_id = $1 AND user_id = $2 ORDER BY created_at DESC, id DESC`, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strikes := []domain.ModerationStrike{}
+	for rows.Next() {
+		var s domain.ModerationStrike
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.TenantID, &s.UserID, &s.CaseID, &s.Kind, &s.Reason, &s.CreatedBy, &s.ExpiresAt,
+			&revokedAt, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.RevokedAt = timePtr(revokedAt)
+		strikes = append(strikes, s)
+	}
+	return strikes, rows.Err()
+}
+
+func (r *ModerationRepo) RevokeStrikes(ctx context.Context, tenantID, caseID string, at time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE moderation_strikes SET revoked_at = $1 WHERE tenant_id = $2 AND case_id = $3 AND revoked_at IS NULL`,
+		at, tenantID, caseID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *ModerationRepo) SaveBan(ctx context.Context, b *domain.ModerationBan) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO moderation_bans (tenant_id, user_id, case_id, reason, created_by, until, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (tenant_id, user_id) DO UPDATE SET case_id = $3, reason = $4, created_by = $5, until = $6,
+			created_at = $7`,
+		b.TenantID, b.UserID, b.CaseID, b.Reason, b.CreatedBy, nullTime(b.Until), b.CreatedAt)
+	return err
+}
+
+func (r *ModerationRepo) GetBan(ctx context.Context, tenantID, userID string) (*domain.ModerationBan, error) {
+	var b domain.ModerationBan
+	var until sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+		SELECT tenant_id, user_id, case_id, reason, created_by, until, created_at FROM moderation_bans
+		WHERE tenant_id = $1 AND user_id = $2`, tenantID, userID).
+		Scan(&b.TenantID, &b.UserID, &b.CaseID, &b.Reason, &b.CreatedBy, &until, &b.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.Until = timePtr(until)
+	return &b, nil
+}
+
+func (r *ModerationRepo) DeleteBan(ctx context.Context, tenantID, userID string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM moderation_bans WHERE tenant_id = $1 AND user_id = $2`, tenantID, userID)
+	return err
+}
+
+func (r *ModerationRepo) CreateAppeal(ctx context.Context, a *domain.ModerationAppeal) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO moderation_appeals (`+moderationAppealColumns+`) VALUES ($1, $2, $3, $4, $5, $6, '', '', NULL, $7)`,
+		a.ID, a.TenantID, a.CaseID, a.UserID, a.Statement, a.Status, a.CreatedAt)
+	return err
+}
+
+func (r *ModerationRepo) GetAppeal(ctx context.Context, tenantID, id string) (*domain.ModerationAppeal, error) {
+	appeals, err := r.queryAppeals(ctx, `SELECT `+moderationAppealColumns+` FROM moderation_appeals
+		WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(appeals) == 0 {
+		return nil, domain.NotFound("Appeal")
+	}
+	return &appeals[0], nil
+}
+
+func (r *ModerationRepo) AppealForCase(ctx context.Context, tenantID, caseID string) (*domain.ModerationAppeal, error) {
+	appeals, err := r.queryAppeals(ctx, `SELECT `+moderationAppealColumns+` FROM moderation_appeals
+		WHERE tenant_id = $1 AND case_id = $2`, tenantID, caseID)
+	if err != nil || len(appeals) == 0 {
+		return nil, err
+	}
+	return &appeals[0], nil
+}
+
+func (r *ModerationRepo) ListAppeals(ctx context.Context, tenantID string, f domain.ModerationAppealFilter, params domain.ListParams) ([]domain.ModerationAppeal, int, error) {
+	args := []interface{}{tenantID}
+	where := []string{"tenant_id = $1"}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if f.UserID != "" {
+		args = append(args, f.UserID)
+		where = append(where, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM moderation_appeals WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, params.Limit, params.Offset())
+	appeals, err := r.queryAppeals(ctx, fmt.Sprintf(`SELECT `+moderationAppealColumns+` FROM moderation_appeals
+		WHERE %s ORDER BY created_at, id LIMIT $%d OFFSET $%d`, whereSQL, len(args)-1, len(args)), args...)
+	return appeals, total, err
+}
+
+func (r *ModerationRepo) SaveAppeal(ctx context.Context, a *domain.ModerationAppeal) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE moderation_appeals SET status = $1, decision_note = $2, decided_by = $3, decided_at = $4
+		WHERE tenant_id = $5 AND id = $6`,
+		a.Status, a.DecisionNote, a.DecidedBy, nullTime(a.DecidedAt), a.TenantID, a.ID)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Appeal")
+}
+
+func (r *ModerationRepo) queryCases(ctx context.Context, query string, args ...interface{}) ([]domain.ModerationCase, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := []domain.ModerationCase{}
+	for rows.Next() {
+		var c domain.ModerationCase
+		var flags string
+		var assignedAt, decidedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.TenantID, &c.ResourceType, &c.ResourceID, &c.SubjectUserID, &c.Excerpt, &c.Status,
+			&flags, &c.Hidden, &c.ReportCount, &c.AssigneeID, &assignedAt, &c.Decision, &c.DecisionNote, &c.DecidedBy,
+			&decidedAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.Flags = []string{}
+		if err := decodeJSON(flags, &c.Flags); err != nil {
+			return nil, err
+		}
+		c.AssignedAt = timePtr(assignedAt)
+		c.DecidedAt = timePtr(decidedAt)
+		cases = append(cases, c)
+	}
+	return cases, rows.Err()
+}
+
+func (r *ModerationRepo) queryRules(ctx context.Context, query string, args ...interface{}) ([]domain.ModerationRule, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []domain.ModerationRule{}
+	for rows.Next() {
+		var rule domain.ModerationRule
+		if err := rows.Scan(&rule.ID, &rule.TenantID, &rule.Name, &rule.Kind, &rule.Pattern, &rule.Action, &rule.Enabled,
+			&rule.CreatedBy, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (r *ModerationRepo) queryAppeals(ctx context.Context, query string, args ...interface{}) ([]domain.ModerationAppeal, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appeals := []domain.ModerationAppeal{}
+	for rows.Next() {
+		var a domain.ModerationAppeal
+		var decidedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.TenantID, &a.CaseID, &a.UserID, &a.Statement, &a.Status, &a.DecisionNote,
+			&a.DecidedBy, &decidedAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.DecidedAt = timePtr(decidedAt)
+		appeals = append(appeals, a)
+	}
+	return appeals, rows.Err()
+}
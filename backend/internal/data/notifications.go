@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// NotificationRepo stores the in-app notification inbox.
+type NotificationRepo struct {
+	db DB
+}
+
+// NewNotificationRepo creates a notification repository.
+func NewNotificationRepo(db DB) *NotificationRepo {
+	return &NotificationRepo{db: db}
+}
+
+func init() {
+	maskTable("notifications",
+		keep("id"), keep("tenant_id"), userRef("user_id"), keep("kind"),
+		fakeText("title"), fakeText("body"), userRef("actor_id"),
+		keep("resource_type"), userRefWhen("resource_id", "resource_type"),
+		shiftDate("read_at"), shiftDate("created_at"),
+	)
+}
+
+func (r *NotificationRepo) Create(ctx context.Context, n *domain.Notification) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO notifications (id, tenant_id, user_id, kind, title, body, actor_id, resource_type, resource_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		n.ID, n.TenantID, n.UserID, n.Kind, n.Title, n.Body, n.ActorID, n.ResourceType, n.ResourceID, n.CreatedAt)
+	return err
+}
+
+func (r *NotificationRepo) List(ctx context.Context, tenantID, userID string, unreadOnly bool, params domain.ListParams) ([]domain.Notification, int, error) {
+	where := `tenant_id = $1 AND user_id = $2`
+	if unreadOnly {
+		where += ` AND read_at IS NULL`
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM notifications WHERE `+where, tenantID, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, tenant_id, user_id, kind, title, body, actor_id, resource_type, resource_id, read_at, created_at
+		FROM notifications WHERE `+where+`
+		ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4`,
+		tenantID, userID, params.Limit, params.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []domain.Notification{}
+	for rows.Next() {
+		var n domain.Notification
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.TenantID, &n.UserID, &n.Kind, &n.Title, &n.Body, &n.ActorID, &n.ResourceType, &n.ResourceID, &readAt, &n.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		n.ReadAt = timePtr(readAt)
+		items = append(items, n)
+	}
+	return items, total, rows.Err()
+}
+
+func (r *NotificationRepo) MarkRead(ctx context.Context, tenantID, userID, id string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE notifications SET read_at = COALESCE(read_at, $1)
+		WHERE tenant_id = $2 AND user_id = $3 AND id = $4`, at, tenantID, userID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Notification")
+}
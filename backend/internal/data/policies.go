@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	policyColumns = `id, tenant_id, kind, version, title, body, summary, material, status, effective_at, published_by,
+	published_at, created_by, created_at, updated_at`
+	policyAcceptanceColumns = `id, tenant_id, user_id, policy_id, kind, version, ip_address, user_agent, accepted_at`
+)
+
+// PolicyRepo stores policy versions and their acceptances.
+type PolicyRepo struct {
+	db DB
+}
+
+// NewPolicyRepo creates a policy repository.
+func NewPolicyRepo(db DB) *PolicyRepo {
+	return &PolicyRepo{db: db}
+}
+
+func init() {
+	maskTable("policy_versions",
+		keep("id"), keep("tenant_id"), keep("kind"), keep("version"), keep("title"), keep("body"), keep("summary"),
+		keep("material"), keep("status"), shiftDate("effective_at"), userRef("published_by"), shiftDate("published_at"),
+		userRef("created_by"), shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("policy_acceptances",
+		keep("id"), keep("tenant_id"), userRef("user_id"), keep("policy_id"), keep("kind"), keep("version"),
+		hashed("ip_address"), blank("user_agent"), shiftDate("accepted_at"),
+	)
+}
+
+func (r *PolicyRepo) Create(ctx context.Context, p *domain.PolicyVersion) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO policy_versions (`+policyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		p.ID, p.TenantID, p.Kind, p.Version, p.Title, p.Body, p.Summary, p.Material, p.Status, nullTime(p.EffectiveAt),
+		p.PublishedBy, nullTime(p.PublishedAt), p.CreatedBy, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return conflict(err)
+	}
+	return nil
+}
+
+func (r *PolicyRepo) Get(ctx context.Context, tenantID, id string) (*domain.PolicyVersion, error) {
+	policies, err := r.query(ctx, `SELECT `+policyColumns+` FROM policy_versions WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, domain.NotFound("Policy")
+	}
+	return &policies[0], nil
+}
+
+func (r *PolicyRepo) Update(ctx context.Context, p *domain.PolicyVersion) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE policy_versions SET kind = $1, version = $2, title = $3, body = $4,
+		summary = $5, material = $6, status = $7, effective_at = $8, published_by = $9, published_at = $10, updated_at = $11
+		WHERE tenant_id = $12 AND id = $13`,
+		p.Kind, p.Version, p.Title, p.Body, p.Summary, p.Material, p.Status, nullTime(p.EffectiveAt), p.PublishedBy,
+		nullTime(p.PublishedAt), p.UpdatedAt, p.TenantID, p.ID)
+	if err != nil {
+		return conflict(err)
+	}
+	return requireRow(res, "Policy")
+}
+
+func (r *PolicyRepo) Delete(ctx context.Context, tenantID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM policy_versions WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Policy")
+}
+
+func (r *PolicyRepo) List(ctx context.Context, tenantID string, f domain.PolicyFilter, params domain.ListParams) ([]domain.PolicyVersion, int, error) {
+	args := []interface{}{tenantID}
+	where := []string{"tenant_id = $1"}
+	if f.Kind != "" {
+		args = append(args, f.Kind)
+		where = append(where, fmt.Sprintf("kind = $%d", len(args)))
+	}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("status = $%d", len(args)))
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM policy_versions WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, params.Limit, params.Offset())
+	policies, err := r.query(ctx, fmt.Sprintf(`SELECT `+policyColumns+` FROM policy_versions
+		WHERE %s ORDER BY kind, created_at DESC, id LIMIT $%d OFFSET $%d`, whereSQL, len(args)-1, len(args)), args...)
+	return policies, total, err
+}
+
+func (r *PolicyRepo) Published(ctx context.Context, tenantID string) ([]domain.PolicyVersion, error) {
+	return r.query(ctx, `SELECT `+policyColumns+` FROM policy_versions WHERE tenant_id = $1 AND status = $2
+		ORDER BY kind, effective_at, published_at, id`, tenantID, domain.PolicyPublished)
+}
+
+func (r *PolicyRepo) Accept(ctx context.Context, a *domain.PolicyAcceptance) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO policy_acceptances (`+policyAcceptanceColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (tenant_id, user_id, policy_id) DO NOTHING`,
+		a.ID, a.TenantID, a.UserID, a.PolicyID, a.Kind, a.Version, a.IPAddress, a.UserAgent, a.AcceptedAt)
+	return err
+}
+
+func (r *PolicyRepo) Acceptances(ctx context.Context, tenantID, userID string) ([]domain.PolicyAcceptance, error) {
+	return r.queryAcceptances(ctx, `SELECT `+policyAcceptanceColumns+` FROM policy_acceptances
+		WHERE tenant_id = $1 AND user_id = $2 ORDER BY accepted_at DESC, id`, tenantID, userID)
+}
+
+func (r *PolicyRepo) VersionAcceptances(ctx context.Context, tenantID, policyID string, params domain.ListParams) ([]domain.PolicyAcceptance, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM policy_acceptances WHERE tenant_id = $1 AND policy_id = $2`,
+		tenantID, policyID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	items, err := r.queryAcceptances(ctx, `SELECT `+policyAcceptanceColumns+` FROM policy_acceptances
+		WHERE tenant_id = $1 AND policy_id = $2 ORDER BY accepted_at DESC, id LIMIT $3 OFFSET $4`,
+		tenantID, policyID, params.Limit, params.Offset())
+	return items, total, err
+}
+
+func (r *PolicyRepo) CountAcceptances(ctx context.Context, tenantID string) (map[string]int, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT policy_id, COUNT(*) FROM policy_acceptances WHERE tenant_id = $1
+		GROUP BY policy_id`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+func (r *PolicyRepo) CountAccepted(ctx context.Context, tenantID string, policyIDs []string) (int, error) {
+	if len(policyIDs) == 0 {
+		return 0, nil
+	}
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT user_id) FROM policy_acceptances
+		WHERE tenant_id = $1 AND policy_id IN (`+placeholders(2, len(policyIDs))+`)`,
+		append([]interface{}{tenantID}, stringArgs(policyIDs)...)...).Scan(&n)
+	return n, err
+}
+
+func (r *PolicyRepo) CountUsers(ctx context.Context, tenantID string) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE tenant_id = $1`, tenantID).Scan(&n)
+	return n, err
+}
+
+func (r *PolicyRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.PolicyVersion, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []domain.PolicyVersion{}
+	for rows.Next() {
+		var p domain.PolicyVersion
+		var effectiveAt, publishedAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.TenantID, &p.Kind, &p.Version, &p.Title, &p.Body, &p.Summary, &p.Material,
+			&p.Status, &effectiveAt, &p.PublishedBy, &publishedAt, &p.CreatedBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		p.EffectiveAt = timePtr(effectiveAt)
+		p.PublishedAt = timePtr(publishedAt)
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func (r *PolicyRepo) queryAcceptances(ctx context.Context, query string, args ...interface{}) ([]domain.PolicyAcceptance, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []domain.PolicyAcceptance{}
+	for rows.Next() {
+		var a domain.PolicyAcceptance
+		if err := rows.Scan(&a.ID, &a.TenantID, &a.UserID, &a.PolicyID, &a.Kind, &a.Version, &a.IPAddress, &a.UserAgent,
+			&a.AcceptedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// PreferenceRepo stores user preferences and tenant defaults as JSON
+// values keyed by preference name.
+type PreferenceRepo struct {
+	db DB
+}
+
+// NewPreferenceRepo creates a preference repository.
+func NewPreferenceRepo(db DB) *PreferenceRepo {
+	return &PreferenceRepo{db: db}
+}
+
+func init() {
+	// A user's values may be free text; blank ones fall back to the
+	// defaults
+	maskTable("user_preferences", keep("tenant_id"), userRef("user_id"), keep("key"), blank("value"), shiftDate("updated_at"))
+	maskTable("tenant_preference_defaults", keep("tenant_id"), keep("key"), keep("value"), shiftDate("updated_at"))
+}
+
+func (r *PreferenceRepo) UserValues(ctx context.Context, tenantID, userID string) (map[string]json.RawMessage, error) {
+	return r.values(ctx, `SELECT key, value FROM user_preferences WHERE tenant_id = $1 AND user_id = $2`, tenantID, userID)
+}
+
+func (r *PreferenceRepo) TenantDefaults(ctx context.Context, tenantID string) (map[string]json.RawMessage, error) {
+	return r.values(ctx, `SELECT key, value FROM tenant_preference_defaults WHERE tenant_id = $1`, tenantID)
+}
+
+func (r *PreferenceRepo) SetUserValues(ctx context.Context, tenantID, userID string, values map[string]json.RawMessage, at time.Time) error {
+	return r.write(ctx, values,
+		func(tx *sql.Tx, key string) error {
+			_, err := tx.ExecContext(ctx, `DELETE FROM user_preferences WHERE tenant_id = $1 AND user_id = $2 AND key = $3`, tenantID, userID, key)
+			return err
+		},
+		func(tx *sql.Tx, key string, value json.RawMessage) error {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO user_preferences (tenant_id, user_id, key, value, updated_at) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (tenant_id, user_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+				tenantID, userID, key, string(value), at)
+			return err
+		})
+}
+
+func (r *PreferenceRepo) SetTenantDefaults(ctx context.Context, tenantID string, values map[string]json.RawMessage, at time.Time) error {
+	return r.write(ctx, values,
+		func(tx *sql.Tx, key string) error {
+			_, err := tx.ExecContext(ctx, `DELETE FROM tenant_preference_defaults WHERE tenant_id = $1 AND key = $2`, tenantID, key)
+			return err
+		},
+		func(tx *sql.Tx, key string, value json.RawMessage) error {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO tenant_preference_defaults (tenant_id, key, value, updated_at) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (tenant_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+				tenantID, key, string(value), at)
+			return err
+		})
+}
+
+// write applies a patch in one transaction: nil values are deleted, the
+// others upserted.
+func (r *PreferenceRepo) write(ctx context.Context, values map[string]json.RawMessage,
+	del func(tx *sql.Tx, key string) error, put func(tx *sql.Tx, key string, value json.RawMessage) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, value := range values {
+		if value == nil {
+			err = del(tx, key)
+		} else {
+			err = put(tx, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PreferenceRepo) values(ctx context.Context, query string, args ...interface{}) (map[string]json.RawMessage, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = json.RawMessage(value)
+	}
+	return values, rows.Err()
+}
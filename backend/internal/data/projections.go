@@ -0,0 +1,319 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const checkpointColumns = `name, position, rebuilding, last_error, failed_at, rebuilt_at, updated_at`
+
+// ProjectionCheckpointRepo stores how far each projection has read the
+// event log of its database, and which runner holds it.
+type ProjectionCheckpointRepo struct {
+	db DB
+}
+
+// NewProjectionCheckpointRepo creates a checkpoint repository.
+func NewProjectionCheckpointRepo(db DB) *ProjectionCheckpointRepo {
+	return &ProjectionCheckpointRepo{db: db}
+}
+
+func init() {
+	maskTable("projection_checkpoints",
+		keep("name"), keep("position"), keep("rebuilding"), keep("last_error"), shiftDate("failed_at"),
+		shiftDate("rebuilt_at"), blank("lease_owner"), nulled("lease_until"), shiftDate("updated_at"),
+	)
+	maskTable("discussion_stats",
+		keep("id"), keep("tenant_id"), keep("resource_type"), userRefWhen("resource_id", "resource_type"),
+		keep("comment_count"), keep("thread_count"), keep("reaction_count"), keep("participant_count"),
+		shiftDate("last_comment_at"), keep("last_position"), shiftDate("updated_at"),
+	)
+	maskTable("discussion_participants",
+		keep("tenant_id"), keep("resource_type"), userRefWhen("resource_id", "resource_type"), userRef("user_id"),
+	)
+	maskTable("user_engagement",
+		keep("id"), keep("tenant_id"), userRef("user_id"), keep("comment_count"), keep("reply_count"),
+		keep("reaction_count"), shiftDate("last_active_at"), keep("last_position"), shiftDate("updated_at"),
+	)
+}
+
+func (r *ProjectionCheckpointRepo) Claim(ctx context.Context, name, owner string, until, now time.Time) (*domain.ProjectionCheckpoint, error) {
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO projection_checkpoints (name, updated_at) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`,
+		name, now); err != nil {
+		return nil, err
+	}
+	cp, err := scanCheckpoint(r.db.QueryRowContext(ctx, `
+		UPDATE projection_checkpoints SET lease_owner = $1, lease_until = $2
+		WHERE name = $3 AND (lease_owner = $1 OR lease_owner = '' OR lease_until < $4)
+		RETURNING `+checkpointColumns, owner, until, name, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return cp, err
+}
+
+func (r *ProjectionCheckpointRepo) Save(ctx context.Context, cp *domain.ProjectionCheckpoint, owner string, until time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE projection_checkpoints
+		SET position = $1, rebuilding = $2, last_error = $3, failed_at = $4, rebuilt_at = $5, updated_at = $6, lease_until = $7
+		WHERE name = $8 AND lease_owner = $9`,
+		cp.Position, cp.Rebuilding, cp.LastError, nullTime(cp.FailedAt), nullTime(cp.RebuiltAt), cp.UpdatedAt, until,
+		cp.Name, owner)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.ErrConflict
+	}
+	return nil
+}
+
+func (r *ProjectionCheckpointRepo) Release(ctx context.Context, name, owner string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE projection_checkpoints SET lease_owner = '', lease_until = NULL WHERE name = $1 AND lease_owner = $2`,
+		name, owner)
+	return err
+}
+
+func (r *ProjectionCheckpointRepo) List(ctx context.Context) ([]domain.ProjectionCheckpoint, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+checkpointColumns+` FROM projection_checkpoints ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []domain.ProjectionCheckpoint{}
+	for rows.Next() {
+		cp, err := scanCheckpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, *cp)
+	}
+	return checkpoints, rows.Err()
+}
+
+func scanCheckpoint(sc scanner) (*domain.ProjectionCheckpoint, error) {
+	var cp domain.ProjectionCheckpoint
+	var failedAt, rebuiltAt sql.NullTime
+	if err := sc.Scan(&cp.Name, &cp.Position, &cp.Rebuilding, &cp.LastError, &failedAt, &rebuiltAt, &cp.UpdatedAt); err != nil {
+		return nil, err
+	}
+	cp.FailedAt, cp.RebuiltAt = timePtr(failedAt), timePtr(rebuiltAt)
+	cp.UpdatedAt = cp.UpdatedAt.UTC()
+	return &cp, nil
+}
+
+// DiscussionProjection maintains discussion_stats, the comment, thread,
+// reaction and participant counts of every commented resource, so
+// resources can be listed by how lively their discussion is without
+// aggregating the comments table.
+type DiscussionProjection struct {
+	db DB
+}
+
+// NewDiscussionProjection creates the discussions projection.
+func NewDiscussionProjection(db DB) *DiscussionProjection {
+	return &DiscussionProjection{db: db}
+}
+
+func (p *DiscussionProjection) Name() string { return "discussions" }
+
+func (p *DiscussionProjection) Description() string {
+	return "Comment, thread, reaction and participant counts per commented resource"
+}
+
+func (p *DiscussionProjection) Events() []string {
+	return []string{"comment.created", "comment.deleted", "comment.reaction_added", "comment.reaction_removed"}
+}
+
+func (p *DiscussionProjection) Apply(ctx context.Context, e domain.LoggedEvent) error {
+	if e.ResourceType == "" || e.ResourceID == "" {
+		return nil
+	}
+	var comments, threads, reactions int
+	var lastComment *time.Time
+	switch e.Type {
+	case "comment.created":
+		comments, lastComment = 1, &e.OccurredAt
+		if parentID, _ := e.Data["parent_id"].(string); parentID == "" {
+			threads = 1
+		}
+	case "comment.deleted":
+		comments = -1
+		if commentID, _ := e.Data["comment_id"].(string); commentID != "" && commentID == e.Data["thread_id"] {
+			threads = -1
+		}
+	case "comment.reaction_added":
+		reactions = 1
+	case "comment.reaction_removed":
+		reactions = -1
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO discussion_stats (id, tenant_id, resource_type, resource_id, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id, resource_type, resource_id) DO NOTHING`,
+		domain.NewID(), e.TenantID, e.ResourceType, e.ResourceID, now); err != nil {
+		return err
+	}
+	if e.Type == "comment.created" && e.ActorID != "" {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO discussion_participants (tenant_id, resource_type, resource_id, user_id) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, resource_type, resource_id, user_id) DO NOTHING`,
+			e.TenantID, e.ResourceType, e.ResourceID, e.ActorID); err != nil {
+			return err
+		}
+	}
+	// The position guard skips events applied before a crash that came
+	// ahead of the checkpoint being saved
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE discussion_stats SET
+			comment_count = comment_count + $1, thread_count = thread_count + $2, reaction_count = reaction_count + $3,
+			last_comment_at = COALESCE($4, last_comment_at),
+			participant_count = (SELECT COUNT(*) FROM discussion_participants p
+				WHERE p.tenant_id = $5 AND p.resource_type = $6 AND p.resource_id = $7),
+			last_position = $8, updated_at = $9
+		WHERE tenant_id = $5 AND resource_type = $6 AND resource_id = $7 AND last_position < $8`,
+		comments, threads, reactions, nullTime(lastComment), e.TenantID, e.ResourceType, e.ResourceID, e.Position, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *DiscussionProjection) Reset(ctx context.Context) error {
+	return resetTables(ctx, p.db, "discussion_stats", "discussion_participants")
+}
+
+// EngagementProjection maintains user_engagement, how much each user
+// comments and reacts, for engagement reports.
+type EngagementProjection struct {
+	db DB
+}
+
+// NewEngagementProjection creates the engagement projection.
+func NewEngagementProjection(db DB) *EngagementProjection {
+	return &EngagementProjection{db: db}
+}
+
+func (p *EngagementProjection) Name() string { return "engagement" }
+
+func (p *EngagementProjection) Description() string {
+	return "Comments, replies and reactions per user"
+}
+
+func (p *EngagementProjection) Events() []string {
+	return []string{"comment.created", "comment.reaction_added", "comment.reaction_removed"}
+}
+
+func (p *EngagementProjection) Apply(ctx context.Context, e domain.LoggedEvent) error {
+	if e.ActorID == "" {
+		return nil
+	}
+	var comments, replies, reactions int
+	switch e.Type {
+	case "comment.created":
+		comments = 1
+		if parentID, _ := e.Data["parent_id"].(string); parentID != "" {
+			replies = 1
+		}
+	case "comment.reaction_added":
+		reactions = 1
+	case "comment.reaction_removed":
+		reactions = -1
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_engagement (id, tenant_id, user_id, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, user_id) DO NOTHING`,
+		domain.NewID(), e.TenantID, e.ActorID, now); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE user_engagement SET
+			comment_count = comment_count + $1, reply_count = reply_count + $2, reaction_count = reaction_count + $3,
+			last_active_at = $4, last_position = $5, updated_at = $6
+		WHERE tenant_id = $7 AND user_id = $8 AND last_position < $5`,
+		comments, replies, reactions, e.OccurredAt, e.Position, now, e.TenantID, e.ActorID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *EngagementProjection) Reset(ctx context.Context) error {
+	return resetTables(ctx, p.db, "user_engagement")
+}
+
+func resetTables(ctx context.Context, db DB, tables ...string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range tables {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// NewDiscussionListSource lists the discussion statistics of the caller's
+// tenant, maintained by DiscussionProjection.
+func NewDiscussionListSource(db DB) *SQLListSource {
+	return &SQLListSource{
+		db:    db,
+		name:  "discussions",
+		table: "discussion_stats",
+		fields: []domain.ListField{
+			{Name: "id", Type: domain.FieldString, Column: "id", Filterable: true},
+			{Name: "resource_type", Type: domain.FieldString, Column: "resource_type", Filterable: true, Sortable: true, Default: true},
+			{Name: "resource_id", Type: domain.FieldString, Column: "resource_id", Filterable: true, Sortable: true, Default: true},
+			{Name: "comment_count", Type: domain.FieldInt, Column: "comment_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "thread_count", Type: domain.FieldInt, Column: "thread_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "reaction_count", Type: domain.FieldInt, Column: "reaction_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "participant_count", Type: domain.FieldInt, Column: "participant_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "last_comment_at", Type: domain.FieldTime, Column: "last_comment_at", Filterable: true, Sortable: true, Default: true},
+		},
+		defaultOrder: []domain.SortField{{Field: "last_comment_at", Desc: true}},
+	}
+}
+
+// NewEngagementListSource lists the engagement of the users of the
+// caller's tenant, maintained by EngagementProjection.
+func NewEngagementListSource(db DB) *SQLListSource {
+	return &SQLListSource{
+		db:    db,
+		name:  "engagement",
+		table: "user_engagement",
+		fields: []domain.ListField{
+			{Name: "id", Type: domain.FieldString, Column: "id", Filterable: true},
+			{Name: "user_id", Type: domain.FieldString, Column: "user_id", Filterable: true, Sortable: true, Default: true},
+			{Name: "comment_count", Type: domain.FieldInt, Column: "comment_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "reply_count", Type: domain.FieldInt, Column: "reply_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "reaction_count", Type: domain.FieldInt, Column: "reaction_count", Filterable: true, Sortable: true, Default: true},
+			{Name: "last_active_at", Type: domain.FieldTime, Column: "last_active_at", Filterable: true, Sortable: true, Default: true},
+		},
+		defaultOrder: []domain.SortField{{Field: "comment_count", Desc: true}},
+	}
+}
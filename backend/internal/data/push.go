@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const pushColumns = `id, tenant_id, user_id, endpoint, p256dh, auth, device, user_agent, expires_at, last_success_at, created_at, updated_at`
+
+// PushRepo stores Web Push subscriptions.
+type PushRepo struct {
+	db DB
+}
+
+// NewPushRepo creates a push subscription repository.
+func NewPushRepo(db DB) *PushRepo {
+	return &PushRepo{db: db}
+}
+
+func init() {
+	maskTable("push_subscriptions",
+		keep("id"), keep("tenant_id"), userRef("user_id"), hashed("endpoint"), blank("p256dh"), blank("auth"),
+		fakeText("device"), blank("user_agent"), shiftDate("expires_at"), shiftDate("last_success_at"),
+		shiftDate("created_at"), shiftDate("updated_at"),
+	)
+}
+
+// Save inserts the subscription or, when its endpoint is known, moves the
+// existing one to the subscription's user with the new keys; sub gets the
+// stored ID and creation time.
+func (r *PushRepo) Save(ctx context.Context, sub *domain.PushSubscription) error {
+	return r.db.QueryRowContext(ctx, `
+		INSERT INTO push_subscriptions (`+pushColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULL, $10, $10)
+		ON CONFLICT (endpoint) DO UPDATE SET tenant_id = $2, user_id = $3, p256dh = $5, auth = $6, device = $7,
+			user_agent = $8, expires_at = $9, updated_at = $10
+		RETURNING id, created_at`,
+		sub.ID, sub.TenantID, sub.UserID, sub.Endpoint, sub.P256dh, sub.Auth, sub.Device, sub.UserAgent,
+		nullTime(sub.ExpiresAt), sub.UpdatedAt).Scan(&sub.ID, &sub.CreatedAt)
+}
+
+func (r *PushRepo) Get(ctx context.Context, id string) (*domain.PushSubscription, error) {
+	subs, err := r.query(ctx, `SELECT `+pushColumns+` FROM push_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, domain.NotFound("Push subscription")
+	}
+	return &subs[0], nil
+}
+
+func (r *PushRepo) List(ctx context.Context, tenantID, userID string) ([]domain.PushSubscription, error) {
+	return r.query(ctx, `SELECT `+pushColumns+` FROM push_subscriptions WHERE tenant_id = $1 AND user_id = $2
+		ORDER BY created_at, id`, tenantID, userID)
+}
+
+func (r *PushRepo) Delete(ctx context.Context, tenantID, userID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE tenant_id = $1 AND user_id = $2 AND id = $3`,
+		tenantID, userID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Push subscription")
+}
+
+func (r *PushRepo) Remove(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE id = $1`, id)
+	return err
+}
+
+func (r *PushRepo) Delivered(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE push_subscriptions SET last_success_at = $1 WHERE id = $2`, at, id)
+	return err
+}
+
+func (r *PushRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.PushSubscription, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []domain.PushSubscription{}
+	for rows.Next() {
+		var s domain.PushSubscription
+		var expiresAt, lastSuccessAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.TenantID, &s.UserID, &s.Endpoint, &s.P256dh, &s.Auth, &s.Device, &s.UserAgent,
+			&expiresAt, &lastSuccessAt, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		s.ExpiresAt = timePtr(expiresAt)
+		s.LastSuccessAt = timePtr(lastSuccessAt)
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// realtimeLogLock is the advisory lock key serializing appends.
+const realtimeLogLock = 7_146_511
+
+// RealtimeLogRepo is the message log of the Postgres backplane. Appends
+// hold an advisory lock, so sequence numbers commit in order and readers
+// can page through the log without ever skipping a message that commits
+// late. It requires PostgreSQL.
+type RealtimeLogRepo struct {
+	db DB
+}
+
+// NewRealtimeLogRepo creates a realtime message log.
+func NewRealtimeLogRepo(db DB) *RealtimeLogRepo {
+	return &RealtimeLogRepo{db: db}
+}
+
+func init() {
+	maskTable("realtime_messages", keep("seq"), blank("body"), shiftDate("created_at"))
+}
+
+// Append stores a message under the next sequence number and notifies
+// the listeners of channel when it commits.
+func (r *RealtimeLogRepo) Append(ctx context.Context, channel string, body []byte, at time.Time) (uint64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, realtimeLogLock); err != nil {
+		return 0, err
+	}
+	var seq uint64
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO realtime_messages (seq, body, created_at)
+		SELECT COALESCE(MAX(seq), 0) + 1, $1, $2 FROM realtime_messages
+		RETURNING seq`, string(body), at).Scan(&seq); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, strconv.FormatUint(seq, 10)); err != nil {
+		return 0, err
+	}
+	return seq, tx.Commit()
+}
+
+// After calls fn with up to limit messages following seq, in order, and
+// returns how many there were.
+func (r *RealtimeLogRepo) After(ctx context.Context, seq uint64, limit int, fn func(seq uint64, body []byte)) (int, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT seq, body FROM realtime_messages WHERE seq > $1 ORDER BY seq LIMIT $2`,
+		int64(seq), limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var s int64
+		var body string
+		if err := rows.Scan(&s, &body); err != nil {
+			return n, err
+		}
+		fn(uint64(s), []byte(body))
+		n++
+	}
+	return n, rows.Err()
+}
+
+// Prune deletes messages older than before. The newest message is kept
+// so the sequence continues after a quiet period.
+func (r *RealtimeLogRepo) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM realtime_messages WHERE created_at < $1
+		AND seq < (SELECT MAX(seq) FROM realtime_messages)`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
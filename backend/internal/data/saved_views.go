@@ -0,0 +1,249 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const savedViewColumns = `v.id, v.tenant_id, v.owner_id, v.name, v.source, v.query,
+	v.schedule_frequency, v.schedule_hour, v.schedule_weekday, v.next_run_at, v.last_sent_at,
+	v.created_at, v.updated_at`
+
+// SavedViewRepo stores saved views, their team shares and schedules.
+type SavedViewRepo struct {
+	db DB
+}
+
+// NewSavedViewRepo creates a saved view repository.
+func NewSavedViewRepo(db DB) *SavedViewRepo {
+	return &SavedViewRepo{db: db}
+}
+
+func init() {
+	// Names and filter values are typed by users; a blank query lists
+	// everything
+	maskTable("saved_views",
+		keep("id"), keep("tenant_id"), userRef("owner_id"), fakeText("name"), keep("source"), blank("query"),
+		keep("schedule_frequency"), keep("schedule_hour"), keep("schedule_weekday"),
+		shiftDate("next_run_at"), nulled("last_sent_at"), shiftDate("created_at"), shiftDate("updated_at"),
+	)
+	maskTable("saved_view_shares", keep("view_id"), hashed("team_id"))
+}
+
+func (r *SavedViewRepo) Create(ctx context.Context, v *domain.SavedView) error {
+	query, err := json.Marshal(v.Query)
+	if err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO saved_views (id, tenant_id, owner_id, name, source, query, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		v.ID, v.TenantID, v.OwnerID, v.Name, v.Source, string(query), v.CreatedAt, v.UpdatedAt); err != nil {
+		return err
+	}
+	if err := replaceShares(ctx, tx, v.ID, v.SharedWithTeams); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SavedViewRepo) Get(ctx context.Context, tenantID, id string) (*domain.SavedView, error) {
+	views, err := r.query(ctx, `SELECT `+savedViewColumns+` FROM saved_views v WHERE v.tenant_id = $1 AND v.id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(views) == 0 {
+		return nil, domain.NotFound("Saved view")
+	}
+	return &views[0], nil
+}
+
+func (r *SavedViewRepo) ListVisible(ctx context.Context, tenantID, userID string, teams []string, params domain.ListParams) ([]domain.SavedView, int, error) {
+	where := `v.tenant_id = $1 AND v.owner_id = $2`
+	args := []interface{}{tenantID, userID}
+	if len(teams) > 0 {
+		where = `v.tenant_id = $1 AND (v.owner_id = $2 OR v.id IN (
+			SELECT view_id FROM saved_view_shares WHERE team_id IN (` + placeholders(3, len(teams)) + `)))`
+		args = append(args, stringArgs(teams)...)
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM saved_views v WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	n := len(args)
+	args = append(args, params.Limit, params.Offset())
+	views, err := r.query(ctx, fmt.Sprintf(`SELECT `+savedViewColumns+` FROM saved_views v WHERE `+where+`
+		ORDER BY v.name, v.id LIMIT $%d OFFSET $%d`, n+1, n+2), args...)
+	return views, total, err
+}
+
+func (r *SavedViewRepo) Update(ctx context.Context, v *domain.SavedView) error {
+	query, err := json.Marshal(v.Query)
+	if err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE saved_views SET name = $1, source = $2, query = $3, updated_at = $4
+		WHERE tenant_id = $5 AND id = $6`,
+		v.Name, v.Source, string(query), v.UpdatedAt, v.TenantID, v.ID)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Saved view"); err != nil {
+		return err
+	}
+	if err := replaceShares(ctx, tx, v.ID, v.SharedWithTeams); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SavedViewRepo) Delete(ctx context.Context, tenantID, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM saved_view_shares WHERE view_id = $1`, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM saved_views WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, "Saved view"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SavedViewRepo) SetSchedule(ctx context.Context, tenantID, id string, s *domain.ViewSchedule) error {
+	var res sql.Result
+	var err error
+	if s == nil {
+		res, err = r.db.ExecContext(ctx, `
+			UPDATE saved_views SET schedule_frequency = NULL, schedule_hour = NULL, schedule_weekday = NULL, next_run_at = NULL
+			WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	} else {
+		res, err = r.db.ExecContext(ctx, `
+			UPDATE saved_views SET schedule_frequency = $1, schedule_hour = $2, schedule_weekday = $3, next_run_at = $4
+			WHERE tenant_id = $5 AND id = $6`, s.Frequency, s.Hour, s.Weekday, s.NextRunAt, tenantID, id)
+	}
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Saved view")
+}
+
+func (r *SavedViewRepo) DueSchedules(ctx context.Context, now time.Time, limit int) ([]domain.SavedView, error) {
+	return r.query(ctx, `SELECT `+savedViewColumns+` FROM saved_views v
+		WHERE v.next_run_at IS NOT NULL AND v.next_run_at <= $1
+		ORDER BY v.next_run_at, v.id LIMIT $2`, now, limit)
+}
+
+func (r *SavedViewRepo) AdvanceSchedule(ctx context.Context, id string, next time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE saved_views SET next_run_at = $1 WHERE id = $2`, next, id)
+	return err
+}
+
+func (r *SavedViewRepo) MarkDelivered(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE saved_views SET last_sent_at = $1 WHERE id = $2`, at, id)
+	return err
+}
+
+func (r *SavedViewRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.SavedView, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []domain.SavedView{}
+	for rows.Next() {
+		var v domain.SavedView
+		var rawQuery string
+		var frequency sql.NullString
+		var hour, weekday sql.NullInt64
+		var nextRunAt, lastSentAt sql.NullTime
+		if err := rows.Scan(&v.ID, &v.TenantID, &v.OwnerID, &v.Name, &v.Source, &rawQuery,
+			&frequency, &hour, &weekday, &nextRunAt, &lastSentAt, &v.CreatedAt, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(rawQuery, &v.Query); err != nil {
+			return nil, err
+		}
+		if frequency.Valid {
+			v.Schedule = &domain.ViewSchedule{
+				Frequency:  frequency.String,
+				Hour:       int(hour.Int64),
+				Weekday:    int(weekday.Int64),
+				NextRunAt:  nextRunAt.Time.UTC(),
+				LastSentAt: timePtr(lastSentAt),
+			}
+		}
+		v.SharedWithTeams = []string{}
+		views = append(views, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return views, r.loadShares(ctx, views)
+}
+
+func (r *SavedViewRepo) loadShares(ctx context.Context, views []domain.SavedView) error {
+	if len(views) == 0 {
+		return nil
+	}
+	index := make(map[string]*domain.SavedView, len(views))
+	ids := make([]string, len(views))
+	for i := range views {
+		index[views[i].ID] = &views[i]
+		ids[i] = views[i].ID
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT view_id, team_id FROM saved_view_shares
+		WHERE view_id IN (`+placeholders(1, len(ids))+`) ORDER BY team_id`, stringArgs(ids)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var viewID, teamID string
+		if err := rows.Scan(&viewID, &teamID); err != nil {
+			return err
+		}
+		index[viewID].SharedWithTeams = append(index[viewID].SharedWithTeams, teamID)
+	}
+	return rows.Err()
+}
+
+func replaceShares(ctx context.Context, tx *sql.Tx, viewID string, teams []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM saved_view_shares WHERE view_id = $1`, viewID); err != nil {
+		return err
+	}
+	for _, team := range teams {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO saved_view_shares (view_id, team_id) VALUES ($1, $2)`, viewID, team); err != nil {
+			return err
+		}
+	}
+	return nil
+}
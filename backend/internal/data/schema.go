@@ -0,0 +1,439 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Schema describes the tables of a database as normalized definitions, so
+// two databases of the same dialect can be compared object by object.
+type Schema map[string]*TableSchema
+
+// TableSchema holds the definitions of a table's columns, indexes and
+// constraints, keyed by name.
+type TableSchema struct {
+	Columns     map[string]string `json:"columns"`
+	Indexes     map[string]string `json:"indexes"`
+	Constraints map[string]string `json:"constraints"`
+}
+
+func newTableSchema() *TableSchema {
+	return &TableSchema{Columns: map[string]string{}, Indexes: map[string]string{}, Constraints: map[string]string{}}
+}
+
+// SchemaDrift is one difference between the live and the expected schema.
+type SchemaDrift struct {
+	Object   string `json:"object"`
+	Problem  string `json:"problem"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+func (d SchemaDrift) String() string {
+	switch {
+	case d.Expected != "" && d.Actual != "":
+		return fmt.Sprintf("%s %s: expected %q, found %q", d.Object, d.Problem, d.Expected, d.Actual)
+	case d.Expected != "":
+		return fmt.Sprintf("%s %s: expected %q", d.Object, d.Problem, d.Expected)
+	case d.Actual != "":
+		return fmt.Sprintf("%s %s: found %q", d.Object, d.Problem, d.Actual)
+	}
+	return d.Object + " " + d.Problem
+}
+
+// dialect returns "postgres" or "sqlite" for a database/sql driver name.
+func dialect(driver string) string {
+	switch driver {
+	case "postgres", "pgx":
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// VerifySchema compares the live schema with the one the embedded
+// migrations produce, and the applied migrations with the embedded ones.
+// It returns no drift for a database that was only changed by migrations.
+func VerifySchema(ctx context.Context, db *sql.DB, driver string) ([]SchemaDrift, error) {
+	drift, err := migrationDrift(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var live, expected Schema
+	if dialect(driver) == "postgres" {
+		if live, err = postgresSchema(ctx, db, "current_schema()"); err != nil {
+			return nil, err
+		}
+		expected, err = expectedPostgresSchema(ctx, db)
+	} else {
+		if live, err = sqliteSchema(ctx, db); err != nil {
+			return nil, err
+		}
+		expected, err = expectedSQLiteSchema(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("build expected schema: %w", err)
+	}
+	return append(drift, diffSchemas(expected, live)...), nil
+}
+
+// migrationDrift reports embedded migrations that were not applied and
+// applied migrations this binary does not know, e.g. after a rollback.
+func migrationDrift(ctx context.Context, db *sql.DB) ([]SchemaDrift, error) {
+	applied, err := AppliedMigrations(ctx, db)
+	if err != nil {
+		// A database that was never migrated has no schema_migrations
+		msg := err.Error()
+		if !strings.Contains(msg, "no such table") && !strings.Contains(msg, "does not exist") {
+			return nil, err
+		}
+		applied = map[string]bool{}
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var drift []SchemaDrift
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			drift = append(drift, SchemaDrift{Object: "migration " + m.Version, Problem: "is not applied"})
+		}
+		delete(applied, m.Version)
+	}
+	for version := range applied {
+		drift = append(drift, SchemaDrift{Object: "migration " + version, Problem: "is applied but not embedded in this build"})
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Object < drift[j].Object })
+	return drift, nil
+}
+
+func diffSchemas(expected, live Schema) []SchemaDrift {
+	var drift []SchemaDrift
+	for _, table := range sortedKeys(expected) {
+		got, ok := live[table]
+		if !ok {
+			drift = append(drift, SchemaDrift{Object: "table " + table, Problem: "is missing"})
+			continue
+		}
+		want := expected[table]
+		drift = append(drift, diffObjects("column "+table+".", want.Columns, got.Columns)...)
+		drift = append(drift, diffObjects("index "+table+".", want.Indexes, got.Indexes)...)
+		drift = append(drift, diffObjects("constraint "+table+".", want.Constraints, got.Constraints)...)
+	}
+	for _, table := range sortedKeys(live) {
+		if _, ok := expected[table]; !ok {
+			drift = append(drift, SchemaDrift{Object: "table " + table, Problem: "is not created by any migration"})
+		}
+	}
+	return drift
+}
+
+func diffObjects(prefix string, want, got map[string]string) []SchemaDrift {
+	var drift []SchemaDrift
+	for _, name := range sortedKeys(want) {
+		def, ok := got[name]
+		switch {
+		case !ok:
+			drift = append(drift, SchemaDrift{Object: prefix + name, Problem: "is missing", Expected: want[name]})
+		case def != want[name]:
+			drift = append(drift, SchemaDrift{Object: prefix + name, Problem: "differs", Expected: want[name], Actual: def})
+		}
+	}
+	for _, name := range sortedKeys(got) {
+		if _, ok := want[name]; !ok {
+			drift = append(drift, SchemaDrift{Object: prefix + name, Problem: "is not created by any migration", Actual: got[name]})
+		}
+	}
+	return drift
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expectedSQLiteSchema applies the migrations to an in-memory database.
+func expectedSQLiteSchema(ctx context.Context) (Schema, error) {
+	db, err := sql.Open("sqlite", "file:expected-schema?mode=memory")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// Each connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	if err := Migrate(ctx, db); err != nil {
+		return nil, err
+	}
+	return sqliteSchema(ctx, db)
+}
+
+// sqliteColumns lists the columns of every application table in order.
+func sqliteColumns(ctx context.Context, db *sql.DB) (map[string][]string, error) {
+	var tables []string
+	err := queryRows(ctx, db, `
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`,
+		nil, func(scan func(...interface{}) error) error {
+			var name string
+			if err := scan(&name); err != nil {
+				return err
+			}
+			tables = append(tables, name)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string][]string, len(tables))
+	for _, table := range tables {
+		err := queryRows(ctx, db, `SELECT name FROM pragma_table_info($1) ORDER BY cid`,
+			[]interface{}{table}, func(scan func(...interface{}) error) error {
+				var name string
+				if err := scan(&name); err != nil {
+					return err
+				}
+				columns[table] = append(columns[table], name)
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// sqliteSchema reads the schema from SQLite's catalog and pragmas.
+func sqliteSchema(ctx context.Context, db *sql.DB) (Schema, error) {
+	schema := Schema{}
+	columns, err := sqliteColumns(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for table := range columns {
+		t := newTableSchema()
+		schema[table] = t
+
+		var pk []string
+		err := queryRows(ctx, db, `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info($1) ORDER BY pk, cid`,
+			[]interface{}{table}, func(scan func(...interface{}) error) error {
+				var name, typ string
+				var notNull, pkPos int
+				var dflt sql.NullString
+				if err := scan(&name, &typ, &notNull, &dflt, &pkPos); err != nil {
+					return err
+				}
+				def := strings.ToUpper(typ)
+				if notNull == 1 {
+					def += " NOT NULL"
+				}
+				if dflt.Valid {
+					def += " DEFAULT " + dflt.String
+				}
+				t.Columns[name] = def
+				if pkPos > 0 {
+					pk = append(pk, name)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+		if len(pk) > 0 {
+			t.Constraints["primary_key"] = "PRIMARY KEY (" + strings.Join(pk, ", ") + ")"
+		}
+
+		err = queryRows(ctx, db, `SELECT "from", "table", "to", on_delete FROM pragma_foreign_key_list($1) ORDER BY id, seq`,
+			[]interface{}{table}, func(scan func(...interface{}) error) error {
+				var from, ref string
+				var to sql.NullString
+				var onDelete string
+				if err := scan(&from, &ref, &to, &onDelete); err != nil {
+					return err
+				}
+				def := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", from, ref, to.String)
+				if onDelete != "NO ACTION" {
+					def += " ON DELETE " + onDelete
+				}
+				t.Constraints["foreign_key_"+from] = def
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		// Indexes backing the primary key are covered by its constraint
+		var indexes []string
+		unique := map[string]bool{}
+		err = queryRows(ctx, db, `SELECT name, "unique" FROM pragma_index_list($1) WHERE origin != 'pk'`,
+			[]interface{}{table}, func(scan func(...interface{}) error) error {
+				var name string
+				var u int
+				if err := scan(&name, &u); err != nil {
+					return err
+				}
+				indexes = append(indexes, name)
+				unique[name] = u == 1
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			var cols []string
+			err := queryRows(ctx, db, `SELECT name FROM pragma_index_info($1) ORDER BY seqno`,
+				[]interface{}{index}, func(scan func(...interface{}) error) error {
+					var name sql.NullString
+					if err := scan(&name); err != nil {
+						return err
+					}
+					cols = append(cols, name.String)
+					return nil
+				})
+			if err != nil {
+				return nil, err
+			}
+			def := "(" + strings.Join(cols, ", ") + ")"
+			if unique[index] {
+				def = "UNIQUE " + def
+			}
+			t.Indexes[index] = def
+		}
+	}
+	return schema, nil
+}
+
+// expectedPostgresSchema applies the migrations to a scratch schema inside
+// a transaction that is rolled back, so nothing is left behind.
+func expectedPostgresSchema(ctx context.Context, db *sql.DB) (Schema, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	const scratch = "schema_verify_scratch"
+	if _, err := tx.ExecContext(ctx, `CREATE SCHEMA `+scratch); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SET LOCAL search_path TO `+scratch); err != nil {
+		return nil, err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range migrations {
+		// CONCURRENTLY cannot run in a transaction and makes no difference
+		// to the resulting schema
+		if _, err := tx.ExecContext(ctx, strings.ReplaceAll(m.SQL, " CONCURRENTLY", "")); err != nil {
+			return nil, fmt.Errorf("migration %s: %w", m.Version, err)
+		}
+	}
+	return postgresSchema(ctx, tx, "'"+scratch+"'")
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// postgresSchema reads the schema named by the SQL expression schemaExpr
+// from the system catalogs. Schema names are stripped from definitions so
+// schemas can be compared with each other.
+func postgresSchema(ctx context.Context, db queryer, schemaExpr string) (Schema, error) {
+	schema := Schema{}
+	table := func(name string) *TableSchema {
+		if schema[name] == nil {
+			schema[name] = newTableSchema()
+		}
+		return schema[name]
+	}
+
+	err := queryRows(ctx, db, `
+		SELECT table_name, column_name, data_type, is_nullable, COALESCE(column_default, '')
+		FROM information_schema.columns
+		WHERE table_schema = `+schemaExpr+` AND table_name != 'schema_migrations'`,
+		nil, func(scan func(...interface{}) error) error {
+			var t, name, typ, nullable, dflt string
+			if err := scan(&t, &name, &typ, &nullable, &dflt); err != nil {
+				return err
+			}
+			def := strings.ToUpper(typ)
+			if nullable == "NO" {
+				def += " NOT NULL"
+			}
+			if dflt != "" {
+				def += " DEFAULT " + dflt
+			}
+			table(t).Columns[name] = def
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix string
+	err = queryRows(ctx, db, `SELECT `+schemaExpr, nil, func(scan func(...interface{}) error) error {
+		return scan(&prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix += "."
+
+	err = queryRows(ctx, db, `
+		SELECT tablename, indexname, indexdef FROM pg_indexes
+		WHERE schemaname = `+schemaExpr+` AND tablename != 'schema_migrations'`,
+		nil, func(scan func(...interface{}) error) error {
+			var t, name, def string
+			if err := scan(&t, &name, &def); err != nil {
+				return err
+			}
+			table(t).Indexes[name] = strings.ReplaceAll(def, prefix, "")
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, db, `
+		SELECT cls.relname, con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class cls ON cls.oid = con.conrelid
+		JOIN pg_namespace ns ON ns.oid = cls.relnamespace
+		WHERE ns.nspname = `+schemaExpr+` AND cls.relname != 'schema_migrations'`,
+		nil, func(scan func(...interface{}) error) error {
+			var t, name, def string
+			if err := scan(&t, &name, &def); err != nil {
+				return err
+			}
+			table(t).Constraints[name] = strings.ReplaceAll(def, prefix, "")
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// queryRows runs a query and calls fn with a scan function for each row.
+func queryRows(ctx context.Context, db queryer, query string, args []interface{}, fn func(scan func(...interface{}) error) error) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,462 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshots copy the database for development use with every column put
+// through a masking rule. Rules are declared next to the repository that
+// owns the table (see the init functions of users.go, comments.go, ...);
+// CheckMasking fails when a migration adds a column nobody masked.
+
+// maskFunc returns the value to store for a column. row holds the
+// original values of the whole row.
+type maskFunc func(m *masker, v interface{}, row map[string]interface{}) interface{}
+
+// MaskRule masks one column.
+type MaskRule struct {
+	Column string
+	Kind   string
+	fn     maskFunc
+}
+
+type tableMasking struct {
+	rules map[string]MaskRule
+	// skip leaves the table empty in snapshots, for tables whose rows are
+	// transient or too free-form to mask, such as job payloads.
+	skip bool
+}
+
+var maskingRules = make(map[string]tableMasking)
+
+// maskTable declares the masking rules of a table. It is called from init
+// functions, so it is not synchronized.
+func maskTable(table string, rules ...MaskRule) {
+	t := tableMasking{rules: make(map[string]MaskRule, len(rules))}
+	for _, r := range rules {
+		t.rules[r.Column] = r
+	}
+	maskingRules[table] = t
+}
+
+// skipTable declares that a table's rows are not copied into snapshots.
+func skipTable(table string) {
+	maskingRules[table] = tableMasking{skip: true}
+}
+
+// keep copies values that identify nobody: generated IDs, tenant IDs,
+// enumerations, counters.
+func keep(column string) MaskRule {
+	return MaskRule{column, "keep", func(_ *masker, v interface{}, _ map[string]interface{}) interface{} { return v }}
+}
+
+// hashed replaces values with a keyed hash, so equal values stay equal.
+func hashed(column string) MaskRule {
+	return MaskRule{column, "hash", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		return m.mapString(v, func(s string) string { return hex.EncodeToString(m.sum("hash", s)) })
+	}}
+}
+
+// userRef pseudonymizes user IDs. Every column holding a user ID must use
+// it so references between tables still match.
+func userRef(column string) MaskRule {
+	return MaskRule{column, "user_ref", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		return m.mapString(v, m.userID)
+	}}
+}
+
+// userRefWhen pseudonymizes a polymorphic reference when typeColumn names
+// the users resource, and keeps it otherwise.
+func userRefWhen(column, typeColumn string) MaskRule {
+	return MaskRule{column, "user_ref_when", func(m *masker, v interface{}, row map[string]interface{}) interface{} {
+		if t, _ := row[typeColumn].(string); t == "user" || t == "users" {
+			return m.mapString(v, m.userID)
+		}
+		return v
+	}}
+}
+
+// fakeName replaces a person's name with a generated one.
+func fakeName(column string) MaskRule {
+	return MaskRule{column, "fake_name", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		return m.mapString(v, func(s string) string {
+			first, last := m.person("name", s)
+			return first + " " + last
+		})
+	}}
+}
+
+// fakeUsername replaces a login name; the result is unique for distinct
+// inputs in practice.
+func fakeUsername(column string) MaskRule {
+	return MaskRule{column, "fake_username", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		return m.mapString(v, func(s string) string {
+			first, last := m.person("username", s)
+			return strings.ToLower(first+"."+last) + "." + hex.EncodeToString(m.sum("username", s)[:3])
+		})
+	}}
+}
+
+// fakeEmail replaces an address with one under the reserved example.com
+// domain.
+func fakeEmail(column string) MaskRule {
+	return MaskRule{column, "fake_email", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		return m.mapString(v, func(s string) string {
+			first, last := m.person("email", s)
+			return strings.ToLower(first+"."+last) + "." + hex.EncodeToString(m.sum("email", s)[:3]) + "@example.com"
+		})
+	}}
+}
+
+// fakeText replaces free text with filler of about the same length.
+func fakeText(column string) MaskRule {
+	return MaskRule{column, "fake_text", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		return m.mapString(v, func(s string) string { return m.filler(s) })
+	}}
+}
+
+// blank empties a NOT NULL text column.
+func blank(column string) MaskRule {
+	return MaskRule{column, "blank", func(_ *masker, v interface{}, _ map[string]interface{}) interface{} {
+		if v == nil {
+			return nil
+		}
+		return ""
+	}}
+}
+
+// nulled drops the value of a nullable column.
+func nulled(column string) MaskRule {
+	return MaskRule{column, "null", func(*masker, interface{}, map[string]interface{}) interface{} { return nil }}
+}
+
+// shiftDate moves timestamps by the snapshot's offset. All dates move by
+// the same amount, so their order and the intervals between them survive.
+func shiftDate(column string) MaskRule {
+	return MaskRule{column, "shift_date", func(m *masker, v interface{}, _ map[string]interface{}) interface{} {
+		if t, ok := v.(time.Time); ok {
+			return t.Add(m.shift)
+		}
+		return v
+	}}
+}
+
+// masker holds the per-snapshot secret and date offset. The secret is
+// random and discarded, so masked values cannot be traced back.
+type masker struct {
+	key   []byte
+	shift time.Duration
+}
+
+func newMasker(shiftDays int) (*masker, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if shiftDays == 0 {
+		shiftDays = -(1 + int(binary.BigEndian.Uint16(key[:2]))%365)
+	}
+	return &masker{key: key, shift: time.Duration(shiftDays) * 24 * time.Hour}, nil
+}
+
+func (m *masker) sum(purpose, s string) []byte {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(purpose + "\x00" + s))
+	return mac.Sum(nil)
+}
+
+func (m *masker) mapString(v interface{}, fn func(string) string) interface{} {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return v
+	}
+	return fn(s)
+}
+
+func (m *masker) userID(s string) string {
+	return "user-" + hex.EncodeToString(m.sum("user", s)[:10])
+}
+
+func (m *masker) person(purpose, s string) (string, string) {
+	h := m.sum(purpose+"/person", s)
+	return firstNames[int(h[0])%len(firstNames)], lastNames[int(h[1])%len(lastNames)]
+}
+
+func (m *masker) filler(s string) string {
+	h := m.sum("text", s)
+	var b strings.Builder
+	for i := 0; b.Len() < len(s); i++ {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fillerWords[int(h[i%len(h)]+byte(i))%len(fillerWords)])
+	}
+	return b.String()
+}
+
+var (
+	firstNames  = []string{"Ada", "Alan", "Barbara", "Claude", "Dennis", "Edsger", "Frances", "Grace", "Hedy", "Ivan", "Joan", "Ken", "Linus", "Margaret", "Niklaus", "Radia", "Sophie", "Tim"}
+	lastNames   = []string{"Allen", "Bosak", "Cerf", "Dijkstra", "Engelbart", "Floyd", "Goldberg", "Hopper", "Iverson", "Knuth", "Lamport", "Liskov", "McCarthy", "Perlman", "Ritchie", "Thompson", "Wilson", "Wirth"}
+	fillerWords = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua"}
+)
+
+// SnapshotOptions configures a snapshot.
+type SnapshotOptions struct {
+	// Out is the path of the SQLite file to create. It must not exist.
+	Out string
+	// ShiftDays moves every shifted date by this many days; zero picks a
+	// random offset of up to a year into the past.
+	ShiftDays int
+	// Tenants, when set, has the databases of isolated tenants copied too,
+	// each into a file of its own under Out + ".tenants".
+	Tenants *TenantRouter
+}
+
+// SnapshotTable reports how many rows of a table were copied.
+type SnapshotTable struct {
+	// Tenant is set for tables of an isolated tenant's database.
+	Tenant  string
+	Name    string
+	Rows    int
+	Skipped bool
+}
+
+// Snapshot copies src into a new SQLite database at opts.Out, masking
+// every column. The target is migrated with the embedded migrations, so
+// src must be fully migrated too. A failed snapshot leaves no file behind.
+//
+// Isolated tenants keep their own database in the snapshot, as their
+// rows cannot be merged into the main one: tables such as blobs and
+// realtime_messages are keyed per database. The snapshot's
+// tenant_databases rows point at the copies.
+func Snapshot(ctx context.Context, src *sql.DB, opts SnapshotOptions) ([]SnapshotTable, error) {
+	for _, path := range []string{opts.Out, tenantSnapshotDir(opts.Out)} {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s already exists", path)
+		}
+	}
+	report, err := snapshot(ctx, src, opts)
+	if err != nil {
+		os.Remove(opts.Out)
+		os.RemoveAll(tenantSnapshotDir(opts.Out))
+		return nil, err
+	}
+	return report, nil
+}
+
+func tenantSnapshotDir(out string) string {
+	return out + ".tenants"
+}
+
+func snapshot(ctx context.Context, src *sql.DB, opts SnapshotOptions) ([]SnapshotTable, error) {
+	m, err := newMasker(opts.ShiftDays)
+	if err != nil {
+		return nil, err
+	}
+	report, err := snapshotDatabase(ctx, src, opts.Out, m)
+	if err != nil || opts.Tenants == nil {
+		return report, err
+	}
+
+	tenants, err := opts.Tenants.Tenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return report, nil
+	}
+	if err := os.MkdirAll(tenantSnapshotDir(opts.Out), 0o750); err != nil {
+		return nil, err
+	}
+	dst, err := Open("sqlite", "file:"+opts.Out+"?_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+	for _, t := range tenants {
+		out, err := filepath.Abs(filepath.Join(tenantSnapshotDir(opts.Out), t.TenantID+".db"))
+		if err != nil {
+			return nil, err
+		}
+		tables, err := snapshotTenant(ctx, opts.Tenants, t.Location, out, m)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.TenantID, err)
+		}
+		for _, table := range tables {
+			table.Tenant = t.TenantID
+			report = append(report, table)
+		}
+		if _, err := dst.ExecContext(ctx, `UPDATE tenant_databases SET location = $1 WHERE tenant_id = $2`,
+			out, t.TenantID); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func snapshotTenant(ctx context.Context, tenants *TenantRouter, location, out string, m *masker) ([]SnapshotTable, error) {
+	src, err := tenants.open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return snapshotDatabase(ctx, src, out, m)
+}
+
+// snapshotDatabase copies src into a new SQLite database at out.
+func snapshotDatabase(ctx context.Context, src *sql.DB, out string, m *masker) ([]SnapshotTable, error) {
+	// Foreign keys are checked by the source already; turning them off
+	// lets tables be copied in any order.
+	dst, err := Open("sqlite", "file:"+out+"?_pragma=foreign_keys(0)&_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+	if err := Migrate(ctx, dst); err != nil {
+		return nil, err
+	}
+	schema, err := sqliteColumns(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	if problems := maskingProblems(schema); len(problems) > 0 {
+		return nil, fmt.Errorf("masking rules are incomplete:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	tables := make([]string, 0, len(schema))
+	for table := range schema {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	report := make([]SnapshotTable, 0, len(tables))
+	for _, table := range tables {
+		masking := maskingRules[table]
+		if masking.skip {
+			report = append(report, SnapshotTable{Name: table, Skipped: true})
+			continue
+		}
+		n, err := copyMasked(ctx, src, dst, m, table, schema[table], masking)
+		if err != nil {
+			return nil, fmt.Errorf("copy %s: %w", table, err)
+		}
+		report = append(report, SnapshotTable{Name: table, Rows: n})
+	}
+	return report, nil
+}
+
+func copyMasked(ctx context.Context, src, dst *sql.DB, m *masker, table string, columns []string, masking tableMasking) (int, error) {
+	rows, err := src.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(columns, ", "), table))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+		table, strings.Join(columns, ", "), placeholders(1, len(columns))))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	n := 0
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return 0, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		masked := make([]interface{}, len(columns))
+		for i, col := range columns {
+			masked[i] = masking.rules[col].fn(m, row[col], row)
+		}
+		if _, err := insert.ExecContext(ctx, masked...); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// CheckMasking migrates an in-memory database and reports every column
+// without a masking rule and every rule without a column.
+func CheckMasking(ctx context.Context) ([]string, error) {
+	db, err := sql.Open("sqlite", "file:masking-check?mode=memory")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// Each connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	if err := Migrate(ctx, db); err != nil {
+		return nil, err
+	}
+	schema, err := sqliteColumns(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return maskingProblems(schema), nil
+}
+
+func maskingProblems(schema map[string][]string) []string {
+	var problems []string
+	for table, columns := range schema {
+		masking, ok := maskingRules[table]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table %s has no masking rules", table))
+			continue
+		}
+		if masking.skip {
+			continue
+		}
+		present := make(map[string]bool, len(columns))
+		for _, col := range columns {
+			present[col] = true
+			if _, ok := masking.rules[col]; !ok {
+				problems = append(problems, fmt.Sprintf("column %s.%s has no masking rule", table, col))
+			}
+		}
+		for col := range masking.rules {
+			if !present[col] {
+				problems = append(problems, fmt.Sprintf("masking rule for %s.%s matches no column", table, col))
+			}
+		}
+	}
+	for table := range maskingRules {
+		if _, ok := schema[table]; !ok {
+			problems = append(problems, fmt.Sprintf("masking rules for table %s, which does not exist", table))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
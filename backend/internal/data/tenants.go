@@ -0,0 +1,547 @@
+package data
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// DB is what repositories need from a database. *sql.DB implements it,
+// and so does TenantRouter, which sends each query to the database of the
+// tenant it is made for.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+const (
+	// tenantRefresh is how often the list of tenants with a database of
+	// their own is reread, so tenants provisioned by another process are
+	// picked up.
+	tenantRefresh = 30 * time.Second
+	// poolCloseDelay lets queries that picked a pool just before it was
+	// evicted finish before it is closed.
+	poolCloseDelay = time.Minute
+	tenantMaxConns = 10
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,47}$`)
+
+func init() {
+	maskTable("tenant_databases",
+		keep("tenant_id"), keep("location"), keep("version"), shiftDate("created_at"), shiftDate("migrated_at"),
+	)
+}
+
+// TenantDatabase is a tenant with a database of its own.
+type TenantDatabase struct {
+	TenantID string `json:"tenant_id"`
+	// Location is the SQLite file or the Postgres schema.
+	Location string `json:"location"`
+	// Version is the last migration applied when the database was last
+	// migrated.
+	Version    string     `json:"version"`
+	CreatedAt  time.Time  `json:"created_at"`
+	MigratedAt *time.Time `json:"migrated_at,omitempty"`
+}
+
+// TenantOptions configures a TenantRouter.
+type TenantOptions struct {
+	// Driver and DSN are those of the main database. SQLite tenants get a
+	// file under Dir, opened with the DSN's parameters; Postgres tenants
+	// get a schema in the main database.
+	Driver string
+	DSN    string
+	// Dir holds a directory per tenant, for its SQLite file and its files.
+	Dir string
+	// MaxPools bounds the tenant databases open at once. Opening another
+	// closes the least recently used.
+	MaxPools int
+}
+
+type tenantPool struct {
+	tenantID string
+	db       *sql.DB
+	// ready is closed once db or err is set
+	ready chan struct{}
+	err   error
+}
+
+// TenantRouter isolates tenants that need their data kept apart in a
+// database of their own: a SQLite file or a Postgres schema. Queries made
+// with a context naming such a tenant (see domain.WithTenant) go to its
+// database; all others go to the main database, where tenants are kept
+// apart by their tenant_id columns.
+//
+// A tenant's database is opened and migrated on first use, and stays
+// open until MaxPools other tenants were used more recently.
+type TenantRouter struct {
+	shared *sql.DB
+	opts   TenantOptions
+
+	mu       sync.Mutex
+	isolated map[string]string // tenant ID → location
+	loadedAt time.Time
+	pools    map[string]*list.Element
+	lru      *list.List // of *tenantPool, most recently used first
+}
+
+// NewTenantRouter reads the tenants with a database of their own from the
+// main database, which must be migrated.
+func NewTenantRouter(ctx context.Context, shared *sql.DB, opts TenantOptions) (*TenantRouter, error) {
+	if opts.MaxPools < 1 {
+		opts.MaxPools = 1
+	}
+	r := &TenantRouter{
+		shared: shared,
+		opts:   opts,
+		pools:  make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+	if err := r.load(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *TenantRouter) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db, err := r.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
+
+func (r *TenantRouter) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db, err := r.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+func (r *TenantRouter) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db, err := r.For(ctx)
+	if err != nil {
+		return failedRow(ctx, err)
+	}
+	return db.QueryRowContext(ctx, query, args...)
+}
+
+func (r *TenantRouter) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	db, err := r.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.BeginTx(ctx, opts)
+}
+
+// For returns the database for the tenant of ctx.
+func (r *TenantRouter) For(ctx context.Context) (*sql.DB, error) {
+	tenantID := domain.TenantFromContext(ctx)
+	if tenantID == "" {
+		return r.shared, nil
+	}
+	location, ok := r.location(ctx, tenantID)
+	if !ok {
+		return r.shared, nil
+	}
+	return r.pool(ctx, tenantID, location)
+}
+
+// Dir returns the directory for the files of the tenant of ctx, or ""
+// when the tenant shares the main database.
+func (r *TenantRouter) Dir(ctx context.Context) string {
+	tenantID := domain.TenantFromContext(ctx)
+	if tenantID == "" {
+		return ""
+	}
+	if _, ok := r.location(ctx, tenantID); !ok {
+		return ""
+	}
+	return filepath.Join(r.opts.Dir, tenantID)
+}
+
+// IsolatedTenants returns the IDs of the tenants with a database of their
+// own.
+func (r *TenantRouter) IsolatedTenants(ctx context.Context) ([]string, error) {
+	r.refresh(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.isolated))
+	for id := range r.isolated {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Tenants returns the tenants with a database of their own.
+func (r *TenantRouter) Tenants(ctx context.Context) ([]TenantDatabase, error) {
+	rows, err := r.shared.QueryContext(ctx, `
+		SELECT tenant_id, location, version, created_at, migrated_at FROM tenant_databases ORDER BY tenant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []TenantDatabase{}
+	for rows.Next() {
+		var t TenantDatabase
+		var migratedAt sql.NullTime
+		if err := rows.Scan(&t.TenantID, &t.Location, &t.Version, &t.CreatedAt, &migratedAt); err != nil {
+			return nil, err
+		}
+		t.MigratedAt = timePtr(migratedAt)
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// Provision gives a tenant a database of its own and migrates it. The
+// tenant must not have data in the main database yet: it would stay
+// there, out of the tenant's reach.
+func (r *TenantRouter) Provision(ctx context.Context, tenantID string) (*TenantDatabase, error) {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return nil, fmt.Errorf("tenant ID %q must be 1-48 lowercase letters, digits, _ or -, starting with a letter or digit", tenantID)
+	}
+	var exists bool
+	if err := r.shared.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tenant_databases WHERE tenant_id = $1)`,
+		tenantID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("tenant %s already has a database", tenantID)
+	}
+	var users int
+	if err := r.shared.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE tenant_id = $1`, tenantID).Scan(&users); err != nil {
+		return nil, err
+	}
+	if users > 0 {
+		return nil, fmt.Errorf("tenant %s already has data in the main database", tenantID)
+	}
+
+	dir := filepath.Join(r.opts.Dir, tenantID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	t := &TenantDatabase{TenantID: tenantID, CreatedAt: time.Now().UTC()}
+	tx, err := r.shared.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	switch r.opts.Driver {
+	case "postgres":
+		// Tenants a-b and a_b map to the same schema. It is created
+		// without IF NOT EXISTS so the second one is refused rather than
+		// sharing the first one's data.
+		t.Location = "tenant_" + strings.ReplaceAll(tenantID, "-", "_")
+		if _, err := tx.ExecContext(ctx, `CREATE SCHEMA `+pq.QuoteIdentifier(t.Location)); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "42P06" {
+				return nil, fmt.Errorf("schema %s for tenant %s is already in use", t.Location, tenantID)
+			}
+			return nil, fmt.Errorf("create schema %s: %w", t.Location, err)
+		}
+	default:
+		t.Location = filepath.Join(dir, "data.db")
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO tenant_databases (tenant_id, location, version, created_at) VALUES ($1, $2, '', $3)`,
+		t.TenantID, t.Location, t.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	version, err := r.Migrate(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	t.Version, t.MigratedAt = version, &now
+	return t, r.load(ctx)
+}
+
+// Migrate applies the pending migrations to a tenant's database and
+// returns the last one applied.
+func (r *TenantRouter) Migrate(ctx context.Context, tenantID string) (string, error) {
+	var location string
+	err := r.shared.QueryRowContext(ctx, `SELECT location FROM tenant_databases WHERE tenant_id = $1`, tenantID).Scan(&location)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("tenant %s has no database of its own", tenantID)
+	}
+	if err != nil {
+		return "", err
+	}
+	db, err := r.open(location)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	return r.migrate(ctx, tenantID, db)
+}
+
+// MigrateAll migrates the databases of all tenants, concurrency at a
+// time, and calls done as each finishes. It returns how many failed.
+func (r *TenantRouter) MigrateAll(ctx context.Context, concurrency int, done func(tenantID, version string, err error)) (int, error) {
+	tenants, err := r.Tenants(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+	)
+	work := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tenantID := range work {
+				version, err := r.Migrate(ctx, tenantID)
+				mu.Lock()
+				if err != nil {
+					failed++
+				}
+				done(tenantID, version, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range tenants {
+		select {
+		case work <- t.TenantID:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+	return failed, ctx.Err()
+}
+
+// Close closes the open tenant databases. The main database is left to
+// its owner.
+func (r *TenantRouter) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for el := r.lru.Front(); el != nil; el = el.Next() {
+		p := el.Value.(*tenantPool)
+		go func() {
+			<-p.ready
+			if p.db != nil {
+				p.db.Close()
+			}
+		}()
+	}
+	r.pools = make(map[string]*list.Element)
+	r.lru.Init()
+}
+
+// location returns where a tenant's database is, rereading the list of
+// tenants once it is older than tenantRefresh.
+func (r *TenantRouter) location(ctx context.Context, tenantID string) (string, bool) {
+	r.refresh(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	location, ok := r.isolated[tenantID]
+	return location, ok
+}
+
+func (r *TenantRouter) refresh(ctx context.Context) {
+	r.mu.Lock()
+	stale := time.Since(r.loadedAt) > tenantRefresh
+	if stale {
+		// Other callers use the current list meanwhile
+		r.loadedAt = time.Now()
+	}
+	r.mu.Unlock()
+	if !stale {
+		return
+	}
+	if err := r.load(ctx); err != nil {
+		log.Printf("tenants: reading tenant databases: %v", err)
+	}
+}
+
+func (r *TenantRouter) load(ctx context.Context) error {
+	rows, err := r.shared.QueryContext(ctx, `SELECT tenant_id, location FROM tenant_databases`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	isolated := make(map[string]string)
+	for rows.Next() {
+		var tenantID, location string
+		if err := rows.Scan(&tenantID, &location); err != nil {
+			return err
+		}
+		isolated[tenantID] = location
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.isolated = isolated
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// pool returns the open database of a tenant, opening and migrating it
+// when needed. Concurrent callers wait for a single open.
+func (r *TenantRouter) pool(ctx context.Context, tenantID, location string) (*sql.DB, error) {
+	r.mu.Lock()
+	if el, ok := r.pools[tenantID]; ok {
+		r.lru.MoveToFront(el)
+		p := el.Value.(*tenantPool)
+		r.mu.Unlock()
+		select {
+		case <-p.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return p.db, p.err
+	}
+	p := &tenantPool{tenantID: tenantID, ready: make(chan struct{})}
+	el := r.lru.PushFront(p)
+	r.pools[tenantID] = el
+	for r.lru.Len() > r.opts.MaxPools {
+		r.evict(r.lru.Back())
+	}
+	r.mu.Unlock()
+
+	// The pool outlives the request opening it
+	openCtx := context.WithoutCancel(ctx)
+	db, err := r.open(location)
+	if err == nil {
+		if _, err = r.migrate(openCtx, tenantID, db); err != nil {
+			db.Close()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("open database of tenant %s: %w", tenantID, err)
+		// Forget the failure so the next caller tries again
+		r.mu.Lock()
+		if r.pools[tenantID] == el {
+			r.lru.Remove(el)
+			delete(r.pools, tenantID)
+		}
+		r.mu.Unlock()
+	} else {
+		p.db = db
+	}
+	p.err = err
+	close(p.ready)
+	return p.db, p.err
+}
+
+// evict forgets a pool and closes it once it is ready and callers that
+// just picked it had time to finish. r.mu must be held.
+func (r *TenantRouter) evict(el *list.Element) {
+	p := el.Value.(*tenantPool)
+	r.lru.Remove(el)
+	if r.pools[p.tenantID] == el {
+		delete(r.pools, p.tenantID)
+	}
+	go func() {
+		<-p.ready
+		if p.db != nil {
+			time.AfterFunc(poolCloseDelay, func() { p.db.Close() })
+		}
+	}()
+}
+
+func (r *TenantRouter) open(location string) (*sql.DB, error) {
+	var dsn string
+	switch r.opts.Driver {
+	case "postgres":
+		dsn = withSearchPath(r.opts.DSN, location)
+	default:
+		dsn = "file:" + location
+		if i := strings.Index(r.opts.DSN, "?"); i >= 0 {
+			dsn += r.opts.DSN[i:]
+		}
+	}
+	db, err := Open(r.opts.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxIdleConns(2)
+	db.SetMaxOpenConns(tenantMaxConns)
+	return db, nil
+}
+
+// migrate applies the pending migrations to a tenant's database and
+// records the last one in tenant_databases.
+func (r *TenantRouter) migrate(ctx context.Context, tenantID string, db *sql.DB) (string, error) {
+	if err := Migrate(ctx, db); err != nil {
+		return "", err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return "", err
+	}
+	version := ""
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].Version
+	}
+	_, err = r.shared.ExecContext(ctx, `UPDATE tenant_databases SET version = $1, migrated_at = $2 WHERE tenant_id = $3`,
+		version, time.Now().UTC(), tenantID)
+	return version, err
+}
+
+// withSearchPath makes a Postgres DSN, in URL or key=value form, use the
+// given schema.
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// failedRow returns a row whose Scan reports err, since QueryRowContext
+// has no other way to fail.
+func failedRow(ctx context.Context, err error) *sql.Row {
+	db := sql.OpenDB(errConnector{err})
+	defer db.Close()
+	return db.QueryRowContext(ctx, "")
+}
+
+type errConnector struct{ err error }
+
+func (c errConnector) Connect(context.Context) (driver.Conn, error) { return nil, c.err }
+func (c errConnector) Driver() driver.Driver                        { return errDriver(c) }
+
+type errDriver struct{ err error }
+
+func (d errDriver) Open(string) (driver.Conn, error) { return nil, d.err }
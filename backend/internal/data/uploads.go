@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const uploadColumns = `id, tenant_id, owner_id, length, received, metadata, file_id, expires_at, created_at, updated_at`
+
+// UploadRepo stores resumable uploads.
+type UploadRepo struct {
+	db DB
+}
+
+// NewUploadRepo creates an upload repository.
+func NewUploadRepo(db DB) *UploadRepo {
+	return &UploadRepo{db: db}
+}
+
+func init() {
+	maskTable("uploads",
+		keep("id"), keep("tenant_id"), userRef("owner_id"), keep("length"), keep("received"), blank("metadata"),
+		keep("file_id"), shiftDate("expires_at"), shiftDate("created_at"), shiftDate("updated_at"),
+	)
+}
+
+func (r *UploadRepo) Create(ctx context.Context, u *domain.Upload) error {
+	metadata, err := encodeJSON(u.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO uploads (`+uploadColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		u.ID, u.TenantID, u.OwnerID, u.Length, u.Offset, metadata, u.FileID, u.ExpiresAt, u.CreatedAt, u.UpdatedAt)
+	return err
+}
+
+func (r *UploadRepo) Get(ctx context.Context, tenantID, id string) (*domain.Upload, error) {
+	uploads, err := r.query(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(uploads) == 0 {
+		return nil, domain.NotFound("Upload")
+	}
+	return &uploads[0], nil
+}
+
+func (r *UploadRepo) CountActive(ctx context.Context, tenantID, ownerID string) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM uploads WHERE tenant_id = $1 AND owner_id = $2 AND file_id IS NULL
+		AND expires_at > $3`, tenantID, ownerID, time.Now().UTC()).Scan(&n)
+	return n, err
+}
+
+func (r *UploadRepo) Advance(ctx context.Context, id string, from, to int64, expiresAt, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE uploads SET received = $1, expires_at = $2, updated_at = $3
+		WHERE id = $4 AND received = $5`, to, expiresAt, at, id, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = domain.ErrUploadOffsetMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *UploadRepo) Complete(ctx context.Context, id, fileID string, expiresAt, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE uploads SET file_id = $1, expires_at = $2, updated_at = $3 WHERE id = $4`,
+		fileID, expiresAt, at, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Upload")
+}
+
+func (r *UploadRepo) Delete(ctx context.Context, tenantID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM uploads WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, "Upload")
+}
+
+func (r *UploadRepo) Expired(ctx context.Context, before time.Time, limit int) ([]domain.Upload, error) {
+	return r.query(ctx, `SELECT `+uploadColumns+` FROM uploads WHERE expires_at < $1 ORDER BY expires_at LIMIT $2`, before, limit)
+}
+
+func (r *UploadRepo) Exists(ctx context.Context, ids []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return exists, nil
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT id FROM uploads WHERE id IN (`+placeholders(1, len(ids))+`)`, stringArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		exists[id] = true
+	}
+	return exists, rows.Err()
+}
+
+func (r *UploadRepo) query(ctx context.Context, query string, args ...interface{}) ([]domain.Upload, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []domain.Upload{}
+	for rows.Next() {
+		var u domain.Upload
+		var metadata string
+		var fileID sql.NullString
+		if err := rows.Scan(&u.ID, &u.TenantID, &u.OwnerID, &u.Length, &u.Offset, &metadata, &fileID,
+			&u.ExpiresAt, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if fileID.Valid {
+			u.FileID = &fileID.String
+		}
+		u.Metadata = map[string]string{}
+		if err := decodeJSON(metadata, &u.Metadata); err != nil {
+			return nil, fmt.Errorf("upload %s: %w", u.ID, err)
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
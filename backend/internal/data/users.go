@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// UserRepo stores users in the users table.
+type UserRepo struct {
+	db DB
+}
+
+// NewUserRepo creates a user repository.
+func NewUserRepo(db DB) *UserRepo {
+	return &UserRepo{db: db}
+}
+
+func init() {
+	maskTable("users",
+		userRef("id"), keep("tenant_id"),
+		fakeUsername("username"), fakeEmail("email"), fakeName("display_name"),
+		shiftDate("created_at"), shiftDate("updated_at"),
+	)
+}
+
+func (r *UserRepo) Upsert(ctx context.Context, u *domain.User) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO users (id, tenant_id, username, email, display_name, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (tenant_id, id) DO UPDATE SET
+			username = excluded.username,
+			email = excluded.email,
+			updated_at = excluded.updated_at`,
+		u.ID, u.TenantID, u.Username, u.Email, u.DisplayName, u.CreatedAt, u.UpdatedAt)
+	return err
+}
+
+func (r *UserRepo) GetByID(ctx context.Context, tenantID, id string) (*domain.User, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, tenant_id, username, email, display_name, created_at, updated_at
+		FROM users WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	u, err := scanUser(row)
+	if err != nil {
+		return nil, notFound(err, "User")
+	}
+	return u, nil
+}
+
+func (r *UserRepo) FindByUsernames(ctx context.Context, tenantID string, usernames []string) ([]domain.User, error) {
+	args := append([]interface{}{tenantID}, stringArgs(usernames)...)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, tenant_id, username, email, display_name, created_at, updated_at
+		FROM users WHERE tenant_id = $1 AND username IN (`+placeholders(2, len(usernames))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []domain.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(s scanner) (*domain.User, error) {
+	var u domain.User
+	if err := s.Scan(&u.ID, &u.TenantID, &u.Username, &u.Email, &u.DisplayName, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Activity is an entry of the activity stream, recorded from a domain
+// event that concerns a resource.
+type Activity struct {
+	ID           string                 `json:"id"`
+	TenantID     string                 `json:"tenant_id"`
+	ActorID      string                 `json:"actor_id,omitempty"`
+	Verb         string                 `json:"verb"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   string                 `json:"resource_id"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+// ActivityRepository persists activities and the resources users follow.
+type ActivityRepository interface {
+	Create(ctx context.Context, a *Activity) error
+	ListForResource(ctx context.Context, tenantID, resourceType, resourceID string, params ListParams) ([]Activity, int, error)
+	ListFeed(ctx context.Context, tenantID, userID string, params ListParams) ([]Activity, int, error)
+	Follow(ctx context.Context, tenantID, userID, resourceType, resourceID string, at time.Time) error
+	Unfollow(ctx context.Context, tenantID, userID, resourceType, resourceID string) error
+}
+
+// ActivityService aggregates domain events into per-resource timelines and
+// per-user feeds. A user's feed contains their own activity and the
+// activity on every resource they follow; users follow a resource
+// automatically when they act on it or are mentioned in it.
+type ActivityService struct {
+	repo ActivityRepository
+}
+
+// NewActivityService creates an activity service.
+func NewActivityService(repo ActivityRepository) *ActivityService {
+	return &ActivityService{repo: repo}
+}
+
+// HandleEvent records an event that targets a resource. It is meant to be
+// subscribed to the event bus for all event types.
+func (s *ActivityService) HandleEvent(ctx context.Context, e Event) error {
+	if e.ResourceType == "" || e.ResourceID == "" {
+		return nil
+	}
+	err := s.repo.Create(ctx, &Activity{
+		ID:           NewID(),
+		TenantID:     e.TenantID,
+		ActorID:      e.ActorID,
+		Verb:         e.Type,
+		ResourceType: e.ResourceType,
+		ResourceID:   e.ResourceID,
+		Data:         e.Data,
+		CreatedAt:    e.OccurredAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	followers := []string{e.ActorID}
+	if mentioned, ok := e.Data["mentioned_user_ids"].([]string); ok {
+		followers = append(followers, mentioned...)
+	}
+	for _, userID := range followers {
+		if userID == "" {
+			continue
+		}
+		if err := s.repo.Follow(ctx, e.TenantID, userID, e.ResourceType, e.ResourceID, e.OccurredAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForResource returns a page of a resource's timeline, newest first.
+func (s *ActivityService) ForResource(ctx context.Context, actor Identity, resourceType, resourceID string, params ListParams) ([]Activity, PaginationMeta, error) {
+	if err := ValidateResourceRef(resourceType, resourceID); err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.ListForResource(ctx, actor.TenantID, resourceType, resourceID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Feed returns a page of the caller's feed, newest first.
+func (s *ActivityService) Feed(ctx context.Context, actor Identity, params ListParams) ([]Activity, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.ListFeed(ctx, actor.TenantID, actor.UserID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Follow adds a resource to the caller's feed.
+func (s *ActivityService) Follow(ctx context.Context, actor Identity, resourceType, resourceID string) error {
+	if err := ValidateResourceRef(resourceType, resourceID); err != nil {
+		return err
+	}
+	return s.repo.Follow(ctx, actor.TenantID, actor.UserID, resourceType, resourceID, time.Now().UTC())
+}
+
+// Unfollow removes a resource from the caller's feed.
+func (s *ActivityService) Unfollow(ctx context.Context, actor Identity, resourceType, resourceID string) error {
+	if err := ValidateResourceRef(resourceType, resourceID); err != nil {
+		return err
+	}
+	return s.repo.Unfollow(ctx, actor.TenantID, actor.UserID, resourceType, resourceID)
+}
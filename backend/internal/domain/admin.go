@@ -0,0 +1,392 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// AdminAction is an operation of the generic admin API.
+type AdminAction string
+
+const (
+	AdminRead   AdminAction = "read"
+	AdminCreate AdminAction = "create"
+	AdminUpdate AdminAction = "update"
+	AdminDelete AdminAction = "delete"
+)
+
+// AdminField describes a field of an admin resource: how it lists (from
+// ListField) and how writes to it are validated.
+type AdminField struct {
+	ListField
+	Label    string `json:"label"`
+	Required bool   `json:"required"`
+	// ReadOnly fields are never written by clients; Immutable ones only
+	// on create.
+	ReadOnly  bool     `json:"read_only"`
+	Immutable bool     `json:"immutable"`
+	Nullable  bool     `json:"nullable"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Options   []string `json:"options,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	// Relation names the admin resource the field's value refers to.
+	Relation string `json:"relation,omitempty"`
+}
+
+// AdminResourceSchema is the metadata an admin UI is generated from.
+type AdminResourceSchema struct {
+	Name    string        `json:"name"`
+	Label   string        `json:"label"`
+	Fields  []AdminField  `json:"fields"`
+	Actions []AdminAction `json:"actions"`
+
+	// Roles besides admin that may read or write the resource.
+	ReadRoles  []string `json:"-"`
+	WriteRoles []string `json:"-"`
+}
+
+// Field returns the named field.
+func (s AdminResourceSchema) Field(name string) (AdminField, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return AdminField{}, false
+}
+
+// AdminResource is a tenant-scoped collection the admin API can manage
+// generically. Values passed to Create and Update are already validated
+// and converted to the field types. Delete is given the acting user, so
+// resources can delete through their domain service.
+type AdminResource interface {
+	Schema() AdminResourceSchema
+	List(ctx context.Context, tenantID string, q ListQuery, params ListParams) ([]map[string]interface{}, int, error)
+	Get(ctx context.Context, tenantID, id string) (map[string]interface{}, error)
+	Create(ctx context.Context, tenantID string, values map[string]interface{}) (map[string]interface{}, error)
+	Update(ctx context.Context, tenantID, id string, values map[string]interface{}) (map[string]interface{}, error)
+	Delete(ctx context.Context, actor Identity, id string) error
+}
+
+// AdminService exposes registered admin resources with permission checks,
+// validation and auditing.
+type AdminService struct {
+	audit   *AuditService
+	listing *ListingService
+
+	mu        sync.RWMutex
+	resources map[string]AdminResource
+}
+
+// NewAdminService creates an admin service for the given resources.
+func NewAdminService(audit *AuditService, resources ...AdminResource) *AdminService {
+	s := &AdminService{audit: audit, listing: NewListingService(), resources: make(map[string]AdminResource)}
+	for _, r := range resources {
+		s.Register(r)
+	}
+	return s
+}
+
+// Register adds a resource. It panics on invalid metadata, which is a
+// programming error.
+func (s *AdminService) Register(r AdminResource) {
+	schema := r.Schema()
+	for _, f := range schema.Fields {
+		if f.Pattern != "" {
+			regexp.MustCompile(f.Pattern)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.resources[schema.Name]; dup {
+		panic(fmt.Sprintf("admin resource %q registered twice", schema.Name))
+	}
+	s.resources[schema.Name] = r
+	s.listing.Register(adminListSource{r})
+}
+
+// Schemas describes the resources the caller may read. Actions lists only
+// what the caller is allowed to do.
+func (s *AdminService) Schemas(actor Identity) []AdminResourceSchema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schemas := []AdminResourceSchema{}
+	for _, r := range s.resources {
+		if schema, ok := permittedSchema(actor, r.Schema()); ok {
+			schemas = append(schemas, schema)
+		}
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	return schemas
+}
+
+// Schema describes one resource the caller may read.
+func (s *AdminService) Schema(actor Identity, name string) (AdminResourceSchema, error) {
+	r, err := s.authorize(actor, name, AdminRead)
+	if err != nil {
+		return AdminResourceSchema{}, err
+	}
+	schema, _ := permittedSchema(actor, r.Schema())
+	return schema, nil
+}
+
+// List returns a page of records filtered and sorted with the listing
+// toolkit's query syntax.
+func (s *AdminService) List(ctx context.Context, actor Identity, name string, q ListQuery, params ListParams) ([]map[string]interface{}, PaginationMeta, error) {
+	if _, err := s.authorize(actor, name, AdminRead); err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return s.listing.Execute(ctx, actor, name, q, params)
+}
+
+// Get returns one record.
+func (s *AdminService) Get(ctx context.Context, actor Identity, name, id string) (map[string]interface{}, error) {
+	r, err := s.authorize(actor, name, AdminRead)
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, actor.TenantID, id)
+}
+
+// Create validates and stores a new record.
+func (s *AdminService) Create(ctx context.Context, actor Identity, name string, input map[string]interface{}) (map[string]interface{}, error) {
+	r, err := s.authorize(actor, name, AdminCreate)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.validate(ctx, actor, r.Schema(), input, true)
+	if err != nil {
+		return nil, err
+	}
+	record, err := r.Create(ctx, actor.TenantID, values)
+	if err != nil {
+		return nil, err
+	}
+	s.record(ctx, actor, "admin.create", name, record, values)
+	return record, nil
+}
+
+// Update validates and applies a partial update. The audit entry keeps the
+// previous and new value of every changed field.
+func (s *AdminService) Update(ctx context.Context, actor Identity, name, id string, input map[string]interface{}) (map[string]interface{}, error) {
+	r, err := s.authorize(actor, name, AdminUpdate)
+	if err != nil {
+		return nil, err
+	}
+	before, err := r.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.validate(ctx, actor, r.Schema(), input, false)
+	if err != nil {
+		return nil, err
+	}
+	after, err := r.Update(ctx, actor.TenantID, id, values)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]interface{})
+	for field := range values {
+		if !reflect.DeepEqual(before[field], after[field]) {
+			changes[field] = map[string]interface{}{"from": before[field], "to": after[field]}
+		}
+	}
+	s.record(ctx, actor, "admin.update", name, after, changes)
+	return after, nil
+}
+
+// Delete removes a record; the audit entry keeps its last state.
+func (s *AdminService) Delete(ctx context.Context, actor Identity, name, id string) error {
+	r, err := s.authorize(actor, name, AdminDelete)
+	if err != nil {
+		return err
+	}
+	before, err := r.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := r.Delete(ctx, actor, id); err != nil {
+		return err
+	}
+	s.record(ctx, actor, "admin.delete", name, before, before)
+	return nil
+}
+
+// record writes the audit entry of a change that already happened, so a
+// failure is logged rather than reported to the caller.
+func (s *AdminService) record(ctx context.Context, actor Identity, action, name string, record, changes map[string]interface{}) {
+	id, _ := record["id"].(string)
+	if err := s.audit.Record(ctx, actor, action, name, id, changes); err != nil {
+		log.Printf("audit %s %s/%s: %v", action, name, id, err)
+	}
+}
+
+func (s *AdminService) authorize(actor Identity, name string, action AdminAction) (AdminResource, error) {
+	s.mu.RLock()
+	r, ok := s.resources[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, NotFound("Admin resource " + name)
+	}
+	schema := r.Schema()
+	if !canAdmin(actor, schema, AdminRead) {
+		return nil, NotFound("Admin resource " + name)
+	}
+	if !slices.Contains(schema.Actions, action) {
+		return nil, AppError{
+			Status:  http.StatusMethodNotAllowed,
+			Code:    "ACTION_NOT_ALLOWED",
+			Message: fmt.Sprintf("%s does not support %s", name, action),
+		}
+	}
+	if !canAdmin(actor, schema, action) {
+		return nil, ErrForbidden
+	}
+	return r, nil
+}
+
+func canAdmin(actor Identity, schema AdminResourceSchema, action AdminAction) bool {
+	if actor.IsAdmin() {
+		return true
+	}
+	roles := schema.WriteRoles
+	if action == AdminRead {
+		roles = append(slices.Clone(schema.ReadRoles), schema.WriteRoles...)
+	}
+	for _, role := range roles {
+		if actor.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// permittedSchema narrows the schema's actions to those the caller may
+// perform, reporting false if the caller cannot read the resource.
+func permittedSchema(actor Identity, schema AdminResourceSchema) (AdminResourceSchema, bool) {
+	if !canAdmin(actor, schema, AdminRead) {
+		return schema, false
+	}
+	actions := []AdminAction{}
+	for _, action := range schema.Actions {
+		if canAdmin(actor, schema, action) {
+			actions = append(actions, action)
+		}
+	}
+	schema.Actions = actions
+	if schema.Label == "" {
+		schema.Label = schema.Name
+	}
+	return schema, true
+}
+
+// validate checks input against the field metadata and converts it to the
+// field types. Problems are reported per field.
+func (s *AdminService) validate(ctx context.Context, actor Identity, schema AdminResourceSchema, input map[string]interface{}, creating bool) (map[string]interface{}, error) {
+	problems := make(map[string]interface{})
+	fail := func(field, format string, args ...interface{}) {
+		msgs, _ := problems[field].([]string)
+		problems[field] = append(msgs, fmt.Sprintf(format, args...))
+	}
+
+	values := make(map[string]interface{})
+	for name, raw := range input {
+		field, ok := schema.Field(name)
+		switch {
+		case !ok:
+			fail(name, "unknown field")
+			continue
+		case field.ReadOnly, field.Immutable && !creating:
+			fail(name, "field is read-only")
+			continue
+		}
+		if raw == nil {
+			if field.Required || !field.Nullable {
+				fail(name, "must not be null")
+			} else {
+				values[name] = nil
+			}
+			continue
+		}
+		v, err := convertScalar(field.Type, raw)
+		if err != nil {
+			fail(name, "%v", err)
+			continue
+		}
+		if str, ok := v.(string); ok {
+			if field.Required && str == "" {
+				fail(name, "must not be empty")
+			}
+			if field.MaxLength > 0 && utf8.RuneCountInString(str) > field.MaxLength {
+				fail(name, "must be at most %d characters", field.MaxLength)
+			}
+			if len(field.Options) > 0 && !slices.Contains(field.Options, str) {
+				fail(name, "must be one of %v", field.Options)
+			}
+			if field.Pattern != "" && str != "" && !regexp.MustCompile(field.Pattern).MatchString(str) {
+				fail(name, "has an invalid format")
+			}
+			if field.Relation != "" && str != "" {
+				if err := s.checkRelation(ctx, actor, field.Relation, str); err != nil {
+					fail(name, "%v", err)
+				}
+			}
+		}
+		values[name] = v
+	}
+	if creating {
+		for _, field := range schema.Fields {
+			if _, ok := input[field.Name]; field.Required && !field.ReadOnly && !ok {
+				fail(field.Name, "is required")
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, ErrValidation.WithDetails(problems)
+	}
+	return values, nil
+}
+
+func (s *AdminService) checkRelation(ctx context.Context, actor Identity, name, id string) error {
+	s.mu.RLock()
+	r, ok := s.resources[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	_, err := r.Get(ctx, actor.TenantID, id)
+	var appErr AppError
+	if errors.As(err, &appErr) && appErr.Status == http.StatusNotFound {
+		return fmt.Errorf("refers to an unknown %s", name)
+	}
+	return err
+}
+
+// adminListSource lets the listing toolkit validate and run admin lists.
+type adminListSource struct {
+	resource AdminResource
+}
+
+func (a adminListSource) Name() string { return a.resource.Schema().Name }
+
+func (a adminListSource) Fields() []ListField {
+	fields := a.resource.Schema().Fields
+	out := make([]ListField, len(fields))
+	for i, f := range fields {
+		out[i] = f.ListField
+	}
+	return out
+}
+
+func (a adminListSource) Query(ctx context.Context, actor Identity, q ListQuery, params ListParams) ([]map[string]interface{}, int, error) {
+	return a.resource.List(ctx, actor.TenantID, q, params)
+}
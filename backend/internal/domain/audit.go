@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// AuditEntry records a privileged change for later review.
+type AuditEntry struct {
+	ID           string                 `json:"id"`
+	TenantID     string                 `json:"tenant_id"`
+	ActorID      string                 `json:"actor_id"`
+	Action       string                 `json:"action"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   string                 `json:"resource_id"`
+	Changes      map[string]interface{} `json:"changes,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+// AuditFilter narrows the audit log. Empty fields match everything.
+type AuditFilter struct {
+	ActorID      string `form:"actor_id"`
+	Action       string `form:"action"`
+	ResourceType string `form:"resource_type"`
+	ResourceID   string `form:"resource_id"`
+}
+
+// AuditRepository persists the audit log.
+type AuditRepository interface {
+	Create(ctx context.Context, e *AuditEntry) error
+	List(ctx context.Context, tenantID string, f AuditFilter, params ListParams) ([]AuditEntry, int, error)
+}
+
+// AuditService writes and reads the append-only audit log.
+type AuditService struct {
+	repo AuditRepository
+}
+
+// NewAuditService creates an audit service.
+func NewAuditService(repo AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record appends an entry for an action the caller performed.
+func (s *AuditService) Record(ctx context.Context, actor Identity, action, resourceType, resourceID string, changes map[string]interface{}) error {
+	return s.repo.Create(ctx, &AuditEntry{
+		ID:           NewID(),
+		TenantID:     actor.TenantID,
+		ActorID:      actor.UserID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      changes,
+		CreatedAt:    time.Now().UTC(),
+	})
+}
+
+// List returns the tenant's audit log, newest first. Only admins may read it.
+func (s *AuditService) List(ctx context.Context, actor Identity, f AuditFilter, params ListParams) ([]AuditEntry, PaginationMeta, error) {
+	if !actor.IsAdmin() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, f, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// History returns up to a page of the newest entries about one resource,
+// for features that show a resource's trail to non-admins.
+func (s *AuditService) History(ctx context.Context, tenantID, resourceType, resourceID string) ([]AuditEntry, error) {
+	items, _, err := s.repo.List(ctx, tenantID, AuditFilter{ResourceType: resourceType, ResourceID: resourceID},
+		ListParams{Page: 1, Limit: maxPageLimit})
+	return items, err
+}
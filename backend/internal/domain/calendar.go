@@ -0,0 +1,534 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"greact-bones/backend/internal/ical"
+)
+
+const (
+	// Kinds of calendar entries. Events span a time range, tasks are due
+	// at a point in time.
+	CalendarEvent = "event"
+	CalendarTask  = "task"
+
+	maxCalendarTitleLength = 200
+	maxCalendarTextLength  = 10000
+	maxCalendarExDates     = 1000
+	maxFeedNameLength      = 100
+	maxActiveFeeds         = 20
+	// maxOccurrenceWindow and maxOccurrences bound one occurrence query.
+	maxOccurrenceWindow = 366 * 24 * time.Hour
+	maxOccurrences      = 5000
+	// Feeds contain one-off entries from feedPast ago and entries starting
+	// within feedFuture.
+	feedPast   = 180 * 24 * time.Hour
+	feedFuture = 2 * 366 * 24 * time.Hour
+	// feedTouchInterval throttles last_used_at updates of polled feeds.
+	feedTouchInterval = 5 * time.Minute
+)
+
+// CalendarEntry is a date-bearing resource: an event or a task with a due
+// date. Times are stored in UTC; TimeZone is the IANA zone the entry was
+// created in, used for all-day dates and to expand recurrences on the
+// wall clock. For tasks StartsAt is the due time.
+type CalendarEntry struct {
+	ID          string      `json:"id"`
+	TenantID    string      `json:"tenant_id"`
+	OwnerID     string      `json:"owner_id"`
+	UID         string      `json:"uid"`
+	Kind        string      `json:"kind"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Location    string      `json:"location"`
+	StartsAt    time.Time   `json:"starts_at"`
+	EndsAt      *time.Time  `json:"ends_at,omitempty"`
+	AllDay      bool        `json:"all_day"`
+	TimeZone    string      `json:"time_zone"`
+	RRule       string      `json:"rrule,omitempty"`
+	ExDates     []time.Time `json:"exdates"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// CalendarEntryInput creates or updates an entry. Nil fields are left
+// unchanged on update.
+type CalendarEntryInput struct {
+	Kind        *string      `json:"kind"`
+	Title       *string      `json:"title"`
+	Description *string      `json:"description"`
+	Location    *string      `json:"location"`
+	StartsAt    *time.Time   `json:"starts_at"`
+	EndsAt      *time.Time   `json:"ends_at"`
+	AllDay      *bool        `json:"all_day"`
+	TimeZone    *string      `json:"time_zone"`
+	RRule       *string      `json:"rrule"`
+	ExDates     *[]time.Time `json:"exdates"`
+	Completed   *bool        `json:"completed"`
+}
+
+// CalendarOccurrence is one instance of an entry within a queried window.
+type CalendarOccurrence struct {
+	EntryID   string     `json:"entry_id"`
+	Kind      string     `json:"kind"`
+	Title     string     `json:"title"`
+	Location  string     `json:"location,omitempty"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	AllDay    bool       `json:"all_day"`
+	TimeZone  string     `json:"time_zone"`
+	Recurring bool       `json:"recurring"`
+	Completed bool       `json:"completed"`
+}
+
+// CalendarFeed is a secret URL serving a user's entries to calendar apps.
+// Only a hash of the token is stored, so Token and URL are set once, in
+// the response that creates the feed.
+type CalendarFeed struct {
+	ID         string     `json:"id"`
+	TenantID   string     `json:"tenant_id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	URL        string     `json:"url,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CalendarRepository persists calendar entries and feeds.
+type CalendarRepository interface {
+	Create(ctx context.Context, e *CalendarEntry) error
+	Get(ctx context.Context, tenantID, id string) (*CalendarEntry, error)
+	List(ctx context.Context, tenantID, ownerID string, params ListParams) ([]CalendarEntry, int, error)
+	Update(ctx context.Context, e *CalendarEntry) error
+	Delete(ctx context.Context, tenantID, id string) error
+	// InRange returns the owner's entries that may occur in [from, to):
+	// those starting before to that recur or end at or after from.
+	InRange(ctx context.Context, tenantID, ownerID string, from, to time.Time) ([]CalendarEntry, error)
+	// Upsert replaces the owner's entry with the same UID, or creates it,
+	// and reports whether it was created.
+	Upsert(ctx context.Context, e *CalendarEntry) (bool, error)
+
+	CreateFeed(ctx context.Context, f *CalendarFeed, tokenHash string) error
+	ListFeeds(ctx context.Context, tenantID, userID string) ([]CalendarFeed, error)
+	FeedByToken(ctx context.Context, tokenHash string) (*CalendarFeed, error)
+	RevokeFeed(ctx context.Context, tenantID, userID, id string, at time.Time) error
+	TouchFeed(ctx context.Context, id string, at time.Time) error
+}
+
+// CalendarService manages calendar entries, expands their recurrences,
+// and publishes them to calendar apps as iCalendar feeds.
+type CalendarService struct {
+	repo   CalendarRepository
+	appURL string
+}
+
+// NewCalendarService creates a calendar service. Feed URLs are built from
+// appURL.
+func NewCalendarService(repo CalendarRepository, appURL string) *CalendarService {
+	return &CalendarService{repo: repo, appURL: strings.TrimRight(appURL, "/")}
+}
+
+// List returns the caller's entries.
+func (s *CalendarService) List(ctx context.Context, actor Identity, params ListParams) ([]CalendarEntry, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, actor.UserID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Get returns one of the caller's entries. Calendars are private, so
+// other users' entries are reported as missing.
+func (s *CalendarService) Get(ctx context.Context, actor Identity, id string) (*CalendarEntry, error) {
+	e, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.OwnerID != actor.UserID {
+		return nil, NotFound("Calendar entry")
+	}
+	return e, nil
+}
+
+// Create adds an entry to the caller's calendar.
+func (s *CalendarService) Create(ctx context.Context, actor Identity, in CalendarEntryInput) (*CalendarEntry, error) {
+	if in.Title == nil || in.StartsAt == nil {
+		return nil, InvalidInput("title and starts_at are required")
+	}
+	now := time.Now().UTC()
+	e := &CalendarEntry{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		OwnerID:   actor.UserID,
+		Kind:      CalendarEvent,
+		TimeZone:  "UTC",
+		ExDates:   []time.Time{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	e.UID = e.ID
+	if err := s.apply(e, in); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Update changes one of the caller's entries.
+func (s *CalendarService) Update(ctx context.Context, actor Identity, id string, in CalendarEntryInput) (*CalendarEntry, error) {
+	e, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(e, in); err != nil {
+		return nil, err
+	}
+	e.UpdatedAt = time.Now().UTC()
+	if err := s.repo.Update(ctx, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Delete removes one of the caller's entries.
+func (s *CalendarService) Delete(ctx context.Context, actor Identity, id string) error {
+	if _, err := s.Get(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, actor.TenantID, id)
+}
+
+// Occurrences lists the instances of the caller's entries overlapping
+// [from, to), expanding recurring entries, in start order.
+func (s *CalendarService) Occurrences(ctx context.Context, actor Identity, from, to time.Time) ([]CalendarOccurrence, error) {
+	if !to.After(from) {
+		return nil, InvalidInput("to must be after from")
+	}
+	if to.Sub(from) > maxOccurrenceWindow {
+		return nil, InvalidInput("the window can span at most %d days", int(maxOccurrenceWindow.Hours()/24))
+	}
+	from, to = from.UTC(), to.UTC()
+	entries, err := s.repo.InRange(ctx, actor.TenantID, actor.UserID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	occurrences := []CalendarOccurrence{}
+	for _, e := range entries {
+		occurrences = append(occurrences, expandEntry(e, from, to)...)
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool { return occurrences[i].StartsAt.Before(occurrences[j].StartsAt) })
+	if len(occurrences) > maxOccurrences {
+		occurrences = occurrences[:maxOccurrences]
+	}
+	return occurrences, nil
+}
+
+// expandEntry returns the occurrences of an entry overlapping [from, to).
+func expandEntry(e CalendarEntry, from, to time.Time) []CalendarOccurrence {
+	loc := ical.LoadLocation(e.TimeZone)
+	length := time.Duration(0)
+	if e.EndsAt != nil {
+		length = e.EndsAt.Sub(e.StartsAt)
+	}
+	instance := func(start time.Time) CalendarOccurrence {
+		o := CalendarOccurrence{
+			EntryID:   e.ID,
+			Kind:      e.Kind,
+			Title:     e.Title,
+			Location:  e.Location,
+			StartsAt:  start.UTC(),
+			AllDay:    e.AllDay,
+			TimeZone:  e.TimeZone,
+			Recurring: e.RRule != "",
+			Completed: e.CompletedAt != nil,
+		}
+		if e.EndsAt != nil {
+			end := endOf(start, e.StartsAt.In(loc), e.EndsAt.In(loc), e.AllDay).UTC()
+			o.EndsAt = &end
+		}
+		return o
+	}
+	overlaps := func(o CalendarOccurrence) bool {
+		if !o.StartsAt.Before(to) {
+			return false
+		}
+		return !o.StartsAt.Before(from) || (o.EndsAt != nil && o.EndsAt.After(from))
+	}
+
+	if e.RRule == "" {
+		if o := instance(e.StartsAt.In(loc)); overlaps(o) {
+			return []CalendarOccurrence{o}
+		}
+		return nil
+	}
+	rule, err := ical.ParseRecurrence(e.RRule, loc)
+	if err != nil {
+		log.Printf("calendar: entry %s has an invalid recurrence rule: %v", e.ID, err)
+		return nil
+	}
+	starts, _ := rule.Expand(e.StartsAt.In(loc), from.Add(-length), to, maxOccurrences)
+	var occurrences []CalendarOccurrence
+	for _, start := range starts {
+		if excluded(e, loc, start) {
+			continue
+		}
+		if o := instance(start); overlaps(o) {
+			occurrences = append(occurrences, o)
+		}
+	}
+	return occurrences
+}
+
+// endOf returns the end of the occurrence starting at start of a series
+// whose first instance spans first to last. All-day entries keep their
+// number of days, others their duration.
+func endOf(start, first, last time.Time, allDay bool) time.Time {
+	if allDay {
+		days := int(last.Sub(first).Hours()/24 + 0.5)
+		return start.AddDate(0, 0, days)
+	}
+	return start.Add(last.Sub(first))
+}
+
+// excluded reports whether an occurrence was removed from its series with
+// EXDATE. All-day exclusions match on the date.
+func excluded(e CalendarEntry, loc *time.Location, start time.Time) bool {
+	for _, ex := range e.ExDates {
+		if e.AllDay && ical.FormatDate(ex.In(loc)) == ical.FormatDate(start) {
+			return true
+		}
+		if ex.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateFeed issues a new feed URL for the caller. The URL is only
+// returned here; a lost URL is replaced by revoking it and creating a
+// new one.
+func (s *CalendarService) CreateFeed(ctx context.Context, actor Identity, name string) (*CalendarFeed, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Calendar"
+	}
+	if utf8.RuneCountInString(name) > maxFeedNameLength {
+		return nil, InvalidInput("name must be at most %d characters", maxFeedNameLength)
+	}
+	feeds, err := s.repo.ListFeeds(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	active := 0
+	for _, f := range feeds {
+		if f.RevokedAt == nil {
+			active++
+		}
+	}
+	if active >= maxActiveFeeds {
+		return nil, InvalidInput("you can have at most %d active feeds; revoke one first", maxActiveFeeds)
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret[:])
+	f := &CalendarFeed{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		UserID:    actor.UserID,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.repo.CreateFeed(ctx, f, hashFeedToken(token)); err != nil {
+		return nil, err
+	}
+	f.Token = token
+	f.URL = s.appURL + "/api/calendar/ics/" + token + ".ics"
+	if f.TenantID != DefaultTenant {
+		// Calendar apps fetch the feed anonymously
+		f.URL += "?tenant=" + url.QueryEscape(f.TenantID)
+	}
+	return f, nil
+}
+
+// ListFeeds returns the caller's feeds, revoked ones included, without
+// their URLs.
+func (s *CalendarService) ListFeeds(ctx context.Context, actor Identity) ([]CalendarFeed, error) {
+	return s.repo.ListFeeds(ctx, actor.TenantID, actor.UserID)
+}
+
+// RevokeFeed disables one of the caller's feeds for good.
+func (s *CalendarService) RevokeFeed(ctx context.Context, actor Identity, id string) error {
+	return s.repo.RevokeFeed(ctx, actor.TenantID, actor.UserID, id, time.Now().UTC())
+}
+
+// Feed renders the calendar behind a feed token. Unknown and revoked
+// tokens are both reported as missing.
+func (s *CalendarService) Feed(ctx context.Context, token string) (*CalendarFeed, []byte, error) {
+	f, err := s.repo.FeedByToken(ctx, hashFeedToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.RevokedAt != nil {
+		return nil, nil, NotFound("Calendar feed")
+	}
+	now := time.Now().UTC()
+	if f.LastUsedAt == nil || now.Sub(*f.LastUsedAt) > feedTouchInterval {
+		if err := s.repo.TouchFeed(ctx, f.ID, now); err != nil {
+			log.Printf("calendar: recording use of feed %s failed: %v", f.ID, err)
+		}
+	}
+	entries, err := s.repo.InRange(ctx, f.TenantID, f.UserID, now.Add(-feedPast), now.Add(feedFuture))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, encodeCalendar(f.Name, entries, now), nil
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apply copies the input onto the entry and validates the result.
+func (s *CalendarService) apply(e *CalendarEntry, in CalendarEntryInput) error {
+	if in.Kind != nil {
+		e.Kind = *in.Kind
+	}
+	if in.Title != nil {
+		e.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Description != nil {
+		e.Description = *in.Description
+	}
+	if in.Location != nil {
+		e.Location = strings.TrimSpace(*in.Location)
+	}
+	if in.StartsAt != nil {
+		e.StartsAt = *in.StartsAt
+	}
+	if in.EndsAt != nil {
+		e.EndsAt = in.EndsAt
+	}
+	if in.AllDay != nil {
+		e.AllDay = *in.AllDay
+	}
+	if in.TimeZone != nil {
+		e.TimeZone = *in.TimeZone
+	}
+	if in.RRule != nil {
+		e.RRule = strings.TrimPrefix(strings.TrimSpace(*in.RRule), "RRULE:")
+	}
+	if in.ExDates != nil {
+		e.ExDates = *in.ExDates
+	}
+	if in.Completed != nil {
+		switch {
+		case !*in.Completed:
+			e.CompletedAt = nil
+		case e.CompletedAt == nil:
+			now := time.Now().UTC()
+			e.CompletedAt = &now
+		}
+	}
+	return normalizeEntry(e)
+}
+
+// normalizeEntry validates an entry and brings it into canonical form:
+// UTC times, all-day entries at midnight in their zone and the
+// recurrence rule re-encoded.
+func normalizeEntry(e *CalendarEntry) error {
+	if e.Kind != CalendarEvent && e.Kind != CalendarTask {
+		return InvalidInput("kind must be %s or %s", CalendarEvent, CalendarTask)
+	}
+	if e.Title == "" || utf8.RuneCountInString(e.Title) > maxCalendarTitleLength {
+		return InvalidInput("title must be between 1 and %d characters", maxCalendarTitleLength)
+	}
+	if utf8.RuneCountInString(e.Description) > maxCalendarTextLength || utf8.RuneCountInString(e.Location) > maxCalendarTextLength {
+		return InvalidInput("description and location must be at most %d characters", maxCalendarTextLength)
+	}
+	if e.TimeZone == "" || e.TimeZone == "Local" {
+		return InvalidInput("time_zone must be an IANA time zone such as Europe/Berlin")
+	}
+	loc, err := time.LoadLocation(e.TimeZone)
+	if err != nil {
+		return InvalidInput("unknown time_zone %q", e.TimeZone)
+	}
+	e.TimeZone = loc.String()
+	if e.StartsAt.IsZero() {
+		return InvalidInput("starts_at is required")
+	}
+
+	start := e.StartsAt.In(loc)
+	if e.AllDay {
+		start = midnight(start)
+	}
+	switch e.Kind {
+	case CalendarTask:
+		e.EndsAt = nil
+	case CalendarEvent:
+		e.CompletedAt = nil
+		var end time.Time
+		switch {
+		case e.EndsAt != nil && e.AllDay:
+			end = midnight(e.EndsAt.In(loc))
+			if !end.After(start) {
+				end = start.AddDate(0, 0, 1)
+			}
+		case e.EndsAt != nil:
+			end = e.EndsAt.In(loc)
+		case e.AllDay:
+			end = start.AddDate(0, 0, 1)
+		default:
+			end = start.Add(time.Hour)
+		}
+		if end.Before(start) {
+			return InvalidInput("ends_at must not be before starts_at")
+		}
+		end = end.UTC()
+		e.EndsAt = &end
+	}
+	e.StartsAt = start.UTC()
+
+	if e.RRule != "" {
+		rule, err := ical.ParseRecurrence(e.RRule, loc)
+		if err != nil {
+			return InvalidInput("invalid rrule: %s", strings.TrimPrefix(err.Error(), "ical: "))
+		}
+		e.RRule = rule.String()
+	}
+	if len(e.ExDates) > maxCalendarExDates {
+		return InvalidInput("an entry can have at most %d exdates", maxCalendarExDates)
+	}
+	exdates := make([]time.Time, 0, len(e.ExDates))
+	for _, ex := range e.ExDates {
+		if e.AllDay {
+			ex = midnight(ex.In(loc))
+		}
+		exdates = append(exdates, ex.UTC())
+	}
+	e.ExDates = exdates
+	return nil
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
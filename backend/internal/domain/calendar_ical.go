@@ -0,0 +1,354 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"greact-bones/backend/internal/ical"
+)
+
+const (
+	calendarProductID = "-//Greact-Bones//Calendar//EN"
+
+	// DefaultImportHorizon and MaxImportHorizon bound how far into the
+	// future imported recurring series are expanded.
+	DefaultImportHorizon = 365 * 24 * time.Hour
+	MaxImportHorizon     = 5 * 365 * 24 * time.Hour
+	// Imports expand series from importPast ago, at most
+	// maxImportedOccurrences instances each, and create at most
+	// maxImportEntries entries.
+	importPast             = 365 * 24 * time.Hour
+	maxImportedOccurrences = 1000
+	maxImportEntries       = 5000
+)
+
+// CalendarImportResult summarizes an .ics import.
+type CalendarImportResult struct {
+	Created int                  `json:"created"`
+	Updated int                  `json:"updated"`
+	Skipped []CalendarImportSkip `json:"skipped"`
+	// Truncated lists the UIDs of series that continue past the horizon
+	// or the per-series limit.
+	Truncated []string `json:"truncated"`
+}
+
+// CalendarImportSkip is a component that could not be imported.
+type CalendarImportSkip struct {
+	UID    string `json:"uid,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// encodeCalendar renders entries as a VCALENDAR with a VTIMEZONE for every
+// zone the timed entries use.
+func encodeCalendar(name string, entries []CalendarEntry, now time.Time) []byte {
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", calendarProductID)
+	cal.Add("CALSCALE", "GREGORIAN")
+	cal.Add("METHOD", "PUBLISH")
+	cal.AddText("X-WR-CALNAME", name)
+	cal.Add("REFRESH-INTERVAL", "PT1H", "VALUE", "DURATION")
+	cal.Add("X-PUBLISHED-TTL", "PT1H")
+
+	// Each zone is described from the earliest entry using it to the end
+	// of the feed window
+	zones := map[string]time.Time{}
+	for _, e := range entries {
+		if e.AllDay || e.TimeZone == "UTC" {
+			continue
+		}
+		if first, ok := zones[e.TimeZone]; !ok || e.StartsAt.Before(first) {
+			zones[e.TimeZone] = e.StartsAt
+		}
+	}
+	for _, tzid := range sortedKeys(zones) {
+		cal.Append(ical.Timezone(ical.LoadLocation(tzid), zones[tzid], now.Add(feedFuture)))
+	}
+	for _, e := range entries {
+		cal.Append(entryComponent(e))
+	}
+	return cal.Encode()
+}
+
+// entryComponent renders an event as a VEVENT and a task as a VTODO.
+// Times are written in the entry's zone so recurrences follow its wall
+// clock in the calendar app too.
+func entryComponent(e CalendarEntry) *ical.Component {
+	loc := ical.LoadLocation(e.TimeZone)
+	addTime := func(c *ical.Component, name string, t time.Time) {
+		if e.AllDay {
+			c.AddDate(name, t.In(loc))
+		} else {
+			c.AddTime(name, t.In(loc))
+		}
+	}
+
+	c := ical.NewComponent("VEVENT")
+	if e.Kind == CalendarTask {
+		c = ical.NewComponent("VTODO")
+	}
+	c.AddText("UID", e.UID)
+	c.Add("DTSTAMP", ical.FormatUTC(e.UpdatedAt))
+	c.Add("CREATED", ical.FormatUTC(e.CreatedAt))
+	c.Add("LAST-MODIFIED", ical.FormatUTC(e.UpdatedAt))
+	c.AddText("SUMMARY", e.Title)
+	if e.Description != "" {
+		c.AddText("DESCRIPTION", e.Description)
+	}
+	if e.Location != "" {
+		c.AddText("LOCATION", e.Location)
+	}
+	if e.Kind == CalendarTask {
+		addTime(c, "DUE", e.StartsAt)
+		if e.CompletedAt != nil {
+			c.Add("STATUS", "COMPLETED")
+			c.Add("COMPLETED", ical.FormatUTC(*e.CompletedAt))
+		} else {
+			c.Add("STATUS", "NEEDS-ACTION")
+		}
+	} else {
+		addTime(c, "DTSTART", e.StartsAt)
+		if e.EndsAt != nil {
+			addTime(c, "DTEND", *e.EndsAt)
+		}
+	}
+	if e.RRule != "" {
+		c.Add("RRULE", e.RRule)
+		for _, ex := range e.ExDates {
+			addTime(c, "EXDATE", ex)
+		}
+	}
+	return c
+}
+
+// Import reads an .ics file into the caller's calendar. Events and tasks
+// are matched to existing entries by UID, so importing the same file
+// again updates them. Recurring series are expanded into one entry per
+// instance up to horizon, with excluded dates left out and instances
+// overridden by a RECURRENCE-ID component taken from that component.
+func (s *CalendarService) Import(ctx context.Context, actor Identity, r io.Reader, horizon time.Duration) (*CalendarImportResult, error) {
+	if horizon <= 0 {
+		horizon = DefaultImportHorizon
+	}
+	if horizon > MaxImportHorizon {
+		return nil, InvalidInput("horizon can be at most %d days", int(MaxImportHorizon.Hours()/24))
+	}
+	cal, err := ical.Parse(r)
+	if err != nil {
+		return nil, InvalidInput("not a valid iCalendar file: %s", strings.TrimPrefix(err.Error(), "ical: "))
+	}
+	if cal.Name != "VCALENDAR" {
+		return nil, InvalidInput("not a valid iCalendar file: expected VCALENDAR, got %s", cal.Name)
+	}
+	// Floating times are read in the calendar's default zone, if it names
+	// one
+	floating := time.UTC
+	if tz := cal.Text("X-WR-TIMEZONE"); tz != "" {
+		floating = ical.LoadLocation(tz)
+	}
+
+	now := time.Now().UTC()
+	result := &CalendarImportResult{Skipped: []CalendarImportSkip{}, Truncated: []string{}}
+	var components []*ical.Component
+	components = append(components, cal.Children("VEVENT")...)
+	components = append(components, cal.Children("VTODO")...)
+
+	// Instances replaced by RECURRENCE-ID components are skipped when
+	// their series is expanded
+	overridden := map[string]bool{}
+	for _, c := range components {
+		if p := c.Get("RECURRENCE-ID"); p != nil {
+			if t, allDay, err := p.Time(floating); err == nil {
+				overridden[strings.TrimSpace(c.Text("UID"))+"/"+instanceKey(t, allDay)] = true
+			}
+		}
+	}
+
+	var entries []CalendarEntry
+	for _, c := range components {
+		expanded, truncated, err := importComponent(c, floating, overridden, now.Add(-importPast), now.Add(horizon))
+		if err != nil {
+			result.Skipped = append(result.Skipped, CalendarImportSkip{UID: c.Text("UID"), Reason: err.Error()})
+			continue
+		}
+		if truncated {
+			result.Truncated = append(result.Truncated, c.Text("UID"))
+		}
+		entries = append(entries, expanded...)
+	}
+
+	for i, e := range entries {
+		if i == maxImportEntries {
+			result.Skipped = append(result.Skipped, CalendarImportSkip{
+				Reason: fmt.Sprintf("%d more entries exceed the limit of %d per import", len(entries)-i, maxImportEntries),
+			})
+			break
+		}
+		e.ID = NewID()
+		e.TenantID = actor.TenantID
+		e.OwnerID = actor.UserID
+		e.CreatedAt, e.UpdatedAt = now, now
+		if err := normalizeEntry(&e); err != nil {
+			result.Skipped = append(result.Skipped, CalendarImportSkip{UID: e.UID, Reason: importReason(err)})
+			continue
+		}
+		created, err := s.repo.Upsert(ctx, &e)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// importComponent converts a VEVENT or VTODO into entries, one per
+// instance in [from, to) for recurring ones. Each instance gets the UID
+// "<series UID>/<instance start>", the form RECURRENCE-ID overrides are
+// stored under too.
+func importComponent(c *ical.Component, floating *time.Location, overridden map[string]bool, from, to time.Time) ([]CalendarEntry, bool, error) {
+	uid := strings.TrimSpace(c.Text("UID"))
+	if uid == "" {
+		return nil, false, errors.New("missing UID")
+	}
+	e := CalendarEntry{
+		UID:         uid,
+		Kind:        CalendarEvent,
+		Title:       truncateRunes(strings.TrimSpace(c.Text("SUMMARY")), maxCalendarTitleLength),
+		Description: truncateRunes(c.Text("DESCRIPTION"), maxCalendarTextLength),
+		Location:    truncateRunes(strings.TrimSpace(c.Text("LOCATION")), maxCalendarTextLength),
+		ExDates:     []time.Time{},
+	}
+	if e.Title == "" {
+		e.Title = "(untitled)"
+	}
+
+	startProp := c.Get("DTSTART")
+	if c.Name == "VTODO" {
+		e.Kind = CalendarTask
+		startProp = c.Get("DUE")
+		if startProp == nil {
+			return nil, false, errors.New("task has no due date")
+		}
+		if c.Get("COMPLETED") != nil || strings.EqualFold(c.Text("STATUS"), "COMPLETED") {
+			completed := time.Now().UTC()
+			if p := c.Get("COMPLETED"); p != nil {
+				if t, _, err := p.Time(time.UTC); err == nil {
+					completed = t
+				}
+			}
+			e.CompletedAt = &completed
+		}
+	}
+	if startProp == nil {
+		return nil, false, errors.New("event has no DTSTART")
+	}
+	start, allDay, err := startProp.Time(floating)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s: %s", startProp.Name, startProp.Value)
+	}
+	e.AllDay = allDay
+	e.TimeZone = start.Location().String()
+
+	end := start
+	if e.Kind == CalendarEvent {
+		switch {
+		case c.Get("DTEND") != nil:
+			end, _, err = c.Get("DTEND").Time(start.Location())
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid DTEND: %s", c.Get("DTEND").Value)
+			}
+		case c.Get("DURATION") != nil:
+			d, err := ical.ParseDuration(c.Get("DURATION").Value)
+			if err != nil {
+				return nil, false, errors.New("invalid DURATION")
+			}
+			end = start.Add(d)
+		case allDay:
+			end = start.AddDate(0, 0, 1)
+		}
+		if end.Before(start) {
+			return nil, false, errors.New("ends before it starts")
+		}
+	}
+
+	if p := c.Get("RECURRENCE-ID"); p != nil {
+		recurrence, recurrenceAllDay, err := p.Time(floating)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid RECURRENCE-ID: %s", p.Value)
+		}
+		e.UID = uid + "/" + instanceKey(recurrence, recurrenceAllDay)
+		return []CalendarEntry{withTimes(e, start, end)}, false, nil
+	}
+	rrule := c.Get("RRULE")
+	if rrule == nil {
+		return []CalendarEntry{withTimes(e, start, end)}, false, nil
+	}
+
+	rule, err := ical.ParseRecurrence(rrule.Value, start.Location())
+	if err != nil {
+		return nil, false, errors.New(strings.TrimPrefix(err.Error(), "ical: "))
+	}
+	exdates := map[string]bool{}
+	for _, p := range c.All("EXDATE") {
+		times, err := p.Times(start.Location())
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid EXDATE: %s", p.Value)
+		}
+		for _, t := range times {
+			exdates[instanceKey(t, allDay)] = true
+		}
+	}
+	starts, more := rule.Expand(start, from, to, maxImportedOccurrences)
+	var entries []CalendarEntry
+	for _, occurrence := range starts {
+		key := instanceKey(occurrence, allDay)
+		if exdates[key] || overridden[uid+"/"+key] {
+			continue
+		}
+		instance := e
+		instance.UID = uid + "/" + key
+		entries = append(entries, withTimes(instance, occurrence, endOf(occurrence, start, end, allDay)))
+	}
+	return entries, more, nil
+}
+
+func withTimes(e CalendarEntry, start, end time.Time) CalendarEntry {
+	e.StartsAt = start
+	if e.Kind == CalendarEvent {
+		e.EndsAt = &end
+	}
+	return e
+}
+
+// instanceKey identifies an instance of a series by its start, in the
+// format of RECURRENCE-ID values.
+func instanceKey(t time.Time, allDay bool) string {
+	if allDay {
+		return ical.FormatDate(t)
+	}
+	return ical.FormatUTC(t)
+}
+
+func importReason(err error) string {
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		return appErr.Message
+	}
+	return err.Error()
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,310 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"greact-bones/backend/internal/yjs"
+)
+
+const (
+	// JobCompactDocuments folds the stored updates of busy documents into
+	// their snapshot.
+	JobCompactDocuments = "collab.compact"
+
+	// Team access levels of a shared document.
+	CollabEdit = "edit"
+	CollabView = "view"
+
+	maxCollabTitleLength = 200
+	// compactAfterUpdates is how many stored updates the periodic job
+	// leaves alone before compacting a document.
+	compactAfterUpdates = 50
+)
+
+// CollabShare grants a team access to a collaborative document.
+type CollabShare struct {
+	TeamID string `json:"team_id"`
+	Access string `json:"access"`
+}
+
+// CollabDocument is a document edited concurrently by several clients
+// through the Yjs sync protocol. Its content is the snapshot plus the
+// updates stored since the last compaction; the server never interprets it.
+type CollabDocument struct {
+	ID             string        `json:"id"`
+	TenantID       string        `json:"tenant_id"`
+	OwnerID        string        `json:"owner_id"`
+	Title          string        `json:"title"`
+	Shares         []CollabShare `json:"shares"`
+	PendingUpdates int           `json:"pending_updates"`
+	CompactedAt    *time.Time    `json:"compacted_at,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// CollabDocumentInput creates or updates a document. Nil fields are left
+// unchanged on update.
+type CollabDocumentInput struct {
+	Title  *string        `json:"title"`
+	Shares *[]CollabShare `json:"shares"`
+}
+
+// CollabUpdate is a stored Yjs update.
+type CollabUpdate struct {
+	ID   string
+	Data []byte
+}
+
+// CollabState is a document's snapshot and the updates stored after it.
+// Version changes with every compaction.
+type CollabState struct {
+	Snapshot []byte
+	Version  int
+	Updates  []CollabUpdate
+}
+
+// CollabRepository persists documents, their shares and Yjs updates.
+type CollabRepository interface {
+	Create(ctx context.Context, d *CollabDocument, snapshot []byte) error
+	Get(ctx context.Context, tenantID, id string) (*CollabDocument, error)
+	ListVisible(ctx context.Context, tenantID, userID string, teams []string, params ListParams) ([]CollabDocument, int, error)
+	Update(ctx context.Context, d *CollabDocument) error
+	Delete(ctx context.Context, tenantID, id string) error
+	AppendUpdate(ctx context.Context, tenantID, documentID string, u CollabUpdate, at time.Time) error
+	State(ctx context.Context, tenantID, id string) (*CollabState, error)
+	// SaveSnapshot replaces the snapshot and deletes the merged updates,
+	// failing with ErrConflict if the snapshot changed since version.
+	SaveSnapshot(ctx context.Context, tenantID, id string, version int, snapshot []byte, merged []string, at time.Time) error
+	// NeedingCompaction returns the documents with at least minUpdates
+	// stored updates.
+	NeedingCompaction(ctx context.Context, minUpdates, limit int) ([]CollabDocument, error)
+}
+
+// CollabService manages collaborative documents and their persisted
+// state. Live editing sessions are handled by realtime.CollabServer.
+type CollabService struct {
+	repo CollabRepository
+}
+
+// NewCollabService creates the service and registers its compaction job.
+func NewCollabService(repo CollabRepository, jobs *JobQueue) *CollabService {
+	s := &CollabService{repo: repo}
+	jobs.Register(JobCompactDocuments, s.handleCompact)
+	return s
+}
+
+// List returns the documents the caller owns or that are shared with one
+// of the caller's teams.
+func (s *CollabService) List(ctx context.Context, actor Identity, params ListParams) ([]CollabDocument, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.ListVisible(ctx, actor.TenantID, actor.UserID, actor.Teams, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Get returns a document visible to the caller.
+func (s *CollabService) Get(ctx context.Context, actor Identity, id string) (*CollabDocument, error) {
+	d, _, err := s.Join(ctx, actor, id)
+	return d, err
+}
+
+// Join checks that the caller may open a document and reports whether
+// they may edit it: owners and teams with edit access can, teams with
+// view access only receive changes.
+func (s *CollabService) Join(ctx context.Context, actor Identity, id string) (*CollabDocument, bool, error) {
+	d, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if d.OwnerID == actor.UserID {
+		return d, true, nil
+	}
+	canView, canEdit := false, false
+	for _, share := range d.Shares {
+		if slices.Contains(actor.Teams, share.TeamID) {
+			canView = true
+			canEdit = canEdit || share.Access == CollabEdit
+		}
+	}
+	if !canView {
+		return nil, false, NotFound("Document")
+	}
+	return d, canEdit, nil
+}
+
+// Create adds an empty document owned by the caller.
+func (s *CollabService) Create(ctx context.Context, actor Identity, in CollabDocumentInput) (*CollabDocument, error) {
+	if in.Title == nil {
+		return nil, InvalidInput("title is required")
+	}
+	now := time.Now().UTC()
+	d := &CollabDocument{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		OwnerID:   actor.UserID,
+		Shares:    []CollabShare{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.apply(actor, d, in); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, d, yjs.EmptyUpdate); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Update changes a document's title or shares. Only the owner may.
+func (s *CollabService) Update(ctx context.Context, actor Identity, id string, in CollabDocumentInput) (*CollabDocument, error) {
+	d, err := s.owned(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(actor, d, in); err != nil {
+		return nil, err
+	}
+	d.UpdatedAt = time.Now().UTC()
+	if err := s.repo.Update(ctx, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Delete removes a document with its history. Only the owner may.
+func (s *CollabService) Delete(ctx context.Context, actor Identity, id string) error {
+	if _, err := s.owned(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, actor.TenantID, id)
+}
+
+// Content returns the document as a single Yjs update, for clients that
+// load it without joining a session.
+func (s *CollabService) Content(ctx context.Context, actor Identity, id string) ([]byte, error) {
+	if _, _, err := s.Join(ctx, actor, id); err != nil {
+		return nil, err
+	}
+	return s.State(ctx, actor.TenantID, id)
+}
+
+// State merges the snapshot and stored updates of a document.
+func (s *CollabService) State(ctx context.Context, tenantID, id string) ([]byte, error) {
+	st, err := s.repo.State(ctx, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	return mergeCollabState(st)
+}
+
+// StoreUpdate persists an update a client sent. Malformed updates are
+// rejected so they cannot break compaction or other clients.
+func (s *CollabService) StoreUpdate(ctx context.Context, tenantID, id string, update []byte) error {
+	if err := yjs.Validate(update); err != nil {
+		return InvalidInput("invalid document update")
+	}
+	return s.repo.AppendUpdate(ctx, tenantID, id, CollabUpdate{ID: NewID(), Data: update}, time.Now().UTC())
+}
+
+// Compact folds the stored updates of a document into its snapshot. If
+// another replica compacts the document at the same time, this one gives
+// way.
+func (s *CollabService) Compact(ctx context.Context, tenantID, id string) error {
+	st, err := s.repo.State(ctx, tenantID, id)
+	if err != nil {
+		return err
+	}
+	if len(st.Updates) == 0 {
+		return nil
+	}
+	snapshot, err := mergeCollabState(st)
+	if err != nil {
+		return err
+	}
+	merged := make([]string, len(st.Updates))
+	for i, u := range st.Updates {
+		merged[i] = u.ID
+	}
+	err = s.repo.SaveSnapshot(ctx, tenantID, id, st.Version, snapshot, merged, time.Now().UTC())
+	if errors.Is(err, ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+// handleCompact compacts every document with enough stored updates.
+func (s *CollabService) handleCompact(ctx context.Context, job Job) error {
+	docs, err := s.repo.NeedingCompaction(ctx, compactAfterUpdates, 100)
+	if err != nil {
+		return err
+	}
+	for _, d := range docs {
+		if err := s.Compact(ctx, d.TenantID, d.ID); err != nil {
+			log.Printf("collab: compacting document %s failed: %v", d.ID, err)
+		}
+	}
+	return nil
+}
+
+func mergeCollabState(st *CollabState) ([]byte, error) {
+	updates := make([][]byte, 0, len(st.Updates)+1)
+	updates = append(updates, st.Snapshot)
+	for _, u := range st.Updates {
+		updates = append(updates, u.Data)
+	}
+	merged, err := yjs.MergeUpdates(updates...)
+	if err != nil {
+		return nil, fmt.Errorf("collab: merging document state: %w", err)
+	}
+	return merged, nil
+}
+
+// apply validates the input and copies it onto the document. Documents
+// can only be shared with teams the caller belongs to.
+func (s *CollabService) apply(actor Identity, d *CollabDocument, in CollabDocumentInput) error {
+	if in.Title != nil {
+		title := strings.TrimSpace(*in.Title)
+		if title == "" || utf8.RuneCountInString(title) > maxCollabTitleLength {
+			return InvalidInput("title must be between 1 and %d characters", maxCollabTitleLength)
+		}
+		d.Title = title
+	}
+	if in.Shares != nil {
+		shares := []CollabShare{}
+		seen := map[string]bool{}
+		for _, share := range *in.Shares {
+			if share.Access != CollabEdit && share.Access != CollabView {
+				return InvalidInput("access must be %s or %s", CollabEdit, CollabView)
+			}
+			if !actor.IsAdmin() && !slices.Contains(actor.Teams, share.TeamID) {
+				return InvalidInput("you can only share documents with your own teams (%s)", share.TeamID)
+			}
+			if !seen[share.TeamID] {
+				seen[share.TeamID] = true
+				shares = append(shares, share)
+			}
+		}
+		d.Shares = shares
+	}
+	return nil
+}
+
+func (s *CollabService) owned(ctx context.Context, actor Identity, id string) (*CollabDocument, error) {
+	d, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if d.OwnerID != actor.UserID {
+		return nil, ErrForbidden
+	}
+	return d, nil
+}
@@ -0,0 +1,616 @@
+package domain
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxCommentLength = 10000
+	maxEmojiLength   = 32
+)
+
+var (
+	resourceTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+	mentionPattern      = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.-]{1,64})`)
+)
+
+var (
+	ErrCommentDeleted = AppError{
+		Status:  http.StatusConflict,
+		Code:    "COMMENT_DELETED",
+		Message: "Comment has been deleted",
+	}
+)
+
+// CommentPreferenceKeys lets users choose which comment notifications
+// they receive.
+var CommentPreferenceKeys = []PreferenceKey{
+	{
+		Key:         "notifications.comment.mention",
+		Type:        PreferenceBool,
+		Default:     true,
+		Description: "Notify me when someone mentions me in a comment",
+	},
+	{
+		Key:         "notifications.comment.reply",
+		Type:        PreferenceBool,
+		Default:     true,
+		Description: "Notify me when someone replies to my comment",
+	},
+}
+
+// CommentEventData is the data of comment.created and comment.updated.
+type CommentEventData struct {
+	CommentID        string   `json:"comment_id"`
+	ThreadID         string   `json:"thread_id" doc:"ID of the thread's root comment"`
+	ParentID         string   `json:"parent_id,omitempty" doc:"Comment replied to; only set on comment.created"`
+	Excerpt          string   `json:"excerpt" doc:"Start of the comment body"`
+	MentionedUserIDs []string `json:"mentioned_user_ids"`
+}
+
+// CommentDeletedData is the data of comment.deleted.
+type CommentDeletedData struct {
+	CommentID string `json:"comment_id"`
+	ThreadID  string `json:"thread_id"`
+}
+
+// CommentReactionData is the data of comment.reaction_added and
+// comment.reaction_removed.
+type CommentReactionData struct {
+	CommentID string `json:"comment_id"`
+	ThreadID  string `json:"thread_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// CommentEventSchemas describes the events published by CommentService.
+// They are streamed to clients watching the commented resource.
+var CommentEventSchemas = []MessageSchema{
+	{
+		Name:    "comment.created",
+		Version: 1,
+		Summary: "A comment or reply was posted",
+		Channel: ChannelResource,
+		Payload: SchemaOf(CommentEventData{}),
+	},
+	{
+		Name:    "comment.updated",
+		Version: 1,
+		Summary: "A comment was edited",
+		Channel: ChannelResource,
+		Payload: SchemaOf(CommentEventData{}),
+	},
+	{
+		Name:    "comment.deleted",
+		Version: 1,
+		Summary: "A comment was deleted",
+		Channel: ChannelResource,
+		Payload: SchemaOf(CommentDeletedData{}),
+	},
+	{
+		Name:    "comment.reaction_added",
+		Version: 1,
+		Summary: "A user reacted to a comment",
+		Channel: ChannelResource,
+		Payload: SchemaOf(CommentReactionData{}),
+	},
+	{
+		Name:    "comment.reaction_removed",
+		Version: 1,
+		Summary: "A user removed a reaction from a comment",
+		Channel: ChannelResource,
+		Payload: SchemaOf(CommentReactionData{}),
+	},
+}
+
+// Comment is a message attached to any resource. Comments form threads:
+// the first comment of a thread is its root and every reply carries the
+// root's ID as ThreadID. Comments hidden by moderators keep their place
+// in the thread, but only their author and moderators see the body.
+type Comment struct {
+	ID           string            `json:"id"`
+	TenantID     string            `json:"tenant_id"`
+	ResourceType string            `json:"resource_type"`
+	ResourceID   string            `json:"resource_id"`
+	ThreadID     string            `json:"thread_id"`
+	ParentID     string            `json:"parent_id,omitempty"`
+	AuthorID     string            `json:"author_id"`
+	Body         string            `json:"body"`
+	Mentions     []string          `json:"mentions"`
+	Reactions    []ReactionSummary `json:"reactions"`
+	ReplyCount   int               `json:"reply_count"`
+	EditedAt     *time.Time        `json:"edited_at,omitempty"`
+	HiddenAt     *time.Time        `json:"hidden_at,omitempty"`
+	DeletedAt    *time.Time        `json:"deleted_at,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// ReactionSummary aggregates the reactions of one emoji on a comment.
+type ReactionSummary struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// CommentRevision is a previous version of an edited comment.
+type CommentRevision struct {
+	ID        string    `json:"id"`
+	CommentID string    `json:"comment_id"`
+	Body      string    `json:"body"`
+	EditorID  string    `json:"editor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateCommentInput is the payload for posting a comment.
+type CreateCommentInput struct {
+	ResourceType string
+	ResourceID   string
+	ParentID     string
+	Body         string
+}
+
+// CommentRepository persists comments, their revisions and reactions.
+type CommentRepository interface {
+	Create(ctx context.Context, c *Comment) error
+	Get(ctx context.Context, tenantID, id string) (*Comment, error)
+	ListThreads(ctx context.Context, tenantID, resourceType, resourceID string, params ListParams) ([]Comment, int, error)
+	ListReplies(ctx context.Context, tenantID, threadID string, params ListParams) ([]Comment, int, error)
+	UpdateBody(ctx context.Context, c *Comment, previous CommentRevision) error
+	SoftDelete(ctx context.Context, tenantID, id string, at time.Time) error
+	SetHidden(ctx context.Context, tenantID, id string, at *time.Time) error
+	Revisions(ctx context.Context, tenantID, commentID string) ([]CommentRevision, error)
+	SetMentions(ctx context.Context, commentID string, userIDs []string) error
+	AddReaction(ctx context.Context, commentID, userID, emoji string, at time.Time) (bool, error)
+	RemoveReaction(ctx context.Context, commentID, userID, emoji string) (bool, error)
+}
+
+// CommentService implements threaded discussions on resources.
+type CommentService struct {
+	repo          CommentRepository
+	users         *UserService
+	notifications *NotificationService
+	events        *EventBus
+	moderation    *ModerationService
+}
+
+// NewCommentService creates a comment service.
+func NewCommentService(repo CommentRepository, users *UserService, notifications *NotificationService, events *EventBus) *CommentService {
+	return &CommentService{repo: repo, users: users, notifications: notifications, events: events}
+}
+
+// UseModeration keeps banned users from posting, editing and reacting,
+// and checks new and edited comments against the moderation rules before
+// they are announced.
+func (s *CommentService) UseModeration(m *ModerationService) {
+	s.moderation = m
+}
+
+// ValidateResourceRef checks a resource type and ID taken from a URL.
+func ValidateResourceRef(resourceType, resourceID string) error {
+	if !resourceTypePattern.MatchString(resourceType) {
+		return InvalidInput("invalid resource type %q", resourceType)
+	}
+	if resourceID == "" || len(resourceID) > 100 {
+		return InvalidInput("invalid resource id")
+	}
+	return nil
+}
+
+// ListThreads returns a page of root comments on a resource, oldest first.
+func (s *CommentService) ListThreads(ctx context.Context, actor Identity, resourceType, resourceID string, params ListParams) ([]Comment, PaginationMeta, error) {
+	if err := ValidateResourceRef(resourceType, resourceID); err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.ListThreads(ctx, actor.TenantID, resourceType, resourceID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	redactHidden(actor, items)
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// ListReplies returns a page of the replies in a comment's thread.
+func (s *CommentService) ListReplies(ctx context.Context, actor Identity, commentID string, params ListParams) ([]Comment, PaginationMeta, error) {
+	root, err := s.repo.Get(ctx, actor.TenantID, commentID)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.ListReplies(ctx, actor.TenantID, root.ThreadID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	redactHidden(actor, items)
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Get returns a single comment.
+func (s *CommentService) Get(ctx context.Context, actor Identity, id string) (*Comment, error) {
+	c, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	comments := []Comment{*c}
+	redactHidden(actor, comments)
+	return &comments[0], nil
+}
+
+// Create posts a new comment or reply, notifies mentioned users and the
+// author of the parent comment, and publishes comment.created.
+func (s *CommentService) Create(ctx context.Context, actor Identity, in CreateCommentInput) (*Comment, error) {
+	body, err := normalizeCommentBody(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAllowed(ctx, actor); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	c := &Comment{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		AuthorID:  actor.UserID,
+		Body:      body,
+		Reactions: []ReactionSummary{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	var parent *Comment
+	if in.ParentID != "" {
+		parent, err = s.repo.Get(ctx, actor.TenantID, in.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.DeletedAt != nil {
+			return nil, ErrCommentDeleted
+		}
+		c.ResourceType, c.ResourceID = parent.ResourceType, parent.ResourceID
+		c.ThreadID, c.ParentID = parent.ThreadID, parent.ID
+	} else {
+		if err := ValidateResourceRef(in.ResourceType, in.ResourceID); err != nil {
+			return nil, err
+		}
+		c.ResourceType, c.ResourceID = in.ResourceType, in.ResourceID
+		c.ThreadID = c.ID
+	}
+
+	if err := s.repo.Create(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := s.screen(ctx, c); err != nil {
+		return nil, err
+	}
+	mentioned, err := s.applyMentions(ctx, c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if parent != nil && parent.AuthorID != actor.UserID && !slices.Contains(mentioned, parent.AuthorID) {
+		s.notify(ctx, actor, c, parent.AuthorID, "comment.reply", "New reply to your comment")
+	}
+	s.publish(ctx, actor, "comment.created", c, map[string]interface{}{
+		"thread_id":          c.ThreadID,
+		"parent_id":          c.ParentID,
+		"excerpt":            announcedExcerpt(c),
+		"mentioned_user_ids": mentioned,
+	})
+	return c, nil
+}
+
+// Edit replaces the body of a comment, keeping the previous text as a
+// revision. Only the author may edit a comment.
+func (s *CommentService) Edit(ctx context.Context, actor Identity, id, body string) (*Comment, error) {
+	body, err := normalizeCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAllowed(ctx, actor); err != nil {
+		return nil, err
+	}
+	c, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.AuthorID != actor.UserID {
+		return nil, ErrForbidden
+	}
+	if c.DeletedAt != nil {
+		return nil, ErrCommentDeleted
+	}
+	if c.Body == body {
+		return c, nil
+	}
+
+	now := time.Now().UTC()
+	previous := CommentRevision{
+		ID:        NewID(),
+		CommentID: c.ID,
+		Body:      c.Body,
+		EditorID:  actor.UserID,
+		CreatedAt: now,
+	}
+	c.Body = body
+	c.EditedAt = &now
+	c.UpdatedAt = now
+	if err := s.repo.UpdateBody(ctx, c, previous); err != nil {
+		return nil, err
+	}
+	if err := s.screen(ctx, c); err != nil {
+		return nil, err
+	}
+	mentioned, err := s.applyMentions(ctx, c, c.Mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, actor, "comment.updated", c, map[string]interface{}{
+		"thread_id":          c.ThreadID,
+		"excerpt":            announcedExcerpt(c),
+		"mentioned_user_ids": mentioned,
+	})
+	return c, nil
+}
+
+// Delete soft-deletes a comment so that its thread stays intact. Authors
+// may delete their own comments and admins may delete any comment.
+func (s *CommentService) Delete(ctx context.Context, actor Identity, id string) error {
+	c, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return err
+	}
+	if c.AuthorID != actor.UserID && !actor.IsAdmin() {
+		return ErrForbidden
+	}
+	if c.DeletedAt != nil {
+		return nil
+	}
+	if err := s.repo.SoftDelete(ctx, actor.TenantID, id, time.Now().UTC()); err != nil {
+		return err
+	}
+	s.publish(ctx, actor, "comment.deleted", c, map[string]interface{}{"thread_id": c.ThreadID})
+	return nil
+}
+
+// ModeratedContent describes a comment to moderation.
+func (s *CommentService) ModeratedContent(ctx context.Context, tenantID, id string) (ModeratedContent, error) {
+	c, err := s.repo.Get(ctx, tenantID, id)
+	if err != nil {
+		return ModeratedContent{}, err
+	}
+	if c.DeletedAt != nil {
+		return ModeratedContent{}, ErrCommentDeleted
+	}
+	return ModeratedContent{AuthorID: c.AuthorID, Text: c.Body}, nil
+}
+
+// SetHidden hides a comment from everyone but its author and moderators,
+// or shows it again.
+func (s *CommentService) SetHidden(ctx context.Context, tenantID, id string, hidden bool) error {
+	var at *time.Time
+	if hidden {
+		now := time.Now().UTC()
+		at = &now
+	}
+	return s.repo.SetHidden(ctx, tenantID, id, at)
+}
+
+// RemoveModerated deletes a comment on a moderator's decision.
+func (s *CommentService) RemoveModerated(ctx context.Context, actor Identity, id string) error {
+	c, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return err
+	}
+	if c.DeletedAt != nil {
+		return nil
+	}
+	if err := s.repo.SoftDelete(ctx, actor.TenantID, id, time.Now().UTC()); err != nil {
+		return err
+	}
+	s.publish(ctx, actor, "comment.deleted", c, map[string]interface{}{"thread_id": c.ThreadID})
+	return nil
+}
+
+// History returns the previous versions of a comment, newest first.
+// Deleted comments have none; those of hidden comments are only shown to
+// moderators reviewing them.
+func (s *CommentService) History(ctx context.Context, actor Identity, id string) ([]CommentRevision, error) {
+	c, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.DeletedAt != nil || (c.HiddenAt != nil && !actor.IsModerator()) {
+		return nil, NotFound("Comment")
+	}
+	return s.repo.Revisions(ctx, actor.TenantID, id)
+}
+
+// React adds the caller's reaction to a comment.
+func (s *CommentService) React(ctx context.Context, actor Identity, id, emoji string) (*Comment, error) {
+	return s.toggleReaction(ctx, actor, id, emoji, true)
+}
+
+// Unreact removes the caller's reaction from a comment.
+func (s *CommentService) Unreact(ctx context.Context, actor Identity, id, emoji string) (*Comment, error) {
+	return s.toggleReaction(ctx, actor, id, emoji, false)
+}
+
+func (s *CommentService) toggleReaction(ctx context.Context, actor Identity, id, emoji string, add bool) (*Comment, error) {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return nil, InvalidInput("invalid reaction")
+	}
+	if err := s.checkAllowed(ctx, actor); err != nil {
+		return nil, err
+	}
+	c, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.DeletedAt != nil {
+		return nil, ErrCommentDeleted
+	}
+
+	var changed bool
+	if add {
+		changed, err = s.repo.AddReaction(ctx, c.ID, actor.UserID, emoji, time.Now().UTC())
+	} else {
+		changed, err = s.repo.RemoveReaction(ctx, c.ID, actor.UserID, emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		eventType := "comment.reaction_added"
+		if !add {
+			eventType = "comment.reaction_removed"
+		}
+		s.publish(ctx, actor, eventType, c, map[string]interface{}{"thread_id": c.ThreadID, "emoji": emoji})
+	}
+	return s.Get(ctx, actor, id)
+}
+
+// applyMentions stores the users mentioned in the comment body and notifies
+// the ones that were not mentioned before. It returns all mentioned IDs.
+func (s *CommentService) applyMentions(ctx context.Context, c *Comment, previous []string) ([]string, error) {
+	users, err := s.users.ResolveUsernames(ctx, c.TenantID, ParseMentions(c.Body))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	if err := s.repo.SetMentions(ctx, c.ID, ids); err != nil {
+		return nil, err
+	}
+	c.Mentions = ids
+
+	actor := Identity{UserID: c.AuthorID, TenantID: c.TenantID}
+	for _, id := range ids {
+		if id == c.AuthorID || slices.Contains(previous, id) {
+			continue
+		}
+		s.notify(ctx, actor, c, id, "comment.mention", "You were mentioned in a comment")
+	}
+	return ids, nil
+}
+
+// screen runs the moderation rules on a saved comment and picks up
+// whether they hid it, so its text is left out of the notifications and
+// events announcing it.
+func (s *CommentService) screen(ctx context.Context, c *Comment) error {
+	if s.moderation == nil {
+		return nil
+	}
+	if err := s.moderation.Flag(ctx, c.TenantID, "comment", c.ID); err != nil {
+		// The comment is saved; moderators can still flag it by hand.
+		log.Printf("moderation: checking comment %s: %v", c.ID, err)
+	}
+	saved, err := s.repo.Get(ctx, c.TenantID, c.ID)
+	if err != nil {
+		return err
+	}
+	c.HiddenAt = saved.HiddenAt
+	return nil
+}
+
+// announcedExcerpt is the excerpt sent out about a comment, which is
+// empty while the comment is hidden.
+func announcedExcerpt(c *Comment) string {
+	if c.HiddenAt != nil {
+		return ""
+	}
+	return excerpt(c.Body)
+}
+
+func (s *CommentService) checkAllowed(ctx context.Context, actor Identity) error {
+	if s.moderation == nil {
+		return nil
+	}
+	return s.moderation.CheckAllowed(ctx, actor)
+}
+
+// redactHidden blanks the body of hidden comments for callers other than
+// their author and moderators.
+func redactHidden(actor Identity, comments []Comment) {
+	for i := range comments {
+		c := &comments[i]
+		if c.HiddenAt != nil && c.AuthorID != actor.UserID && !actor.IsModerator() {
+			c.Body = ""
+			c.Mentions = []string{}
+		}
+	}
+}
+
+func (s *CommentService) notify(ctx context.Context, actor Identity, c *Comment, userID, kind, title string) {
+	err := s.notifications.Notify(ctx, Notification{
+		TenantID:     c.TenantID,
+		UserID:       userID,
+		Kind:         kind,
+		Title:        title,
+		Body:         announcedExcerpt(c),
+		ActorID:      actor.UserID,
+		ResourceType: c.ResourceType,
+		ResourceID:   c.ResourceID,
+	})
+	if err != nil {
+		// The comment is already saved; a lost notification is not worth
+		// failing the request for.
+		log.Printf("notify %s about comment %s: %v", userID, c.ID, err)
+	}
+}
+
+func (s *CommentService) publish(ctx context.Context, actor Identity, eventType string, c *Comment, data map[string]interface{}) {
+	data["comment_id"] = c.ID
+	s.events.Publish(ctx, Event{
+		Type:         eventType,
+		TenantID:     c.TenantID,
+		ActorID:      actor.UserID,
+		ResourceType: c.ResourceType,
+		ResourceID:   c.ResourceID,
+		Data:         data,
+	})
+}
+
+// ParseMentions returns the distinct usernames mentioned with @name.
+func ParseMentions(body string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		name := strings.TrimRight(m[1], ".-")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+func normalizeCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", InvalidInput("comment body is required")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return "", InvalidInput("comment body must be at most %d characters", maxCommentLength)
+	}
+	return body, nil
+}
+
+func excerpt(s string) string {
+	const max = 140
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max]) + "…"
+}
@@ -0,0 +1,585 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// JobRenderDocument renders a document in the background.
+	JobRenderDocument = "documents.render"
+	// JobExpireDocuments deletes documents past their retention.
+	JobExpireDocuments = "documents.expire"
+
+	// Kinds of the notifications about documents rendered in the
+	// background.
+	NotificationDocumentReady  = "document.ready"
+	NotificationDocumentFailed = "document.failed"
+
+	// inlineDocumentBytes is the most data rendered while the caller
+	// waits; larger documents and reports are rendered in the background.
+	inlineDocumentBytes = 64 << 10
+	maxDocumentData     = 5 << 20
+	maxReportRows       = 10000
+	documentExpireBatch = 500
+)
+
+// ErrDocumentLinkExpired is returned for download links past their
+// expiry; a fresh link comes with the document.
+var ErrDocumentLinkExpired = AppError{
+	Status:  http.StatusGone,
+	Code:    "LINK_EXPIRED",
+	Message: "The download link has expired",
+}
+
+// DocumentStatus is the state of a generated document.
+type DocumentStatus string
+
+const (
+	DocumentPending DocumentStatus = "pending"
+	DocumentReady   DocumentStatus = "ready"
+	DocumentFailed  DocumentStatus = "failed"
+)
+
+// Document is a PDF generated from a template. Once ready, its content
+// is a file of its owner, and URL is a signed link downloading it
+// without a token until URLExpiresAt. Documents and their files are
+// deleted at ExpiresAt.
+type Document struct {
+	ID           string         `json:"id"`
+	TenantID     string         `json:"tenant_id"`
+	OwnerID      string         `json:"owner_id"`
+	Template     string         `json:"template"`
+	Name         string         `json:"name"`
+	Source       string         `json:"source,omitempty"`
+	Status       DocumentStatus `json:"status"`
+	Error        string         `json:"error,omitempty"`
+	FileID       *string        `json:"file_id"`
+	Pages        int            `json:"pages"`
+	Size         int64          `json:"size"`
+	URL          string         `json:"url,omitempty"`
+	URLExpiresAt *time.Time     `json:"url_expires_at,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	CompletedAt  *time.Time     `json:"completed_at,omitempty"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+// DocumentRequest asks for a document. Data is handed to the template.
+// Reports name a list source whose rows, filtered and sorted by Query,
+// are handed to the template too; they are always rendered in the
+// background, as are requests with Background set.
+type DocumentRequest struct {
+	Template   string          `json:"template" binding:"required"`
+	Name       string          `json:"name"`
+	Data       json.RawMessage `json:"data"`
+	Source     string          `json:"source"`
+	Query      ListQuery       `json:"query"`
+	Background bool            `json:"background"`
+}
+
+// DocumentTemplate describes a template documents are rendered from.
+type DocumentTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Builtin     bool   `json:"builtin"`
+}
+
+// DocumentContext is what templates are executed with.
+type DocumentContext struct {
+	Name        string
+	Author      string
+	GeneratedAt time.Time
+	Data        interface{}
+	// List holds the rows of reports
+	List *DocumentList
+}
+
+// DocumentList is the rows of a list source, formatted as text.
+type DocumentList struct {
+	Source    string
+	Columns   []string
+	Rows      [][]string
+	Total     int
+	Truncated bool
+}
+
+// DocumentImageLoader opens the content of an image file for a document.
+type DocumentImageLoader func(ctx context.Context, fileID string) (io.ReadCloser, error)
+
+// DocumentRenderer renders templates into PDF.
+type DocumentRenderer interface {
+	Templates() []DocumentTemplate
+	// Render executes a template with data, writes the PDF to w and
+	// returns its number of pages.
+	Render(ctx context.Context, template string, data interface{}, images DocumentImageLoader, w io.Writer) (int, error)
+}
+
+// DocumentRepository persists documents. The request of a pending
+// document is kept until it is rendered.
+type DocumentRepository interface {
+	Create(ctx context.Context, d *Document, request []byte) error
+	Get(ctx context.Context, tenantID, id string) (*Document, error)
+	Request(ctx context.Context, tenantID, id string) ([]byte, error)
+	// Finish records the outcome of rendering and drops the request.
+	Finish(ctx context.Context, d *Document) error
+	List(ctx context.Context, tenantID, ownerID string, params ListParams) ([]Document, int, error)
+	Delete(ctx context.Context, tenantID, id string) error
+	Expired(ctx context.Context, now time.Time, limit int) ([]Document, error)
+}
+
+// DocumentService generates PDF documents from templates, stores them as
+// files and hands out signed download links.
+type DocumentService struct {
+	repo          DocumentRepository
+	renderer      DocumentRenderer
+	files         *FileService
+	listing       *ListingService
+	jobs          *JobQueue
+	notifications *NotificationService
+	key           []byte
+	appURL        string
+	linkTTL       time.Duration
+	retention     time.Duration
+}
+
+// NewDocumentService creates a document service and registers its jobs.
+// Links are signed with a key derived from secret and stay valid for
+// linkTTL; documents are kept for retention.
+func NewDocumentService(repo DocumentRepository, renderer DocumentRenderer, files *FileService, listing *ListingService,
+	jobs *JobQueue, secret, appURL string, linkTTL, retention time.Duration) *DocumentService {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("document-links"))
+	s := &DocumentService{
+		repo: repo, renderer: renderer, files: files, listing: listing, jobs: jobs,
+		key: mac.Sum(nil), appURL: strings.TrimRight(appURL, "/"), linkTTL: linkTTL, retention: retention,
+	}
+	jobs.Register(JobRenderDocument, s.handleRender)
+	jobs.Register(JobExpireDocuments, s.handleExpire)
+	return s
+}
+
+// UseNotifications tells owners when documents rendered in the
+// background are ready or failed.
+func (s *DocumentService) UseNotifications(n *NotificationService) {
+	s.notifications = n
+}
+
+// Templates lists the templates documents can be rendered from.
+func (s *DocumentService) Templates() []DocumentTemplate {
+	return s.renderer.Templates()
+}
+
+// Create generates a document for the caller. Small documents are
+// rendered right away and returned ready; mistakes in them are reported
+// without keeping a document. Others are returned pending and rendered
+// by a job.
+func (s *DocumentService) Create(ctx context.Context, actor Identity, req DocumentRequest) (*Document, error) {
+	if !s.hasTemplate(req.Template) {
+		return nil, NotFound("Document template")
+	}
+	if len(req.Data) > maxDocumentData {
+		return nil, InvalidInput("document data can be at most %d bytes", maxDocumentData)
+	}
+	if len(req.Data) > 0 && !bytes.Equal(bytes.TrimSpace(req.Data), []byte("null")) {
+		var data interface{}
+		if err := json.Unmarshal(req.Data, &data); err != nil {
+			return nil, InvalidInput("data must be JSON")
+		}
+	}
+	if req.Source != "" {
+		q, err := s.listing.Validate(req.Source, req.Query)
+		if err != nil {
+			return nil, err
+		}
+		req.Query = q
+	}
+	name, err := documentName(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	d := &Document{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		OwnerID:   actor.UserID,
+		Template:  req.Template,
+		Name:      name,
+		Source:    req.Source,
+		Status:    DocumentPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.retention),
+	}
+	if req.Source == "" && !req.Background && len(req.Data) <= inlineDocumentBytes {
+		file, pages, err := s.render(ctx, actor, d, req)
+		if err != nil {
+			return nil, err
+		}
+		s.complete(d, file, pages)
+		if err := s.repo.Create(ctx, d, nil); err != nil {
+			return nil, err
+		}
+		s.sign(d)
+		return d, nil
+	}
+
+	request, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, d, request); err != nil {
+		return nil, err
+	}
+	payload := documentJob{ID: d.ID, Actor: actor}
+	if _, err := s.jobs.Enqueue(WithTenant(ctx, actor.TenantID), JobRenderDocument, payload); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *DocumentService) hasTemplate(name string) bool {
+	for _, t := range s.renderer.Templates() {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// documentName is the requested file name, or the template's name, with
+// a .pdf extension.
+func documentName(req DocumentRequest) (string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = req.Template
+		if req.Source != "" {
+			name += "-" + req.Source
+		}
+		name += "-" + time.Now().UTC().Format("2006-01-02")
+	}
+	if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+		name += ".pdf"
+	}
+	return cleanFileName(name)
+}
+
+// render executes the template and stores the PDF as a file of the
+// caller.
+func (s *DocumentService) render(ctx context.Context, actor Identity, d *Document, req DocumentRequest) (*File, int, error) {
+	data, err := s.templateData(ctx, actor, d, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	var buf bytes.Buffer
+	pages, err := s.renderer.Render(ctx, d.Template, data, s.images(actor), &buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := s.files.Upload(ctx, actor, d.Name, "application/pdf", &buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, pages, nil
+}
+
+func (s *DocumentService) templateData(ctx context.Context, actor Identity, d *Document, req DocumentRequest) (*DocumentContext, error) {
+	dc := &DocumentContext{Name: strings.TrimSuffix(d.Name, ".pdf"), Author: actor.Username, GeneratedAt: time.Now().UTC()}
+	if len(req.Data) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(req.Data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&dc.Data); err != nil {
+			return nil, InvalidInput("data must be JSON")
+		}
+	}
+	if req.Source == "" {
+		return dc, nil
+	}
+
+	q, err := s.listing.Validate(req.Source, req.Query)
+	if err != nil {
+		return nil, err
+	}
+	list := &DocumentList{Source: req.Source, Columns: q.Columns, Rows: [][]string{}}
+	params := ListParams{Page: 1, Limit: maxPageLimit}
+	for {
+		rows, meta, err := s.listing.Execute(ctx, actor, req.Source, q, params)
+		if err != nil {
+			return nil, err
+		}
+		list.Total = meta.Total
+		for _, r := range rows {
+			if len(list.Rows) == maxReportRows {
+				list.Truncated = true
+				break
+			}
+			cells := make([]string, len(q.Columns))
+			for i, col := range q.Columns {
+				cells[i] = formatListValue(r[col])
+			}
+			list.Rows = append(list.Rows, cells)
+		}
+		if !meta.HasNext || list.Truncated {
+			break
+		}
+		params.Page++
+	}
+	dc.List = list
+	return dc, nil
+}
+
+// formatListValue writes a list field as report text.
+func formatListValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case time.Time:
+		return x.UTC().Format("2006-01-02 15:04")
+	case *time.Time:
+		if x == nil {
+			return ""
+		}
+		return x.UTC().Format("2006-01-02 15:04")
+	case bool:
+		if x {
+			return "yes"
+		}
+		return "no"
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// images lets templates show the caller's image files.
+func (s *DocumentService) images(actor Identity) DocumentImageLoader {
+	return func(ctx context.Context, fileID string) (io.ReadCloser, error) {
+		_, content, err := s.files.Open(ctx, actor, fileID)
+		if err != nil {
+			return nil, err
+		}
+		return content, nil
+	}
+}
+
+func (s *DocumentService) complete(d *Document, file *File, pages int) {
+	now := time.Now().UTC()
+	d.Status, d.Error = DocumentReady, ""
+	d.FileID, d.Pages, d.Size = &file.ID, pages, file.Size
+	d.CompletedAt = &now
+}
+
+// Get returns one of the caller's documents, with a fresh download link
+// when it is ready. Admins may read any document of their tenant.
+func (s *DocumentService) Get(ctx context.Context, actor Identity, id string) (*Document, error) {
+	d, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if d.OwnerID != actor.UserID && !actor.IsAdmin() {
+		return nil, NotFound("Document")
+	}
+	s.sign(d)
+	return d, nil
+}
+
+// List returns the caller's documents, newest first.
+func (s *DocumentService) List(ctx context.Context, actor Identity, params ListParams) ([]Document, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, actor.UserID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	for i := range items {
+		s.sign(&items[i])
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Delete removes one of the caller's documents and its file.
+func (s *DocumentService) Delete(ctx context.Context, actor Identity, id string) error {
+	d, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return err
+	}
+	return s.delete(ctx, d)
+}
+
+func (s *DocumentService) delete(ctx context.Context, d *Document) error {
+	if d.FileID != nil {
+		owner := Identity{UserID: d.OwnerID, TenantID: d.TenantID}
+		var appErr AppError
+		if err := s.files.Delete(ctx, owner, *d.FileID); err != nil && !(errors.As(err, &appErr) && appErr.Code == ErrNotFound.Code) {
+			return err
+		}
+	}
+	return s.repo.Delete(ctx, d.TenantID, d.ID)
+}
+
+// sign sets the download link of a ready document.
+func (s *DocumentService) sign(d *Document) {
+	if d.Status != DocumentReady {
+		return
+	}
+	expires := time.Now().Add(s.linkTTL).Truncate(time.Second).UTC()
+	query := url.Values{}
+	query.Set("tenant", d.TenantID)
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", s.signature(d.TenantID, d.ID, expires.Unix()))
+	d.URL = s.appURL + "/api/documents/" + url.PathEscape(d.ID) + "/download?" + query.Encode()
+	d.URLExpiresAt = &expires
+}
+
+func (s *DocumentService) signature(tenantID, id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\x00%s\x00%d", tenantID, id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Download opens the PDF behind a signed link. Links with a wrong
+// signature are reported as missing documents.
+func (s *DocumentService) Download(ctx context.Context, tenantID, id string, expires int64, signature string) (*Document, io.ReadSeekCloser, error) {
+	if !hmac.Equal([]byte(signature), []byte(s.signature(tenantID, id, expires))) {
+		return nil, nil, NotFound("Document")
+	}
+	if time.Now().Unix() > expires {
+		return nil, nil, ErrDocumentLinkExpired
+	}
+	d, err := s.repo.Get(ctx, tenantID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.Status != DocumentReady || d.FileID == nil {
+		return nil, nil, NotFound("Document")
+	}
+	_, content, err := s.files.Open(ctx, Identity{UserID: d.OwnerID, TenantID: d.TenantID}, *d.FileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, content, nil
+}
+
+type documentJob struct {
+	ID    string   `json:"id"`
+	Actor Identity `json:"actor"`
+}
+
+// handleRender renders a pending document. Mistakes in the template or
+// data fail the document at once; other errors are retried until the
+// job's last attempt.
+func (s *DocumentService) handleRender(ctx context.Context, job Job) error {
+	var payload documentJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+	d, err := s.repo.Get(ctx, payload.Actor.TenantID, payload.ID)
+	var appErr AppError
+	if errors.As(err, &appErr) && appErr.Code == ErrNotFound.Code {
+		// Deleted before it was rendered
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if d.Status != DocumentPending {
+		return nil
+	}
+	raw, err := s.repo.Request(ctx, d.TenantID, d.ID)
+	if err != nil {
+		return err
+	}
+	var req DocumentRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return fmt.Errorf("decode request: %w", err)
+	}
+
+	file, pages, err := s.render(ctx, payload.Actor, d, req)
+	if err != nil {
+		if !errors.As(err, &appErr) && job.Attempts < job.MaxAttempts {
+			return err
+		}
+		d.Status, d.Error = DocumentFailed, err.Error()
+		if errors.As(err, &appErr) {
+			d.Error = appErr.Message
+		}
+		now := time.Now().UTC()
+		d.CompletedAt = &now
+		if err := s.repo.Finish(ctx, d); err != nil {
+			return err
+		}
+		s.notify(ctx, d)
+		return nil
+	}
+	s.complete(d, file, pages)
+	if err := s.repo.Finish(ctx, d); err != nil {
+		return err
+	}
+	s.notify(ctx, d)
+	return nil
+}
+
+func (s *DocumentService) notify(ctx context.Context, d *Document) {
+	if s.notifications == nil {
+		return
+	}
+	n := Notification{
+		TenantID:     d.TenantID,
+		UserID:       d.OwnerID,
+		Kind:         NotificationDocumentReady,
+		Title:        fmt.Sprintf("%q is ready", d.Name),
+		Body:         fmt.Sprintf("The document has %d pages.", d.Pages),
+		ResourceType: "document",
+		ResourceID:   d.ID,
+	}
+	if d.Status == DocumentFailed {
+		n.Kind = NotificationDocumentFailed
+		n.Title = fmt.Sprintf("%q could not be generated", d.Name)
+		n.Body = d.Error
+	}
+	if err := s.notifications.Notify(ctx, n); err != nil {
+		log.Printf("documents: notifying %s about document %s: %v", d.OwnerID, d.ID, err)
+	}
+}
+
+// ExpireDocuments deletes documents past their retention, with their
+// files, and returns how many were deleted.
+func (s *DocumentService) ExpireDocuments(ctx context.Context) (int, error) {
+	removed := 0
+	for {
+		expired, err := s.repo.Expired(ctx, time.Now().UTC(), documentExpireBatch)
+		if err != nil {
+			return removed, err
+		}
+		for i := range expired {
+			if err := s.delete(ctx, &expired[i]); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+		if len(expired) < documentExpireBatch {
+			return removed, nil
+		}
+	}
+}
+
+func (s *DocumentService) handleExpire(ctx context.Context, job Job) error {
+	removed, err := s.ExpireDocuments(ctx)
+	if removed > 0 {
+		log.Printf("documents: removed %d expired documents", removed)
+	}
+	return err
+}
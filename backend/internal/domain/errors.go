@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AppError is the error type returned by services for failures the client
+// can act on. Handlers turn it into the standard error envelope.
+type AppError struct {
+	Status  int                    `json:"-"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e AppError) Error() string {
+	return e.Message
+}
+
+// WithDetails returns a copy of the error carrying extra details.
+func (e AppError) WithDetails(details map[string]interface{}) AppError {
+	e.Details = details
+	return e
+}
+
+// Predefined errors
+var (
+	ErrNotFound = AppError{
+		Status:  http.StatusNotFound,
+		Code:    "NOT_FOUND",
+		Message: "Resource not found",
+	}
+
+	ErrUnauthorized = AppError{
+		Status:  http.StatusUnauthorized,
+		Code:    "UNAUTHORIZED",
+		Message: "Authentication required",
+	}
+
+	ErrForbidden = AppError{
+		Status:  http.StatusForbidden,
+		Code:    "FORBIDDEN",
+		Message: "You do not have permission to perform this action",
+	}
+
+	ErrValidation = AppError{
+		Status:  http.StatusBadRequest,
+		Code:    "VALIDATION_ERROR",
+		Message: "Invalid input data",
+	}
+
+	ErrConflict = AppError{
+		Status:  http.StatusConflict,
+		Code:    "CONFLICT",
+		Message: "Resource already exists",
+	}
+)
+
+// NotFound returns ErrNotFound with a message naming the missing thing.
+func NotFound(what string) AppError {
+	err := ErrNotFound
+	err.Message = fmt.Sprintf("%s not found", what)
+	return err
+}
+
+// InvalidInput builds a 400 error for input that fails business validation.
+func InvalidInput(format string, args ...interface{}) AppError {
+	return AppError{
+		Status:  http.StatusBadRequest,
+		Code:    "INVALID_INPUT",
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a domain event published after a state change.
+type Event struct {
+	ID           string                 `json:"id"`
+	Type         string                 `json:"type"`
+	Version      int                    `json:"version,omitempty"`
+	TenantID     string                 `json:"tenant_id"`
+	ActorID      string                 `json:"actor_id,omitempty"`
+	ResourceType string                 `json:"resource_type,omitempty"`
+	ResourceID   string                 `json:"resource_id,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+	OccurredAt   time.Time              `json:"occurred_at"`
+}
+
+// EventHandler reacts to a published event.
+type EventHandler func(ctx context.Context, e Event) error
+
+type eventSubscription struct {
+	pattern string
+	handler EventHandler
+}
+
+// EventBus dispatches domain events to in-process subscribers. Handlers run
+// synchronously in subscription order; a failing handler is logged and does
+// not stop the others.
+type EventBus struct {
+	mu      sync.RWMutex
+	subs    []eventSubscription
+	schemas *MessageSchemaRegistry
+	log     EventLog
+}
+
+// NewEventBus creates an empty event bus.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe registers a handler for events whose type matches pattern.
+// A pattern is either an exact type ("comment.created"), a prefix ending in
+// ".*" ("comment.*") or "*" for every event.
+func (b *EventBus) Subscribe(pattern string, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, eventSubscription{pattern: pattern, handler: handler})
+}
+
+// UseSchemas makes Publish stamp events with their schema version and log
+// events whose data does not match the registered schema.
+func (b *EventBus) UseSchemas(schemas *MessageSchemaRegistry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schemas = schemas
+}
+
+// Publish fills in the event ID, timestamp and schema version, appends
+// the event to the event log, if any, and delivers it.
+func (b *EventBus) Publish(ctx context.Context, e Event) {
+	if e.ID == "" {
+		e.ID = NewID()
+	}
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	subs := append([]eventSubscription(nil), b.subs...)
+	schemas, eventLog := b.schemas, b.log
+	b.mu.RUnlock()
+
+	if schemas != nil {
+		var problems []string
+		e.Version, problems = schemas.Validate(e.Type, e.Data)
+		for _, p := range problems {
+			log.Printf("event %s does not match its schema: %s", e.Type, p)
+		}
+	}
+	if eventLog != nil {
+		if _, err := eventLog.Append(ctx, e); err != nil {
+			log.Printf("event %s could not be logged: %v", e.Type, err)
+		}
+	}
+
+	for _, sub := range subs {
+		if !matchEventType(sub.pattern, e.Type) {
+			continue
+		}
+		if err := sub.handler(ctx, e); err != nil {
+			log.Printf("event handler for %q failed on %s: %v", sub.pattern, e.Type, err)
+		}
+	}
+}
+
+func matchEventType(pattern, eventType string) bool {
+	if pattern == "*" || pattern == eventType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(eventType, prefix)
+	}
+	return false
+}
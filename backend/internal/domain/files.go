@@ -0,0 +1,513 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// JobCollectBlobs runs the blob garbage collector.
+	JobCollectBlobs = "files.gc"
+
+	maxFileNameLength = 255
+	// blobGracePeriod protects blobs that were just written or
+	// unreferenced from the garbage collector, so uploads in progress and
+	// deletes racing a new upload of the same content keep their blob.
+	blobGracePeriod = time.Hour
+	blobSweepBatch  = 500
+)
+
+// File is an uploaded file. Its content is a blob addressed by the SHA-256
+// of its bytes, shared by every file with the same content. ScanStatus is
+// the malware scan state of that content; only clean files can be
+// downloaded.
+type File struct {
+	ID            string    `json:"id"`
+	TenantID      string    `json:"tenant_id"`
+	OwnerID       string    `json:"owner_id"`
+	Name          string    `json:"name"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	ScanStatus    string    `json:"scan_status"`
+	ScanSignature string    `json:"scan_signature,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Blob is the metadata of stored content. RefCount is the number of file
+// records pointing at it. Content is scanned once, shared by all its
+// files, and again whenever the scanner's signatures change.
+type Blob struct {
+	Hash           string     `json:"hash"`
+	Size           int64      `json:"size"`
+	RefCount       int        `json:"ref_count"`
+	ScanStatus     string     `json:"scan_status"`
+	ScanSignature  string     `json:"scan_signature,omitempty"`
+	ScannerVersion string     `json:"scanner_version"`
+	ScannedAt      *time.Time `json:"scanned_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// BlobInfo describes content in a BlobStore.
+type BlobInfo struct {
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ErrBlobNotFound is returned by a BlobStore for content it does not
+// hold.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps content addressed by its SHA-256 hash.
+type BlobStore interface {
+	// Put stores content and returns its hash and size. Content already
+	// present is kept once and its modification time refreshed.
+	Put(ctx context.Context, r io.Reader) (BlobInfo, error)
+	Open(ctx context.Context, hash string) (io.ReadSeekCloser, error)
+	Stat(ctx context.Context, hash string) (BlobInfo, error)
+	// Delete removes content unless it was written or refreshed after
+	// before, and reports whether it did.
+	Delete(ctx context.Context, hash string, before time.Time) (bool, error)
+	// Walk calls fn for all stored content.
+	Walk(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// FileRepository persists file records and blob reference counts.
+type FileRepository interface {
+	// Create inserts the file and takes a reference on its blob, creating
+	// the blob row with the file's scan status when needed. The file's
+	// status is then set to its blob's.
+	Create(ctx context.Context, f *File) error
+	Get(ctx context.Context, tenantID, id string) (*File, error)
+	List(ctx context.Context, tenantID, ownerID string, params ListParams) ([]File, int, error)
+	// Delete removes the file and drops its reference on the blob.
+	Delete(ctx context.Context, tenantID, id string, at time.Time) error
+
+	// Recount sets every blob's reference count to the number of files
+	// pointing at it and returns how many counts were wrong.
+	Recount(ctx context.Context, at time.Time) (int, error)
+	// Unreferenced returns blobs without references that last changed
+	// before the given time.
+	Unreferenced(ctx context.Context, before time.Time, limit int) ([]Blob, error)
+	// DeleteBlob removes a blob row if it is still unreferenced and
+	// unchanged since before.
+	DeleteBlob(ctx context.Context, hash string, before time.Time) (bool, error)
+	Blobs(ctx context.Context) (map[string]Blob, error)
+	// RefCountMismatches returns blobs whose count differs from the number
+	// of files using them.
+	RefCountMismatches(ctx context.Context) ([]BlobRefMismatch, error)
+
+	Blob(ctx context.Context, hash string) (*Blob, error)
+	// SetScanResult records a scan of a blob and returns its previous
+	// status.
+	SetScanResult(ctx context.Context, hash, status, signature, version string, at time.Time) (string, error)
+	// StaleScans returns referenced blobs not scanned with the given
+	// scanner version, least recently scanned first.
+	StaleScans(ctx context.Context, version string, limit int) ([]Blob, error)
+	// FilesByBlob returns the files of every tenant using a blob.
+	FilesByBlob(ctx context.Context, hash string) ([]File, error)
+	// Unscanned returns the tenant's files whose content is not clean.
+	Unscanned(ctx context.Context, tenantID string, params ListParams) ([]File, int, error)
+}
+
+// BlobRefMismatch is a blob whose stored reference count is wrong.
+type BlobRefMismatch struct {
+	Hash   string `json:"hash"`
+	Stored int    `json:"stored"`
+	Actual int    `json:"actual"`
+}
+
+// BlobGCReport summarizes a garbage collection run.
+type BlobGCReport struct {
+	Recounted      int   `json:"recounted"`
+	BlobsDeleted   int   `json:"blobs_deleted"`
+	OrphansDeleted int   `json:"orphans_deleted"`
+	BytesFreed     int64 `json:"bytes_freed"`
+}
+
+// BlobCheckReport lists the differences between file metadata and
+// storage. A deep check also rehashes stored content.
+type BlobCheckReport struct {
+	Blobs           int               `json:"blobs"`
+	Objects         int               `json:"objects"`
+	Missing         []string          `json:"missing"`
+	SizeMismatches  []string          `json:"size_mismatches"`
+	Corrupt         []string          `json:"corrupt"`
+	Orphans         []string          `json:"orphans"`
+	RefMismatches   []BlobRefMismatch `json:"ref_mismatches"`
+	Deep            bool              `json:"deep"`
+	ProblemsFound   int               `json:"problems_found"`
+	CheckedAt       time.Time         `json:"checked_at"`
+	DurationSeconds float64           `json:"duration_seconds"`
+}
+
+// FileService stores uploaded files with deduplicated, reference-counted
+// content and collects content no file uses anymore.
+type FileService struct {
+	repo    FileRepository
+	store   BlobStore
+	jobs    *JobQueue
+	maxSize int64
+
+	scanner       Scanner
+	notifications *NotificationService
+}
+
+// NewFileService creates a file service accepting files up to maxSize
+// bytes and registers its garbage collection job.
+func NewFileService(repo FileRepository, store BlobStore, jobs *JobQueue, maxSize int64) *FileService {
+	s := &FileService{repo: repo, store: store, jobs: jobs, maxSize: maxSize}
+	if jobs != nil {
+		jobs.Register(JobCollectBlobs, s.handleCollect)
+	}
+	return s
+}
+
+// MaxSize is the largest accepted file in bytes.
+func (s *FileService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Upload stores content as a new file owned by the caller. The content
+// is hashed while it is written; if the same bytes were stored before,
+// the new file shares them.
+func (s *FileService) Upload(ctx context.Context, actor Identity, name, contentType string, r io.Reader) (*File, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.store.Put(ctx, &limitedReader{r: r, left: s.maxSize})
+	if errors.Is(err, errFileTooLarge) {
+		return nil, InvalidInput("files can be at most %d bytes", s.maxSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("files: storing content: %w", err)
+	}
+	return s.Record(ctx, actor, name, contentType, info)
+}
+
+// Record creates a file for content already in the blob store, for
+// callers that wrote it themselves. New content is quarantined until the
+// scanner found it clean.
+func (s *FileService) Record(ctx context.Context, actor Identity, name, contentType string, info BlobInfo) (*File, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		ID:          NewID(),
+		TenantID:    actor.TenantID,
+		OwnerID:     actor.UserID,
+		Name:        name,
+		ContentType: s.contentType(ctx, name, contentType, info.Hash),
+		Size:        info.Size,
+		SHA256:      info.Hash,
+		ScanStatus:  ScanClean,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if s.scanner != nil {
+		f.ScanStatus = ScanPending
+	}
+	if err := s.repo.Create(ctx, f); err != nil {
+		return nil, err
+	}
+	if f.ScanStatus == ScanPending && s.jobs != nil {
+		// The rescan job picks the content up if this fails
+		if _, err := s.jobs.Enqueue(ctx, JobScanBlob, map[string]string{"hash": f.SHA256}); err != nil {
+			log.Printf("files: queueing scan of %s: %v", f.SHA256, err)
+		}
+	}
+	return f, nil
+}
+
+// List returns the caller's files.
+func (s *FileService) List(ctx context.Context, actor Identity, params ListParams) ([]File, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, actor.UserID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Get returns one of the caller's files. Admins may read any file of
+// their tenant.
+func (s *FileService) Get(ctx context.Context, actor Identity, id string) (*File, error) {
+	f, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if f.OwnerID != actor.UserID && !actor.IsAdmin() {
+		return nil, NotFound("File")
+	}
+	return f, nil
+}
+
+// Open returns a file with a reader of its content, unless the content
+// is quarantined.
+func (s *FileService) Open(ctx context.Context, actor Identity, id string) (*File, io.ReadSeekCloser, error) {
+	f, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch f.ScanStatus {
+	case ScanPending:
+		return nil, nil, ErrFilePendingScan
+	case ScanInfected:
+		return nil, nil, ErrFileQuarantined
+	}
+	content, err := s.store.Open(ctx, f.SHA256)
+	if errors.Is(err, ErrBlobNotFound) {
+		log.Printf("files: content %s of file %s is missing", f.SHA256, f.ID)
+		return nil, nil, NotFound("File content")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, content, nil
+}
+
+// Delete removes one of the caller's files. Its content stays until the
+// garbage collector finds no other file using it.
+func (s *FileService) Delete(ctx context.Context, actor Identity, id string) error {
+	if _, err := s.Get(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, actor.TenantID, id, time.Now().UTC())
+}
+
+// CollectGarbage is a mark-and-sweep pass over the blobs. Marking
+// recounts references from the file records, which also repairs counts
+// that drifted. Sweeping deletes blobs without references, then content
+// in storage that has no blob row, such as the remains of failed uploads.
+// Both spare anything changed within the grace period.
+func (s *FileService) CollectGarbage(ctx context.Context) (*BlobGCReport, error) {
+	now := time.Now().UTC()
+	cutoff := now.Add(-blobGracePeriod)
+	report := &BlobGCReport{}
+
+	recounted, err := s.repo.Recount(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("files: marking blobs: %w", err)
+	}
+	report.Recounted = recounted
+
+	for {
+		blobs, err := s.repo.Unreferenced(ctx, cutoff, blobSweepBatch)
+		if err != nil {
+			return nil, err
+		}
+		deleted := 0
+		for _, b := range blobs {
+			ok, err := s.repo.DeleteBlob(ctx, b.Hash, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			deleted++
+			report.BlobsDeleted++
+			if removed, err := s.store.Delete(ctx, b.Hash, cutoff); err != nil {
+				log.Printf("files: deleting content %s failed: %v", b.Hash, err)
+			} else if removed {
+				report.BytesFreed += b.Size
+			}
+		}
+		if len(blobs) < blobSweepBatch || deleted == 0 {
+			break
+		}
+	}
+
+	known, err := s.repo.Blobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.Walk(ctx, func(info BlobInfo) error {
+		if _, ok := known[info.Hash]; ok || !info.ModTime.Before(cutoff) {
+			return nil
+		}
+		removed, err := s.store.Delete(ctx, info.Hash, cutoff)
+		if err != nil {
+			return err
+		}
+		if removed {
+			report.OrphansDeleted++
+			report.BytesFreed += info.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("files: sweeping storage: %w", err)
+	}
+	return report, nil
+}
+
+// Check compares the blob metadata with storage: content that is missing,
+// has the wrong size or (with deep) the wrong hash, stored content
+// without metadata, and wrong reference counts.
+func (s *FileService) Check(ctx context.Context, deep bool) (*BlobCheckReport, error) {
+	started := time.Now()
+	report := &BlobCheckReport{
+		Missing:        []string{},
+		SizeMismatches: []string{},
+		Corrupt:        []string{},
+		Orphans:        []string{},
+		Deep:           deep,
+		CheckedAt:      started.UTC(),
+	}
+	blobs, err := s.repo.Blobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Blobs = len(blobs)
+
+	seen := make(map[string]bool, len(blobs))
+	err = s.store.Walk(ctx, func(info BlobInfo) error {
+		report.Objects++
+		b, ok := blobs[info.Hash]
+		if !ok {
+			report.Orphans = append(report.Orphans, info.Hash)
+			return nil
+		}
+		seen[info.Hash] = true
+		if info.Size != b.Size {
+			report.SizeMismatches = append(report.SizeMismatches, info.Hash)
+			return nil
+		}
+		if deep {
+			ok, err := s.verify(ctx, info.Hash)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				report.Corrupt = append(report.Corrupt, info.Hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range sortedKeys(blobs) {
+		if !seen[hash] {
+			report.Missing = append(report.Missing, hash)
+		}
+	}
+	if report.RefMismatches, err = s.repo.RefCountMismatches(ctx); err != nil {
+		return nil, err
+	}
+	report.ProblemsFound = len(report.Missing) + len(report.SizeMismatches) + len(report.Corrupt) +
+		len(report.Orphans) + len(report.RefMismatches)
+	report.DurationSeconds = time.Since(started).Seconds()
+	return report, nil
+}
+
+// verify rehashes stored content.
+func (s *FileService) verify(ctx context.Context, hash string) (bool, error) {
+	r, err := s.store.Open(ctx, hash)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	sum, err := hashContent(r)
+	if err != nil {
+		return false, err
+	}
+	return sum == hash, nil
+}
+
+// hashContent returns the hex SHA-256 of everything r yields.
+func hashContent(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *FileService) handleCollect(ctx context.Context, job Job) error {
+	report, err := s.CollectGarbage(ctx)
+	if err != nil {
+		return err
+	}
+	if report.Recounted+report.BlobsDeleted+report.OrphansDeleted > 0 {
+		log.Printf("files: garbage collection fixed %d reference counts, deleted %d blobs and %d orphans, freed %d bytes",
+			report.Recounted, report.BlobsDeleted, report.OrphansDeleted, report.BytesFreed)
+	}
+	return nil
+}
+
+// contentType keeps a specific type the client sent, and otherwise goes
+// by the file extension or sniffs the content. Form encodings are what
+// HTTP clients send for any raw body, so they say nothing about it.
+func (s *FileService) contentType(ctx context.Context, name, declared, hash string) string {
+	t, _, err := mime.ParseMediaType(declared)
+	switch {
+	case err != nil, t == "application/octet-stream", t == "application/x-www-form-urlencoded", strings.HasPrefix(t, "multipart/"):
+	default:
+		return declared
+	}
+	if t := mime.TypeByExtension(strings.ToLower(path.Ext(name))); t != "" {
+		return t
+	}
+	r, err := s.store.Open(ctx, hash)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer r.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	return http.DetectContentType(head[:n])
+}
+
+func cleanFileName(name string) (string, error) {
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, `\`, "/")))
+	if name == "" || name == "." || name == "/" {
+		return "", InvalidInput("a file name is required")
+	}
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		return "", InvalidInput("file names can be at most %d characters", maxFileNameLength)
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return "", InvalidInput("file names cannot contain control characters")
+	}
+	return name, nil
+}
+
+var errFileTooLarge = errors.New("file too large")
+
+// limitedReader fails once more than left bytes were read, unlike
+// io.LimitReader which silently truncates.
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, errFileTooLarge
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
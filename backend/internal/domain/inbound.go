@@ -0,0 +1,357 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// InboundEmail is a received e-mail, parsed. Reply is Text without the
+// quoted history and signature.
+type InboundEmail struct {
+	MessageID   string
+	From        string
+	Recipients  []string
+	Subject     string
+	Text        string
+	Reply       string
+	Attachments []InboundAttachment
+	// Automated is set for bounces, auto-replies and list mail, which are
+	// never acted on
+	Automated bool
+}
+
+// InboundAttachment is a file attached to a received e-mail.
+type InboundAttachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+// ReplyAboveMarker is the line notification e-mails start with. Reply
+// extraction drops it and everything below.
+const ReplyAboveMarker = "-- Reply above this line to comment --"
+
+// Inbound address kinds.
+const (
+	InboundReply = "reply"
+	InboundFiles = "files"
+)
+
+// InboundAddress is what a signed inbound address stands for: a reply by
+// a user to the discussion of a resource, or files sent in by a user.
+type InboundAddress struct {
+	Kind         string `json:"kind"`
+	TenantID     string `json:"tenant_id"`
+	UserID       string `json:"user_id"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+}
+
+var addressEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// InboundAddresses signs and verifies the addresses of the inbound mail
+// domain. Addresses carry what they stand for, so receiving mail needs
+// no lookup; the signature keeps them from being guessed or altered.
+type InboundAddresses struct {
+	key    []byte
+	domain string
+}
+
+// NewInboundAddresses creates addresses at domain signed with a key
+// derived from secret.
+func NewInboundAddresses(secret, domain string) *InboundAddresses {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("inbound-mail"))
+	return &InboundAddresses{key: mac.Sum(nil), domain: strings.ToLower(domain)}
+}
+
+// Domain returns the inbound mail domain.
+func (a *InboundAddresses) Domain() string { return a.domain }
+
+// Reply returns the address at which userID's replies become comments on
+// the resource.
+func (a *InboundAddresses) Reply(tenantID, userID, resourceType, resourceID string) string {
+	return a.address(InboundReply, tenantID, userID, resourceType, resourceID)
+}
+
+// Files returns the address at which attachments sent by userID are
+// stored as their files.
+func (a *InboundAddresses) Files(tenantID, userID string) string {
+	return a.address(InboundFiles, tenantID, userID)
+}
+
+func (a *InboundAddresses) address(kind string, fields ...string) string {
+	payload := strings.ToLower(addressEncoding.EncodeToString([]byte(strings.Join(fields, "\x00"))))
+	return kind + "-" + payload + "-" + a.sign(kind, payload) + "@" + a.domain
+}
+
+func (a *InboundAddresses) sign(kind, payload string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + "-" + payload))
+	return strings.ToLower(addressEncoding.EncodeToString(mac.Sum(nil)[:10]))
+}
+
+var errUnknownAddress = errors.New("not an inbound address")
+
+// Parse verifies a recipient address, with or without a display name.
+// Mail servers may change the case of addresses, which the encoding
+// tolerates.
+func (a *InboundAddresses) Parse(addr string) (InboundAddress, error) {
+	if parsed, err := mail.ParseAddress(addr); err == nil {
+		addr = parsed.Address
+	}
+	local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(addr)), "@")
+	if !ok || domain != a.domain {
+		return InboundAddress{}, errUnknownAddress
+	}
+	parts := strings.Split(local, "-")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(a.sign(parts[0], parts[1]))) {
+		return InboundAddress{}, errUnknownAddress
+	}
+	raw, err := addressEncoding.DecodeString(strings.ToUpper(parts[1]))
+	if err != nil {
+		return InboundAddress{}, errUnknownAddress
+	}
+	fields := strings.Split(string(raw), "\x00")
+	switch {
+	case parts[0] == InboundReply && len(fields) == 4:
+		return InboundAddress{Kind: InboundReply, TenantID: fields[0], UserID: fields[1], ResourceType: fields[2], ResourceID: fields[3]}, nil
+	case parts[0] == InboundFiles && len(fields) == 2:
+		return InboundAddress{Kind: InboundFiles, TenantID: fields[0], UserID: fields[1]}, nil
+	}
+	return InboundAddress{}, errUnknownAddress
+}
+
+// Inbound message states.
+const (
+	InboundProcessing = "processing"
+	InboundProcessed  = "processed"
+	InboundRejected   = "rejected"
+)
+
+// InboundMessage records what became of a received e-mail sent to one
+// inbound address.
+type InboundMessage struct {
+	ID           string    `json:"id"`
+	TenantID     string    `json:"tenant_id"`
+	UserID       string    `json:"user_id"`
+	MessageID    string    `json:"message_id"`
+	Address      string    `json:"address"`
+	Kind         string    `json:"kind"`
+	ResourceType string    `json:"resource_type,omitempty"`
+	ResourceID   string    `json:"resource_id,omitempty"`
+	Sender       string    `json:"sender"`
+	Subject      string    `json:"subject"`
+	Status       string    `json:"status" doc:"processing, processed or rejected"`
+	Reason       string    `json:"reason,omitempty"`
+	CommentID    string    `json:"comment_id,omitempty"`
+	FileIDs      []string  `json:"file_ids"`
+	ReceivedAt   time.Time `json:"received_at"`
+}
+
+// InboundMailRepository records received messages. Create returns the
+// message stored for m's message ID and address: m itself, or the one
+// recorded when the message was received there before.
+type InboundMailRepository interface {
+	Create(ctx context.Context, m *InboundMessage) (*InboundMessage, error)
+	Finish(ctx context.Context, m *InboundMessage) error
+	List(ctx context.Context, tenantID string, params ListParams) ([]InboundMessage, int, error)
+}
+
+// InboundAddressesView lists the caller's inbound addresses.
+type InboundAddressesView struct {
+	Files string `json:"files"`
+	Reply string `json:"reply,omitempty"`
+}
+
+// InboundMailService turns received e-mail into comments and files.
+// Mail is only acted on when it is sent to a valid signed address from
+// the e-mail address of the user the address belongs to; checking that
+// the sender is genuine (SPF, DKIM) is left to the MX or relay.
+type InboundMailService struct {
+	repo      InboundMailRepository
+	addresses *InboundAddresses
+	users     *UserService
+	comments  *CommentService
+	files     *FileService
+}
+
+// NewInboundMailService creates the inbound mail service.
+func NewInboundMailService(repo InboundMailRepository, addresses *InboundAddresses, users *UserService, comments *CommentService, files *FileService) *InboundMailService {
+	return &InboundMailService{repo: repo, addresses: addresses, users: users, comments: comments, files: files}
+}
+
+// Accepts reports whether mail to recipient would be acted on, so the
+// SMTP listener can refuse other recipients.
+func (s *InboundMailService) Accepts(recipient string) bool {
+	_, err := s.addresses.Parse(recipient)
+	return err == nil
+}
+
+// Addresses returns the caller's file address and, when a resource is
+// given, the address for replying to its discussion.
+func (s *InboundMailService) Addresses(actor Identity, resourceType, resourceID string) (*InboundAddressesView, error) {
+	view := &InboundAddressesView{Files: s.addresses.Files(actor.TenantID, actor.UserID)}
+	if resourceType != "" || resourceID != "" {
+		if err := ValidateResourceRef(resourceType, resourceID); err != nil {
+			return nil, err
+		}
+		view.Reply = s.addresses.Reply(actor.TenantID, actor.UserID, resourceType, resourceID)
+	}
+	return view, nil
+}
+
+// Receive acts on msg for each of its recipients that is an inbound
+// address, and returns what became of it. Mail that cannot be acted on is
+// recorded as rejected; an error means the message should be retried.
+// A message already received at an address is skipped, unless an earlier
+// delivery failed before it was processed.
+func (s *InboundMailService) Receive(ctx context.Context, msg *InboundEmail) ([]InboundMessage, error) {
+	results := []InboundMessage{}
+	seen := make(map[string]bool)
+	for _, rcpt := range msg.Recipients {
+		addr, err := s.addresses.Parse(rcpt)
+		if err != nil {
+			continue
+		}
+		key := strings.ToLower(rcpt)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		m := &InboundMessage{
+			ID:           NewID(),
+			TenantID:     addr.TenantID,
+			UserID:       addr.UserID,
+			MessageID:    msg.MessageID,
+			Address:      key,
+			Kind:         addr.Kind,
+			ResourceType: addr.ResourceType,
+			ResourceID:   addr.ResourceID,
+			Sender:       msg.From,
+			Subject:      truncateRunes(msg.Subject, 200),
+			Status:       InboundProcessing,
+			FileIDs:      []string{},
+			ReceivedAt:   time.Now().UTC(),
+		}
+		if m.MessageID == "" {
+			m.MessageID = m.ID
+		}
+		tctx := WithTenant(ctx, addr.TenantID)
+		stored, err := s.repo.Create(tctx, m)
+		if err != nil {
+			return results, err
+		}
+		if stored.ID != m.ID {
+			if stored.Status != InboundProcessing {
+				continue
+			}
+			// Keep the comment an earlier attempt posted, so the retry
+			// does not post it twice.
+			m.ID, m.ReceivedAt, m.CommentID = stored.ID, stored.ReceivedAt, stored.CommentID
+		}
+		if err := s.process(tctx, addr, msg, m); err != nil {
+			return results, err
+		}
+		if err := s.repo.Finish(tctx, m); err != nil {
+			return results, err
+		}
+		results = append(results, *m)
+	}
+	return results, nil
+}
+
+// process acts on msg for one address, recording the outcome in m.
+func (s *InboundMailService) process(ctx context.Context, addr InboundAddress, msg *InboundEmail, m *InboundMessage) error {
+	reject := func(reason string) error {
+		m.Status, m.Reason = InboundRejected, reason
+		log.Printf("inbound mail %s to %s rejected: %s", m.MessageID, m.Address, reason)
+		return nil
+	}
+	if msg.Automated {
+		return reject("automated message")
+	}
+	user, err := s.users.Get(ctx, addr.TenantID, addr.UserID)
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		return reject("unknown user")
+	}
+	if err != nil {
+		return err
+	}
+	if user.Email == "" || !strings.EqualFold(user.Email, msg.From) {
+		return reject("sender does not match the address's user")
+	}
+	actor := Identity{UserID: user.ID, TenantID: user.TenantID, Username: user.Username, Email: user.Email}
+
+	switch addr.Kind {
+	case InboundReply:
+		if strings.TrimSpace(msg.Reply) == "" && len(msg.Attachments) == 0 {
+			return reject("empty reply")
+		}
+		if m.CommentID == "" && strings.TrimSpace(msg.Reply) != "" {
+			c, err := s.comments.Create(ctx, actor, CreateCommentInput{
+				ResourceType: addr.ResourceType, ResourceID: addr.ResourceID, Body: msg.Reply,
+			})
+			if errors.As(err, &appErr) {
+				return reject(appErr.Message)
+			}
+			if err != nil {
+				return err
+			}
+			m.CommentID = c.ID
+			if err := s.repo.Finish(ctx, m); err != nil {
+				return err
+			}
+		}
+	case InboundFiles:
+		if len(msg.Attachments) == 0 {
+			return reject("no attachments")
+		}
+	}
+
+	var skipped []string
+	for _, a := range msg.Attachments {
+		f, err := s.files.Upload(ctx, actor, a.Name, a.ContentType, bytes.NewReader(a.Content))
+		if errors.As(err, &appErr) {
+			skipped = append(skipped, fmt.Sprintf("%s: %s", a.Name, appErr.Message))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.FileIDs = append(m.FileIDs, f.ID)
+	}
+	if len(skipped) > 0 {
+		m.Reason = "skipped " + strings.Join(skipped, "; ")
+	}
+	if m.CommentID == "" && len(m.FileIDs) == 0 {
+		return reject(m.Reason)
+	}
+	m.Status = InboundProcessed
+	return nil
+}
+
+// List returns the messages received for the caller's tenant, newest
+// first.
+func (s *InboundMailService) List(ctx context.Context, actor Identity, params ListParams) ([]InboundMessage, PaginationMeta, error) {
+	if !actor.IsAdmin() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
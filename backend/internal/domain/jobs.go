@@ -0,0 +1,354 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle state of a background job.
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobDead      JobStatus = "dead"
+)
+
+const (
+	// DefaultQueue receives jobs enqueued without an explicit queue.
+	DefaultQueue = "default"
+
+	defaultMaxAttempts = 5
+	jobLease           = 5 * time.Minute
+	maxRetryDelay      = time.Hour
+)
+
+// Job is a unit of background work persisted in the job queue.
+type Job struct {
+	ID          string          `json:"id"`
+	TenantID    string          `json:"tenant_id,omitempty"`
+	Queue       string          `json:"queue"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      JobStatus       `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// JobRepository persists jobs. ClaimNext must atomically hand a runnable
+// job to a single worker; a claimed job whose lease expires (for example
+// because its worker crashed) becomes claimable again.
+type JobRepository interface {
+	Enqueue(ctx context.Context, j *Job) error
+	ClaimNext(ctx context.Context, queues []string, now time.Time, lease time.Duration) (*Job, error)
+	Complete(ctx context.Context, id string, at time.Time) error
+	Fail(ctx context.Context, id string, attempt int, message string, retryAt *time.Time, at time.Time) error
+}
+
+// JobHandler performs the work of a job kind.
+type JobHandler func(ctx context.Context, job Job) error
+
+// EnqueueOption customizes an enqueued job.
+type EnqueueOption func(*Job)
+
+// OnQueue places the job on a named queue.
+func OnQueue(queue string) EnqueueOption {
+	return func(j *Job) { j.Queue = queue }
+}
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) EnqueueOption {
+	return func(j *Job) { j.RunAt = t.UTC() }
+}
+
+// MaxAttempts sets how often the job is tried before it is dead-lettered.
+func MaxAttempts(n int) EnqueueOption {
+	return func(j *Job) { j.MaxAttempts = n }
+}
+
+// JobQueue runs persisted background jobs with retries. Failed jobs are
+// retried with exponential backoff and moved to the dead state once they
+// exhausted their attempts.
+type JobQueue struct {
+	repo         JobRepository
+	workers      int
+	pollInterval time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	queues   map[string]bool
+}
+
+// NewJobQueue creates a job queue processed by the given number of workers.
+func NewJobQueue(repo JobRepository, workers int) *JobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &JobQueue{
+		repo:         repo,
+		workers:      workers,
+		pollInterval: time.Second,
+		handlers:     make(map[string]JobHandler),
+		queues:       map[string]bool{DefaultQueue: true},
+	}
+}
+
+// Register sets the handler for a job kind.
+func (q *JobQueue) Register(kind string, handler JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Enqueue persists a job of the given kind with a JSON-encoded payload.
+// The job runs for the tenant of ctx, if any; see WithTenant.
+func (q *JobQueue) Enqueue(ctx context.Context, kind string, payload interface{}, opts ...EnqueueOption) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s payload: %w", kind, err)
+	}
+	now := time.Now().UTC()
+	j := &Job{
+		ID:          NewID(),
+		TenantID:    TenantFromContext(ctx),
+		Queue:       DefaultQueue,
+		Kind:        kind,
+		Payload:     raw,
+		Status:      JobPending,
+		MaxAttempts: defaultMaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	q.mu.Lock()
+	q.queues[j.Queue] = true
+	q.mu.Unlock()
+
+	if err := q.repo.Enqueue(ctx, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Queues returns the names of the queues the workers process.
+func (q *JobQueue) Queues() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	names := make([]string, 0, len(q.queues))
+	for name := range q.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddQueue makes the workers process an additional queue.
+func (q *JobQueue) AddQueue(name string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queues[name] = true
+}
+
+// Run processes jobs until ctx is cancelled.
+func (q *JobQueue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *JobQueue) work(ctx context.Context) {
+	for {
+		processed, err := q.processNext(ctx)
+		if err != nil {
+			log.Printf("job queue: %v", err)
+		}
+		if processed {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// processNext claims and runs one job. It reports whether a job was found.
+func (q *JobQueue) processNext(ctx context.Context) (bool, error) {
+	job, err := q.repo.ClaimNext(ctx, q.Queues(), time.Now().UTC(), jobLease)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+
+	var runErr error
+	if !ok {
+		runErr = fmt.Errorf("no handler registered for job kind %q", job.Kind)
+	} else {
+		runErr = runJob(WithTenant(ctx, job.TenantID), handler, *job)
+	}
+
+	now := time.Now().UTC()
+	if runErr == nil {
+		return true, q.repo.Complete(ctx, job.ID, now)
+	}
+
+	var retryAt *time.Time
+	if job.Attempts < job.MaxAttempts {
+		t := now.Add(retryDelay(job.Attempts))
+		retryAt = &t
+	}
+	log.Printf("job %s (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, runErr)
+	return true, q.repo.Fail(ctx, job.ID, job.Attempts, runErr.Error(), retryAt, now)
+}
+
+// runJob calls the handler, turning a panic into an error.
+func runJob(ctx context.Context, handler JobHandler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// retryDelay is 10s, 20s, 40s, ... capped at an hour.
+func retryDelay(attempt int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryDelay)
+}
+
+// ScheduledTask enqueues a job of Kind every Interval.
+type ScheduledTask struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"`
+	Interval time.Duration `json:"interval"`
+	Queue    string        `json:"queue,omitempty"`
+}
+
+// Scheduler enqueues jobs for periodic tasks.
+type Scheduler struct {
+	queue   *JobQueue
+	tenants func(ctx context.Context) ([]string, error)
+
+	mu    sync.Mutex
+	tasks []ScheduledTask
+}
+
+// NewScheduler creates a scheduler feeding the job queue.
+func NewScheduler(queue *JobQueue) *Scheduler {
+	return &Scheduler{queue: queue}
+}
+
+// UseTenants makes every task also run once for each tenant that tenants
+// returns: those with a database of their own, which the run for the main
+// database does not reach.
+func (s *Scheduler) UseTenants(tenants func(ctx context.Context) ([]string, error)) {
+	s.tenants = tenants
+}
+
+// Add registers a periodic task.
+func (s *Scheduler) Add(task ScheduledTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if task.Queue != "" {
+		s.queue.AddQueue(task.Queue)
+	}
+	s.tasks = append(s.tasks, task)
+}
+
+// Tasks returns the registered tasks.
+func (s *Scheduler) Tasks() []ScheduledTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ScheduledTask(nil), s.tasks...)
+}
+
+// Trigger enqueues the named task immediately. The job for the main
+// database is returned.
+func (s *Scheduler) Trigger(ctx context.Context, name string) (*Job, error) {
+	for _, task := range s.Tasks() {
+		if task.Name == name {
+			return s.enqueue(ctx, task)
+		}
+	}
+	return nil, NotFound("Scheduled task " + name)
+}
+
+// Run enqueues every task once per interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	last := make(map[string]time.Time)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, task := range s.Tasks() {
+				if now.Sub(last[task.Name]) < task.Interval {
+					continue
+				}
+				last[task.Name] = now
+				if _, err := s.enqueue(ctx, task); err != nil {
+					log.Printf("scheduler: enqueue %s: %v", task.Name, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *Scheduler) enqueue(ctx context.Context, task ScheduledTask) (*Job, error) {
+	var opts []EnqueueOption
+	if task.Queue != "" {
+		opts = append(opts, OnQueue(task.Queue))
+	}
+	// Periodic tasks run again on their next tick, so a single attempt is
+	// enough.
+	opts = append(opts, MaxAttempts(1))
+	payload := map[string]string{"task": task.Name}
+	job, err := s.queue.Enqueue(ctx, task.Kind, payload, opts...)
+	if err != nil || s.tenants == nil {
+		return job, err
+	}
+
+	tenants, err := s.tenants(ctx)
+	if err != nil {
+		log.Printf("scheduler: listing tenants for %s: %v", task.Name, err)
+		return job, nil
+	}
+	for _, tenantID := range tenants {
+		if _, err := s.queue.Enqueue(WithTenant(ctx, tenantID), task.Kind, payload, opts...); err != nil {
+			log.Printf("scheduler: enqueue %s for tenant %s: %v", task.Name, tenantID, err)
+		}
+	}
+	return job, nil
+}
@@ -0,0 +1,278 @@
+package domain
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// throughputWindow is the period the queue statistics count finished jobs
+// and failed attempts over.
+const throughputWindow = time.Hour
+
+// QueueStats summarizes a job queue for operators.
+type QueueStats struct {
+	Name     string     `json:"name"`
+	Paused   bool       `json:"paused"`
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	PausedBy string     `json:"paused_by,omitempty"`
+	// Pending counts jobs waiting to run, Runnable those already due.
+	Pending  int `json:"pending"`
+	Runnable int `json:"runnable"`
+	Running  int `json:"running"`
+	Dead     int `json:"dead"`
+	// Latency is how long the oldest due job has been waiting, in seconds.
+	Latency float64 `json:"latency_seconds"`
+	// Succeeded and Failed count jobs finished and attempts failed within
+	// the throughput window.
+	Succeeded int `json:"succeeded_last_hour"`
+	Failed    int `json:"failed_last_hour"`
+}
+
+// QueueCounts are the raw numbers a repository reports for a queue.
+type QueueCounts struct {
+	Pending     int
+	Runnable    int
+	Running     int
+	Dead        int
+	Succeeded   int
+	Failed      int
+	OldestDueAt *time.Time
+	PausedAt    *time.Time
+	PausedBy    string
+}
+
+// JobAttempt records a failed attempt of a job.
+type JobAttempt struct {
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JobDetail is a job with its error history.
+type JobDetail struct {
+	Job
+	Attempts []JobAttempt `json:"attempt_history"`
+}
+
+// JobFilter narrows job listings.
+type JobFilter struct {
+	Queue  string    `form:"queue"`
+	Kind   string    `form:"kind"`
+	Status JobStatus `form:"status"`
+}
+
+// JobAdminRepository gives operators access to the stored jobs.
+type JobAdminRepository interface {
+	QueueCounts(ctx context.Context, now, since time.Time) (map[string]QueueCounts, error)
+	ListJobs(ctx context.Context, f JobFilter, params ListParams) ([]Job, int, error)
+	GetJob(ctx context.Context, id string) (*Job, error)
+	JobAttempts(ctx context.Context, id string) ([]JobAttempt, error)
+	// RetryDead makes dead jobs pending again with a fresh set of attempts.
+	// An empty id retries every dead job of the queue. It returns how many
+	// jobs were revived.
+	RetryDead(ctx context.Context, queue, id string, now time.Time) (int, error)
+	DeleteDead(ctx context.Context, id string) (int, error)
+	SetPaused(ctx context.Context, queue string, pausedAt *time.Time, by string) error
+}
+
+// ErrJobNotDead is returned when retrying or deleting a job that is not
+// dead-lettered.
+var ErrJobNotDead = AppError{
+	Status:  http.StatusConflict,
+	Code:    "JOB_NOT_DEAD",
+	Message: "Only dead jobs can be retried or deleted",
+}
+
+// JobAdminService backs the job queue administration API. Queues and jobs
+// are shared by every tenant, so it is limited to operators; it records
+// every change in the audit log.
+type JobAdminService struct {
+	repo      JobAdminRepository
+	queue     *JobQueue
+	scheduler *Scheduler
+	audit     *AuditService
+}
+
+// NewJobAdminService creates the job administration service.
+func NewJobAdminService(repo JobAdminRepository, queue *JobQueue, scheduler *Scheduler, audit *AuditService) *JobAdminService {
+	return &JobAdminService{repo: repo, queue: queue, scheduler: scheduler, audit: audit}
+}
+
+// Queues returns statistics for every known queue, including queues that
+// only exist in storage.
+func (s *JobAdminService) Queues(ctx context.Context, actor Identity) ([]QueueStats, error) {
+	if !actor.IsOperator() {
+		return nil, ErrForbidden
+	}
+	now := time.Now().UTC()
+	counts, err := s.repo.QueueCounts(ctx, now, now.Add(-throughputWindow))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range s.queue.Queues() {
+		if _, ok := counts[name]; !ok {
+			counts[name] = QueueCounts{}
+		}
+	}
+	stats := make([]QueueStats, 0, len(counts))
+	for name, c := range counts {
+		st := QueueStats{
+			Name:      name,
+			Paused:    c.PausedAt != nil,
+			PausedAt:  c.PausedAt,
+			PausedBy:  c.PausedBy,
+			Pending:   c.Pending,
+			Runnable:  c.Runnable,
+			Running:   c.Running,
+			Dead:      c.Dead,
+			Succeeded: c.Succeeded,
+			Failed:    c.Failed,
+		}
+		if c.OldestDueAt != nil {
+			st.Latency = max(now.Sub(*c.OldestDueAt).Seconds(), 0)
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats, nil
+}
+
+// Jobs lists jobs, newest first.
+func (s *JobAdminService) Jobs(ctx context.Context, actor Identity, f JobFilter, params ListParams) ([]Job, PaginationMeta, error) {
+	if !actor.IsOperator() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.ListJobs(ctx, f, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Job returns a job with its payload and failed attempts.
+func (s *JobAdminService) Job(ctx context.Context, actor Identity, id string) (*JobDetail, error) {
+	if !actor.IsOperator() {
+		return nil, ErrForbidden
+	}
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := s.repo.JobAttempts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &JobDetail{Job: *job, Attempts: attempts}, nil
+}
+
+// Retry revives a dead job.
+func (s *JobAdminService) Retry(ctx context.Context, actor Identity, id string) (*JobDetail, error) {
+	if !actor.IsOperator() {
+		return nil, ErrForbidden
+	}
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.repo.RetryDead(ctx, job.Queue, id, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrJobNotDead
+	}
+	s.record(ctx, actor, "jobs.retry", "job", id, map[string]interface{}{"kind": job.Kind, "queue": job.Queue})
+	return s.Job(ctx, actor, id)
+}
+
+// RetryQueue revives every dead job of a queue and returns their number.
+func (s *JobAdminService) RetryQueue(ctx context.Context, actor Identity, queue string) (int, error) {
+	if !actor.IsOperator() {
+		return 0, ErrForbidden
+	}
+	n, err := s.repo.RetryDead(ctx, queue, "", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		s.record(ctx, actor, "jobs.retry_queue", "job_queue", queue, map[string]interface{}{"jobs": n})
+	}
+	return n, nil
+}
+
+// Delete removes a dead job and its history.
+func (s *JobAdminService) Delete(ctx context.Context, actor Identity, id string) error {
+	if !actor.IsOperator() {
+		return ErrForbidden
+	}
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	n, err := s.repo.DeleteDead(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotDead
+	}
+	s.record(ctx, actor, "jobs.delete", "job", id, map[string]interface{}{"kind": job.Kind, "queue": job.Queue, "last_error": job.LastError})
+	return nil
+}
+
+// Pause stops workers from claiming jobs of a queue. Jobs already running
+// finish; new jobs are still accepted and wait until the queue resumes.
+func (s *JobAdminService) Pause(ctx context.Context, actor Identity, queue string) error {
+	if !actor.IsOperator() {
+		return ErrForbidden
+	}
+	now := time.Now().UTC()
+	if err := s.repo.SetPaused(ctx, queue, &now, actor.UserID); err != nil {
+		return err
+	}
+	s.record(ctx, actor, "jobs.pause", "job_queue", queue, nil)
+	return nil
+}
+
+// Resume lets workers claim jobs of a paused queue again.
+func (s *JobAdminService) Resume(ctx context.Context, actor Identity, queue string) error {
+	if !actor.IsOperator() {
+		return ErrForbidden
+	}
+	if err := s.repo.SetPaused(ctx, queue, nil, ""); err != nil {
+		return err
+	}
+	s.record(ctx, actor, "jobs.resume", "job_queue", queue, nil)
+	return nil
+}
+
+// ScheduledTasks returns the periodic tasks.
+func (s *JobAdminService) ScheduledTasks(actor Identity) ([]ScheduledTask, error) {
+	if !actor.IsOperator() {
+		return nil, ErrForbidden
+	}
+	return s.scheduler.Tasks(), nil
+}
+
+// Trigger enqueues a scheduled task outside its interval.
+func (s *JobAdminService) Trigger(ctx context.Context, actor Identity, name string) (*Job, error) {
+	if !actor.IsOperator() {
+		return nil, ErrForbidden
+	}
+	job, err := s.scheduler.Trigger(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	s.record(ctx, actor, "jobs.trigger", "scheduled_task", name, map[string]interface{}{"job_id": job.ID})
+	return job, nil
+}
+
+func (s *JobAdminService) record(ctx context.Context, actor Identity, action, resourceType, resourceID string, changes map[string]interface{}) {
+	if err := s.audit.Record(ctx, actor, action, resourceType, resourceID, changes); err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
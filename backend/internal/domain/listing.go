@@ -0,0 +1,333 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilterOp is a comparison supported by list filters.
+type FilterOp string
+
+const (
+	OpEq       FilterOp = "eq"
+	OpNe       FilterOp = "ne"
+	OpLt       FilterOp = "lt"
+	OpLte      FilterOp = "lte"
+	OpGt       FilterOp = "gt"
+	OpGte      FilterOp = "gte"
+	OpIn       FilterOp = "in"
+	OpContains FilterOp = "contains"
+)
+
+var filterOps = []FilterOp{OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpIn, OpContains}
+
+// FieldType is the value type of a listable field.
+type FieldType string
+
+const (
+	FieldString FieldType = "string"
+	FieldInt    FieldType = "int"
+	FieldBool   FieldType = "bool"
+	FieldTime   FieldType = "time"
+)
+
+// ListField describes a field a list source exposes.
+type ListField struct {
+	Name       string    `json:"name"`
+	Type       FieldType `json:"type"`
+	Sortable   bool      `json:"sortable"`
+	Filterable bool      `json:"filterable"`
+	Default    bool      `json:"default"`
+
+	// Column is the storage column backing the field.
+	Column string `json:"-"`
+}
+
+// Filter restricts a list to items whose field compares to Value.
+type Filter struct {
+	Field string      `json:"field"`
+	Op    FilterOp    `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// SortField orders a list by a field.
+type SortField struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc,omitempty"`
+}
+
+// ListQuery is what a list view shows: which items (filters), in which
+// order (sort) and which fields (columns). Pagination is kept apart so a
+// query can be saved and executed page by page.
+type ListQuery struct {
+	Filters []Filter    `json:"filters,omitempty"`
+	Sort    []SortField `json:"sort,omitempty"`
+	Columns []string    `json:"columns,omitempty"`
+}
+
+// ListSource is a collection that can be queried with the listing toolkit.
+type ListSource interface {
+	Name() string
+	Fields() []ListField
+	// Query returns the matching rows restricted to the columns of q, which
+	// the listing service has already validated and filled in.
+	Query(ctx context.Context, actor Identity, q ListQuery, params ListParams) ([]map[string]interface{}, int, error)
+}
+
+// ListSourceSchema describes a registered source to clients.
+type ListSourceSchema struct {
+	Name   string      `json:"name"`
+	Fields []ListField `json:"fields"`
+}
+
+// ListingService validates list queries and runs them against the
+// registered sources.
+type ListingService struct {
+	mu      sync.RWMutex
+	sources map[string]ListSource
+}
+
+// NewListingService creates a listing service with the given sources.
+func NewListingService(sources ...ListSource) *ListingService {
+	s := &ListingService{sources: make(map[string]ListSource)}
+	for _, src := range sources {
+		s.Register(src)
+	}
+	return s
+}
+
+// Register adds a source, replacing any source with the same name.
+func (s *ListingService) Register(src ListSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[src.Name()] = src
+}
+
+// Schemas describes every registered source, sorted by name.
+func (s *ListingService) Schemas() []ListSourceSchema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schemas := make([]ListSourceSchema, 0, len(s.sources))
+	for _, src := range s.sources {
+		schemas = append(schemas, ListSourceSchema{Name: src.Name(), Fields: src.Fields()})
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	return schemas
+}
+
+// Execute validates the query against the source and returns a page of it.
+func (s *ListingService) Execute(ctx context.Context, actor Identity, source string, q ListQuery, params ListParams) ([]map[string]interface{}, PaginationMeta, error) {
+	src, err := s.source(source)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	q, err = s.prepare(src, q)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	params = params.Normalize()
+	rows, total, err := src.Query(ctx, actor, q, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return rows, NewPaginationMeta(params, total), nil
+}
+
+// Validate checks that a query is valid for a source without running it.
+func (s *ListingService) Validate(source string, q ListQuery) (ListQuery, error) {
+	src, err := s.source(source)
+	if err != nil {
+		return ListQuery{}, err
+	}
+	return s.prepare(src, q)
+}
+
+func (s *ListingService) source(name string) (ListSource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	src, ok := s.sources[name]
+	if !ok {
+		return nil, NotFound("List source " + name)
+	}
+	return src, nil
+}
+
+// prepare validates fields and operators, converts filter values to the
+// field types and fills in the default columns.
+func (s *ListingService) prepare(src ListSource, q ListQuery) (ListQuery, error) {
+	fields := make(map[string]ListField)
+	for _, f := range src.Fields() {
+		fields[f.Name] = f
+	}
+
+	out := ListQuery{}
+	for _, f := range q.Filters {
+		field, ok := fields[f.Field]
+		if !ok || !field.Filterable {
+			return ListQuery{}, InvalidInput("cannot filter by %q", f.Field)
+		}
+		if !slices.Contains(filterOps, f.Op) {
+			return ListQuery{}, InvalidInput("unknown filter operator %q", f.Op)
+		}
+		if f.Op == OpContains && field.Type != FieldString {
+			return ListQuery{}, InvalidInput("contains only applies to text fields")
+		}
+		value, err := convertFilterValue(field, f.Op, f.Value)
+		if err != nil {
+			return ListQuery{}, InvalidInput("filter %s: %v", f.Field, err)
+		}
+		out.Filters = append(out.Filters, Filter{Field: f.Field, Op: f.Op, Value: value})
+	}
+
+	for _, sf := range q.Sort {
+		field, ok := fields[sf.Field]
+		if !ok || !field.Sortable {
+			return ListQuery{}, InvalidInput("cannot sort by %q", sf.Field)
+		}
+		out.Sort = append(out.Sort, sf)
+	}
+
+	for _, col := range q.Columns {
+		if _, ok := fields[col]; !ok {
+			return ListQuery{}, InvalidInput("unknown column %q", col)
+		}
+		out.Columns = append(out.Columns, col)
+	}
+	if len(out.Columns) == 0 {
+		for _, f := range src.Fields() {
+			if f.Default {
+				out.Columns = append(out.Columns, f.Name)
+			}
+		}
+	}
+	return out, nil
+}
+
+func convertFilterValue(field ListField, op FilterOp, v interface{}) (interface{}, error) {
+	if op == OpIn {
+		var items []interface{}
+		switch list := v.(type) {
+		case []interface{}:
+			items = list
+		case string:
+			for _, part := range strings.Split(list, ",") {
+				items = append(items, part)
+			}
+		default:
+			return nil, fmt.Errorf("in expects a list")
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("in expects at least one value")
+		}
+		converted := make([]interface{}, len(items))
+		for i, item := range items {
+			c, err := convertScalar(field.Type, item)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = c
+		}
+		return converted, nil
+	}
+	return convertScalar(field.Type, v)
+}
+
+func convertScalar(t FieldType, v interface{}) (interface{}, error) {
+	switch t {
+	case FieldString:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case float64:
+			return strconv.FormatFloat(s, 'f', -1, 64), nil
+		}
+	case FieldInt:
+		switch n := v.(type) {
+		case float64:
+			return int64(n), nil
+		case string:
+			i, err := strconv.ParseInt(n, 10, 64)
+			if err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("expected an integer")
+	case FieldBool:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err == nil {
+				return parsed, nil
+			}
+		}
+		return nil, fmt.Errorf("expected a boolean")
+	case FieldTime:
+		if s, ok := v.(string); ok {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err == nil {
+				return parsed.UTC(), nil
+			}
+		}
+		return nil, fmt.Errorf("expected an RFC 3339 timestamp")
+	}
+	return nil, fmt.Errorf("unsupported value")
+}
+
+var filterParamPattern = regexp.MustCompile(`^filter\[([A-Za-z0-9_]+)\](?:\[([a-z]+)\])?$`)
+
+// ParseListQuery reads a list query from URL parameters:
+//
+//	filter[status]=open&filter[created_at][gte]=2024-01-01T00:00:00Z
+//	sort=-created_at,title
+//	columns=id,title
+func ParseListQuery(values url.Values) ListQuery {
+	var q ListQuery
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m := filterParamPattern.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		op := FilterOp(m[2])
+		if op == "" {
+			op = OpEq
+		}
+		for _, v := range values[key] {
+			q.Filters = append(q.Filters, Filter{Field: m[1], Op: op, Value: v})
+		}
+	}
+
+	for _, part := range strings.Split(values.Get("sort"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if name, desc := strings.CutPrefix(part, "-"); desc {
+			q.Sort = append(q.Sort, SortField{Field: name, Desc: true})
+		} else {
+			q.Sort = append(q.Sort, SortField{Field: part})
+		}
+	}
+
+	for _, col := range strings.Split(values.Get("columns"), ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			q.Columns = append(q.Columns, col)
+		}
+	}
+	return q
+}
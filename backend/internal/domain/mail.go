@@ -0,0 +1,17 @@
+package domain
+
+import "context"
+
+// Email is an outgoing e-mail message.
+type Email struct {
+	To      []string
+	ReplyTo string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends e-mail.
+type Mailer interface {
+	Send(ctx context.Context, msg Email) error
+}
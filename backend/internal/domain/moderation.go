@@ -0,0 +1,744 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Moderation case states
+const (
+	ModerationOpen     = "open"
+	ModerationResolved = "resolved"
+)
+
+// Moderation decisions. Every decision but dismiss gives the author of
+// the content a strike.
+const (
+	DecisionHide    = "hide"
+	DecisionDelete  = "delete"
+	DecisionWarn    = "warn"
+	DecisionBan     = "ban"
+	DecisionDismiss = "dismiss"
+)
+
+const (
+	// NotificationModeration tells users about a decision on their content.
+	NotificationModeration = "moderation.decision"
+	// NotificationReportResolved thanks reporters once their report was
+	// reviewed.
+	NotificationReportResolved = "moderation.report_resolved"
+
+	maxReportDetails  = 2000
+	maxModerationNote = 2000
+	moderationExcerpt = 280
+	maxBanDays        = 3650
+	// autoBanDuration is how long users are banned for reaching the
+	// strike limit.
+	autoBanDuration = 7 * 24 * time.Hour
+)
+
+var (
+	reportReasons       = []string{"spam", "harassment", "hate", "violence", "sexual", "misinformation", "other"}
+	moderationDecisions = []string{DecisionHide, DecisionDelete, DecisionWarn, DecisionBan, DecisionDismiss}
+)
+
+var (
+	ErrAlreadyReported = AppError{
+		Status:  http.StatusConflict,
+		Code:    "ALREADY_REPORTED",
+		Message: "You already reported this",
+	}
+
+	ErrUserBanned = AppError{
+		Status:  http.StatusForbidden,
+		Code:    "USER_BANNED",
+		Message: "Your account is suspended",
+	}
+
+	ErrCaseClosed = AppError{
+		Status:  http.StatusConflict,
+		Code:    "CASE_CLOSED",
+		Message: "The case was already decided",
+	}
+
+	ErrCaseAssigned = AppError{
+		Status:  http.StatusConflict,
+		Code:    "CASE_ASSIGNED",
+		Message: "The case is assigned to another moderator",
+	}
+)
+
+// ModerationCase collects the reports and rule matches about one
+// resource until a moderator decides on it. A resource has at most one
+// open case; reports after the decision open a new one.
+type ModerationCase struct {
+	ID            string     `json:"id"`
+	TenantID      string     `json:"tenant_id"`
+	ResourceType  string     `json:"resource_type"`
+	ResourceID    string     `json:"resource_id"`
+	SubjectUserID string     `json:"subject_user_id,omitempty"`
+	Excerpt       string     `json:"excerpt"`
+	Status        string     `json:"status"`
+	Flags         []string   `json:"flags"`
+	Hidden        bool       `json:"hidden"`
+	ReportCount   int        `json:"report_count"`
+	AssigneeID    string     `json:"assignee_id,omitempty"`
+	AssignedAt    *time.Time `json:"assigned_at,omitempty"`
+	Decision      string     `json:"decision,omitempty"`
+	DecisionNote  string     `json:"decision_note,omitempty"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// ModerationCaseDetail is a case with its reports, appeal and the audit
+// trail of moderator actions on it.
+type ModerationCaseDetail struct {
+	ModerationCase
+	Reports []ModerationReport `json:"reports"`
+	Appeal  *ModerationAppeal  `json:"appeal,omitempty"`
+	History []AuditEntry       `json:"history"`
+}
+
+// ModerationReport is a user's complaint about a resource.
+type ModerationReport struct {
+	ID           string    `json:"id"`
+	TenantID     string    `json:"tenant_id"`
+	CaseID       string    `json:"case_id"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   string    `json:"resource_id"`
+	ReporterID   string    `json:"reporter_id"`
+	Reason       string    `json:"reason"`
+	Details      string    `json:"details,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ModerationReportInput is the payload for reporting a resource.
+type ModerationReportInput struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+// ModerationCaseFilter narrows the queue. Status is open by default or
+// "all"; Assignee is a user ID, "me" or "none".
+type ModerationCaseFilter struct {
+	Status        string `form:"status"`
+	Assignee      string `form:"assignee"`
+	ResourceType  string `form:"resource_type"`
+	SubjectUserID string `form:"subject_user_id"`
+}
+
+// ModerationAssignInput assigns a case. Without assignee_id the case goes
+// to the caller; an empty one unassigns it.
+type ModerationAssignInput struct {
+	AssigneeID *string `json:"assignee_id"`
+}
+
+// ModerationDecisionInput decides a case. BanDays limits a ban, which is
+// permanent without it.
+type ModerationDecisionInput struct {
+	Decision string `json:"decision"`
+	Note     string `json:"note"`
+	BanDays  *int   `json:"ban_days"`
+}
+
+// ModerationStrike counts against a user whose content a moderator acted
+// on. Strikes expire; appeals revoke them.
+type ModerationStrike struct {
+	ID        string     `json:"id"`
+	TenantID  string     `json:"tenant_id"`
+	UserID    string     `json:"user_id"`
+	CaseID    string     `json:"case_id"`
+	Kind      string     `json:"kind"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (s ModerationStrike) active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ModerationBan keeps a user from posting and reporting until Until, or
+// until it is lifted when Until is nil.
+type ModerationBan struct {
+	TenantID  string     `json:"tenant_id"`
+	UserID    string     `json:"user_id"`
+	CaseID    string     `json:"case_id,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	Until     *time.Time `json:"until,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (b *ModerationBan) effective(now time.Time) bool {
+	return b != nil && (b.Until == nil || now.Before(*b.Until))
+}
+
+// ModerationStanding is a user's strike record.
+type ModerationStanding struct {
+	UserID        string             `json:"user_id"`
+	ActiveStrikes int                `json:"active_strikes"`
+	Strikes       []ModerationStrike `json:"strikes"`
+	Ban           *ModerationBan     `json:"ban,omitempty"`
+}
+
+// ModeratedContent is what moderation needs to know about a resource.
+type ModeratedContent struct {
+	AuthorID string
+	Text     string
+}
+
+// ModerationTarget describes the resources of one type to moderation.
+type ModerationTarget interface {
+	ModeratedContent(ctx context.Context, tenantID, id string) (ModeratedContent, error)
+}
+
+// HideableTarget is a target whose resources moderators can hide from
+// everyone but their author and moderators.
+type HideableTarget interface {
+	ModerationTarget
+	SetHidden(ctx context.Context, tenantID, id string, hidden bool) error
+}
+
+// RemovableTarget is a target whose resources moderators can delete.
+type RemovableTarget interface {
+	ModerationTarget
+	RemoveModerated(ctx context.Context, actor Identity, id string) error
+}
+
+// ModerationRepository persists cases, reports, rules, strikes, bans and
+// appeals.
+type ModerationRepository interface {
+	// OpenCase inserts c unless the resource has an open case, and
+	// returns the open case.
+	OpenCase(ctx context.Context, c *ModerationCase) (*ModerationCase, error)
+	GetCase(ctx context.Context, tenantID, id string) (*ModerationCase, error)
+	ListCases(ctx context.Context, tenantID string, f ModerationCaseFilter, params ListParams) ([]ModerationCase, int, error)
+	SaveCase(ctx context.Context, c *ModerationCase) error
+	// AddReport stores the report and counts it on its case, unless the
+	// reporter already reported the case.
+	AddReport(ctx context.Context, r *ModerationReport) (bool, error)
+	Reports(ctx context.Context, tenantID, caseID string) ([]ModerationReport, error)
+
+	ListRules(ctx context.Context, tenantID string) ([]ModerationRule, error)
+	GetRule(ctx context.Context, tenantID, id string) (*ModerationRule, error)
+	SaveRule(ctx context.Context, r *ModerationRule) error
+	DeleteRule(ctx context.Context, tenantID, id string) error
+
+	AddStrike(ctx context.Context, s *ModerationStrike) error
+	Strikes(ctx context.Context, tenantID, userID string) ([]ModerationStrike, error)
+	RevokeStrikes(ctx context.Context, tenantID, caseID string, at time.Time) (int64, error)
+	SaveBan(ctx context.Context, b *ModerationBan) error
+	// GetBan returns nil when the user was never banned or the ban was
+	// lifted.
+	GetBan(ctx context.Context, tenantID, userID string) (*ModerationBan, error)
+	DeleteBan(ctx context.Context, tenantID, userID string) error
+
+	CreateAppeal(ctx context.Context, a *ModerationAppeal) error
+	GetAppeal(ctx context.Context, tenantID, id string) (*ModerationAppeal, error)
+	// AppealForCase returns nil when the case was not appealed.
+	AppealForCase(ctx context.Context, tenantID, caseID string) (*ModerationAppeal, error)
+	ListAppeals(ctx context.Context, tenantID string, f ModerationAppealFilter, params ListParams) ([]ModerationAppeal, int, error)
+	SaveAppeal(ctx context.Context, a *ModerationAppeal) error
+}
+
+// ModerationService runs the moderation queue: users report resources,
+// rules flag content as it is posted, and moderators decide the cases.
+// Moderators hold the moderator or admin role; their actions are
+// recorded in the audit log.
+type ModerationService struct {
+	repo          ModerationRepository
+	audit         *AuditService
+	notifications *NotificationService
+	targets       map[string]ModerationTarget
+
+	banAfter  int
+	strikeTTL time.Duration
+}
+
+// NewModerationService creates a moderation service. Users are banned
+// for a week at three active strikes, which expire after 90 days, unless
+// SetStrikePolicy says otherwise.
+func NewModerationService(repo ModerationRepository, audit *AuditService, notifications *NotificationService) *ModerationService {
+	return &ModerationService{
+		repo:          repo,
+		audit:         audit,
+		notifications: notifications,
+		targets:       make(map[string]ModerationTarget),
+		banAfter:      3,
+		strikeTTL:     90 * 24 * time.Hour,
+	}
+}
+
+// RegisterTarget lets moderation look into, and act on, resources of the
+// type. Resources of other types can be reported, but decisions on them
+// only affect their author when the report's case knows one.
+func (s *ModerationService) RegisterTarget(resourceType string, t ModerationTarget) {
+	s.targets[resourceType] = t
+}
+
+// SetStrikePolicy bans users automatically once they have banAfter
+// active strikes (never when 0); strikes expire after expiry.
+func (s *ModerationService) SetStrikePolicy(banAfter int, expiry time.Duration) {
+	s.banAfter = banAfter
+	s.strikeTTL = expiry
+}
+
+// CheckAllowed fails with ErrUserBanned while the caller is banned.
+func (s *ModerationService) CheckAllowed(ctx context.Context, actor Identity) error {
+	ban, err := s.repo.GetBan(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return err
+	}
+	if !ban.effective(time.Now()) {
+		return nil
+	}
+	details := map[string]interface{}{"case_id": ban.CaseID}
+	if ban.Until != nil {
+		details["until"] = ban.Until
+	}
+	return ErrUserBanned.WithDetails(details)
+}
+
+// Report files the caller's report about a resource, adding it to the
+// resource's open case.
+func (s *ModerationService) Report(ctx context.Context, actor Identity, resourceType, resourceID string, in ModerationReportInput) (*ModerationReport, error) {
+	if err := ValidateResourceRef(resourceType, resourceID); err != nil {
+		return nil, err
+	}
+	reason := strings.ToLower(strings.TrimSpace(in.Reason))
+	if !slices.Contains(reportReasons, reason) {
+		return nil, InvalidInput("reason must be one of %s", strings.Join(reportReasons, ", "))
+	}
+	details := strings.TrimSpace(in.Details)
+	if utf8.RuneCountInString(details) > maxReportDetails {
+		return nil, InvalidInput("details must be at most %d characters", maxReportDetails)
+	}
+	if err := s.CheckAllowed(ctx, actor); err != nil {
+		return nil, err
+	}
+	content, err := s.content(ctx, actor.TenantID, resourceType, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	if content.AuthorID == actor.UserID {
+		return nil, InvalidInput("you cannot report your own content")
+	}
+
+	c, err := s.openCase(ctx, actor.TenantID, resourceType, resourceID, content)
+	if err != nil {
+		return nil, err
+	}
+	r := &ModerationReport{
+		ID:           NewID(),
+		TenantID:     actor.TenantID,
+		CaseID:       c.ID,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		ReporterID:   actor.UserID,
+		Reason:       reason,
+		Details:      details,
+		CreatedAt:    time.Now().UTC(),
+	}
+	added, err := s.repo.AddReport(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrAlreadyReported
+	}
+	return r, nil
+}
+
+// Cases returns a page of the queue, most reported first.
+func (s *ModerationService) Cases(ctx context.Context, actor Identity, f ModerationCaseFilter, params ListParams) ([]ModerationCase, PaginationMeta, error) {
+	if !actor.IsModerator() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	switch f.Status {
+	case "":
+		f.Status = ModerationOpen
+	case "all":
+		f.Status = ""
+	case ModerationOpen, ModerationResolved:
+	default:
+		return nil, PaginationMeta{}, InvalidInput("status must be open, resolved or all")
+	}
+	if f.Assignee == "me" {
+		f.Assignee = actor.UserID
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.ListCases(ctx, actor.TenantID, f, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Case returns a case with its reports, appeal and history.
+func (s *ModerationService) Case(ctx context.Context, actor Identity, id string) (*ModerationCaseDetail, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	c, err := s.repo.GetCase(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	detail := &ModerationCaseDetail{ModerationCase: *c}
+	if detail.Reports, err = s.repo.Reports(ctx, actor.TenantID, id); err != nil {
+		return nil, err
+	}
+	if detail.Appeal, err = s.repo.AppealForCase(ctx, actor.TenantID, id); err != nil {
+		return nil, err
+	}
+	if detail.History, err = s.audit.History(ctx, actor.TenantID, "moderation_case", id); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// Assign hands an open case to a moderator. Moderators take cases
+// themselves; only admins assign others or take over assigned cases.
+func (s *ModerationService) Assign(ctx context.Context, actor Identity, id string, in ModerationAssignInput) (*ModerationCase, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	assignee := actor.UserID
+	if in.AssigneeID != nil {
+		assignee = strings.TrimSpace(*in.AssigneeID)
+	}
+	if assignee != "" && assignee != actor.UserID && !actor.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	c, err := s.repo.GetCase(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != ModerationOpen {
+		return nil, ErrCaseClosed
+	}
+	if c.AssigneeID != "" && c.AssigneeID != actor.UserID && !actor.IsAdmin() {
+		return nil, ErrCaseAssigned
+	}
+	if c.AssigneeID == assignee {
+		return c, nil
+	}
+
+	previous := c.AssigneeID
+	now := time.Now().UTC()
+	c.AssigneeID = assignee
+	c.AssignedAt = &now
+	if assignee == "" {
+		c.AssignedAt = nil
+	}
+	c.UpdatedAt = now
+	if err := s.repo.SaveCase(ctx, c); err != nil {
+		return nil, err
+	}
+	s.record(ctx, actor, "moderation.assign", "moderation_case", c.ID, map[string]interface{}{
+		"assignee_id": map[string]interface{}{"from": previous, "to": assignee},
+	})
+	return c, nil
+}
+
+// Decide resolves an open case: the content is hidden or deleted, or
+// left alone, and its author gets a strike, a warning or a ban. Authors
+// and reporters are notified.
+func (s *ModerationService) Decide(ctx context.Context, actor Identity, id string, in ModerationDecisionInput) (*ModerationCase, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	decision := strings.ToLower(strings.TrimSpace(in.Decision))
+	if !slices.Contains(moderationDecisions, decision) {
+		return nil, InvalidInput("decision must be one of %s", strings.Join(moderationDecisions, ", "))
+	}
+	note := strings.TrimSpace(in.Note)
+	if utf8.RuneCountInString(note) > maxModerationNote {
+		return nil, InvalidInput("note must be at most %d characters", maxModerationNote)
+	}
+	if in.BanDays != nil && (decision != DecisionBan || *in.BanDays < 1 || *in.BanDays > maxBanDays) {
+		return nil, InvalidInput("ban_days must be between 1 and %d, and only given with a ban", maxBanDays)
+	}
+
+	c, err := s.repo.GetCase(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != ModerationOpen {
+		return nil, ErrCaseClosed
+	}
+	if c.AssigneeID != "" && c.AssigneeID != actor.UserID && !actor.IsAdmin() {
+		return nil, ErrCaseAssigned
+	}
+	if c.SubjectUserID == actor.UserID {
+		return nil, ErrForbidden
+	}
+	if (decision == DecisionWarn || decision == DecisionBan) && c.SubjectUserID == "" {
+		return nil, InvalidInput("the %s has no known author to %s", c.ResourceType, decision)
+	}
+
+	switch decision {
+	case DecisionHide:
+		if err := s.setHidden(ctx, c, true); err != nil {
+			return nil, err
+		}
+	case DecisionDelete:
+		t, ok := s.targets[c.ResourceType].(RemovableTarget)
+		if !ok {
+			return nil, InvalidInput("%s resources cannot be deleted by moderators", c.ResourceType)
+		}
+		if err := t.RemoveModerated(ctx, actor, c.ResourceID); err != nil {
+			return nil, err
+		}
+	case DecisionDismiss:
+		// Undo what an auto-flag rule did
+		if c.Hidden {
+			if err := s.setHidden(ctx, c, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	c.Status = ModerationResolved
+	c.Decision = decision
+	c.DecisionNote = note
+	c.DecidedBy = actor.UserID
+	c.DecidedAt = &now
+	c.UpdatedAt = now
+	if err := s.repo.SaveCase(ctx, c); err != nil {
+		return nil, err
+	}
+	changes := map[string]interface{}{"decision": decision, "note": note, "subject_user_id": c.SubjectUserID}
+	if in.BanDays != nil {
+		changes["ban_days"] = *in.BanDays
+	}
+	s.record(ctx, actor, "moderation.decide", "moderation_case", c.ID, changes)
+
+	if decision != DecisionDismiss && c.SubjectUserID != "" {
+		if err := s.strike(ctx, actor, c, in.BanDays, now); err != nil {
+			return nil, err
+		}
+		s.notifyDecision(ctx, c)
+	}
+	s.notifyReporters(ctx, c)
+	return c, nil
+}
+
+// Standing returns a user's strikes and ban. Users may see their own.
+func (s *ModerationService) Standing(ctx context.Context, actor Identity, userID string) (*ModerationStanding, error) {
+	if userID != actor.UserID && !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	strikes, err := s.repo.Strikes(ctx, actor.TenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	ban, err := s.repo.GetBan(ctx, actor.TenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	standing := &ModerationStanding{UserID: userID, Strikes: strikes}
+	for i := range strikes {
+		strikes[i].Active = strikes[i].active(now)
+		if strikes[i].Active {
+			standing.ActiveStrikes++
+		}
+	}
+	if ban.effective(now) {
+		standing.Ban = ban
+	}
+	return standing, nil
+}
+
+// LiftBan ends a user's ban early.
+func (s *ModerationService) LiftBan(ctx context.Context, actor Identity, userID string) error {
+	if !actor.IsModerator() {
+		return ErrForbidden
+	}
+	ban, err := s.repo.GetBan(ctx, actor.TenantID, userID)
+	if err != nil {
+		return err
+	}
+	if ban == nil {
+		return NotFound("Ban")
+	}
+	if err := s.repo.DeleteBan(ctx, actor.TenantID, userID); err != nil {
+		return err
+	}
+	s.record(ctx, actor, "moderation.lift_ban", "user", userID, map[string]interface{}{"case_id": ban.CaseID, "until": ban.Until})
+	return nil
+}
+
+// strike records a strike for the decision on c and bans the author for
+// a ban decision or when the strike reaches the limit.
+func (s *ModerationService) strike(ctx context.Context, actor Identity, c *ModerationCase, banDays *int, now time.Time) error {
+	err := s.repo.AddStrike(ctx, &ModerationStrike{
+		ID:        NewID(),
+		TenantID:  c.TenantID,
+		UserID:    c.SubjectUserID,
+		CaseID:    c.ID,
+		Kind:      c.Decision,
+		Reason:    c.DecisionNote,
+		CreatedBy: actor.UserID,
+		ExpiresAt: now.Add(s.strikeTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	if c.Decision == DecisionBan {
+		var until *time.Time
+		if banDays != nil {
+			t := now.AddDate(0, 0, *banDays)
+			until = &t
+		}
+		return s.repo.SaveBan(ctx, &ModerationBan{
+			TenantID:  c.TenantID,
+			UserID:    c.SubjectUserID,
+			CaseID:    c.ID,
+			Reason:    c.DecisionNote,
+			CreatedBy: actor.UserID,
+			Until:     until,
+			CreatedAt: now,
+		})
+	}
+	if s.banAfter <= 0 {
+		return nil
+	}
+
+	strikes, err := s.repo.Strikes(ctx, c.TenantID, c.SubjectUserID)
+	if err != nil {
+		return err
+	}
+	active := 0
+	for _, st := range strikes {
+		if st.active(now) {
+			active++
+		}
+	}
+	if active < s.banAfter {
+		return nil
+	}
+	ban, err := s.repo.GetBan(ctx, c.TenantID, c.SubjectUserID)
+	if err != nil || ban.effective(now) {
+		return err
+	}
+	until := now.Add(autoBanDuration)
+	if err := s.repo.SaveBan(ctx, &ModerationBan{
+		TenantID:  c.TenantID,
+		UserID:    c.SubjectUserID,
+		CaseID:    c.ID,
+		Reason:    fmt.Sprintf("%d active strikes", active),
+		CreatedBy: actor.UserID,
+		Until:     &until,
+		CreatedAt: now,
+	}); err != nil {
+		return err
+	}
+	s.notify(ctx, c, c.SubjectUserID, NotificationModeration, "Your account is suspended",
+		fmt.Sprintf("You reached %d strikes and cannot post until %s.", active, until.Format(time.RFC1123)))
+	return nil
+}
+
+// content describes a resource through its target; resources without
+// one are described by nothing.
+func (s *ModerationService) content(ctx context.Context, tenantID, resourceType, resourceID string) (ModeratedContent, error) {
+	t, ok := s.targets[resourceType]
+	if !ok {
+		return ModeratedContent{}, nil
+	}
+	return t.ModeratedContent(ctx, tenantID, resourceID)
+}
+
+func (s *ModerationService) openCase(ctx context.Context, tenantID, resourceType, resourceID string, content ModeratedContent) (*ModerationCase, error) {
+	now := time.Now().UTC()
+	return s.repo.OpenCase(ctx, &ModerationCase{
+		ID:            NewID(),
+		TenantID:      tenantID,
+		ResourceType:  resourceType,
+		ResourceID:    resourceID,
+		SubjectUserID: content.AuthorID,
+		Excerpt:       truncateRunes(content.Text, moderationExcerpt),
+		Status:        ModerationOpen,
+		Flags:         []string{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+}
+
+func (s *ModerationService) setHidden(ctx context.Context, c *ModerationCase, hidden bool) error {
+	t, ok := s.targets[c.ResourceType].(HideableTarget)
+	if !ok {
+		return InvalidInput("%s resources cannot be hidden", c.ResourceType)
+	}
+	if err := t.SetHidden(ctx, c.TenantID, c.ResourceID, hidden); err != nil {
+		return err
+	}
+	c.Hidden = hidden
+	return nil
+}
+
+func (s *ModerationService) notifyDecision(ctx context.Context, c *ModerationCase) {
+	var title string
+	switch c.Decision {
+	case DecisionHide:
+		title = "Your content was hidden by a moderator"
+	case DecisionDelete:
+		title = "Your content was removed by a moderator"
+	case DecisionWarn:
+		title = "You received a warning from a moderator"
+	case DecisionBan:
+		title = "Your account is suspended"
+	}
+	s.notify(ctx, c, c.SubjectUserID, NotificationModeration, title, c.DecisionNote)
+}
+
+func (s *ModerationService) notifyReporters(ctx context.Context, c *ModerationCase) {
+	reports, err := s.repo.Reports(ctx, c.TenantID, c.ID)
+	if err != nil {
+		log.Printf("moderation: reporters of case %s: %v", c.ID, err)
+		return
+	}
+	for _, r := range reports {
+		s.notify(ctx, c, r.ReporterID, NotificationReportResolved, "Thanks for your report",
+			"A moderator reviewed the content you reported.")
+	}
+}
+
+// notify tells a user about case c. Notifications point at the case so
+// users can appeal.
+func (s *ModerationService) notify(ctx context.Context, c *ModerationCase, userID, kind, title, body string) {
+	err := s.notifications.Notify(ctx, Notification{
+		TenantID:     c.TenantID,
+		UserID:       userID,
+		Kind:         kind,
+		Title:        title,
+		Body:         body,
+		ResourceType: "moderation_case",
+		ResourceID:   c.ID,
+	})
+	if err != nil {
+		log.Printf("moderation: notify %s about case %s: %v", userID, c.ID, err)
+	}
+}
+
+// record writes the audit entry of an action that already happened, so
+// a failure is logged rather than reported to the caller.
+func (s *ModerationService) record(ctx context.Context, actor Identity, action, resourceType, resourceID string, changes map[string]interface{}) {
+	if err := s.audit.Record(ctx, actor, action, resourceType, resourceID, changes); err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
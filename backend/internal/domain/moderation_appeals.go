@@ -0,0 +1,230 @@
+package domain
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Appeal states
+const (
+	AppealPending = "pending"
+	// AppealUpheld keeps the decision.
+	AppealUpheld = "upheld"
+	// AppealOverturned revokes the decision's strike and ban and shows
+	// hidden content again. Deleted content stays deleted.
+	AppealOverturned = "overturned"
+)
+
+const (
+	// NotificationAppealDecided tells users the outcome of their appeal.
+	NotificationAppealDecided = "moderation.appeal_decided"
+
+	// appealWindow is how long after a decision its subject may appeal.
+	appealWindow       = 30 * 24 * time.Hour
+	maxAppealStatement = 2000
+)
+
+var (
+	ErrAlreadyAppealed = AppError{
+		Status:  http.StatusConflict,
+		Code:    "ALREADY_APPEALED",
+		Message: "The decision was already appealed",
+	}
+
+	ErrAppealWindowClosed = AppError{
+		Status:  http.StatusConflict,
+		Code:    "APPEAL_WINDOW_CLOSED",
+		Message: "The decision can no longer be appealed",
+	}
+)
+
+// ModerationAppeal asks moderators to reconsider a decision. Each
+// decision can be appealed once, by the user it was about.
+type ModerationAppeal struct {
+	ID           string     `json:"id"`
+	TenantID     string     `json:"tenant_id"`
+	CaseID       string     `json:"case_id"`
+	UserID       string     `json:"user_id"`
+	Statement    string     `json:"statement"`
+	Status       string     `json:"status"`
+	DecisionNote string     `json:"decision_note,omitempty"`
+	DecidedBy    string     `json:"decided_by,omitempty"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// ModerationAppealInput is the payload for appealing a decision.
+type ModerationAppealInput struct {
+	CaseID    string `json:"case_id"`
+	Statement string `json:"statement"`
+}
+
+// ModerationAppealDecisionInput decides an appeal.
+type ModerationAppealDecisionInput struct {
+	Outcome string `json:"outcome"`
+	Note    string `json:"note"`
+}
+
+// ModerationAppealFilter narrows the appeals listed. Moderators see
+// pending appeals by default; status "all" lists every appeal.
+type ModerationAppealFilter struct {
+	Status string `form:"status"`
+	UserID string `form:"-"`
+}
+
+// Appeal files the caller's appeal against a decision about them. Banned
+// users may appeal too.
+func (s *ModerationService) Appeal(ctx context.Context, actor Identity, in ModerationAppealInput) (*ModerationAppeal, error) {
+	statement := strings.TrimSpace(in.Statement)
+	if statement == "" || utf8.RuneCountInString(statement) > maxAppealStatement {
+		return nil, InvalidInput("statement is required and must be at most %d characters", maxAppealStatement)
+	}
+	c, err := s.repo.GetCase(ctx, actor.TenantID, in.CaseID)
+	if err != nil {
+		return nil, err
+	}
+	// Cases about others look like missing ones
+	if c.SubjectUserID != actor.UserID {
+		return nil, NotFound("Moderation case")
+	}
+	if c.Status != ModerationResolved || c.Decision == DecisionDismiss {
+		return nil, InvalidInput("only decisions against you can be appealed")
+	}
+	if c.DecidedAt != nil && time.Since(*c.DecidedAt) > appealWindow {
+		return nil, ErrAppealWindowClosed
+	}
+	existing, err := s.repo.AppealForCase(ctx, actor.TenantID, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrAlreadyAppealed
+	}
+
+	a := &ModerationAppeal{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		CaseID:    c.ID,
+		UserID:    actor.UserID,
+		Statement: statement,
+		Status:    AppealPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.repo.CreateAppeal(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// MyAppeals returns the caller's appeals, oldest first.
+func (s *ModerationService) MyAppeals(ctx context.Context, actor Identity, params ListParams) ([]ModerationAppeal, PaginationMeta, error) {
+	return s.listAppeals(ctx, actor.TenantID, ModerationAppealFilter{UserID: actor.UserID}, params)
+}
+
+// Appeals returns a page of the appeals queue, oldest first.
+func (s *ModerationService) Appeals(ctx context.Context, actor Identity, f ModerationAppealFilter, params ListParams) ([]ModerationAppeal, PaginationMeta, error) {
+	if !actor.IsModerator() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	switch f.Status {
+	case "":
+		f.Status = AppealPending
+	case "all":
+		f.Status = ""
+	case AppealPending, AppealUpheld, AppealOverturned:
+	default:
+		return nil, PaginationMeta{}, InvalidInput("status must be pending, upheld, overturned or all")
+	}
+	f.UserID = ""
+	return s.listAppeals(ctx, actor.TenantID, f, params)
+}
+
+// DecideAppeal upholds or overturns the decision appealed against. The
+// moderator who made the decision cannot review it, unless an admin.
+func (s *ModerationService) DecideAppeal(ctx context.Context, actor Identity, id string, in ModerationAppealDecisionInput) (*ModerationAppeal, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	if in.Outcome != AppealUpheld && in.Outcome != AppealOverturned {
+		return nil, InvalidInput("outcome must be %s or %s", AppealUpheld, AppealOverturned)
+	}
+	note := strings.TrimSpace(in.Note)
+	if utf8.RuneCountInString(note) > maxModerationNote {
+		return nil, InvalidInput("note must be at most %d characters", maxModerationNote)
+	}
+	a, err := s.repo.GetAppeal(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Status != AppealPending {
+		return nil, ErrCaseClosed
+	}
+	c, err := s.repo.GetCase(ctx, actor.TenantID, a.CaseID)
+	if err != nil {
+		return nil, err
+	}
+	if (c.DecidedBy == actor.UserID && !actor.IsAdmin()) || a.UserID == actor.UserID {
+		return nil, ErrForbidden
+	}
+
+	now := time.Now().UTC()
+	if in.Outcome == AppealOverturned {
+		if err := s.overturn(ctx, c, now); err != nil {
+			return nil, err
+		}
+	}
+	a.Status = in.Outcome
+	a.DecisionNote = note
+	a.DecidedBy = actor.UserID
+	a.DecidedAt = &now
+	if err := s.repo.SaveAppeal(ctx, a); err != nil {
+		return nil, err
+	}
+	s.record(ctx, actor, "moderation.decide_appeal", "moderation_case", c.ID, map[string]interface{}{
+		"appeal_id": a.ID, "outcome": a.Status, "note": note,
+	})
+
+	title := "Your appeal was rejected"
+	if a.Status == AppealOverturned {
+		title = "Your appeal was accepted"
+	}
+	s.notify(ctx, c, a.UserID, NotificationAppealDecided, title, note)
+	return a, nil
+}
+
+// overturn undoes what the decision on c did to its subject and content.
+func (s *ModerationService) overturn(ctx context.Context, c *ModerationCase, now time.Time) error {
+	if _, err := s.repo.RevokeStrikes(ctx, c.TenantID, c.ID, now); err != nil {
+		return err
+	}
+	ban, err := s.repo.GetBan(ctx, c.TenantID, c.SubjectUserID)
+	if err != nil {
+		return err
+	}
+	// The decision banned the user, or its strike reached the limit
+	if ban != nil && ban.CaseID == c.ID {
+		if err := s.repo.DeleteBan(ctx, c.TenantID, c.SubjectUserID); err != nil {
+			return err
+		}
+	}
+	if c.Hidden {
+		if err := s.setHidden(ctx, c, false); err != nil {
+			return err
+		}
+		c.UpdatedAt = now
+		return s.repo.SaveCase(ctx, c)
+	}
+	return nil
+}
+
+func (s *ModerationService) listAppeals(ctx context.Context, tenantID string, f ModerationAppealFilter, params ListParams) ([]ModerationAppeal, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.ListAppeals(ctx, tenantID, f, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
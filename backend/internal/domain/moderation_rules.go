@@ -0,0 +1,285 @@
+package domain
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Kinds of auto-flag rules
+const (
+	// RuleKeyword matches a word or phrase, ignoring case.
+	RuleKeyword = "keyword"
+	// RuleRegex matches a regular expression (RE2 syntax).
+	RuleRegex = "regex"
+)
+
+// What auto-flag rules do with matching content
+const (
+	// RuleFlag opens a case for moderators to review.
+	RuleFlag = "flag"
+	// RuleHide also hides the content until a moderator decides.
+	RuleHide = "hide"
+)
+
+const (
+	maxModerationRules = 200
+	maxRuleName        = 100
+	maxRulePattern     = 500
+)
+
+// ModerationRule flags content that matches its pattern when it is
+// posted or edited.
+type ModerationRule struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"tenant_id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Pattern   string    `json:"pattern"`
+	Action    string    `json:"action"`
+	Enabled   bool      `json:"enabled"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ModerationRuleInput creates or updates a rule; absent fields keep
+// their value, or the default when creating.
+type ModerationRuleInput struct {
+	Name    *string `json:"name"`
+	Kind    *string `json:"kind"`
+	Pattern *string `json:"pattern"`
+	Action  *string `json:"action"`
+	Enabled *bool   `json:"enabled"`
+}
+
+// ModerationRuleTestInput is text to try the rules on.
+type ModerationRuleTestInput struct {
+	Text string `json:"text"`
+}
+
+// compile returns the expression the rule matches with. Keywords match
+// whole words, so "ass" does not flag "class".
+func (r ModerationRule) compile() (*regexp.Regexp, error) {
+	if r.Kind == RuleKeyword {
+		return regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}_])` + regexp.QuoteMeta(r.Pattern) + `(?:$|[^\p{L}\p{N}_])`)
+	}
+	return regexp.Compile(r.Pattern)
+}
+
+// Rules returns the tenant's auto-flag rules.
+func (s *ModerationService) Rules(ctx context.Context, actor Identity) ([]ModerationRule, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	return s.repo.ListRules(ctx, actor.TenantID)
+}
+
+// CreateRule adds an auto-flag rule. Name and pattern are required; the
+// kind defaults to keyword and the action to flag.
+func (s *ModerationService) CreateRule(ctx context.Context, actor Identity, in ModerationRuleInput) (*ModerationRule, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	rules, err := s.repo.ListRules(ctx, actor.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) >= maxModerationRules {
+		return nil, InvalidInput("a tenant can have at most %d rules", maxModerationRules)
+	}
+	now := time.Now().UTC()
+	r := &ModerationRule{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		Kind:      RuleKeyword,
+		Action:    RuleFlag,
+		Enabled:   true,
+		CreatedBy: actor.UserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := applyRuleInput(r, in); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveRule(ctx, r); err != nil {
+		return nil, err
+	}
+	s.record(ctx, actor, "moderation.create_rule", "moderation_rule", r.ID, ruleChanges(nil, r))
+	return r, nil
+}
+
+// UpdateRule changes the given fields of a rule.
+func (s *ModerationService) UpdateRule(ctx context.Context, actor Identity, id string, in ModerationRuleInput) (*ModerationRule, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	r, err := s.repo.GetRule(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	before := *r
+	if err := applyRuleInput(r, in); err != nil {
+		return nil, err
+	}
+	changes := ruleChanges(&before, r)
+	if len(changes) == 0 {
+		return r, nil
+	}
+	r.UpdatedAt = time.Now().UTC()
+	if err := s.repo.SaveRule(ctx, r); err != nil {
+		return nil, err
+	}
+	s.record(ctx, actor, "moderation.update_rule", "moderation_rule", r.ID, changes)
+	return r, nil
+}
+
+// DeleteRule removes a rule. Cases it flagged stay in the queue.
+func (s *ModerationService) DeleteRule(ctx context.Context, actor Identity, id string) error {
+	if !actor.IsModerator() {
+		return ErrForbidden
+	}
+	r, err := s.repo.GetRule(ctx, actor.TenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteRule(ctx, actor.TenantID, id); err != nil {
+		return err
+	}
+	s.record(ctx, actor, "moderation.delete_rule", "moderation_rule", id, ruleChanges(r, nil))
+	return nil
+}
+
+// TestRules returns the enabled rules the text matches.
+func (s *ModerationService) TestRules(ctx context.Context, actor Identity, in ModerationRuleTestInput) ([]ModerationRule, error) {
+	if !actor.IsModerator() {
+		return nil, ErrForbidden
+	}
+	return s.matchRules(ctx, actor.TenantID, in.Text)
+}
+
+// Flag checks a resource against the tenant's rules. Matching content
+// gets an open case listing the rules, and is hidden when a rule says
+// so.
+func (s *ModerationService) Flag(ctx context.Context, tenantID, resourceType, resourceID string) error {
+	content, err := s.content(ctx, tenantID, resourceType, resourceID)
+	if err != nil || content.Text == "" {
+		return err
+	}
+	matched, err := s.matchRules(ctx, tenantID, content.Text)
+	if err != nil || len(matched) == 0 {
+		return err
+	}
+
+	c, err := s.openCase(ctx, tenantID, resourceType, resourceID, content)
+	if err != nil {
+		return err
+	}
+	changed := false
+	hide := false
+	for _, r := range matched {
+		if !slices.Contains(c.Flags, r.Name) {
+			c.Flags = append(c.Flags, r.Name)
+			changed = true
+		}
+		hide = hide || r.Action == RuleHide
+	}
+	if hide && !c.Hidden {
+		if err := s.setHidden(ctx, c, true); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	// An edit may have changed the text the case shows
+	c.Excerpt = truncateRunes(content.Text, moderationExcerpt)
+	c.UpdatedAt = time.Now().UTC()
+	return s.repo.SaveCase(ctx, c)
+}
+
+func (s *ModerationService) matchRules(ctx context.Context, tenantID, text string) ([]ModerationRule, error) {
+	rules, err := s.repo.ListRules(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	matched := []ModerationRule{}
+	for _, r := range rules {
+		if !r.Enabled {
+			continue
+		}
+		re, err := r.compile()
+		if err != nil {
+			log.Printf("moderation: rule %s does not compile: %v", r.ID, err)
+			continue
+		}
+		if re.MatchString(text) {
+			matched = append(matched, r)
+		}
+	}
+	return matched, nil
+}
+
+func applyRuleInput(r *ModerationRule, in ModerationRuleInput) error {
+	if in.Name != nil {
+		r.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.Kind != nil {
+		r.Kind = *in.Kind
+	}
+	if in.Pattern != nil {
+		r.Pattern = *in.Pattern
+		if r.Kind == RuleKeyword {
+			r.Pattern = strings.TrimSpace(r.Pattern)
+		}
+	}
+	if in.Action != nil {
+		r.Action = *in.Action
+	}
+	if in.Enabled != nil {
+		r.Enabled = *in.Enabled
+	}
+
+	if r.Name == "" || utf8.RuneCountInString(r.Name) > maxRuleName {
+		return InvalidInput("name is required and must be at most %d characters", maxRuleName)
+	}
+	if r.Kind != RuleKeyword && r.Kind != RuleRegex {
+		return InvalidInput("kind must be %s or %s", RuleKeyword, RuleRegex)
+	}
+	if r.Action != RuleFlag && r.Action != RuleHide {
+		return InvalidInput("action must be %s or %s", RuleFlag, RuleHide)
+	}
+	if r.Pattern == "" || utf8.RuneCountInString(r.Pattern) > maxRulePattern {
+		return InvalidInput("pattern is required and must be at most %d characters", maxRulePattern)
+	}
+	if _, err := r.compile(); err != nil {
+		return InvalidInput("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// ruleChanges returns the fields that differ between two versions of a
+// rule, either of which may be nil.
+func ruleChanges(before, after *ModerationRule) map[string]interface{} {
+	fields := func(r *ModerationRule) map[string]interface{} {
+		if r == nil {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{
+			"name": r.Name, "kind": r.Kind, "pattern": r.Pattern, "action": r.Action, "enabled": r.Enabled,
+		}
+	}
+	from, to := fields(before), fields(after)
+	changes := map[string]interface{}{}
+	for _, k := range []string{"name", "kind", "pattern", "action", "enabled"} {
+		if from[k] != to[k] {
+			changes[k] = map[string]interface{}{"from": from[k], "to": to[k]}
+		}
+	}
+	return changes
+}
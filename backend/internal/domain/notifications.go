@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Notification is a message addressed to a single user.
+type Notification struct {
+	ID           string     `json:"id"`
+	TenantID     string     `json:"tenant_id"`
+	UserID       string     `json:"user_id"`
+	Kind         string     `json:"kind"`
+	Title        string     `json:"title"`
+	Body         string     `json:"body,omitempty"`
+	ActorID      string     `json:"actor_id,omitempty"`
+	ResourceType string     `json:"resource_type,omitempty"`
+	ResourceID   string     `json:"resource_id,omitempty"`
+	ReadAt       *time.Time `json:"read_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// NotificationRepository persists the in-app notification inbox.
+type NotificationRepository interface {
+	Create(ctx context.Context, n *Notification) error
+	List(ctx context.Context, tenantID, userID string, unreadOnly bool, params ListParams) ([]Notification, int, error)
+	MarkRead(ctx context.Context, tenantID, userID, id string, at time.Time) error
+}
+
+// NotificationChannel delivers a stored notification through an
+// additional medium such as the realtime stream or e-mail.
+type NotificationChannel interface {
+	Name() string
+	Deliver(ctx context.Context, n Notification) error
+}
+
+// NotificationService stores notifications in the user's inbox and fans
+// them out to the registered channels.
+type NotificationService struct {
+	repo        NotificationRepository
+	channels    []NotificationChannel
+	preferences *PreferenceService
+}
+
+// NewNotificationService creates a notification service.
+func NewNotificationService(repo NotificationRepository, channels ...NotificationChannel) *NotificationService {
+	return &NotificationService{repo: repo, channels: channels}
+}
+
+// AddChannel registers another delivery channel.
+func (s *NotificationService) AddChannel(ch NotificationChannel) {
+	s.channels = append(s.channels, ch)
+}
+
+// UsePreferences lets users opt out of notification kinds. A kind is
+// muted when the boolean preference "notifications.<kind>" is false.
+func (s *NotificationService) UsePreferences(p *PreferenceService) {
+	s.preferences = p
+}
+
+// Notify stores the notification and delivers it through every channel.
+// Channel failures are logged; the inbox entry is the source of truth.
+func (s *NotificationService) Notify(ctx context.Context, n Notification) error {
+	if s.muted(ctx, n) {
+		return nil
+	}
+	n.ID = NewID()
+	n.CreatedAt = time.Now().UTC()
+	if err := s.repo.Create(ctx, &n); err != nil {
+		return err
+	}
+	for _, ch := range s.channels {
+		if err := ch.Deliver(ctx, n); err != nil {
+			log.Printf("notification channel %s failed for %s: %v", ch.Name(), n.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *NotificationService) muted(ctx context.Context, n Notification) bool {
+	if s.preferences == nil {
+		return false
+	}
+	key := "notifications." + n.Kind
+	if _, ok := s.preferences.registry.Lookup(key); !ok {
+		return false
+	}
+	enabled, err := s.preferences.Value(ctx, n.TenantID, n.UserID, key)
+	if err != nil {
+		log.Printf("read preference %s for %s: %v", key, n.UserID, err)
+		return false
+	}
+	return enabled == false
+}
+
+// List returns a page of the user's notifications, newest first.
+func (s *NotificationService) List(ctx context.Context, actor Identity, unreadOnly bool, params ListParams) ([]Notification, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, actor.UserID, unreadOnly, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// MarkRead marks one of the user's notifications as read.
+func (s *NotificationService) MarkRead(ctx context.Context, actor Identity, id string) error {
+	return s.repo.MarkRead(ctx, actor.TenantID, actor.UserID, id, time.Now().UTC())
+}
@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// JobSendNotificationEmail e-mails one notification.
+const JobSendNotificationEmail = "notifications.email"
+
+// EmailPreferenceKeys lets users opt in to notifications by e-mail.
+var EmailPreferenceKeys = []PreferenceKey{
+	{
+		Key:         "notifications.email",
+		Type:        PreferenceBool,
+		Default:     false,
+		Description: "Also send my notifications to me by e-mail",
+	},
+}
+
+// EmailNotifications delivers notifications by e-mail to the users who
+// opted in. With reply addresses, e-mails about a resource can be
+// answered to comment on it.
+type EmailNotifications struct {
+	users       *UserService
+	preferences *PreferenceService
+	mailer      Mailer
+	jobs        *JobQueue
+	appURL      string
+	replies     *InboundAddresses
+}
+
+// NewEmailNotifications creates the e-mail notification channel. Mail is
+// sent from a background job.
+func NewEmailNotifications(users *UserService, preferences *PreferenceService, mailer Mailer, jobs *JobQueue, appURL string) *EmailNotifications {
+	c := &EmailNotifications{users: users, preferences: preferences, mailer: mailer, jobs: jobs, appURL: appURL}
+	jobs.Register(JobSendNotificationEmail, c.handleSend)
+	return c
+}
+
+// UseReplyAddresses sets a signed reply address on e-mails about a
+// resource.
+func (c *EmailNotifications) UseReplyAddresses(addresses *InboundAddresses) {
+	c.replies = addresses
+}
+
+func (c *EmailNotifications) Name() string { return "email" }
+
+func (c *EmailNotifications) Deliver(ctx context.Context, n Notification) error {
+	enabled, err := c.preferences.Value(ctx, n.TenantID, n.UserID, "notifications.email")
+	if err != nil || enabled != true {
+		return err
+	}
+	_, err = c.jobs.Enqueue(ctx, JobSendNotificationEmail, n)
+	return err
+}
+
+func (c *EmailNotifications) handleSend(ctx context.Context, job Job) error {
+	var n Notification
+	if err := json.Unmarshal(job.Payload, &n); err != nil {
+		return err
+	}
+	user, err := c.users.Get(ctx, n.TenantID, n.UserID)
+	var appErr AppError
+	if errors.As(err, &appErr) && appErr.Code == ErrNotFound.Code {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return nil
+	}
+
+	msg := Email{To: []string{user.Email}, Subject: n.Title}
+	var text strings.Builder
+	if c.replies != nil && n.ResourceType != "" && n.ResourceID != "" {
+		msg.ReplyTo = c.replies.Reply(n.TenantID, n.UserID, n.ResourceType, n.ResourceID)
+		text.WriteString(ReplyAboveMarker + "\n\n")
+	}
+	text.WriteString(n.Title + "\n")
+	if n.Body != "" {
+		text.WriteString("\n" + n.Body + "\n")
+	}
+	text.WriteString("\nOpen the app: " + c.appURL + "\n")
+	if msg.ReplyTo != "" {
+		text.WriteString("Reply to this e-mail to comment; attachments are added to your files.\n")
+	}
+	msg.Text = text.String()
+	return c.mailer.Send(ctx, msg)
+}
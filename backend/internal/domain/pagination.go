@@ -0,0 +1,55 @@
+package domain
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// ListParams are the page-based pagination parameters accepted by list
+// endpoints.
+type ListParams struct {
+	Page  int `form:"page"`
+	Limit int `form:"limit"`
+}
+
+// Normalize clamps the parameters to sane values.
+func (p ListParams) Normalize() ListParams {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Limit < 1 {
+		p.Limit = defaultPageLimit
+	}
+	if p.Limit > maxPageLimit {
+		p.Limit = maxPageLimit
+	}
+	return p
+}
+
+// Offset returns the number of rows to skip for the current page.
+func (p ListParams) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// PaginationMeta describes a page of results.
+type PaginationMeta struct {
+	Total      int  `json:"total"`
+	Page       int  `json:"page"`
+	Limit      int  `json:"limit"`
+	TotalPages int  `json:"total_pages"`
+	HasNext    bool `json:"has_next"`
+	HasPrev    bool `json:"has_prev"`
+}
+
+// NewPaginationMeta computes the metadata for a page of a result set.
+func NewPaginationMeta(params ListParams, total int) PaginationMeta {
+	totalPages := (total + params.Limit - 1) / params.Limit
+	return PaginationMeta{
+		Total:      total,
+		Page:       params.Page,
+		Limit:      params.Limit,
+		TotalPages: totalPages,
+		HasNext:    params.Page < totalPages,
+		HasPrev:    params.Page > 1,
+	}
+}
@@ -0,0 +1,754 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Policy version states
+const (
+	// PolicyDraft versions are still being written; only admins see them.
+	PolicyDraft = "draft"
+	// PolicyPublished versions are final. Each becomes the current version
+	// of its kind when it takes effect.
+	PolicyPublished = "published"
+)
+
+const (
+	maxPolicyVersion = 50
+	maxPolicyTitle   = 200
+	maxPolicySummary = 2000
+	maxPolicyBody    = 200 << 10
+
+	// policyCacheTTL is how long the published versions of a tenant are
+	// cached. Versions published on another instance are enforced here
+	// after at most this long.
+	policyCacheTTL = 30 * time.Second
+)
+
+var policyKindPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+var (
+	// ErrPolicyAcceptanceRequired is the answer of authenticated routes,
+	// other than the policy routes, while the caller has yet to accept the
+	// current version of a policy. Its details list the versions to accept.
+	ErrPolicyAcceptanceRequired = AppError{
+		Status:  http.StatusForbidden,
+		Code:    "POLICY_ACCEPTANCE_REQUIRED",
+		Message: "You must accept the updated terms to continue",
+	}
+
+	ErrPolicyPublished = AppError{
+		Status:  http.StatusConflict,
+		Code:    "POLICY_PUBLISHED",
+		Message: "Published policy versions cannot be changed",
+	}
+
+	ErrPolicySuperseded = AppError{
+		Status:  http.StatusConflict,
+		Code:    "POLICY_SUPERSEDED",
+		Message: "A newer version of the policy is in effect",
+	}
+)
+
+// PolicyVersion is a version of a legal document, such as the terms of
+// service (kind "terms") or the privacy policy ("privacy"). The latest
+// published version in effect is the current version of its kind.
+// Material versions must be accepted again: users are held up until they
+// accepted the latest material version in effect, or a later version.
+// Other versions, such as corrections, only need accepting by users who
+// never accepted the kind.
+type PolicyVersion struct {
+	ID          string     `json:"id"`
+	TenantID    string     `json:"tenant_id"`
+	Kind        string     `json:"kind"`
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	Summary     string     `json:"summary,omitempty"`
+	Material    bool       `json:"material"`
+	Status      string     `json:"status"`
+	EffectiveAt *time.Time `json:"effective_at,omitempty"`
+	PublishedBy string     `json:"published_by,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ref returns the summary of the version shown where its text is not
+// needed.
+func (p PolicyVersion) ref() PolicyRef {
+	return PolicyRef{ID: p.ID, Kind: p.Kind, Version: p.Version, Title: p.Title, Summary: p.Summary, EffectiveAt: p.EffectiveAt}
+}
+
+// PolicyRef names a policy version.
+type PolicyRef struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	Summary     string     `json:"summary,omitempty"`
+	EffectiveAt *time.Time `json:"effective_at,omitempty"`
+}
+
+// PolicyVersionInput creates or updates a draft; absent fields keep
+// their value. Material defaults to true.
+type PolicyVersionInput struct {
+	Kind     *string `json:"kind"`
+	Version  *string `json:"version"`
+	Title    *string `json:"title"`
+	Body     *string `json:"body"`
+	Summary  *string `json:"summary"`
+	Material *bool   `json:"material"`
+}
+
+// PolicyPublishInput publishes a draft, taking effect right away or at
+// EffectiveAt.
+type PolicyPublishInput struct {
+	EffectiveAt *time.Time `json:"effective_at"`
+}
+
+// PolicyFilter narrows the versions admins list.
+type PolicyFilter struct {
+	Kind   string `form:"kind"`
+	Status string `form:"status"`
+}
+
+// PolicyAcceptance records that a user accepted a policy version.
+type PolicyAcceptance struct {
+	ID         string    `json:"id"`
+	TenantID   string    `json:"tenant_id"`
+	UserID     string    `json:"user_id"`
+	PolicyID   string    `json:"policy_id"`
+	Kind       string    `json:"kind"`
+	Version    string    `json:"version"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	AcceptedAt time.Time `json:"accepted_at"`
+}
+
+// PolicyStatus is a kind of policy as it stands for the caller.
+type PolicyStatus struct {
+	Kind    string         `json:"kind"`
+	Current *PolicyVersion `json:"current,omitempty"`
+	// Upcoming is published but not in effect yet. It may be accepted
+	// ahead of time.
+	Upcoming *PolicyVersion `json:"upcoming,omitempty"`
+	// Accepted is the caller's acceptance of the latest version they
+	// accepted.
+	Accepted *PolicyAcceptance `json:"accepted,omitempty"`
+	// Required is set while the caller must accept Current to continue.
+	Required bool `json:"required"`
+}
+
+// PolicyReport shows how many of the tenant's users accepted each kind
+// of policy and each of its published versions.
+type PolicyReport struct {
+	Users       int                `json:"users"`
+	Kinds       []PolicyKindReport `json:"kinds"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
+
+// PolicyKindReport counts the users who may continue, having accepted
+// the required version or a later one.
+type PolicyKindReport struct {
+	Kind            string               `json:"kind"`
+	CurrentVersion  string               `json:"current_version,omitempty"`
+	RequiredVersion string               `json:"required_version,omitempty"`
+	Compliant       int                  `json:"compliant"`
+	Rate            float64              `json:"rate"`
+	Versions        []PolicyVersionStats `json:"versions"`
+}
+
+// PolicyVersionStats counts the acceptances of a published version.
+type PolicyVersionStats struct {
+	ID          string     `json:"id"`
+	Version     string     `json:"version"`
+	Material    bool       `json:"material"`
+	EffectiveAt *time.Time `json:"effective_at"`
+	Acceptances int        `json:"acceptances"`
+	Rate        float64    `json:"rate"`
+}
+
+// PolicyRepository persists policy versions and their acceptances.
+type PolicyRepository interface {
+	Create(ctx context.Context, p *PolicyVersion) error
+	Get(ctx context.Context, tenantID, id string) (*PolicyVersion, error)
+	Update(ctx context.Context, p *PolicyVersion) error
+	Delete(ctx context.Context, tenantID, id string) error
+	List(ctx context.Context, tenantID string, f PolicyFilter, params ListParams) ([]PolicyVersion, int, error)
+	// Published returns the published versions of every kind in the order
+	// they take effect.
+	Published(ctx context.Context, tenantID string) ([]PolicyVersion, error)
+
+	// Accept records an acceptance, unless the user already accepted the
+	// version.
+	Accept(ctx context.Context, a *PolicyAcceptance) error
+	// Acceptances returns a user's acceptances, the latest first.
+	Acceptances(ctx context.Context, tenantID, userID string) ([]PolicyAcceptance, error)
+	VersionAcceptances(ctx context.Context, tenantID, policyID string, params ListParams) ([]PolicyAcceptance, int, error)
+	// CountAcceptances returns the number of acceptances of each version.
+	CountAcceptances(ctx context.Context, tenantID string) (map[string]int, error)
+	// CountAccepted counts the users who accepted any of the versions.
+	CountAccepted(ctx context.Context, tenantID string, policyIDs []string) (int, error)
+	CountUsers(ctx context.Context, tenantID string) (int, error)
+}
+
+// PolicyService manages versioned legal documents and records which
+// versions users accepted. Admins write and publish versions; changes are
+// recorded in the audit log.
+type PolicyService struct {
+	repo  PolicyRepository
+	audit *AuditService
+
+	mu        sync.Mutex
+	published map[string]publishedPolicies
+	// satisfied maps tenant/user to the required versions the user was
+	// last found to have accepted.
+	satisfied map[string]string
+}
+
+type publishedPolicies struct {
+	versions []PolicyVersion
+	loadedAt time.Time
+}
+
+// NewPolicyService creates a policy service.
+func NewPolicyService(repo PolicyRepository, audit *AuditService) *PolicyService {
+	return &PolicyService{
+		repo:      repo,
+		audit:     audit,
+		published: make(map[string]publishedPolicies),
+		satisfied: make(map[string]string),
+	}
+}
+
+// policyKind is what the published versions of a kind mean at a time.
+type policyKind struct {
+	name     string
+	versions []PolicyVersion
+	// current is the index of the version in effect and required that of
+	// the latest material one; -1 when there is none.
+	current, required int
+}
+
+func (k policyKind) index(id string) int {
+	for i, v := range k.versions {
+		if v.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// kinds groups the published versions of the tenant by kind, sorted by
+// name.
+func (s *PolicyService) kinds(ctx context.Context, tenantID string, now time.Time) ([]policyKind, error) {
+	s.mu.Lock()
+	cached, ok := s.published[tenantID]
+	s.mu.Unlock()
+	if !ok || now.Sub(cached.loadedAt) > policyCacheTTL {
+		versions, err := s.repo.Published(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		cached = publishedPolicies{versions: versions, loadedAt: now}
+		s.mu.Lock()
+		s.published[tenantID] = cached
+		s.mu.Unlock()
+	}
+
+	byName := map[string]*policyKind{}
+	var kinds []*policyKind
+	for _, v := range cached.versions {
+		k, ok := byName[v.Kind]
+		if !ok {
+			k = &policyKind{name: v.Kind, current: -1, required: -1}
+			byName[v.Kind] = k
+			kinds = append(kinds, k)
+		}
+		k.versions = append(k.versions, v)
+		if !v.EffectiveAt.After(now) {
+			k.current = len(k.versions) - 1
+			if v.Material {
+				k.required = k.current
+			}
+		}
+	}
+	out := make([]policyKind, 0, len(kinds))
+	for _, k := range kinds {
+		out = append(out, *k)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// forgetPublished drops the cached versions of a tenant after a publish.
+func (s *PolicyService) forgetPublished(tenantID string) {
+	s.mu.Lock()
+	delete(s.published, tenantID)
+	s.mu.Unlock()
+}
+
+// pending returns the current versions the user must accept to continue.
+func (s *PolicyService) pending(ctx context.Context, actor Identity) ([]PolicyRef, error) {
+	kinds, err := s.kinds(ctx, actor.TenantID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	var required []string
+	for _, k := range kinds {
+		if k.required >= 0 {
+			required = append(required, k.versions[k.required].ID)
+		}
+	}
+	if len(required) == 0 {
+		return nil, nil
+	}
+	key := actor.TenantID + "/" + actor.UserID
+	gates := strings.Join(required, ",")
+	s.mu.Lock()
+	satisfied := s.satisfied[key] == gates
+	s.mu.Unlock()
+	if satisfied {
+		return nil, nil
+	}
+
+	accepted, err := s.repo.Acceptances(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []PolicyRef
+	for _, k := range kinds {
+		if mustAccept(k, accepted) {
+			pending = append(pending, k.versions[k.current].ref())
+		}
+	}
+	if len(pending) == 0 {
+		s.mu.Lock()
+		s.satisfied[key] = gates
+		s.mu.Unlock()
+	}
+	return pending, nil
+}
+
+// mustAccept reports whether a user with the acceptances is held up by
+// the kind.
+func mustAccept(k policyKind, accepted []PolicyAcceptance) bool {
+	if k.required < 0 {
+		return false
+	}
+	for _, a := range accepted {
+		if a.Kind == k.name && k.index(a.PolicyID) >= k.required {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAccepted fails with ErrPolicyAcceptanceRequired while the caller
+// has yet to accept the current version of a policy.
+func (s *PolicyService) CheckAccepted(ctx context.Context, actor Identity) error {
+	pending, err := s.pending(ctx, actor)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	return ErrPolicyAcceptanceRequired.WithDetails(map[string]interface{}{"policies": pending})
+}
+
+// Current returns every kind of policy with its current and upcoming
+// versions and whether the caller must accept it.
+func (s *PolicyService) Current(ctx context.Context, actor Identity) ([]PolicyStatus, error) {
+	kinds, err := s.kinds(ctx, actor.TenantID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	accepted, err := s.repo.Acceptances(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]PolicyStatus, 0, len(kinds))
+	for _, k := range kinds {
+		st := PolicyStatus{Kind: k.name, Required: mustAccept(k, accepted)}
+		if k.current >= 0 {
+			st.Current = &k.versions[k.current]
+		}
+		if next := k.current + 1; next < len(k.versions) {
+			st.Upcoming = &k.versions[next]
+		}
+		latest := -1
+		for i, a := range accepted {
+			if a.Kind == k.name && k.index(a.PolicyID) > latest {
+				latest = k.index(a.PolicyID)
+				st.Accepted = &accepted[i]
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Get returns a version. Drafts are only found by admins.
+func (s *PolicyService) Get(ctx context.Context, actor Identity, id string) (*PolicyVersion, error) {
+	p, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PolicyPublished && !actor.IsAdmin() {
+		return nil, NotFound("Policy")
+	}
+	return p, nil
+}
+
+// Accept records that the caller accepted a version, from the given
+// address and user agent. Accepting a version again returns the first
+// acceptance. Versions superseded by one in effect cannot be accepted.
+func (s *PolicyService) Accept(ctx context.Context, actor Identity, id, ip, userAgent string) (*PolicyAcceptance, error) {
+	p, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PolicyPublished {
+		return nil, InvalidInput("drafts cannot be accepted")
+	}
+	accepted, err := s.repo.Acceptances(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range accepted {
+		if accepted[i].PolicyID == id {
+			return &accepted[i], nil
+		}
+	}
+	now := time.Now().UTC()
+	kinds, err := s.kinds(ctx, actor.TenantID, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range kinds {
+		if i := k.index(id); i >= 0 && i < k.current {
+			return nil, ErrPolicySuperseded.WithDetails(map[string]interface{}{"current": k.versions[k.current].ref()})
+		}
+	}
+
+	a := &PolicyAcceptance{
+		ID:         NewID(),
+		TenantID:   actor.TenantID,
+		UserID:     actor.UserID,
+		PolicyID:   p.ID,
+		Kind:       p.Kind,
+		Version:    p.Version,
+		IPAddress:  ip,
+		UserAgent:  truncateRunes(userAgent, 255),
+		AcceptedAt: now,
+	}
+	if err := s.repo.Accept(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Acceptances returns the caller's acceptances, the latest first.
+func (s *PolicyService) Acceptances(ctx context.Context, actor Identity) ([]PolicyAcceptance, error) {
+	return s.repo.Acceptances(ctx, actor.TenantID, actor.UserID)
+}
+
+// List returns the versions of the tenant, drafts included, for admins.
+func (s *PolicyService) List(ctx context.Context, actor Identity, f PolicyFilter, params ListParams) ([]PolicyVersion, PaginationMeta, error) {
+	if !actor.IsAdmin() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	if f.Status != "" && f.Status != PolicyDraft && f.Status != PolicyPublished {
+		return nil, PaginationMeta{}, InvalidInput("status must be %s or %s", PolicyDraft, PolicyPublished)
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.List(ctx, actor.TenantID, f, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Create adds a draft. Kind, version, title and body are required.
+func (s *PolicyService) Create(ctx context.Context, actor Identity, in PolicyVersionInput) (*PolicyVersion, error) {
+	if !actor.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	now := time.Now().UTC()
+	p := &PolicyVersion{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		Material:  true,
+		Status:    PolicyDraft,
+		CreatedBy: actor.UserID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := applyPolicyInput(p, in); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, p); err != nil {
+		return nil, policyConflict(err, p)
+	}
+	s.record(ctx, actor, "policy.create", p.ID, policyChanges(nil, p))
+	return p, nil
+}
+
+// Update changes the given fields of a draft.
+func (s *PolicyService) Update(ctx context.Context, actor Identity, id string, in PolicyVersionInput) (*PolicyVersion, error) {
+	if !actor.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	p, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PolicyDraft {
+		return nil, ErrPolicyPublished
+	}
+	before := *p
+	if err := applyPolicyInput(p, in); err != nil {
+		return nil, err
+	}
+	changes := policyChanges(&before, p)
+	if len(changes) == 0 {
+		return p, nil
+	}
+	p.UpdatedAt = time.Now().UTC()
+	if err := s.repo.Update(ctx, p); err != nil {
+		return nil, policyConflict(err, p)
+	}
+	s.record(ctx, actor, "policy.update", p.ID, changes)
+	return p, nil
+}
+
+// Delete removes a draft. Published versions stay, as acceptances refer
+// to them.
+func (s *PolicyService) Delete(ctx context.Context, actor Identity, id string) error {
+	if !actor.IsAdmin() {
+		return ErrForbidden
+	}
+	p, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return err
+	}
+	if p.Status != PolicyDraft {
+		return ErrPolicyPublished
+	}
+	if err := s.repo.Delete(ctx, actor.TenantID, id); err != nil {
+		return err
+	}
+	s.record(ctx, actor, "policy.delete", id, policyChanges(p, nil))
+	return nil
+}
+
+// Publish makes a draft final. It takes effect right away, or at a later
+// time given, but not before a version of its kind published earlier.
+func (s *PolicyService) Publish(ctx context.Context, actor Identity, id string, in PolicyPublishInput) (*PolicyVersion, error) {
+	if !actor.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	p, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PolicyDraft {
+		return nil, ErrPolicyPublished
+	}
+	now := time.Now().UTC()
+	effective := now
+	if in.EffectiveAt != nil {
+		if in.EffectiveAt.Before(now) {
+			return nil, InvalidInput("effective_at must not be in the past")
+		}
+		effective = in.EffectiveAt.UTC()
+	}
+	published, err := s.repo.Published(ctx, actor.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range published {
+		if v.Kind == p.Kind && v.EffectiveAt.After(effective) {
+			return nil, InvalidInput("version %s of %s takes effect at %s; this version cannot take effect before it",
+				v.Version, v.Kind, v.EffectiveAt.Format(time.RFC3339))
+		}
+	}
+
+	p.Status = PolicyPublished
+	p.EffectiveAt = &effective
+	p.PublishedBy = actor.UserID
+	p.PublishedAt = &now
+	p.UpdatedAt = now
+	if err := s.repo.Update(ctx, p); err != nil {
+		return nil, err
+	}
+	s.forgetPublished(actor.TenantID)
+	s.record(ctx, actor, "policy.publish", p.ID, map[string]interface{}{
+		"kind": p.Kind, "version": p.Version, "material": p.Material, "effective_at": effective,
+	})
+	return p, nil
+}
+
+// VersionAcceptances lists who accepted a version, the latest first.
+func (s *PolicyService) VersionAcceptances(ctx context.Context, actor Identity, id string, params ListParams) ([]PolicyAcceptance, PaginationMeta, error) {
+	if !actor.IsAdmin() {
+		return nil, PaginationMeta{}, ErrForbidden
+	}
+	if _, err := s.repo.Get(ctx, actor.TenantID, id); err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	params = params.Normalize()
+	items, total, err := s.repo.VersionAcceptances(ctx, actor.TenantID, id, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Report counts acceptances against the users in the tenant's directory.
+func (s *PolicyService) Report(ctx context.Context, actor Identity) (*PolicyReport, error) {
+	if !actor.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	now := time.Now().UTC()
+	s.forgetPublished(actor.TenantID)
+	kinds, err := s.kinds(ctx, actor.TenantID, now)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.repo.CountUsers(ctx, actor.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.repo.CountAcceptances(ctx, actor.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	rate := func(n int) float64 {
+		if users == 0 {
+			return 0
+		}
+		return float64(n) / float64(users)
+	}
+
+	report := &PolicyReport{Users: users, Kinds: []PolicyKindReport{}, GeneratedAt: now}
+	for _, k := range kinds {
+		kr := PolicyKindReport{Kind: k.name, Versions: []PolicyVersionStats{}}
+		if k.current >= 0 {
+			kr.CurrentVersion = k.versions[k.current].Version
+		}
+		if k.required >= 0 {
+			kr.RequiredVersion = k.versions[k.required].Version
+			var ids []string
+			for _, v := range k.versions[k.required:] {
+				ids = append(ids, v.ID)
+			}
+			if kr.Compliant, err = s.repo.CountAccepted(ctx, actor.TenantID, ids); err != nil {
+				return nil, err
+			}
+		} else {
+			// Nothing is required yet, so nobody is held up
+			kr.Compliant = users
+		}
+		kr.Rate = rate(kr.Compliant)
+		for _, v := range k.versions {
+			kr.Versions = append(kr.Versions, PolicyVersionStats{
+				ID: v.ID, Version: v.Version, Material: v.Material, EffectiveAt: v.EffectiveAt,
+				Acceptances: counts[v.ID], Rate: rate(counts[v.ID]),
+			})
+		}
+		report.Kinds = append(report.Kinds, kr)
+	}
+	return report, nil
+}
+
+func applyPolicyInput(p *PolicyVersion, in PolicyVersionInput) error {
+	if in.Kind != nil {
+		p.Kind = strings.ToLower(strings.TrimSpace(*in.Kind))
+	}
+	if in.Version != nil {
+		p.Version = strings.TrimSpace(*in.Version)
+	}
+	if in.Title != nil {
+		p.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Body != nil {
+		p.Body = strings.TrimSpace(*in.Body)
+	}
+	if in.Summary != nil {
+		p.Summary = strings.TrimSpace(*in.Summary)
+	}
+	if in.Material != nil {
+		p.Material = *in.Material
+	}
+
+	if !policyKindPattern.MatchString(p.Kind) {
+		return InvalidInput("kind must be a lowercase name such as terms or privacy")
+	}
+	if p.Version == "" || utf8.RuneCountInString(p.Version) > maxPolicyVersion {
+		return InvalidInput("version is required and must be at most %d characters", maxPolicyVersion)
+	}
+	if p.Title == "" || utf8.RuneCountInString(p.Title) > maxPolicyTitle {
+		return InvalidInput("title is required and must be at most %d characters", maxPolicyTitle)
+	}
+	if p.Body == "" || len(p.Body) > maxPolicyBody {
+		return InvalidInput("body is required and must be at most %d bytes", maxPolicyBody)
+	}
+	if utf8.RuneCountInString(p.Summary) > maxPolicySummary {
+		return InvalidInput("summary must be at most %d characters", maxPolicySummary)
+	}
+	return nil
+}
+
+// policyConflict explains a clash with an existing version of the kind.
+func policyConflict(err error, p *PolicyVersion) error {
+	var appErr AppError
+	if errors.As(err, &appErr) && appErr.Code == ErrConflict.Code {
+		appErr.Message = "Version " + p.Version + " of " + p.Kind + " already exists"
+		return appErr
+	}
+	return err
+}
+
+// policyChanges returns the fields that differ between two versions of a
+// draft, either of which may be nil. Bodies are compared, not recorded.
+func policyChanges(before, after *PolicyVersion) map[string]interface{} {
+	fields := func(p *PolicyVersion) map[string]interface{} {
+		if p == nil {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{
+			"kind": p.Kind, "version": p.Version, "title": p.Title, "summary": p.Summary, "material": p.Material,
+			"body_bytes": len(p.Body),
+		}
+	}
+	from, to := fields(before), fields(after)
+	changes := map[string]interface{}{}
+	for _, k := range []string{"kind", "version", "title", "summary", "material", "body_bytes"} {
+		if from[k] != to[k] {
+			changes[k] = map[string]interface{}{"from": from[k], "to": to[k]}
+		}
+	}
+	if before != nil && after != nil && before.Body != after.Body {
+		changes["body"] = "changed"
+	}
+	return changes
+}
+
+func (s *PolicyService) record(ctx context.Context, actor Identity, action, id string, changes map[string]interface{}) {
+	if err := s.audit.Record(ctx, actor, action, "policy", id, changes); err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
@@ -0,0 +1,399 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PreferenceType is the value type of a preference key.
+type PreferenceType string
+
+const (
+	PreferenceBool   PreferenceType = "bool"
+	PreferenceString PreferenceType = "string"
+	PreferenceInt    PreferenceType = "int"
+	PreferenceEnum   PreferenceType = "enum"
+	PreferenceObject PreferenceType = "object"
+)
+
+var ErrInvalidPreferences = AppError{
+	Status:  http.StatusBadRequest,
+	Code:    "INVALID_PREFERENCES",
+	Message: "One or more preferences are invalid",
+}
+
+// PreferencesChangedData is the data of preferences.changed.
+type PreferencesChangedData struct {
+	UserID string   `json:"user_id"`
+	Keys   []string `json:"keys" doc:"Keys that were set or reset"`
+}
+
+// PreferenceDefaultsChangedData is the data of preferences.defaults_changed.
+type PreferenceDefaultsChangedData struct {
+	Keys []string `json:"keys" doc:"Keys whose tenant default was set or reset"`
+}
+
+// PreferenceEventSchemas describes the events published by
+// PreferenceService.
+var PreferenceEventSchemas = []MessageSchema{
+	{
+		Name:    "preferences.changed",
+		Version: 1,
+		Summary: "A user changed their preferences",
+		Channel: ChannelEvents,
+		Payload: SchemaOf(PreferencesChangedData{}),
+	},
+	{
+		Name:    "preferences.defaults_changed",
+		Version: 1,
+		Summary: "An admin changed the tenant's preference defaults",
+		Channel: ChannelEvents,
+		Payload: SchemaOf(PreferenceDefaultsChangedData{}),
+	},
+}
+
+// PreferenceKey declares a user preference. Modules register the keys they
+// own with the registry; only registered keys can be read or written.
+type PreferenceKey struct {
+	Key         string         `json:"key"`
+	Type        PreferenceType `json:"type"`
+	Default     interface{}    `json:"default"`
+	Description string         `json:"description,omitempty"`
+	Options     []string       `json:"options,omitempty"`
+	Min         *int           `json:"min,omitempty"`
+	Max         *int           `json:"max,omitempty"`
+	MaxLength   int            `json:"max_length,omitempty"`
+
+	// Validate optionally checks a value after the type checks passed.
+	Validate func(value interface{}) error `json:"-"`
+}
+
+// PreferenceRegistry is the schema of all known preference keys.
+type PreferenceRegistry struct {
+	mu   sync.RWMutex
+	keys map[string]PreferenceKey
+}
+
+// NewPreferenceRegistry creates an empty registry.
+func NewPreferenceRegistry() *PreferenceRegistry {
+	return &PreferenceRegistry{keys: make(map[string]PreferenceKey)}
+}
+
+// Register adds keys to the registry. It panics if a key is registered
+// twice or its default does not satisfy its own schema, since both are
+// programming errors.
+func (r *PreferenceRegistry) Register(keys ...PreferenceKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range keys {
+		if _, dup := r.keys[k.Key]; dup {
+			panic("preferences: key registered twice: " + k.Key)
+		}
+		if _, err := k.check(k.Default); err != nil {
+			panic(fmt.Sprintf("preferences: invalid default for %s: %v", k.Key, err))
+		}
+		r.keys[k.Key] = k
+	}
+}
+
+// Lookup returns the declaration of a key.
+func (r *PreferenceRegistry) Lookup(key string) (PreferenceKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k, ok := r.keys[key]
+	return k, ok
+}
+
+// Keys returns all declared keys sorted by name.
+func (r *PreferenceRegistry) Keys() []PreferenceKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]PreferenceKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys
+}
+
+// validatePatch checks a partial update against the schema. A nil value
+// means "reset to default". It returns the normalized values or an error
+// listing every invalid key.
+func (r *PreferenceRegistry) validatePatch(patch map[string]json.RawMessage) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(patch))
+	problems := make(map[string]interface{})
+	for key, raw := range patch {
+		k, ok := r.Lookup(key)
+		if !ok {
+			problems[key] = "unknown preference"
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			problems[key] = "invalid JSON value"
+			continue
+		}
+		if v == nil {
+			values[key] = nil
+			continue
+		}
+		normalized, err := k.check(v)
+		if err != nil {
+			problems[key] = err.Error()
+			continue
+		}
+		values[key] = normalized
+	}
+	if len(problems) > 0 {
+		return nil, ErrInvalidPreferences.WithDetails(problems)
+	}
+	return values, nil
+}
+
+// check validates a decoded JSON value and normalizes numbers to int.
+func (k PreferenceKey) check(v interface{}) (interface{}, error) {
+	switch k.Type {
+	case PreferenceBool:
+		if _, ok := v.(bool); !ok {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+	case PreferenceString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		if k.MaxLength > 0 && len(s) > k.MaxLength {
+			return nil, fmt.Errorf("must be at most %d characters", k.MaxLength)
+		}
+	case PreferenceInt:
+		var n int
+		switch num := v.(type) {
+		case int:
+			n = num
+		case float64:
+			if num != math.Trunc(num) {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			n = int(num)
+		default:
+			return nil, fmt.Errorf("must be an integer")
+		}
+		if k.Min != nil && n < *k.Min {
+			return nil, fmt.Errorf("must be at least %d", *k.Min)
+		}
+		if k.Max != nil && n > *k.Max {
+			return nil, fmt.Errorf("must be at most %d", *k.Max)
+		}
+		v = n
+	case PreferenceEnum:
+		s, ok := v.(string)
+		if !ok || !slices.Contains(k.Options, s) {
+			return nil, fmt.Errorf("must be one of %v", k.Options)
+		}
+	case PreferenceObject:
+		if _, ok := v.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("must be an object")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported preference type %q", k.Type)
+	}
+	if k.Validate != nil {
+		if err := k.Validate(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// CorePreferenceKeys are the frontend settings owned by the UI shell.
+var CorePreferenceKeys = []PreferenceKey{
+	{
+		Key:         "ui.theme",
+		Type:        PreferenceEnum,
+		Default:     "system",
+		Options:     []string{"light", "dark", "system"},
+		Description: "Color theme of the application",
+	},
+	{
+		Key:         "ui.table_layouts",
+		Type:        PreferenceObject,
+		Default:     map[string]interface{}{},
+		Description: "Column order, widths and visibility per table, keyed by table ID",
+	},
+	{
+		Key:         "ui.page_size",
+		Type:        PreferenceInt,
+		Default:     20,
+		Min:         intPtr(1),
+		Max:         intPtr(maxPageLimit),
+		Description: "Default number of rows shown by list views",
+	},
+}
+
+func intPtr(n int) *int { return &n }
+
+// PreferenceRepository persists user preferences and tenant defaults. Only
+// explicitly set values are stored.
+type PreferenceRepository interface {
+	UserValues(ctx context.Context, tenantID, userID string) (map[string]json.RawMessage, error)
+	TenantDefaults(ctx context.Context, tenantID string) (map[string]json.RawMessage, error)
+	SetUserValues(ctx context.Context, tenantID, userID string, values map[string]json.RawMessage, at time.Time) error
+	SetTenantDefaults(ctx context.Context, tenantID string, values map[string]json.RawMessage, at time.Time) error
+}
+
+// PreferenceService resolves and updates preferences. The effective value
+// of a key is the user's value, else the tenant default, else the default
+// declared in the registry.
+type PreferenceService struct {
+	registry *PreferenceRegistry
+	repo     PreferenceRepository
+	events   *EventBus
+}
+
+// NewPreferenceService creates a preference service.
+func NewPreferenceService(registry *PreferenceRegistry, repo PreferenceRepository, events *EventBus) *PreferenceService {
+	return &PreferenceService{registry: registry, repo: repo, events: events}
+}
+
+// Schema returns the declared keys.
+func (s *PreferenceService) Schema() []PreferenceKey {
+	return s.registry.Keys()
+}
+
+// ForUser returns the effective value of every key for the caller.
+func (s *PreferenceService) ForUser(ctx context.Context, actor Identity) (map[string]interface{}, error) {
+	return s.effective(ctx, actor.TenantID, actor.UserID)
+}
+
+// Value returns the effective value of one key for a user. Other modules
+// use it to honor settings such as notification choices.
+func (s *PreferenceService) Value(ctx context.Context, tenantID, userID, key string) (interface{}, error) {
+	values, err := s.effective(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := values[key]
+	if !ok {
+		return nil, NotFound("Preference " + key)
+	}
+	return v, nil
+}
+
+// UpdateForUser applies a partial update to the caller's preferences and
+// publishes preferences.changed. A null value resets a key to its default.
+func (s *PreferenceService) UpdateForUser(ctx context.Context, actor Identity, patch map[string]json.RawMessage) (map[string]interface{}, error) {
+	values, err := s.registry.validatePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetUserValues(ctx, actor.TenantID, actor.UserID, encodePreferenceValues(values), time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	s.events.Publish(ctx, Event{
+		Type:     "preferences.changed",
+		TenantID: actor.TenantID,
+		ActorID:  actor.UserID,
+		Data:     map[string]interface{}{"user_id": actor.UserID, "keys": sortedKeys(values)},
+	})
+	return s.effective(ctx, actor.TenantID, actor.UserID)
+}
+
+// TenantDefaults returns the defaults in effect for the caller's tenant.
+func (s *PreferenceService) TenantDefaults(ctx context.Context, actor Identity) (map[string]interface{}, error) {
+	return s.effective(ctx, actor.TenantID, "")
+}
+
+// UpdateTenantDefaults applies a partial update to the tenant defaults and
+// publishes preferences.defaults_changed. Only admins may change them.
+func (s *PreferenceService) UpdateTenantDefaults(ctx context.Context, actor Identity, patch map[string]json.RawMessage) (map[string]interface{}, error) {
+	if !actor.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	values, err := s.registry.validatePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetTenantDefaults(ctx, actor.TenantID, encodePreferenceValues(values), time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	s.events.Publish(ctx, Event{
+		Type:     "preferences.defaults_changed",
+		TenantID: actor.TenantID,
+		ActorID:  actor.UserID,
+		Data:     map[string]interface{}{"keys": sortedKeys(values)},
+	})
+	return s.effective(ctx, actor.TenantID, "")
+}
+
+// effective layers registry defaults, tenant defaults and, when userID is
+// set, the user's own values. Stored values of keys that are no longer
+// registered or no longer valid are ignored.
+func (s *PreferenceService) effective(ctx context.Context, tenantID, userID string) (map[string]interface{}, error) {
+	keys := s.registry.Keys()
+	values := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		values[k.Key] = k.Default
+	}
+
+	layers := make([]map[string]json.RawMessage, 0, 2)
+	defaults, err := s.repo.TenantDefaults(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, defaults)
+	if userID != "" {
+		own, err := s.repo.UserValues(ctx, tenantID, userID)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, own)
+	}
+
+	for _, layer := range layers {
+		for key, raw := range layer {
+			k, ok := s.registry.Lookup(key)
+			if !ok {
+				continue
+			}
+			var v interface{}
+			if json.Unmarshal(raw, &v) != nil {
+				continue
+			}
+			if normalized, err := k.check(v); err == nil {
+				values[key] = normalized
+			}
+		}
+	}
+	return values, nil
+}
+
+// encodePreferenceValues turns validated values into stored JSON; reset
+// keys map to nil.
+func encodePreferenceValues(values map[string]interface{}) map[string]json.RawMessage {
+	encoded := make(map[string]json.RawMessage, len(values))
+	for key, v := range values {
+		if v == nil {
+			encoded[key] = nil
+			continue
+		}
+		raw, _ := json.Marshal(v)
+		encoded[key] = raw
+	}
+	return encoded
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
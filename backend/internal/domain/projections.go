@@ -0,0 +1,405 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// LoggedEvent is an event as stored in the event log. Positions increase
+// by one per event within a database.
+type LoggedEvent struct {
+	Position int64 `json:"position"`
+	Event
+}
+
+// EventLog keeps every published event so read models can be rebuilt
+// from history.
+type EventLog interface {
+	Append(ctx context.Context, e Event) (int64, error)
+	// Read returns up to limit events after position, oldest first.
+	Read(ctx context.Context, after int64, limit int) ([]LoggedEvent, error)
+	Head(ctx context.Context) (int64, error)
+}
+
+// Projection maintains a denormalized read model from domain events.
+// Events are delivered in log order at least once, so Apply must ignore
+// events it has already applied.
+type Projection interface {
+	Name() string
+	Description() string
+	// Events lists the event type patterns the projection consumes, in
+	// the syntax of EventBus.Subscribe.
+	Events() []string
+	Apply(ctx context.Context, e LoggedEvent) error
+	// Reset empties the read model ahead of a rebuild.
+	Reset(ctx context.Context) error
+}
+
+// ProjectionCheckpoint records how far a projection has read the event
+// log of a database.
+type ProjectionCheckpoint struct {
+	Name       string
+	Position   int64
+	Rebuilding bool
+	LastError  string
+	FailedAt   *time.Time
+	RebuiltAt  *time.Time
+	UpdatedAt  time.Time
+}
+
+// ProjectionCheckpointRepository stores checkpoints. A checkpoint is
+// leased to one runner at a time so replicas and rebuilds never apply
+// the same projection concurrently.
+type ProjectionCheckpointRepository interface {
+	// Claim leases the checkpoint, creating it at position 0, and returns
+	// nil when another owner holds an unexpired lease.
+	Claim(ctx context.Context, name, owner string, until, now time.Time) (*ProjectionCheckpoint, error)
+	// Save stores the checkpoint and extends the lease. It fails with
+	// ErrConflict when the lease was lost.
+	Save(ctx context.Context, cp *ProjectionCheckpoint, owner string, until time.Time) error
+	Release(ctx context.Context, name, owner string) error
+	List(ctx context.Context) ([]ProjectionCheckpoint, error)
+}
+
+// Projection states reported by ProjectionStatus.
+const (
+	ProjectionCurrent    = "current"
+	ProjectionBehind     = "behind"
+	ProjectionFailing    = "failing"
+	ProjectionRebuilding = "rebuilding"
+)
+
+// ProjectionStatus reports how far a projection trails the event log.
+type ProjectionStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Events      []string   `json:"events"`
+	State       string     `json:"state" doc:"current, behind, failing or rebuilding"`
+	Position    int64      `json:"position" doc:"Position of the last event read"`
+	Head        int64      `json:"head" doc:"Position of the newest event in the log"`
+	Lag         int64      `json:"lag" doc:"Events in the log not read yet"`
+	LagSeconds  float64    `json:"lag_seconds" doc:"Age of the oldest event not read yet"`
+	LastError   string     `json:"last_error,omitempty"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"`
+	RebuiltAt   *time.Time `json:"rebuilt_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+const (
+	projectionBatch = 200
+	projectionPoll  = 5 * time.Second
+	projectionLease = 30 * time.Second
+)
+
+// ProjectionService keeps read models up to date with the event log of
+// the main database and of every tenant with a database of its own. Each
+// projection reads the log from its checkpoint; one that fails stops at
+// the failing event and retries it on the next pass.
+type ProjectionService struct {
+	log         EventLog
+	checkpoints ProjectionCheckpointRepository
+	owner       string
+	tenants     func(ctx context.Context) ([]string, error)
+	wake        chan struct{}
+	projections []Projection
+}
+
+// NewProjectionService creates a projection runner reading log.
+func NewProjectionService(log EventLog, checkpoints ProjectionCheckpointRepository, projections ...Projection) *ProjectionService {
+	return &ProjectionService{
+		log:         log,
+		checkpoints: checkpoints,
+		owner:       NewID(),
+		wake:        make(chan struct{}, 1),
+		projections: projections,
+	}
+}
+
+// UseTenants makes the runner also maintain the read models in the
+// databases of the tenants returned by tenants.
+func (s *ProjectionService) UseTenants(tenants func(ctx context.Context) ([]string, error)) {
+	s.tenants = tenants
+}
+
+// Watch wakes the runner whenever events publishes an event, instead of
+// waiting for the next poll.
+func (s *ProjectionService) Watch(events *EventBus) {
+	events.Subscribe("*", func(context.Context, Event) error {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+}
+
+// Projections returns the registered projections.
+func (s *ProjectionService) Projections() []Projection {
+	return append([]Projection(nil), s.projections...)
+}
+
+func (s *ProjectionService) projection(name string) (Projection, error) {
+	for _, p := range s.Projections() {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, NotFound("Projection")
+}
+
+// Run applies new events until ctx is cancelled.
+func (s *ProjectionService) Run(ctx context.Context) {
+	ticker := time.NewTicker(projectionPoll)
+	defer ticker.Stop()
+	for {
+		for _, dbctx := range s.databases(ctx) {
+			for _, p := range s.Projections() {
+				if err := s.catchUp(dbctx, p); err != nil {
+					log.Printf("projections: %s%s: %v", p.Name(), tenantSuffix(dbctx), err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// databases returns a context per database the read models live in.
+func (s *ProjectionService) databases(ctx context.Context) []context.Context {
+	dbs := []context.Context{ctx}
+	if s.tenants == nil {
+		return dbs
+	}
+	tenants, err := s.tenants(ctx)
+	if err != nil {
+		log.Printf("projections: listing tenants: %v", err)
+		return dbs
+	}
+	for _, id := range tenants {
+		dbs = append(dbs, WithTenant(ctx, id))
+	}
+	return dbs
+}
+
+func tenantSuffix(ctx context.Context) string {
+	if id := TenantFromContext(ctx); id != "" {
+		return " (tenant " + id + ")"
+	}
+	return ""
+}
+
+// catchUp applies the events after the checkpoint of p, unless another
+// runner holds it.
+func (s *ProjectionService) catchUp(ctx context.Context, p Projection) error {
+	now := time.Now().UTC()
+	cp, err := s.checkpoints.Claim(ctx, p.Name(), s.owner, now.Add(projectionLease), now)
+	if err != nil || cp == nil {
+		return err
+	}
+	defer s.checkpoints.Release(context.WithoutCancel(ctx), p.Name(), s.owner)
+	if cp.Rebuilding {
+		// A rebuild that was interrupted resumes where it stopped
+		return s.rebuild(ctx, p, cp, nil)
+	}
+	return s.apply(ctx, p, cp, nil)
+}
+
+// apply reads the log after cp in batches, saving the checkpoint after
+// each batch and when an event fails to apply.
+func (s *ProjectionService) apply(ctx context.Context, p Projection, cp *ProjectionCheckpoint, progress func(position int64)) error {
+	patterns := p.Events()
+	for {
+		events, err := s.log.Read(ctx, cp.Position, projectionBatch)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		var failed error
+		for _, e := range events {
+			if matchesAny(patterns, e.Type) {
+				if err := p.Apply(ctx, e); err != nil {
+					failed = fmt.Errorf("applying event %d (%s): %w", e.Position, e.Type, err)
+					break
+				}
+			}
+			cp.Position = e.Position
+		}
+		now := time.Now().UTC()
+		cp.UpdatedAt = now
+		if failed != nil {
+			cp.LastError, cp.FailedAt = failed.Error(), &now
+		} else {
+			cp.LastError, cp.FailedAt = "", nil
+		}
+		if err := s.checkpoints.Save(ctx, cp, s.owner, now.Add(projectionLease)); err != nil {
+			return err
+		}
+		if failed != nil {
+			return failed
+		}
+		if progress != nil {
+			progress(cp.Position)
+		}
+		if len(events) < projectionBatch {
+			return nil
+		}
+	}
+}
+
+func matchesAny(patterns []string, eventType string) bool {
+	for _, pattern := range patterns {
+		if matchEventType(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuild empties the named read model in the database of ctx and
+// replays the whole event log into it. It waits for a runner applying the
+// projection to finish its pass; progress, if set, is called after each
+// batch.
+func (s *ProjectionService) Rebuild(ctx context.Context, name string, progress func(position int64)) (*ProjectionStatus, error) {
+	p, err := s.projection(name)
+	if err != nil {
+		return nil, err
+	}
+	var cp *ProjectionCheckpoint
+	for {
+		now := time.Now().UTC()
+		if cp, err = s.checkpoints.Claim(ctx, name, s.owner, now.Add(projectionLease), now); err != nil {
+			return nil, err
+		}
+		if cp != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	defer s.checkpoints.Release(context.WithoutCancel(ctx), name, s.owner)
+
+	// Mark the rebuild before emptying the read model, so that a rebuild
+	// interrupted halfway is finished by the runner instead of the read
+	// model being left partial
+	cp.Position, cp.Rebuilding, cp.LastError, cp.FailedAt = 0, true, "", nil
+	now := time.Now().UTC()
+	cp.UpdatedAt = now
+	if err := s.checkpoints.Save(ctx, cp, s.owner, now.Add(projectionLease)); err != nil {
+		return nil, err
+	}
+	if err := p.Reset(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.rebuild(ctx, p, cp, progress); err != nil {
+		return nil, err
+	}
+	return s.Status(ctx, name)
+}
+
+// rebuild replays the log into a read model being rebuilt and clears the
+// rebuilding mark once it has caught up.
+func (s *ProjectionService) rebuild(ctx context.Context, p Projection, cp *ProjectionCheckpoint, progress func(position int64)) error {
+	if err := s.apply(ctx, p, cp, progress); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	cp.Rebuilding, cp.RebuiltAt, cp.UpdatedAt = false, &now, now
+	return s.checkpoints.Save(ctx, cp, s.owner, now.Add(projectionLease))
+}
+
+// Statuses reports every projection in the database of ctx.
+func (s *ProjectionService) Statuses(ctx context.Context) ([]ProjectionStatus, error) {
+	checkpoints, err := s.checkpoints.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	head, err := s.log.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]ProjectionCheckpoint, len(checkpoints))
+	for _, cp := range checkpoints {
+		byName[cp.Name] = cp
+	}
+	statuses := []ProjectionStatus{}
+	for _, p := range s.Projections() {
+		cp, ok := byName[p.Name()]
+		if !ok {
+			cp = ProjectionCheckpoint{Name: p.Name()}
+		}
+		st, err := s.status(ctx, p, cp, head)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Status reports the named projection in the database of ctx.
+func (s *ProjectionService) Status(ctx context.Context, name string) (*ProjectionStatus, error) {
+	statuses, err := s.Statuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i], nil
+		}
+	}
+	return nil, NotFound("Projection")
+}
+
+func (s *ProjectionService) status(ctx context.Context, p Projection, cp ProjectionCheckpoint, head int64) (ProjectionStatus, error) {
+	st := ProjectionStatus{
+		Name:        p.Name(),
+		Description: p.Description(),
+		Events:      p.Events(),
+		State:       ProjectionCurrent,
+		Position:    cp.Position,
+		Head:        head,
+		Lag:         max(head-cp.Position, 0),
+		LastError:   cp.LastError,
+		FailedAt:    cp.FailedAt,
+		RebuiltAt:   cp.RebuiltAt,
+	}
+	if !cp.UpdatedAt.IsZero() {
+		st.UpdatedAt = &cp.UpdatedAt
+	}
+	if st.Lag > 0 {
+		next, err := s.log.Read(ctx, cp.Position, 1)
+		if err != nil {
+			return st, err
+		}
+		if len(next) > 0 {
+			st.LagSeconds = time.Since(next[0].OccurredAt).Seconds()
+		}
+		st.State = ProjectionBehind
+	}
+	switch {
+	case cp.Rebuilding:
+		st.State = ProjectionRebuilding
+	case cp.LastError != "":
+		st.State = ProjectionFailing
+	}
+	return st, nil
+}
+
+// UseLog makes Publish append every event to log before delivering it.
+// Events that cannot be appended are still delivered.
+func (b *EventBus) UseLog(log EventLog) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.log = log
+}
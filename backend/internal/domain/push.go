@@ -0,0 +1,393 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// JobDeliverPush sends one push message to one subscription.
+	JobDeliverPush = "push.deliver"
+
+	maxPushSubscriptions = 20
+	maxPushBody          = 1000
+	maxPushDevice        = 100
+	maxPushTTL           = 28 * 24 * time.Hour
+)
+
+// pushTopicPattern is the base64url alphabet a Topic header is limited to.
+var pushTopicPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{0,32}$`)
+
+// Push message urgencies (RFC 8030 section 5.3). Push services may hold
+// back low-urgency messages to save the device's battery.
+const (
+	PushUrgencyVeryLow = "very-low"
+	PushUrgencyLow     = "low"
+	PushUrgencyNormal  = "normal"
+	PushUrgencyHigh    = "high"
+)
+
+var pushUrgencies = []string{PushUrgencyVeryLow, PushUrgencyLow, PushUrgencyNormal, PushUrgencyHigh}
+
+// ErrPushSubscriptionGone is returned by a PushSender when the push
+// service no longer knows the subscription (404 or 410), typically
+// because the user revoked the permission or the browser unsubscribed.
+var ErrPushSubscriptionGone = errors.New("push subscription is gone")
+
+// PushSubscription is a browser's push endpoint for one of a user's
+// devices, as returned by PushManager.subscribe.
+type PushSubscription struct {
+	ID            string     `json:"id"`
+	TenantID      string     `json:"tenant_id"`
+	UserID        string     `json:"user_id"`
+	Endpoint      string     `json:"endpoint"`
+	P256dh        string     `json:"-"`
+	Auth          string     `json:"-"`
+	Device        string     `json:"device"`
+	UserAgent     string     `json:"user_agent"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// PushSubscriptionInput is the JSON of a browser PushSubscription plus an
+// optional device name.
+type PushSubscriptionInput struct {
+	Endpoint       string `json:"endpoint" binding:"required"`
+	ExpirationTime *int64 `json:"expirationTime"`
+	Keys           struct {
+		P256dh string `json:"p256dh" binding:"required"`
+		Auth   string `json:"auth" binding:"required"`
+	} `json:"keys"`
+	Device string `json:"device"`
+}
+
+// PushOptions are the delivery headers of a push message.
+type PushOptions struct {
+	// TTL is how long the push service keeps the message for a device
+	// that is offline; zero means deliver now or never.
+	TTL     time.Duration
+	Urgency string
+	// Topic replaces an undelivered message with the same topic.
+	Topic string
+}
+
+// PushTestInput asks for a test message with the given delivery options.
+// TTL is in seconds and defaults to the one used for notifications.
+type PushTestInput struct {
+	TTL     *int   `json:"ttl"`
+	Urgency string `json:"urgency"`
+	Topic   string `json:"topic"`
+}
+
+// PushMessage is the payload the service worker receives.
+type PushMessage struct {
+	Kind         string `json:"kind"`
+	Title        string `json:"title"`
+	Body         string `json:"body,omitempty"`
+	Notification string `json:"notification_id,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+}
+
+// PushSender sends an encrypted message to a push service.
+type PushSender interface {
+	Send(ctx context.Context, sub PushSubscription, payload []byte, opts PushOptions) error
+}
+
+// PushRepository persists push subscriptions. Save inserts or, for a
+// known endpoint, updates the subscription and hands it to the caller.
+type PushRepository interface {
+	Save(ctx context.Context, sub *PushSubscription) error
+	Get(ctx context.Context, id string) (*PushSubscription, error)
+	List(ctx context.Context, tenantID, userID string) ([]PushSubscription, error)
+	Delete(ctx context.Context, tenantID, userID, id string) error
+	Remove(ctx context.Context, id string) error
+	Delivered(ctx context.Context, id string, at time.Time) error
+}
+
+// pushDelivery is the payload of a JobDeliverPush job. The message is
+// dropped once ExpiresAt, TTL seconds after it was queued, passes, so
+// retries honour the TTL.
+type pushDelivery struct {
+	SubscriptionID string          `json:"subscription_id"`
+	Message        json.RawMessage `json:"message"`
+	TTL            int             `json:"ttl"`
+	Urgency        string          `json:"urgency"`
+	Topic          string          `json:"topic,omitempty"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+}
+
+// PushService manages users' push subscriptions and delivers
+// notifications to them through the job queue. It is a
+// NotificationChannel.
+type PushService struct {
+	repo      PushRepository
+	sender    PushSender
+	jobs      *JobQueue
+	publicKey string
+	ttl       time.Duration
+	urgencies map[string]string
+	loopback  bool
+}
+
+// NewPushService creates a push service. publicKey is the VAPID key
+// clients subscribe with; ttl is how long push services keep
+// notifications for offline devices.
+func NewPushService(repo PushRepository, sender PushSender, jobs *JobQueue, publicKey string, ttl time.Duration) *PushService {
+	s := &PushService{
+		repo:      repo,
+		sender:    sender,
+		jobs:      jobs,
+		publicKey: publicKey,
+		ttl:       ttl,
+		urgencies: map[string]string{},
+	}
+	jobs.Register(JobDeliverPush, s.handleDeliver)
+	return s
+}
+
+// SetUrgency delivers notifications of a kind with the given urgency
+// instead of normal.
+func (s *PushService) SetUrgency(kind, urgency string) {
+	s.urgencies[kind] = urgency
+}
+
+// AllowLoopback accepts plain-HTTP endpoints on loopback addresses, so
+// a local push service stand-in can subscribe during development.
+func (s *PushService) AllowLoopback() {
+	s.loopback = true
+}
+
+// PublicKey is the VAPID public key, base64url-encoded.
+func (s *PushService) PublicKey() string {
+	return s.publicKey
+}
+
+func (s *PushService) Name() string { return "push" }
+
+// Subscribe stores a subscription for the caller's device. Subscribing
+// again from the same browser updates the existing subscription.
+func (s *PushService) Subscribe(ctx context.Context, actor Identity, in PushSubscriptionInput, userAgent string) (*PushSubscription, error) {
+	if err := s.checkEndpoint(in.Endpoint); err != nil {
+		return nil, err
+	}
+	p256dh, err := decodePushKey(in.Keys.P256dh)
+	if err != nil || len(p256dh) != 65 || p256dh[0] != 0x04 {
+		return nil, InvalidInput("keys.p256dh must be an uncompressed P-256 public key")
+	}
+	auth, err := decodePushKey(in.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, InvalidInput("keys.auth must be a 16-byte secret")
+	}
+	device := strings.TrimSpace(in.Device)
+	if utf8.RuneCountInString(device) > maxPushDevice {
+		return nil, InvalidInput("device must be at most %d characters", maxPushDevice)
+	}
+	existing, err := s.repo.List(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, sub := range existing {
+		known = known || sub.Endpoint == in.Endpoint
+	}
+	if !known && len(existing) >= maxPushSubscriptions {
+		return nil, InvalidInput("at most %d devices can receive push notifications; remove one first", maxPushSubscriptions)
+	}
+
+	now := time.Now().UTC()
+	sub := &PushSubscription{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		UserID:    actor.UserID,
+		Endpoint:  in.Endpoint,
+		P256dh:    base64.RawURLEncoding.EncodeToString(p256dh),
+		Auth:      base64.RawURLEncoding.EncodeToString(auth),
+		Device:    device,
+		UserAgent: truncateRunes(userAgent, 255),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if in.ExpirationTime != nil {
+		t := time.UnixMilli(*in.ExpirationTime).UTC()
+		sub.ExpiresAt = &t
+	}
+	if err := s.repo.Save(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// List returns the caller's subscriptions.
+func (s *PushService) List(ctx context.Context, actor Identity) ([]PushSubscription, error) {
+	return s.repo.List(ctx, actor.TenantID, actor.UserID)
+}
+
+// Unsubscribe removes one of the caller's subscriptions.
+func (s *PushService) Unsubscribe(ctx context.Context, actor Identity, id string) error {
+	return s.repo.Delete(ctx, actor.TenantID, actor.UserID, id)
+}
+
+// Deliver queues a push of the notification to each of the recipient's
+// devices.
+func (s *PushService) Deliver(ctx context.Context, n Notification) error {
+	urgency := s.urgencies[n.Kind]
+	if urgency == "" {
+		urgency = PushUrgencyNormal
+	}
+	msg := PushMessage{
+		Kind:         n.Kind,
+		Title:        n.Title,
+		Body:         truncateRunes(n.Body, maxPushBody),
+		Notification: n.ID,
+		ResourceType: n.ResourceType,
+		ResourceID:   n.ResourceID,
+	}
+	_, err := s.send(ctx, n.TenantID, n.UserID, msg, PushOptions{TTL: s.ttl, Urgency: urgency})
+	return err
+}
+
+// SendTest pushes a test message to the caller's devices and returns how
+// many deliveries were queued.
+func (s *PushService) SendTest(ctx context.Context, actor Identity, in PushTestInput) (int, error) {
+	opts := PushOptions{TTL: s.ttl, Urgency: in.Urgency, Topic: in.Topic}
+	if in.TTL != nil {
+		opts.TTL = time.Duration(*in.TTL) * time.Second
+	}
+	if opts.Urgency == "" {
+		opts.Urgency = PushUrgencyNormal
+	}
+	if !slices.Contains(pushUrgencies, opts.Urgency) {
+		return 0, InvalidInput("urgency must be one of %s", strings.Join(pushUrgencies, ", "))
+	}
+	if opts.TTL < 0 || opts.TTL > maxPushTTL {
+		return 0, InvalidInput("ttl must be between 0 and %d seconds", int(maxPushTTL/time.Second))
+	}
+	if !pushTopicPattern.MatchString(opts.Topic) {
+		return 0, InvalidInput("topic must be at most 32 letters, digits, - or _")
+	}
+	msg := PushMessage{Kind: "push.test", Title: "Test notification", Body: "Push notifications work on this device."}
+	return s.send(ctx, actor.TenantID, actor.UserID, msg, opts)
+}
+
+func (s *PushService) send(ctx context.Context, tenantID, userID string, msg PushMessage, opts PushOptions) (int, error) {
+	subs, err := s.repo.List(ctx, tenantID, userID)
+	if err != nil || len(subs) == 0 {
+		return 0, err
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	expiresAt := time.Now().UTC().Add(opts.TTL)
+	for _, sub := range subs {
+		_, err := s.jobs.Enqueue(ctx, JobDeliverPush, pushDelivery{
+			SubscriptionID: sub.ID,
+			Message:        raw,
+			TTL:            int(opts.TTL / time.Second),
+			Urgency:        opts.Urgency,
+			Topic:          opts.Topic,
+			ExpiresAt:      expiresAt,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(subs), nil
+}
+
+// handleDeliver sends a queued message. Subscriptions the push service
+// reports as gone, or that expired, are removed.
+func (s *PushService) handleDeliver(ctx context.Context, job Job) error {
+	var d pushDelivery
+	if err := json.Unmarshal(job.Payload, &d); err != nil {
+		return err
+	}
+	sub, err := s.repo.Get(ctx, d.SubscriptionID)
+	var appErr AppError
+	if errors.As(err, &appErr) && appErr.Code == ErrNotFound.Code {
+		// Unsubscribed after the message was queued
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if sub.ExpiresAt != nil && sub.ExpiresAt.Before(now) {
+		log.Printf("push: removing expired subscription %s of %s", sub.ID, sub.UserID)
+		return s.repo.Remove(ctx, sub.ID)
+	}
+	// A retried message keeps its original deadline
+	ttl := d.ExpiresAt.Sub(now).Truncate(time.Second)
+	if ttl < 0 {
+		// A message for immediate delivery (TTL 0) still gets its first
+		// attempt; any other is stale
+		if d.TTL > 0 || job.Attempts > 1 {
+			log.Printf("push: dropping message for subscription %s, its TTL passed before it was delivered", sub.ID)
+			return nil
+		}
+		ttl = 0
+	}
+	err = s.sender.Send(ctx, *sub, d.Message, PushOptions{TTL: ttl, Urgency: d.Urgency, Topic: d.Topic})
+	if errors.Is(err, ErrPushSubscriptionGone) {
+		log.Printf("push: removing subscription %s of %s: %v", sub.ID, sub.UserID, err)
+		return s.repo.Remove(ctx, sub.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return s.repo.Delivered(ctx, sub.ID, now)
+}
+
+// checkEndpoint requires HTTPS, as browsers' push services use, and
+// rejects private addresses so subscriptions cannot make the server send
+// requests into its own network. Host names are checked by the
+// PushSender when it connects, against the addresses they resolve to.
+func (s *PushService) checkEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return InvalidInput("endpoint must be an absolute URL")
+	}
+	host := u.Hostname()
+	ip := net.ParseIP(host)
+	loopback := host == "localhost" || (ip != nil && ip.IsLoopback())
+	if loopback && s.loopback {
+		return nil
+	}
+	if u.Scheme != "https" {
+		return InvalidInput("endpoint must be an https URL")
+	}
+	if loopback || (ip != nil && !PublicIP(ip)) {
+		return InvalidInput("endpoint must be a public push service")
+	}
+	return nil
+}
+
+// PublicIP reports whether ip may belong to a public push service rather
+// than to the server's own network.
+func PublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+func decodePushKey(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("not base64url: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,394 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Job kinds of the saved view e-mail delivery.
+	JobDeliverDueViews = "saved_views.deliver_due"
+	JobDeliverView     = "saved_views.deliver"
+
+	maxViewNameLength = 100
+	maxDeliveredRows  = 100
+)
+
+// ViewSchedule configures periodic e-mail delivery of a saved view's
+// results to its owner. Times are in UTC.
+type ViewSchedule struct {
+	Frequency  string     `json:"frequency"`
+	Hour       int        `json:"hour"`
+	Weekday    int        `json:"weekday,omitempty"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// SavedView is a named list query over a list source. Views belong to
+// their owner and may be shared read-only with teams.
+type SavedView struct {
+	ID              string        `json:"id"`
+	TenantID        string        `json:"tenant_id"`
+	OwnerID         string        `json:"owner_id"`
+	Name            string        `json:"name"`
+	Source          string        `json:"source"`
+	Query           ListQuery     `json:"query"`
+	SharedWithTeams []string      `json:"shared_with_teams"`
+	Schedule        *ViewSchedule `json:"schedule,omitempty"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+// SavedViewInput creates or updates a saved view. Nil fields are left
+// unchanged on update.
+type SavedViewInput struct {
+	Name            *string    `json:"name"`
+	Source          *string    `json:"source"`
+	Query           *ListQuery `json:"query"`
+	SharedWithTeams *[]string  `json:"shared_with_teams"`
+}
+
+// SavedViewRepository persists saved views and their schedules.
+type SavedViewRepository interface {
+	Create(ctx context.Context, v *SavedView) error
+	Get(ctx context.Context, tenantID, id string) (*SavedView, error)
+	ListVisible(ctx context.Context, tenantID, userID string, teams []string, params ListParams) ([]SavedView, int, error)
+	Update(ctx context.Context, v *SavedView) error
+	Delete(ctx context.Context, tenantID, id string) error
+	SetSchedule(ctx context.Context, tenantID, id string, s *ViewSchedule) error
+	DueSchedules(ctx context.Context, now time.Time, limit int) ([]SavedView, error)
+	AdvanceSchedule(ctx context.Context, id string, next time.Time) error
+	MarkDelivered(ctx context.Context, id string, at time.Time) error
+}
+
+// SavedViewService manages saved views, executes them through the listing
+// toolkit and delivers scheduled results by e-mail.
+type SavedViewService struct {
+	repo    SavedViewRepository
+	listing *ListingService
+	users   *UserService
+	jobs    *JobQueue
+	mailer  Mailer
+	appURL  string
+}
+
+// NewSavedViewService creates a saved view service and registers its
+// delivery job handlers.
+func NewSavedViewService(repo SavedViewRepository, listing *ListingService, users *UserService, jobs *JobQueue, mailer Mailer, appURL string) *SavedViewService {
+	s := &SavedViewService{repo: repo, listing: listing, users: users, jobs: jobs, mailer: mailer, appURL: strings.TrimRight(appURL, "/")}
+	jobs.Register(JobDeliverDueViews, s.handleDeliverDue)
+	jobs.Register(JobDeliverView, s.handleDeliver)
+	return s
+}
+
+// List returns the views the caller owns or that are shared with one of
+// the caller's teams.
+func (s *SavedViewService) List(ctx context.Context, actor Identity, params ListParams) ([]SavedView, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.ListVisible(ctx, actor.TenantID, actor.UserID, actor.Teams, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// Get returns a view visible to the caller.
+func (s *SavedViewService) Get(ctx context.Context, actor Identity, id string) (*SavedView, error) {
+	v, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if !canSeeView(actor, v) {
+		return nil, NotFound("Saved view")
+	}
+	return v, nil
+}
+
+// Create saves a new view owned by the caller.
+func (s *SavedViewService) Create(ctx context.Context, actor Identity, in SavedViewInput) (*SavedView, error) {
+	if in.Name == nil || in.Source == nil {
+		return nil, InvalidInput("name and source are required")
+	}
+	now := time.Now().UTC()
+	v := &SavedView{
+		ID:              NewID(),
+		TenantID:        actor.TenantID,
+		OwnerID:         actor.UserID,
+		SharedWithTeams: []string{},
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := s.apply(actor, v, in); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Update changes a view. Only the owner may update it.
+func (s *SavedViewService) Update(ctx context.Context, actor Identity, id string, in SavedViewInput) (*SavedView, error) {
+	v, err := s.owned(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(actor, v, in); err != nil {
+		return nil, err
+	}
+	v.UpdatedAt = time.Now().UTC()
+	if err := s.repo.Update(ctx, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Delete removes a view. Only the owner may delete it.
+func (s *SavedViewService) Delete(ctx context.Context, actor Identity, id string) error {
+	if _, err := s.owned(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, actor.TenantID, id)
+}
+
+// Execute runs a visible view as the caller and returns a page of results.
+func (s *SavedViewService) Execute(ctx context.Context, actor Identity, id string, params ListParams) ([]map[string]interface{}, PaginationMeta, *SavedView, error) {
+	v, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, PaginationMeta{}, nil, err
+	}
+	rows, meta, err := s.listing.Execute(ctx, actor, v.Source, v.Query, params)
+	if err != nil {
+		return nil, PaginationMeta{}, nil, err
+	}
+	return rows, meta, v, nil
+}
+
+// SetSchedule enables e-mail delivery of the view's results to its owner.
+func (s *SavedViewService) SetSchedule(ctx context.Context, actor Identity, id string, schedule ViewSchedule) (*SavedView, error) {
+	v, err := s.owned(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Frequency != "daily" && schedule.Frequency != "weekly" {
+		return nil, InvalidInput("frequency must be daily or weekly")
+	}
+	if schedule.Hour < 0 || schedule.Hour > 23 {
+		return nil, InvalidInput("hour must be between 0 and 23")
+	}
+	if schedule.Weekday < 0 || schedule.Weekday > 6 {
+		return nil, InvalidInput("weekday must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	schedule.LastSentAt = nil
+	if v.Schedule != nil {
+		schedule.LastSentAt = v.Schedule.LastSentAt
+	}
+	schedule.NextRunAt = nextViewDelivery(schedule, time.Now().UTC())
+	if err := s.repo.SetSchedule(ctx, actor.TenantID, id, &schedule); err != nil {
+		return nil, err
+	}
+	v.Schedule = &schedule
+	return v, nil
+}
+
+// ClearSchedule disables e-mail delivery of a view.
+func (s *SavedViewService) ClearSchedule(ctx context.Context, actor Identity, id string) error {
+	if _, err := s.owned(ctx, actor, id); err != nil {
+		return err
+	}
+	return s.repo.SetSchedule(ctx, actor.TenantID, id, nil)
+}
+
+// handleDeliverDue enqueues a delivery job for every view whose schedule
+// is due and moves the schedule to its next run.
+func (s *SavedViewService) handleDeliverDue(ctx context.Context, job Job) error {
+	now := time.Now().UTC()
+	due, err := s.repo.DueSchedules(ctx, now, 100)
+	if err != nil {
+		return err
+	}
+	for _, v := range due {
+		if err := s.repo.AdvanceSchedule(ctx, v.ID, nextViewDelivery(*v.Schedule, now)); err != nil {
+			return err
+		}
+		payload := map[string]string{"tenant_id": v.TenantID, "view_id": v.ID}
+		if _, err := s.jobs.Enqueue(ctx, JobDeliverView, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleDeliver executes a view as its owner and e-mails the results.
+func (s *SavedViewService) handleDeliver(ctx context.Context, job Job) error {
+	var payload struct {
+		TenantID string `json:"tenant_id"`
+		ViewID   string `json:"view_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
+	v, err := s.repo.Get(ctx, payload.TenantID, payload.ViewID)
+	if err != nil {
+		return err
+	}
+	owner, err := s.users.Get(ctx, v.TenantID, v.OwnerID)
+	if err != nil {
+		return err
+	}
+	if owner.Email == "" {
+		log.Printf("saved view %s: owner %s has no e-mail address, skipping delivery", v.ID, owner.ID)
+		return nil
+	}
+
+	actor := Identity{UserID: owner.ID, TenantID: owner.TenantID, Username: owner.Username, Email: owner.Email}
+	rows, meta, err := s.listing.Execute(ctx, actor, v.Source, v.Query, ListParams{Page: 1, Limit: maxDeliveredRows})
+	if err != nil {
+		return err
+	}
+	msg, err := s.renderDelivery(v, rows, meta)
+	if err != nil {
+		return err
+	}
+	msg.To = []string{owner.Email}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+	return s.repo.MarkDelivered(ctx, v.ID, time.Now().UTC())
+}
+
+var viewEmailTemplate = template.Must(template.New("view").Parse(`<h2>{{.Name}}</h2>
+<p>{{.Total}} matching items{{if .Truncated}}, showing the first {{len .Rows}}{{end}}.</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+<p><a href="{{.Link}}">Open in the app</a></p>`))
+
+func (s *SavedViewService) renderDelivery(v *SavedView, rows []map[string]interface{}, meta PaginationMeta) (Email, error) {
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]string, len(v.Query.Columns))
+		for j, col := range v.Query.Columns {
+			cells[i][j] = formatCell(row[col])
+		}
+	}
+	data := struct {
+		Name      string
+		Columns   []string
+		Rows      [][]string
+		Total     int
+		Truncated bool
+		Link      string
+	}{v.Name, v.Query.Columns, cells, meta.Total, meta.Total > len(rows), s.appURL + "/views/" + v.ID}
+
+	var html bytes.Buffer
+	if err := viewEmailTemplate.Execute(&html, data); err != nil {
+		return Email{}, err
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n\n%d matching items.\n\n%s\n", v.Name, meta.Total, strings.Join(v.Query.Columns, " | "))
+	for _, row := range cells {
+		text.WriteString(strings.Join(row, " | ") + "\n")
+	}
+	fmt.Fprintf(&text, "\nOpen in the app: %s\n", data.Link)
+
+	return Email{Subject: "Saved view: " + v.Name, Text: text.String(), HTML: html.String()}, nil
+}
+
+func formatCell(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// apply validates the input and copies it onto the view. The query must
+// be valid for the source, and views can only be shared with teams the
+// caller belongs to.
+func (s *SavedViewService) apply(actor Identity, v *SavedView, in SavedViewInput) error {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxViewNameLength {
+			return InvalidInput("name must be between 1 and %d characters", maxViewNameLength)
+		}
+		v.Name = name
+	}
+	if in.Source != nil {
+		v.Source = *in.Source
+	}
+	if in.Query != nil {
+		v.Query = *in.Query
+	}
+	if in.Source != nil || in.Query != nil {
+		q, err := s.listing.Validate(v.Source, v.Query)
+		if err != nil {
+			return err
+		}
+		v.Query = q
+	}
+	if in.SharedWithTeams != nil {
+		teams := []string{}
+		for _, team := range *in.SharedWithTeams {
+			if !actor.IsAdmin() && !slices.Contains(actor.Teams, team) {
+				return InvalidInput("you can only share views with your own teams (%s)", team)
+			}
+			if !slices.Contains(teams, team) {
+				teams = append(teams, team)
+			}
+		}
+		v.SharedWithTeams = teams
+	}
+	return nil
+}
+
+func (s *SavedViewService) owned(ctx context.Context, actor Identity, id string) (*SavedView, error) {
+	v, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if v.OwnerID != actor.UserID {
+		return nil, ErrForbidden
+	}
+	return v, nil
+}
+
+func canSeeView(actor Identity, v *SavedView) bool {
+	if v.OwnerID == actor.UserID {
+		return true
+	}
+	for _, team := range v.SharedWithTeams {
+		if slices.Contains(actor.Teams, team) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextViewDelivery returns the first delivery time of the schedule after t.
+func nextViewDelivery(s ViewSchedule, t time.Time) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), s.Hour, 0, 0, 0, time.UTC)
+	if s.Frequency == "weekly" {
+		next = next.AddDate(0, 0, (s.Weekday-int(next.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	}
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,249 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Scan states of stored content
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+const (
+	// JobScanBlob scans newly uploaded content.
+	JobScanBlob = "files.scan"
+	// JobRescanBlobs scans content not yet checked against the current
+	// signatures.
+	JobRescanBlobs = "files.rescan"
+
+	// NotificationFileQuarantined tells owners their file was found to
+	// contain malware.
+	NotificationFileQuarantined = "file.quarantined"
+
+	rescanBatch = 100
+)
+
+var (
+	ErrFilePendingScan = AppError{
+		Status:  http.StatusConflict,
+		Code:    "FILE_PENDING_SCAN",
+		Message: "The file is being scanned for malware; try again shortly",
+	}
+
+	ErrFileQuarantined = AppError{
+		Status:  http.StatusForbidden,
+		Code:    "FILE_QUARANTINED",
+		Message: "The file was quarantined because it contains malware",
+	}
+)
+
+// Scanner checks content for malware.
+type Scanner interface {
+	Name() string
+	// Version identifies the signatures in use. When it changes, all
+	// content is scanned again.
+	Version(ctx context.Context) (string, error)
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// ScanResult is the verdict on scanned content.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// RescanReport summarizes a rescan run.
+type RescanReport struct {
+	Scanner  string `json:"scanner"`
+	Version  string `json:"version"`
+	Scanned  int    `json:"scanned"`
+	Infected int    `json:"infected"`
+	Released int    `json:"released"`
+	Failed   int    `json:"failed"`
+}
+
+// UseScanner makes uploads wait in quarantine until scanner found them
+// clean, and notifies owners of files found infected. It registers the
+// scan jobs when the service has a job queue.
+func (s *FileService) UseScanner(scanner Scanner, notifications *NotificationService) {
+	s.scanner = scanner
+	s.notifications = notifications
+	if s.jobs != nil {
+		s.jobs.Register(JobScanBlob, s.handleScan)
+		s.jobs.Register(JobRescanBlobs, s.handleRescan)
+	}
+}
+
+// Scanner returns the scanner in use, or nil.
+func (s *FileService) Scanner() Scanner {
+	return s.scanner
+}
+
+// Unscanned returns the tenant's files that are quarantined or still
+// waiting for their scan.
+func (s *FileService) Unscanned(ctx context.Context, actor Identity, params ListParams) ([]File, PaginationMeta, error) {
+	params = params.Normalize()
+	items, total, err := s.repo.Unscanned(ctx, actor.TenantID, params)
+	if err != nil {
+		return nil, PaginationMeta{}, err
+	}
+	return items, NewPaginationMeta(params, total), nil
+}
+
+// ScanBlob scans content unless it was already scanned with the current
+// signatures. It returns the content's scan status.
+func (s *FileService) ScanBlob(ctx context.Context, hash string) (string, error) {
+	if s.scanner == nil {
+		return "", errors.New("files: no scanner configured")
+	}
+	version, err := s.scanner.Version(ctx)
+	if err != nil {
+		return "", fmt.Errorf("files: scanner version: %w", err)
+	}
+	b, err := s.repo.Blob(ctx, hash)
+	if err != nil {
+		return "", err
+	}
+	if b.ScannerVersion == version && b.ScanStatus != ScanPending {
+		return b.ScanStatus, nil
+	}
+	return s.scan(ctx, b, version)
+}
+
+// Rescan scans all content not checked against the current signatures,
+// e.g. after a signature update, and content whose first scan failed.
+func (s *FileService) Rescan(ctx context.Context) (*RescanReport, error) {
+	if s.scanner == nil {
+		return nil, InvalidInput("no malware scanner is configured")
+	}
+	version, err := s.scanner.Version(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("files: scanner version: %w", err)
+	}
+	report := &RescanReport{Scanner: s.scanner.Name(), Version: version}
+	failed := map[string]bool{}
+	for {
+		blobs, err := s.repo.StaleScans(ctx, version, rescanBatch+len(failed))
+		if err != nil {
+			return nil, err
+		}
+		progress := 0
+		for _, b := range blobs {
+			if failed[b.Hash] {
+				continue
+			}
+			previous := b.ScanStatus
+			status, err := s.scan(ctx, &b, version)
+			if err != nil {
+				log.Printf("files: rescanning %s: %v", b.Hash, err)
+				failed[b.Hash] = true
+				report.Failed++
+				continue
+			}
+			progress++
+			report.Scanned++
+			switch {
+			case status == ScanInfected && previous != ScanInfected:
+				report.Infected++
+			case status == ScanClean && previous == ScanInfected:
+				report.Released++
+			}
+		}
+		if progress == 0 {
+			break
+		}
+	}
+	return report, nil
+}
+
+// scan runs the scanner over a blob and records the verdict. Owners are
+// notified when content becomes infected.
+func (s *FileService) scan(ctx context.Context, b *Blob, version string) (string, error) {
+	content, err := s.store.Open(ctx, b.Hash)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.scanner.Scan(ctx, content)
+	content.Close()
+	if err != nil {
+		return "", fmt.Errorf("files: scanning %s with %s: %w", b.Hash, s.scanner.Name(), err)
+	}
+	status := ScanClean
+	if result.Infected {
+		status = ScanInfected
+	}
+	previous, err := s.repo.SetScanResult(ctx, b.Hash, status, result.Signature, version, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case status == ScanInfected && previous != ScanInfected:
+		log.Printf("files: content %s quarantined, %s found %s", b.Hash, s.scanner.Name(), result.Signature)
+		s.notifyQuarantined(ctx, b.Hash, result.Signature)
+	case status == ScanClean && previous == ScanInfected:
+		log.Printf("files: content %s released, %s %s no longer detects it", b.Hash, s.scanner.Name(), version)
+	}
+	return status, nil
+}
+
+func (s *FileService) notifyQuarantined(ctx context.Context, hash, signature string) {
+	if s.notifications == nil {
+		return
+	}
+	files, err := s.repo.FilesByBlob(ctx, hash)
+	if err != nil {
+		log.Printf("files: finding owners of quarantined content %s: %v", hash, err)
+		return
+	}
+	for _, f := range files {
+		err := s.notifications.Notify(ctx, Notification{
+			TenantID:     f.TenantID,
+			UserID:       f.OwnerID,
+			Kind:         NotificationFileQuarantined,
+			Title:        fmt.Sprintf("%q was quarantined", f.Name),
+			Body:         fmt.Sprintf("A malware scan found %s in the file. It can no longer be downloaded.", signature),
+			ResourceType: "file",
+			ResourceID:   f.ID,
+		})
+		if err != nil {
+			log.Printf("files: notifying %s about quarantined file %s: %v", f.OwnerID, f.ID, err)
+		}
+	}
+}
+
+func (s *FileService) handleScan(ctx context.Context, job Job) error {
+	var payload struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return err
+	}
+	_, err := s.ScanBlob(ctx, payload.Hash)
+	var appErr AppError
+	if errors.As(err, &appErr) && appErr.Code == ErrNotFound.Code {
+		// Collected before it was scanned
+		return nil
+	}
+	return err
+}
+
+func (s *FileService) handleRescan(ctx context.Context, job Job) error {
+	report, err := s.Rescan(ctx)
+	if err != nil {
+		return err
+	}
+	if report.Scanned+report.Failed > 0 {
+		log.Printf("files: rescanned %d blobs with %s %s, %d infected, %d released, %d failed",
+			report.Scanned, report.Scanner, report.Version, report.Infected, report.Released, report.Failed)
+	}
+	return nil
+}
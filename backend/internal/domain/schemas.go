@@ -0,0 +1,415 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONSchema is the subset of JSON Schema used to describe event and
+// realtime message payloads.
+type JSONSchema struct {
+	Type        string
+	Nullable    bool
+	Format      string
+	Description string
+	Enum        []string
+	Properties  map[string]*JSONSchema
+	Required    []string
+	Items       *JSONSchema
+
+	// Closed objects reject fields that are not in Properties; Values is
+	// the schema of every value of an open object (a map).
+	Closed bool
+	Values *JSONSchema
+}
+
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	if s.Type != "" {
+		if s.Nullable {
+			out["type"] = []string{s.Type, "null"}
+		} else {
+			out["type"] = s.Type
+		}
+	}
+	if s.Format != "" {
+		out["format"] = s.Format
+	}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Properties != nil {
+		out["properties"] = s.Properties
+	}
+	if len(s.Required) > 0 {
+		out["required"] = s.Required
+	}
+	if s.Items != nil {
+		out["items"] = s.Items
+	}
+	if s.Closed {
+		out["additionalProperties"] = false
+	} else if s.Values != nil {
+		out["additionalProperties"] = s.Values
+	}
+	return json.Marshal(out)
+}
+
+// Clone returns a copy of the schema that can be changed without
+// affecting the original.
+func (s *JSONSchema) Clone() *JSONSchema {
+	c := *s
+	c.Enum = slices.Clone(s.Enum)
+	c.Required = slices.Clone(s.Required)
+	if s.Properties != nil {
+		c.Properties = make(map[string]*JSONSchema, len(s.Properties))
+		for name, p := range s.Properties {
+			c.Properties[name] = p.Clone()
+		}
+	}
+	if s.Items != nil {
+		c.Items = s.Items.Clone()
+	}
+	if s.Values != nil {
+		c.Values = s.Values.Clone()
+	}
+	return &c
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf derives a schema from a Go value by reflection. Struct fields
+// are named by their json tag and required unless tagged omitempty;
+// slices, maps and pointers may be null, and byte slices are base64
+// strings. A doc tag sets the description.
+func SchemaOf(v interface{}) *JSONSchema {
+	return schemaOfType(reflect.TypeOf(v))
+}
+
+func schemaOfType(t reflect.Type) *JSONSchema {
+	if t == nil {
+		return &JSONSchema{}
+	}
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		// encoding/json writes byte slices as base64
+		return &JSONSchema{Type: "string", Format: "byte", Nullable: true}
+	case t.Kind() == reflect.Pointer:
+		s := schemaOfType(t.Elem())
+		s.Nullable = s.Type != ""
+		return s
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Nullable: t.Kind() == reflect.Slice, Items: schemaOfType(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", Nullable: true, Values: schemaOfType(t.Elem())}
+	case reflect.Struct:
+		s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, Closed: true}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			p := schemaOfType(f.Type)
+			p.Description = f.Tag.Get("doc")
+			s.Properties[name] = p
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	// Interfaces accept any value
+	return &JSONSchema{}
+}
+
+// Validate checks a decoded JSON value against the schema and returns a
+// description of every mismatch.
+func (s *JSONSchema) Validate(v interface{}) []string {
+	var problems []string
+	s.validate("", v, &problems)
+	return problems
+}
+
+func (s *JSONSchema) validate(path string, v interface{}, problems *[]string) {
+	at := path
+	if at == "" {
+		at = "payload"
+	}
+	if v == nil {
+		if s.Type != "" && !s.Nullable {
+			*problems = append(*problems, at+": must not be null")
+		}
+		return
+	}
+	if s.Type != "" && jsonType(v) != s.Type && !(s.Type == "number" && jsonType(v) == "integer") {
+		*problems = append(*problems, fmt.Sprintf("%s: expected %s, got %s", at, s.Type, jsonType(v)))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, fmt.Sprint(v)) {
+		*problems = append(*problems, fmt.Sprintf("%s: %v is not one of %s", at, v, strings.Join(s.Enum, ", ")))
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*problems = append(*problems, joinPath(path, name)+": is required")
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			if p, ok := s.Properties[name]; ok {
+				p.validate(joinPath(path, name), v[name], problems)
+			} else if s.Values != nil {
+				s.Values.validate(joinPath(path, name), v[name], problems)
+			} else if s.Closed {
+				*problems = append(*problems, joinPath(path, name)+": is not in the schema")
+			}
+		}
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// CheckCompatibility lists the changes from old to new that would break
+// a consumer written against old: removing a required field or making it
+// optional, changing a type, allowing null where it was not allowed and
+// adding enum values. Adding fields is compatible.
+func CheckCompatibility(old, new *JSONSchema) []string {
+	var problems []string
+	checkCompatibility("", old, new, &problems)
+	return problems
+}
+
+func checkCompatibility(path string, old, new *JSONSchema, problems *[]string) {
+	at := path
+	if at == "" {
+		at = "payload"
+	}
+	if old.Type != new.Type && old.Type != "" {
+		*problems = append(*problems, fmt.Sprintf("%s: type changed from %s to %s", at, describeType(old), describeType(new)))
+		return
+	}
+	if new.Nullable && !old.Nullable {
+		*problems = append(*problems, at+": may now be null")
+	}
+	if len(old.Enum) > 0 {
+		for _, value := range new.Enum {
+			if !slices.Contains(old.Enum, value) {
+				*problems = append(*problems, fmt.Sprintf("%s: enum value %q added", at, value))
+			}
+		}
+	}
+	for _, name := range old.Required {
+		if !slices.Contains(new.Required, name) {
+			if _, ok := new.Properties[name]; ok {
+				*problems = append(*problems, joinPath(path, name)+": is no longer required")
+			} else {
+				*problems = append(*problems, joinPath(path, name)+": required field removed")
+			}
+		}
+	}
+	for _, name := range sortedKeys(old.Properties) {
+		if p, ok := new.Properties[name]; ok {
+			checkCompatibility(joinPath(path, name), old.Properties[name], p, problems)
+		}
+	}
+	if old.Items != nil && new.Items != nil {
+		checkCompatibility(path+"[]", old.Items, new.Items, problems)
+	}
+	if old.Values != nil && new.Values != nil {
+		checkCompatibility(joinPath(path, "*"), old.Values, new.Values, problems)
+	}
+}
+
+func describeType(s *JSONSchema) string {
+	if s.Type == "" {
+		return "any"
+	}
+	return s.Type
+}
+
+// MessageChannel is where a message is delivered.
+type MessageChannel string
+
+const (
+	// ChannelEvents messages are domain events on the in-process bus only.
+	ChannelEvents MessageChannel = "events"
+	// ChannelResource messages are domain events that are also streamed to
+	// clients subscribed to the event's resource.
+	ChannelResource MessageChannel = "resource"
+	// ChannelUser messages are streamed to a single user.
+	ChannelUser MessageChannel = "user"
+	// ChannelCollab messages are the binary frames of a collaborative
+	// document's WebSocket, sent in both directions.
+	ChannelCollab MessageChannel = "collab"
+	// ChannelBackplane messages are relayed between replicas and never
+	// reach clients.
+	ChannelBackplane MessageChannel = "backplane"
+)
+
+// MessageSchema is a versioned definition of an event or realtime message.
+// Each version must be compatible with the one before it; a breaking
+// change needs a new message name.
+type MessageSchema struct {
+	Name        string         `json:"name"`
+	Version     int            `json:"version"`
+	Summary     string         `json:"summary"`
+	Description string         `json:"description,omitempty"`
+	Channel     MessageChannel `json:"channel"`
+	Deprecated  bool           `json:"deprecated,omitempty"`
+	Payload     *JSONSchema    `json:"payload"`
+}
+
+// MessageSchemaInfo is a registered message with all of its versions.
+type MessageSchemaInfo struct {
+	MessageSchema
+	Versions []MessageSchema `json:"versions"`
+}
+
+// MessageSchemaRegistry holds the schemas of all events and realtime
+// messages, used to validate published events and generate the AsyncAPI
+// document.
+type MessageSchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string][]MessageSchema
+}
+
+// NewMessageSchemaRegistry creates an empty registry.
+func NewMessageSchemaRegistry() *MessageSchemaRegistry {
+	return &MessageSchemaRegistry{schemas: make(map[string][]MessageSchema)}
+}
+
+// Register adds message schemas, oldest version first. It panics if a
+// version is out of sequence or incompatible with the previous one, since
+// both are programming errors.
+func (r *MessageSchemaRegistry) Register(schemas ...MessageSchema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range schemas {
+		versions := r.schemas[s.Name]
+		if s.Version != len(versions)+1 {
+			panic(fmt.Sprintf("schemas: %s version %d registered after version %d", s.Name, s.Version, len(versions)))
+		}
+		if len(versions) > 0 {
+			prev := versions[len(versions)-1]
+			if prev.Channel != s.Channel {
+				panic(fmt.Sprintf("schemas: %s version %d moves from channel %s to %s", s.Name, s.Version, prev.Channel, s.Channel))
+			}
+			if problems := CheckCompatibility(prev.Payload, s.Payload); len(problems) > 0 {
+				panic(fmt.Sprintf("schemas: %s version %d is incompatible with version %d:\n  %s",
+					s.Name, s.Version, prev.Version, strings.Join(problems, "\n  ")))
+			}
+		}
+		r.schemas[s.Name] = append(versions, s)
+	}
+}
+
+// Latest returns the current version of a message schema.
+func (r *MessageSchemaRegistry) Latest(name string) (MessageSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.schemas[name]
+	if len(versions) == 0 {
+		return MessageSchema{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// Get returns a message schema with all of its versions.
+func (r *MessageSchemaRegistry) Get(name string) (*MessageSchemaInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.schemas[name]
+	if len(versions) == 0 {
+		return nil, NotFound("message schema")
+	}
+	return &MessageSchemaInfo{MessageSchema: versions[len(versions)-1], Versions: slices.Clone(versions)}, nil
+}
+
+// All returns the current version of every message sorted by name.
+func (r *MessageSchemaRegistry) All() []MessageSchema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := make([]MessageSchema, 0, len(r.schemas))
+	for _, versions := range r.schemas {
+		all = append(all, versions[len(versions)-1])
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Validate checks a payload against the current version of a message.
+// The payload is compared in its JSON form, as consumers see it.
+func (r *MessageSchemaRegistry) Validate(name string, payload interface{}) (int, []string) {
+	s, ok := r.Latest(name)
+	if !ok {
+		return 0, []string{"no schema is registered for " + name}
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return s.Version, []string{err.Error()}
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return s.Version, []string{err.Error()}
+	}
+	return s.Version, s.Payload.Validate(v)
+}
@@ -0,0 +1,23 @@
+package domain
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a context for work done on behalf of a tenant. When
+// the tenant has a database of its own, repositories use it for queries
+// made with the context; other tenants share the main database and are
+// kept apart by their tenant_id columns.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	if tenantID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or "" for work
+// that is not done for a single tenant.
+func TenantFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+	return id
+}
@@ -0,0 +1,448 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JobExpireUploads removes resumable uploads past their expiry.
+	JobExpireUploads = "uploads.expire"
+
+	maxActiveUploads    = 20
+	maxUploadMetadata   = 4096
+	uploadExpireBatch   = 500
+	uploadChecksumUsage = "checksums are sent as \"<algorithm> <base64 digest>\""
+)
+
+// UploadChecksumAlgorithms are the digests accepted for chunk checksums.
+var UploadChecksumAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+var (
+	ErrUploadTooLarge = AppError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Code:    "UPLOAD_TOO_LARGE",
+		Message: "The upload exceeds its length or the maximum file size",
+	}
+
+	ErrUploadOffsetMismatch = AppError{
+		Status:  http.StatusConflict,
+		Code:    "UPLOAD_OFFSET_MISMATCH",
+		Message: "Upload-Offset does not match the bytes received so far",
+	}
+
+	ErrUploadLocked = AppError{
+		Status:  http.StatusLocked,
+		Code:    "UPLOAD_LOCKED",
+		Message: "Another request is writing to this upload",
+	}
+
+	// ErrUploadChecksumMismatch uses the status the tus checksum extension
+	// defines for it.
+	ErrUploadChecksumMismatch = AppError{
+		Status:  460,
+		Code:    "CHECKSUM_MISMATCH",
+		Message: "The chunk does not match its checksum and was discarded",
+	}
+)
+
+// Upload is a resumable upload in progress. Chunks are appended until
+// Offset reaches Length, then the content becomes a file and FileID
+// points at it.
+type Upload struct {
+	ID        string            `json:"id"`
+	TenantID  string            `json:"tenant_id"`
+	OwnerID   string            `json:"owner_id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	FileID    *string           `json:"file_id"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Complete reports whether all bytes were received and stored as a file.
+func (u *Upload) Complete() bool {
+	return u.FileID != nil
+}
+
+// FileName is the name of the resulting file, from the filename metadata
+// most tus clients send or the name key some use instead.
+func (u *Upload) FileName() string {
+	return firstMetadata(u.Metadata, "filename", "name")
+}
+
+// FileType is the content type the client declared, if any.
+func (u *Upload) FileType() string {
+	return firstMetadata(u.Metadata, "filetype", "type")
+}
+
+func firstMetadata(metadata map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v := metadata[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// UploadChecksum is the expected digest of a chunk.
+type UploadChecksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// ParseUploadChecksum parses an Upload-Checksum header value.
+func ParseUploadChecksum(value string) (*UploadChecksum, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok {
+		return nil, InvalidInput(uploadChecksumUsage)
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, InvalidInput(uploadChecksumUsage)
+	}
+	c := &UploadChecksum{Algorithm: strings.ToLower(algorithm), Sum: sum}
+	if c.hash() == nil {
+		return nil, InvalidInput("unsupported checksum algorithm %q (supported: %s)", algorithm, strings.Join(UploadChecksumAlgorithms, ", "))
+	}
+	return c, nil
+}
+
+func (c *UploadChecksum) hash() hash.Hash {
+	switch c.Algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// UploadRepository persists resumable uploads.
+type UploadRepository interface {
+	Create(ctx context.Context, u *Upload) error
+	Get(ctx context.Context, tenantID, id string) (*Upload, error)
+	// CountActive returns the owner's uploads still receiving data.
+	CountActive(ctx context.Context, tenantID, ownerID string) (int, error)
+	// Advance moves the offset from one value to another and fails with
+	// ErrUploadOffsetMismatch if the upload is no longer at from.
+	Advance(ctx context.Context, id string, from, to int64, expiresAt, at time.Time) error
+	Complete(ctx context.Context, id, fileID string, expiresAt, at time.Time) error
+	Delete(ctx context.Context, tenantID, id string) error
+	// Expired returns uploads whose expiry passed before the given time.
+	Expired(ctx context.Context, before time.Time, limit int) ([]Upload, error)
+	// Exists reports which of the given upload IDs have a record.
+	Exists(ctx context.Context, ids []string) (map[string]bool, error)
+}
+
+// UploadStore holds the bytes of uploads in progress.
+type UploadStore interface {
+	Create(ctx context.Context, id string) error
+	// Append writes r at offset, first discarding anything stored past
+	// it, such as a chunk that failed its checksum or a write that was
+	// never recorded. It returns the number of bytes written.
+	Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error)
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+	Remove(ctx context.Context, id string) error
+	// Walk calls fn for every stored upload with its last change.
+	Walk(ctx context.Context, fn func(id string, modTime time.Time) error) error
+}
+
+// UploadService implements resumable uploads on top of the file service:
+// chunks go to an UploadStore, and the finished content is stored as a
+// file through FileService.Upload, so it is deduplicated like any other.
+type UploadService struct {
+	repo   UploadRepository
+	parts  UploadStore
+	files  *FileService
+	expiry time.Duration
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+// NewUploadService creates an upload service whose uploads expire after
+// expiry without activity, and registers the job removing them.
+func NewUploadService(repo UploadRepository, parts UploadStore, files *FileService, jobs *JobQueue, expiry time.Duration) *UploadService {
+	s := &UploadService{repo: repo, parts: parts, files: files, expiry: expiry, active: map[string]bool{}}
+	if jobs != nil {
+		jobs.Register(JobExpireUploads, s.handleExpire)
+	}
+	return s
+}
+
+// MaxSize is the largest accepted upload in bytes.
+func (s *UploadService) MaxSize() int64 {
+	return s.files.MaxSize()
+}
+
+// Create starts an upload of length bytes. The filename and filetype
+// metadata name the resulting file.
+func (s *UploadService) Create(ctx context.Context, actor Identity, length int64, metadata map[string]string) (*Upload, error) {
+	if length < 0 {
+		return nil, InvalidInput("the upload length cannot be negative")
+	}
+	if length > s.MaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	if size > maxUploadMetadata {
+		return nil, InvalidInput("upload metadata can be at most %d bytes", maxUploadMetadata)
+	}
+	if _, err := cleanFileName(firstMetadata(metadata, "filename", "name")); err != nil {
+		return nil, err
+	}
+	active, err := s.repo.CountActive(ctx, actor.TenantID, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if active >= maxActiveUploads {
+		return nil, InvalidInput("you can have at most %d uploads in progress; finish or cancel one first", maxActiveUploads)
+	}
+
+	now := time.Now().UTC()
+	u := &Upload{
+		ID:        NewID(),
+		TenantID:  actor.TenantID,
+		OwnerID:   actor.UserID,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: now.Add(s.expiry),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if u.Metadata == nil {
+		u.Metadata = map[string]string{}
+	}
+	if err := s.parts.Create(ctx, u.ID); err != nil {
+		return nil, fmt.Errorf("uploads: %w", err)
+	}
+	if err := s.repo.Create(ctx, u); err != nil {
+		s.parts.Remove(ctx, u.ID)
+		return nil, err
+	}
+	if length == 0 {
+		return s.complete(ctx, actor, u)
+	}
+	return u, nil
+}
+
+// Get returns one of the caller's uploads that has not expired.
+func (s *UploadService) Get(ctx context.Context, actor Identity, id string) (*Upload, error) {
+	u, err := s.repo.Get(ctx, actor.TenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.OwnerID != actor.UserID || !u.ExpiresAt.After(time.Now()) {
+		return nil, NotFound("Upload")
+	}
+	return u, nil
+}
+
+// Append stores a chunk sent for the given offset. Without a checksum,
+// as much of an interrupted chunk as arrived is kept so the client can
+// resume from there; with one, the chunk is kept only if it matches.
+// The chunk that completes the upload turns it into a file.
+func (s *UploadService) Append(ctx context.Context, actor Identity, id string, offset int64, r io.Reader, checksum *UploadChecksum) (*Upload, error) {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	u, err := s.Get(ctx, actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return nil, ErrUploadOffsetMismatch.WithDetails(map[string]interface{}{"offset": u.Offset})
+	}
+	if u.Offset == u.Length {
+		// An empty chunk at the end retries a completion that failed
+		if u.Complete() {
+			return u, nil
+		}
+		return s.complete(ctx, actor, u)
+	}
+
+	remaining := u.Length - u.Offset
+	body := io.LimitReader(r, remaining+1)
+	var digest hash.Hash
+	if checksum != nil {
+		digest = checksum.hash()
+		body = io.TeeReader(body, digest)
+	}
+	n, readErr := s.parts.Append(ctx, u.ID, u.Offset, body)
+	switch {
+	case n > remaining:
+		return nil, ErrUploadTooLarge
+	case digest != nil && readErr != nil:
+		return nil, readErr
+	case digest != nil && !bytes.Equal(digest.Sum(nil), checksum.Sum):
+		return nil, ErrUploadChecksumMismatch
+	}
+
+	now := time.Now().UTC()
+	if n > 0 {
+		if err := s.repo.Advance(ctx, u.ID, u.Offset, u.Offset+n, now.Add(s.expiry), now); err != nil {
+			return nil, err
+		}
+		u.Offset += n
+		u.ExpiresAt, u.UpdatedAt = now.Add(s.expiry), now
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("uploads: receiving chunk: %w", readErr)
+	}
+	if u.Offset == u.Length {
+		return s.complete(ctx, actor, u)
+	}
+	return u, nil
+}
+
+// Terminate cancels an upload and discards its bytes. A finished
+// upload's file is kept.
+func (s *UploadService) Terminate(ctx context.Context, actor Identity, id string) error {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.Get(ctx, actor, id); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, actor.TenantID, id); err != nil {
+		return err
+	}
+	return s.parts.Remove(ctx, id)
+}
+
+// ExpireUploads removes uploads past their expiry, and stored bytes of
+// uploads that have no record anymore. It returns how many it removed.
+// Uploads receiving a chunk right now are left for the next run.
+func (s *UploadService) ExpireUploads(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	removed := 0
+	for {
+		expired, err := s.repo.Expired(ctx, now, uploadExpireBatch)
+		if err != nil {
+			return removed, err
+		}
+		batch := 0
+		for _, u := range expired {
+			unlock, err := s.lock(u.ID)
+			if err != nil {
+				continue
+			}
+			err = s.parts.Remove(ctx, u.ID)
+			if err == nil {
+				err = s.repo.Delete(ctx, u.TenantID, u.ID)
+			}
+			unlock()
+			if err != nil {
+				return removed, err
+			}
+			batch++
+		}
+		removed += batch
+		if len(expired) < uploadExpireBatch || batch == 0 {
+			break
+		}
+	}
+
+	// Bytes whose record is gone, e.g. after a crash between the two
+	var stale []string
+	err := s.parts.Walk(ctx, func(id string, modTime time.Time) error {
+		if modTime.Before(now.Add(-s.expiry)) {
+			stale = append(stale, id)
+		}
+		return nil
+	})
+	if err != nil || len(stale) == 0 {
+		return removed, err
+	}
+	known, err := s.repo.Exists(ctx, stale)
+	if err != nil {
+		return removed, err
+	}
+	for _, id := range stale {
+		if known[id] {
+			continue
+		}
+		if err := s.parts.Remove(ctx, id); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// complete stores the received bytes as a file. The bytes are kept
+// until the file exists, so a failed completion can be retried.
+func (s *UploadService) complete(ctx context.Context, actor Identity, u *Upload) (*Upload, error) {
+	content, err := s.parts.Open(ctx, u.ID)
+	if err != nil {
+		return nil, fmt.Errorf("uploads: %w", err)
+	}
+	defer content.Close()
+	f, err := s.files.Upload(ctx, actor, u.FileName(), u.FileType(), io.LimitReader(content, u.Length))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if err := s.repo.Complete(ctx, u.ID, f.ID, now.Add(s.expiry), now); err != nil {
+		return nil, err
+	}
+	if err := s.parts.Remove(ctx, u.ID); err != nil {
+		log.Printf("uploads: removing received bytes of %s: %v", u.ID, err)
+	}
+	u.FileID = &f.ID
+	u.ExpiresAt, u.UpdatedAt = now.Add(s.expiry), now
+	return u, nil
+}
+
+// lock gives a request exclusive use of an upload, so concurrent chunks
+// for the same offset cannot interleave.
+func (s *UploadService) lock(id string) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[id] {
+		return nil, ErrUploadLocked
+	}
+	s.active[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.active, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *UploadService) handleExpire(ctx context.Context, job Job) error {
+	removed, err := s.ExpireUploads(ctx)
+	if removed > 0 {
+		log.Printf("uploads: removed %d expired uploads", removed)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is the local record of a person known to the API. Users are
+// provisioned just-in-time from the claims of their bearer token.
+type User struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UserRepository persists users.
+type UserRepository interface {
+	Upsert(ctx context.Context, u *User) error
+	GetByID(ctx context.Context, tenantID, id string) (*User, error)
+	FindByUsernames(ctx context.Context, tenantID string, usernames []string) ([]User, error)
+}
+
+// UserService keeps the user directory in sync with authenticated callers.
+type UserService struct {
+	repo UserRepository
+
+	mu   sync.Mutex
+	seen map[string]Identity
+}
+
+// NewUserService creates a user service backed by repo.
+func NewUserService(repo UserRepository) *UserService {
+	return &UserService{repo: repo, seen: make(map[string]Identity)}
+}
+
+// Sync records the identity in the directory. It only writes when the
+// identity was not seen before or its profile claims changed.
+func (s *UserService) Sync(ctx context.Context, id Identity) error {
+	key := id.TenantID + "/" + id.UserID
+	s.mu.Lock()
+	prev, ok := s.seen[key]
+	s.mu.Unlock()
+	if ok && prev.Username == id.Username && prev.Email == id.Email {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	username := id.Username
+	if username == "" {
+		username = id.UserID
+	}
+	err := s.repo.Upsert(ctx, &User{
+		ID:        id.UserID,
+		TenantID:  id.TenantID,
+		Username:  username,
+		Email:     id.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.seen[key] = id
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns a user by ID.
+func (s *UserService) Get(ctx context.Context, tenantID, id string) (*User, error) {
+	return s.repo.GetByID(ctx, tenantID, id)
+}
+
+// ResolveUsernames maps usernames to users of the tenant, skipping unknown
+// names.
+func (s *UserService) ResolveUsernames(ctx context.Context, tenantID string, usernames []string) ([]User, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+	return s.repo.FindByUsernames(ctx, tenantID, usernames)
+}
+
+// ModeratedContent lets users be reported: the reported user is the
+// author, and their profile the content.
+func (s *UserService) ModeratedContent(ctx context.Context, tenantID, id string) (ModeratedContent, error) {
+	u, err := s.repo.GetByID(ctx, tenantID, id)
+	if err != nil {
+		return ModeratedContent{}, err
+	}
+	return ModeratedContent{AuthorID: u.ID, Text: strings.TrimSpace(u.Username + " " + u.DisplayName)}, nil
+}
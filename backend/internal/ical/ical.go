@@ -0,0 +1,298 @@
+// Package ical reads and writes iCalendar data (RFC 5545): components and
+// properties, date and time values with time zones, and recurrence rules.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxLineOctets = 75
+
+// Component is a calendar component such as VCALENDAR, VEVENT or
+// VTIMEZONE.
+type Component struct {
+	Name       string
+	Props      []Property
+	Components []*Component
+}
+
+// Property is a content line. Params holds parameters like TZID or VALUE
+// with their values unquoted.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// NewComponent creates an empty component.
+func NewComponent(name string) *Component {
+	return &Component{Name: name}
+}
+
+// Add appends a property with raw value and parameters given as name,
+// value pairs.
+func (c *Component) Add(name, value string, params ...string) {
+	p := Property{Name: name, Value: value}
+	if len(params) > 0 {
+		p.Params = make(map[string]string, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			p.Params[params[i]] = params[i+1]
+		}
+	}
+	c.Props = append(c.Props, p)
+}
+
+// AddText appends a property with a TEXT value, escaping it.
+func (c *Component) AddText(name, value string) {
+	c.Add(name, EscapeText(value))
+}
+
+// Append adds a subcomponent.
+func (c *Component) Append(child *Component) {
+	c.Components = append(c.Components, child)
+}
+
+// Get returns the first property with the given name.
+func (c *Component) Get(name string) *Property {
+	for i := range c.Props {
+		if c.Props[i].Name == name {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+// All returns every property with the given name.
+func (c *Component) All(name string) []Property {
+	var props []Property
+	for _, p := range c.Props {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Text returns the unescaped TEXT value of a property, or "".
+func (c *Component) Text(name string) string {
+	if p := c.Get(name); p != nil {
+		return UnescapeText(p.Value)
+	}
+	return ""
+}
+
+// Children returns the subcomponents with the given name.
+func (c *Component) Children(name string) []*Component {
+	var children []*Component
+	for _, child := range c.Components {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Encode writes the component as folded content lines with CRLF endings.
+func (c *Component) Encode() []byte {
+	var b strings.Builder
+	c.encode(&b)
+	return []byte(b.String())
+}
+
+func (c *Component) encode(b *strings.Builder) {
+	writeLine(b, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		writeLine(b, p.String())
+	}
+	for _, child := range c.Components {
+		child.encode(b)
+	}
+	writeLine(b, "END:"+c.Name)
+}
+
+// String formats the property as an unfolded content line. Parameters
+// are written in name order so output is stable.
+func (p Property) String() string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := p.Params[name]
+		if strings.ContainsAny(value, ":;,") {
+			value = `"` + strings.ReplaceAll(value, `"`, "") + `"`
+		}
+		b.WriteString(";" + name + "=" + value)
+	}
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+// writeLine folds a content line after 75 octets without splitting UTF-8
+// sequences.
+func writeLine(b *strings.Builder, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts
+		limit = maxLineOctets - 1
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// EscapeText escapes a TEXT value.
+func EscapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// UnescapeText reverses EscapeText.
+func UnescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// ErrSyntax is returned for input that is not iCalendar data.
+var ErrSyntax = errors.New("ical: syntax error")
+
+// Parse reads the first component of an iCalendar stream, normally a
+// VCALENDAR. Folded lines are joined and both CRLF and LF endings are
+// accepted.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var stack []*Component
+	var root *Component
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w on line %d", err, n+1)
+		}
+		switch p.Name {
+		case "BEGIN":
+			c := NewComponent(strings.ToUpper(p.Value))
+			if len(stack) > 0 {
+				stack[len(stack)-1].Append(c)
+			} else if root == nil {
+				root = c
+			} else {
+				return root, nil
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("%w: unexpected END:%s on line %d", ErrSyntax, p.Value, n+1)
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return root, nil
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: property outside a component on line %d", ErrSyntax, n+1)
+			}
+			c := stack[len(stack)-1]
+			c.Props = append(c.Props, p)
+		}
+	}
+	if root == nil || len(stack) > 0 {
+		return nil, fmt.Errorf("%w: incomplete calendar", ErrSyntax)
+	}
+	return root, nil
+}
+
+func unfold(r io.Reader) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	var lines []string
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// parseLine splits "NAME;PARAM=value;...:value", honouring quoted
+// parameter values that contain ':' or ';'.
+func parseLine(line string) (Property, error) {
+	var p Property
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return p, ErrSyntax
+	}
+	p.Name = strings.ToUpper(line[:i])
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return p, ErrSyntax
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return p, ErrSyntax
+			}
+			value, line = line[1:end+1], line[end+2:]
+			i = 0
+		} else {
+			i = strings.IndexAny(line, ";:")
+			if i < 0 {
+				return p, ErrSyntax
+			}
+			value = line[:i]
+			line = line[i:]
+			i = 0
+		}
+		if p.Params == nil {
+			p.Params = map[string]string{}
+		}
+		p.Params[name] = value
+		if line == "" {
+			return p, ErrSyntax
+		}
+	}
+	if line[i] != ':' {
+		return p, ErrSyntax
+	}
+	p.Value = line[i+1:]
+	return p, nil
+}
@@ -0,0 +1,412 @@
+package ical
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a recurrence rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+
+	// maxPeriods bounds expansion of rules whose filters never match, such
+	// as the 31st of February.
+	maxPeriods = 100000
+)
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var allMonths = []time.Month{time.January, time.February, time.March, time.April, time.May, time.June,
+	time.July, time.August, time.September, time.October, time.November, time.December}
+
+// WeekdayNum is a BYDAY entry: a weekday, optionally the Nth (or Nth from
+// last when negative) within the month or year.
+type WeekdayNum struct {
+	Day time.Weekday
+	N   int
+}
+
+func (w WeekdayNum) String() string {
+	if w.N != 0 {
+		return strconv.Itoa(w.N) + weekdayCodes[w.Day]
+	}
+	return weekdayCodes[w.Day]
+}
+
+// Recurrence is an RRULE. It supports the parts calendar apps write for
+// events — FREQ from DAILY to YEARLY with INTERVAL, COUNT, UNTIL, BYDAY,
+// BYMONTHDAY, BYMONTH and WKST — and rejects the rest instead of
+// expanding them wrongly.
+type Recurrence struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	UntilDate  bool
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+// ParseRecurrence parses an RRULE value. loc is used for an UNTIL given
+// as a floating time or a date.
+func ParseRecurrence(value string, loc *time.Location) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(strings.TrimSpace(value), ";") {
+		if part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("ical: invalid recurrence rule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(val))
+			switch r.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				return nil, fmt.Errorf("ical: unsupported recurrence frequency %s", val)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(val)
+			if err == nil && r.Interval < 1 {
+				err = fmt.Errorf("ical: INTERVAL must be positive")
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(val)
+			if err == nil && r.Count < 1 {
+				err = fmt.Errorf("ical: COUNT must be positive")
+			}
+		case "UNTIL":
+			p := Property{Name: "UNTIL", Value: val}
+			r.Until, r.UntilDate, err = p.Time(loc)
+		case "BYDAY":
+			for _, v := range strings.Split(val, ",") {
+				var w WeekdayNum
+				if w, err = parseWeekdayNum(v); err != nil {
+					break
+				}
+				r.ByDay = append(r.ByDay, w)
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(val, ",") {
+				n, convErr := strconv.Atoi(v)
+				if convErr != nil || n == 0 || n < -31 || n > 31 {
+					err = fmt.Errorf("ical: invalid BYMONTHDAY %q", v)
+					break
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, v := range strings.Split(val, ",") {
+				n, convErr := strconv.Atoi(v)
+				if convErr != nil || n < 1 || n > 12 {
+					err = fmt.Errorf("ical: invalid BYMONTH %q", v)
+					break
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			day := slices.Index(weekdayCodes, strings.ToUpper(val))
+			if day < 0 {
+				err = fmt.Errorf("ical: invalid WKST %q", val)
+			}
+			r.WeekStart = time.Weekday(day)
+		default:
+			return nil, fmt.Errorf("ical: unsupported recurrence rule part %s", strings.ToUpper(name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Freq == "" {
+		return nil, fmt.Errorf("ical: recurrence rule has no FREQ")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("ical: recurrence rule has both COUNT and UNTIL")
+	}
+	for _, w := range r.ByDay {
+		if w.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return nil, fmt.Errorf("ical: numbered BYDAY is only valid in MONTHLY and YEARLY rules")
+		}
+	}
+	return r, nil
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("ical: invalid BYDAY %q", s)
+	}
+	day := slices.Index(weekdayCodes, s[len(s)-2:])
+	if day < 0 {
+		return WeekdayNum{}, fmt.Errorf("ical: invalid BYDAY %q", s)
+	}
+	w := WeekdayNum{Day: time.Weekday(day)}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return WeekdayNum{}, fmt.Errorf("ical: invalid BYDAY %q", s)
+		}
+		w.N = n
+	}
+	return w, nil
+}
+
+// String formats the rule as an RRULE value.
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.UntilDate {
+			parts = append(parts, "UNTIL="+FormatDate(r.Until))
+		} else {
+			parts = append(parts, "UNTIL="+FormatUTC(r.Until))
+		}
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+joinInts(r.ByMonth))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, w := range r.ByDay {
+			days[i] = w.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayCodes[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts[T ~int](values []T) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, ",")
+}
+
+// Expand returns the occurrences of a series starting at start that fall
+// in [from, to), at most limit of them. Occurrences keep start's wall
+// clock time in its location, so a 9:00 meeting stays at 9:00 across
+// daylight saving changes. start itself is the first occurrence. The
+// second result reports whether the series continues after the last
+// returned occurrence.
+func (r *Recurrence) Expand(start, from, to time.Time, limit int) ([]time.Time, bool) {
+	var out []time.Time
+	count := 0
+	until := r.Until
+	if r.UntilDate && !until.IsZero() {
+		// A date UNTIL includes that whole day
+		until = time.Date(until.Year(), until.Month(), until.Day()+1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+	}
+	first := true
+	for period := 0; period < maxPeriods; period++ {
+		candidates := r.candidates(start, period)
+		if first {
+			// DTSTART always counts as the first instance, even if it does
+			// not match the rule.
+			if !slices.ContainsFunc(candidates, start.Equal) {
+				candidates = append([]time.Time{start}, candidates...)
+			}
+			first = false
+		}
+		for _, t := range candidates {
+			if t.Before(start) {
+				continue
+			}
+			if !until.IsZero() && t.After(until) {
+				return out, false
+			}
+			if !t.Before(to) {
+				return out, true
+			}
+			count++
+			if !t.Before(from) {
+				if len(out) == limit {
+					return out, true
+				}
+				out = append(out, t)
+			}
+			if r.Count > 0 && count >= r.Count {
+				return out, false
+			}
+		}
+	}
+	return out, false
+}
+
+// candidates lists the instances in the period-th period of the series,
+// in order.
+func (r *Recurrence) candidates(start time.Time, period int) []time.Time {
+	loc := start.Location()
+	hour, minute, sec := start.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hour, minute, sec, start.Nanosecond(), loc)
+	}
+	step := period * r.Interval
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		days = []time.Time{at(start.Year(), start.Month(), start.Day()+step)}
+	case Weekly:
+		offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := at(start.Year(), start.Month(), start.Day()-offset+7*step)
+		if len(r.ByDay) == 0 {
+			days = []time.Time{at(start.Year(), start.Month(), start.Day()+7*step)}
+		}
+		for i := 0; i < 7 && len(r.ByDay) > 0; i++ {
+			day := at(weekStart.Year(), weekStart.Month(), weekStart.Day()+i)
+			if r.matchesWeekday(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		month := at(start.Year(), start.Month()+time.Month(step), 1)
+		days = r.monthDays(start, month.Year(), month.Month(), at)
+	case Yearly:
+		year := start.Year() + step
+		// Without BYMONTH, BYMONTHDAY applies to every month and BYDAY to
+		// the whole year; only a bare rule repeats on start's date.
+		months := r.ByMonth
+		switch {
+		case len(months) > 0:
+		case len(r.ByMonthDay) > 0:
+			months = allMonths
+		case len(r.ByDay) > 0:
+			days = r.yearWeekdays(year, at)
+		default:
+			months = []time.Month{start.Month()}
+		}
+		for _, m := range months {
+			days = append(days, r.monthDays(start, year, m, at)...)
+		}
+	}
+
+	out := days[:0]
+	for _, d := range days {
+		if len(r.ByMonth) > 0 && !slices.Contains(r.ByMonth, d.Month()) {
+			continue
+		}
+		if r.Freq == Daily && len(r.ByDay) > 0 && !r.matchesWeekday(d) {
+			continue
+		}
+		if r.Freq == Daily && len(r.ByMonthDay) > 0 && !matchesMonthDay(d, r.ByMonthDay) {
+			continue
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return slices.CompactFunc(out, func(a, b time.Time) bool { return a.Equal(b) })
+}
+
+// monthDays expands BYMONTHDAY and BYDAY within one month; with neither,
+// the series repeats on start's day of the month, skipping months that
+// are too short.
+func (r *Recurrence) monthDays(start time.Time, year int, month time.Month, at func(int, time.Month, int) time.Time) []time.Time {
+	length := daysIn(year, month)
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, n := range r.ByMonthDay {
+			if n < 0 {
+				n = length + 1 + n
+			}
+			if n >= 1 && n <= length {
+				day := at(year, month, n)
+				if len(r.ByDay) == 0 || r.matchesWeekday(day) {
+					days = append(days, day)
+				}
+			}
+		}
+	case len(r.ByDay) > 0:
+		for _, w := range r.ByDay {
+			days = append(days, nthWeekdays(w, year, month, 1, length, at)...)
+		}
+	default:
+		if start.Day() <= length {
+			days = []time.Time{at(year, month, start.Day())}
+		}
+	}
+	return days
+}
+
+// yearWeekdays expands BYDAY entries over a whole year, as in
+// FREQ=YEARLY;BYDAY=20MO or every Monday of the year for BYDAY=MO.
+func (r *Recurrence) yearWeekdays(year int, at func(int, time.Month, int) time.Time) []time.Time {
+	length := 365
+	if daysIn(year, time.February) == 29 {
+		length = 366
+	}
+	var days []time.Time
+	for _, w := range r.ByDay {
+		days = append(days, nthWeekdays(w, year, time.January, 1, length, at)...)
+	}
+	return days
+}
+
+// nthWeekdays returns the days matching w among the length days starting
+// at the first of month; an unnumbered weekday matches all of them.
+func nthWeekdays(w WeekdayNum, year int, month time.Month, first, length int, at func(int, time.Month, int) time.Time) []time.Time {
+	var matches []time.Time
+	for i := 0; i < length; i++ {
+		day := at(year, month, first+i)
+		if day.Weekday() == w.Day {
+			matches = append(matches, day)
+		}
+	}
+	switch {
+	case w.N > 0 && w.N <= len(matches):
+		return matches[w.N-1 : w.N]
+	case w.N < 0 && -w.N <= len(matches):
+		return matches[len(matches)+w.N : len(matches)+w.N+1]
+	case w.N == 0:
+		return matches
+	}
+	return nil
+}
+
+func (r *Recurrence) matchesWeekday(t time.Time) bool {
+	for _, w := range r.ByDay {
+		if w.Day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMonthDay(t time.Time, monthDays []int) bool {
+	length := daysIn(t.Year(), t.Month())
+	for _, n := range monthDays {
+		if n == t.Day() || length+1+n == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
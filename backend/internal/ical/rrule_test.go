@@ -0,0 +1,155 @@
+package ical
+
+import (
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// TestExpandRFC5545 expands the examples of RFC 5545 section 3.8.5.3
+// that use the supported rule parts. All start at 9:00 in New York.
+func TestExpandRFC5545(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		rule  string
+		// want lists the first occurrences, or all of them for ending rules
+		want []string
+	}{
+		{"daily for 10 occurrences", "19970902", "FREQ=DAILY;COUNT=10",
+			[]string{"19970902", "19970903", "19970904", "19970905", "19970906", "19970907", "19970908", "19970909", "19970910", "19970911"}},
+		{"every other day", "19970902", "FREQ=DAILY;INTERVAL=2",
+			[]string{"19970902", "19970904", "19970906", "19970908", "19970910"}},
+		{"every 10 days, 5 occurrences", "19970902", "FREQ=DAILY;INTERVAL=10;COUNT=5",
+			[]string{"19970902", "19970912", "19970922", "19971002", "19971012"}},
+		{"every day in January, for 3 years (daily)", "19980101", "FREQ=DAILY;UNTIL=20000131T140000Z;BYMONTH=1",
+			januaries()},
+		{"every day in January, for 3 years (yearly)", "19980101", "FREQ=YEARLY;UNTIL=20000131T140000Z;BYMONTH=1;BYDAY=SU,MO,TU,WE,TH,FR,SA",
+			januaries()},
+		{"weekly for 10 occurrences", "19970902", "FREQ=WEEKLY;COUNT=10",
+			[]string{"19970902", "19970909", "19970916", "19970923", "19970930", "19971007", "19971014", "19971021", "19971028", "19971104"}},
+		{"weekly on Tuesday and Thursday for five weeks", "19970902", "FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH",
+			[]string{"19970902", "19970904", "19970909", "19970911", "19970916", "19970918", "19970923", "19970925", "19970930", "19971002"}},
+		{"every other week on Monday, Wednesday and Friday", "19970901", "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971224T000000Z;WKST=SU;BYDAY=MO,WE,FR",
+			[]string{"19970901", "19970903", "19970905", "19970915", "19970917", "19970919", "19970929", "19971001", "19971003",
+				"19971013", "19971015", "19971017", "19971027", "19971029", "19971031", "19971110", "19971112", "19971114",
+				"19971124", "19971126", "19971128", "19971208", "19971210", "19971212", "19971222"}},
+		{"every other week on Tuesday and Thursday, for 8 occurrences", "19970902", "FREQ=WEEKLY;INTERVAL=2;COUNT=8;WKST=SU;BYDAY=TU,TH",
+			[]string{"19970902", "19970904", "19970916", "19970918", "19970930", "19971002", "19971014", "19971016"}},
+		{"week start changes the weeks (MO)", "19970805", "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			[]string{"19970805", "19970810", "19970819", "19970824"}},
+		{"week start changes the weeks (SU)", "19970805", "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			[]string{"19970805", "19970817", "19970819", "19970831"}},
+		{"monthly on the first Friday for 10 occurrences", "19970905", "FREQ=MONTHLY;COUNT=10;BYDAY=1FR",
+			[]string{"19970905", "19971003", "19971107", "19971205", "19980102", "19980206", "19980306", "19980403", "19980501", "19980605"}},
+		{"every other month on the first and last Sunday", "19970907", "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU",
+			[]string{"19970907", "19970928", "19971102", "19971130", "19980104", "19980125", "19980301", "19980329", "19980503", "19980531"}},
+		{"monthly on the second-to-last Monday for 6 months", "19970922", "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO",
+			[]string{"19970922", "19971020", "19971117", "19971222", "19980119", "19980216"}},
+		{"monthly on the third-to-the-last day", "19970928", "FREQ=MONTHLY;BYMONTHDAY=-3",
+			[]string{"19970928", "19971029", "19971128", "19971229", "19980129", "19980226"}},
+		{"monthly on the 2nd and 15th for 10 occurrences", "19970902", "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15",
+			[]string{"19970902", "19970915", "19971002", "19971015", "19971102", "19971115", "19971202", "19971215", "19980102", "19980115"}},
+		{"monthly on the first and last day for 10 occurrences", "19970930", "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1",
+			[]string{"19970930", "19971001", "19971031", "19971101", "19971130", "19971201", "19971231", "19980101", "19980131", "19980201"}},
+		{"every 18 months on the 10th to 15th", "19970910", "FREQ=MONTHLY;INTERVAL=18;COUNT=10;BYMONTHDAY=10,11,12,13,14,15",
+			[]string{"19970910", "19970911", "19970912", "19970913", "19970914", "19970915", "19990310", "19990311", "19990312", "19990313"}},
+		{"every Tuesday, every other month", "19970902", "FREQ=MONTHLY;INTERVAL=2;BYDAY=TU",
+			[]string{"19970902", "19970909", "19970916", "19970923", "19970930", "19971104", "19971111", "19971118", "19971125", "19980106"}},
+		{"yearly in June and July for 10 occurrences", "19970610", "FREQ=YEARLY;COUNT=10;BYMONTH=6,7",
+			[]string{"19970610", "19970710", "19980610", "19980710", "19990610", "19990710", "20000610", "20000710", "20010610", "20010710"}},
+		{"every other year on January, February and March", "19970310", "FREQ=YEARLY;INTERVAL=2;COUNT=10;BYMONTH=1,2,3",
+			[]string{"19970310", "19990110", "19990210", "19990310", "20010110", "20010210", "20010310", "20030110", "20030210", "20030310"}},
+		{"every 20th Monday of the year", "19970519", "FREQ=YEARLY;BYDAY=20MO",
+			[]string{"19970519", "19980518", "19990517"}},
+		{"every Thursday in March", "19970313", "FREQ=YEARLY;BYMONTH=3;BYDAY=TH",
+			[]string{"19970313", "19970320", "19970327", "19980305", "19980312", "19980319", "19980326", "19990304"}},
+		{"every Thursday in June, July and August", "19970605", "FREQ=YEARLY;BYDAY=TH;BYMONTH=6,7,8",
+			[]string{"19970605", "19970612", "19970619", "19970626", "19970703", "19970710", "19970717", "19970724", "19970731",
+				"19970807", "19970814", "19970821", "19970828", "19980604"}},
+		// The RFC excludes DTSTART with an EXDATE
+		{"every Friday the 13th", "19970902", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			[]string{"19970902", "19980213", "19980313", "19981113", "19990813", "20001013"}},
+		{"the first Saturday after the first Sunday of the month", "19970913", "FREQ=MONTHLY;BYDAY=SA;BYMONTHDAY=7,8,9,10,11,12,13",
+			[]string{"19970913", "19971011", "19971108", "19971213", "19980110", "19980207", "19980307", "19980411", "19980509", "19980613"}},
+		{"US presidential election day", "19961105", "FREQ=YEARLY;INTERVAL=4;BYMONTH=11;BYDAY=TU;BYMONTHDAY=2,3,4,5,6,7,8",
+			[]string{"19961105", "20001107", "20041102"}},
+		{"skips months without the day", "20070115", "FREQ=MONTHLY;BYMONTHDAY=15,30;COUNT=5",
+			[]string{"20070115", "20070130", "20070215", "20070315", "20070330"}},
+
+		// Yearly rules without BYMONTH expand over the whole year
+		{"yearly on the 1st of every month", "19970101", "FREQ=YEARLY;BYMONTHDAY=1",
+			[]string{"19970101", "19970201", "19970301", "19970401", "19970501", "19970601", "19970701", "19970801", "19970901",
+				"19971001", "19971101", "19971201", "19980101"}},
+		{"yearly on the last day of every month", "19970131", "FREQ=YEARLY;BYMONTHDAY=-1;COUNT=4",
+			[]string{"19970131", "19970228", "19970331", "19970430"}},
+		{"yearly on every Monday", "19971222", "FREQ=YEARLY;BYDAY=MO;COUNT=4",
+			[]string{"19971222", "19971229", "19980105", "19980112"}},
+		{"yearly on every Friday the 13th", "19970902", "FREQ=YEARLY;BYDAY=FR;BYMONTHDAY=13",
+			[]string{"19970902", "19980213", "19980313", "19981113", "19990813", "20001013"}},
+		{"yearly on the first and last Sunday of the year", "19970105", "FREQ=YEARLY;BYDAY=1SU,-1SU;COUNT=4",
+			[]string{"19970105", "19971228", "19980104", "19981227"}},
+		{"yearly on start's date", "19960229", "FREQ=YEARLY;COUNT=3",
+			[]string{"19960229", "20000229", "20040229"}},
+	}
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRecurrence(tt.rule, ny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			day, err := time.ParseInLocation("20060102", tt.start, ny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := day.Add(9 * time.Hour)
+			got, _ := rule.Expand(start, start, start.AddDate(10, 0, 0), len(tt.want))
+			dates := make([]string, len(got))
+			for i, occurrence := range got {
+				dates[i] = occurrence.Format("20060102")
+				if h, m, _ := occurrence.Clock(); h != 9 || m != 0 {
+					t.Errorf("occurrence %s is at %02d:%02d, want 09:00", occurrence, h, m)
+				}
+			}
+			if !slices.Equal(dates, tt.want) {
+				t.Fatalf("got %v\nwant %v", dates, tt.want)
+			}
+		})
+	}
+}
+
+// januaries lists every day of January 1998 to 2000.
+func januaries() []string {
+	var days []string
+	for year := 1998; year <= 2000; year++ {
+		for d := 1; d <= 31; d++ {
+			days = append(days, time.Date(year, time.January, d, 0, 0, 0, 0, time.UTC).Format("20060102"))
+		}
+	}
+	return days
+}
+
+func TestExpandEnds(t *testing.T) {
+	rule, err := ParseRecurrence("FREQ=YEARLY;BYMONTHDAY=1;COUNT=3", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, time.November, 1, 9, 0, 0, 0, time.UTC)
+	got, more := rule.Expand(start, start, start.AddDate(5, 0, 0), 10)
+	want := []time.Time{start, start.AddDate(0, 1, 0), start.AddDate(0, 2, 0)}
+	if !slices.EqualFunc(got, want, time.Time.Equal) || more {
+		t.Fatalf("got %v (more: %v), want %v and no more", got, more, want)
+	}
+
+	// A window ending early reports that the series goes on
+	got, more = rule.Expand(start, start, start.AddDate(0, 1, 0), 10)
+	if len(got) != 1 || !more {
+		t.Fatalf("got %v (more: %v), want the first occurrence and more", got, more)
+	}
+}
@@ -0,0 +1,202 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "20060102"
+	localLayout    = "20060102T150405"
+	utcLayout      = "20060102T150405Z"
+	offsetLayout   = "-0700"
+	maxTransitions = 400
+)
+
+// FormatUTC formats a DATE-TIME in UTC.
+func FormatUTC(t time.Time) string {
+	return t.UTC().Format(utcLayout)
+}
+
+// FormatLocal formats a DATE-TIME as wall clock time, to be written with a
+// TZID parameter.
+func FormatLocal(t time.Time) string {
+	return t.Format(localLayout)
+}
+
+// FormatDate formats a DATE.
+func FormatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+// AddTime appends a DATE-TIME property in t's location: UTC times are
+// written with a Z suffix, others as wall clock time with a TZID.
+func (c *Component) AddTime(name string, t time.Time) {
+	if loc := t.Location(); loc == time.UTC || loc.String() == "" {
+		c.Add(name, FormatUTC(t))
+		return
+	}
+	c.Add(name, FormatLocal(t), "TZID", t.Location().String())
+}
+
+// AddDate appends a DATE property.
+func (c *Component) AddDate(name string, t time.Time) {
+	c.Add(name, FormatDate(t), "VALUE", "DATE")
+}
+
+// Time parses a DATE or DATE-TIME property. Times with a TZID are read
+// in that zone, floating times (no TZID, no Z) in loc. allDay reports a
+// DATE value, which is returned as midnight in loc.
+func (p *Property) Time(loc *time.Location) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(p.Value)
+	if p.Params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		t, err = time.ParseInLocation(dateLayout, value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse(utcLayout, value)
+		return t, false, err
+	}
+	if tzid := p.Params["TZID"]; tzid != "" {
+		loc = LoadLocation(tzid)
+	}
+	t, err = time.ParseInLocation(localLayout, value, loc)
+	return t, false, err
+}
+
+// Times parses a property holding a comma-separated list of dates or
+// date-times, such as EXDATE or RDATE.
+func (p *Property) Times(loc *time.Location) ([]time.Time, error) {
+	var times []time.Time
+	for _, value := range strings.Split(p.Value, ",") {
+		single := Property{Name: p.Name, Params: p.Params, Value: value}
+		t, _, err := single.Time(loc)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// LoadLocation resolves a TZID. Besides IANA names it accepts the
+// "/mozilla.org/.../Europe/Berlin" style prefixes some clients write, and
+// falls back to UTC for zones it does not know.
+func LoadLocation(tzid string) *time.Location {
+	tzid = strings.Trim(tzid, `"`)
+	for {
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			return loc
+		}
+		i := strings.IndexByte(tzid, '/')
+		if i < 0 || i == len(tzid)-1 {
+			return time.UTC
+		}
+		tzid = tzid[i+1:]
+	}
+}
+
+// ParseDuration parses a DURATION value such as "PT1H30M", "P1D" or
+// "-P2W".
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, fmt.Errorf("ical: invalid duration %q", orig)
+	}
+	s = s[1:]
+	var d time.Duration
+	inTime := false
+	for s != "" {
+		if s[0] == 'T' {
+			inTime, s = true, s[1:]
+			continue
+		}
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, fmt.Errorf("ical: invalid duration %q", orig)
+		}
+		n, _ := strconv.Atoi(s[:i])
+		unit := time.Duration(n)
+		switch {
+		case s[i] == 'W' && !inTime:
+			d += unit * 7 * 24 * time.Hour
+		case s[i] == 'D' && !inTime:
+			d += unit * 24 * time.Hour
+		case s[i] == 'H' && inTime:
+			d += unit * time.Hour
+		case s[i] == 'M' && inTime:
+			d += unit * time.Minute
+		case s[i] == 'S' && inTime:
+			d += unit * time.Second
+		default:
+			return 0, fmt.Errorf("ical: invalid duration %q", orig)
+		}
+		s = s[i+1:]
+	}
+	return sign * d, nil
+}
+
+// Timezone builds a VTIMEZONE describing loc between from and to, listing
+// every offset change in that window as its own STANDARD or DAYLIGHT
+// observance. Explicit transitions are understood by every client, unlike
+// RRULE-based observances which cannot express historical rule changes.
+func Timezone(loc *time.Location, from, to time.Time) *Component {
+	tz := NewComponent("VTIMEZONE")
+	tz.Add("TZID", loc.String())
+
+	t := from.In(loc)
+	name, offset := t.Zone()
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		start = t
+	}
+	// The first observance is the one in effect when the window opens,
+	// starting at the transition that introduced it.
+	_, before := start.Add(-time.Second).In(loc).Zone()
+	tz.Append(observance(t.IsDST(), start.In(loc), name, before, offset))
+
+	for i := 0; i < maxTransitions; i++ {
+		_, end := t.ZoneBounds()
+		if end.IsZero() || end.After(to) {
+			break
+		}
+		next := end.In(loc)
+		nextName, nextOffset := next.Zone()
+		tz.Append(observance(next.IsDST(), next, nextName, offset, nextOffset))
+		t, offset = next, nextOffset
+	}
+	return tz
+}
+
+func observance(dst bool, start time.Time, name string, from, to int) *Component {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	c := NewComponent(kind)
+	// DTSTART of an observance is the local time in the offset in effect
+	// before the change.
+	c.Add("DTSTART", start.In(time.FixedZone("", from)).Format(localLayout))
+	c.Add("TZOFFSETFROM", formatOffset(from))
+	c.Add("TZOFFSETTO", formatOffset(to))
+	if name != "" && !strings.HasPrefix(name, "+") && !strings.HasPrefix(name, "-") {
+		c.Add("TZNAME", name)
+	}
+	return c
+}
+
+func formatOffset(seconds int) string {
+	return time.Unix(0, 0).In(time.FixedZone("", seconds)).Format(offsetLayout)
+}
@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// SMTPMailer sends e-mail through an SMTP relay.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates a mailer for the relay at addr (host:port). PLAIN
+// authentication is used when a username is given.
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg domain.Email) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("mail: no recipients")
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, msg.To, Render(m.from, msg))
+}
+
+// LogMailer writes e-mail to the log instead of sending it. It is used in
+// development when no SMTP relay is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg domain.Email) error {
+	log.Printf("mail to %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, msg.Text)
+	return nil
+}
+
+// Render encodes msg as a MIME message with a text part and, when set, an
+// HTML alternative.
+func Render(from string, msg domain.Email) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", msg.ReplyTo)
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, msg.Text)
+		return buf.Bytes()
+	}
+
+	boundary := newBoundary()
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&buf, part.body)
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, s string) {
+	w := quotedprintable.NewWriter(buf)
+	w.Write([]byte(s))
+	w.Close()
+}
+
+func newBoundary() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return "greact-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,211 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// maxMIMEDepth bounds how deeply multipart bodies are followed.
+const maxMIMEDepth = 10
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// Parse reads a MIME message. Recipients are taken from the To, Cc,
+// Delivered-To and X-Original-To headers; callers that know the envelope
+// recipients should use those instead. Text is the first text/plain
+// part, or the first text/html part converted to text.
+func Parse(r io.Reader) (*domain.InboundEmail, error) {
+	m, err := netmail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("mail: reading message: %w", err)
+	}
+	msg := &domain.InboundEmail{
+		MessageID: strings.Trim(strings.TrimSpace(m.Header.Get("Message-Id")), "<>"),
+		Automated: automated(m.Header),
+	}
+	if subject, err := headerDecoder.DecodeHeader(m.Header.Get("Subject")); err == nil {
+		msg.Subject = strings.TrimSpace(subject)
+	} else {
+		msg.Subject = m.Header.Get("Subject")
+	}
+	if from, err := addressParser().Parse(m.Header.Get("From")); err == nil {
+		msg.From = from.Address
+	}
+	for _, h := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		if m.Header.Get(h) == "" {
+			continue
+		}
+		if list, err := addressParser().ParseList(m.Header.Get(h)); err == nil {
+			for _, a := range list {
+				msg.Recipients = append(msg.Recipients, a.Address)
+			}
+		}
+	}
+
+	p := &parser{msg: msg}
+	if err := p.walk(textproto.MIMEHeader(m.Header), m.Body, 0); err != nil {
+		return nil, err
+	}
+	msg.Text = p.text
+	if msg.Text == "" && p.html != "" {
+		msg.Text = HTMLToText(p.html)
+	}
+	msg.Reply = ExtractReply(msg.Text)
+	return msg, nil
+}
+
+func addressParser() *netmail.AddressParser {
+	return &netmail.AddressParser{WordDecoder: headerDecoder}
+}
+
+// automated reports bounces, auto-replies and list mail.
+func automated(h netmail.Header) bool {
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	return h.Get("X-Autoreply") != "" || h.Get("X-Autorespond") != "" || h.Get("List-Id") != "" ||
+		strings.TrimSpace(h.Get("Return-Path")) == "<>"
+}
+
+type parser struct {
+	msg  *domain.InboundEmail
+	text string
+	html string
+}
+
+func (p *parser) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return nil
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("mail: reading multipart body: %w", err)
+			}
+			if err := p.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("mail: decoding body: %w", err)
+	}
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if decoded, err := headerDecoder.DecodeHeader(name); err == nil {
+		name = decoded
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if disposition == "attachment" || name != "" || !isText {
+		if name == "" {
+			name = fmt.Sprintf("attachment-%d", len(p.msg.Attachments)+1)
+			if mediaType == "message/rfc822" {
+				name += ".eml"
+			} else if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+		p.msg.Attachments = append(p.msg.Attachments, domain.InboundAttachment{
+			Name: name, ContentType: mediaType, Content: content,
+		})
+		return nil
+	}
+	text := decodeCharset(params["charset"], content)
+	if mediaType == "text/plain" && p.text == "" {
+		p.text = text
+	} else if mediaType == "text/html" && p.html == "" {
+		p.html = text
+	}
+	return nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// decodeCharset converts text to UTF-8, replacing what cannot be decoded.
+func decodeCharset(charset string, content []byte) string {
+	if charset != "" && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "us-ascii") {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(content); err == nil {
+				content = decoded
+			}
+		}
+	}
+	if !utf8.Valid(content) {
+		content = bytes.ToValidUTF8(content, []byte("�"))
+	}
+	return strings.ReplaceAll(string(content), "\r\n", "\n")
+}
+
+var (
+	htmlDropped = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+	htmlBreaks  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTags    = regexp.MustCompile(`(?s)<[^>]*>`)
+	// The quoted history of common mail clients starts at these markers
+	htmlQuotes = regexp.MustCompile(`(?i)<blockquote\b|class="gmail_quote|id="divRplyFwdMsg"|id="appendonsend"`)
+)
+
+// HTMLToText renders the text of an HTML mail body, without the quoted
+// history where it can be recognized.
+func HTMLToText(s string) string {
+	if loc := htmlQuotes.FindStringIndex(s); loc != nil {
+		s = s[:loc[0]]
+	}
+	s = htmlDropped.ReplaceAllString(s, "")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(htmlTags.ReplaceAllString(s, ""))
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
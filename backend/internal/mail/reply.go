@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"regexp"
+	"strings"
+
+	"greact-bones/backend/internal/domain"
+)
+
+var (
+	// "On Tue, 3 Sep 2024 at 10:00, Ann <ann@example.com> wrote:", which
+	// clients may wrap onto a second line
+	replyAttribution = regexp.MustCompile(`(?i)^(on|am|le|el|il|op)\s.*(wrote|schrieb|a écrit|escribió|ha scritto|schreef)\s*:$`)
+	replySeparator   = regexp.MustCompile(`(?i)^(-{2,}\s*original message\s*-{2,}|_{10,}|-{2,}\s*forwarded message\s*-{2,})`)
+	replyHeader      = regexp.MustCompile(`(?i)^\*?(from|von|de):\*?\s`)
+	replyHeaderNext  = regexp.MustCompile(`(?i)^\*?(sent|date|to|subject|gesendet|envoyé|enviado):\*?\s`)
+	replyMobile      = regexp.MustCompile(`(?i)^sent from my \w+`)
+)
+
+// ExtractReply returns the text a person wrote in reply, without the
+// quoted history below it, quoted lines and their signature.
+func ExtractReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	end := len(lines)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		next := ""
+		if i+1 < len(lines) {
+			next = strings.TrimSpace(lines[i+1])
+		}
+		if strings.Contains(line, domain.ReplyAboveMarker) ||
+			replyAttribution.MatchString(line) ||
+			(next != "" && replyAttribution.MatchString(line+" "+next)) ||
+			replySeparator.MatchString(line) ||
+			(replyHeader.MatchString(line) && replyHeaderNext.MatchString(next)) {
+			end = i
+			break
+		}
+		// A signature starts with "-- " by convention
+		if lines[i] == "-- " || line == "--" || replyMobile.MatchString(line) {
+			end = i
+			break
+		}
+	}
+
+	var kept []string
+	for _, line := range lines[:end] {
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		kept = append(kept, strings.TrimRight(line, " \t"))
+	}
+	// Collapse runs of blank lines left behind by removed quotes
+	out := make([]string, 0, len(kept))
+	for _, line := range kept {
+		if line == "" && len(out) > 0 && out[len(out)-1] == "" {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
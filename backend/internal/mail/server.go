@@ -0,0 +1,312 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	maxSMTPSessions   = 100
+	maxSMTPRecipients = 100
+	smtpTimeout       = 5 * time.Minute
+)
+
+// InboundHandler acts on the mail Server receives.
+type InboundHandler interface {
+	// Accepts reports whether mail to recipient is wanted.
+	Accepts(recipient string) bool
+	// Receive acts on a message; an error makes the sender retry later.
+	Receive(ctx context.Context, msg *domain.InboundEmail) ([]domain.InboundMessage, error)
+}
+
+// Server is an SMTP receiver for the inbound mail domain, meant to be the
+// domain's MX or to sit behind one. It accepts only recipients the
+// handler wants and hands each message to it before answering, so mail
+// that cannot be acted on is refused to the sender instead of being
+// bounced later. STARTTLS is offered when a TLS configuration is set.
+type Server struct {
+	hostname string
+	maxBytes int64
+	handler  InboundHandler
+	tls      *tls.Config
+	sessions sync.WaitGroup
+}
+
+// NewServer creates an SMTP receiver introducing itself as hostname and
+// refusing messages larger than maxBytes.
+func NewServer(hostname string, maxBytes int64, handler InboundHandler) *Server {
+	return &Server{hostname: hostname, maxBytes: maxBytes, handler: handler}
+}
+
+// UseTLS offers STARTTLS with cfg.
+func (s *Server) UseTLS(cfg *tls.Config) {
+	s.tls = cfg
+}
+
+// ListenAndServe accepts connections on addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is cancelled, then waits for
+// the sessions in progress to end.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	defer s.sessions.Wait()
+
+	slots := make(chan struct{}, maxSMTPSessions)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			conn.Write([]byte("421 4.3.2 Too many connections, try again later\r\n"))
+			conn.Close()
+			continue
+		}
+		s.sessions.Add(1)
+		go func() {
+			defer s.sessions.Done()
+			defer func() { <-slots }()
+			(&smtpSession{server: s, conn: conn}).serve(ctx)
+		}()
+	}
+}
+
+type smtpSession struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+	tls    bool
+
+	helo       string
+	from       string
+	mailFrom   bool
+	recipients []string
+}
+
+func (c *smtpSession) serve(ctx context.Context) {
+	defer c.conn.Close()
+	c.text = textproto.NewConn(c.conn)
+	c.reply(220, c.server.hostname+" ESMTP ready")
+	for {
+		c.conn.SetDeadline(time.Now().Add(smtpTimeout))
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			c.reset()
+			c.helo = arg
+			c.reply(250, c.server.hostname)
+		case "EHLO":
+			c.reset()
+			c.helo = arg
+			ext := []string{c.server.hostname, "SIZE " + strconv.FormatInt(c.server.maxBytes, 10), "8BITMIME"}
+			if c.server.tls != nil && !c.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			c.reply(250, ext...)
+		case "STARTTLS":
+			if c.server.tls == nil || c.tls {
+				c.reply(502, "5.5.1 STARTTLS not available")
+				continue
+			}
+			c.reply(220, "2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(c.conn, c.server.tls)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return
+			}
+			c.conn, c.text, c.tls = tlsConn, textproto.NewConn(tlsConn), true
+			c.reset()
+			c.helo = ""
+		case "MAIL":
+			c.mail(arg)
+		case "RCPT":
+			c.rcpt(arg)
+		case "DATA":
+			if !c.data(ctx) {
+				return
+			}
+		case "RSET":
+			c.reset()
+			c.reply(250, "2.0.0 OK")
+		case "NOOP":
+			c.reply(250, "2.0.0 OK")
+		case "VRFY":
+			c.reply(252, "2.5.0 Cannot verify, send some mail")
+		case "QUIT":
+			c.reply(221, "2.0.0 Bye")
+			return
+		default:
+			c.reply(502, "5.5.2 Command not recognized")
+		}
+	}
+}
+
+func (c *smtpSession) reset() {
+	c.from, c.mailFrom, c.recipients = "", false, nil
+}
+
+func (c *smtpSession) reply(code int, lines ...string) {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		c.text.PrintfLine("%d%s%s", code, sep, line)
+	}
+}
+
+// pathArg returns the address in "FROM:<addr> PARAMS" and the params.
+func pathArg(arg, prefix string) (string, string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", "", false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", "", false
+	}
+	return rest[1:end], strings.TrimSpace(rest[end+1:]), true
+}
+
+func (c *smtpSession) mail(arg string) {
+	if c.helo == "" {
+		c.reply(503, "5.5.1 Say EHLO first")
+		return
+	}
+	if c.mailFrom {
+		c.reply(503, "5.5.1 Sender already given")
+		return
+	}
+	from, params, ok := pathArg(arg, "FROM:")
+	if !ok {
+		c.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range strings.Fields(params) {
+		if key, value, _ := strings.Cut(param, "="); strings.EqualFold(key, "SIZE") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > c.server.maxBytes {
+				c.reply(552, "5.3.4 Message too large")
+				return
+			}
+		}
+	}
+	c.from, c.mailFrom = from, true
+	c.reply(250, "2.1.0 OK")
+}
+
+func (c *smtpSession) rcpt(arg string) {
+	if !c.mailFrom {
+		c.reply(503, "5.5.1 Need MAIL first")
+		return
+	}
+	to, _, ok := pathArg(arg, "TO:")
+	if !ok {
+		c.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if len(c.recipients) >= maxSMTPRecipients {
+		c.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+	if !c.server.handler.Accepts(to) {
+		c.reply(550, "5.1.1 No such recipient")
+		return
+	}
+	c.recipients = append(c.recipients, to)
+	c.reply(250, "2.1.5 OK")
+}
+
+// data receives and hands over a message. It returns false when the
+// connection cannot be used any more.
+func (c *smtpSession) data(ctx context.Context) bool {
+	if len(c.recipients) == 0 {
+		c.reply(503, "5.5.1 Need RCPT first")
+		return true
+	}
+	c.reply(354, "End data with <CR><LF>.<CR><LF>")
+	var buf bytes.Buffer
+	dot := c.text.DotReader()
+	n, err := io.Copy(&buf, io.LimitReader(dot, c.server.maxBytes+1))
+	if err != nil {
+		return false
+	}
+	defer c.reset()
+	if n > c.server.maxBytes {
+		// Read the rest so the session stays in sync
+		if _, err := io.Copy(io.Discard, dot); err != nil {
+			return false
+		}
+		c.reply(552, "5.3.4 Message too large")
+		return true
+	}
+
+	msg, err := Parse(bufio.NewReader(&buf))
+	if err != nil {
+		c.reply(554, "5.6.0 Malformed message")
+		return true
+	}
+	msg.Recipients = c.recipients
+	if c.from == "" {
+		msg.Automated = true
+	}
+	results, err := c.server.handler.Receive(ctx, msg)
+	if err != nil {
+		log.Printf("inbound mail from %s: %v", c.from, err)
+		c.reply(451, "4.3.0 Temporary failure, try again later")
+		return true
+	}
+	var reasons []string
+	for _, r := range results {
+		if r.Status != domain.InboundRejected {
+			c.reply(250, "2.0.0 OK")
+			return true
+		}
+		reasons = append(reasons, r.Reason)
+	}
+	if len(reasons) == 0 {
+		// Every recipient already received this message
+		c.reply(250, "2.0.0 OK")
+		return true
+	}
+	c.reply(550, fmt.Sprintf("5.7.1 Message not accepted: %s", strings.Join(reasons, "; ")))
+	return true
+}
@@ -0,0 +1,179 @@
+// Package malware implements domain.Scanner with ClamAV's clamd daemon
+// and with a built-in signature matcher.
+package malware
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	clamdChunkSize      = 64 << 10
+	clamdDefaultTimeout = 2 * time.Minute
+)
+
+// ErrClamdStreamLimit means clamd refused content larger than its
+// StreamMaxLength setting.
+var ErrClamdStreamLimit = errors.New("clamd: content exceeds StreamMaxLength")
+
+// Clamd scans content with a clamd daemon over its socket protocol,
+// streaming it with INSTREAM so the daemon needs no access to the files.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd creates a client for the daemon at addr: "unix:///path/to.sock",
+// "tcp://host:port" or just "host:port".
+func NewClamd(addr string) (*Clamd, error) {
+	c := &Clamd{network: "tcp", address: addr, timeout: clamdDefaultTimeout}
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		c.network, c.address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		c.address = strings.TrimPrefix(addr, "tcp://")
+	}
+	if c.address == "" {
+		return nil, fmt.Errorf("clamd: no address in %q", addr)
+	}
+	return c, nil
+}
+
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+// Ping checks that the daemon answers.
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
+
+// Version returns the engine and signature database version, e.g.
+// "ClamAV 1.0.5/27180". The database build date clamd appends is left
+// out; the database version alone changes with every update.
+func (c *Clamd) Version(ctx context.Context) (string, error) {
+	reply, err := c.command(ctx, "VERSION", nil)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(reply, "/", 3)
+	if len(parts) < 2 {
+		return reply, nil
+	}
+	return parts[0] + "/" + parts[1], nil
+}
+
+// Scan streams r to the daemon. Replies are "stream: OK",
+// "stream: <signature> FOUND" or an error.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (domain.ScanResult, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return domain.ScanResult{}, err
+	}
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(reply, " FOUND")
+		if _, after, ok := strings.Cut(signature, ": "); ok {
+			signature = after
+		}
+		return domain.ScanResult{Infected: true, Signature: signature}, nil
+	case strings.HasSuffix(reply, ": OK"):
+		return domain.ScanResult{}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return domain.ScanResult{}, ErrClamdStreamLimit
+	}
+	return domain.ScanResult{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// command sends a NUL-terminated command ("z" prefix), the content for
+// INSTREAM as length-prefixed chunks ending with an empty one, and reads
+// the NUL-terminated reply.
+func (c *Clamd) command(ctx context.Context, name string, content io.Reader) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(c.timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("z" + name + "\x00"); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	if content != nil {
+		if err := writeChunks(w, content); err != nil {
+			// clamd closes the connection after a size limit error, which
+			// fails the write; its reply says why
+			if reply, readErr := readReply(conn); readErr == nil && reply != "" {
+				return reply, nil
+			}
+			return "", fmt.Errorf("clamd: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return "", fmt.Errorf("clamd: reading reply: %w", err)
+	}
+	return reply, nil
+}
+
+func writeChunks(w *bufio.Writer, r io.Reader) error {
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := w.Write(size[:]); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	_, err := w.Write(size[:])
+	return err
+}
+
+// readReply reads up to the terminating NUL; clamd also closes the
+// connection after replying.
+func readReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(io.LimitReader(r, 4096)).ReadString(0)
+	if err != nil && (!errors.Is(err, io.EOF) || reply == "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
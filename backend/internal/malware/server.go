@@ -0,0 +1,143 @@
+package malware
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// ClamdServer speaks the clamd protocol (PING, VERSION and INSTREAM) on
+// top of any scanner. Backed by Signatures, it stands in for ClamAV in
+// development and for exercising the Clamd client without installing
+// ClamAV.
+type ClamdServer struct {
+	Scanner domain.Scanner
+	// MaxStream is the largest accepted stream in bytes, like clamd's
+	// StreamMaxLength.
+	MaxStream int64
+}
+
+// Serve handles connections on l until it is closed.
+func (s *ClamdServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers one command. Commands are prefixed with "z" and end with
+// NUL, or with "n" and end with a newline; replies end the same way.
+func (s *ClamdServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clamdDefaultTimeout))
+	r := bufio.NewReader(conn)
+
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	delim := byte(0)
+	switch prefix {
+	case 'z':
+	case 'n':
+		delim = '\n'
+	default:
+		return
+	}
+	command, err := r.ReadString(delim)
+	if err != nil {
+		return
+	}
+	reply := s.reply(strings.TrimSuffix(command, string(delim)), r)
+	conn.Write(append([]byte(reply), delim))
+}
+
+func (s *ClamdServer) reply(command string, r io.Reader) string {
+	ctx := context.Background()
+	switch command {
+	case "PING":
+		return "PONG"
+	case "VERSION":
+		version, err := s.Scanner.Version(ctx)
+		if err != nil {
+			return err.Error() + " ERROR"
+		}
+		return fmt.Sprintf("%s/%s", version, time.Now().UTC().Format(time.ANSIC))
+	case "INSTREAM":
+		stream := &chunkReader{r: r, max: s.MaxStream}
+		result, err := s.Scanner.Scan(ctx, stream)
+		if err == nil {
+			// A scanner may stop at the first match; read the rest so the
+			// client is not reset while still sending
+			_, err = io.Copy(io.Discard, stream)
+		}
+		switch {
+		case errors.Is(err, errStreamLimit):
+			return "INSTREAM size limit exceeded. ERROR"
+		case err != nil:
+			log.Printf("clamd: scan failed: %v", err)
+			return err.Error() + " ERROR"
+		case result.Infected:
+			return "stream: " + result.Signature + " FOUND"
+		}
+		return "stream: OK"
+	}
+	return "UNKNOWN COMMAND"
+}
+
+var errStreamLimit = errors.New("stream limit exceeded")
+
+// chunkReader decodes INSTREAM chunks: a 4-byte big-endian length and
+// that many bytes, until a zero length.
+type chunkReader struct {
+	r     io.Reader
+	max   int64
+	total int64
+	chunk int64
+	done  bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	for c.chunk == 0 {
+		var size [4]byte
+		if _, err := io.ReadFull(c.r, size[:]); err != nil {
+			return 0, err
+		}
+		c.chunk = int64(binary.BigEndian.Uint32(size[:]))
+		if c.chunk == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.total += c.chunk
+		if c.max > 0 && c.total > c.max {
+			return 0, errStreamLimit
+		}
+	}
+	if int64(len(p)) > c.chunk {
+		p = p[:c.chunk]
+	}
+	n, err := c.r.Read(p)
+	c.chunk -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
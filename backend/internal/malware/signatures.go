@@ -0,0 +1,226 @@
+package malware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// Kinds of signature rules
+const (
+	// RuleHex matches a byte sequence, written in hex, anywhere in the
+	// content.
+	RuleHex = "hex"
+	// RuleText matches a literal string anywhere in the content.
+	RuleText = "text"
+	// RuleSHA256 matches content with the given hex SHA-256 digest.
+	RuleSHA256 = "sha256"
+)
+
+// eicar is the standard antivirus test file, detected by every scanner,
+// so detection can be tried without real malware.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// BuiltinRules are always active.
+var BuiltinRules = []Rule{
+	{Name: "Eicar-Test-Signature", Kind: RuleText, Pattern: []byte(eicar)},
+}
+
+// Rule is one signature.
+type Rule struct {
+	Name    string
+	Kind    string
+	Pattern []byte
+}
+
+// ParseRules reads a signature file. Each line holds a name, a kind and
+// a pattern separated by whitespace; for text rules the pattern is the
+// rest of the line. Empty lines and lines starting with # are ignored:
+//
+//	# name                 kind    pattern
+//	Example-Dropper        hex     4d5a900003000000deadbeef
+//	Example-Macro          text    Sub AutoOpen() Shell
+//	Example-Known-Bad      sha256  9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected name, kind and pattern", line)
+		}
+		rule := Rule{Name: fields[0], Kind: strings.ToLower(fields[1])}
+		var err error
+		switch rule.Kind {
+		case RuleHex:
+			rule.Pattern, err = hex.DecodeString(strings.Join(fields[2:], ""))
+		case RuleSHA256:
+			rule.Pattern, err = hex.DecodeString(fields[2])
+			if err == nil && len(rule.Pattern) != sha256.Size {
+				err = fmt.Errorf("a SHA-256 digest has %d hex digits", sha256.Size*2)
+			}
+		case RuleText:
+			// Everything after the kind, inner spacing included
+			_, rest, _ := strings.Cut(text, fields[0])
+			_, rest, _ = strings.Cut(rest, fields[1])
+			rule.Pattern = []byte(strings.TrimSpace(rest))
+		default:
+			err = fmt.Errorf("unknown kind %q (use hex, text or sha256)", fields[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rule.Pattern) == 0 {
+			return nil, fmt.Errorf("line %d: empty pattern", line)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Signatures scans content against BuiltinRules and the rules of an
+// optional signature file. The file is reloaded when it changes, which
+// also changes the version and so makes all content be scanned again.
+type Signatures struct {
+	path string
+
+	mu      sync.Mutex
+	rules   []Rule
+	version string
+	modTime time.Time
+}
+
+// NewSignatures loads the rules in path, if given.
+func NewSignatures(path string) (*Signatures, error) {
+	s := &Signatures{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Signatures) Name() string {
+	return "signatures"
+}
+
+// Version is a digest of the active rules.
+func (s *Signatures) Version(ctx context.Context) (string, error) {
+	if err := s.reload(); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version, nil
+}
+
+// Rules returns the active rules.
+func (s *Signatures) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rules
+}
+
+// Scan reads r once, matching patterns across read boundaries and
+// hashing the content for digest rules.
+func (s *Signatures) Scan(ctx context.Context, r io.Reader) (domain.ScanResult, error) {
+	if err := s.reload(); err != nil {
+		return domain.ScanResult{}, err
+	}
+	rules := s.Rules()
+	overlap := 0
+	for _, rule := range rules {
+		if rule.Kind != RuleSHA256 && len(rule.Pattern) > overlap {
+			overlap = len(rule.Pattern)
+		}
+	}
+
+	digest := sha256.New()
+	buf := make([]byte, 0, overlap+64<<10)
+	chunk := make([]byte, 64<<10)
+	for {
+		if err := ctx.Err(); err != nil {
+			return domain.ScanResult{}, err
+		}
+		n, err := r.Read(chunk)
+		if n > 0 {
+			digest.Write(chunk[:n])
+			// Keep the tail of the previous read so patterns spanning two
+			// reads are found
+			if keep := overlap - 1; keep >= 0 && len(buf) > keep {
+				buf = append(buf[:0], buf[len(buf)-keep:]...)
+			}
+			buf = append(buf, chunk[:n]...)
+			for _, rule := range rules {
+				if rule.Kind != RuleSHA256 && bytes.Contains(buf, rule.Pattern) {
+					return domain.ScanResult{Infected: true, Signature: rule.Name}, nil
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return domain.ScanResult{}, err
+		}
+	}
+	sum := digest.Sum(nil)
+	for _, rule := range rules {
+		if rule.Kind == RuleSHA256 && bytes.Equal(sum, rule.Pattern) {
+			return domain.ScanResult{Infected: true, Signature: rule.Name}, nil
+		}
+	}
+	return domain.ScanResult{}, nil
+}
+
+// reload reads the signature file when it changed since the last load.
+func (s *Signatures) reload() error {
+	var modTime time.Time
+	if s.path != "" {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return fmt.Errorf("signatures: %w", err)
+		}
+		modTime = info.ModTime()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version != "" && modTime.Equal(s.modTime) {
+		return nil
+	}
+
+	rules := append([]Rule(nil), BuiltinRules...)
+	if s.path != "" {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return fmt.Errorf("signatures: %w", err)
+		}
+		defer f.Close()
+		custom, err := ParseRules(f)
+		if err != nil {
+			return fmt.Errorf("signatures: %s: %w", s.path, err)
+		}
+		rules = append(rules, custom...)
+	}
+	h := sha256.New()
+	for _, rule := range rules {
+		fmt.Fprintf(h, "%s\x00%s\x00%x\n", rule.Name, rule.Kind, rule.Pattern)
+	}
+	s.rules = rules
+	s.modTime = modTime
+	s.version = fmt.Sprintf("%d rules/%x", len(rules), h.Sum(nil)[:6])
+	return nil
+}
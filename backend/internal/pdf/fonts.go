@@ -0,0 +1,71 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// fontFamily holds the TrueType files of a family by gofpdf style: "",
+// "B", "I" and "BI". Only the regular style is required; the others fall
+// back to it.
+type fontFamily map[string][]byte
+
+func builtinFonts() map[string]fontFamily {
+	return map[string]fontFamily{
+		"sans": {"": goregular.TTF, "B": gobold.TTF, "I": goitalic.TTF, "BI": gobolditalic.TTF},
+		"mono": {"": gomono.TTF, "B": gomonobold.TTF, "I": gomonoitalic.TTF, "BI": gomonobolditalic.TTF},
+	}
+}
+
+// fontStyles maps the style suffixes of font file names to gofpdf styles.
+var fontStyles = map[string]string{
+	"regular": "", "bold": "B", "italic": "I", "oblique": "I", "bolditalic": "BI", "boldoblique": "BI",
+}
+
+// loadFonts reads the TrueType fonts in dir. Files are named after their
+// family and style, such as Inter-Regular.ttf and Inter-BoldItalic.ttf;
+// a file without a style suffix is the regular style.
+func loadFonts(dir string) (map[string]fontFamily, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.ttf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	families := map[string]fontFamily{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		family, style := name, ""
+		if i := strings.LastIndexAny(name, "-_"); i > 0 {
+			if s, ok := fontStyles[strings.ToLower(name[i+1:])]; ok {
+				family, style = name[:i], s
+			}
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("pdf: reading font: %w", err)
+		}
+		family = strings.ToLower(family)
+		if families[family] == nil {
+			families[family] = fontFamily{}
+		}
+		families[family][style] = content
+	}
+	for family, styles := range families {
+		if styles[""] == nil {
+			return nil, fmt.Errorf("pdf: font family %q in %s has no regular style", family, dir)
+		}
+	}
+	return families, nil
+}
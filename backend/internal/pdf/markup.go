@@ -0,0 +1,429 @@
+package pdf
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The markup a template produces is a small subset of HTML:
+//
+//	<document size="A4" orientation="portrait" margin="15" font="sans" font-size="10" title="...">
+//	  <header>...</header> <footer>...</footer>
+//	  <h1>..</h1> <h2>..</h2> <h3>..</h3>
+//	  <p align="left|center|right|justify" size="9" color="#555" font="mono">text, <b>bold</b>, <i>italic</i>, <br></p>
+//	  <table widths="3,1,1" border="true" zebra="true" size="9">
+//	    <thead><tr><th>..</th></tr></thead>
+//	    <tr><td align="right" colspan="2">..</td></tr>
+//	  </table>
+//	  <img file="<file ID>" width="40" height="20" align="center"> or <img src="data:image/png;base64,...">
+//	  <hr> <spacer height="5"> <pagebreak>
+//	</document>
+//
+// Lengths are in millimetres and font sizes in points. Header and footer
+// text may contain {page} and {pages}.
+
+type blockKind int
+
+const (
+	blockHeading blockKind = iota
+	blockParagraph
+	blockTable
+	blockImage
+	blockRule
+	blockSpacer
+	blockPageBreak
+)
+
+type document struct {
+	size        string
+	orientation string
+	margin      float64
+	font        string
+	fontSize    float64
+	title       string
+	header      []block
+	footer      []block
+	body        []block
+}
+
+// run is text in one style.
+type run struct {
+	text         string
+	bold, italic bool
+}
+
+type block struct {
+	kind  blockKind
+	level int
+	runs  []run
+	align string
+	size  float64
+	color string
+	font  string
+
+	// Tables
+	widths []float64
+	head   []row
+	rows   []row
+	border bool
+	zebra  bool
+
+	// Images; height also sizes spacers
+	file, src     string
+	width, height float64
+}
+
+type row struct {
+	cells []cell
+}
+
+type cell struct {
+	text    string
+	header  bool
+	bold    bool
+	align   string
+	colspan int
+}
+
+// node is an element of the parsed markup, or a text node when name is
+// empty.
+type node struct {
+	name     string
+	attrs    map[string]string
+	children []*node
+	text     string
+}
+
+func (n *node) attr(name string) string {
+	return n.attrs[name]
+}
+
+func (n *node) number(name string, fallback float64) (float64, error) {
+	v := strings.TrimSpace(n.attrs[name])
+	if v == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("<%s %s=%q> is not a number", n.name, name, v)
+	}
+	return f, nil
+}
+
+func (n *node) flag(name string) bool {
+	v := strings.ToLower(strings.TrimSpace(n.attrs[name]))
+	return v == "true" || v == "1" || v == "yes" || v == name
+}
+
+// parseMarkup reads markup into a tree below a synthetic root, so
+// documents without a <document> element work too.
+func parseMarkup(r io.Reader) (*node, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = voidElements
+	d.Entity = xml.HTMLEntity
+
+	root := &node{name: "#root"}
+	stack := []*node{root}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pdf: reading markup: %w", err)
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: strings.ToLower(t.Name.Local), attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.attrs[strings.ToLower(a.Name.Local)] = a.Value
+			}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			// Close up to the matching element, forgiving unclosed ones
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &node{text: string(t)})
+		}
+		if len(stack) > maxMarkupDepth {
+			return nil, fmt.Errorf("pdf: markup is nested deeper than %d elements", maxMarkupDepth)
+		}
+	}
+	return root, nil
+}
+
+const maxMarkupDepth = 50
+
+// voidElements never have content, so need no closing tag.
+var voidElements = append([]string{"spacer", "pagebreak"}, xml.HTMLAutoClose...)
+
+// buildDocument turns the markup tree into page setup and blocks.
+func buildDocument(root *node) (*document, error) {
+	doc := &document{size: "A4", orientation: "portrait", margin: 15, font: "sans", fontSize: 10}
+	body := root
+	for _, child := range root.children {
+		if child.name == "document" {
+			body = child
+			break
+		}
+	}
+	if body != root {
+		doc.size = firstNonEmpty(body.attr("size"), doc.size)
+		doc.orientation = strings.ToLower(firstNonEmpty(body.attr("orientation"), doc.orientation))
+		doc.font = strings.ToLower(firstNonEmpty(body.attr("font"), doc.font))
+		doc.title = strings.TrimSpace(body.attr("title"))
+		var err error
+		if doc.margin, err = body.number("margin", doc.margin); err != nil {
+			return nil, err
+		}
+		if doc.fontSize, err = body.number("font-size", doc.fontSize); err != nil {
+			return nil, err
+		}
+	}
+	if doc.orientation != "portrait" && doc.orientation != "landscape" {
+		return nil, fmt.Errorf("pdf: orientation must be portrait or landscape, not %q", doc.orientation)
+	}
+
+	for _, child := range body.children {
+		var err error
+		switch child.name {
+		case "header":
+			doc.header, err = buildBlocks(child.children)
+		case "footer":
+			doc.footer, err = buildBlocks(child.children)
+		default:
+			var blocks []block
+			blocks, err = buildBlocks([]*node{child})
+			doc.body = append(doc.body, blocks...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func buildBlocks(nodes []*node) ([]block, error) {
+	var blocks []block
+	for _, n := range nodes {
+		b := block{align: strings.ToLower(n.attr("align")), color: n.attr("color"), font: strings.ToLower(n.attr("font"))}
+		var err error
+		if b.size, err = n.number("size", 0); err != nil {
+			return nil, err
+		}
+		switch n.name {
+		case "":
+			// Text between blocks becomes a paragraph when it is not blank
+			if text := collapseSpace(n.text); strings.TrimSpace(text) != "" {
+				blocks = append(blocks, block{kind: blockParagraph, runs: []run{{text: strings.TrimSpace(text)}}})
+			}
+			continue
+		case "h1", "h2", "h3":
+			b.kind, b.level = blockHeading, int(n.name[1]-'0')
+			b.runs = trimRuns(inlineRuns(n, false, false))
+		case "p", "div":
+			b.kind = blockParagraph
+			b.runs = trimRuns(inlineRuns(n, false, false))
+		case "table":
+			b.kind = blockTable
+			if err := buildTable(n, &b); err != nil {
+				return nil, err
+			}
+		case "img":
+			b.kind = blockImage
+			b.file, b.src = strings.TrimSpace(n.attr("file")), strings.TrimSpace(n.attr("src"))
+			if b.file == "" && b.src == "" {
+				return nil, fmt.Errorf("pdf: <img> needs a file or src attribute")
+			}
+			if b.width, err = n.number("width", 0); err != nil {
+				return nil, err
+			}
+			if b.height, err = n.number("height", 0); err != nil {
+				return nil, err
+			}
+		case "hr":
+			b.kind = blockRule
+		case "spacer":
+			b.kind = blockSpacer
+			if b.height, err = n.number("height", 5); err != nil {
+				return nil, err
+			}
+		case "pagebreak":
+			b.kind = blockPageBreak
+		case "section", "main", "article":
+			inner, err := buildBlocks(n.children)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, inner...)
+			continue
+		default:
+			return nil, fmt.Errorf("pdf: unsupported element <%s>", n.name)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+func buildTable(n *node, b *block) error {
+	b.border = n.attr("border") == "" || n.flag("border")
+	b.zebra = n.flag("zebra")
+	if spec := strings.TrimSpace(n.attr("widths")); spec != "" {
+		for _, part := range strings.Split(spec, ",") {
+			w, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(part), "%"), 64)
+			if err != nil || w <= 0 {
+				return fmt.Errorf("pdf: <table widths=%q> must list positive numbers", spec)
+			}
+			b.widths = append(b.widths, w)
+		}
+	}
+
+	var walk func(n *node, head bool) error
+	walk = func(n *node, head bool) error {
+		for _, child := range n.children {
+			switch child.name {
+			case "thead":
+				if err := walk(child, true); err != nil {
+					return err
+				}
+			case "tbody", "tfoot":
+				if err := walk(child, false); err != nil {
+					return err
+				}
+			case "tr":
+				r, err := buildRow(child)
+				if err != nil {
+					return err
+				}
+				if head {
+					b.head = append(b.head, r)
+				} else {
+					b.rows = append(b.rows, r)
+				}
+			case "":
+				if strings.TrimSpace(child.text) != "" {
+					return fmt.Errorf("pdf: text in a <table> must be inside a cell")
+				}
+			default:
+				return fmt.Errorf("pdf: unsupported element <%s> in a table", child.name)
+			}
+		}
+		return nil
+	}
+	return walk(n, false)
+}
+
+func buildRow(n *node) (row, error) {
+	var r row
+	for _, child := range n.children {
+		if child.name == "" {
+			continue
+		}
+		if child.name != "td" && child.name != "th" {
+			return row{}, fmt.Errorf("pdf: unsupported element <%s> in a table row", child.name)
+		}
+		span, err := child.number("colspan", 1)
+		if err != nil {
+			return row{}, err
+		}
+		runs := trimRuns(inlineRuns(child, false, false))
+		c := cell{header: child.name == "th", align: strings.ToLower(child.attr("align")), colspan: max(int(span), 1)}
+		allBold := len(runs) > 0
+		for _, r := range runs {
+			c.text += r.text
+			allBold = allBold && r.bold
+		}
+		c.bold = c.header || allBold
+		r.cells = append(r.cells, c)
+	}
+	return r, nil
+}
+
+// inlineRuns flattens the text of n into runs of one style each.
+func inlineRuns(n *node, bold, italic bool) []run {
+	var runs []run
+	for _, child := range n.children {
+		switch child.name {
+		case "":
+			runs = append(runs, run{text: collapseSpace(child.text), bold: bold, italic: italic})
+		case "br":
+			runs = append(runs, run{text: "\n", bold: bold, italic: italic})
+		case "b", "strong":
+			runs = append(runs, inlineRuns(child, true, italic)...)
+		case "i", "em":
+			runs = append(runs, inlineRuns(child, bold, true)...)
+		default:
+			runs = append(runs, inlineRuns(child, bold, italic)...)
+		}
+	}
+	// Merge neighbours of the same style
+	merged := runs[:0]
+	for _, r := range runs {
+		if last := len(merged) - 1; last >= 0 && merged[last].bold == r.bold && merged[last].italic == r.italic {
+			merged[last].text += r.text
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// trimRuns removes the white space around the text of the runs and
+// around line breaks.
+func trimRuns(runs []run) []run {
+	for i := range runs {
+		runs[i].text = strings.ReplaceAll(runs[i].text, " \n", "\n")
+		runs[i].text = strings.ReplaceAll(runs[i].text, "\n ", "\n")
+	}
+	if len(runs) > 0 {
+		runs[0].text = strings.TrimLeft(runs[0].text, " ")
+		runs[len(runs)-1].text = strings.TrimRight(runs[len(runs)-1].text, " ")
+	}
+	kept := runs[:0]
+	for _, r := range runs {
+		if r.text != "" {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// collapseSpace turns runs of white space into single spaces, as HTML
+// does.
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,574 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const (
+	// maxPages bounds the length of a document
+	maxPages      = 2000
+	maxImageBytes = 10 << 20
+	// ptToMM converts font sizes in points to millimetres
+	ptToMM      = 25.4 / 72
+	lineSpacing = 1.35
+	cellPadding = 1.2
+)
+
+var (
+	headFill  = [3]int{235, 235, 235}
+	zebraFill = [3]int{247, 247, 247}
+	ruleColor = [3]int{180, 180, 180}
+)
+
+// layout places the blocks of a document on pages.
+type layout struct {
+	ctx    context.Context
+	pdf    *gofpdf.Fpdf
+	doc    *document
+	fonts  map[string]fontFamily
+	added  map[string]bool
+	images domain.DocumentImageLoader
+	loaded map[string]*gofpdf.ImageInfoType
+	width  float64
+	// furniture is set while drawing headers and footers, which never
+	// break pages and may show page numbers
+	furniture bool
+	// failed is an error from outside the layout, such as loading an
+	// image, to be returned as is
+	failed error
+}
+
+func render(ctx context.Context, doc *document, fonts map[string]fontFamily, images domain.DocumentImageLoader, w io.Writer) (int, error) {
+	orientation := "P"
+	if doc.orientation == "landscape" {
+		orientation = "L"
+	}
+	switch strings.ToLower(doc.size) {
+	case "a3", "a4", "a5", "letter", "legal":
+	default:
+		return 0, domain.InvalidInput("page size must be A3, A4, A5, Letter or Legal, not %q", doc.size)
+	}
+	if fonts[doc.font] == nil {
+		return 0, domain.InvalidInput("unknown font %q", doc.font)
+	}
+
+	pdf := gofpdf.New(orientation, "mm", doc.size, "")
+	// Before any font is added, so the digits of page counts are kept in
+	// font subsets
+	pdf.AliasNbPages("{pages}")
+	pdf.SetMargins(doc.margin, doc.margin, doc.margin)
+	if doc.title != "" {
+		pdf.SetTitle(doc.title, true)
+	}
+	pageWidth, _ := pdf.GetPageSize()
+	l := &layout{
+		ctx: ctx, pdf: pdf, doc: doc, fonts: fonts, added: map[string]bool{}, images: images,
+		loaded: map[string]*gofpdf.ImageInfoType{}, width: pageWidth - 2*doc.margin,
+	}
+
+	footerHeight, err := l.measure(doc.footer)
+	if err != nil {
+		return 0, err
+	}
+	pdf.SetAutoPageBreak(true, doc.margin+footerHeight)
+	pdf.SetHeaderFunc(func() {
+		if pdf.PageNo() > maxPages {
+			pdf.SetError(domain.InvalidInput("documents can have at most %d pages", maxPages))
+			return
+		}
+		if len(doc.header) > 0 {
+			l.furniture = true
+			l.blocks(doc.header)
+			l.furniture = false
+			pdf.Ln(l.lineHeight(doc.fontSize) * 0.5)
+		}
+	})
+	pdf.SetFooterFunc(func() {
+		if len(doc.footer) == 0 {
+			return
+		}
+		pdf.SetY(-(doc.margin + footerHeight))
+		l.furniture = true
+		l.blocks(doc.footer)
+		l.furniture = false
+	})
+
+	pdf.AddPage()
+	l.blocks(doc.body)
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		if l.failed != nil {
+			return 0, l.failed
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		var appErr domain.AppError
+		if errors.As(err, &appErr) {
+			return 0, err
+		}
+		return 0, domain.InvalidInput("the document cannot be laid out: %v", err)
+	}
+	pages := pdf.PageNo()
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return pages, nil
+}
+
+func (l *layout) blocks(blocks []block) {
+	for _, b := range blocks {
+		if err := l.ctx.Err(); err != nil {
+			l.pdf.SetError(err)
+		}
+		if l.pdf.Err() {
+			return
+		}
+		switch b.kind {
+		case blockHeading:
+			l.heading(b)
+		case blockParagraph:
+			l.paragraph(b)
+		case blockTable:
+			l.table(b)
+		case blockImage:
+			l.image(b)
+		case blockRule:
+			l.rule()
+		case blockSpacer:
+			l.pdf.Ln(b.height)
+		case blockPageBreak:
+			if !l.furniture {
+				l.pdf.AddPage()
+			}
+		}
+	}
+}
+
+// measure returns the height of footer blocks, which are drawn at a fixed
+// distance from the bottom of the page.
+func (l *layout) measure(blocks []block) (float64, error) {
+	height := 0.0
+	for _, b := range blocks {
+		switch b.kind {
+		case blockHeading, blockParagraph:
+			size := l.fontSize(b)
+			style := ""
+			for _, r := range b.runs {
+				if r.bold || b.kind == blockHeading {
+					style = "B"
+				}
+			}
+			l.setFont(b.font, style, size)
+			text := ""
+			for _, r := range b.runs {
+				text += r.text
+			}
+			lines := max(len(l.pdf.SplitText(l.text(text), l.width)), 1)
+			height += float64(lines)*l.lineHeight(size) + l.gap(b)
+		case blockRule:
+			height += 3
+		case blockSpacer:
+			height += b.height
+		default:
+			return 0, domain.InvalidInput("footers can only hold text, rules and spacers")
+		}
+	}
+	return height, l.pdf.Error()
+}
+
+func (l *layout) fontSize(b block) float64 {
+	size := l.doc.fontSize
+	if b.size > 0 {
+		size = b.size
+	}
+	if b.kind == blockHeading {
+		size *= map[int]float64{1: 1.8, 2: 1.4, 3: 1.15}[b.level]
+	}
+	return size
+}
+
+func (l *layout) lineHeight(size float64) float64 {
+	return size * ptToMM * lineSpacing
+}
+
+// gap is the space below a block.
+func (l *layout) gap(b block) float64 {
+	return l.lineHeight(l.fontSize(b)) * 0.4
+}
+
+// setFont selects a font, adding it to the document on first use.
+func (l *layout) setFont(family, style string, size float64) {
+	if family == "" {
+		family = l.doc.font
+	}
+	styles := l.fonts[family]
+	if styles == nil {
+		l.pdf.SetError(domain.InvalidInput("unknown font %q", family))
+		return
+	}
+	if styles[style] == nil {
+		style = ""
+	}
+	if key := family + "/" + style; !l.added[key] {
+		l.pdf.AddUTF8FontFromBytes(family, style, styles[style])
+		l.added[key] = true
+	}
+	l.pdf.SetFont(family, style, size)
+}
+
+func (l *layout) setColor(color string) {
+	rgb, ok := parseColor(color)
+	if !ok {
+		rgb = [3]int{0, 0, 0}
+	}
+	l.pdf.SetTextColor(rgb[0], rgb[1], rgb[2])
+}
+
+// text prepares text for drawing. The fonts only cover the basic
+// multilingual plane, and furniture shows the page number.
+func (l *layout) text(s string) string {
+	if l.furniture {
+		s = strings.ReplaceAll(s, "{page}", strconv.Itoa(l.pdf.PageNo()))
+	}
+	return strings.Map(func(r rune) rune {
+		if r > 0xFFFF {
+			return '�'
+		}
+		return r
+	}, s)
+}
+
+func fontStyle(bold, italic bool) string {
+	style := ""
+	if bold {
+		style += "B"
+	}
+	if italic {
+		style += "I"
+	}
+	return style
+}
+
+func alignment(align, fallback string) string {
+	switch align {
+	case "center":
+		return "C"
+	case "right":
+		return "R"
+	case "justify":
+		return "J"
+	case "left":
+		return "L"
+	}
+	return fallback
+}
+
+func (l *layout) heading(b block) {
+	size := l.fontSize(b)
+	lh := l.lineHeight(size)
+	_, top, _, _ := l.pdf.GetMargins()
+	if !l.furniture && l.pdf.GetY() > top+1 {
+		l.pdf.Ln(lh * 0.5)
+	}
+	// Keep a heading with at least a line of what follows it
+	if !l.furniture && l.pdf.GetY()+3*lh > l.limit() {
+		l.pdf.AddPage()
+	}
+	text := ""
+	for _, r := range b.runs {
+		text += r.text
+	}
+	l.setFont(b.font, "B", size)
+	l.setColor(b.color)
+	l.pdf.MultiCell(0, lh, l.text(text), "", alignment(b.align, "L"), false)
+	l.pdf.Ln(l.gap(b))
+}
+
+func (l *layout) paragraph(b block) {
+	if len(b.runs) == 0 {
+		return
+	}
+	size := l.fontSize(b)
+	lh := l.lineHeight(size)
+	l.setColor(b.color)
+	if len(b.runs) == 1 {
+		r := b.runs[0]
+		l.setFont(b.font, fontStyle(r.bold, r.italic), size)
+		l.pdf.MultiCell(0, lh, l.text(r.text), "", alignment(b.align, "L"), false)
+	} else {
+		// Mixed styles flow from left to right
+		for _, r := range b.runs {
+			l.setFont(b.font, fontStyle(r.bold, r.italic), size)
+			l.pdf.Write(lh, l.text(r.text))
+		}
+		l.pdf.Ln(lh)
+	}
+	l.pdf.Ln(l.gap(b))
+}
+
+func (l *layout) rule() {
+	y := l.pdf.GetY() + 1
+	left, _, _, _ := l.pdf.GetMargins()
+	l.pdf.SetDrawColor(ruleColor[0], ruleColor[1], ruleColor[2])
+	l.pdf.SetLineWidth(0.2)
+	l.pdf.Line(left, y, left+l.width, y)
+	l.pdf.SetDrawColor(0, 0, 0)
+	l.pdf.SetY(y + 2)
+}
+
+// limit is where the page breaks.
+func (l *layout) limit() float64 {
+	_, height := l.pdf.GetPageSize()
+	_, margin := l.pdf.GetAutoPageBreak()
+	return height - margin
+}
+
+func (l *layout) table(b block) {
+	columns := 0
+	for _, r := range append(append([]row{}, b.head...), b.rows...) {
+		n := 0
+		for _, c := range r.cells {
+			n += c.colspan
+		}
+		columns = max(columns, n)
+	}
+	if columns == 0 {
+		return
+	}
+	weights := make([]float64, columns)
+	total := 0.0
+	for i := range weights {
+		weights[i] = 1
+		if i < len(b.widths) {
+			weights[i] = b.widths[i]
+		}
+		total += weights[i]
+	}
+	widths := make([]float64, columns)
+	for i, w := range weights {
+		widths[i] = l.width * w / total
+	}
+
+	size := l.fontSize(b)
+	for _, r := range b.head {
+		l.row(b, r, widths, size, headFill, true)
+	}
+	for i, r := range b.rows {
+		if err := l.ctx.Err(); err != nil {
+			l.pdf.SetError(err)
+		}
+		if l.pdf.Err() {
+			return
+		}
+		fill := [3]int{-1}
+		if b.zebra && i%2 == 1 {
+			fill = zebraFill
+		}
+		if height := l.rowHeight(b, r, widths, size); !l.furniture && l.pdf.GetY()+height > l.limit() {
+			// The header rows are repeated on every page of the table
+			l.pdf.AddPage()
+			for _, h := range b.head {
+				l.row(b, h, widths, size, headFill, true)
+			}
+		}
+		l.row(b, r, widths, size, fill, false)
+	}
+	l.pdf.Ln(l.gap(b))
+}
+
+// spans returns the width of each cell of a row.
+func spans(r row, widths []float64) []float64 {
+	out := make([]float64, len(r.cells))
+	col := 0
+	for i, c := range r.cells {
+		for j := 0; j < c.colspan && col < len(widths); j++ {
+			out[i] += widths[col]
+			col++
+		}
+	}
+	return out
+}
+
+func (l *layout) rowHeight(b block, r row, widths []float64, size float64) float64 {
+	height := 0.0
+	for i, w := range spans(r, widths) {
+		c := r.cells[i]
+		l.setFont(b.font, fontStyle(c.bold, false), size)
+		lines := max(len(l.pdf.SplitText(l.text(c.text), w)), 1)
+		height = max(height, float64(lines)*l.lineHeight(size)+2*cellPadding)
+	}
+	return height
+}
+
+func (l *layout) row(b block, r row, widths []float64, size float64, fill [3]int, head bool) {
+	left, _, _, _ := l.pdf.GetMargins()
+	y := l.pdf.GetY()
+	height := l.rowHeight(b, r, widths, size)
+	x := left
+	l.setColor(b.color)
+	for i, w := range spans(r, widths) {
+		c := r.cells[i]
+		if fill[0] >= 0 {
+			l.pdf.SetFillColor(fill[0], fill[1], fill[2])
+			l.pdf.Rect(x, y, w, height, "F")
+		}
+		if b.border {
+			l.pdf.SetDrawColor(ruleColor[0], ruleColor[1], ruleColor[2])
+			l.pdf.SetLineWidth(0.2)
+			l.pdf.Rect(x, y, w, height, "D")
+		}
+		l.setFont(b.font, fontStyle(c.bold, false), size)
+		l.pdf.SetXY(x, y+cellPadding)
+		l.pdf.MultiCell(w, l.lineHeight(size), l.text(c.text), "", alignment(c.align, "L"), false)
+		x += w
+	}
+	l.pdf.SetDrawColor(0, 0, 0)
+	l.pdf.SetXY(left, y+height)
+}
+
+func (l *layout) image(b block) {
+	info, name := l.load(b)
+	if info == nil {
+		return
+	}
+	naturalWidth, naturalHeight := info.Extent()
+	if naturalWidth <= 0 || naturalHeight <= 0 {
+		return
+	}
+	w, h := b.width, b.height
+	switch {
+	case w == 0 && h == 0:
+		w = min(naturalWidth, l.width)
+		h = w * naturalHeight / naturalWidth
+	case w == 0:
+		w = h * naturalWidth / naturalHeight
+	case h == 0:
+		h = w * naturalHeight / naturalWidth
+	}
+	if w > l.width {
+		w, h = l.width, h*l.width/w
+	}
+
+	left, _, _, _ := l.pdf.GetMargins()
+	x := left
+	switch b.align {
+	case "center":
+		x += (l.width - w) / 2
+	case "right":
+		x += l.width - w
+	}
+	if !l.furniture && l.pdf.GetY()+h > l.limit() {
+		l.pdf.AddPage()
+	}
+	y := l.pdf.GetY()
+	l.pdf.ImageOptions(name, x, y, w, h, false, gofpdf.ImageOptions{}, 0, "")
+	l.pdf.SetY(y + h + l.gap(b))
+}
+
+// load registers the image of a block once per document.
+func (l *layout) load(b block) (*gofpdf.ImageInfoType, string) {
+	name := "file:" + b.file
+	if b.file == "" {
+		name = b.src
+	}
+	if info, ok := l.loaded[name]; ok {
+		return info, name
+	}
+
+	var content []byte
+	var err error
+	if b.file != "" {
+		content, err = l.readFile(b.file)
+	} else {
+		content, err = decodeDataURI(b.src)
+	}
+	if err != nil {
+		l.pdf.SetError(err)
+		return nil, ""
+	}
+	imageType := ""
+	switch http.DetectContentType(content) {
+	case "image/png":
+		imageType = "PNG"
+	case "image/jpeg":
+		imageType = "JPG"
+	case "image/gif":
+		imageType = "GIF"
+	default:
+		l.pdf.SetError(domain.InvalidInput("images must be PNG, JPEG or GIF"))
+		return nil, ""
+	}
+	info := l.pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: imageType, ReadDpi: true}, bytes.NewReader(content))
+	if info == nil {
+		return nil, ""
+	}
+	l.loaded[name] = info
+	return info, name
+}
+
+func (l *layout) readFile(id string) ([]byte, error) {
+	if l.images == nil {
+		return nil, domain.InvalidInput("images from files are not available here")
+	}
+	rc, err := l.images(l.ctx, id)
+	if err != nil {
+		l.failed = err
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(io.LimitReader(rc, maxImageBytes+1))
+	if err != nil {
+		l.failed = err
+		return nil, err
+	}
+	if len(content) > maxImageBytes {
+		return nil, domain.InvalidInput("images can be at most %d bytes", maxImageBytes)
+	}
+	return content, nil
+}
+
+// decodeDataURI returns the content of a base64 data URI.
+func decodeDataURI(uri string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return nil, domain.InvalidInput("image sources must be data URIs; use the file attribute for stored images")
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, domain.InvalidInput("image data URIs must be base64 encoded")
+	}
+	content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, domain.InvalidInput("malformed image data URI")
+	}
+	return content, nil
+}
+
+// parseColor reads #rgb and #rrggbb colors.
+func parseColor(s string) ([3]int, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return [3]int{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return [3]int{}, false
+	}
+	return [3]int{int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)}, true
+}
@@ -0,0 +1,327 @@
+// Package pdf generates PDF documents from templates. A template is Go
+// html/template source producing a small HTML-like markup, which is laid
+// out on pages with tables, images, repeated headers and footers and
+// embedded TrueType fonts.
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// maxMarkupBytes bounds the markup a template may produce.
+const maxMarkupBytes = 32 << 20
+
+//go:embed templates/*.html
+var builtinTemplates embed.FS
+
+var builtinDescriptions = map[string]string{
+	"invoice": "An invoice with seller, customer, line items, tax and totals",
+	"report":  "A table of the rows of a list source, with a title and summary",
+}
+
+// Renderer renders the built-in templates and those loaded from a
+// directory.
+type Renderer struct {
+	templates map[string]*template.Template
+	infos     map[string]domain.DocumentTemplate
+	fonts     map[string]fontFamily
+}
+
+// NewRenderer creates a renderer with the built-in templates and the Go
+// fonts as the "sans" and "mono" families.
+func NewRenderer() (*Renderer, error) {
+	r := &Renderer{
+		templates: map[string]*template.Template{},
+		infos:     map[string]domain.DocumentTemplate{},
+		fonts:     builtinFonts(),
+	}
+	entries, err := builtinTemplates.ReadDir("templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		src, err := builtinTemplates.ReadFile("templates/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(e.Name(), ".html")
+		if err := r.add(name, builtinDescriptions[name], true, string(src)); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// LoadTemplates adds the *.html templates in dir, named after their
+// files. A template named like a built-in one replaces it.
+func (r *Renderer) LoadTemplates(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("pdf: reading template: %w", err)
+		}
+		if err := r.add(strings.TrimSuffix(filepath.Base(path), ".html"), "", false, string(src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFonts adds the TrueType fonts in dir; see loadFonts for how files
+// are named. Families may replace "sans" and "mono".
+func (r *Renderer) LoadFonts(dir string) error {
+	families, err := loadFonts(dir)
+	if err != nil {
+		return err
+	}
+	for name, family := range families {
+		r.fonts[name] = family
+	}
+	return nil
+}
+
+func (r *Renderer) add(name, description string, builtin bool, src string) error {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(src)
+	if err != nil {
+		return fmt.Errorf("pdf: parsing template %s: %w", name, err)
+	}
+	r.templates[name] = t
+	r.infos[name] = domain.DocumentTemplate{Name: name, Description: description, Builtin: builtin}
+	return nil
+}
+
+// Templates lists the templates by name.
+func (r *Renderer) Templates() []domain.DocumentTemplate {
+	out := make([]domain.DocumentTemplate, 0, len(r.infos))
+	for _, info := range r.infos {
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Fonts lists the font families templates may use.
+func (r *Renderer) Fonts() []string {
+	out := make([]string, 0, len(r.fonts))
+	for name := range r.fonts {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Render executes a template with data and writes the resulting PDF to w.
+// It returns the number of pages. Mistakes in the template or data are
+// reported as invalid input.
+func (r *Renderer) Render(ctx context.Context, name string, data interface{}, images domain.DocumentImageLoader, w io.Writer) (int, error) {
+	t, ok := r.templates[name]
+	if !ok {
+		return 0, domain.NotFound("Document template")
+	}
+	var markup bytes.Buffer
+	if err := t.Execute(&limitedWriter{w: &markup, left: maxMarkupBytes}, data); err != nil {
+		return 0, domain.InvalidInput("%v", err)
+	}
+	root, err := parseMarkup(&markup)
+	if err != nil {
+		return 0, domain.InvalidInput("%v", err)
+	}
+	doc, err := buildDocument(root)
+	if err != nil {
+		return 0, domain.InvalidInput("%v", err)
+	}
+	return render(ctx, doc, r.fonts, images, w)
+}
+
+type limitedWriter struct {
+	w    io.Writer
+	left int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.left {
+		return 0, fmt.Errorf("the template produced more than %d bytes of markup", maxMarkupBytes)
+	}
+	l.left -= len(p)
+	return l.w.Write(p)
+}
+
+// templateFuncs are available to templates in addition to the standard
+// ones. Values taking a pipeline argument take it last:
+//
+//	{{.issued_at | date "2 Jan 2006"}}  {{.amount | number 2}}
+//	{{.total | money "EUR"}}  {{mul .quantity .unit_price}}
+var templateFuncs = template.FuncMap{
+	"date":    formatDate,
+	"number":  formatNumber,
+	"money":   formatMoney,
+	"add":     add,
+	"sub":     sub,
+	"mul":     mul,
+	"div":     divide,
+	"default": defaultValue,
+}
+
+// toFloat reads numbers as decoded from JSON, Go numbers and numeric
+// strings. A missing value is zero.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		if strings.TrimSpace(n) == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func arith(a, b interface{}, op func(x, y float64) float64) (float64, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+func add(a, b interface{}) (float64, error) {
+	return arith(a, b, func(x, y float64) float64 { return x + y })
+}
+
+func sub(a, b interface{}) (float64, error) {
+	return arith(a, b, func(x, y float64) float64 { return x - y })
+}
+
+func mul(a, b interface{}) (float64, error) {
+	return arith(a, b, func(x, y float64) float64 { return x * y })
+}
+
+func divide(a, b interface{}) (float64, error) {
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return arith(a, y, func(x, y float64) float64 { return x / y })
+}
+
+// formatNumber rounds to decimals and groups thousands with commas.
+func formatNumber(decimals int, v interface{}) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	s := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	if f < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if fraction != "" {
+		b.WriteString("." + fraction)
+	}
+	return b.String(), nil
+}
+
+var currencySymbols = map[string]string{"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥"}
+
+// formatMoney writes an amount with its currency's symbol, or its code
+// when it has no well-known symbol.
+func formatMoney(currency string, v interface{}) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	decimals := 2
+	if currency == "JPY" {
+		decimals = 0
+	}
+	s, err := formatNumber(decimals, v)
+	if err != nil {
+		return "", err
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + s, nil
+	}
+	return strings.TrimSpace(sign + s + " " + currency), nil
+}
+
+// formatDate formats times and RFC 3339 or YYYY-MM-DD strings. Other
+// strings are returned as they are.
+func formatDate(layout string, v interface{}) string {
+	var t time.Time
+	switch d := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		t = d
+	case *time.Time:
+		if d == nil {
+			return ""
+		}
+		t = *d
+	case string:
+		var err error
+		if t, err = time.Parse(time.RFC3339, d); err != nil {
+			if t, err = time.Parse("2006-01-02", d); err != nil {
+				return d
+			}
+		}
+	default:
+		return fmt.Sprint(v)
+	}
+	return t.Format(layout)
+}
+
+func defaultValue(fallback, v interface{}) interface{} {
+	if v == nil {
+		return fallback
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return fallback
+	}
+	return v
+}
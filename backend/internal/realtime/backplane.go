@@ -0,0 +1,171 @@
+package realtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// replayBuffer is how many recent messages a hub keeps so clients
+	// that reconnect can resume where they left off.
+	replayBuffer = 1024
+	// outboxSize is how many messages may wait for the backplane before
+	// further ones are dropped.
+	outboxSize = 4096
+
+	backplaneRetryMin = 100 * time.Millisecond
+	backplaneRetryMax = 5 * time.Second
+	// backplanePublishTimeout bounds a publish, which is then retried
+	backplanePublishTimeout = 5 * time.Second
+)
+
+// Backplane carries hub messages between the replicas of the API. It
+// numbers the messages of all replicas in one sequence, so every replica
+// delivers them in the same order, and retains recent messages so a
+// replica that reconnects or restarts resumes without gaps.
+type Backplane interface {
+	Name() string
+	// Publish appends a message to the sequence. Bodies are unique, so a
+	// backplane may drop one it already appended when a publish is retried.
+	Publish(ctx context.Context, body []byte) error
+	// Receive calls deliver with every message after sequence number
+	// after, published by any replica, in order, until ctx is done or the
+	// backplane fails. With after 0 it starts at the oldest retained
+	// message.
+	Receive(ctx context.Context, after uint64, deliver func(seq uint64, body []byte)) error
+	Close() error
+}
+
+// envelope is a message as it travels over the backplane. Serial numbers
+// the messages of the origin, which makes every body unique.
+type envelope struct {
+	Origin string          `json:"origin"`
+	Serial uint64          `json:"serial"`
+	Topic  string          `json:"topic"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// relay connects a hub to its backplane.
+type relay struct {
+	backplane Backplane
+	origin    string
+	outbox    chan []byte
+	serial    atomic.Uint64
+
+	// Guarded by Hub.mu
+	last   uint64
+	recent []Message
+}
+
+// UseBackplane relays the hub's messages through b. Call it before the
+// hub is used, and Run to start relaying.
+func (h *Hub) UseBackplane(b Backplane) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	h.relay = &relay{backplane: b, origin: hex.EncodeToString(id), outbox: make(chan []byte, outboxSize)}
+}
+
+// Origin identifies this replica in Message.Origin.
+func (h *Hub) Origin() string {
+	if h.relay == nil {
+		return ""
+	}
+	return h.relay.origin
+}
+
+// Run publishes the hub's messages to the backplane and delivers those
+// of every replica until ctx is done. Publishing and receiving retry
+// after failures; the sequence numbers keep delivery free of gaps and
+// duplicates.
+func (h *Hub) Run(ctx context.Context) {
+	if h.relay == nil {
+		return
+	}
+	r := h.relay
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case body := <-r.outbox:
+				retry(ctx, "publishing to the "+r.backplane.Name()+" backplane", func() error {
+					return r.backplane.Publish(ctx, body)
+				})
+			}
+		}
+	}()
+	retry(ctx, "receiving from the "+r.backplane.Name()+" backplane", func() error {
+		h.mu.RLock()
+		after := r.last
+		h.mu.RUnlock()
+		err := r.backplane.Receive(ctx, after, h.receive)
+		if err == nil && ctx.Err() == nil {
+			err = errors.New("receiving stopped")
+		}
+		return err
+	})
+}
+
+func (r *relay) publish(msg Message) {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		log.Printf("realtime: encoding %s message: %v", msg.Type, err)
+		return
+	}
+	body, _ := json.Marshal(envelope{Origin: r.origin, Serial: r.serial.Add(1), Topic: msg.Topic, Type: msg.Type, Data: data})
+	select {
+	case r.outbox <- body:
+	default:
+		log.Printf("realtime: backplane outbox full, dropping %s message to %s", msg.Type, msg.Topic)
+	}
+}
+
+// receive delivers a message from the backplane to local subscribers
+// and keeps it for clients that resume later.
+func (h *Hub) receive(seq uint64, body []byte) {
+	var e envelope
+	if err := json.Unmarshal(body, &e); err != nil {
+		log.Printf("realtime: malformed backplane message %d: %v", seq, err)
+		return
+	}
+	msg := Message{ID: seq, Topic: e.Topic, Type: e.Type, Data: e.Data, Origin: e.Origin}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.relay
+	if seq <= r.last {
+		return
+	}
+	r.last = seq
+	if len(r.recent) == replayBuffer {
+		r.recent = append(r.recent[:0], r.recent[1:]...)
+	}
+	r.recent = append(r.recent, msg)
+	h.deliver(msg)
+}
+
+// retry calls fn until it succeeds or ctx is done, backing off between
+// failures.
+func retry(ctx context.Context, what string, fn func() error) {
+	delay := backplaneRetryMin
+	for {
+		err := fn()
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Printf("realtime: %s failed, retrying in %s: %v", what, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, backplaneRetryMax)
+	}
+}
@@ -0,0 +1,539 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"greact-bones/backend/internal/domain"
+	"greact-bones/backend/internal/yjs"
+)
+
+const (
+	collabWriteWait    = 10 * time.Second
+	collabPongWait     = 60 * time.Second
+	collabPingPeriod   = 50 * time.Second
+	collabMaxMessage   = 16 << 20
+	collabSendBuffer   = 256
+	collabCompactEvery = 200
+)
+
+// CollabServer runs live editing sessions of collaborative documents over
+// the y-websocket protocol. Clients of a document share a room: updates a
+// client sends are stored and relayed to the others, and awareness states
+// (cursors, presence) are relayed as they are. Rooms exist while at least
+// one client is connected. With a hub, rooms of the same document on
+// different replicas relay updates and awareness to each other.
+type CollabServer struct {
+	docs *domain.CollabService
+	hub  *Hub
+
+	mu    sync.Mutex
+	rooms map[string]*collabRoom
+}
+
+// NewCollabServer creates a server storing documents through docs.
+func NewCollabServer(docs *domain.CollabService) *CollabServer {
+	return &CollabServer{docs: docs, rooms: make(map[string]*collabRoom)}
+}
+
+// collabRelay is the data of a collab.relay message: an update or
+// awareness states a client sent to another replica's room.
+type collabRelay struct {
+	Update    []byte               `json:"update,omitempty"`
+	Awareness []yjs.AwarenessState `json:"awareness,omitempty"`
+}
+
+// CollabMessageSchemas describes the frames of a document's WebSocket,
+// which are binary and lib0-encoded (unsigned varints and byte arrays
+// prefixed with their length), and the messages rooms relay to each
+// other across replicas.
+var CollabMessageSchemas = []domain.MessageSchema{
+	{
+		Name:        "collab.sync_step1",
+		Version:     1,
+		Summary:     "A state vector, asking for the updates the sender is missing",
+		Description: "Message type 0 and sync step 0, then the encoded state vector. The server sends one when a client connects.",
+		Channel:     domain.ChannelCollab,
+		Payload:     yjsFrame("varuint 0, varuint 0, state vector as a byte array"),
+	},
+	{
+		Name:        "collab.sync_step2",
+		Version:     1,
+		Summary:     "The updates the sender of a sync step 1 is missing",
+		Description: "Message type 0 and sync step 1, then one Yjs update (format v1).",
+		Channel:     domain.ChannelCollab,
+		Payload:     yjsFrame("varuint 0, varuint 1, update as a byte array"),
+	},
+	{
+		Name:        "collab.update",
+		Version:     1,
+		Summary:     "A change to the document",
+		Description: "Message type 0 and sync step 2, then a Yjs update (format v1). Updates from clients with view access are dropped.",
+		Channel:     domain.ChannelCollab,
+		Payload:     yjsFrame("varuint 0, varuint 2, update as a byte array"),
+	},
+	{
+		Name:    "collab.awareness",
+		Version: 1,
+		Summary: "Presence of clients, such as cursors and user names",
+		Description: "Message type 1, then the awareness update as a byte array: the number of clients, and the ID, clock " +
+			"and JSON state of each. A null state means the client left.",
+		Channel: domain.ChannelCollab,
+		Payload: yjsFrame("varuint 1, awareness update as a byte array"),
+	},
+	{
+		Name:        "collab.query_awareness",
+		Version:     1,
+		Summary:     "Asks for the awareness states of every client",
+		Description: "Message type 3 alone. The server answers with collab.awareness.",
+		Channel:     domain.ChannelCollab,
+		Payload:     yjsFrame("varuint 3"),
+	},
+	{
+		Name:    "collab.relay",
+		Version: 1,
+		Summary: "An update or awareness states a client sent, for the document's rooms on other replicas",
+		Channel: domain.ChannelBackplane,
+		Payload: domain.SchemaOf(collabRelay{}),
+	},
+}
+
+func yjsFrame(layout string) *domain.JSONSchema {
+	return &domain.JSONSchema{Type: "string", Format: "binary", Description: "Binary WebSocket frame: " + layout}
+}
+
+// CollabTopic is the hub topic rooms of a document relay changes on.
+func CollabTopic(tenantID, docID string) string {
+	return "tenant:" + tenantID + ":collab:" + docID
+}
+
+type collabRoom struct {
+	tenantID, docID string
+	refs            int           // guarded by CollabServer.mu
+	hub             *Hub          // nil without relaying
+	sub             *Subscription // to CollabTopic
+
+	mu        sync.Mutex
+	loaded    bool
+	state     []byte   // merged document
+	pending   [][]byte // updates received since state was merged
+	stored    int      // updates stored since the room last compacted
+	conns     map[*collabConn]bool
+	awareness map[uint64]yjs.AwarenessState
+	// owners maps awareness IDs to the local client that introduced them;
+	// IDs of clients on other replicas map to nil
+	owners map[uint64]*collabConn
+}
+
+type collabConn struct {
+	ws       *websocket.Conn
+	canEdit  bool
+	send     chan []byte
+	closing  sync.Once
+	shutdown chan struct{}
+}
+
+// UseHub relays rooms through the hub, so clients connected to
+// different replicas edit together.
+func (s *CollabServer) UseHub(hub *Hub) {
+	s.hub = hub
+}
+
+// Serve runs the session of a client that was allowed to join the
+// document and returns when it disconnects. Clients without edit access
+// receive changes but their own updates are dropped.
+func (s *CollabServer) Serve(ws *websocket.Conn, doc *domain.CollabDocument, canEdit bool) {
+	c := &collabConn{ws: ws, canEdit: canEdit, send: make(chan []byte, collabSendBuffer), shutdown: make(chan struct{})}
+	room := s.acquire(doc.TenantID, doc.ID)
+	defer s.release(room)
+
+	if err := room.join(s.docs, c); err != nil {
+		log.Printf("collab: loading document %s failed: %v", doc.ID, err)
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "document unavailable"),
+			time.Now().Add(collabWriteWait))
+		ws.Close()
+		return
+	}
+	defer room.leave(c)
+
+	go c.writeLoop()
+	c.readLoop(s.docs, room)
+}
+
+// Shutdown disconnects every client, telling them the server is going
+// away so they reconnect to another replica.
+func (s *CollabServer) Shutdown() {
+	s.mu.Lock()
+	rooms := make([]*collabRoom, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.mu.Unlock()
+	for _, room := range rooms {
+		room.mu.Lock()
+		for c := range room.conns {
+			c.close()
+		}
+		room.mu.Unlock()
+	}
+}
+
+func (s *CollabServer) acquire(tenantID, docID string) *collabRoom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := tenantID + "/" + docID
+	room := s.rooms[key]
+	if room == nil {
+		room = &collabRoom{
+			tenantID:  tenantID,
+			docID:     docID,
+			conns:     make(map[*collabConn]bool),
+			awareness: make(map[uint64]yjs.AwarenessState),
+			owners:    make(map[uint64]*collabConn),
+		}
+		if s.hub != nil {
+			room.hub = s.hub
+			room.sub = s.hub.Subscribe(CollabTopic(tenantID, docID))
+			go room.receiveRelayed(s.hub.Origin())
+		}
+		s.rooms[key] = room
+	}
+	room.refs++
+	return room
+}
+
+// release drops the room when its last client left, compacting the
+// updates it stored.
+func (s *CollabServer) release(room *collabRoom) {
+	s.mu.Lock()
+	room.refs--
+	last := room.refs == 0
+	if last {
+		delete(s.rooms, room.tenantID+"/"+room.docID)
+		if room.sub != nil {
+			room.hub.Unsubscribe(room.sub)
+		}
+	}
+	s.mu.Unlock()
+
+	room.mu.Lock()
+	stored := room.stored
+	room.mu.Unlock()
+	if last && stored > 0 {
+		go s.compact(room)
+	}
+}
+
+func (s *CollabServer) compact(room *collabRoom) {
+	if err := s.docs.Compact(room.context(), room.tenantID, room.docID); err != nil {
+		log.Printf("collab: compacting document %s failed: %v", room.docID, err)
+	}
+}
+
+// context is for the room's database work, which outlives the requests
+// of its clients.
+func (r *collabRoom) context() context.Context {
+	return domain.WithTenant(context.Background(), r.tenantID)
+}
+
+// join loads the document on first use, adds the client and greets it
+// with the server's state vector and the current awareness states.
+func (r *collabRoom) join(docs *domain.CollabService, c *collabConn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		state, err := docs.State(r.context(), r.tenantID, r.docID)
+		if err != nil {
+			return err
+		}
+		r.state, r.loaded = state, true
+	}
+	state, err := r.snapshot()
+	if err != nil {
+		return err
+	}
+	sv, err := yjs.StateVectorFromUpdate(state)
+	if err != nil {
+		return err
+	}
+	r.conns[c] = true
+	c.enqueue(yjs.SyncMessage(yjs.SyncStep1, sv.Encode()))
+	if len(r.awareness) > 0 {
+		c.enqueue(yjs.AwarenessMessage(r.awarenessStates()))
+	}
+	return nil
+}
+
+// leave removes the client and announces that its awareness states are
+// gone.
+func (r *collabRoom) leave(c *collabConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, c)
+	var removed []yjs.AwarenessState
+	for client, owner := range r.owners {
+		if owner == c {
+			st := r.awareness[client]
+			removed = append(removed, yjs.AwarenessState{Client: client, Clock: st.Clock + 1, State: "null"})
+			delete(r.awareness, client)
+			delete(r.owners, client)
+		}
+	}
+	if len(removed) > 0 {
+		r.broadcast(yjs.AwarenessMessage(removed), nil)
+		r.relay(collabRelay{Awareness: removed})
+	}
+	c.close()
+}
+
+// snapshot merges the pending updates into the room state. Callers hold
+// r.mu.
+func (r *collabRoom) snapshot() ([]byte, error) {
+	if len(r.pending) > 0 {
+		merged, err := yjs.MergeUpdates(append([][]byte{r.state}, r.pending...)...)
+		if err != nil {
+			return nil, err
+		}
+		r.state, r.pending = merged, nil
+	}
+	return r.state, nil
+}
+
+// broadcast sends a message to every client except skip. Callers hold
+// r.mu.
+func (r *collabRoom) broadcast(msg []byte, skip *collabConn) {
+	for c := range r.conns {
+		if c != skip {
+			c.enqueue(msg)
+		}
+	}
+}
+
+func (r *collabRoom) awarenessStates() []yjs.AwarenessState {
+	states := make([]yjs.AwarenessState, 0, len(r.awareness))
+	for _, st := range r.awareness {
+		states = append(states, st)
+	}
+	return states
+}
+
+func (c *collabConn) readLoop(docs *domain.CollabService, room *collabRoom) {
+	c.ws.SetReadLimit(collabMaxMessage)
+	c.ws.SetReadDeadline(time.Now().Add(collabPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(collabPongWait))
+	})
+	for {
+		kind, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if kind != websocket.BinaryMessage {
+			continue
+		}
+		if err := c.handle(docs, room, msg); err != nil {
+			var appErr domain.AppError
+			if !errors.As(err, &appErr) {
+				log.Printf("collab: document %s: %v", room.docID, err)
+			}
+			c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+				time.Now().Add(collabWriteWait))
+			return
+		}
+	}
+}
+
+func (c *collabConn) handle(docs *domain.CollabService, room *collabRoom, msg []byte) error {
+	d := yjs.NewDecoder(msg)
+	switch d.Uvarint() {
+	case yjs.MessageSync:
+		step := d.Uvarint()
+		payload := d.Bytes()
+		if err := d.Err(); err != nil {
+			return domain.InvalidInput("malformed sync message")
+		}
+		switch step {
+		case yjs.SyncStep1:
+			return c.sendMissing(room, payload)
+		case yjs.SyncStep2, yjs.SyncUpdate:
+			return c.applyUpdate(docs, room, payload)
+		}
+	case yjs.MessageAwareness:
+		payload := d.Bytes()
+		states, err := yjs.DecodeAwareness(payload)
+		if d.Err() != nil || err != nil {
+			return domain.InvalidInput("malformed awareness message")
+		}
+		room.updateAwareness(c, states)
+	case yjs.MessageQueryAwareness:
+		room.mu.Lock()
+		c.enqueue(yjs.AwarenessMessage(room.awarenessStates()))
+		room.mu.Unlock()
+	}
+	return nil
+}
+
+// sendMissing answers a client's state vector with the part of the
+// document it does not have yet.
+func (c *collabConn) sendMissing(room *collabRoom, rawSV []byte) error {
+	sv, err := yjs.DecodeStateVector(rawSV)
+	if err != nil {
+		return domain.InvalidInput("malformed state vector")
+	}
+	room.mu.Lock()
+	state, err := room.snapshot()
+	room.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	diff, err := yjs.DiffUpdate(state, sv)
+	if err != nil {
+		return err
+	}
+	c.enqueue(yjs.SyncMessage(yjs.SyncStep2, diff))
+	return nil
+}
+
+// applyUpdate stores an update and relays it to the other clients. The
+// update is persisted before anyone sees it.
+func (c *collabConn) applyUpdate(docs *domain.CollabService, room *collabRoom, update []byte) error {
+	if !c.canEdit || yjs.IsEmpty(update) {
+		return nil
+	}
+	if err := docs.StoreUpdate(room.context(), room.tenantID, room.docID, update); err != nil {
+		return err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.pending = append(room.pending, update)
+	room.stored++
+	room.broadcast(yjs.SyncMessage(yjs.SyncUpdate, update), c)
+	room.relay(collabRelay{Update: update})
+	if room.stored >= collabCompactEvery {
+		room.stored = 0
+		go func() {
+			if err := docs.Compact(room.context(), room.tenantID, room.docID); err != nil {
+				log.Printf("collab: compacting document %s failed: %v", room.docID, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// updateAwareness records the states a client sent and relays them to
+// everyone, the sender included. A client may only change the states of
+// awareness IDs it introduced. States relayed from other replicas have
+// no client.
+func (r *collabRoom) updateAwareness(c *collabConn, states []yjs.AwarenessState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var accepted []yjs.AwarenessState
+	for _, st := range states {
+		if owner, ok := r.owners[st.Client]; ok && owner != c {
+			continue
+		}
+		prev, known := r.awareness[st.Client]
+		if known && st.Clock < prev.Clock {
+			continue
+		}
+		if st.Removed() {
+			delete(r.awareness, st.Client)
+			delete(r.owners, st.Client)
+		} else {
+			r.awareness[st.Client] = st
+			r.owners[st.Client] = c
+		}
+		accepted = append(accepted, st)
+	}
+	if len(accepted) > 0 {
+		r.broadcast(yjs.AwarenessMessage(accepted), nil)
+		if c != nil {
+			r.relay(collabRelay{Awareness: accepted})
+		}
+	}
+}
+
+// relay sends a local change to the rooms of the document on other
+// replicas.
+func (r *collabRoom) relay(data collabRelay) {
+	if r.hub != nil {
+		r.hub.Publish(Message{Topic: CollabTopic(r.tenantID, r.docID), Type: "collab.relay", Data: data})
+	}
+}
+
+// receiveRelayed applies the changes other replicas relay until the room
+// is released. Updates were stored by the replica that received them.
+func (r *collabRoom) receiveRelayed(origin string) {
+	for msg := range r.sub.C {
+		if msg.Origin == origin {
+			continue
+		}
+		raw, err := json.Marshal(msg.Data)
+		if err != nil {
+			continue
+		}
+		var data collabRelay
+		if err := json.Unmarshal(raw, &data); err != nil {
+			log.Printf("collab: malformed relayed message for document %s: %v", r.docID, err)
+			continue
+		}
+		if len(data.Update) > 0 {
+			r.mu.Lock()
+			// Before loading, the stored state already has the update
+			if r.loaded {
+				r.pending = append(r.pending, data.Update)
+				r.broadcast(yjs.SyncMessage(yjs.SyncUpdate, data.Update), nil)
+			}
+			r.mu.Unlock()
+		}
+		if len(data.Awareness) > 0 {
+			r.updateAwareness(nil, data.Awareness)
+		}
+	}
+}
+
+// enqueue queues a message without blocking. A client too slow to keep
+// up is disconnected; it resyncs when it reconnects.
+func (c *collabConn) enqueue(msg []byte) {
+	select {
+	case <-c.shutdown:
+	case c.send <- msg:
+	default:
+		c.close()
+	}
+}
+
+func (c *collabConn) close() {
+	c.closing.Do(func() { close(c.shutdown) })
+}
+
+func (c *collabConn) writeLoop() {
+	ticker := time.NewTicker(collabPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+	for {
+		select {
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if err := c.ws.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(collabWriteWait)); err != nil {
+				return
+			}
+		case <-c.shutdown:
+			c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(collabWriteWait))
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package realtime
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// subscriberBuffer is the number of messages a slow subscriber may lag
+// behind before further messages to it are dropped.
+const subscriberBuffer = 64
+
+// Message is a realtime update pushed to subscribed clients.
+type Message struct {
+	// ID numbers the messages of all replicas in the order every replica
+	// delivers them; it is zero without a backplane.
+	ID    uint64      `json:"id,omitempty"`
+	Topic string      `json:"topic"`
+	Type  string      `json:"type"`
+	Data  interface{} `json:"data"`
+	// Origin identifies the replica that published the message.
+	Origin string `json:"-"`
+}
+
+// Subscription receives the messages published to its topics.
+type Subscription struct {
+	C      <-chan Message
+	ch     chan Message
+	topics []string
+}
+
+// Hub is a publish/subscribe hub for realtime updates. On its own it
+// delivers messages to the subscribers of this process; with a backplane
+// messages reach the subscribers of every replica.
+type Hub struct {
+	mu     sync.RWMutex
+	topics map[string]map[*Subscription]struct{}
+
+	// Set by UseBackplane
+	relay *relay
+}
+
+// NewHub creates an empty hub.
+func NewHub() *Hub {
+	return &Hub{topics: make(map[string]map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription to the given topics. Callers must
+// Unsubscribe when done.
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	return h.SubscribeAfter(0, topics...)
+}
+
+// SubscribeAfter is Subscribe for a client resuming after the message
+// with ID after: the subscription starts with the retained messages on
+// its topics that the client missed.
+func (h *Hub) SubscribeAfter(after uint64, topics ...string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var missed []Message
+	if after > 0 && h.relay != nil {
+		for _, msg := range h.relay.recent {
+			if msg.ID > after && slices.Contains(topics, msg.Topic) {
+				missed = append(missed, msg)
+			}
+		}
+	}
+	ch := make(chan Message, subscriberBuffer+len(missed))
+	for _, msg := range missed {
+		ch <- msg
+	}
+	sub := &Subscription{C: ch, ch: ch, topics: topics}
+	for _, topic := range topics {
+		if h.topics[topic] == nil {
+			h.topics[topic] = make(map[*Subscription]struct{})
+		}
+		h.topics[topic][sub] = struct{}{}
+	}
+	return sub
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range sub.topics {
+		delete(h.topics[topic], sub)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	close(sub.ch)
+}
+
+// Publish sends a message to every subscriber of its topic without
+// blocking; subscribers whose buffer is full miss the message. With a
+// backplane the message is delivered once the backplane relayed it.
+func (h *Hub) Publish(msg Message) {
+	if h.relay != nil {
+		h.relay.publish(msg)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.deliver(msg)
+}
+
+// deliver fans a message out to the local subscribers. Callers hold h.mu.
+func (h *Hub) deliver(msg Message) {
+	for sub := range h.topics[msg.Topic] {
+		select {
+		case sub.ch <- msg:
+		default:
+		}
+	}
+}
+
+// ResourceTopic is the topic carrying updates about a resource.
+func ResourceTopic(tenantID, resourceType, resourceID string) string {
+	return "tenant:" + tenantID + ":resource:" + resourceType + ":" + resourceID
+}
+
+// UserTopic is the topic carrying updates addressed to a user.
+func UserTopic(tenantID, userID string) string {
+	return "tenant:" + tenantID + ":user:" + userID
+}
+
+// ForwardEvents pushes every domain event that concerns a resource to the
+// resource's topic.
+func ForwardEvents(bus *domain.EventBus, hub *Hub) {
+	bus.Subscribe("*", func(ctx context.Context, e domain.Event) error {
+		if e.ResourceType == "" || e.ResourceID == "" {
+			return nil
+		}
+		hub.Publish(Message{
+			Topic: ResourceTopic(e.TenantID, e.ResourceType, e.ResourceID),
+			Type:  e.Type,
+			Data:  e,
+		})
+		return nil
+	})
+}
+
+// MessageSchemas describes the messages the hub sends that are not domain
+// events.
+var MessageSchemas = []domain.MessageSchema{
+	{
+		Name:    "notification.created",
+		Version: 1,
+		Summary: "A notification was added to the user's inbox",
+		Channel: domain.ChannelUser,
+		Payload: domain.SchemaOf(domain.Notification{}),
+	},
+}
+
+// NotificationChannel delivers notifications to the recipient's user topic.
+type NotificationChannel struct {
+	hub *Hub
+}
+
+// NewNotificationChannel creates a realtime notification channel.
+func NewNotificationChannel(hub *Hub) *NotificationChannel {
+	return &NotificationChannel{hub: hub}
+}
+
+func (c *NotificationChannel) Name() string { return "realtime" }
+
+func (c *NotificationChannel) Deliver(ctx context.Context, n domain.Notification) error {
+	c.hub.Publish(Message{
+		Topic: UserTopic(n.TenantID, n.UserID),
+		Type:  "notification.created",
+		Data:  n,
+	})
+	return nil
+}
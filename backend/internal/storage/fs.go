@@ -0,0 +1,173 @@
+// Package storage implements domain.BlobStore.
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+const tempDir = "tmp"
+
+// FSBlobStore keeps blobs as files named by their hash under a root
+// directory, fanned out by the first two byte pairs of the hash
+// (ab/cd/abcd...). Content is written to a temporary file while it is
+// hashed and then renamed into place, so readers never see partial
+// blobs.
+type FSBlobStore struct {
+	root string
+	// mu orders refreshing an existing blob in Put against Delete, so a
+	// blob is never removed between being found and being refreshed.
+	mu sync.Mutex
+}
+
+// NewFSBlobStore creates the root directory if needed.
+func NewFSBlobStore(root string) (*FSBlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, tempDir), 0o750); err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	return &FSBlobStore{root: root}, nil
+}
+
+// Root returns the directory blobs are stored in.
+func (s *FSBlobStore) Root() string {
+	return s.root
+}
+
+func (s *FSBlobStore) Put(ctx context.Context, r io.Reader) (domain.BlobInfo, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tempDir), "upload-*")
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	path := s.path(hash)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		// Deduplicated: keep the stored copy and mark it as fresh
+		return domain.BlobInfo{Hash: hash, Size: size, ModTime: now}, os.Chtimes(path, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return domain.BlobInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return domain.BlobInfo{}, err
+	}
+	return domain.BlobInfo{Hash: hash, Size: size, ModTime: now}, nil
+}
+
+func (s *FSBlobStore) Open(ctx context.Context, hash string) (io.ReadSeekCloser, error) {
+	if !validHash(hash) {
+		return nil, domain.ErrBlobNotFound
+	}
+	f, err := os.Open(s.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, domain.ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *FSBlobStore) Stat(ctx context.Context, hash string) (domain.BlobInfo, error) {
+	if !validHash(hash) {
+		return domain.BlobInfo{}, domain.ErrBlobNotFound
+	}
+	info, err := os.Stat(s.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return domain.BlobInfo{}, domain.ErrBlobNotFound
+	}
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	return domain.BlobInfo{Hash: hash, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FSBlobStore) Delete(ctx context.Context, hash string, before time.Time) (bool, error) {
+	if !validHash(hash) {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(hash)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.ModTime().Before(before) {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+// Walk visits the stored blobs. Leftover temporary files of interrupted
+// uploads older than a day are removed on the way.
+func (s *FSBlobStore) Walk(ctx context.Context, fn func(domain.BlobInfo) error) error {
+	return filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if filepath.Base(filepath.Dir(path)) == tempDir {
+			if time.Since(info.ModTime()) > 24*time.Hour {
+				os.Remove(path)
+			}
+			return nil
+		}
+		hash := d.Name()
+		if !validHash(hash) || s.path(hash) != path {
+			return nil
+		}
+		return fn(domain.BlobInfo{Hash: hash, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+func (s *FSBlobStore) path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash[2:4], hash)
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	return strings.Trim(hash, "0123456789abcdef") == ""
+}
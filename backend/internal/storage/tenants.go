@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// TenantDir returns the directory of the tenant of ctx when the tenant
+// keeps its data apart from the others, or "".
+type TenantDir func(ctx context.Context) string
+
+// tenantStores opens a store per tenant directory on first use.
+type tenantStores[S any] struct {
+	dir  TenantDir
+	sub  string
+	open func(root string) (S, error)
+
+	mu     sync.Mutex
+	stores map[string]S
+}
+
+// get returns the store of the tenant of ctx, or shared.
+func (t *tenantStores[S]) get(ctx context.Context, shared S) (S, error) {
+	dir := t.dir(ctx)
+	if dir == "" {
+		return shared, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.stores[dir]; ok {
+		return s, nil
+	}
+	s, err := t.open(filepath.Join(dir, t.sub))
+	if err != nil {
+		return s, err
+	}
+	t.stores[dir] = s
+	return s, nil
+}
+
+// TenantBlobStore keeps the content of tenants with a database of their
+// own in a directory of their own. Blobs are then only shared within a
+// database, so collecting one database's garbage cannot remove content
+// another still references.
+type TenantBlobStore struct {
+	shared  domain.BlobStore
+	tenants tenantStores[*FSBlobStore]
+}
+
+// NewTenantBlobStore stores the content of tenants for which dir returns
+// a directory in its "files" subdirectory, and all other content in
+// shared.
+func NewTenantBlobStore(shared domain.BlobStore, dir TenantDir) *TenantBlobStore {
+	return &TenantBlobStore{
+		shared: shared,
+		tenants: tenantStores[*FSBlobStore]{
+			dir: dir, sub: "files", open: NewFSBlobStore, stores: make(map[string]*FSBlobStore),
+		},
+	}
+}
+
+func (s *TenantBlobStore) store(ctx context.Context) (domain.BlobStore, error) {
+	fs, err := s.tenants.get(ctx, nil)
+	if err != nil || fs == nil {
+		return s.shared, err
+	}
+	return fs, nil
+}
+
+func (s *TenantBlobStore) Put(ctx context.Context, r io.Reader) (domain.BlobInfo, error) {
+	store, err := s.store(ctx)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	return store.Put(ctx, r)
+}
+
+func (s *TenantBlobStore) Open(ctx context.Context, hash string) (io.ReadSeekCloser, error) {
+	store, err := s.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(ctx, hash)
+}
+
+func (s *TenantBlobStore) Stat(ctx context.Context, hash string) (domain.BlobInfo, error) {
+	store, err := s.store(ctx)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	return store.Stat(ctx, hash)
+}
+
+func (s *TenantBlobStore) Delete(ctx context.Context, hash string, before time.Time) (bool, error) {
+	store, err := s.store(ctx)
+	if err != nil {
+		return false, err
+	}
+	return store.Delete(ctx, hash, before)
+}
+
+func (s *TenantBlobStore) Walk(ctx context.Context, fn func(domain.BlobInfo) error) error {
+	store, err := s.store(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Walk(ctx, fn)
+}
+
+// TenantUploadStore keeps the uploads in progress of tenants with a
+// database of their own in a directory of their own, where only their
+// database's expiry sweep sees them.
+type TenantUploadStore struct {
+	shared  domain.UploadStore
+	tenants tenantStores[*FSUploadStore]
+}
+
+// NewTenantUploadStore stores the uploads of tenants for which dir
+// returns a directory in its "uploads" subdirectory, and all others in
+// shared.
+func NewTenantUploadStore(shared domain.UploadStore, dir TenantDir) *TenantUploadStore {
+	return &TenantUploadStore{
+		shared: shared,
+		tenants: tenantStores[*FSUploadStore]{
+			dir: dir, sub: "uploads", open: NewFSUploadStore, stores: make(map[string]*FSUploadStore),
+		},
+	}
+}
+
+func (s *TenantUploadStore) store(ctx context.Context) (domain.UploadStore, error) {
+	fs, err := s.tenants.get(ctx, nil)
+	if err != nil || fs == nil {
+		return s.shared, err
+	}
+	return fs, nil
+}
+
+func (s *TenantUploadStore) Create(ctx context.Context, id string) error {
+	store, err := s.store(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Create(ctx, id)
+}
+
+func (s *TenantUploadStore) Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error) {
+	store, err := s.store(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return store.Append(ctx, id, offset, r)
+}
+
+func (s *TenantUploadStore) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	store, err := s.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(ctx, id)
+}
+
+func (s *TenantUploadStore) Remove(ctx context.Context, id string) error {
+	store, err := s.store(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Remove(ctx, id)
+}
+
+func (s *TenantUploadStore) Walk(ctx context.Context, fn func(id string, modTime time.Time) error) error {
+	store, err := s.store(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Walk(ctx, fn)
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const uploadSuffix = ".part"
+
+// FSUploadStore keeps the bytes of resumable uploads in progress as one
+// file per upload in a directory.
+type FSUploadStore struct {
+	dir string
+}
+
+// NewFSUploadStore creates the directory if needed.
+func NewFSUploadStore(dir string) (*FSUploadStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	return &FSUploadStore{dir: dir}, nil
+}
+
+func (s *FSUploadStore) Create(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FSUploadStore) Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	return n, err
+}
+
+func (s *FSUploadStore) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *FSUploadStore) Remove(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FSUploadStore) Walk(ctx context.Context, fn func(id string, modTime time.Time) error) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), uploadSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(strings.TrimSuffix(e.Name(), uploadSuffix), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path maps an upload ID to its file, refusing IDs that could escape
+// the directory.
+func (s *FSUploadStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("storage: invalid upload id %q", id)
+	}
+	return filepath.Join(s.dir, id+uploadSuffix), nil
+}
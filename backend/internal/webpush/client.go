@@ -0,0 +1,125 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// errPrivateAddress is returned for endpoints whose host name resolves to
+// an address in the server's own network.
+var errPrivateAddress = errors.New("endpoint does not resolve to a public address")
+
+// Client sends push messages to push services. It implements
+// domain.PushSender.
+type Client struct {
+	key      *VAPIDKey
+	subject  string
+	http     *http.Client
+	loopback bool
+}
+
+// NewClient creates a client that signs requests with key. subject is a
+// mailto: or https: contact the push service can reach the operator at.
+// The client only connects to public addresses, whatever an endpoint's
+// host name resolves to.
+func NewClient(key *VAPIDKey, subject string) *Client {
+	c := &Client{key: key, subject: subject}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: c.checkAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would resolve host names itself, past checkAddress.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	c.http = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	return c
+}
+
+// AllowLoopback lets the client connect to loopback addresses, for a
+// local push service stand-in during development.
+func (c *Client) AllowLoopback() {
+	c.loopback = true
+}
+
+// checkAddress runs before each connection with the resolved address,
+// so host names pointing into the server's network are refused too.
+func (c *Client) checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return fmt.Errorf("%w: %s", errPrivateAddress, address)
+	case ip.IsLoopback() && c.loopback:
+		return nil
+	case !domain.PublicIP(ip):
+		return fmt.Errorf("%w: %s", errPrivateAddress, ip)
+	}
+	return nil
+}
+
+// Send encrypts payload for the subscription and posts it to its
+// endpoint. 404 and 410 responses, and endpoints resolving to private
+// addresses, yield domain.ErrPushSubscriptionGone.
+func (c *Client) Send(ctx context.Context, sub domain.PushSubscription, payload []byte, opts domain.PushOptions) error {
+	p256dh, err := decodeBase64(sub.P256dh)
+	if err != nil {
+		return fmt.Errorf("webpush: subscription key: %w", err)
+	}
+	auth, err := decodeBase64(sub.Auth)
+	if err != nil {
+		return fmt.Errorf("webpush: authentication secret: %w", err)
+	}
+	body, err := Encrypt(payload, p256dh, auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := c.key.authorization(sub.Endpoint, c.subject, time.Now())
+	if err != nil {
+		return fmt.Errorf("webpush: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webpush: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(opts.TTL/time.Second)))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+	resp, err := c.http.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return fmt.Errorf("%w: %w", domain.ErrPushSubscriptionGone, err)
+	}
+	if err != nil {
+		return fmt.Errorf("webpush: %w", err)
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return fmt.Errorf("%w: %s", domain.ErrPushSubscriptionGone, resp.Status)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webpush: rate limited by the push service (Retry-After %q)", resp.Header.Get("Retry-After"))
+	}
+	return fmt.Errorf("webpush: push service answered %s: %s", resp.Status, bytes.TrimSpace(detail))
+}
@@ -0,0 +1,59 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"greact-bones/backend/internal/domain"
+)
+
+// TestSendRefusesHostResolvingToLoopback checks that the client refuses
+// endpoints whose host name resolves to a private address, which the
+// literal-address check on subscribing cannot see.
+func TestSendRefusesHostResolvingToLoopback(t *testing.T) {
+	receiver := NewReceiver()
+	received := 0
+	receiver.Received = func(Received) { received++ }
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	// localhost resolves to 127.0.0.1; the receiver builds the endpoint
+	// from the host the subscription was requested at.
+	u, _ := url.Parse(srv.URL)
+	resp, err := http.Get("http://localhost:" + u.Port() + "/subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var in domain.PushSubscriptionInput
+	if err := json.NewDecoder(resp.Body).Decode(&in); err != nil {
+		t.Fatal(err)
+	}
+	sub := domain.PushSubscription{Endpoint: in.Endpoint, P256dh: in.Keys.P256dh, Auth: in.Keys.Auth}
+
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(key, "mailto:ops@example.com")
+	err = client.Send(context.Background(), sub, []byte("hello"), domain.PushOptions{})
+	if !errors.Is(err, domain.ErrPushSubscriptionGone) || !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("sending to %s: got %v, want a refused private address", sub.Endpoint, err)
+	}
+	if received != 0 {
+		t.Fatalf("the receiver got %d messages, want none", received)
+	}
+
+	client.AllowLoopback()
+	if err := client.Send(context.Background(), sub, []byte("hello"), domain.PushOptions{}); err != nil {
+		t.Fatalf("sending with loopback allowed: %v", err)
+	}
+	if received != 1 {
+		t.Fatalf("the receiver got %d messages, want 1", received)
+	}
+}
@@ -0,0 +1,137 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// recordSize is the aes128gcm record size; a push message is a single
+	// record.
+	recordSize = 4096
+	// headerSize is the salt, record size, key ID length and key ID.
+	headerSize = 16 + 4 + 1 + 65
+	// MaxPayload is the largest plaintext that fits the 4096 bytes every
+	// push service accepts, after the header, the padding delimiter and
+	// the authentication tag.
+	MaxPayload = recordSize - headerSize - 1 - 16
+)
+
+// ErrPayloadTooLarge means a payload exceeds MaxPayload.
+var ErrPayloadTooLarge = fmt.Errorf("webpush: payload exceeds %d bytes", MaxPayload)
+
+// Encrypt encrypts plaintext for a subscription per RFC 8291 with the
+// aes128gcm content coding of RFC 8188. p256dh is the subscription's
+// public key and auth its authentication secret.
+func Encrypt(plaintext, p256dh, auth []byte) ([]byte, error) {
+	if len(plaintext) > MaxPayload {
+		return nil, ErrPayloadTooLarge
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: subscription key: %w", err)
+	}
+	if len(auth) != 16 {
+		return nil, errors.New("webpush: the authentication secret must be 16 bytes")
+	}
+	// A new key and salt for every message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	gcm, nonce, err := contentKeys(secret, auth, salt, p256dh, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0, headerSize+len(plaintext)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(asPublic)))
+	body = append(body, asPublic...)
+	// 0x02 marks the last record; no further padding
+	record := append(append([]byte(nil), plaintext...), 0x02)
+	return gcm.Seal(body, nonce, record, nil), nil
+}
+
+// Decrypt reverses Encrypt with the subscription's private key, as the
+// browser does on receipt.
+func Decrypt(body []byte, uaPrivate *ecdh.PrivateKey, auth []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("webpush: message too short")
+	}
+	salt := body[:16]
+	idLen := int(body[20])
+	if len(body) < 21+idLen {
+		return nil, errors.New("webpush: message too short")
+	}
+	asPublic := body[21 : 21+idLen]
+	sender, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: sender key: %w", err)
+	}
+	secret, err := uaPrivate.ECDH(sender)
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := contentKeys(secret, auth, salt, uaPrivate.PublicKey().Bytes(), asPublic)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: %w", err)
+	}
+	record = bytes.TrimRight(record, "\x00")
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		return nil, errors.New("webpush: message is not a single final record")
+	}
+	return record[:len(record)-1], nil
+}
+
+// contentKeys derives the content encryption key and nonce (RFC 8291
+// section 3.4) and returns the cipher keyed with it.
+func contentKeys(secret, auth, salt, uaPublic, asPublic []byte) (cipher.AEAD, []byte, error) {
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(auth, secret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, nonce, nil
+}
+
+// hkdf is HKDF-SHA-256 (RFC 5869) for outputs of at most one hash
+// length, which is all Web Push needs.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
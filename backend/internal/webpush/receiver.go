@@ -0,0 +1,198 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Receiver is a push service stand-in for local testing. GET /subscribe
+// plays a browser subscribing and returns a subscription to register with
+// the API. Messages posted to it are checked like a real push service
+// does (VAPID token, headers, encryption) and decrypted; unknown and
+// unsubscribed endpoints answer 404 and 410.
+type Receiver struct {
+	// Received is called with each decrypted message.
+	Received func(Received)
+
+	mu            sync.Mutex
+	subscriptions map[string]*receiverSubscription
+}
+
+// Received is a message the Receiver accepted.
+type Received struct {
+	Subscription string
+	Subject      string
+	TTL          int
+	Urgency      string
+	Topic        string
+	Payload      []byte
+}
+
+type receiverSubscription struct {
+	private *ecdh.PrivateKey
+	auth    []byte
+	// vapidKey is the application server key the subscription is bound
+	// to, set by the first message as browsers set it on subscribe
+	vapidKey string
+	gone     bool
+}
+
+// NewReceiver creates an empty push service stand-in.
+func NewReceiver() *Receiver {
+	return &Receiver{subscriptions: map[string]*receiverSubscription{}}
+}
+
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/subscribe":
+		rc.subscribe(w, r)
+	case strings.HasPrefix(r.URL.Path, "/push/"):
+		id := strings.TrimPrefix(r.URL.Path, "/push/")
+		switch r.Method {
+		case http.MethodPost:
+			rc.push(w, r, id)
+		case http.MethodDelete:
+			rc.unsubscribe(w, id)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// subscribe returns a new subscription in the JSON form of a browser
+// PushSubscription.
+func (rc *Receiver) subscribe(w http.ResponseWriter, r *http.Request) {
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	idBytes := make([]byte, 12)
+	rand.Read(idBytes)
+	id := b64.EncodeToString(idBytes)
+
+	rc.mu.Lock()
+	rc.subscriptions[id] = &receiverSubscription{private: private, auth: auth}
+	rc.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"endpoint":       "http://" + r.Host + "/push/" + id,
+		"expirationTime": nil,
+		"keys": map[string]string{
+			"p256dh": b64.EncodeToString(private.PublicKey().Bytes()),
+			"auth":   b64.EncodeToString(auth),
+		},
+	})
+}
+
+// unsubscribe makes the endpoint answer 410 Gone from now on.
+func (rc *Receiver) unsubscribe(w http.ResponseWriter, id string) {
+	rc.mu.Lock()
+	sub, ok := rc.subscriptions[id]
+	if ok {
+		sub.gone = true
+	}
+	rc.mu.Unlock()
+	if !ok {
+		http.Error(w, "no such subscription", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *Receiver) push(w http.ResponseWriter, r *http.Request, id string) {
+	rc.mu.Lock()
+	sub, ok := rc.subscriptions[id]
+	rc.mu.Unlock()
+	switch {
+	case !ok:
+		http.Error(w, "no such subscription", http.StatusNotFound)
+		return
+	case sub.gone:
+		http.Error(w, "subscription expired or was unsubscribed", http.StatusGone)
+		return
+	}
+
+	claims, vapidKey, err := verifyAuthorization(r.Header.Get("Authorization"), time.Now())
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if audience := "http://" + r.Host; claims.Audience != audience {
+		http.Error(w, fmt.Sprintf("unauthorized: token audience is %q, not %q", claims.Audience, audience), http.StatusUnauthorized)
+		return
+	}
+	rc.mu.Lock()
+	if sub.vapidKey == "" {
+		sub.vapidKey = vapidKey
+	}
+	bound := sub.vapidKey
+	rc.mu.Unlock()
+	if vapidKey != bound {
+		http.Error(w, "forbidden: the subscription belongs to another application server key", http.StatusForbidden)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Header.Get("TTL"))
+	if err != nil || ttl < 0 {
+		http.Error(w, "missing or invalid TTL header", http.StatusBadRequest)
+		return
+	}
+	urgency := r.Header.Get("Urgency")
+	switch urgency {
+	case "", "very-low", "low", "normal", "high":
+	default:
+		http.Error(w, "invalid Urgency header", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "aes128gcm" {
+		http.Error(w, "Content-Encoding must be aes128gcm", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, recordSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > recordSize {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	payload, err := Decrypt(body, sub.private, sub.auth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if rc.Received != nil {
+		rc.Received(Received{
+			Subscription: id,
+			Subject:      claims.Subject,
+			TTL:          ttl,
+			Urgency:      urgency,
+			Topic:        r.Header.Get("Topic"),
+			Payload:      payload,
+		})
+	}
+	w.Header().Set("Location", "/message/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// LogReceived prints a received message, for Receiver.Received.
+func LogReceived(m Received) {
+	log.Printf("push to %s (TTL %ds, urgency %q, topic %q, from %s): %s", m.Subscription, m.TTL, m.Urgency, m.Topic, m.Subject, m.Payload)
+}
@@ -0,0 +1,223 @@
+// Package webpush sends Web Push messages (RFC 8030) with VAPID
+// authentication (RFC 8292) and payloads encrypted per RFC 8291, and
+// includes a push service stand-in for local testing.
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vapidTokenTTL is how long a VAPID token is valid; RFC 8292 allows at
+// most 24 hours.
+const vapidTokenTTL = 12 * time.Hour
+
+var b64 = base64.RawURLEncoding
+
+// VAPIDKey is the P-256 key pair that identifies this server to push
+// services. Browsers bind subscriptions to its public key, so replacing
+// the key invalidates every subscription.
+type VAPIDKey struct {
+	private *ecdsa.PrivateKey
+}
+
+// GenerateVAPIDKey creates a new key pair.
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{private: private}, nil
+}
+
+// ParseVAPIDKey reads a private key in the format web-push libraries
+// use: the 32-byte scalar, base64url-encoded.
+func ParseVAPIDKey(encoded string) (*VAPIDKey, error) {
+	d, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("vapid: private key is not base64url: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("vapid: %w", err)
+	}
+	public, err := parsePublicKey(key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{private: &ecdsa.PrivateKey{PublicKey: *public, D: new(big.Int).SetBytes(d)}}, nil
+}
+
+// LoadOrCreateVAPIDKey reads the key stored at path, creating the file
+// with a new key on first use.
+func LoadOrCreateVAPIDKey(path string) (*VAPIDKey, bool, error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		key, err := ParseVAPIDKey(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return key, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, false, err
+	}
+	// O_EXCL so two replicas starting at once do not overwrite each other
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		key, _, err := LoadOrCreateVAPIDKey(path)
+		return key, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := f.WriteString(key.PrivateKey() + "\n"); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return key, true, f.Close()
+}
+
+// PublicKey is the uncompressed public point, base64url-encoded. Clients
+// pass it as applicationServerKey to PushManager.subscribe.
+func (k *VAPIDKey) PublicKey() string {
+	return b64.EncodeToString(k.publicBytes())
+}
+
+// PrivateKey is the private scalar, base64url-encoded.
+func (k *VAPIDKey) PrivateKey() string {
+	return b64.EncodeToString(k.private.D.FillBytes(make([]byte, 32)))
+}
+
+func (k *VAPIDKey) publicBytes() []byte {
+	public, _ := k.private.ECDH()
+	return public.PublicKey().Bytes()
+}
+
+// authorization builds the "vapid" Authorization header for a push to
+// endpoint: a signed JWT naming the push service's origin and a contact
+// for the server's operator, and the public key to verify it with.
+func (k *VAPIDKey) authorization(endpoint, subject string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTokenTTL).Unix(),
+		"sub": subject,
+	})
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// ES256 signatures are r and s as fixed-size big-endian integers
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return "vapid t=" + signingInput + "." + b64.EncodeToString(signature) + ", k=" + k.PublicKey(), nil
+}
+
+// vapidClaims are the verified claims of a VAPID token.
+type vapidClaims struct {
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	Subject   string `json:"sub"`
+}
+
+// verifyAuthorization checks a "vapid" Authorization header as a push
+// service does and returns its claims and public key.
+func verifyAuthorization(header string, now time.Time) (*vapidClaims, string, error) {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "vapid") {
+		return nil, "", errors.New("missing vapid authorization")
+	}
+	var token, publicKey string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			publicKey = value
+		}
+	}
+	raw, err := decodeBase64(publicKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("public key: %w", err)
+	}
+	public, err := parsePublicKey(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, "", errors.New("malformed token")
+	}
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return nil, "", errors.New("malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(public, digest[:], r, s) {
+		return nil, "", errors.New("invalid token signature")
+	}
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, "", errors.New("malformed token claims")
+	}
+	var claims vapidClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, "", errors.New("malformed token claims")
+	}
+	expires := time.Unix(claims.ExpiresAt, 0)
+	if expires.Before(now) || expires.After(now.Add(24*time.Hour)) {
+		return nil, "", fmt.Errorf("token expiry %s is not within the next 24 hours", expires.UTC().Format(time.RFC3339))
+	}
+	return &claims, publicKey, nil
+}
+
+// parsePublicKey converts an uncompressed P-256 point, which ecdh
+// validates, into an ECDSA public key.
+func parsePublicKey(raw []byte) (*ecdsa.PublicKey, error) {
+	if _, err := ecdh.P256().NewPublicKey(raw); err != nil {
+		return nil, fmt.Errorf("vapid: %w", err)
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(raw[1:33]),
+		Y:     new(big.Int).SetBytes(raw[33:]),
+	}, nil
+}
+
+// decodeBase64 accepts base64url with or without padding, and standard
+// base64, since clients are not consistent about which they send.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return b64.DecodeString(s)
+}
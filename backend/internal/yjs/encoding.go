@@ -0,0 +1,153 @@
+// Package yjs reads and writes the binary formats of the Yjs CRDT library:
+// document updates (format v1), state vectors and the lib0 encoding they
+// are built on. It merges and diffs updates without integrating them into
+// a document, which is all a server relaying and storing updates needs.
+package yjs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrMalformed is returned for input that is not a valid encoding.
+var ErrMalformed = errors.New("yjs: malformed input")
+
+// Decoder reads lib0-encoded values. The first error sticks; later reads
+// return zero values.
+type Decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+// NewDecoder creates a decoder reading b.
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{buf: b}
+}
+
+// Err returns the first error encountered.
+func (d *Decoder) Err() error { return d.err }
+
+// Done reports whether all input was read.
+func (d *Decoder) Done() bool { return d.err != nil || d.pos >= len(d.buf) }
+
+func (d *Decoder) fail() {
+	if d.err == nil {
+		d.err = ErrMalformed
+	}
+	d.pos = len(d.buf)
+}
+
+// Byte reads a single byte.
+func (d *Decoder) Byte() byte {
+	if d.pos >= len(d.buf) {
+		d.fail()
+		return 0
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b
+}
+
+// Uvarint reads an unsigned variable-length integer.
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+// Bytes reads a length-prefixed byte array.
+func (d *Decoder) Bytes() []byte {
+	n := d.Uvarint()
+	if d.err != nil || n > uint64(len(d.buf)-d.pos) {
+		d.fail()
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+// String reads a length-prefixed UTF-8 string.
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
+
+func (d *Decoder) skip(n int) {
+	if n > len(d.buf)-d.pos {
+		d.fail()
+		return
+	}
+	d.pos += n
+}
+
+// skipVarint skips a signed or unsigned variable-length integer; both use
+// the high bit of each byte as the continuation flag.
+func (d *Decoder) skipVarint() {
+	for d.Byte()&0x80 != 0 {
+	}
+}
+
+// skipAny skips a value in lib0's "any" encoding.
+func (d *Decoder) skipAny() {
+	switch d.Byte() {
+	case 127, 126, 121, 120: // undefined, null, false, true
+	case 125: // integer
+		d.skipVarint()
+	case 124: // float32
+		d.skip(4)
+	case 123, 122: // float64, bigint
+		d.skip(8)
+	case 119: // string
+		d.Bytes()
+	case 118: // object
+		for n := d.Uvarint(); n > 0 && d.err == nil; n-- {
+			d.Bytes()
+			d.skipAny()
+		}
+	case 117: // array
+		for n := d.Uvarint(); n > 0 && d.err == nil; n-- {
+			d.skipAny()
+		}
+	case 116: // byte array
+		d.Bytes()
+	default:
+		d.fail()
+	}
+}
+
+// Encoder writes lib0-encoded values.
+type Encoder struct {
+	buf []byte
+}
+
+// Bytes returns the encoded data.
+func (e *Encoder) Bytes() []byte { return e.buf }
+
+// Byte writes a single byte.
+func (e *Encoder) Byte(b byte) { e.buf = append(e.buf, b) }
+
+// Uvarint writes an unsigned variable-length integer.
+func (e *Encoder) Uvarint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+
+// WriteBytes writes a length-prefixed byte array.
+func (e *Encoder) WriteBytes(b []byte) {
+	e.Uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// String writes a length-prefixed UTF-8 string.
+func (e *Encoder) String(s string) {
+	e.Uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// raw appends already encoded bytes.
+func (e *Encoder) raw(b []byte) { e.buf = append(e.buf, b...) }
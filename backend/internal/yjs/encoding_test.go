@@ -0,0 +1,153 @@
+package yjs
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Updates with every kind of content the decoder has to step over,
+// as Yjs 13 encodes them.
+var (
+	// doc.clientID = 3; doc.getArray('a').insert(0,
+	//   [null, true, false, -1, 0.5, 1.1, 'x', {k: [1]}])
+	arrayAny = []byte{1, 1, 3, 0, 8, 1, 1, 'a', 8,
+		126, 120, 121, 125, 0x41, 124, 0x3f, 0, 0, 0,
+		123, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a,
+		119, 1, 'x', 118, 1, 1, 'k', 117, 1, 125, 1, 0}
+	// Y.encodeStateAsUpdate(doc, Uint8Array.of(1, 3, 5))
+	arrayAnyFrom5 = []byte{1, 1, 3, 5, 0x88, 3, 4, 3,
+		123, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a,
+		119, 1, 'x', 118, 1, 1, 'k', 117, 1, 125, 1, 0}
+	// doc.clientID = 4; doc.getXmlFragment('x').insert(0, [new Y.XmlElement('p')])
+	xmlElement = []byte{1, 1, 4, 0, 7, 1, 1, 'x', 3, 1, 'p', 0}
+	// doc.clientID = 5; doc.getText('t').insert(0, 'b', {bold: true})
+	formattedText = []byte{1, 3, 5, 0,
+		6, 1, 1, 't', 4, 'b', 'o', 'l', 'd', 4, 't', 'r', 'u', 'e',
+		0x84, 5, 0, 1, 'b',
+		0x86, 5, 1, 4, 'b', 'o', 'l', 'd', 4, 'n', 'u', 'l', 'l', 0}
+	// doc.clientID = 6; doc.getArray('a').insert(0, [Uint8Array.of(7, 8)])
+	arrayBinary = []byte{1, 1, 6, 0, 3, 1, 1, 'a', 2, 7, 8, 0}
+	// doc.clientID = 7; map = doc.getMap('m'); map.set('k', 1); map.set('k', 2)
+	// The first value is garbage collected to deleted content, and the
+	// second only names its key through its origin
+	mapOverwritten = []byte{1, 2, 7, 0, 0x21, 1, 1, 'm', 1, 'k', 1,
+		0xa8, 7, 0, 1, 125, 2, 1, 7, 1, 0, 1}
+)
+
+func TestContentKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		update []byte
+		want   StateVector
+	}{
+		{"any values", arrayAny, StateVector{3: 8}},
+		{"XML element", xmlElement, StateVector{4: 1}},
+		{"formatting", formattedText, StateVector{5: 3}},
+		{"binary", arrayBinary, StateVector{6: 1}},
+		{"deleted content", mapOverwritten, StateVector{7: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sv, err := StateVectorFromUpdate(tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sv, tt.want) {
+				t.Fatalf("got state vector %v, want %v", sv, tt.want)
+			}
+			// Content is written back exactly as read
+			merged, err := MergeUpdates(tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(merged, tt.update) {
+				t.Fatalf("merged: got %v, want %v", merged, tt.update)
+			}
+		})
+	}
+}
+
+func TestDiffSplitsAnyValues(t *testing.T) {
+	got, err := DiffUpdate(arrayAny, StateVector{3: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, arrayAnyFrom5) {
+		t.Fatalf("got %v, want %v", got, arrayAnyFrom5)
+	}
+	merged, err := MergeUpdates(arrayAnyFrom5, arrayAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(merged, arrayAny) {
+		t.Fatalf("merged: got %v, want %v", merged, arrayAny)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var e Encoder
+	e.Uvarint(0)
+	e.Uvarint(300)
+	e.Byte(7)
+	e.WriteBytes([]byte{1, 2})
+	e.String("héllo")
+	if want := []byte{0, 0xac, 0x02, 7, 2, 1, 2, 6, 'h', 0xc3, 0xa9, 'l', 'l', 'o'}; !bytes.Equal(e.Bytes(), want) {
+		t.Fatalf("encoded %v, want %v", e.Bytes(), want)
+	}
+
+	d := NewDecoder(e.Bytes())
+	if v := d.Uvarint(); v != 0 {
+		t.Errorf("got %d, want 0", v)
+	}
+	if v := d.Uvarint(); v != 300 {
+		t.Errorf("got %d, want 300", v)
+	}
+	if b := d.Byte(); b != 7 {
+		t.Errorf("got %d, want 7", b)
+	}
+	if b := d.Bytes(); !bytes.Equal(b, []byte{1, 2}) {
+		t.Errorf("got %v, want [1 2]", b)
+	}
+	if s := d.String(); s != "héllo" {
+		t.Errorf("got %q, want héllo", s)
+	}
+	if !d.Done() || d.Err() != nil {
+		t.Fatalf("got done %v and error %v at the end, want done without error", d.Done(), d.Err())
+	}
+
+	// Reading past the end fails, and the failure sticks
+	if b := d.Byte(); b != 0 || !errors.Is(d.Err(), ErrMalformed) {
+		t.Fatalf("reading past the end: got %d, %v", b, d.Err())
+	}
+	if v := d.Uvarint(); v != 0 {
+		t.Fatalf("reading after a failure: got %d, want 0", v)
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	tests := map[string]struct {
+		input []byte
+		read  func(d *Decoder)
+	}{
+		"empty varint":        {nil, func(d *Decoder) { d.Uvarint() }},
+		"unfinished varint":   {[]byte{0x80, 0x80}, func(d *Decoder) { d.Uvarint() }},
+		"overflowing varint":  {bytes.Repeat([]byte{0xff}, 11), func(d *Decoder) { d.Uvarint() }},
+		"bytes past the end":  {[]byte{3, 1, 2}, func(d *Decoder) { d.Bytes() }},
+		"huge byte length":    {[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, func(d *Decoder) { d.Bytes() }},
+		"string past the end": {[]byte{5, 'a'}, func(d *Decoder) { _ = d.String() }},
+		"unknown any value":   {[]byte{0x50}, func(d *Decoder) { d.skipAny() }},
+		"float past the end":  {[]byte{123, 0, 0}, func(d *Decoder) { d.skipAny() }},
+		"object past the end": {[]byte{118, 2, 1, 'k', 120}, func(d *Decoder) { d.skipAny() }},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := NewDecoder(tt.input)
+			tt.read(d)
+			if !errors.Is(d.Err(), ErrMalformed) || !d.Done() {
+				t.Fatalf("got error %v, want ErrMalformed", d.Err())
+			}
+		})
+	}
+}
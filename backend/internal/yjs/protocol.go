@@ -0,0 +1,63 @@
+package yjs
+
+// Message types of the y-websocket protocol.
+const (
+	MessageSync           = 0
+	MessageAwareness      = 1
+	MessageAuth           = 2
+	MessageQueryAwareness = 3
+)
+
+// Sync message steps: a peer sends its state vector (step 1), the other
+// answers with the updates it is missing (step 2), and from then on both
+// send new updates as they happen.
+const (
+	SyncStep1  = 0
+	SyncStep2  = 1
+	SyncUpdate = 2
+)
+
+// SyncMessage encodes a sync message carrying a state vector or update.
+func SyncMessage(step byte, payload []byte) []byte {
+	var e Encoder
+	e.Uvarint(MessageSync)
+	e.Uvarint(uint64(step))
+	e.WriteBytes(payload)
+	return e.Bytes()
+}
+
+// AwarenessState is the presence of one client (cursor, user name, ...)
+// as the JSON the client sent. A "null" state means the client left.
+type AwarenessState struct {
+	Client uint64
+	Clock  uint64
+	State  string
+}
+
+// Removed reports whether the state announces that the client left.
+func (s AwarenessState) Removed() bool { return s.State == "null" }
+
+// DecodeAwareness reads the payload of an awareness message.
+func DecodeAwareness(b []byte) ([]AwarenessState, error) {
+	d := NewDecoder(b)
+	var states []AwarenessState
+	for n := d.Uvarint(); n > 0 && d.err == nil; n-- {
+		states = append(states, AwarenessState{Client: d.Uvarint(), Clock: d.Uvarint(), State: d.String()})
+	}
+	return states, d.err
+}
+
+// AwarenessMessage encodes an awareness message with the given states.
+func AwarenessMessage(states []AwarenessState) []byte {
+	var payload Encoder
+	payload.Uvarint(uint64(len(states)))
+	for _, s := range states {
+		payload.Uvarint(s.Client)
+		payload.Uvarint(s.Clock)
+		payload.String(s.State)
+	}
+	var e Encoder
+	e.Uvarint(MessageAwareness)
+	e.WriteBytes(payload.Bytes())
+	return e.Bytes()
+}
@@ -0,0 +1,60 @@
+package yjs
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSyncMessage(t *testing.T) {
+	// y-protocols' writeSyncStep1 for a doc at {1: 3, 2: 1}, behind the
+	// y-websocket sync message type
+	want := []byte{MessageSync, SyncStep1, 5, 2, 2, 1, 1, 3}
+	if got := SyncMessage(SyncStep1, StateVector{1: 3, 2: 1}.Encode()); !bytes.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	msg := SyncMessage(SyncUpdate, textAB)
+	d := NewDecoder(msg)
+	if typ, step, payload := d.Uvarint(), d.Uvarint(), d.Bytes(); typ != MessageSync || step != SyncUpdate || !bytes.Equal(payload, textAB) {
+		t.Fatalf("decoded type %d, step %d, payload %v", typ, step, payload)
+	}
+	if !d.Done() || d.Err() != nil {
+		t.Fatalf("got done %v and error %v, want the whole message read", d.Done(), d.Err())
+	}
+}
+
+func TestAwareness(t *testing.T) {
+	// awarenessProtocol.encodeAwarenessUpdate(awareness, [1]) for client 1
+	// with the state {user: 'alice'}, behind the y-websocket message type
+	user := `{"user":"alice"}`
+	encoded := append([]byte{MessageAwareness, 20, 1, 1, 0, 16}, user...)
+	states := []AwarenessState{{Client: 1, Clock: 0, State: user}}
+
+	if got := AwarenessMessage(states); !bytes.Equal(got, encoded) {
+		t.Fatalf("got %v, want %v", got, encoded)
+	}
+	d := NewDecoder(encoded)
+	d.Uvarint()
+	got, err := DecodeAwareness(d.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, states) {
+		t.Fatalf("got %+v, want %+v", got, states)
+	}
+	if got[0].Removed() {
+		t.Fatal("a state with a user is not a removal")
+	}
+	if !(AwarenessState{Client: 1, Clock: 1, State: "null"}).Removed() {
+		t.Fatal("a null state is a removal")
+	}
+
+	payload := encoded[2:]
+	for n := 0; n < len(payload); n++ {
+		if _, err := DecodeAwareness(payload[:n]); !errors.Is(err, ErrMalformed) {
+			t.Errorf("truncated to %d bytes: got %v, want ErrMalformed", n, err)
+		}
+	}
+}
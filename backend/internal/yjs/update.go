@@ -0,0 +1,466 @@
+package yjs
+
+import (
+	"sort"
+	"unicode/utf16"
+)
+
+// Content and struct references, the low five bits of a struct's info byte.
+const (
+	refGC      = 0
+	refDeleted = 1
+	refJSON    = 2
+	refBinary  = 3
+	refString  = 4
+	refEmbed   = 5
+	refFormat  = 6
+	refType    = 7
+	refAny     = 8
+	refDoc     = 9
+	refSkip    = 10
+)
+
+// Info byte flags of an item.
+const (
+	flagOrigin      = 0x80
+	flagRightOrigin = 0x40
+	flagParentSub   = 0x20
+)
+
+// Yjs type references of ContentType; XML elements and hooks carry a name.
+const (
+	typeXMLElement = 3
+	typeXMLHook    = 5
+)
+
+// ID identifies the first unit of a struct: the client that created it
+// and that client's logical clock.
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+// block is a struct of an update: an item, a garbage-collected range or a
+// skipped range. Item content is kept encoded, split into units where it
+// can be sliced, so blocks can be written back without understanding the
+// content.
+type block struct {
+	ref    byte
+	id     ID
+	length uint64
+
+	// Items only
+	parentSub   bool
+	origin      *ID
+	rightOrigin *ID
+	parent      []byte   // encoded parent and parent key, set when there are no origins
+	str         []uint16 // refString, in UTF-16 units like JavaScript strings
+	units       [][]byte // refJSON and refAny, one encoded value per unit
+	content     []byte   // encoded content of the other (single-unit) kinds
+}
+
+func (b *block) end() uint64 { return b.id.Clock + b.length }
+
+// sliceFrom returns the block without its first offset units. The rest of
+// an item is attached to the unit before it, as Yjs does when splitting.
+func (b *block) sliceFrom(offset uint64) *block {
+	if offset == 0 {
+		return b
+	}
+	s := *b
+	s.id.Clock += offset
+	s.length -= offset
+	if b.ref == refGC || b.ref == refSkip {
+		return &s
+	}
+	s.origin = &ID{Client: b.id.Client, Clock: b.id.Clock + offset - 1}
+	s.parent = nil
+	switch b.ref {
+	case refString:
+		s.str = b.str[offset:]
+	case refJSON, refAny:
+		s.units = b.units[offset:]
+	}
+	return &s
+}
+
+type deleteRange struct {
+	clock, length uint64
+}
+
+// update is a decoded document update.
+type update struct {
+	blocks  map[uint64][]*block
+	deletes map[uint64][]deleteRange
+}
+
+func decodeUpdate(b []byte) (*update, error) {
+	d := NewDecoder(b)
+	u := &update{blocks: map[uint64][]*block{}, deletes: map[uint64][]deleteRange{}}
+
+	for clients := d.Uvarint(); clients > 0 && d.err == nil; clients-- {
+		n := d.Uvarint()
+		client := d.Uvarint()
+		clock := d.Uvarint()
+		for ; n > 0 && d.err == nil; n-- {
+			blk := decodeBlock(d, ID{Client: client, Clock: clock})
+			if d.err != nil {
+				break
+			}
+			if blk.length == 0 {
+				d.fail()
+				break
+			}
+			u.blocks[client] = append(u.blocks[client], blk)
+			clock += blk.length
+		}
+	}
+	for clients := d.Uvarint(); clients > 0 && d.err == nil; clients-- {
+		client := d.Uvarint()
+		for n := d.Uvarint(); n > 0 && d.err == nil; n-- {
+			r := deleteRange{clock: d.Uvarint(), length: d.Uvarint()}
+			u.deletes[client] = append(u.deletes[client], r)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return u, nil
+}
+
+func decodeBlock(d *Decoder, id ID) *block {
+	info := d.Byte()
+	blk := &block{ref: info & 0x1f, id: id}
+	switch blk.ref {
+	case refGC, refSkip:
+		blk.length = d.Uvarint()
+		return blk
+	}
+
+	blk.parentSub = info&flagParentSub != 0
+	if info&flagOrigin != 0 {
+		blk.origin = &ID{Client: d.Uvarint(), Clock: d.Uvarint()}
+	}
+	if info&flagRightOrigin != 0 {
+		blk.rightOrigin = &ID{Client: d.Uvarint(), Clock: d.Uvarint()}
+	}
+	if blk.origin == nil && blk.rightOrigin == nil {
+		start := d.pos
+		if d.Uvarint() == 1 {
+			d.Bytes() // name of a root type
+		} else {
+			d.Uvarint() // ID of the parent item
+			d.Uvarint()
+		}
+		if blk.parentSub {
+			d.Bytes()
+		}
+		blk.parent = d.buf[start:d.pos]
+	}
+
+	start := d.pos
+	blk.length = 1
+	switch blk.ref {
+	case refDeleted:
+		blk.length = d.Uvarint()
+	case refJSON:
+		blk.length = d.Uvarint()
+		for i := uint64(0); i < blk.length && d.err == nil; i++ {
+			at := d.pos
+			d.Bytes()
+			blk.units = append(blk.units, d.buf[at:d.pos])
+		}
+	case refAny:
+		blk.length = d.Uvarint()
+		for i := uint64(0); i < blk.length && d.err == nil; i++ {
+			at := d.pos
+			d.skipAny()
+			blk.units = append(blk.units, d.buf[at:d.pos])
+		}
+	case refString:
+		blk.str = utf16.Encode([]rune(d.String()))
+		blk.length = uint64(len(blk.str))
+	case refBinary, refEmbed:
+		d.Bytes()
+	case refFormat:
+		d.Bytes()
+		d.Bytes()
+	case refType:
+		if t := d.Uvarint(); t == typeXMLElement || t == typeXMLHook {
+			d.Bytes()
+		}
+	case refDoc:
+		d.Bytes()
+		d.skipAny()
+	default:
+		d.fail()
+	}
+	blk.content = d.buf[start:d.pos]
+	return blk
+}
+
+func (b *block) encode(e *Encoder) {
+	switch b.ref {
+	case refGC, refSkip:
+		e.Byte(b.ref)
+		e.Uvarint(b.length)
+		return
+	}
+
+	info := b.ref
+	if b.origin != nil {
+		info |= flagOrigin
+	}
+	if b.rightOrigin != nil {
+		info |= flagRightOrigin
+	}
+	if b.parentSub {
+		info |= flagParentSub
+	}
+	e.Byte(info)
+	if b.origin != nil {
+		e.Uvarint(b.origin.Client)
+		e.Uvarint(b.origin.Clock)
+	}
+	if b.rightOrigin != nil {
+		e.Uvarint(b.rightOrigin.Client)
+		e.Uvarint(b.rightOrigin.Clock)
+	}
+	if b.origin == nil && b.rightOrigin == nil {
+		e.raw(b.parent)
+	}
+
+	switch b.ref {
+	case refDeleted:
+		e.Uvarint(b.length)
+	case refJSON, refAny:
+		e.Uvarint(uint64(len(b.units)))
+		for _, unit := range b.units {
+			e.raw(unit)
+		}
+	case refString:
+		e.String(string(utf16.Decode(b.str)))
+	default:
+		e.raw(b.content)
+	}
+}
+
+func (u *update) encode() []byte {
+	var e Encoder
+	clients := make([]uint64, 0, len(u.blocks))
+	for client, blocks := range u.blocks {
+		if len(blocks) > 0 {
+			clients = append(clients, client)
+		}
+	}
+	// Yjs writes higher client IDs first
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	e.Uvarint(uint64(len(clients)))
+	for _, client := range clients {
+		blocks := u.blocks[client]
+		e.Uvarint(uint64(len(blocks)))
+		e.Uvarint(client)
+		e.Uvarint(blocks[0].id.Clock)
+		for _, b := range blocks {
+			b.encode(&e)
+		}
+	}
+
+	clients = clients[:0]
+	for client, ranges := range u.deletes {
+		if len(ranges) > 0 {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	e.Uvarint(uint64(len(clients)))
+	for _, client := range clients {
+		e.Uvarint(client)
+		e.Uvarint(uint64(len(u.deletes[client])))
+		for _, r := range u.deletes[client] {
+			e.Uvarint(r.clock)
+			e.Uvarint(r.length)
+		}
+	}
+	return e.Bytes()
+}
+
+// MergeUpdates combines updates into one update containing every struct
+// and deletion exactly once. Updates may overlap and arrive in any order.
+// Gaps, from updates that depend on structs not yet seen, are kept as
+// skipped ranges so clients still wait for the missing structs.
+func MergeUpdates(updates ...[]byte) ([]byte, error) {
+	merged := &update{blocks: map[uint64][]*block{}, deletes: map[uint64][]deleteRange{}}
+	for _, b := range updates {
+		u, err := decodeUpdate(b)
+		if err != nil {
+			return nil, err
+		}
+		for client, blocks := range u.blocks {
+			for _, blk := range blocks {
+				if blk.ref != refSkip {
+					merged.blocks[client] = append(merged.blocks[client], blk)
+				}
+			}
+		}
+		for client, ranges := range u.deletes {
+			merged.deletes[client] = append(merged.deletes[client], ranges...)
+		}
+	}
+
+	for client, blocks := range merged.blocks {
+		sort.SliceStable(blocks, func(i, j int) bool {
+			if blocks[i].id.Clock != blocks[j].id.Clock {
+				return blocks[i].id.Clock < blocks[j].id.Clock
+			}
+			return blocks[i].length > blocks[j].length
+		})
+		out := blocks[:0:0]
+		for _, blk := range blocks {
+			if len(out) > 0 {
+				end := out[len(out)-1].end()
+				switch {
+				case blk.end() <= end:
+					continue
+				case blk.id.Clock < end:
+					blk = blk.sliceFrom(end - blk.id.Clock)
+				case blk.id.Clock > end:
+					out = append(out, &block{ref: refSkip, id: ID{Client: client, Clock: end}, length: blk.id.Clock - end})
+				}
+			}
+			out = append(out, blk)
+		}
+		merged.blocks[client] = out
+	}
+	for client, ranges := range merged.deletes {
+		merged.deletes[client] = mergeRanges(ranges)
+	}
+	return merged.encode(), nil
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap or touch.
+func mergeRanges(ranges []deleteRange) []deleteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].clock < ranges[j].clock })
+	out := ranges[:0:0]
+	for _, r := range ranges {
+		if n := len(out); n > 0 && r.clock <= out[n-1].clock+out[n-1].length {
+			if end := r.clock + r.length; end > out[n-1].clock+out[n-1].length {
+				out[n-1].length = end - out[n-1].clock
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// StateVector is the next expected clock of each client.
+type StateVector map[uint64]uint64
+
+// DecodeStateVector reads an encoded state vector.
+func DecodeStateVector(b []byte) (StateVector, error) {
+	d := NewDecoder(b)
+	sv := StateVector{}
+	for n := d.Uvarint(); n > 0 && d.err == nil; n-- {
+		client := d.Uvarint()
+		sv[client] = d.Uvarint()
+	}
+	return sv, d.err
+}
+
+// Encode writes the state vector.
+func (sv StateVector) Encode() []byte {
+	var e Encoder
+	clients := make([]uint64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	e.Uvarint(uint64(len(clients)))
+	for _, client := range clients {
+		e.Uvarint(client)
+		e.Uvarint(sv[client])
+	}
+	return e.Bytes()
+}
+
+// StateVectorFromUpdate returns the state a document would have after
+// applying the update: for each client, the clock up to which its structs
+// are complete from the start.
+func StateVectorFromUpdate(b []byte) (StateVector, error) {
+	u, err := decodeUpdate(b)
+	if err != nil {
+		return nil, err
+	}
+	sv := StateVector{}
+	for client, blocks := range u.blocks {
+		if len(blocks) == 0 || blocks[0].id.Clock != 0 {
+			continue
+		}
+		var clock uint64
+		for _, blk := range blocks {
+			if blk.ref == refSkip || blk.id.Clock != clock {
+				break
+			}
+			clock = blk.end()
+		}
+		sv[client] = clock
+	}
+	return sv, nil
+}
+
+// DiffUpdate returns the part of an update a peer with the given state
+// vector is missing. Deletions are always included.
+func DiffUpdate(b []byte, sv StateVector) ([]byte, error) {
+	u, err := decodeUpdate(b)
+	if err != nil {
+		return nil, err
+	}
+	for client, blocks := range u.blocks {
+		known := sv[client]
+		out := blocks[:0:0]
+		for _, blk := range blocks {
+			if blk.end() <= known {
+				continue
+			}
+			if blk.id.Clock < known {
+				blk = blk.sliceFrom(known - blk.id.Clock)
+			}
+			if len(out) == 0 && blk.ref == refSkip {
+				continue
+			}
+			out = append(out, blk)
+		}
+		u.blocks[client] = out
+	}
+	return u.encode(), nil
+}
+
+// IsEmpty reports whether an update contains neither structs nor
+// deletions, like the ones clients send when they have nothing new.
+func IsEmpty(b []byte) bool {
+	u, err := decodeUpdate(b)
+	if err != nil {
+		return false
+	}
+	for _, blocks := range u.blocks {
+		if len(blocks) > 0 {
+			return false
+		}
+	}
+	for _, ranges := range u.deletes {
+		if len(ranges) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that b is a well-formed update.
+func Validate(b []byte) error {
+	_, err := decodeUpdate(b)
+	return err
+}
+
+// EmptyUpdate is an update without changes.
+var EmptyUpdate = []byte{0, 0}
@@ -0,0 +1,274 @@
+package yjs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Updates as Yjs 13 encodes them (Y.encodeStateAsUpdate, the doc's "update"
+// event and Y.diffUpdate). Each comment shows the JavaScript producing it.
+var (
+	// doc.clientID = 1; text = doc.getText('t'); text.insert(0, 'ab')
+	textAB = []byte{1, 1, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 0}
+	// then text.insert(2, 'c'), as sent by the "update" event
+	textC = []byte{1, 1, 1, 2, 0x84, 1, 1, 1, 'c', 0}
+	// then Y.encodeStateAsUpdate(doc); Yjs has merged the two items
+	textABC = []byte{1, 1, 1, 0, 4, 1, 1, 't', 3, 'a', 'b', 'c', 0}
+	// Y.diffUpdate(textABC, Uint8Array.of(1, 1, 1)): "bc" after "a"
+	textBC = []byte{1, 1, 1, 1, 0x84, 1, 0, 2, 'b', 'c', 0}
+	// then text.insert(3, 'd')
+	textD = []byte{1, 1, 1, 3, 0x84, 1, 2, 1, 'd', 0}
+	// then text.delete(0, 1)
+	deleteA = []byte{0, 1, 1, 1, 0, 1}
+	// then text.delete(0, 1) again, removing "b"
+	deleteB = []byte{0, 1, 1, 1, 1, 1}
+	// doc.clientID = 2; doc.getMap('m').set('k', 1)
+	mapK = []byte{1, 1, 2, 0, 0x28, 1, 1, 'm', 1, 'k', 1, 125, 1, 0}
+	// doc.clientID = 1; doc.getText('t').insert(0, '😀'), two UTF-16 units
+	textEmoji = []byte{1, 1, 1, 0, 4, 1, 1, 't', 4, 0xf0, 0x9f, 0x98, 0x80, 0}
+	// Y.mergeUpdates([textAB, textD]): "c" is missing, so a skip holds
+	// its place
+	textABSkipD = []byte{1, 3, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 10, 1, 0x84, 1, 2, 1, 'd', 0}
+	// Y.mergeUpdates([textAB, mapK])
+	textABMapK = []byte{2, 1, 2, 0, 0x28, 1, 1, 'm', 1, 'k', 1, 125, 1, 1, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 0}
+	// Y.mergeUpdates([textAB, deleteA])
+	textABDeleteA = []byte{1, 1, 1, 0, 4, 1, 1, 't', 2, 'a', 'b', 1, 1, 1, 0, 1}
+)
+
+func TestStateVectorFromUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		update []byte
+		want   StateVector
+	}{
+		{"empty", EmptyUpdate, StateVector{}},
+		{"one item", textAB, StateVector{1: 2}},
+		{"merged items", textABC, StateVector{1: 3}},
+		{"missing the start", textC, StateVector{}},
+		{"deletions only", deleteA, StateVector{}},
+		{"map entry", mapK, StateVector{2: 1}},
+		{"two clients", textABMapK, StateVector{1: 2, 2: 1}},
+		{"stops at a skip", textABSkipD, StateVector{1: 2}},
+		{"counts UTF-16 units", textEmoji, StateVector{1: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StateVectorFromUpdate(tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateVectorEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		sv      StateVector
+		encoded []byte
+	}{
+		// Y.encodeStateVector of a new doc
+		{"empty", StateVector{}, []byte{0}},
+		// Yjs writes higher client IDs first
+		{"two clients", StateVector{1: 3, 2: 1}, []byte{2, 2, 1, 1, 3}},
+		{"multi-byte values", StateVector{3069413637: 300}, []byte{1, 0x85, 0x92, 0xce, 0xb7, 0x0b, 0xac, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sv.Encode(); !bytes.Equal(got, tt.encoded) {
+				t.Fatalf("Encode: got %v, want %v", got, tt.encoded)
+			}
+			got, err := DecodeStateVector(tt.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.sv) {
+				t.Fatalf("DecodeStateVector: got %v, want %v", got, tt.sv)
+			}
+		})
+	}
+}
+
+func TestMergeUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates [][]byte
+		want    []byte
+	}{
+		{"nothing", nil, EmptyUpdate},
+		{"one update", [][]byte{textAB}, textAB},
+		{"two clients", [][]byte{textAB, mapK}, textABMapK},
+		{"two clients, reversed", [][]byte{mapK, textAB}, textABMapK},
+		{"contained update", [][]byte{textABC, textAB}, textABC},
+		{"contained update first", [][]byte{textAB, textABC}, textABC},
+		{"duplicate", [][]byte{textAB, textAB}, textAB},
+		{"gap", [][]byte{textAB, textD}, textABSkipD},
+		{"gap, reversed", [][]byte{textD, textAB}, textABSkipD},
+		{"gap filled", [][]byte{textABSkipD, textABC}, []byte{1, 2, 1, 0, 4, 1, 1, 't', 3, 'a', 'b', 'c', 0x84, 1, 2, 1, 'd', 0}},
+		{"deletion", [][]byte{textAB, deleteA}, textABDeleteA},
+		{"duplicate deletion", [][]byte{deleteA, textAB, deleteA}, textABDeleteA},
+		{"adjacent deletions", [][]byte{deleteB, deleteA}, []byte{0, 1, 1, 1, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeUpdates(tt.updates...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		update []byte
+		sv     StateVector
+		want   []byte
+	}{
+		{"empty state", textABC, StateVector{}, textABC},
+		{"other client known", textABC, StateVector{2: 5}, textABC},
+		// Y.encodeStateAsUpdate(doc, Uint8Array.of(1, 1, 2))
+		{"splits an item", textABC, StateVector{1: 2}, textC},
+		{"splits an item early", textABC, StateVector{1: 1}, textBC},
+		{"up to date", textABC, StateVector{1: 3}, EmptyUpdate},
+		{"ahead", textABC, StateVector{1: 10}, EmptyUpdate},
+		{"keeps deletions", textABDeleteA, StateVector{1: 2}, deleteA},
+		{"one of two clients", textABMapK, StateVector{2: 1}, textAB},
+		{"drops a leading skip", textABSkipD, StateVector{1: 2}, textD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffUpdate(tt.update, tt.sv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMergeDiffRoundTrip checks that a peer holding part of a document
+// catches up by merging the diff against its state vector, the exchange
+// of sync steps 1 and 2.
+func TestMergeDiffRoundTrip(t *testing.T) {
+	full, err := MergeUpdates(textAB, textC, mapK, deleteA, textD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullSV, err := StateVectorFromUpdate(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (StateVector{1: 4, 2: 1}); !reflect.DeepEqual(fullSV, want) {
+		t.Fatalf("state vector of the document: got %v, want %v", fullSV, want)
+	}
+
+	for _, known := range [][]byte{EmptyUpdate, textAB, textABC, mapK, textABMapK, textABSkipD, full} {
+		sv, err := StateVectorFromUpdate(known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		missing, err := DiffUpdate(full, sv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		caughtUp, err := MergeUpdates(known, missing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := StateVectorFromUpdate(caughtUp); !reflect.DeepEqual(got, fullSV) {
+			t.Errorf("knowing %v: got state vector %v after merging the diff, want %v", known, got, fullSV)
+		}
+		// Nothing is missing any more, except the deletions
+		rest, err := DiffUpdate(caughtUp, fullSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, deleteA) {
+			t.Errorf("knowing %v: got %v left over, want only the deletion", known, rest)
+		}
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		update []byte
+		want   bool
+	}{
+		{EmptyUpdate, true},
+		{textAB, false},
+		{deleteA, false},
+		{[]byte{1}, false},
+	} {
+		if got := IsEmpty(tt.update); got != tt.want {
+			t.Errorf("IsEmpty(%v): got %v, want %v", tt.update, got, tt.want)
+		}
+	}
+}
+
+func TestMalformedUpdates(t *testing.T) {
+	inputs := map[string][]byte{
+		"no input":              {},
+		"unknown content":       {1, 1, 1, 0, 11, 1, 1, 't', 0},
+		"zero-length struct":    {1, 1, 1, 0, 0, 0, 0},
+		"string past the end":   {1, 1, 1, 0, 4, 1, 1, 't', 100, 'a', 0},
+		"parent past the end":   {1, 1, 1, 0, 4, 1, 200, 't'},
+		"unknown any value":     {1, 1, 2, 0, 0x28, 1, 1, 'm', 1, 'k', 1, 0x50, 0},
+		"overlong varint":       {0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 1, 0},
+		"huge client count":     {0xff, 0xff, 0xff, 0xff, 0x0f},
+		"huge struct count":     {1, 0xff, 0xff, 0xff, 0xff, 0x0f, 1, 0, 4, 1, 1, 't', 1, 'a', 0},
+		"huge deletion count":   {0, 1, 1, 0xff, 0xff, 0xff, 0xff, 0x0f, 0, 1},
+		"awareness, not update": {1, 20, 1, 1, 0, 16},
+	}
+	// Every truncation of a valid update is malformed too
+	for name, update := range map[string][]byte{"textABC": textABC, "textABMapK": textABMapK,
+		"textABSkipD": textABSkipD, "textABDeleteA": textABDeleteA, "textEmoji": textEmoji} {
+		for n := 1; n < len(update); n++ {
+			inputs[fmt.Sprintf("%s truncated to %d bytes", name, n)] = update[:n]
+		}
+	}
+
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			if err := Validate(input); !errors.Is(err, ErrMalformed) {
+				t.Errorf("Validate: got %v, want ErrMalformed", err)
+			}
+			if _, err := MergeUpdates(textAB, input); !errors.Is(err, ErrMalformed) {
+				t.Errorf("MergeUpdates: got %v, want ErrMalformed", err)
+			}
+			if _, err := DiffUpdate(input, StateVector{}); !errors.Is(err, ErrMalformed) {
+				t.Errorf("DiffUpdate: got %v, want ErrMalformed", err)
+			}
+			if _, err := StateVectorFromUpdate(input); !errors.Is(err, ErrMalformed) {
+				t.Errorf("StateVectorFromUpdate: got %v, want ErrMalformed", err)
+			}
+			if IsEmpty(input) {
+				t.Error("IsEmpty: got true for malformed input")
+			}
+		})
+	}
+}
+
+func TestMalformedStateVectors(t *testing.T) {
+	valid := []byte{2, 2, 1, 1, 3}
+	for n := 0; n < len(valid); n++ {
+		if _, err := DecodeStateVector(valid[:n]); !errors.Is(err, ErrMalformed) {
+			t.Errorf("DecodeStateVector(%v): got %v, want ErrMalformed", valid[:n], err)
+		}
+	}
+	if _, err := DecodeStateVector([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("huge client count: got %v, want ErrMalformed", err)
+	}
+}
@@ -0,0 +1,36 @@
+// Queries against the Go API. Keys have the form ['api', path], which is
+// also how the server embeds preloaded data in index.html.
+
+const TOKEN_COOKIE = 'access_token'
+
+export function getToken() {
+  const match = document.cookie.match(new RegExp(`(?:^|; )${TOKEN_COOKIE}=([^;]*)`))
+  return match ? decodeURIComponent(match[1]) : null
+}
+
+// setToken stores the bearer token in a cookie so the server can preload
+// data for the signed-in user when it serves the page.
+export function setToken(token) {
+  const secure = window.location.protocol === 'https:' ? '; Secure' : ''
+  document.cookie = token
+    ? `${TOKEN_COOKIE}=${encodeURIComponent(token)}; Path=/; SameSite=Strict${secure}`
+    : `${TOKEN_COOKIE}=; Path=/; Max-Age=0`
+}
+
+export async function apiFetch(path) {
+  const token = getToken()
+  const response = await fetch(path, {
+    headers: token ? { Authorization: `Bearer ${token}` } : {},
+  })
+  const body = await response.json()
+  if (!response.ok) {
+    throw new Error(body.message || `Request failed with status ${response.status}`)
+  }
+  return body
+}
+
+// apiQuery returns query options for a GET request, e.g.
+// useQuery(apiQuery('/api/me/preferences')).
+export function apiQuery(path) {
+  return { queryKey: ['api', path], queryFn: () => apiFetch(path) }
+}
@@ -1,10 +1,28 @@
 import { StrictMode } from 'react'
 import { createRoot } from 'react-dom/client'
+import { QueryClient, QueryClientProvider, hydrate } from '@tanstack/react-query'
 import './index.css'
 import App from './App.jsx'
 
+const queryClient = new QueryClient({
+  defaultOptions: { queries: { staleTime: 30_000 } },
+})
+
+// When the Go server serves the app it embeds the data of the current
+// route, so the first render does not wait for the API.
+const preloaded = document.getElementById('__REACT_QUERY_STATE__')
+if (preloaded) {
+  try {
+    hydrate(queryClient, JSON.parse(preloaded.textContent))
+  } catch (error) {
+    console.warn('Ignoring invalid preloaded state', error)
+  }
+}
+
 createRoot(document.getElementById('root')).render(
   <StrictMode>
-    <App />
+    <QueryClientProvider client={queryClient}>
+      <App />
+    </QueryClientProvider>
   </StrictMode>,
-) 
\ No newline at end of file
+)